@@ -0,0 +1,251 @@
+package sonic
+
+import (
+	"net"
+	"time"
+)
+
+// ConnHandler handles a connection accepted by an Acceptor. The handler owns the connection: it must close it once
+// done with it.
+type ConnHandler func(conn Conn)
+
+// AcceptMiddleware wraps the handler of an Acceptor. For each connection, a middleware can act on the connection and
+// hand it to next, hand it to next later, for example once an asynchronous handshake completes, route it elsewhere, or
+// close it and not call next at all.
+//
+// Middlewares are invoked on the IO goroutine, so they must not block. A middleware is built once per Acceptor, so it
+// can keep state across connections, such as a rate limit.
+type AcceptMiddleware func(next ConnHandler) ConnHandler
+
+// Chain composes middlewares into one. The first middleware is the outermost: with Chain(a, b), connections are
+// handed to a, then to b, then to the handler.
+func Chain(middlewares ...AcceptMiddleware) AcceptMiddleware {
+	return func(next ConnHandler) ConnHandler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+// Acceptor accepts connections from a Listener and hands each of them to a handler through a chain of middlewares,
+// such that concerns like rate limiting, TLS detection or logging can be stacked per listener instead of being wired
+// by hand in every server.
+type Acceptor struct {
+	ln      Listener
+	handler ConnHandler
+	onError func(error)
+
+	accepting bool
+	stopped   bool
+}
+
+// NewAcceptor creates an Acceptor handing the connections accepted from ln, which must be nonblocking, to handler
+// through middlewares, the first of which is the outermost. onError is invoked when accepting fails, in which case
+// the Acceptor stops accepting until Start is called again.
+func NewAcceptor(
+	ln Listener,
+	handler ConnHandler,
+	onError func(err error),
+	middlewares ...AcceptMiddleware,
+) *Acceptor {
+	return &Acceptor{
+		ln:      ln,
+		handler: Chain(middlewares...)(handler),
+		onError: onError,
+	}
+}
+
+// Start starts accepting connections.
+func (a *Acceptor) Start() {
+	a.stopped = false
+	if !a.accepting {
+		a.accepting = true
+		a.ln.AsyncAccept(a.onAccept)
+	}
+}
+
+// Stop stops accepting connections once the pending accept completes. Connections already handed to the middlewares
+// are not affected.
+func (a *Acceptor) Stop() {
+	a.stopped = true
+}
+
+func (a *Acceptor) onAccept(err error, conn Conn) {
+	if err != nil {
+		a.accepting = false
+		if a.onError != nil {
+			a.onError(err)
+		}
+		return
+	}
+	a.handler(conn)
+	if a.stopped {
+		a.accepting = false
+	} else {
+		a.ln.AsyncAccept(a.onAccept)
+	}
+}
+
+// SniffTLS routes the connections whose first bytes are a TLS handshake to onTLS, and the others down the chain. The
+// first bytes are peeked, so they are still read by whoever gets the connection. A connection closed before sending
+// anything, or which sends nothing within DefaultSniffTimeout, is closed and routed nowhere. See TLSSniffer.
+func SniffTLS(ioc *IO, onTLS ConnHandler) AcceptMiddleware {
+	return func(next ConnHandler) ConnHandler {
+		return func(conn Conn) {
+			sniffTLS(ioc, conn, DefaultSniffTimeout, onTLS, next)
+		}
+	}
+}
+
+// RateLimitConns closes the connections which come in at more than rate per second, allowing bursts of up to burst
+// connections, and hands the others down the chain. Unlike the accept rate limit of a Listener, which leaves the
+// connections in the kernel's accept queue, the excess connections are shed, so their clients find out right away.
+func RateLimitConns(rate float64, burst int) AcceptMiddleware {
+	if burst < 1 {
+		burst = 1
+	}
+	return func(next ConnHandler) ConnHandler {
+		throttle := &rateThrottle{
+			rate:   rate,
+			burst:  float64(burst),
+			tokens: float64(burst),
+			last:   time.Now(),
+		}
+		return func(conn Conn) {
+			if throttle.wait(time.Now()) > 0 {
+				_ = conn.Close()
+				return
+			}
+			if throttle.rate > 0 {
+				throttle.tokens--
+			}
+			next(conn)
+		}
+	}
+}
+
+// ConnLimits bounds the connections open at the same time. A bound of 0 or less is disabled.
+type ConnLimits struct {
+	// MaxConns bounds all connections.
+	MaxConns int
+
+	// MaxConnsPerIP bounds the connections from the same remote IP address. Connections without an IP address, such as
+	// Unix domain connections, are only bounded by MaxConns.
+	MaxConnsPerIP int
+}
+
+// ConnLimiterStats are the counters of a ConnLimiter.
+type ConnLimiterStats struct {
+	// Open is the number of connections handed down the chain and not yet closed.
+	Open int
+
+	// Rejected counts the connections closed because the global bound was reached, and RejectedPerIP those closed
+	// because the bound of their IP address was reached.
+	Rejected      uint64
+	RejectedPerIP uint64
+}
+
+// ConnLimiter enforces ConnLimits on the connections of an Acceptor, such that a public endpoint cannot be exhausted
+// by a single client or a flood of connections. Connections over a bound are closed as soon as they are accepted.
+//
+// A connection counts towards the bounds until the Conn handed down the chain is closed, or handed over to another IO
+// by IOPool.Adopt, in which case the bounds only apply to the connections not handed over yet. A ConnLimiter must only
+// be used from the goroutine running the IO.
+type ConnLimiter struct {
+	limits ConnLimits
+	open   int
+	perIP  map[string]int
+	stats  ConnLimiterStats
+}
+
+// NewConnLimiter creates a ConnLimiter enforcing the given limits. See Middleware.
+func NewConnLimiter(limits ConnLimits) *ConnLimiter {
+	return &ConnLimiter{
+		limits: limits,
+		perIP:  make(map[string]int),
+	}
+}
+
+// Middleware returns the AcceptMiddleware enforcing the limits. Connections within the limits are handed down the
+// chain.
+func (l *ConnLimiter) Middleware() AcceptMiddleware {
+	return func(next ConnHandler) ConnHandler {
+		return func(conn Conn) {
+			if conn, ok := l.acquire(conn); ok {
+				next(conn)
+			}
+		}
+	}
+}
+
+// Stats returns the counters of the limiter.
+func (l *ConnLimiter) Stats() ConnLimiterStats {
+	stats := l.stats
+	stats.Open = l.open
+	return stats
+}
+
+// OpenFrom returns the number of open connections from the given IP address.
+func (l *ConnLimiter) OpenFrom(ip net.IP) int {
+	return l.perIP[ip.String()]
+}
+
+func (l *ConnLimiter) acquire(conn Conn) (Conn, bool) {
+	ip := remoteIP(conn)
+
+	switch {
+	case l.limits.MaxConns > 0 && l.open >= l.limits.MaxConns:
+		l.stats.Rejected++
+	case l.limits.MaxConnsPerIP > 0 && ip != "" && l.perIP[ip] >= l.limits.MaxConnsPerIP:
+		l.stats.RejectedPerIP++
+	default:
+		l.open++
+		if ip != "" {
+			l.perIP[ip]++
+		}
+		return &limitedConn{Conn: conn, release: func() { l.release(ip) }}, true
+	}
+
+	_ = conn.Close()
+	return nil, false
+}
+
+func (l *ConnLimiter) release(ip string) {
+	l.open--
+	if ip == "" {
+		return
+	}
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+func remoteIP(conn Conn) string {
+	switch addr := conn.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		return addr.IP.String()
+	case *net.UDPAddr:
+		return addr.IP.String()
+	default:
+		return ""
+	}
+}
+
+// limitedConn is a connection counted by a ConnLimiter until it is closed.
+type limitedConn struct {
+	Conn
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	c.releaseConn()
+	return c.Conn.Close()
+}
+
+func (c *limitedConn) releaseConn() {
+	if c.release != nil {
+		c.release()
+		c.release = nil
+	}
+}
@@ -0,0 +1,179 @@
+package sonic
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic/sonicopts"
+)
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	tag := func(name string) AcceptMiddleware {
+		return func(next ConnHandler) ConnHandler {
+			return func(conn Conn) {
+				order = append(order, name)
+				next(conn)
+			}
+		}
+	}
+
+	handler := Chain(tag("a"), tag("b"), tag("c"))(func(Conn) { order = append(order, "handler") })
+	handler(nil)
+
+	if expected := []string{"a", "b", "c", "handler"}; !reflect.DeepEqual(order, expected) {
+		t.Fatalf("expected %v got=%v", expected, order)
+	}
+}
+
+func TestAcceptor(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := Listen(ioc, "tcp", "localhost:9991", sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var (
+		seen    int
+		handled []Conn
+	)
+	count := func(next ConnHandler) ConnHandler {
+		return func(conn Conn) {
+			seen++
+			next(conn)
+		}
+	}
+
+	acceptor := NewAcceptor(
+		ln,
+		func(conn Conn) { handled = append(handled, conn) },
+		func(err error) { t.Fatal(err) },
+		count,
+		RateLimitConns(0.001, 2),
+	)
+	acceptor.Start()
+
+	const nClients = 3
+
+	shed := make(chan bool, nClients)
+	for i := 0; i < nClients; i++ {
+		go func() {
+			conn, err := net.Dial("tcp", "localhost:9991")
+			if err != nil {
+				shed <- false
+				return
+			}
+			defer conn.Close()
+
+			// Shed connections are closed by the server, the others stay open.
+			_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			_, err = conn.Read(make([]byte, 1))
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				shed <- false
+			} else {
+				shed <- true
+			}
+		}()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && seen < nClients {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	acceptor.Stop()
+
+	if seen != nClients {
+		t.Fatalf("expected the outer middleware to see %d connections got=%d", nClients, seen)
+	}
+	if len(handled) != 2 {
+		t.Fatalf("expected the rate limit to let 2 connections through got=%d", len(handled))
+	}
+
+	nShed := 0
+	for i := 0; i < nClients; i++ {
+		if <-shed {
+			nShed++
+		}
+	}
+	if nShed != 1 {
+		t.Fatalf("expected 1 connection to be shed got=%d", nShed)
+	}
+	for _, conn := range handled {
+		conn.Close()
+	}
+}
+
+func TestConnLimiter(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := Listen(ioc, "tcp", "127.0.0.1:9993", sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var (
+		seen    int
+		handled []Conn
+	)
+	count := func(next ConnHandler) ConnHandler {
+		return func(conn Conn) {
+			seen++
+			next(conn)
+		}
+	}
+	limiter := NewConnLimiter(ConnLimits{MaxConns: 2, MaxConnsPerIP: 1})
+	NewAcceptor(
+		ln,
+		func(conn Conn) { handled = append(handled, conn) },
+		func(err error) { t.Fatal(err) },
+		count,
+		limiter.Middleware(),
+	).Start()
+
+	// All of 127.0.0.0/8 is loopback, so each client gets its own IP address.
+	dial := func(from string) {
+		dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(from)}}
+		conn, err := dialer.Dial("tcp", "127.0.0.1:9993")
+		if err != nil {
+			t.Skipf("cannot connect from %s: %v", from, err)
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		n := seen + 1
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline) && seen < n; {
+			_ = ioc.RunOneFor(time.Millisecond)
+		}
+	}
+
+	dial("127.0.0.1")
+	dial("127.0.0.1") // over the bound of the IP address
+	dial("127.0.0.2")
+	dial("127.0.0.3") // over the global bound
+
+	stats := limiter.Stats()
+	if len(handled) != 2 || stats.Open != 2 {
+		t.Fatalf("expected 2 connections to be let through got=%d open=%d", len(handled), stats.Open)
+	}
+	if stats.RejectedPerIP != 1 || stats.Rejected != 1 {
+		t.Fatalf("expected 1 rejection per bound got=%+v", stats)
+	}
+
+	handled[0].Close()
+	if open := limiter.OpenFrom(net.ParseIP("127.0.0.1")); open != 0 || limiter.Stats().Open != 1 {
+		t.Fatalf("expected the closed connection to be released got=%d open=%d", open, limiter.Stats().Open)
+	}
+
+	dial("127.0.0.1")
+	if len(handled) != 3 {
+		t.Fatalf("expected the connection to be let through once the bound is freed got=%d", len(handled))
+	}
+	handled[1].Close()
+	handled[2].Close()
+}
@@ -16,6 +16,17 @@ var (
 
 type AsyncAdapterHandler func(error, *AsyncAdapter)
 
+// DefaultMaxBufferedReadsPerRun is the default number of reads an AsyncAdapter serves back-to-back from the buffer of
+// its io.ReadWriter, and a TLSStream from the records already read, before yielding to the IO loop.
+const DefaultMaxBufferedReadsPerRun = 16
+
+// BufferedReader is implemented by readers which hold bytes read from the underlying file descriptor but not yet
+// returned to the caller, such as a bufio.Reader. The file descriptor is not readable anymore when those bytes are
+// available, so an AsyncAdapter reads them without waiting for a read event.
+type BufferedReader interface {
+	Buffered() int
+}
+
 // AsyncAdapter is a wrapper around syscall.Conn which enables
 // clients to schedule async read and write operations on the
 // underlying file descriptor.
@@ -25,6 +36,12 @@ type AsyncAdapter struct {
 	rw     io.ReadWriter
 	rc     syscall.RawConn
 	closed uint32
+
+	// Bounds the reads served from the buffer of rw without yielding, such that a burst of buffered records from one
+	// connection, as with TLS, cannot stall the handlers of other connections.
+	br                 BufferedReader
+	maxBufferedReads   int
+	bufferedReadsInRun int
 }
 
 // NewAsyncAdapter takes in an IO instance and an interface of syscall.Conn and io.ReadWriter
@@ -32,6 +49,9 @@ type AsyncAdapter struct {
 //   - provides the async adapter on successful completion
 //   - provides an error if any occurred when async-adapting the provided object
 //
+// If rw implements BufferedReader, as a bufio.Reader wrapping a tls.Conn does, the bytes it buffers are read without
+// waiting for the file descriptor to become readable, at most SetMaxBufferedReadsPerRun times in a row.
+//
 // See async_adapter_test.go for examples on how to setup an AsyncAdapter.
 func NewAsyncAdapter(
 	ioc *IO,
@@ -48,10 +68,12 @@ func NewAsyncAdapter(
 
 	err = rc.Control(func(fd uintptr) {
 		a := &AsyncAdapter{
-			ioc: ioc,
-			rw:  rw,
-			rc:  rc,
+			ioc:              ioc,
+			rw:               rw,
+			rc:               rc,
+			maxBufferedReads: DefaultMaxBufferedReadsPerRun,
 		}
+		a.br, _ = rw.(BufferedReader)
 		a.slot.Fd = int(fd)
 		err := internal.ApplyOpts(int(fd), opts...)
 		cb(err, a)
@@ -112,6 +134,12 @@ func (a *AsyncAdapter) scheduleRead(b []byte, readBytes int, readAll bool, cb As
 		return
 	}
 
+	if a.br != nil && a.br.Buffered() > 0 {
+		a.readBuffered(b, readBytes, readAll, cb)
+		return
+	}
+	a.bufferedReadsInRun = 0
+
 	handler := a.getReadHandler(b, readBytes, readAll, cb)
 	a.slot.Set(internal.ReadEvent, handler)
 
@@ -122,6 +150,33 @@ func (a *AsyncAdapter) scheduleRead(b []byte, readBytes int, readAll bool, cb As
 	}
 }
 
+// readBuffered reads the bytes buffered by rw immediately, unless too many reads have been served from the buffer in
+// this run of the IO loop, in which case the read is posted such that other handlers get to run first.
+func (a *AsyncAdapter) readBuffered(b []byte, readBytes int, readAll bool, cb AsyncCallback) {
+	if a.bufferedReadsInRun < a.maxBufferedReads {
+		a.bufferedReadsInRun++
+		a.asyncReadNow(b, readBytes, readAll, cb)
+		return
+	}
+
+	a.bufferedReadsInRun = 0
+	err := a.ioc.Post(func() {
+		a.scheduleRead(b, readBytes, readAll, cb)
+	})
+	if err != nil {
+		cb(err, readBytes)
+	}
+}
+
+// SetMaxBufferedReadsPerRun sets the number of reads served back-to-back from the buffer of the underlying
+// io.ReadWriter, if it implements BufferedReader, before the next read is posted to the IO. n must be at least 1.
+func (a *AsyncAdapter) SetMaxBufferedReadsPerRun(n int) {
+	if n < 1 {
+		n = 1
+	}
+	a.maxBufferedReads = n
+}
+
 func (a *AsyncAdapter) getReadHandler(b []byte, readBytes int, readAll bool, cb AsyncCallback) internal.Handler {
 	return func(err error) {
 		a.ioc.Deregister(&a.slot)
@@ -1,7 +1,9 @@
 package sonic
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"net"
 	"strings"
 	"syscall"
@@ -362,3 +364,78 @@ func TestAsyncWriteAll(t *testing.T) {
 		t.Fatalf("AsyncWriteAll completion handler not invoked. Did you call ioc.Run*/ioc.Poll*?")
 	}
 }
+
+func TestAsyncReadBufferedYields(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.Write([]byte("0123456789"))
+		time.Sleep(time.Second)
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	rw := struct {
+		*bufio.Reader
+		io.Writer
+	}{bufio.NewReader(client), client}
+
+	var adapter *AsyncAdapter
+	NewAsyncAdapter(ioc, client.(syscall.Conn), rw, func(err error, a *AsyncAdapter) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		adapter = a
+	})
+	adapter.SetMaxBufferedReadsPerRun(2)
+
+	var (
+		read        []byte
+		readAtOther = -1
+		b           = make([]byte, 1)
+		onRead      AsyncCallback
+	)
+	onRead = func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		read = append(read, b[:n]...)
+		if len(read) == 1 {
+			// Another handler which must not wait for all buffered bytes to be read.
+			ioc.Post(func() { readAtOther = len(read) })
+		}
+		if len(read) < 10 {
+			adapter.AsyncRead(b, onRead)
+		}
+	}
+	adapter.AsyncRead(b, onRead)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && len(read) < 10 {
+		_, _ = ioc.PollOne()
+	}
+
+	if string(read) != "0123456789" {
+		t.Fatalf("expected to read 0123456789 got=%s", read)
+	}
+	if readAtOther < 0 || readAtOther >= 10 {
+		t.Fatalf("posted handler ran after %d reads", readAtOther)
+	}
+}
@@ -0,0 +1,64 @@
+package sonic
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Backend is the readiness notification mechanism the IO is built on.
+type Backend uint8
+
+const (
+	BackendEpoll Backend = iota + 1
+	BackendKqueue
+)
+
+func (b Backend) String() string {
+	switch b {
+	case BackendEpoll:
+		return "epoll"
+	case BackendKqueue:
+		return "kqueue"
+	default:
+		return fmt.Sprintf("backend(%d)", uint8(b))
+	}
+}
+
+// Capability is a set of optional features of the IO's backend, which portable applications can check for at runtime
+// instead of through build tags.
+type Capability uint32
+
+const (
+	// CapZeroCopy is set if bytes can be moved between file descriptors without copying them to user space, see
+	// Splicer.
+	CapZeroCopy Capability = 1 << iota
+
+	// CapBusyPoll is set if sockets can busy-poll the device queues in the kernel.
+	CapBusyPoll
+)
+
+var capabilityNames = []string{"zerocopy", "busy-poll"}
+
+// Has returns true if all capabilities of c are in the set.
+func (s Capability) Has(c Capability) bool {
+	return s&c == c
+}
+
+func (s Capability) String() string {
+	var names []string
+	for i, name := range capabilityNames {
+		if s.Has(1 << i) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// Backend returns the backend of the IO along with the capabilities it supports. Capabilities the library does not
+// implement on the platform are never reported, even if the kernel has them.
+func (ioc *IO) Backend() (Backend, Capability) {
+	return platformBackend, platformCapabilities
+}
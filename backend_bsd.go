@@ -0,0 +1,8 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package sonic
+
+const (
+	platformBackend      = BackendKqueue
+	platformCapabilities = Capability(0)
+)
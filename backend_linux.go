@@ -0,0 +1,8 @@
+//go:build linux
+
+package sonic
+
+const (
+	platformBackend      = BackendEpoll
+	platformCapabilities = CapZeroCopy | CapBusyPoll
+)
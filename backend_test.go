@@ -0,0 +1,30 @@
+package sonic
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestIOBackend(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	backend, caps := ioc.Backend()
+	switch runtime.GOOS {
+	case "linux":
+		if backend != BackendEpoll || !caps.Has(CapZeroCopy) {
+			t.Fatalf("expected epoll with zerocopy got=%s %s", backend, caps)
+		}
+	default:
+		if backend != BackendKqueue {
+			t.Fatalf("expected kqueue got=%s", backend)
+		}
+	}
+
+	if s := (CapZeroCopy | CapBusyPoll).String(); s != "zerocopy|busy-poll" {
+		t.Fatalf("wrong capability names got=%s", s)
+	}
+	if s := Capability(0).String(); s != "none" {
+		t.Fatalf("wrong capability names got=%s", s)
+	}
+}
@@ -0,0 +1,173 @@
+package sonic
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+const (
+	DefaultBackoffFactor = 2.0
+	DefaultBackoffJitter = 0.2
+)
+
+// Backoff schedules retries of an operation with exponentially increasing delays. The delays are randomized with
+// jitter such that many clients retrying at the same time do not hammer the peer in lockstep.
+//
+// All callbacks run on the IO's goroutine, through a Timer.
+//
+// A usual workflow for a client that reconnects is:
+//   - on a failed connect, call AsyncRetry with a callback that connects again.
+//   - on a successful connect, call Reset such that the next failure starts from the initial delay.
+type Backoff struct {
+	timer *Timer
+	rand  *rand.Rand
+
+	initial     time.Duration
+	max         time.Duration
+	factor      float64
+	jitter      float64
+	maxAttempts int
+
+	attempts int
+}
+
+// NewBackoff creates a Backoff whose first delay is initial and whose delays never exceed max.
+//
+// If maxAttempts is 0 or negative, the number of retries is unbounded.
+func NewBackoff(ioc *IO, initial, max time.Duration, maxAttempts int) (*Backoff, error) {
+	if initial <= 0 || max < initial {
+		return nil, sonicerrors.ErrInvalidBackoff
+	}
+
+	timer, err := NewTimer(ioc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Backoff{
+		timer: timer,
+		/* #nosec G404 -- jitter does not need a cryptographically secure source */
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		initial:     initial,
+		max:         max,
+		factor:      DefaultBackoffFactor,
+		jitter:      DefaultBackoffJitter,
+		maxAttempts: maxAttempts,
+	}, nil
+}
+
+// SetFactor sets the multiplier applied to the delay after each attempt. It must be at least 1.
+func (b *Backoff) SetFactor(factor float64) {
+	if factor < 1 {
+		factor = 1
+	}
+	b.factor = factor
+}
+
+// SetJitter sets the fraction of each delay which is randomized. A jitter of 0.2 means each delay is picked uniformly
+// from [0.8*d, 1.2*d] where d is the exponential delay. The jitter is clamped to [0, 1].
+func (b *Backoff) SetJitter(jitter float64) {
+	if jitter < 0 {
+		jitter = 0
+	} else if jitter > 1 {
+		jitter = 1
+	}
+	b.jitter = jitter
+}
+
+// Next returns the delay of the next attempt and records the attempt.
+//
+// sonicerrors.ErrBackoffExhausted is returned if all attempts have been made.
+func (b *Backoff) Next() (time.Duration, error) {
+	if b.maxAttempts > 0 && b.attempts >= b.maxAttempts {
+		return 0, sonicerrors.ErrBackoffExhausted
+	}
+
+	delay := float64(b.initial)
+	for i := 0; i < b.attempts && delay < float64(b.max); i++ {
+		delay *= b.factor
+	}
+	if delay > float64(b.max) {
+		delay = float64(b.max)
+	}
+
+	if b.jitter > 0 {
+		delay += delay * b.jitter * (2*b.rand.Float64() - 1)
+	}
+	if delay > float64(b.max) {
+		delay = float64(b.max)
+	}
+
+	b.attempts++
+
+	return time.Duration(delay), nil
+}
+
+// AsyncRetry schedules cb to run on the IO's goroutine after the next delay.
+//
+// sonicerrors.ErrBackoffExhausted is returned if all attempts have been made, in which case cb is never called. Only
+// one retry can be scheduled at a time: sonicerrors.ErrRetryPending is returned, without counting an attempt, while
+// one is.
+func (b *Backoff) AsyncRetry(cb func()) error {
+	if b.timer.Scheduled() {
+		return sonicerrors.ErrRetryPending
+	}
+
+	delay, err := b.Next()
+	if err != nil {
+		return err
+	}
+	return b.timer.ScheduleOnce(delay, cb)
+}
+
+// Retry is the synchronous counterpart of AsyncRetry: it blocks the calling goroutine, which must be the one running
+// the IO, for the next delay, after which the caller makes its attempt. The IO is run in the meantime.
+//
+// sonicerrors.ErrBackoffExhausted is returned, without blocking, if all attempts have been made,
+// sonicerrors.ErrRetryPending if a retry scheduled by AsyncRetry is pending, and sonicerrors.ErrReentrantWait, without
+// making an attempt, if Retry is called from a handler of the IO.
+func (b *Backoff) Retry() error {
+	if b.timer.Scheduled() {
+		return sonicerrors.ErrRetryPending
+	}
+	if b.timer.ioc.Dispatching() {
+		return sonicerrors.ErrReentrantWait
+	}
+
+	delay, err := b.Next()
+	if err != nil {
+		return err
+	}
+	return b.timer.Wait(delay)
+}
+
+// Attempts returns the number of attempts made since creation or since the last Reset.
+func (b *Backoff) Attempts() int {
+	return b.attempts
+}
+
+// Scheduled returns true if a retry is scheduled.
+func (b *Backoff) Scheduled() bool {
+	return b.timer.Scheduled()
+}
+
+// Reset cancels any scheduled retry and makes the next delay equal to the initial delay.
+func (b *Backoff) Reset() error {
+	b.attempts = 0
+	if b.timer.Scheduled() {
+		return b.timer.Cancel()
+	}
+	return nil
+}
+
+// Cancel cancels a scheduled retry, if any. The attempt count is left untouched.
+func (b *Backoff) Cancel() error {
+	return b.timer.Cancel()
+}
+
+// Close closes the Backoff. A scheduled retry will never run.
+func (b *Backoff) Close() error {
+	return b.timer.Close()
+}
@@ -0,0 +1,145 @@
+package sonic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+func TestBackoffNextIsExponentialAndCapped(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	b, err := NewBackoff(ioc, time.Millisecond, 8*time.Millisecond, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	b.SetJitter(0)
+
+	expected := []time.Duration{
+		time.Millisecond,
+		2 * time.Millisecond,
+		4 * time.Millisecond,
+		8 * time.Millisecond,
+		8 * time.Millisecond,
+	}
+	for i, exp := range expected {
+		delay, err := b.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if delay != exp {
+			t.Fatalf("attempt %d: expected delay=%s got=%s", i, exp, delay)
+		}
+	}
+
+	if err := b.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if delay, _ := b.Next(); delay != time.Millisecond {
+		t.Fatalf("expected delay=1ms after reset got=%s", delay)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	b, err := NewBackoff(ioc, 100*time.Millisecond, time.Second, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	b.SetJitter(0.5)
+
+	for i := 0; i < 100; i++ {
+		delay, err := b.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if delay < 50*time.Millisecond || delay > 150*time.Millisecond {
+			t.Fatalf("delay=%s outside of the jitter range", delay)
+		}
+		_ = b.Reset()
+	}
+}
+
+func TestBackoffMaxAttempts(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	b, err := NewBackoff(ioc, time.Millisecond, 2*time.Millisecond, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	retries := 0
+	var retry func()
+	retry = func() {
+		retries++
+		if err := b.AsyncRetry(retry); err != nil {
+			if err != sonicerrors.ErrBackoffExhausted {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := b.AsyncRetry(retry); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AsyncRetry(retry); err != sonicerrors.ErrRetryPending {
+		t.Fatalf("expected ErrRetryPending got=%v", err)
+	}
+	if err := b.Retry(); err != sonicerrors.ErrRetryPending {
+		t.Fatalf("expected ErrRetryPending got=%v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && b.Scheduled() {
+		_, _ = ioc.PollOne()
+	}
+
+	if retries != 3 {
+		t.Fatalf("expected 3 retries got=%d", retries)
+	}
+	if b.Attempts() != 3 {
+		t.Fatalf("expected 3 attempts got=%d", b.Attempts())
+	}
+}
+
+func TestBackoffRetry(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	b, err := NewBackoff(ioc, time.Millisecond, 2*time.Millisecond, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	b.SetJitter(0)
+
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if err := b.Retry(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 3*time.Millisecond {
+		t.Fatalf("expected Retry to block for the delays, took %s", elapsed)
+	}
+
+	if err := b.Retry(); err != sonicerrors.ErrBackoffExhausted {
+		t.Fatalf("expected ErrBackoffExhausted got=%v", err)
+	}
+}
+
+func TestBackoffInvalid(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	if _, err := NewBackoff(ioc, time.Second, time.Millisecond, 0); err != sonicerrors.ErrInvalidBackoff {
+		t.Fatalf("expected ErrInvalidBackoff got=%v", err)
+	}
+}
@@ -0,0 +1,273 @@
+package sonic
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/csdenboer/sonic/internal"
+)
+
+var _ net.Conn = &BlockingConn{}
+
+// DefaultBlockingConnBufferSize is the size of the buffer a BlockingConn reads the Stream into.
+const DefaultBlockingConnBufferSize = 32 * 1024
+
+// BlockingConn is a net.Conn over a Stream of an IO, such that the Stream can be handed to the libraries which expect a
+// net.Conn, such as database drivers or gRPC, while it still runs on the IO.
+//
+// The blocking Read and Write of the BlockingConn are performed by the IO goroutine: they post the asynchronous read
+// or write to the IO and wait for its completion. They must therefore be called from other goroutines, while the IO
+// runs. Like any net.Conn, a BlockingConn is safe to use from several goroutines at once.
+//
+// The deadlines are supported. A read or write which times out is not cancelled, but completes in the background:
+// the bytes it reads are returned by the next Read, and the next Write waits for it first.
+type BlockingConn struct {
+	ioc    *IO
+	stream Stream
+
+	localAddr, remoteAddr net.Addr
+
+	rmu   sync.Mutex
+	rbuf  []byte
+	in    []byte              // the bytes read and not yet returned
+	rres  chan blockingResult // the result of the read in progress, if any
+	rdead blockingDeadline
+
+	wmu   sync.Mutex
+	wres  chan blockingResult // the result of the write in progress, if any
+	wdead blockingDeadline
+
+	once   sync.Once
+	closed chan struct{}
+}
+
+type blockingResult struct {
+	n   int
+	err error
+}
+
+// NewBlockingConn creates a BlockingConn over stream, which must be used from the goroutine running ioc only through
+// the BlockingConn from now on.
+//
+// The addresses of the BlockingConn are the ones of stream if it has any, as a Conn has, and the ones of the socket of
+// stream otherwise.
+func NewBlockingConn(ioc *IO, stream Stream) *BlockingConn {
+	c := &BlockingConn{
+		ioc:    ioc,
+		stream: stream,
+		rbuf:   make([]byte, DefaultBlockingConnBufferSize),
+		closed: make(chan struct{}),
+	}
+
+	if s, ok := stream.(interface {
+		LocalAddr() net.Addr
+		RemoteAddr() net.Addr
+	}); ok {
+		c.localAddr, c.remoteAddr = s.LocalAddr(), s.RemoteAddr()
+	} else {
+		c.localAddr, _ = internal.SocketAddress(stream.RawFd())
+		c.remoteAddr, _ = internal.PeerAddress(stream.RawFd())
+	}
+
+	return c
+}
+
+// Stream returns the Stream of the BlockingConn.
+func (c *BlockingConn) Stream() Stream {
+	return c.stream
+}
+
+// Read reads the next bytes of the Stream, blocking until some are read.
+func (c *BlockingConn) Read(b []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	if len(c.in) > 0 {
+		n := copy(b, c.in)
+		c.in = c.in[n:]
+		return n, nil
+	}
+	if c.isClosed() {
+		return 0, net.ErrClosed
+	}
+
+	if c.rres == nil {
+		res := make(chan blockingResult, 1)
+		err := c.ioc.Post(func() {
+			c.stream.AsyncRead(c.rbuf, func(err error, n int) {
+				res <- blockingResult{n, err}
+			})
+		})
+		if err != nil {
+			return 0, err
+		}
+		c.rres = res
+	}
+
+	r, err := c.wait(c.rres, &c.rdead)
+	if err != nil {
+		return 0, err
+	}
+	c.rres = nil
+
+	n := copy(b, c.rbuf[:r.n])
+	c.in = c.rbuf[n:r.n]
+	return n, r.err
+}
+
+// Write writes all of b to the Stream, blocking until it is written.
+func (c *BlockingConn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.wres != nil {
+		// The previous write timed out.
+		r, err := c.wait(c.wres, &c.wdead)
+		if err != nil {
+			return 0, err
+		}
+		c.wres = nil
+		if r.err != nil {
+			return 0, r.err
+		}
+	}
+	if c.isClosed() {
+		return 0, net.ErrClosed
+	}
+
+	// The write may complete after a timeout, once the caller reuses b.
+	p := append([]byte(nil), b...)
+	res := make(chan blockingResult, 1)
+	err := c.ioc.Post(func() {
+		c.stream.AsyncWriteAll(p, func(err error, n int) {
+			res <- blockingResult{n, err}
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	c.wres = res
+
+	r, err := c.wait(res, &c.wdead)
+	if err != nil {
+		return 0, err
+	}
+	c.wres = nil
+	return r.n, r.err
+}
+
+// wait waits for the result of an operation until the deadline, or until the BlockingConn is closed.
+func (c *BlockingConn) wait(res chan blockingResult, dead *blockingDeadline) (blockingResult, error) {
+	for {
+		deadline, changed := dead.get()
+
+		var (
+			timer   *time.Timer
+			timeout <-chan time.Time
+		)
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return blockingResult{}, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+
+		select {
+		case r := <-res:
+			if timer != nil {
+				timer.Stop()
+			}
+			return r, nil
+		case <-timeout:
+			return blockingResult{}, os.ErrDeadlineExceeded
+		case <-changed:
+			if timer != nil {
+				timer.Stop()
+			}
+		case <-c.closed:
+			if timer != nil {
+				timer.Stop()
+			}
+			return blockingResult{}, net.ErrClosed
+		}
+	}
+}
+
+func (c *BlockingConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close closes the Stream on the IO goroutine and waits for it. The blocked reads and writes fail with net.ErrClosed.
+func (c *BlockingConn) Close() error {
+	err := net.ErrClosed
+	c.once.Do(func() {
+		close(c.closed)
+
+		res := make(chan error, 1)
+		if err = c.ioc.Post(func() { res <- c.stream.Close() }); err == nil {
+			err = <-res
+		}
+	})
+	return err
+}
+
+func (c *BlockingConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *BlockingConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *BlockingConn) SetDeadline(t time.Time) error {
+	c.rdead.set(t)
+	c.wdead.set(t)
+	return nil
+}
+
+func (c *BlockingConn) SetReadDeadline(t time.Time) error {
+	c.rdead.set(t)
+	return nil
+}
+
+func (c *BlockingConn) SetWriteDeadline(t time.Time) error {
+	c.wdead.set(t)
+	return nil
+}
+
+// blockingDeadline is a deadline of a BlockingConn, which can be changed while a read or write waits for it.
+type blockingDeadline struct {
+	mu      sync.Mutex
+	t       time.Time
+	changed chan struct{} // closed when the deadline changes
+}
+
+func (d *blockingDeadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.t = t
+	if d.changed != nil {
+		close(d.changed)
+	}
+	d.changed = make(chan struct{})
+}
+
+func (d *blockingDeadline) get() (time.Time, chan struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.changed == nil {
+		d.changed = make(chan struct{})
+	}
+	return d.t, d.changed
+}
@@ -0,0 +1,199 @@
+package sonic
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// runIO runs ioc on a goroutine of its own until the returned function is called.
+func runIO(ioc *IO) (stop func()) {
+	var stopped int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for atomic.LoadInt32(&stopped) == 0 {
+			_ = ioc.RunOneFor(time.Millisecond)
+		}
+	}()
+	return func() {
+		atomic.StoreInt32(&stopped, 1)
+		<-done
+	}
+}
+
+func TestBlockingConnEcho(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := NewBlockingConn(ioc, conn)
+	if bc.RemoteAddr().String() != ln.Addr().String() {
+		t.Fatalf("wrong remote address got=%s", bc.RemoteAddr())
+	}
+
+	stop := runIO(ioc)
+	defer stop()
+
+	r := bufio.NewReader(bc)
+	for _, line := range []string{"hello\n", "world\n"} {
+		if _, err := bc.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != line {
+			t.Fatalf("wrong echo got=%q", got)
+		}
+	}
+
+	// Nothing is echoed, so the read times out, and then returns the bytes read in the background.
+	_ = bc.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	b := make([]byte, 16)
+	_, err = bc.Read(b)
+	var nerr net.Error
+	if !errors.As(err, &nerr) || !nerr.Timeout() || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected a timeout got=%v", err)
+	}
+
+	_ = bc.SetReadDeadline(time.Time{})
+	if _, err := bc.Write([]byte("late")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(bc, b[:4]); err != nil || string(b[:4]) != "late" {
+		t.Fatalf("expected the late echo got=%q err=%v", b[:4], err)
+	}
+
+	if err := bc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.Read(b); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed got=%v", err)
+	}
+	if err := bc.Close(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed on the second close got=%v", err)
+	}
+}
+
+func TestBlockingConnCloseUnblocksRead(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			_, _ = io.Copy(io.Discard, conn)
+		}
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := NewBlockingConn(ioc, conn)
+
+	stop := runIO(ioc)
+	defer stop()
+
+	res := make(chan error, 1)
+	go func() {
+		_, err := bc.Read(make([]byte, 16))
+		res <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	_ = bc.Close()
+
+	select {
+	case err := <-res:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("expected net.ErrClosed got=%v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read not unblocked by close")
+	}
+}
+
+func TestBlockingConnHTTPClient(t *testing.T) {
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("hello " + r.URL.Path))
+		}),
+		ReadHeaderTimeout: time.Second,
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := NewBlockingConn(ioc, conn)
+
+	stop := runIO(ioc)
+	defer stop()
+
+	var dialed int32
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(string, string) (net.Conn, error) {
+			if !atomic.CompareAndSwapInt32(&dialed, 0, 1) {
+				return nil, errors.New("a single connection is expected")
+			}
+			return bc, nil
+		},
+	}}
+	defer client.CloseIdleConnections()
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get("http://" + ln.Addr().String() + "/sonic")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "hello /sonic" {
+			t.Fatalf("wrong body got=%q", body)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package sonic
+
+import (
+	"time"
+)
+
+// execBudget bounds the work a stream does back-to-back, without yielding to the IO loop, such that a single stream
+// with an endless supply of ready data cannot monopolize the loop and starve the other streams on the same IO.
+//
+// Asynchronous operations which can complete right away, because the bytes or the messages are already there, invoke
+// their handler inline. A handler which issues the next operation thus runs again before the IO gets to any other
+// stream. Such back-to-back operations form a run, which ends as soon as the stream goes back to the IO loop, that is
+// once a poll of the IO happens. After maxOps operations in a run, or once the run lasted for maxTime, the next
+// operation is posted to the IO instead, behind the handlers which are already ready to run, and a new run begins.
+type execBudget struct {
+	ioc     *IO
+	maxOps  int           // 0 means no limit
+	maxTime time.Duration // 0 means no limit
+
+	ops   int
+	poll  uint64 // the poll of the IO in which the current run started
+	start time.Time
+}
+
+func (b *execBudget) set(ioc *IO, maxOps int, maxTime time.Duration) {
+	b.ioc = ioc
+	b.maxOps = maxOps
+	b.maxTime = maxTime
+	b.ops = 0
+}
+
+// yield returns true if the budget of the current run is exhausted, in which case fn is posted to the IO and a new run
+// begins. Otherwise, the caller performs the operation inline, which is accounted to the current run.
+func (b *execBudget) yield(fn func()) bool {
+	if b.ioc == nil || (b.maxOps <= 0 && b.maxTime <= 0) {
+		return false
+	}
+
+	if b.ops == 0 || b.poll != b.ioc.polls {
+		b.ops, b.poll = 0, b.ioc.polls
+		if b.maxTime > 0 {
+			b.start = time.Now()
+		}
+	} else if (b.maxOps > 0 && b.ops >= b.maxOps) || (b.maxTime > 0 && time.Since(b.start) >= b.maxTime) {
+		if b.ioc.Post(fn) == nil {
+			b.ops = 0
+			return true
+		}
+		// The IO cannot take the operation, so it is performed inline.
+	}
+	b.ops++
+	return false
+}
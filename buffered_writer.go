@@ -0,0 +1,216 @@
+package sonic
+
+import (
+	"time"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+var _ AsyncWriteStream = &BufferedWriter{}
+
+// BufferedWriterConfig sets when a BufferedWriter flushes the writes it buffers. The buffered writes are flushed as
+// soon as one of the bounds is reached, and at the latest when AsyncFlush is called. A bound which is not positive
+// does not apply.
+type BufferedWriterConfig struct {
+	// MaxBytes bounds the number of bytes buffered.
+	MaxBytes int
+
+	// MaxWrites bounds the number of writes buffered.
+	MaxWrites int
+
+	// MaxDelay bounds the time the first buffered write waits for the next ones.
+	MaxDelay time.Duration
+}
+
+type bufferedWrite struct {
+	n  int
+	cb AsyncCallback
+}
+
+// BufferedWriter coalesces the writes made to an AsyncWriteStream, such that many small writes, like the orders of a
+// burst, are sent with a single write, and thus in as few TCP segments as possible, instead of one each.
+//
+// The bytes of AsyncWrite are copied, so the caller can reuse its buffer right away, but the handler of a write is
+// only invoked once its bytes are written to the stream, along with the other writes of its batch. The writes are
+// written in order. A single batch is written to the stream at a time; the writes made meanwhile are buffered until it
+// completes.
+//
+// Once a batch fails, the BufferedWriter fails the writes buffered and made afterwards with the same error.
+//
+// A BufferedWriter must only be used from the goroutine running the IO.
+type BufferedWriter struct {
+	ioc *IO
+	w   AsyncWriteStream
+	cfg BufferedWriterConfig
+
+	buf     []byte
+	writes  []bufferedWrite
+	flushed []byte          // the batch being written, or a spare buffer
+	done    []bufferedWrite // the writes of the batch being written, or a spare slice
+
+	timer      *Timer
+	timerArmed bool
+	onTimer    func()
+
+	writing bool
+	pending bool // a flush is due once the batch being written completes
+	err     error
+}
+
+// NewBufferedWriter creates a BufferedWriter coalescing the writes made to w, which runs on ioc, as configured by cfg.
+func NewBufferedWriter(ioc *IO, w AsyncWriteStream, cfg BufferedWriterConfig) (*BufferedWriter, error) {
+	b := &BufferedWriter{
+		ioc: ioc,
+		w:   w,
+		cfg: cfg,
+	}
+	if cfg.MaxDelay > 0 {
+		timer, err := NewTimer(ioc)
+		if err != nil {
+			return nil, err
+		}
+		b.timer = timer
+		b.onTimer = func() {
+			b.timerArmed = false
+			b.flush()
+		}
+	}
+	return b, nil
+}
+
+// Buffered returns the number of bytes buffered and not yet handed to the stream.
+func (b *BufferedWriter) Buffered() int {
+	return len(b.buf)
+}
+
+// AsyncWrite buffers p and flushes the buffered writes if a bound of the configuration is reached. cb is invoked once
+// p is written to the stream.
+func (b *BufferedWriter) AsyncWrite(p []byte, cb AsyncCallback) {
+	if b.err != nil {
+		cb(b.err, 0)
+		return
+	}
+
+	b.buf = append(b.buf, p...)
+	b.writes = append(b.writes, bufferedWrite{n: len(p), cb: cb})
+
+	if (b.cfg.MaxBytes > 0 && len(b.buf) >= b.cfg.MaxBytes) || (b.cfg.MaxWrites > 0 && len(b.writes) >= b.cfg.MaxWrites) {
+		b.flush()
+	} else if b.timer != nil && !b.timerArmed && !b.writing {
+		b.armTimer()
+	}
+}
+
+// AsyncWriteAll is the same as AsyncWrite: the bytes of a write are always written in full, unless the stream fails.
+func (b *BufferedWriter) AsyncWriteAll(p []byte, cb AsyncCallback) {
+	b.AsyncWrite(p, cb)
+}
+
+// AsyncFlush flushes the buffered writes. cb is invoked once they are written to the stream.
+func (b *BufferedWriter) AsyncFlush(cb func(err error)) {
+	if b.err != nil {
+		cb(b.err)
+		return
+	}
+	if len(b.writes) == 0 && !b.writing {
+		cb(nil)
+		return
+	}
+
+	b.writes = append(b.writes, bufferedWrite{cb: func(err error, _ int) { cb(err) }})
+	b.flush()
+}
+
+func (b *BufferedWriter) flush() {
+	if b.writing {
+		b.pending = true
+		return
+	}
+	b.pending = false
+	b.disarmTimer()
+
+	if len(b.buf) == 0 {
+		// Only empty writes and flushes are buffered.
+		writes := b.writes
+		b.writes = nil
+		for _, w := range writes {
+			w.cb(nil, 0)
+		}
+		return
+	}
+
+	out, writes := b.buf, b.writes
+	b.buf, b.writes = b.flushed[:0], b.done[:0]
+	b.flushed, b.done = nil, nil
+
+	b.writing = true
+	b.w.AsyncWriteAll(out, func(err error, _ int) {
+		b.writing = false
+		if err != nil && b.err == nil {
+			b.err = err
+		}
+
+		for _, w := range writes {
+			if err != nil {
+				w.cb(err, 0)
+			} else {
+				w.cb(nil, w.n)
+			}
+		}
+		for i := range writes {
+			writes[i] = bufferedWrite{}
+		}
+		b.flushed, b.done = out[:0], writes[:0]
+
+		switch {
+		case b.err != nil:
+			b.fail(b.err)
+		case b.pending:
+			b.flush()
+		case len(b.writes) > 0 && b.timer != nil && !b.timerArmed:
+			b.armTimer()
+		}
+	})
+}
+
+// fail invokes the handlers of the buffered writes with err, and drops them.
+func (b *BufferedWriter) fail(err error) {
+	writes := b.writes
+	b.buf, b.writes = b.buf[:0], nil
+	b.pending = false
+	b.disarmTimer()
+
+	for _, w := range writes {
+		w.cb(err, 0)
+	}
+}
+
+func (b *BufferedWriter) armTimer() {
+	if b.timer.ScheduleOnce(b.cfg.MaxDelay, b.onTimer) == nil {
+		b.timerArmed = true
+	}
+}
+
+func (b *BufferedWriter) disarmTimer() {
+	if b.timerArmed {
+		_ = b.timer.Cancel()
+		b.timerArmed = false
+	}
+}
+
+// Cancel cancels the batch being written to the stream, if any, which fails the BufferedWriter, and fails the buffered
+// writes with sonicerrors.ErrCancelled.
+func (b *BufferedWriter) Cancel() {
+	b.w.Cancel()
+	b.fail(sonicerrors.ErrCancelled)
+}
+
+// Close fails the buffered writes with sonicerrors.ErrCancelled, without flushing them, and closes the stream.
+func (b *BufferedWriter) Close() error {
+	b.fail(sonicerrors.ErrCancelled)
+	if b.timer != nil {
+		_ = b.timer.Close()
+		b.timer = nil
+	}
+	return b.w.Close()
+}
@@ -0,0 +1,135 @@
+package sonic
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// batchRecorder is an AsyncWriteStream recording the batches written to it, which complete once complete is called.
+type batchRecorder struct {
+	batches []string
+	pending []AsyncCallback
+}
+
+func (r *batchRecorder) AsyncWrite(b []byte, cb AsyncCallback) {
+	r.batches = append(r.batches, string(b))
+	n := len(b)
+	r.pending = append(r.pending, func(err error, _ int) { cb(err, n) })
+}
+
+func (r *batchRecorder) AsyncWriteAll(b []byte, cb AsyncCallback) {
+	r.AsyncWrite(b, cb)
+}
+
+func (r *batchRecorder) complete(err error) {
+	pending := r.pending
+	r.pending = nil
+	for _, cb := range pending {
+		cb(err, 0)
+	}
+}
+
+func (r *batchRecorder) Cancel()      {}
+func (r *batchRecorder) Close() error { return nil }
+
+func TestBufferedWriterThresholds(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	r := &batchRecorder{}
+	b, err := NewBufferedWriter(ioc, r, BufferedWriterConfig{MaxBytes: 8, MaxWrites: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var written []int
+	onWrite := func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		written = append(written, n)
+	}
+
+	// Three writes reach MaxWrites.
+	b.AsyncWrite([]byte("a"), onWrite)
+	b.AsyncWrite([]byte("b"), onWrite)
+	if len(r.batches) != 0 || b.Buffered() != 2 {
+		t.Fatalf("expected 2 buffered bytes got=%d batches=%v", b.Buffered(), r.batches)
+	}
+	b.AsyncWrite([]byte("c"), onWrite)
+	if len(r.batches) != 1 || r.batches[0] != "abc" {
+		t.Fatalf("expected the batch abc got=%v", r.batches)
+	}
+
+	// The writes made while a batch is written wait for it, even past a bound.
+	b.AsyncWrite([]byte("0123456789"), onWrite)
+	if len(r.batches) != 1 || len(written) != 0 {
+		t.Fatalf("expected no new batch got=%v", r.batches)
+	}
+	r.complete(nil)
+	if len(written) != 3 {
+		t.Fatalf("expected 3 completed writes got=%v", written)
+	}
+	if len(r.batches) != 2 || r.batches[1] != "0123456789" {
+		t.Fatalf("expected the pending batch to be written got=%v", r.batches)
+	}
+	r.complete(nil)
+
+	// An explicit flush writes what is buffered.
+	b.AsyncWrite([]byte("d"), onWrite)
+	flushed := false
+	b.AsyncFlush(func(err error) { flushed = err == nil })
+	if len(r.batches) != 3 || r.batches[2] != "d" || flushed {
+		t.Fatalf("expected the batch d got=%v", r.batches)
+	}
+	r.complete(nil)
+	if !flushed {
+		t.Fatal("expected the flush to complete")
+	}
+	if len(written) != 5 || written[3] != 10 || written[4] != 1 {
+		t.Fatalf("unexpected completed writes %v", written)
+	}
+
+	// A failed batch fails the BufferedWriter.
+	var failed []error
+	b.AsyncWrite([]byte("efg"), func(err error, _ int) { failed = append(failed, err) })
+	b.AsyncFlush(func(error) {})
+	b.AsyncWrite([]byte("h"), func(err error, _ int) { failed = append(failed, err) })
+	r.complete(io.ErrClosedPipe)
+	b.AsyncWrite([]byte("i"), func(err error, _ int) { failed = append(failed, err) })
+	if len(failed) != 3 {
+		t.Fatalf("expected 3 failed writes got=%v", failed)
+	}
+	for _, err := range failed {
+		if !errors.Is(err, io.ErrClosedPipe) {
+			t.Fatalf("expected io.ErrClosedPipe got=%v", err)
+		}
+	}
+}
+
+func TestBufferedWriterMaxDelay(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	r := &batchRecorder{}
+	b, err := NewBufferedWriter(ioc, r, BufferedWriterConfig{MaxDelay: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	start := time.Now()
+	b.AsyncWrite([]byte("a"), func(error, int) {})
+	b.AsyncWrite([]byte("b"), func(error, int) {})
+	for len(r.batches) == 0 && time.Since(start) < 5*time.Second {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if len(r.batches) != 1 || r.batches[0] != "ab" {
+		t.Fatalf("expected the batch ab got=%v", r.batches)
+	}
+	if d := time.Since(start); d < 10*time.Millisecond {
+		t.Fatalf("flushed after %s, before the delay", d)
+	}
+}
@@ -3,6 +3,7 @@ package sonic
 import (
 	"io"
 
+	"github.com/csdenboer/sonic/bytes"
 	"github.com/csdenboer/sonic/sonicerrors"
 )
 
@@ -31,9 +32,71 @@ type ByteBuffer struct {
 	ri int // End index of the read area, always smaller or equal to wi.
 	wi int // End index of the write area.
 
+	// gen is incremented each time bytes are removed from the buffer, which invalidates all marks.
+	gen uint64
+
 	oneByte [1]byte
 
 	data []byte
+
+	// ioc checks the commits and consumes of the buffer while in debug mode, see SetDebugIO.
+	ioc *IO
+
+	// growth and maxSize bound the growth of data, see SetGrowthPolicy and SetMaxSize. maxSize is 0 if the buffer is
+	// unbounded.
+	growth  GrowthPolicy
+	maxSize int
+
+	// mirror backs the buffer of NewMirroredByteBuffer, in which case data starts at offset base of its mapping.
+	mirror *bytes.MirroredBuffer
+	base   int
+}
+
+// GrowthPolicy returns the capacity to which a ByteBuffer of capacity capacity grows when it needs a capacity of at
+// least required bytes. The capacity returned must be at least required.
+type GrowthPolicy func(capacity, required int) int
+
+// bufferReaderFrom is implemented by writers which take the read area of a ByteBuffer without going through
+// io.Writer, see ByteBuffer.WriteTo.
+type bufferReaderFrom interface {
+	readFromBuffer(b *ByteBuffer) (int64, error)
+}
+
+// bufferWriterTo is implemented by readers which fill the write area of a ByteBuffer without going through
+// io.Reader, see ByteBuffer.ReadFrom.
+type bufferWriterTo interface {
+	writeToBuffer(b *ByteBuffer) (int64, error)
+}
+
+// ExponentialGrowth doubles the capacity of the buffer until it fits, growing it by at most maxStep bytes at a time
+// if maxStep is positive. Capping the step keeps a large buffer from doubling for a few bytes more.
+func ExponentialGrowth(maxStep int) GrowthPolicy {
+	return func(capacity, required int) int {
+		if capacity <= 0 {
+			capacity = 1
+		}
+		for capacity < required {
+			step := capacity
+			if maxStep > 0 && step > maxStep {
+				step = maxStep
+			}
+			capacity += step
+		}
+		return capacity
+	}
+}
+
+// FixedGrowth grows the capacity of the buffer by a multiple of step bytes.
+func FixedGrowth(step int) GrowthPolicy {
+	if step <= 0 {
+		step = 1
+	}
+	return func(capacity, required int) int {
+		if capacity >= required {
+			return capacity
+		}
+		return capacity + (required-capacity+step-1)/step*step
+	}
 }
 
 var (
@@ -62,13 +125,101 @@ func NewByteBuffer() *ByteBuffer {
 // into the ByteBuffer's write area.
 //
 // This call grows the write area by at least `n` bytes. This might allocate.
-func (b *ByteBuffer) Reserve(n int) {
-	existing := cap(b.data) - b.wi
-	if need := n - existing; need > 0 {
+//
+// If the buffer cannot hold n more bytes without exceeding its maximum size,
+// see SetMaxSize, it grows up to its maximum size and ErrBufferMaxSize is
+// returned.
+func (b *ByteBuffer) Reserve(n int) error {
+	if n > cap(b.data)-b.wi {
+		return b.grow(b.wi + n)
+	}
+	return nil
+}
+
+// SetGrowthPolicy sets how the buffer grows when it runs out of capacity. By
+// default, it grows to the capacity it needs and no more.
+func (b *ByteBuffer) SetGrowthPolicy(policy GrowthPolicy) {
+	b.growth = policy
+}
+
+// SetMaxSize bounds the capacity of the buffer to n bytes, 0 meaning no bound.
+// Past it, writes and reserves fail with ErrBufferMaxSize, as do ReadFrom and
+// AsyncReadFrom once the write area cannot take another byte. A codec stream
+// reading into the buffer then fails with ErrBufferMaxSize on a message which
+// does not fit, instead of growing with whatever size a peer announces.
+//
+// The capacity of a buffer which already exceeds n is not reduced. The maximum
+// size of a mirrored buffer, see NewMirroredByteBuffer, can only be lowered.
+func (b *ByteBuffer) SetMaxSize(n int) {
+	if n < 0 {
+		n = 0
+	}
+	if b.mirror != nil && (n == 0 || n > b.mirror.Size()) {
+		n = b.mirror.Size()
+	}
+	b.maxSize = n
+}
+
+// MaxSize returns the maximum size of the buffer set with SetMaxSize, 0 if the
+// buffer is unbounded.
+func (b *ByteBuffer) MaxSize() int {
+	return b.maxSize
+}
+
+// grow grows the capacity of the buffer to at least required bytes, following
+// the growth policy and bounded by the maximum size of the buffer.
+func (b *ByteBuffer) grow(required int) error {
+	var err error
+	if b.maxSize > 0 && required > b.maxSize {
+		required, err = b.maxSize, sonicerrors.ErrBufferMaxSize
+	}
+	if required <= cap(b.data) {
+		return err
+	}
+
+	if b.growth == nil {
 		b.data = b.data[:cap(b.data)]
-		b.data = append(b.data, make([]byte, need)...)
+		b.data = append(b.data, make([]byte, required-cap(b.data))...)
+		if b.maxSize > 0 && cap(b.data) > b.maxSize {
+			b.data = b.data[:b.maxSize:b.maxSize]
+		}
+		b.data = b.data[:b.wi]
+		return err
 	}
-	b.data = b.data[:b.wi]
+
+	capacity := b.growth(cap(b.data), required)
+	if b.maxSize > 0 && capacity > b.maxSize {
+		capacity = b.maxSize
+	}
+	if capacity < required {
+		capacity = required
+	}
+	data := make([]byte, b.wi, capacity)
+	copy(data, b.data[:b.wi])
+	b.data = data
+	return err
+}
+
+// fits grows the buffer such that n more bytes can be written, or returns
+// ErrBufferMaxSize without growing it if that exceeds its maximum size.
+func (b *ByteBuffer) fits(n int) error {
+	if n <= cap(b.data)-b.wi || (b.growth == nil && b.maxSize == 0) {
+		// append grows the buffer of the default policy, without zeroing the bytes it is about to overwrite.
+		return nil
+	}
+	if b.maxSize > 0 && b.wi+n > b.maxSize {
+		return sonicerrors.ErrBufferMaxSize
+	}
+	return b.grow(b.wi + n)
+}
+
+// full returns ErrBufferMaxSize if the write area cannot take another byte
+// because the buffer reached its maximum size.
+func (b *ByteBuffer) full() error {
+	if b.maxSize > 0 && b.wi >= cap(b.data) && cap(b.data) >= b.maxSize {
+		return sonicerrors.ErrBufferMaxSize
+	}
+	return nil
 }
 
 // Reserved returns the number of bytes that can be written
@@ -77,12 +228,34 @@ func (b *ByteBuffer) Reserved() int {
 	return cap(b.data) - b.wi
 }
 
+// SetDebugIO makes the buffer check its commits, its consumes and its invariants while ioc is in debug mode, see
+// IO.SetDebug. Committing or consuming more bytes than available is then reported as a violation, instead of being
+// silently clamped.
+func (b *ByteBuffer) SetDebugIO(ioc *IO) {
+	b.ioc = ioc
+}
+
+// checkAreas reports a violation if the invariants of the buffer do not hold after op.
+func (b *ByteBuffer) checkAreas(op string) {
+	if !(0 <= b.si && b.si <= b.ri && b.ri <= b.wi && b.wi == len(b.data) && len(b.data) <= cap(b.data)) {
+		b.ioc.Violation(-1, "byte buffer areas after %s: si=%d ri=%d wi=%d len=%d cap=%d",
+			op, b.si, b.ri, b.wi, len(b.data), cap(b.data))
+	}
+}
+
 // Commit moves `n` bytes from the write area to the read area.
 func (b *ByteBuffer) Commit(n int) {
 	if n <= 0 {
 		return
 	}
 
+	if b.ioc != nil && b.ioc.debug {
+		if n > b.WriteLen() {
+			b.ioc.Violation(-1, "byte buffer commit of %d bytes with %d written", n, b.WriteLen())
+		}
+		defer b.checkAreas("commit")
+	}
+
 	b.ri += n
 	if b.ri > b.wi {
 		b.ri = b.wi
@@ -142,11 +315,25 @@ func (b *ByteBuffer) Consume(n int) {
 		return
 	}
 
+	if b.ioc != nil && b.ioc.debug {
+		if n > b.ReadLen() {
+			b.ioc.Violation(-1, "byte buffer consume of %d bytes with %d readable", n, b.ReadLen())
+		}
+		defer b.checkAreas("consume")
+	}
+
 	if readLen := b.ReadLen(); n > readLen {
 		n = readLen
 	}
 
 	if n > 0 {
+		b.gen++
+
+		if b.mirror != nil && b.si == 0 {
+			b.advance(n)
+			return
+		}
+
 		// TODO this can be smarter
 		copy(b.data[b.si:], b.data[b.si+n:b.wi])
 
@@ -192,6 +379,14 @@ func (b *ByteBuffer) Discard(slot Slot) (discarded int) {
 		return 0
 	}
 
+	b.gen++
+
+	if b.mirror != nil && slot.Index == 0 {
+		b.si -= slot.Length
+		b.advance(slot.Length)
+		return slot.Length
+	}
+
 	copy(b.data[slot.Index:], b.data[slot.Index+slot.Length:b.wi])
 	b.si -= slot.Length
 	b.ri -= slot.Length
@@ -209,12 +404,71 @@ func (b *ByteBuffer) DiscardAll() {
 }
 
 func (b *ByteBuffer) Reset() {
+	b.gen++
 	b.si = 0
 	b.ri = 0
 	b.wi = 0
 	b.data = b.data[:0]
 }
 
+// Detach removes the backing array of an empty buffer and returns it, emptied, such that it can be reused elsewhere.
+// The buffer then holds no memory until the next Attach, Reserve or Write.
+//
+// Detach returns nil and leaves the buffer as is if the buffer holds any bytes, or if it is a mirrored buffer.
+func (b *ByteBuffer) Detach() []byte {
+	if b.wi != 0 || cap(b.data) == 0 || b.mirror != nil {
+		return nil
+	}
+	data := b.data[:0]
+	b.data = nil
+	return data
+}
+
+// Attach makes data, emptied, the backing array of an empty buffer which holds no memory, such as one emptied with
+// Detach.
+//
+// Attach returns false and leaves the buffer as is if the buffer holds any bytes or memory.
+func (b *ByteBuffer) Attach(data []byte) bool {
+	if b.wi != 0 || cap(b.data) != 0 {
+		return false
+	}
+	b.data = data[:0]
+	return true
+}
+
+// ByteBufferMark is a snapshot of the save and read areas of a ByteBuffer.
+type ByteBufferMark struct {
+	si, ri int
+	gen    uint64
+}
+
+// Mark the current state of the save and read areas such that it can be
+// restored later with Rollback.
+//
+// Marks enable speculative parsing: a codec marks the buffer, commits and
+// saves bytes while parsing a message and, if the message turns out to be
+// incomplete, rolls back such that the next attempt starts from the same
+// place. The bytes stay in the buffer.
+func (b *ByteBuffer) Mark() ByteBufferMark {
+	return ByteBufferMark{si: b.si, ri: b.ri, gen: b.gen}
+}
+
+// Rollback the save and read areas to the given mark. Bytes committed since
+// the mark are moved back to the write area. Bytes saved since the mark are
+// moved back to the read area.
+//
+// A mark is invalidated by any call that removes bytes from the buffer, such
+// as Consume, Discard or Reset. Rolling back to an invalid mark returns
+// ErrStaleMark and leaves the buffer untouched.
+func (b *ByteBuffer) Rollback(mark ByteBufferMark) error {
+	if mark.gen != b.gen {
+		return sonicerrors.ErrStaleMark
+	}
+	b.si = mark.si
+	b.ri = mark.ri
+	return nil
+}
+
 // Read the bytes from the read area into `dst`. Consume them.
 func (b *ByteBuffer) Read(dst []byte) (int, error) {
 	if len(dst) == 0 {
@@ -242,7 +496,22 @@ func (b *ByteBuffer) ReadByte() (byte, error) {
 // The buffer is not automatically grown to accommodate all data from the reader.
 // The responsibility is left to the caller which can reserve enough space
 // through Reserve.
+//
+// If the reader is a ByteBuffer, the bytes of its read area are copied
+// directly into the write area and consumed from the reader. If the reader is a
+// Splicer, the bytes it buffered are read directly into the write area. In both
+// cases io.ErrShortBuffer is returned if the write area is full.
 func (b *ByteBuffer) ReadFrom(r io.Reader) (int64, error) {
+	if src, ok := r.(*ByteBuffer); ok {
+		return b.readFromByteBuffer(src)
+	}
+	if src, ok := r.(bufferWriterTo); ok {
+		return src.writeToBuffer(b)
+	}
+	if err := b.full(); err != nil {
+		return 0, err
+	}
+
 	n, err := r.Read(b.data[b.wi:cap(b.data)])
 	if err == nil {
 		b.wi += n
@@ -251,6 +520,22 @@ func (b *ByteBuffer) ReadFrom(r io.Reader) (int64, error) {
 	return int64(n), err
 }
 
+func (b *ByteBuffer) readFromByteBuffer(src *ByteBuffer) (int64, error) {
+	if src.ReadLen() == 0 {
+		return 0, io.EOF
+	}
+	if b.Reserved() == 0 {
+		return 0, io.ErrShortBuffer
+	}
+
+	n := copy(b.data[b.wi:cap(b.data)], src.Data())
+	b.wi += n
+	b.data = b.data[:b.wi]
+	src.Consume(n)
+
+	return int64(n), nil
+}
+
 // UnreadByte from the write area.
 func (b *ByteBuffer) UnreadByte() error {
 	if b.WriteLen() > 0 {
@@ -267,6 +552,10 @@ func (b *ByteBuffer) UnreadByte() error {
 // The responsibility is left to the caller which can reserve enough space
 // through Reserve.
 func (b *ByteBuffer) AsyncReadFrom(r AsyncReader, cb AsyncCallback) {
+	if err := b.full(); err != nil {
+		cb(err, 0)
+		return
+	}
 	r.AsyncRead(b.data[b.wi:cap(b.data)], func(err error, n int) {
 		if err == nil {
 			b.wi += n
@@ -277,7 +566,13 @@ func (b *ByteBuffer) AsyncReadFrom(r AsyncReader, cb AsyncCallback) {
 }
 
 // Write the supplied slice into the write area. Grow the write area if needed.
+//
+// Nothing is written if the buffer would exceed its maximum size, in which
+// case ErrBufferMaxSize is returned.
 func (b *ByteBuffer) Write(bb []byte) (int, error) {
+	if err := b.fits(len(bb)); err != nil {
+		return 0, err
+	}
 	b.data = append(b.data, bb...)
 	n := len(bb)
 	b.wi += n
@@ -287,6 +582,9 @@ func (b *ByteBuffer) Write(bb []byte) (int, error) {
 
 // WriteByte into the write area. Grow the write area if needed.
 func (b *ByteBuffer) WriteByte(bb byte) error {
+	if err := b.fits(1); err != nil {
+		return err
+	}
 	b.data = append(b.data, bb)
 	b.wi += 1
 	b.data = b.data[:b.wi]
@@ -295,6 +593,9 @@ func (b *ByteBuffer) WriteByte(bb byte) error {
 
 // WriteString into the write area. Grow the write area if needed.
 func (b *ByteBuffer) WriteString(s string) (int, error) {
+	if err := b.fits(len(s)); err != nil {
+		return 0, err
+	}
 	b.data = append(b.data, s...)
 	n := len(s)
 	b.wi += n
@@ -304,7 +605,21 @@ func (b *ByteBuffer) WriteString(s string) (int, error) {
 
 // WriteTo the provided writer bytes from the read area. Consume them if no
 // error occurred.
+//
+// If the writer is a ByteBuffer, the bytes are appended directly to its write
+// area, which is grown if needed, unless that exceeds its maximum size. If the
+// writer is a Splicer, the bytes are written directly into its pipe, from where
+// they can be spliced into a socket.
 func (b *ByteBuffer) WriteTo(w io.Writer) (int64, error) {
+	if dst, ok := w.(*ByteBuffer); ok {
+		n, err := dst.Write(b.Data())
+		b.Consume(n)
+		return int64(n), err
+	}
+	if dst, ok := w.(bufferReaderFrom); ok {
+		return dst.readFromBuffer(b)
+	}
+
 	var (
 		n            int
 		err          error
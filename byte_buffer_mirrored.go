@@ -0,0 +1,56 @@
+package sonic
+
+import (
+	"github.com/csdenboer/sonic/bytes"
+)
+
+// NewMirroredByteBuffer returns a ByteBuffer of a fixed size backed by a bytes.MirroredBuffer: its memory is mapped
+// twice, back to back, so the bytes which wrap around the end of the buffer still read as one continuous slice.
+//
+// A ByteBuffer moves the bytes left in its read and write areas to its start whenever bytes are consumed, such that
+// they stay continuous. A mirrored one does not: consuming bytes, or discarding the first saved slot, only moves the
+// start of the buffer forward in its mapping, whatever the number of bytes left. Passing a mirrored buffer as the
+// source buffer of a codec stream thus removes the compaction copies from its read path.
+//
+// size is rounded up to a multiple of the page size. The buffer cannot grow past it: its maximum size is its size,
+// see SetMaxSize, so a message which does not fit fails the codec stream with sonicerrors.ErrBufferMaxSize. The
+// buffer must be released with Destroy.
+func NewMirroredByteBuffer(size int, prefault bool) (*ByteBuffer, error) {
+	mirror, err := bytes.NewMirroredBuffer(size, prefault)
+	if err != nil {
+		return nil, err
+	}
+	b := &ByteBuffer{
+		mirror:  mirror,
+		maxSize: mirror.Size(),
+	}
+	b.data = mirror.Mapping()[:0:mirror.Size()]
+	return b, nil
+}
+
+// Mirrored returns true if the buffer was created with NewMirroredByteBuffer.
+func (b *ByteBuffer) Mirrored() bool {
+	return b.mirror != nil
+}
+
+// Destroy unmaps the memory of a mirrored buffer, after which the buffer must not be used. It does nothing for the
+// other buffers, whose memory is garbage collected.
+func (b *ByteBuffer) Destroy() error {
+	if b.mirror == nil {
+		return nil
+	}
+	err := b.mirror.Destroy()
+	b.mirror, b.data = nil, nil
+	b.si, b.ri, b.wi, b.base = 0, 0, 0, 0
+	return err
+}
+
+// advance removes the first n bytes of a mirrored buffer by moving its start n bytes forward in the mapping, wrapping
+// around to the first mapping once it passes the end of it.
+func (b *ByteBuffer) advance(n int) {
+	size := b.mirror.Size()
+	b.base = (b.base + n) % size
+	b.ri -= n
+	b.wi -= n
+	b.data = b.mirror.Mapping()[b.base : b.base+b.wi : b.base+size]
+}
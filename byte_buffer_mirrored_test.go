@@ -0,0 +1,235 @@
+package sonic
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+func TestMirroredByteBufferWraps(t *testing.T) {
+	size := syscall.Getpagesize()
+	b, err := NewMirroredByteBuffer(size, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Destroy()
+
+	if !b.Mirrored() || b.Cap() != size || b.MaxSize() != size {
+		t.Fatalf("wrong mirrored buffer cap=%d max=%d", b.Cap(), b.MaxSize())
+	}
+
+	// Messages of a size which does not divide the size of the buffer end up wrapping around its end.
+	msg := make([]byte, 100)
+	for i := 0; i < 10*size/len(msg); i++ {
+		for j := range msg {
+			msg[j] = byte(i + j)
+		}
+		if _, err := b.Write(msg[:30]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.Write(msg[30:]); err != nil {
+			t.Fatal(err)
+		}
+		b.Commit(len(msg))
+
+		data := b.Data()
+		if len(data) != len(msg) {
+			t.Fatalf("expected %d bytes got=%d", len(msg), len(data))
+		}
+		for j := range data {
+			if data[j] != msg[j] {
+				t.Fatalf("message %d: wrong byte at %d", i, j)
+			}
+		}
+
+		before := &b.Data()[0]
+		b.Consume(len(msg))
+		if b.ReadLen() != 0 || b.WriteLen() != 0 {
+			t.Fatal("expected the buffer to be empty")
+		}
+		if b.Cap() != size {
+			t.Fatalf("expected the capacity to stay %d got=%d", size, b.Cap())
+		}
+		if _, err := b.Write([]byte{1}); err != nil {
+			t.Fatal(err)
+		}
+		if &b.data[0] == before {
+			t.Fatal("expected the start of the buffer to move instead of the bytes")
+		}
+		b.ShrinkBy(1)
+	}
+}
+
+func TestMirroredByteBufferSaveAndDiscard(t *testing.T) {
+	b, err := NewMirroredByteBuffer(syscall.Getpagesize(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Destroy()
+
+	b.WriteString("helloworld!")
+	b.Commit(11)
+	first := b.Save(5)
+	second := b.Save(5)
+
+	// Consuming with saved bytes moves the bytes left.
+	b.Consume(1)
+	if string(b.SavedSlot(first)) != "hello" || string(b.SavedSlot(second)) != "world" || b.ReadLen() != 0 {
+		t.Fatal("wrong saved slots")
+	}
+
+	b.Discard(first)
+	if string(b.Saved()) != "world" {
+		t.Fatalf("wrong save area after discarding the first slot got=%q", b.Saved())
+	}
+	b.DiscardAll()
+	if b.SaveLen() != 0 || b.Len() != 0 {
+		t.Fatal("expected the buffer to be empty")
+	}
+}
+
+func TestMirroredByteBufferMaxSize(t *testing.T) {
+	size := syscall.Getpagesize()
+	b, err := NewMirroredByteBuffer(size, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Destroy()
+
+	if err := b.Reserve(size + 1); !errors.Is(err, sonicerrors.ErrBufferMaxSize) {
+		t.Fatalf("expected ErrBufferMaxSize got=%v", err)
+	}
+	b.SetMaxSize(0)
+	if b.MaxSize() != size {
+		t.Fatal("expected the max size of a mirrored buffer not to be removed")
+	}
+	if b.Detach() != nil {
+		t.Fatal("expected a mirrored buffer not to be detached")
+	}
+}
+
+func TestMirroredByteBufferCodecConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// Far more bytes than the buffer holds, in messages of a size which does not divide it.
+	const count = 1000
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for i := 0; i < count; i++ {
+			payload := fmt.Sprintf("message %d %0300d", i, i)
+			b := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+			if _, err := conn.Write(append(b, payload...)); err != nil {
+				return
+			}
+		}
+		time.Sleep(time.Second)
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	src, err := NewMirroredByteBuffer(syscall.Getpagesize(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Destroy()
+	codecConn, err := NewNonblockingCodecConn[[]byte, []byte](conn, lengthCodec{}, src, NewByteBuffer())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := 0
+	var onRead AsyncItemCallback[[]byte]
+	onRead = func(err error, b []byte) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := fmt.Sprintf("message %d %0300d", read, read); string(b) != expected {
+			t.Fatalf("wrong message %d got=%q", read, b)
+		}
+		read++
+		if read < count {
+			codecConn.AsyncReadNext(onRead)
+		}
+	}
+	codecConn.AsyncReadNext(onRead)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for read < count && time.Now().Before(deadline) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if read != count {
+		t.Fatalf("expected %d messages got=%d", count, read)
+	}
+}
+
+func BenchmarkMirroredByteBuffer(b *testing.B) {
+	// The workflow of a streaming codec: a read fills the buffer, here with a memcpy, then the messages it holds are
+	// committed and consumed one by one. A ByteBuffer moves the bytes left on each consume, a mirrored one does not.
+	var (
+		size   = syscall.Getpagesize() * 32
+		toCopy = make([]byte, size)
+
+		nMessages = []int{1, 2, 4, 8, 16, 32, 64, 128, 256, 512}
+	)
+
+	newBuffers := []struct {
+		name string
+		new  func() (*ByteBuffer, error)
+	}{
+		{"byte_buffer", func() (*ByteBuffer, error) {
+			buf := NewByteBuffer()
+			buf.Reserve(size)
+			return buf, nil
+		}},
+		{"mirrored_byte_buffer", func() (*ByteBuffer, error) {
+			return NewMirroredByteBuffer(size, true)
+		}},
+	}
+
+	for _, newBuffer := range newBuffers {
+		for _, nMessage := range nMessages {
+			bytesPerMessage := size / nMessage
+			b.Run(fmt.Sprintf("%s_%d", newBuffer.name, nMessage), func(b *testing.B) {
+				buf, err := newBuffer.new()
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer buf.Destroy()
+				buf.Prefault()
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					buf.Claim(func(b []byte) int {
+						return copy(b, toCopy)
+					})
+					for buf.WriteLen() > 0 {
+						buf.Commit(bytesPerMessage)
+						buf.Consume(bytesPerMessage)
+					}
+				}
+				b.ReportAllocs()
+			})
+		}
+	}
+}
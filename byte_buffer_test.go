@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"testing"
 	"time"
@@ -214,6 +215,90 @@ func TestByteBufferPrepareRead(t *testing.T) {
 	}
 }
 
+func TestByteBufferMarkAndRollback(t *testing.T) {
+	b := NewByteBuffer()
+
+	b.Write([]byte("hello world"))
+	b.Commit(2)
+
+	mark := b.Mark()
+	if err := b.PrepareRead(5); err != nil {
+		t.Fatal(err)
+	}
+	b.Save(3)
+	if b.SaveLen() != 3 || b.ReadLen() != 2 || b.WriteLen() != 6 {
+		t.Fatal("invalid areas before rollback")
+	}
+
+	if err := b.Rollback(mark); err != nil {
+		t.Fatal(err)
+	}
+	if b.SaveLen() != 0 || b.ReadLen() != 2 || b.WriteLen() != 9 {
+		t.Fatal("invalid areas after rollback")
+	}
+	if string(b.Data()) != "he" {
+		t.Fatal("invalid read area after rollback")
+	}
+
+	mark = b.Mark()
+	b.Consume(1)
+	if err := b.Rollback(mark); !errors.Is(err, sonicerrors.ErrStaleMark) {
+		t.Fatal("should not be able to rollback after consuming")
+	}
+	if string(b.Data()) != "e" {
+		t.Fatal("a failed rollback should not change the buffer")
+	}
+}
+
+func TestByteBufferReadFromAndWriteToByteBuffer(t *testing.T) {
+	src := NewByteBuffer()
+	src.Write([]byte("hello"))
+	src.Commit(5)
+
+	dst := NewByteBuffer()
+
+	n, err := dst.ReadFrom(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 || dst.WriteLen() != 5 {
+		t.Fatal("invalid write area after ReadFrom")
+	}
+	if src.ReadLen() != 0 {
+		t.Fatal("the bytes read should be consumed from the source")
+	}
+
+	src.Write([]byte("world"))
+	src.Commit(5)
+
+	n, err = src.WriteTo(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 || src.ReadLen() != 0 {
+		t.Fatal("the bytes written should be consumed from the source")
+	}
+
+	dst.Commit(dst.WriteLen())
+	if string(dst.Data()) != "helloworld" {
+		t.Fatalf("expected helloworld got=%s", dst.Data())
+	}
+
+	if _, err := dst.ReadFrom(src); err != io.EOF {
+		t.Fatal("reading from an empty buffer should return EOF")
+	}
+
+	src.Write([]byte("hello"))
+	src.Commit(5)
+	dst.Claim(func(b []byte) int { return cap(b) })
+	if n, err := dst.ReadFrom(src); n != 0 || err != io.ErrShortBuffer {
+		t.Fatalf("reading into a full buffer should return ErrShortBuffer got=%d err=%v", n, err)
+	}
+	if src.ReadLen() != 5 {
+		t.Fatal("the bytes should not be consumed from the source")
+	}
+}
+
 func TestByteBufferClaim1(t *testing.T) {
 	b := NewByteBuffer()
 
@@ -638,6 +723,133 @@ func TestByteBufferShrinkTo(t *testing.T) {
 	}
 }
 
+func TestByteBufferGrowthPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   GrowthPolicy
+		reserves []int
+		expected []int
+	}{
+		{"exponential", ExponentialGrowth(0), []int{600, 1500, 5000}, []int{1024, 2048, 8192}},
+		{"exponential with cap", ExponentialGrowth(1024), []int{600, 1500, 5000}, []int{1024, 2048, 5120}},
+		{"fixed", FixedGrowth(100), []int{600, 1500, 1501}, []int{612, 1512, 1512}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := NewByteBuffer()
+			b.SetGrowthPolicy(test.policy)
+			for i, n := range test.reserves {
+				if err := b.Reserve(n); err != nil {
+					t.Fatal(err)
+				}
+				if b.Cap() != test.expected[i] {
+					t.Fatalf("reserve %d: expected capacity %d got=%d", n, test.expected[i], b.Cap())
+				}
+			}
+		})
+	}
+
+	// The written bytes are kept when the buffer grows.
+	b := NewByteBuffer()
+	b.SetGrowthPolicy(FixedGrowth(1))
+	b.Write([]byte("hello"))
+	b.Commit(5)
+	b.Write(make([]byte, 1024))
+	if b.Cap() != 5+1024 || string(b.Data()) != "hello" {
+		t.Fatalf("wrong buffer after growing cap=%d data=%q", b.Cap(), b.Data())
+	}
+}
+
+func TestByteBufferMaxSize(t *testing.T) {
+	b := NewByteBuffer()
+	b.SetMaxSize(1024)
+	if b.MaxSize() != 1024 {
+		t.Fatal("wrong max size")
+	}
+
+	if err := b.Reserve(4096); !errors.Is(err, sonicerrors.ErrBufferMaxSize) {
+		t.Fatalf("expected ErrBufferMaxSize got=%v", err)
+	}
+	if b.Cap() != 1024 {
+		t.Fatalf("expected the buffer to grow up to its max size got=%d", b.Cap())
+	}
+
+	if n, err := b.Write(make([]byte, 1000)); n != 1000 || err != nil {
+		t.Fatalf("expected the write to fit n=%d err=%v", n, err)
+	}
+	if n, err := b.Write(make([]byte, 25)); n != 0 || !errors.Is(err, sonicerrors.ErrBufferMaxSize) {
+		t.Fatalf("expected the write to fail n=%d err=%v", n, err)
+	}
+	if _, err := b.WriteString("abcdefghijklmnopqrstuvwxyz"); !errors.Is(err, sonicerrors.ErrBufferMaxSize) {
+		t.Fatalf("expected the write to fail err=%v", err)
+	}
+	b.Claim(func(b []byte) int { return len(b) })
+	if err := b.WriteByte(1); !errors.Is(err, sonicerrors.ErrBufferMaxSize) {
+		t.Fatalf("expected the write to fail err=%v", err)
+	}
+	if b.WriteLen() != 1024 {
+		t.Fatalf("expected the buffer to be full got=%d", b.WriteLen())
+	}
+
+	// A full buffer cannot read more.
+	if _, err := b.ReadFrom(bytes.NewReader([]byte("more"))); !errors.Is(err, sonicerrors.ErrBufferMaxSize) {
+		t.Fatalf("expected ReadFrom to fail err=%v", err)
+	}
+
+	// Consuming bytes makes room for more, without growing the buffer.
+	b.Commit(1024)
+	b.Consume(512)
+	if n, err := b.Write(make([]byte, 512)); n != 512 || err != nil {
+		t.Fatalf("expected the write to fit n=%d err=%v", n, err)
+	}
+	if b.Cap() != 1024 {
+		t.Fatalf("expected the buffer not to grow got=%d", b.Cap())
+	}
+}
+
+func TestByteBufferDetachAttach(t *testing.T) {
+	b := NewByteBuffer()
+	b.Write([]byte("hello"))
+	if b.Detach() != nil {
+		t.Fatal("should not detach a buffer holding bytes")
+	}
+
+	b.Commit(5)
+	b.Consume(5)
+	data := b.Detach()
+	if data == nil || len(data) != 0 || cap(data) < 5 {
+		t.Fatal("should have detached the backing array")
+	}
+	if b.Cap() != 0 {
+		t.Fatal("a detached buffer should hold no memory")
+	}
+	if b.Detach() != nil {
+		t.Fatal("should not detach twice")
+	}
+
+	if !b.Attach(data) || b.Cap() != cap(data) {
+		t.Fatal("should have attached the array")
+	}
+	if b.Attach(make([]byte, 8)) {
+		t.Fatal("should not attach to a buffer holding memory")
+	}
+
+	b.Write([]byte("world"))
+	b.Commit(5)
+	if string(b.Data()) != "world" {
+		t.Fatal("wrong data after attach")
+	}
+
+	// A detached buffer grows on the next write.
+	b.Consume(5)
+	b.Detach()
+	b.Write([]byte("again"))
+	b.Commit(5)
+	if string(b.Data()) != "again" {
+		t.Fatal("wrong data after detach")
+	}
+}
+
 func BenchmarkByteBuffer(b *testing.B) {
 	var letters = []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
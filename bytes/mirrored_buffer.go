@@ -201,6 +201,20 @@ func (b *MirroredBuffer) UsedSpace() int {
 	return b.used
 }
 
+// Data returns the committed bytes which are not consumed yet, as one
+// continuous slice even if they wrap around the end of the buffer.
+func (b *MirroredBuffer) Data() []byte {
+	return b.slice[b.head : b.head+b.used]
+}
+
+// Mapping returns both mappings of the buffer: 2*Size() bytes, where the byte
+// at offset i+Size() is the byte at offset i. Any slice of at most Size()
+// bytes of the mapping is thus a continuous view of the buffer, which lets
+// other containers lay their own state on top of it.
+func (b *MirroredBuffer) Mapping() []byte {
+	return b.slice
+}
+
 func (b *MirroredBuffer) Claim(n int) []byte {
 	if free := b.FreeSpace(); n > free {
 		n = free
@@ -11,7 +11,6 @@ import (
 	"testing"
 	"time"
 
-	"github.com/csdenboer/sonic"
 	"github.com/csdenboer/sonic/util"
 )
 
@@ -514,11 +513,8 @@ func BenchmarkMirroredBuffer(b *testing.B) {
 	//   needed in order to make space for the remainder of the message before a
 	//   new network `read` is performed.
 	//
-	// (Arguably, it could be more efficient to Consume only after processing
-	// all complete messages in a `ByteBuffer`. However, we currently don't do
-	// that in production. As such, this benchmark reflects the improvements
-	// that we would get by swapping `ByteBuffer` with `MirroredBuffer` right
-	// now).
+	// See BenchmarkMirroredByteBuffer in package sonic for the same workflow on
+	// a ByteBuffer, with and without a mirrored backing.
 
 	var (
 		size   = syscall.Getpagesize() * 32
@@ -528,42 +524,6 @@ func BenchmarkMirroredBuffer(b *testing.B) {
 		nMessages = []int{1, 2, 4, 8, 16, 32, 64, 128, 256, 512}
 	)
 
-	for _, nMessage := range nMessages {
-		nMessage := nMessage
-		bytesPerMessage := len(toCopy) / nMessage
-		b.Run(
-			fmt.Sprintf(
-				"byte_buffer_%s_%d",
-				util.ByteCountSI(int64(size)),
-				nMessage,
-			),
-			func(b *testing.B) {
-				{
-					buf := sonic.NewByteBuffer()
-					buf.Reserve(len(toCopy))
-					buf.Prefault()
-
-					b.ResetTimer()
-
-					sum := 0
-					for i := 0; i < b.N; i++ {
-						buf.Claim(func(b []byte) int {
-							return copy(b, toCopy)
-						})
-						for buf.WriteLen() > 0 {
-							buf.Commit(bytesPerMessage)
-							// here we would decode the bytes into an internal
-							// type
-							buf.Consume(bytesPerMessage)
-						}
-					}
-					b.ReportAllocs()
-					fmt.Fprint(io.Discard, sum)
-				}
-				runtime.GC()
-			})
-	}
-
 	for _, chunk := range nMessages {
 		chunk := chunk
 		consume := len(toCopy) / chunk
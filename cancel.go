@@ -0,0 +1,104 @@
+package sonic
+
+import (
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+// CancelHandle aborts a single pending asynchronous operation, leaving the other operations and the file descriptor
+// untouched. See AsyncCancellableReadWriter.
+//
+// The zero CancelHandle refers to no operation.
+type CancelHandle struct {
+	op *asyncOp
+}
+
+// Cancel aborts the operation of the handle if it is still pending: its interest in the file descriptor is
+// deregistered, or it is removed from the queue of writes, and its handler is invoked with sonicerrors.ErrCancelled
+// and the bytes transferred so far. Cancel returns true if the operation completed because of it.
+//
+// Cancel returns false if the operation already completed, or if it is about to complete, as an operation which
+// yielded to the IO loop, see SetExecutionBudget, is not waiting on the file descriptor and runs to completion.
+//
+// Cancel must be called from the goroutine running the IO.
+func (h CancelHandle) Cancel() bool {
+	op := h.op
+	if op == nil || op.done {
+		return false
+	}
+	op.cancel()
+	return op.done
+}
+
+// Pending returns true if the operation of the handle has not completed yet.
+func (h CancelHandle) Pending() bool {
+	return h.op != nil && !h.op.done
+}
+
+// asyncOp is an asynchronous operation which can be cancelled on its own, see CancelHandle.
+type asyncOp struct {
+	done   bool
+	cancel func()
+	stop   chan struct{} // closed once the operation completes, see watchContext
+}
+
+// handler returns cb wrapped such that the operation is marked as complete before cb runs.
+func (op *asyncOp) handler(cb AsyncCallback) AsyncCallback {
+	return func(err error, n int) {
+		op.done = true
+		if op.stop != nil {
+			close(op.stop)
+			op.stop = nil
+		}
+		cb(err, n)
+	}
+}
+
+func (f *file) AsyncReadCancellable(b []byte, cb AsyncCallback) CancelHandle {
+	return CancelHandle{f.readOp(b, false, cb)}
+}
+
+func (f *file) AsyncReadAllCancellable(b []byte, cb AsyncCallback) CancelHandle {
+	return CancelHandle{f.readOp(b, true, cb)}
+}
+
+func (f *file) AsyncWriteCancellable(b []byte, cb AsyncCallback) CancelHandle {
+	return CancelHandle{f.writeOp(b, false, cb)}
+}
+
+func (f *file) AsyncWriteAllCancellable(b []byte, cb AsyncCallback) CancelHandle {
+	return CancelHandle{f.writeOp(b, true, cb)}
+}
+
+func (f *file) AsyncWritevCancellable(bufs [][]byte, cb AsyncCallback) CancelHandle {
+	op := &asyncOp{}
+	op.cancel = func() { f.cancelWrite(op) }
+	f.asyncWritev(bufs, op, op.handler(cb))
+	return CancelHandle{op}
+}
+
+func (f *file) readOp(b []byte, readAll bool, cb AsyncCallback) *asyncOp {
+	// Reads are not queued, so the pending read of f is the one of op until op completes.
+	op := &asyncOp{cancel: f.cancelReads}
+	f.asyncRead(b, readAll, op.handler(cb))
+	return op
+}
+
+func (f *file) writeOp(b []byte, writeAll bool, cb AsyncCallback) *asyncOp {
+	op := &asyncOp{}
+	op.cancel = func() { f.cancelWrite(op) }
+	f.asyncWrite(b, writeAll, op, op.handler(cb))
+	return op
+}
+
+// cancelWrite cancels the write of op, which is either queued or in progress, and leaves the other writes untouched.
+func (f *file) cancelWrite(op *asyncOp) {
+	for i, w := range f.writeQueue {
+		if w.op == op {
+			f.writeQueue = append(f.writeQueue[:i], f.writeQueue[i+1:]...)
+			f.accountWrite(-w.size())
+			w.cb(sonicerrors.ErrCancelled, 0)
+			return
+		}
+	}
+	f.cancelPendingWrite()
+}
@@ -3,6 +3,8 @@ package sonic
 import (
 	"errors"
 	"fmt"
+	"io"
+	"time"
 
 	"github.com/csdenboer/sonic/internal"
 	"github.com/csdenboer/sonic/sonicerrors"
@@ -41,7 +43,9 @@ type Codec[Enc, Dec any] interface {
 }
 
 type CodecConn[Enc, Dec any] interface {
-	AsyncReadNext(func(error, Dec))
+	// AsyncReadNext reads the next message asynchronously. Implementations keep the handler and the handlers they
+	// pass to the underlying stream across calls, such that reading a message does not allocate.
+	AsyncReadNext(AsyncItemCallback[Dec])
 	ReadNext() (Dec, error)
 
 	AsyncWriteNext(Enc, AsyncCallback)
@@ -57,6 +61,56 @@ var (
 	_ CodecConn[any, any] = &NonblockingCodecConn[any, any]{}
 )
 
+// codecMemory accounts the capacity of the src and dst buffers of a codec connection, which grow as the codec decodes
+// and encodes bigger messages. The account is created under the memory account of the stream, if it has one, such as
+// a Conn, so the buffers count towards the memory of the IO. It is released once the connection is closed.
+type codecMemory struct {
+	mem      *MemoryAccount
+	src, dst *ByteBuffer
+	closed   bool
+}
+
+func newCodecMemory(stream Stream, src, dst *ByteBuffer) codecMemory {
+	var parent *MemoryAccount
+	if s, ok := stream.(interface{ MemoryAccount() *MemoryAccount }); ok {
+		parent = s.MemoryAccount()
+	}
+	m := codecMemory{mem: NewMemoryAccount(parent), src: src, dst: dst}
+	m.account()
+	return m
+}
+
+func (m *codecMemory) account() {
+	if !m.closed {
+		m.mem.Set(m.src.Cap() + m.dst.Cap())
+	}
+}
+
+func (m *codecMemory) close() {
+	m.closed = true
+	m.mem.Close()
+}
+
+// drainSrc writes the bytes buffered in src, read from the stream of a codec connection but not decoded yet, into w.
+// This hands the connection over to a raw byte stream after its last message, for example to a Splicer when proxying
+// the rest of the connection. If w is a ByteBuffer or a Splicer, the bytes are not copied through an intermediate
+// slice, see ByteBuffer.WriteTo.
+func drainSrc(src *ByteBuffer, w io.Writer) (int64, error) {
+	src.Commit(src.WriteLen())
+	return src.WriteTo(w)
+}
+
+// passDst reads the bytes of r into dst, after the encoded messages not written yet, and writes them into the stream
+// of a codec connection. If r is a ByteBuffer or a Splicer, the bytes are not copied through an intermediate slice,
+// see ByteBuffer.ReadFrom. dst is not grown: at most dst.Reserved() bytes are read from r.
+func passDst(dst *ByteBuffer, stream Stream, r io.Reader) (int64, error) {
+	if _, err := dst.ReadFrom(r); err != nil && err != io.EOF {
+		return 0, err
+	}
+	dst.Commit(dst.WriteLen())
+	return dst.WriteTo(stream)
+}
+
 // BlockingCodecConn handles the decoding/encoding of bytes funneled through a
 // provided blocking file descriptor.
 type BlockingCodecConn[Enc, Dec any] struct {
@@ -65,6 +119,16 @@ type BlockingCodecConn[Enc, Dec any] struct {
 	src    *ByteBuffer
 	dst    *ByteBuffer
 
+	run      execBudget
+	timeouts readTimeouts
+	mem      codecMemory
+
+	// The handler of the pending AsyncReadNext, and the handlers resuming it, bound once such that reads do not
+	// allocate closures.
+	readCb     AsyncItemCallback[Dec]
+	onReadFrom AsyncCallback
+	resumeRead func()
+
 	emptyEnc Enc
 	emptyDec Dec
 }
@@ -82,31 +146,41 @@ func NewBlockingCodecConn[Enc, Dec any](
 		src:    src,
 		dst:    dst,
 	}
+	c.onReadFrom = c.readFrom
+	c.resumeRead = func() { c.AsyncReadNext(c.readCb) }
+	c.mem = newCodecMemory(stream, src, dst)
 	return c, nil
 }
 
-func (c *BlockingCodecConn[Enc, Dec]) AsyncReadNext(cb func(error, Dec)) {
+func (c *BlockingCodecConn[Enc, Dec]) AsyncReadNext(cb AsyncItemCallback[Dec]) {
+	c.readCb = cb
+	if c.run.yield(c.resumeRead) {
+		return
+	}
+
 	item, err := c.codec.Decode(c.src)
+	c.mem.account()
 	if errors.Is(err, sonicerrors.ErrNeedMore) {
-		c.scheduleAsyncRead(cb)
+		c.timeouts.arm(c.src.ReadLen()+c.src.WriteLen() > 0)
+		c.src.AsyncReadFrom(c.stream, c.onReadFrom)
 	} else {
+		c.timeouts.disarm()
 		cb(err, item)
 	}
 }
 
-func (c *BlockingCodecConn[Enc, Dec]) scheduleAsyncRead(cb func(error, Dec)) {
-	c.src.AsyncReadFrom(c.stream, func(err error, _ int) {
-		if err != nil {
-			cb(err, c.emptyDec)
-		} else {
-			c.AsyncReadNext(cb)
-		}
-	})
+func (c *BlockingCodecConn[Enc, Dec]) readFrom(err error, _ int) {
+	if err != nil {
+		c.readCb(c.timeouts.readFailed(err), c.emptyDec)
+	} else {
+		c.AsyncReadNext(c.readCb)
+	}
 }
 
 func (c *BlockingCodecConn[Enc, Dec]) ReadNext() (Dec, error) {
 	for {
 		item, err := c.codec.Decode(c.src)
+		c.mem.account()
 		if err == nil {
 			return item, nil
 		}
@@ -124,6 +198,7 @@ func (c *BlockingCodecConn[Enc, Dec]) ReadNext() (Dec, error) {
 
 func (c *BlockingCodecConn[Enc, Dec]) WriteNext(item Enc) (n int, err error) {
 	err = c.codec.Encode(item, c.dst)
+	c.mem.account()
 	if err == nil {
 		var nn int64
 		nn, err = c.dst.WriteTo(c.stream)
@@ -134,6 +209,7 @@ func (c *BlockingCodecConn[Enc, Dec]) WriteNext(item Enc) (n int, err error) {
 
 func (c *BlockingCodecConn[Enc, Dec]) AsyncWriteNext(item Enc, cb AsyncCallback) {
 	err := c.codec.Encode(item, c.dst)
+	c.mem.account()
 	if err == nil {
 		c.dst.AsyncWriteTo(c.stream, cb)
 	} else {
@@ -141,11 +217,54 @@ func (c *BlockingCodecConn[Enc, Dec]) AsyncWriteNext(item Enc, cb AsyncCallback)
 	}
 }
 
+// WriteTo writes the bytes read from the stream but not decoded yet into w. See drainSrc.
+func (c *BlockingCodecConn[Enc, Dec]) WriteTo(w io.Writer) (int64, error) {
+	return drainSrc(c.src, w)
+}
+
+// ReadFrom writes the bytes of r into the stream as they are, without encoding them. See passDst.
+func (c *BlockingCodecConn[Enc, Dec]) ReadFrom(r io.Reader) (int64, error) {
+	return passDst(c.dst, c.stream, r)
+}
+
+// SetMaxMessagesPerRun bounds the number of messages delivered back-to-back by AsyncReadNext without yielding to the
+// IO loop. See execBudget.
+//
+// AsyncReadNext first decodes from the bytes already buffered in src and only reads from the stream if that is not
+// enough, so the messages brought in by a single read are delivered without any syscall or poll in between.
+func (c *BlockingCodecConn[Enc, Dec]) SetMaxMessagesPerRun(ioc *IO, n int) {
+	c.run.set(ioc, n, c.run.maxTime)
+}
+
+// SetExecutionBudget bounds the messages delivered back-to-back by AsyncReadNext to maxMessages, and the time spent
+// delivering them to maxTime, after which AsyncReadNext yields to the IO loop. 0 means no bound. See execBudget.
+func (c *BlockingCodecConn[Enc, Dec]) SetExecutionBudget(ioc *IO, maxMessages int, maxTime time.Duration) {
+	c.run.set(ioc, maxMessages, maxTime)
+}
+
+// SetReadTimeouts bounds the time AsyncReadNext waits for the first byte of the next message to idle, and the time
+// it waits for the rest of a started message to stall. A timeout <= 0 is disabled. See readTimeouts.
+//
+// An expired timeout cancels all asynchronous operations on the stream, including writes, and completes the read
+// with sonicerrors.ErrIdleTimeout or sonicerrors.ErrStallTimeout. ReadNext is not bounded by the timeouts.
+func (c *BlockingCodecConn[Enc, Dec]) SetReadTimeouts(ioc *IO, idle, stall time.Duration) error {
+	return c.timeouts.set(ioc, c.stream, idle, stall)
+}
+
+// MemoryAccount returns the memory account of the connection, which holds the capacity of its src and dst buffers. It
+// is under the memory account of the underlying stream, if the stream has one, and is released once the connection is
+// closed.
+func (c *BlockingCodecConn[Enc, Dec]) MemoryAccount() *MemoryAccount {
+	return c.mem.mem
+}
+
 func (c *BlockingCodecConn[Enc, Dec]) NextLayer() Stream {
 	return c.stream
 }
 
 func (c *BlockingCodecConn[Enc, Dec]) Close() error {
+	c.timeouts.close()
+	c.mem.close()
 	return c.stream.Close()
 }
 
@@ -155,7 +274,15 @@ type NonblockingCodecConn[Enc, Dec any] struct {
 	src    *ByteBuffer
 	dst    *ByteBuffer
 
-	dispatched int
+	run      execBudget
+	timeouts readTimeouts
+	mem      codecMemory
+
+	// The handler of the pending AsyncReadNext, and the handlers resuming it, bound once such that reads do not
+	// allocate closures.
+	readCb     AsyncItemCallback[Dec]
+	onReadFrom AsyncCallback
+	resumeRead func()
 
 	emptyEnc Enc
 	emptyDec Dec
@@ -180,27 +307,41 @@ func NewNonblockingCodecConn[Enc, Dec any](
 		src:    src,
 		dst:    dst,
 	}
+	c.onReadFrom = c.readFrom
+	c.resumeRead = func() { c.AsyncReadNext(c.readCb) }
+	c.mem = newCodecMemory(stream, src, dst)
 	return c, nil
 }
 
-func (c *NonblockingCodecConn[Enc, Dec]) AsyncReadNext(cb func(error, Dec)) {
+func (c *NonblockingCodecConn[Enc, Dec]) AsyncReadNext(cb AsyncItemCallback[Dec]) {
+	c.readCb = cb
+	if c.run.yield(c.resumeRead) {
+		return
+	}
+
 	item, err := c.codec.Decode(c.src)
+	c.mem.account()
 	if errors.Is(err, sonicerrors.ErrNeedMore) {
-		c.src.AsyncReadFrom(c.stream, func(err error, _ int) {
-			if err != nil {
-				cb(err, c.emptyDec)
-			} else {
-				c.AsyncReadNext(cb)
-			}
-		})
+		c.timeouts.arm(c.src.ReadLen()+c.src.WriteLen() > 0)
+		c.src.AsyncReadFrom(c.stream, c.onReadFrom)
 	} else {
+		c.timeouts.disarm()
 		cb(err, item)
 	}
 }
 
+func (c *NonblockingCodecConn[Enc, Dec]) readFrom(err error, _ int) {
+	if err != nil {
+		c.readCb(c.timeouts.readFailed(err), c.emptyDec)
+	} else {
+		c.AsyncReadNext(c.readCb)
+	}
+}
+
 func (c *NonblockingCodecConn[Enc, Dec]) ReadNext() (Dec, error) {
 	for {
 		item, err := c.codec.Decode(c.src)
+		c.mem.account()
 		if err == nil {
 			return item, nil
 		}
@@ -217,7 +358,9 @@ func (c *NonblockingCodecConn[Enc, Dec]) ReadNext() (Dec, error) {
 }
 
 func (c *NonblockingCodecConn[Enc, Dec]) AsyncWriteNext(item Enc, cb AsyncCallback) {
-	if err := c.codec.Encode(item, c.dst); err != nil {
+	err := c.codec.Encode(item, c.dst)
+	c.mem.account()
+	if err != nil {
 		cb(err, 0)
 		return
 	}
@@ -228,6 +371,7 @@ func (c *NonblockingCodecConn[Enc, Dec]) AsyncWriteNext(item Enc, cb AsyncCallba
 
 func (c *NonblockingCodecConn[Enc, Dec]) WriteNext(item Enc) (n int, err error) {
 	err = c.codec.Encode(item, c.dst)
+	c.mem.account()
 	if err == nil {
 		var nn int64
 		nn, err = c.dst.WriteTo(c.stream)
@@ -236,10 +380,53 @@ func (c *NonblockingCodecConn[Enc, Dec]) WriteNext(item Enc) (n int, err error)
 	return
 }
 
+// WriteTo writes the bytes read from the stream but not decoded yet into w. See drainSrc.
+func (c *NonblockingCodecConn[Enc, Dec]) WriteTo(w io.Writer) (int64, error) {
+	return drainSrc(c.src, w)
+}
+
+// ReadFrom writes the bytes of r into the stream as they are, without encoding them. See passDst.
+func (c *NonblockingCodecConn[Enc, Dec]) ReadFrom(r io.Reader) (int64, error) {
+	return passDst(c.dst, c.stream, r)
+}
+
+// SetMaxMessagesPerRun bounds the number of messages delivered back-to-back by AsyncReadNext without yielding to the
+// IO loop. See execBudget.
+//
+// AsyncReadNext first decodes from the bytes already buffered in src and only reads from the stream if that is not
+// enough, so the messages brought in by a single read are delivered without any syscall or poll in between.
+func (c *NonblockingCodecConn[Enc, Dec]) SetMaxMessagesPerRun(ioc *IO, n int) {
+	c.run.set(ioc, n, c.run.maxTime)
+}
+
+// SetExecutionBudget bounds the messages delivered back-to-back by AsyncReadNext to maxMessages, and the time spent
+// delivering them to maxTime, after which AsyncReadNext yields to the IO loop. 0 means no bound. See execBudget.
+func (c *NonblockingCodecConn[Enc, Dec]) SetExecutionBudget(ioc *IO, maxMessages int, maxTime time.Duration) {
+	c.run.set(ioc, maxMessages, maxTime)
+}
+
+// SetReadTimeouts bounds the time AsyncReadNext waits for the first byte of the next message to idle, and the time
+// it waits for the rest of a started message to stall. A timeout <= 0 is disabled. See readTimeouts.
+//
+// An expired timeout cancels all asynchronous operations on the stream, including writes, and completes the read
+// with sonicerrors.ErrIdleTimeout or sonicerrors.ErrStallTimeout. ReadNext is not bounded by the timeouts.
+func (c *NonblockingCodecConn[Enc, Dec]) SetReadTimeouts(ioc *IO, idle, stall time.Duration) error {
+	return c.timeouts.set(ioc, c.stream, idle, stall)
+}
+
+// MemoryAccount returns the memory account of the connection, which holds the capacity of its src and dst buffers. It
+// is under the memory account of the underlying stream, if the stream has one, and is released once the connection is
+// closed.
+func (c *NonblockingCodecConn[Enc, Dec]) MemoryAccount() *MemoryAccount {
+	return c.mem.mem
+}
+
 func (c *NonblockingCodecConn[Enc, Dec]) NextLayer() Stream {
 	return c.stream
 }
 
 func (c *NonblockingCodecConn[Enc, Dec]) Close() error {
+	c.timeouts.close()
+	c.mem.close()
 	return c.stream.Close()
 }
@@ -0,0 +1,88 @@
+package checksum
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+
+	"github.com/csdenboer/sonic"
+)
+
+var (
+	_ sonic.Codec[[]byte, []byte] = &Codec{}
+
+	ErrChecksumMismatch = errors.New("frame checksum mismatch")
+	ErrFrameTooShort    = errors.New("frame too short to hold a checksum")
+)
+
+const TrailerLen = 4 // bytes
+
+// castagnoli is the CRC32C table. hash/crc32 computes CRC32C with the SSE4.2
+// instructions on amd64 and the CRC32 instructions on arm64 where available.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Checksum returns the CRC32C of b, for protocols which carry it in their own
+// integrity fields.
+func Checksum(b []byte) uint32 {
+	return crc32.Checksum(b, castagnoli)
+}
+
+// MismatchHandler is invoked with a frame whose checksum does not match its
+// payload, with the checksum the frame carries and the one computed over the
+// payload. If it returns nil, the frame is dropped and decoding carries on with
+// the next frame. Otherwise, Decode returns the error.
+type MismatchHandler func(payload []byte, carried, computed uint32) error
+
+// Codec appends a CRC32C of each frame's payload to the frame and validates it
+// when the frame is decoded. It wraps a codec of framed messages, such as
+// frame.Codec, which frames the payload along with its checksum.
+//
+// The checksum is a big endian uint32 trailing the payload. Decode strips it,
+// so the inner codec's framing and the checksum are transparent to the caller.
+type Codec struct {
+	inner      sonic.Codec[[]byte, []byte]
+	onMismatch MismatchHandler
+
+	scratch []byte
+}
+
+// NewCodec returns a Codec checksumming the frames of inner. If onMismatch is
+// nil, Decode returns ErrChecksumMismatch on a mismatch.
+func NewCodec(inner sonic.Codec[[]byte, []byte], onMismatch MismatchHandler) *Codec {
+	return &Codec{
+		inner:      inner,
+		onMismatch: onMismatch,
+	}
+}
+
+func (c *Codec) Encode(payload []byte, dst *sonic.ByteBuffer) error {
+	c.scratch = append(c.scratch[:0], payload...)
+	c.scratch = binary.BigEndian.AppendUint32(c.scratch, Checksum(payload))
+	return c.inner.Encode(c.scratch, dst)
+}
+
+func (c *Codec) Decode(src *sonic.ByteBuffer) ([]byte, error) {
+	for {
+		b, err := c.inner.Decode(src)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) < TrailerLen {
+			return nil, ErrFrameTooShort
+		}
+
+		payload := b[:len(b)-TrailerLen]
+		carried := binary.BigEndian.Uint32(b[len(b)-TrailerLen:])
+		computed := Checksum(payload)
+		if carried == computed {
+			return payload, nil
+		}
+
+		if c.onMismatch == nil {
+			return nil, ErrChecksumMismatch
+		}
+		if err := c.onMismatch(payload, carried, computed); err != nil {
+			return nil, err
+		}
+	}
+}
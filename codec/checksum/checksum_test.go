@@ -0,0 +1,110 @@
+package checksum
+
+import (
+	"errors"
+	"hash/crc32"
+	"testing"
+
+	"github.com/csdenboer/sonic"
+	"github.com/csdenboer/sonic/codec/frame"
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+func TestChecksumIsCRC32C(t *testing.T) {
+	// The check value of CRC32C.
+	if sum := Checksum([]byte("123456789")); sum != 0xe3069283 {
+		t.Fatalf("wrong checksum %#x", sum)
+	}
+	if Checksum([]byte("sonic")) != crc32.Checksum([]byte("sonic"), crc32.MakeTable(crc32.Castagnoli)) {
+		t.Fatal("wrong checksum")
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	buf := sonic.NewByteBuffer()
+	codec := NewCodec(frame.NewCodec(buf), nil)
+
+	for _, m := range []string{"hello", "", "world"} {
+		if err := codec.Encode([]byte(m), buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf.Commit(buf.WriteLen())
+
+	for _, m := range []string{"hello", "", "world"} {
+		b, err := codec.Decode(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != m {
+			t.Fatalf("expected %q got=%q", m, b)
+		}
+	}
+
+	if _, err := codec.Decode(buf); !errors.Is(err, sonicerrors.ErrNeedMore) {
+		t.Fatalf("expected ErrNeedMore got=%v", err)
+	}
+}
+
+func TestCodecMismatch(t *testing.T) {
+	encode := func(buf *sonic.ByteBuffer, messages ...string) {
+		codec := NewCodec(frame.NewCodec(buf), nil)
+		for _, m := range messages {
+			if err := codec.Encode([]byte(m), buf); err != nil {
+				t.Fatal(err)
+			}
+		}
+		buf.Commit(buf.WriteLen())
+
+		// Corrupt the first payload byte of the first frame.
+		buf.Data()[frame.HeaderLen] ^= 0xff
+	}
+
+	// Without a handler, the mismatch is an error.
+	buf := sonic.NewByteBuffer()
+	encode(buf, "hello")
+	codec := NewCodec(frame.NewCodec(buf), nil)
+	if _, err := codec.Decode(buf); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch got=%v", err)
+	}
+
+	// A handler returning nil drops the frame.
+	buf = sonic.NewByteBuffer()
+	encode(buf, "hello", "world")
+	var dropped []string
+	codec = NewCodec(frame.NewCodec(buf), func(payload []byte, carried, computed uint32) error {
+		if carried == computed || computed != Checksum(payload) {
+			t.Fatal("wrong checksums given to the handler")
+		}
+		dropped = append(dropped, string(payload))
+		return nil
+	})
+	b, err := codec.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "world" {
+		t.Fatalf("expected the corrupt frame to be dropped got=%q", b)
+	}
+	if len(dropped) != 1 {
+		t.Fatalf("expected one dropped frame got=%d", len(dropped))
+	}
+
+	// A handler returning an error stops decoding.
+	buf = sonic.NewByteBuffer()
+	encode(buf, "hello")
+	stop := errors.New("stop")
+	codec = NewCodec(frame.NewCodec(buf), func([]byte, uint32, uint32) error { return stop })
+	if _, err := codec.Decode(buf); err != stop {
+		t.Fatalf("expected the handler's error got=%v", err)
+	}
+}
+
+func BenchmarkChecksum(b *testing.B) {
+	payload := make([]byte, 4096)
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = Checksum(payload)
+	}
+}
@@ -0,0 +1,211 @@
+package compress
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/csdenboer/sonic"
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+var (
+	_ sonic.Codec[[]byte, []byte] = &Codec[[]byte, []byte]{}
+
+	ErrBlockTooBig  = errors.New("compressed block too big")
+	ErrCorruptBlock = errors.New("corrupt compressed block")
+)
+
+const (
+	// HeaderLen is the length of the header of a block: the length of the
+	// block's body, with the most significant bit set if the body is
+	// compressed, followed by the length of the decompressed body. Both are
+	// big endian uint32s.
+	HeaderLen = 8 // bytes
+
+	// MaxBlockSize bounds both the body of a block and its decompressed length.
+	MaxBlockSize = 1024 * 1024 // 1MB
+
+	// DefaultBlockSize is the default maximum number of encoded bytes
+	// compressed into a single block.
+	DefaultBlockSize = 64 * 1024
+
+	// MinCompressSize is the number of bytes under which a block is sent
+	// uncompressed, as compressing it is unlikely to pay off.
+	MinCompressSize = 64
+
+	compressedBit = 1 << 31
+)
+
+// Compressor compresses and decompresses the bodies of blocks. Block formats,
+// such as the ones of LZ4 and Snappy, can be plugged in through it.
+type Compressor interface {
+	// Compress appends the compressed src to dst and returns the extended
+	// slice.
+	Compress(dst, src []byte) ([]byte, error)
+
+	// Decompress decompresses src into dst. The length of dst is the length
+	// src had before it was compressed.
+	Decompress(dst, src []byte) error
+}
+
+// Codec compresses the bytes encoded by an inner codec and decompresses the
+// bytes the inner codec decodes. It sits between the transport and the inner
+// codec, which is unaware of it.
+//
+// The encoded bytes of each item are compressed into one or more blocks of at
+// most DefaultBlockSize bytes, see SetBlockSize. Blocks that are small or do
+// not compress are sent as they are. Both peers must use the same Compressor;
+// agreeing on it is left to the application.
+//
+// Compression trades CPU for bandwidth, and pays off on links where bandwidth
+// is the bottleneck and the items are big or redundant enough to compress.
+type Codec[Enc, Dec any] struct {
+	inner      sonic.Codec[Enc, Dec]
+	compressor Compressor
+
+	// The bytes encoded by the inner codec, before compression.
+	encoded *sonic.ByteBuffer
+
+	// The decompressed bytes the inner codec decodes from.
+	plain *sonic.ByteBuffer
+
+	blockSize  int
+	compressed []byte
+
+	emptyDec Dec
+}
+
+// NewCodec returns a Codec which compresses inner with compressor.
+//
+// plain is the buffer inner decodes from. Inner codecs which keep a reference
+// to the buffer they decode from, such as frame.Codec, must be created with
+// plain.
+func NewCodec[Enc, Dec any](
+	inner sonic.Codec[Enc, Dec],
+	plain *sonic.ByteBuffer,
+	compressor Compressor,
+) *Codec[Enc, Dec] {
+	return &Codec[Enc, Dec]{
+		inner:      inner,
+		compressor: compressor,
+		encoded:    sonic.NewByteBuffer(),
+		plain:      plain,
+		blockSize:  DefaultBlockSize,
+	}
+}
+
+// SetBlockSize sets the maximum number of encoded bytes compressed into a
+// single block. Bigger blocks compress better but must be received in full
+// before any of their bytes can be decoded.
+func (c *Codec[Enc, Dec]) SetBlockSize(n int) error {
+	if n <= 0 || n > MaxBlockSize {
+		return fmt.Errorf("block size must be in (0, %d]", MaxBlockSize)
+	}
+	c.blockSize = n
+	return nil
+}
+
+func (c *Codec[Enc, Dec]) Encode(item Enc, dst *sonic.ByteBuffer) error {
+	if err := c.inner.Encode(item, c.encoded); err != nil {
+		return err
+	}
+	c.encoded.Commit(c.encoded.WriteLen())
+
+	for c.encoded.ReadLen() > 0 {
+		b := c.encoded.Data()
+		if len(b) > c.blockSize {
+			b = b[:c.blockSize]
+		}
+		if err := c.encodeBlock(b, dst); err != nil {
+			c.encoded.Consume(c.encoded.ReadLen())
+			return err
+		}
+		c.encoded.Consume(len(b))
+	}
+	return nil
+}
+
+func (c *Codec[Enc, Dec]) encodeBlock(b []byte, dst *sonic.ByteBuffer) error {
+	body, header := b, uint32(len(b))
+
+	if len(b) >= MinCompressSize {
+		compressed, err := c.compressor.Compress(c.compressed[:0], b)
+		if err != nil {
+			return err
+		}
+		c.compressed = compressed
+
+		if len(compressed) < len(b) {
+			body, header = compressed, uint32(len(compressed))|compressedBit
+		}
+	}
+
+	dst.Reserve(HeaderLen + len(body))
+	dst.Claim(func(into []byte) int {
+		binary.BigEndian.PutUint32(into[:4], header)
+		binary.BigEndian.PutUint32(into[4:HeaderLen], uint32(len(b)))
+		copy(into[HeaderLen:], body)
+		return HeaderLen + len(body)
+	})
+	dst.Commit(HeaderLen + len(body))
+
+	return nil
+}
+
+func (c *Codec[Enc, Dec]) Decode(src *sonic.ByteBuffer) (Dec, error) {
+	for {
+		item, err := c.inner.Decode(c.plain)
+		if !errors.Is(err, sonicerrors.ErrNeedMore) {
+			return item, err
+		}
+
+		if err := c.decodeBlock(src); err != nil {
+			return c.emptyDec, err
+		}
+	}
+}
+
+// decodeBlock decompresses the next block of src into the write area of the
+// plain buffer.
+func (c *Codec[Enc, Dec]) decodeBlock(src *sonic.ByteBuffer) error {
+	if err := src.PrepareRead(HeaderLen); err != nil {
+		return err
+	}
+
+	header := binary.BigEndian.Uint32(src.Data()[:4])
+	bodyLen := int(header &^ compressedBit)
+	plainLen := int(binary.BigEndian.Uint32(src.Data()[4:HeaderLen]))
+	if bodyLen > MaxBlockSize || plainLen > MaxBlockSize {
+		return ErrBlockTooBig
+	}
+	compressed := header&compressedBit != 0
+	if !compressed && bodyLen != plainLen {
+		return ErrCorruptBlock
+	}
+
+	if err := src.PrepareRead(HeaderLen + bodyLen); err != nil {
+		if err == sonicerrors.ErrNeedMore {
+			src.Reserve(HeaderLen + bodyLen)
+		}
+		return err
+	}
+	body := src.Data()[HeaderLen : HeaderLen+bodyLen]
+
+	var err error
+	c.plain.Reserve(plainLen)
+	c.plain.Claim(func(into []byte) int {
+		if compressed {
+			if err = c.compressor.Decompress(into[:plainLen], body); err != nil {
+				err = fmt.Errorf("%w: %v", ErrCorruptBlock, err)
+				return 0
+			}
+		} else {
+			copy(into, body)
+		}
+		return plainLen
+	})
+	src.Consume(HeaderLen + bodyLen)
+
+	return err
+}
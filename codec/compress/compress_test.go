@@ -0,0 +1,201 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic"
+	"github.com/csdenboer/sonic/codec/frame"
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+func newFrameCodec(t *testing.T) *Codec[[]byte, []byte] {
+	f, err := NewFlate(flate.BestSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := sonic.NewByteBuffer()
+	return NewCodec[[]byte, []byte](frame.NewCodec(plain), plain, f)
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	enc, dec := newFrameCodec(t), newFrameCodec(t)
+
+	random := make([]byte, 1000)
+	rand.Read(random)
+
+	messages := [][]byte{
+		[]byte("small"),
+		bytes.Repeat([]byte("compressible "), 100),
+		random,
+		bytes.Repeat([]byte("a"), 3*DefaultBlockSize+10),
+		{},
+	}
+
+	wire := sonic.NewByteBuffer()
+	encoded := 0
+	for _, m := range messages {
+		if err := enc.Encode(m, wire); err != nil {
+			t.Fatal(err)
+		}
+		encoded += frame.HeaderLen + len(m)
+	}
+	if wire.ReadLen() >= encoded {
+		t.Fatalf("expected the messages to be compressed, %d bytes on the wire for %d encoded", wire.ReadLen(), encoded)
+	}
+
+	// Hand the wire bytes to the decoder one chunk at a time, as a transport
+	// would.
+	src := sonic.NewByteBuffer()
+	var decoded [][]byte
+	for wire.ReadLen() > 0 {
+		n := 7
+		if n > wire.ReadLen() {
+			n = wire.ReadLen()
+		}
+		src.Write(wire.Data()[:n])
+		wire.Consume(n)
+
+		for {
+			m, err := dec.Decode(src)
+			if errors.Is(err, sonicerrors.ErrNeedMore) {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded = append(decoded, append([]byte{}, m...))
+		}
+	}
+
+	if len(decoded) != len(messages) {
+		t.Fatalf("expected %d messages got=%d", len(messages), len(decoded))
+	}
+	for i := range messages {
+		if !bytes.Equal(decoded[i], messages[i]) {
+			t.Fatalf("message %d was not decoded as it was encoded", i)
+		}
+	}
+}
+
+func TestCodecIncompressibleBlocksAreSentAsIs(t *testing.T) {
+	enc := newFrameCodec(t)
+
+	random := make([]byte, 1000)
+	rand.Read(random)
+
+	wire := sonic.NewByteBuffer()
+	if err := enc.Encode(random, wire); err != nil {
+		t.Fatal(err)
+	}
+
+	header := binary.BigEndian.Uint32(wire.Data()[:4])
+	if header&compressedBit != 0 {
+		t.Fatal("expected an incompressible block to be sent uncompressed")
+	}
+	if int(header) != frame.HeaderLen+len(random) {
+		t.Fatalf("wrong block length %d", header)
+	}
+}
+
+func TestCodecRejectsBadBlocks(t *testing.T) {
+	dec := newFrameCodec(t)
+
+	src := sonic.NewByteBuffer()
+	var header [HeaderLen]byte
+	binary.BigEndian.PutUint32(header[:4], compressedBit|10)
+	binary.BigEndian.PutUint32(header[4:], MaxBlockSize+1)
+	src.Write(header[:])
+	if _, err := dec.Decode(src); !errors.Is(err, ErrBlockTooBig) {
+		t.Fatalf("expected ErrBlockTooBig got=%v", err)
+	}
+
+	dec = newFrameCodec(t)
+	src = sonic.NewByteBuffer()
+	binary.BigEndian.PutUint32(header[:4], compressedBit|4)
+	binary.BigEndian.PutUint32(header[4:], 100)
+	src.Write(header[:])
+	src.Write([]byte{1, 2, 3, 4})
+	if _, err := dec.Decode(src); !errors.Is(err, ErrCorruptBlock) {
+		t.Fatalf("expected ErrCorruptBlock got=%v", err)
+	}
+}
+
+func TestCodecConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	message := bytes.Repeat([]byte("hello sonic "), 1000)
+
+	// The peer echoes the compressed bytes back.
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		b := make([]byte, 4096)
+		for {
+			n, err := conn.Read(b)
+			if err != nil {
+				return
+			}
+			if _, err := conn.Write(b[:n]); err != nil {
+				return
+			}
+		}
+	}()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	conn, err := sonic.Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	codecConn, err := sonic.NewNonblockingCodecConn[[]byte, []byte](
+		conn, newFrameCodec(t), sonic.NewByteBuffer(), sonic.NewByteBuffer())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written := 0
+	codecConn.AsyncWriteNext(message, func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		written = n
+	})
+
+	var echoed []byte
+	codecConn.AsyncReadNext(func(err error, m []byte) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		echoed = append([]byte{}, m...)
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for echoed == nil && time.Now().Before(deadline) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+
+	if !bytes.Equal(echoed, message) {
+		t.Fatal("the echoed message differs from the written one")
+	}
+	if written == 0 || written >= len(message) {
+		t.Fatalf("expected the message to be compressed, wrote %d bytes for %d", written, len(message))
+	}
+}
@@ -0,0 +1,67 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+)
+
+var _ Compressor = &Flate{}
+
+// Flate compresses blocks with DEFLATE, from the standard library.
+type Flate struct {
+	w   *flate.Writer
+	r   io.ReadCloser
+	src bytes.Reader
+	dst appender
+	one [1]byte
+}
+
+// NewFlate returns a Flate compressing at the given level, as defined by
+// compress/flate. flate.BestSpeed suits links that must keep up with a high
+// message rate.
+func NewFlate(level int) (*Flate, error) {
+	f := &Flate{}
+	w, err := flate.NewWriter(&f.dst, level)
+	if err != nil {
+		return nil, err
+	}
+	f.w = w
+	f.r = flate.NewReader(&f.src)
+	return f, nil
+}
+
+func (f *Flate) Compress(dst, src []byte) ([]byte, error) {
+	f.dst = dst
+	f.w.Reset(&f.dst)
+	if _, err := f.w.Write(src); err != nil {
+		return dst, err
+	}
+	if err := f.w.Close(); err != nil {
+		return dst, err
+	}
+	return f.dst, nil
+}
+
+func (f *Flate) Decompress(dst, src []byte) error {
+	f.src.Reset(src)
+	if err := f.r.(flate.Resetter).Reset(&f.src, nil); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(f.r, dst); err != nil {
+		return err
+	}
+	// The stream must end where the block does.
+	if n, _ := f.r.Read(f.one[:]); n != 0 {
+		return io.ErrShortBuffer
+	}
+	return nil
+}
+
+// appender is an io.Writer appending to a slice.
+type appender []byte
+
+func (a *appender) Write(b []byte) (int, error) {
+	*a = append(*a, b...)
+	return len(b), nil
+}
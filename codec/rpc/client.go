@@ -0,0 +1,223 @@
+package rpc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/csdenboer/sonic"
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+var ErrClientClosed = errors.New("rpc client closed")
+
+// CallCallback is invoked when the response to a call is received, when the call times out or when the client fails.
+//
+// The response is only valid for the duration of the callback. Callers must copy it if they want to retain it.
+type CallCallback func(err error, response []byte)
+
+type call struct {
+	id    uint64
+	cb    CallCallback
+	timer *sonic.Timer
+}
+
+// Client matches the responses read from a codec stream to the requests written to it through correlation IDs.
+//
+// Each request is assigned an ID, which the peer must echo back in the response. Each request has a timeout,
+// enforced with a timer on the IO loop. Responses with an unknown ID, such as the responses of timed out requests,
+// are discarded.
+//
+// A Client must only be used from the goroutine running the IO.
+type Client struct {
+	ioc  *sonic.IO
+	conn sonic.CodecConn[*Message, *Message]
+
+	nextID  uint64
+	pending map[uint64]*call
+
+	// Writes are serialized since the codec stream encodes into a single buffer.
+	writes  []*Message
+	writing bool
+
+	reading bool
+	closed  bool
+
+	// Timers are reused across calls since creating one is a system call on some platforms.
+	timers []*sonic.Timer
+}
+
+// NewClient creates a Client which makes calls over the given codec stream.
+func NewClient(ioc *sonic.IO, conn sonic.CodecConn[*Message, *Message]) *Client {
+	return &Client{
+		ioc:     ioc,
+		conn:    conn,
+		nextID:  1,
+		pending: make(map[uint64]*call),
+	}
+}
+
+// AsyncCall sends the payload as a request and invokes cb once the response is received or, with
+// sonicerrors.ErrTimeout, once the timeout expires.
+//
+// The payload is copied, so callers may reuse it once AsyncCall returns.
+func (c *Client) AsyncCall(payload []byte, timeout time.Duration, cb CallCallback) {
+	if c.closed {
+		cb(ErrClientClosed, nil)
+		return
+	}
+
+	timer, err := c.acquireTimer()
+	if err != nil {
+		cb(err, nil)
+		return
+	}
+
+	cl := &call{
+		id:    c.nextID,
+		cb:    cb,
+		timer: timer,
+	}
+	c.nextID++
+
+	if err := timer.ScheduleOnce(timeout, func() { c.onTimeout(cl) }); err != nil {
+		c.releaseTimer(timer)
+		cb(err, nil)
+		return
+	}
+	c.pending[cl.id] = cl
+
+	c.writes = append(c.writes, &Message{
+		ID:      cl.id,
+		Payload: append([]byte(nil), payload...),
+	})
+	c.flush()
+
+	if !c.reading {
+		c.reading = true
+		c.conn.AsyncReadNext(c.onRead)
+	}
+}
+
+// Call is the synchronous counterpart of AsyncCall: it blocks the calling goroutine, which must be the one running the
+// IO, until the response is received or the timeout expires. The IO is run in the meantime, so the responses to other
+// calls are dispatched while waiting.
+//
+// The returned response is a copy which the caller owns. sonicerrors.ErrReentrantWait is returned, without sending
+// the request, if Call is called from a handler of the IO.
+func (c *Client) Call(payload []byte, timeout time.Duration) ([]byte, error) {
+	if c.ioc.Dispatching() {
+		return nil, sonicerrors.ErrReentrantWait
+	}
+
+	var (
+		done     bool
+		response []byte
+		err      error
+	)
+	c.AsyncCall(payload, timeout, func(callErr error, b []byte) {
+		done, err = true, callErr
+		if callErr == nil {
+			response = append([]byte{}, b...)
+		}
+	})
+
+	if runErr := c.ioc.RunUntil(func() bool { return done }); runErr != nil {
+		return nil, runErr
+	}
+	return response, err
+}
+
+// Pending returns the number of calls waiting for a response.
+func (c *Client) Pending() int {
+	return len(c.pending)
+}
+
+func (c *Client) flush() {
+	if c.writing || len(c.writes) == 0 {
+		return
+	}
+
+	m := c.writes[0]
+	c.writes = c.writes[1:]
+
+	c.writing = true
+	c.conn.AsyncWriteNext(m, func(err error, _ int) {
+		c.writing = false
+		if err != nil {
+			c.fail(err)
+		} else {
+			c.flush()
+		}
+	})
+}
+
+func (c *Client) onRead(err error, m *Message) {
+	if err != nil {
+		c.reading = false
+		c.fail(err)
+		return
+	}
+
+	if cl, ok := c.pending[m.ID]; ok {
+		delete(c.pending, m.ID)
+		_ = cl.timer.Cancel()
+		c.releaseTimer(cl.timer)
+		cl.cb(nil, m.Payload)
+	}
+
+	if !c.closed {
+		c.conn.AsyncReadNext(c.onRead)
+	}
+}
+
+func (c *Client) onTimeout(cl *call) {
+	if _, ok := c.pending[cl.id]; ok {
+		delete(c.pending, cl.id)
+		c.releaseTimer(cl.timer)
+		cl.cb(sonicerrors.ErrTimeout, nil)
+	}
+}
+
+// fail completes all pending calls with the given error.
+func (c *Client) fail(err error) {
+	c.writes = c.writes[:0]
+	for id, cl := range c.pending {
+		delete(c.pending, id)
+		_ = cl.timer.Cancel()
+		c.releaseTimer(cl.timer)
+		cl.cb(err, nil)
+	}
+}
+
+func (c *Client) acquireTimer() (*sonic.Timer, error) {
+	if n := len(c.timers); n > 0 {
+		t := c.timers[n-1]
+		c.timers = c.timers[:n-1]
+		return t, nil
+	}
+	return sonic.NewTimer(c.ioc)
+}
+
+func (c *Client) releaseTimer(t *sonic.Timer) {
+	if c.closed {
+		_ = t.Close()
+	} else {
+		c.timers = append(c.timers, t)
+	}
+}
+
+// Close completes all pending calls with ErrClientClosed and closes the underlying codec stream.
+func (c *Client) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.fail(ErrClientClosed)
+	c.closed = true
+
+	for _, t := range c.timers {
+		_ = t.Close()
+	}
+	c.timers = nil
+
+	return c.conn.Close()
+}
@@ -0,0 +1,265 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic"
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+// runServer echoes back every request, in reverse order of arrival for every pair of requests, and never replies to
+// requests whose payload is "drop".
+func runServer(t *testing.T) (addr string, closeServer func()) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var held []byte
+		for {
+			header := make([]byte, HeaderLen)
+			if _, err := io.ReadFull(conn, header); err != nil {
+				return
+			}
+			length := binary.BigEndian.Uint32(header[:LengthLen])
+			payload := make([]byte, int(length)-IDLen)
+			if _, err := io.ReadFull(conn, payload); err != nil {
+				return
+			}
+			if string(payload) == "drop" {
+				continue
+			}
+
+			msg := append(header, payload...)
+			if held == nil {
+				held = msg
+			} else {
+				_, _ = conn.Write(msg)
+				_, _ = conn.Write(held)
+				held = nil
+			}
+		}
+	}()
+
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func newClient(t *testing.T, ioc *sonic.IO, addr string) *Client {
+	conn, err := sonic.Dial(ioc, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, dst := sonic.NewByteBuffer(), sonic.NewByteBuffer()
+	cc, err := sonic.NewNonblockingCodecConn[*Message, *Message](conn, NewCodec(src), src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewClient(ioc, cc)
+}
+
+func TestCodecEncodeDecode(t *testing.T) {
+	src := sonic.NewByteBuffer()
+	codec := NewCodec(src)
+
+	if err := codec.Encode(&Message{ID: 42, Payload: []byte("hello")}, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := codec.Encode(&Message{ID: 43, Payload: nil}, src); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := codec.Decode(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != 42 || !bytes.Equal(m.Payload, []byte("hello")) {
+		t.Fatalf("invalid message id=%d payload=%s", m.ID, m.Payload)
+	}
+
+	m, err = codec.Decode(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != 43 || len(m.Payload) != 0 {
+		t.Fatalf("invalid message id=%d payload=%s", m.ID, m.Payload)
+	}
+
+	if _, err := codec.Decode(src); err != sonicerrors.ErrNeedMore {
+		t.Fatalf("expected ErrNeedMore got=%v", err)
+	}
+}
+
+func TestCodecMaxFrameSize(t *testing.T) {
+	src := sonic.NewByteBuffer()
+	codec := NewCodec(src)
+	codec.SetMaxFrameSize(HeaderLen + 4)
+
+	if err := codec.Encode(&Message{ID: 1, Payload: []byte("hello")}, src); err != ErrFrameTooBig {
+		t.Fatalf("expected ErrFrameTooBig got=%v", err)
+	}
+
+	// A header announcing a frame larger than the maximum is rejected before anything is reserved for it.
+	header := make([]byte, HeaderLen)
+	binary.BigEndian.PutUint32(header[:LengthLen], IDLen+MaxPayloadLength)
+	src.Write(header)
+	capacity := src.Cap()
+	if _, err := codec.Decode(src); err != ErrFrameTooBig {
+		t.Fatalf("expected ErrFrameTooBig got=%v", err)
+	}
+	if src.Cap() != capacity {
+		t.Fatalf("expected nothing to be reserved got capacity=%d", src.Cap())
+	}
+}
+
+func TestClientMatchesOutOfOrderResponses(t *testing.T) {
+	addr, closeServer := runServer(t)
+	defer closeServer()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	client := newClient(t, ioc, addr)
+	defer client.Close()
+
+	got := make(map[string]string)
+	for _, req := range []string{"a", "b", "c", "d"} {
+		req := req
+		client.AsyncCall([]byte(req), time.Second, func(err error, res []byte) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			got[req] = string(res)
+		})
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && client.Pending() > 0 {
+		_, _ = ioc.PollOne()
+	}
+
+	if len(got) != 4 {
+		t.Fatalf("expected 4 responses got=%d", len(got))
+	}
+	for req, res := range got {
+		if req != res {
+			t.Fatalf("request=%s matched with response=%s", req, res)
+		}
+	}
+}
+
+func TestClientCall(t *testing.T) {
+	addr, closeServer := runServer(t)
+	defer closeServer()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	client := newClient(t, ioc, addr)
+	defer client.Close()
+
+	// The server replies to pairs of requests, so the blocking call only completes once the asynchronous call is
+	// sent too. The reply to the asynchronous call comes after the reply to the blocking call, possibly in a later
+	// read, so it is waited for separately. The callback only records its outcome as it also runs, with an error, if
+	// the client is closed before the reply.
+	var (
+		asyncErr  error
+		asyncRes  string
+		asyncDone bool
+	)
+	client.AsyncCall([]byte("a"), time.Second, func(err error, res []byte) {
+		asyncErr, asyncRes, asyncDone = err, string(res), true
+	})
+
+	res, err := client.Call([]byte("b"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res) != "b" {
+		t.Fatalf("expected b got=%s", res)
+	}
+
+	if err := ioc.RunUntil(func() bool { return asyncDone }); err != nil {
+		t.Fatal(err)
+	}
+	if asyncErr != nil {
+		t.Fatal(asyncErr)
+	}
+	if asyncRes != "a" {
+		t.Fatalf("expected the asynchronous call to complete with a got=%q", asyncRes)
+	}
+
+	if _, err := client.Call([]byte("drop"), 10*time.Millisecond); err != sonicerrors.ErrTimeout {
+		t.Fatalf("expected ErrTimeout got=%v", err)
+	}
+}
+
+func TestClientCallTimeout(t *testing.T) {
+	addr, closeServer := runServer(t)
+	defer closeServer()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	client := newClient(t, ioc, addr)
+	defer client.Close()
+
+	var callErr error
+	done := false
+	client.AsyncCall([]byte("drop"), 10*time.Millisecond, func(err error, _ []byte) {
+		callErr = err
+		done = true
+	})
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && !done {
+		_, _ = ioc.PollOne()
+	}
+
+	if callErr != sonicerrors.ErrTimeout {
+		t.Fatalf("expected ErrTimeout got=%v", callErr)
+	}
+	if client.Pending() != 0 {
+		t.Fatal("expected no pending calls")
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	addr, closeServer := runServer(t)
+	defer closeServer()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	client := newClient(t, ioc, addr)
+
+	var callErr error
+	client.AsyncCall([]byte("drop"), time.Second, func(err error, _ []byte) {
+		callErr = err
+	})
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if callErr != ErrClientClosed {
+		t.Fatalf("expected ErrClientClosed got=%v", callErr)
+	}
+
+	client.AsyncCall([]byte("a"), time.Second, func(err error, _ []byte) {
+		callErr = err
+	})
+	if callErr != ErrClientClosed {
+		t.Fatalf("expected ErrClientClosed got=%v", callErr)
+	}
+}
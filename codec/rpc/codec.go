@@ -0,0 +1,132 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/csdenboer/sonic"
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+var (
+	_ sonic.Codec[*Message, *Message] = &Codec{}
+
+	ErrPayloadLengthOverflow = errors.New("payload length overflows")
+	ErrFrameTooBig           = errors.New("frame exceeds the maximum frame size")
+)
+
+const (
+	LengthLen        = 4                  // bytes
+	IDLen            = 8                  // bytes
+	HeaderLen        = LengthLen + IDLen  // bytes
+	MaxPayloadLength = 1024 * 1024 * 1024 // 1GB
+
+	// DefaultMaxFrameSize bounds the frames a Codec decodes, header included, unless set with SetMaxFrameSize.
+	DefaultMaxFrameSize = 16 * 1024 * 1024 // 16MB
+)
+
+// Message is a request or a response. Responses carry the ID of the request they answer.
+type Message struct {
+	ID      uint64
+	Payload []byte
+}
+
+// Codec encodes and decodes Messages. On the wire, a Message is:
+//   - 4 bytes: big endian length of the ID and the payload
+//   - 8 bytes: big endian ID
+//   - the payload
+//
+// The Message returned by Decode, along with its payload, is only valid until the next call to Decode.
+type Codec struct {
+	src *sonic.ByteBuffer
+
+	maxFrameSize int
+
+	decodeMessage Message
+	decodeReset   bool
+	decodeBytes   int
+}
+
+func NewCodec(src *sonic.ByteBuffer) *Codec {
+	return &Codec{src: src, maxFrameSize: DefaultMaxFrameSize}
+}
+
+// SetMaxFrameSize bounds the size of the frames, header included, which Encode writes and Decode reads. Decode fails
+// with ErrFrameTooBig as soon as it reads the header of a larger frame, before reserving any space for it, such that
+// a peer cannot make the Codec allocate up to MaxPayloadLength with a single header. n is capped to the frame of a
+// MaxPayloadLength payload; n <= 0 sets DefaultMaxFrameSize.
+func (c *Codec) SetMaxFrameSize(n int) {
+	if n <= 0 {
+		n = DefaultMaxFrameSize
+	} else if n > HeaderLen+MaxPayloadLength {
+		n = HeaderLen + MaxPayloadLength
+	}
+	c.maxFrameSize = n
+}
+
+// MaxFrameSize returns the value set with SetMaxFrameSize.
+func (c *Codec) MaxFrameSize() int {
+	return c.maxFrameSize
+}
+
+func (c *Codec) Encode(m *Message, dst *sonic.ByteBuffer) error {
+	payloadLen := len(m.Payload)
+
+	if payloadLen > MaxPayloadLength {
+		return ErrPayloadLengthOverflow
+	}
+	if HeaderLen+payloadLen > c.maxFrameSize {
+		return ErrFrameTooBig
+	}
+
+	dst.Reserve(HeaderLen + payloadLen)
+
+	dst.Claim(func(into []byte) int {
+		binary.BigEndian.PutUint32(into[:LengthLen], uint32(IDLen+payloadLen))
+		binary.BigEndian.PutUint64(into[LengthLen:HeaderLen], m.ID)
+		copy(into[HeaderLen:], m.Payload)
+		return HeaderLen + payloadLen
+	})
+	dst.Commit(HeaderLen + payloadLen)
+
+	return nil
+}
+
+func (c *Codec) resetDecode() {
+	if c.decodeReset {
+		c.decodeReset = false
+		c.src.Consume(c.decodeBytes)
+		c.decodeBytes = 0
+	}
+}
+
+func (c *Codec) Decode(src *sonic.ByteBuffer) (*Message, error) {
+	c.resetDecode()
+
+	if err := src.PrepareRead(HeaderLen); err != nil {
+		return nil, err
+	}
+
+	length := int(binary.BigEndian.Uint32(src.Data()[:LengthLen]))
+	if length < IDLen || length-IDLen > MaxPayloadLength {
+		return nil, ErrPayloadLengthOverflow
+	}
+	if LengthLen+length > c.maxFrameSize {
+		return nil, ErrFrameTooBig
+	}
+
+	if err := src.PrepareRead(LengthLen + length); err != nil {
+		if err == sonicerrors.ErrNeedMore {
+			src.Reserve(LengthLen + length)
+		}
+		return nil, err
+	}
+
+	c.decodeMessage.ID = binary.BigEndian.Uint64(src.Data()[LengthLen:HeaderLen])
+	c.decodeMessage.Payload = src.Data()[HeaderLen : LengthLen+length]
+
+	c.decodeReset = true
+	c.decodeBytes = LengthLen + length
+
+	return &c.decodeMessage, nil
+}
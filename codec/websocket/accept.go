@@ -0,0 +1,265 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/csdenboer/sonic"
+	"github.com/csdenboer/sonic/sonicerrors"
+	"golang.org/x/sys/unix"
+)
+
+// Accept performs the server side of the handshake over stream, which is
+// typically a connection just accepted from a sonic.Listener: it reads the
+// upgrade request of the client, validates it and answers it with a 101
+// Switching Protocols response carrying the Sec-WebSocket-Accept key. The
+// stream is then active and owns stream, which should be closed with
+// CloseNextLayer.
+//
+// Accept blocks until the handshake completes. If stream is nonblocking, as
+// the connections of sonic are, Accept waits for its file descriptor to
+// become readable or writable with poll(2). Either way, it blocks the calling
+// goroutine, and so the IO if called from a handler: servers running on the
+// IO should use AsyncAccept.
+//
+// The permessage-deflate extension is negotiated if it is enabled with
+// SetDeflate. The upgrade request callback, if any, is invoked with the
+// request, and the upgrade response callback with the response before it is
+// sent, so it can add headers to it.
+//
+// Invalid requests are answered with an HTTP error and fail with
+// ErrCannotUpgrade; stream is not closed. The subprotocol is picked by the
+// selector set with SetSubprotocolSelector, if any. Origin checks and
+// authorization are left to the caller; see UpgradeRouter for a server
+// handling them.
+func (s *WebsocketStream) Accept(stream sonic.Stream) error {
+	if s.role != RoleServer {
+		return ErrWrongHandshakeRole
+	}
+
+	s.reset()
+
+	err := s.accept(stream)
+	if err != nil {
+		s.releaseMemory()
+	}
+	return err
+}
+
+func (s *WebsocketStream) accept(stream sonic.Stream) error {
+	b := make([]byte, DefaultMaxUpgradeRequestSize)
+	n := 0
+	for {
+		m, err := stream.Read(b[n:])
+		n += m
+		if errors.Is(err, sonicerrors.ErrWouldBlock) {
+			if err = waitFd(stream, unix.POLLIN); err == nil {
+				continue
+			}
+		}
+		if end := bytes.Index(b[:n], []byte("\r\n\r\n")); end >= 0 {
+			return s.acceptRequest(stream, b[:n], end+4, func(res []byte) error {
+				_, err := writeAll(stream, res)
+				return err
+			})
+		}
+		if err == nil && n == len(b) {
+			res, err := rejectResponse(http.StatusRequestHeaderFieldsTooLarge, "request too big", nil)
+			_, _ = writeAll(stream, res)
+			s.state = StateTerminated
+			return err
+		}
+		if err != nil {
+			s.state = StateTerminated
+			return err
+		}
+	}
+}
+
+// AsyncAccept is the asynchronous version of Accept. cb is invoked once the
+// response is sent.
+func (s *WebsocketStream) AsyncAccept(stream sonic.Stream, cb func(error)) {
+	if s.role != RoleServer {
+		cb(ErrWrongHandshakeRole)
+		return
+	}
+
+	s.reset()
+	s.asyncAcceptRead(stream, make([]byte, DefaultMaxUpgradeRequestSize), 0, func(err error) {
+		if err != nil {
+			s.releaseMemory()
+		}
+		cb(err)
+	})
+}
+
+func (s *WebsocketStream) asyncAcceptRead(stream sonic.Stream, b []byte, n int, cb func(error)) {
+	stream.AsyncRead(b[n:], func(err error, m int) {
+		n += m
+
+		var res []byte
+		if end := bytes.Index(b[:n], []byte("\r\n\r\n")); end >= 0 {
+			err = s.acceptRequest(stream, b[:n], end+4, func(r []byte) error {
+				res = r
+				return nil
+			})
+		} else if err == nil && n == len(b) {
+			res, err = rejectResponse(http.StatusRequestHeaderFieldsTooLarge, "request too big", nil)
+			s.state = StateTerminated
+		} else if err == nil {
+			s.asyncAcceptRead(stream, b, n, cb)
+			return
+		} else {
+			s.state = StateTerminated
+		}
+
+		if len(res) == 0 {
+			cb(err)
+			return
+		}
+		stream.AsyncWriteAll(res, func(werr error, _ int) {
+			if err == nil && werr != nil {
+				err = werr
+				s.state = StateTerminated
+			}
+			cb(err)
+		})
+	})
+}
+
+// acceptRequest answers the upgrade request held by the first end bytes of b
+// with write. On success, the stream is active and the bytes which follow the
+// request are kept in the read buffer.
+func (s *WebsocketStream) acceptRequest(
+	stream sonic.Stream,
+	b []byte,
+	end int,
+	write func(res []byte) error,
+) error {
+	res, err := s.upgradeResponse(b[:end])
+	if err != nil {
+		s.state = StateTerminated
+		_ = write(res)
+		return err
+	}
+
+	s.state = StateActive
+	if err = s.init(stream); err == nil {
+		// The client may send frames right after its request.
+		_, _ = s.src.Write(b[end:])
+		err = write(res)
+	}
+	if err != nil {
+		s.state = StateTerminated
+	}
+	return err
+}
+
+// upgradeResponse returns the response to the upgrade request raw. It returns
+// an error wrapping ErrCannotUpgrade, along with the error response, if the
+// request is rejected.
+func (s *WebsocketStream) upgradeResponse(raw []byte) ([]byte, error) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return rejectResponse(http.StatusBadRequest, err.Error(), nil)
+	}
+	if s.upReqCb != nil {
+		s.upReqCb(req)
+	}
+	if status, reason, header := checkUpgradeRequest(req); status != 0 {
+		return rejectResponse(status, reason, header)
+	}
+
+	header := switchingProtocolsHeader(req.Header.Get("Sec-WebSocket-Key"))
+	s.selectSubprotocolFor(req, header)
+	if s.deflate.cfg != nil {
+		if ext, params, ok := acceptDeflateOffer(s.deflate.cfg, req.Header); ok {
+			header.Set("Sec-WebSocket-Extensions", ext)
+			s.deflate.enable(s.deflate.cfg, params)
+		}
+	}
+
+	if s.upResCb != nil {
+		s.upResCb(&http.Response{
+			Status:     "101 Switching Protocols",
+			StatusCode: http.StatusSwitchingProtocols,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     header,
+			Request:    req,
+		})
+	}
+
+	return switchingProtocolsResponse(header), nil
+}
+
+// checkUpgradeRequest returns the status, the reason and the headers of the
+// response rejecting req, or 0 if req is a valid websocket upgrade request.
+func checkUpgradeRequest(req *http.Request) (status int, reason string, header http.Header) {
+	switch {
+	case req.Method != http.MethodGet || !IsUpgradeReq(req) || !headerHasToken(req.Header, "Connection", "upgrade"):
+		return http.StatusBadRequest, "not an upgrade request", nil
+	case req.Header.Get("Sec-WebSocket-Version") != "13":
+		return http.StatusUpgradeRequired, "unsupported version", http.Header{"Sec-WebSocket-Version": {"13"}}
+	case req.Header.Get("Sec-WebSocket-Key") == "":
+		return http.StatusBadRequest, "missing key", nil
+	}
+	return 0, "", nil
+}
+
+// switchingProtocolsHeader returns the headers of the response accepting the
+// upgrade request with the given key.
+func switchingProtocolsHeader(key string) http.Header {
+	header := http.Header{}
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Accept", MakeResponseKey([]byte(key)))
+	return header
+}
+
+func switchingProtocolsResponse(header http.Header) []byte {
+	var res bytes.Buffer
+	res.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	_ = header.Write(&res)
+	res.WriteString("\r\n")
+	return res.Bytes()
+}
+
+// rejectResponse returns the response rejecting an upgrade request with the
+// given status, along with the error the handshake fails with.
+func rejectResponse(status int, reason string, header http.Header) ([]byte, error) {
+	var res bytes.Buffer
+	fmt.Fprintf(&res, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	_ = header.Write(&res)
+	res.WriteString("Connection: close\r\nContent-Length: 0\r\n\r\n")
+
+	return res.Bytes(), fmt.Errorf("%w: %d %s", ErrCannotUpgrade, status, reason)
+}
+
+func writeAll(stream sonic.Stream, b []byte) (n int, err error) {
+	for n < len(b) && err == nil {
+		var m int
+		m, err = stream.Write(b[n:])
+		n += m
+		if errors.Is(err, sonicerrors.ErrWouldBlock) {
+			err = waitFd(stream, unix.POLLOUT)
+		}
+	}
+	return n, err
+}
+
+// waitFd blocks until the file descriptor of the nonblocking stream is ready
+// for the given poll events.
+func waitFd(stream sonic.Stream, events int16) error {
+	fds := []unix.PollFd{{Fd: int32(stream.RawFd()), Events: events}}
+	for {
+		_, err := unix.Poll(fds, -1)
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
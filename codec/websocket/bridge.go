@@ -0,0 +1,143 @@
+package websocket
+
+import (
+	"io"
+
+	"github.com/csdenboer/sonic"
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+// DefaultBridgeBufferSize is the default size of the buffers of a Bridge. It
+// bounds the size of the messages read from the websocket stream and of the
+// messages written to it.
+const DefaultBridgeBufferSize = 64 * 1024
+
+// Bridge connects a websocket stream to a byte stream upstream, such as a TCP
+// connection: the payload of each binary message read from the websocket
+// stream is written to the upstream, and the bytes read from the upstream are
+// written to the websocket stream as binary messages.
+//
+// This is the usual gateway exposing a TCP service to browser clients. Message
+// boundaries are not preserved towards the upstream, which must delimit its
+// messages itself, as with any TCP protocol.
+//
+// Each direction has at most one read or write in flight, so a slow reader on
+// one side stops the bridge from reading on the other side, which in turn
+// makes the kernel apply TCP flow control to the fast writer.
+//
+// Unlike a TCP proxy, a Bridge cannot splice bytes in the kernel as websocket
+// payloads must be unmasked and framed in user space.
+type Bridge struct {
+	ws       Stream
+	upstream sonic.Conn
+
+	toUpstream   []byte
+	fromUpstream []byte
+
+	done    bool
+	onClose func(err error)
+}
+
+// NewBridge creates a Bridge between an active websocket stream and an
+// upstream connection. The buffers of each direction are bufSize bytes long;
+// if bufSize is 0 or less, DefaultBridgeBufferSize is used.
+func NewBridge(ws Stream, upstream sonic.Conn, bufSize int) *Bridge {
+	if bufSize <= 0 {
+		bufSize = DefaultBridgeBufferSize
+	}
+	return &Bridge{
+		ws:           ws,
+		upstream:     upstream,
+		toUpstream:   make([]byte, bufSize),
+		fromUpstream: make([]byte, bufSize),
+	}
+}
+
+// Start starts forwarding in both directions. onClose is invoked once, when
+// the bridge stops because either side failed or closed, in which case the
+// error is io.EOF. Both sides are closed by then.
+//
+// A text message from the websocket peer stops the bridge with
+// ErrUnexpectedMessageType.
+func (b *Bridge) Start(onClose func(err error)) {
+	b.onClose = onClose
+	b.readWebsocket()
+	b.readUpstream()
+}
+
+func (b *Bridge) readWebsocket() {
+	if b.done {
+		return
+	}
+
+	b.ws.AsyncNextMessage(b.toUpstream, func(err error, n int, mt MessageType) {
+		if err != nil {
+			b.stop(err, CloseNormal)
+			return
+		}
+		if mt != TypeBinary {
+			b.stop(ErrUnexpectedMessageType, CloseUnknownData)
+			return
+		}
+
+		b.upstream.AsyncWriteAll(b.toUpstream[:n], func(err error, _ int) {
+			if err != nil {
+				b.stop(err, CloseGoingAway)
+			} else {
+				b.readWebsocket()
+			}
+		})
+	})
+}
+
+func (b *Bridge) readUpstream() {
+	if b.done {
+		return
+	}
+
+	b.upstream.AsyncRead(b.fromUpstream, func(err error, n int) {
+		if err != nil {
+			if err == io.EOF {
+				b.stop(err, CloseNormal)
+			} else {
+				b.stop(err, CloseGoingAway)
+			}
+			return
+		}
+
+		b.ws.AsyncWrite(b.fromUpstream[:n], TypeBinary, func(err error) {
+			if err != nil {
+				b.stop(err, CloseNormal)
+			} else {
+				b.readUpstream()
+			}
+		})
+	})
+}
+
+func (b *Bridge) stop(err error, cc CloseCode) {
+	if b.done {
+		return
+	}
+	b.done = true
+
+	_ = b.upstream.Close()
+
+	if b.ws.State() == StateActive {
+		b.ws.AsyncClose(cc, "", func(error) {
+			_ = b.ws.CloseNextLayer()
+		})
+	} else {
+		_ = b.ws.CloseNextLayer()
+	}
+
+	if b.onClose != nil {
+		b.onClose(err)
+	}
+}
+
+// Close stops the bridge and closes both sides. onClose is invoked with
+// sonicerrors.ErrCancelled.
+func (b *Bridge) Close() {
+	b.stop(sonicerrors.ErrCancelled, CloseGoingAway)
+}
@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic"
+)
+
+func TestBridge(t *testing.T) {
+	// The upstream echoes everything back.
+	upstreamLn, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstreamLn.Close()
+	go func() {
+		conn, err := upstreamLn.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	// The websocket client sends a binary message, reads the reply and closes.
+	replies := make(chan []byte, 1)
+	clientLn, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientLn.Close()
+	go func() {
+		conn, err := clientLn.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		f := NewFrame()
+		f.SetFin()
+		f.SetBinary()
+		f.SetPayload([]byte("hello"))
+		f.Mask()
+		if _, err := f.WriteTo(conn); err != nil {
+			return
+		}
+
+		f = NewFrame()
+		if _, err := f.ReadFrom(conn); err != nil {
+			return
+		}
+		replies <- append([]byte(nil), f.Payload()...)
+
+		f = NewFrame()
+		f.SetFin()
+		f.SetClose()
+		f.SetPayload([]byte{0x03, 0xe8}) // CloseNormal
+		f.Mask()
+		_, _ = f.WriteTo(conn)
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	conn, err := sonic.Dial(ioc, "tcp", clientLn.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, err := NewWebsocketStream(ioc, nil, RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.state = StateActive
+	if err := ws.init(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	upstream, err := sonic.Dial(ioc, "tcp", upstreamLn.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		closed   bool
+		closeErr error
+	)
+	NewBridge(ws, upstream, 0).Start(func(err error) {
+		closed = true
+		closeErr = err
+	})
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && !closed {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+
+	if !closed {
+		t.Fatal("bridge did not close")
+	}
+	if closeErr != io.EOF {
+		t.Fatalf("expected EOF got=%v", closeErr)
+	}
+	select {
+	case reply := <-replies:
+		if !bytes.Equal(reply, []byte("hello")) {
+			t.Fatalf("expected reply=hello got=%s", reply)
+		}
+	default:
+		t.Fatal("client did not get a reply")
+	}
+}
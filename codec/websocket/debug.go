@@ -0,0 +1,57 @@
+package websocket
+
+import (
+	"bytes"
+)
+
+// debugFrame checks, if the IO of the stream is in debug mode, that the frame
+// f, about to be written, decodes back to itself once encoded. A frame which
+// does not, for example because its header holds a stale length, is reported
+// as a violation, see sonic.IO.SetDebug.
+func (s *WebsocketStream) debugFrame(f *Frame) {
+	if s.ioc == nil || !s.ioc.Debug() {
+		return
+	}
+	s.ioc.Debugf("websocket %s write opcode=%s fin=%t len=%d", s.role, f.Opcode(), f.IsFin(), len(f.payload))
+
+	// The length is set in the header when the frame is encoded.
+	f.SetPayloadLen()
+	if n := f.PayloadLen(); n != len(f.payload) {
+		s.ioc.Violation(-1, "websocket frame header holds a length of %d for a payload of %d bytes", n, len(f.payload))
+		return
+	}
+
+	var b bytes.Buffer
+	if _, err := f.WriteTo(&b); err != nil {
+		s.ioc.Violation(-1, "websocket frame cannot be encoded: %v", err)
+		return
+	}
+	encoded := b.Len()
+
+	g := AcquireFrame()
+	defer ReleaseFrame(g)
+	if _, err := g.ReadFrom(&b); err != nil {
+		s.ioc.Violation(-1, "websocket frame cannot be decoded once encoded: %v", err)
+		return
+	}
+
+	switch {
+	case b.Len() != 0:
+		s.ioc.Violation(-1, "websocket frame decoded from %d of its %d bytes", encoded-b.Len(), encoded)
+	case g.header[0] != f.header[0] || g.IsMasked() != f.IsMasked():
+		s.ioc.Violation(-1, "websocket frame header %x decoded as %x", f.header[:2], g.header[:2])
+	case g.IsMasked() && !bytes.Equal(g.mask, f.mask):
+		s.ioc.Violation(-1, "websocket frame mask %x decoded as %x", f.mask, g.mask)
+	case !bytes.Equal(g.payload, f.payload):
+		s.ioc.Violation(-1, "websocket frame payload of %d bytes decoded as %d bytes", len(f.payload), len(g.payload))
+	}
+}
+
+// debugRead writes a diagnostic for the frame f just read, if the IO of the
+// stream is in debug mode.
+func (s *WebsocketStream) debugRead(f *Frame) {
+	if s.ioc == nil || !s.ioc.Debug() {
+		return
+	}
+	s.ioc.Debugf("websocket %s read opcode=%s fin=%t len=%d", s.role, f.Opcode(), f.IsFin(), f.PayloadLen())
+}
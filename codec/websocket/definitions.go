@@ -1,6 +1,7 @@
 package websocket
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"time"
@@ -9,8 +10,9 @@ import (
 )
 
 var (
-	MaxMessageSize = 1024 * 512 // the maximum size of a message
-	CloseTimeout   = 5 * time.Second
+	MaxMessageSize      = 1024 * 512 // the maximum size of a message
+	MaxMessageFragments = 4096       // the default maximum number of frames in a message
+	CloseTimeout        = 5 * time.Second
 )
 
 type Role uint8
@@ -60,6 +62,19 @@ func (t MessageType) String() string {
 	}
 }
 
+// StreamState is the state of a stream, which goes through the closing
+// handshake of RFC 6455 one direction at a time:
+//   - in StateClosedByUs, our close frame is sent and no more messages can be
+//     written, but the peer may still send messages before it replies with its
+//     own close frame, so reads go on.
+//   - in StateCloseAcked and StateClosedByPeer, both close frames are
+//     exchanged, and in StateTerminated the connection is gone: reads fail
+//     with io.EOF, leaving the state as is, and writes with
+//     sonicerrors.ErrCancelled.
+//
+// The synchronous and asynchronous reads and writes behave alike in every
+// state. A read which cannot flush the frames replying to the peer, such as
+// the reply to a close frame, terminates the stream.
 type StreamState uint8
 
 const (
@@ -105,6 +120,18 @@ func (s StreamState) String() string {
 	}
 }
 
+// CanRead returns true if frames can be read from the peer in the state,
+// which is the case until the peer sent its close frame.
+func (s StreamState) CanRead() bool {
+	return s == StateActive || s == StateClosedByUs
+}
+
+// CanWrite returns true if messages can be written to the peer in the state,
+// which is the case until either side sent its close frame.
+func (s StreamState) CanWrite() bool {
+	return s == StateActive
+}
+
 type AsyncMessageHandler = func(err error, n int, mt MessageType)
 type AsyncFrameHandler = func(err error, f *Frame)
 type ControlCallback = func(mt MessageType, payload []byte)
@@ -150,8 +177,9 @@ type Stream interface {
 
 	// SupportsUTF8 returns true if UTF8 validity checks are supported.
 	//
-	// Implementations should not do UTF8 checking by default. Callers
-	// should be able to turn it on when instantiating the Stream.
+	// RFC 6455 requires text messages to be valid UTF8, so implementations
+	// should check them by default. Callers should be able to turn the
+	// checks off.
 	SupportsUTF8() bool
 
 	// NextMessage reads the payload of the next message into the supplied
@@ -246,7 +274,7 @@ type Stream interface {
 	//  - the message is successfully written to the underlying stream
 	//
 	// The message will be written as a single frame. Fragmentation should be
-	// handled by the caller through multiple calls to AsyncWriteFrame.
+	// handled by the caller through multiple calls to WriteSome.
 	Write(b []byte, mt MessageType) error
 
 	// AsyncWrite writes the supplied buffer as a single message with the given
@@ -262,9 +290,33 @@ type Stream interface {
 	//  - the message is successfully written to the underlying stream
 	//
 	// The message will be written as a single frame. Fragmentation should be
-	// handled by the caller through multiple calls to AsyncWriteFrame.
+	// handled by the caller through multiple calls to AsyncWriteSome.
 	AsyncWrite(b []byte, mt MessageType, cb func(err error))
 
+	// WriteSome writes the supplied buffer as the next fragment of a message
+	// with the given type, which ends with it if fin is true.
+	//
+	// This call blocks.
+	WriteSome(fin bool, b []byte, mt MessageType) error
+
+	// AsyncWriteSome writes the supplied buffer as the next fragment of a
+	// message with the given type asynchronously, which ends with it if fin is
+	// true.
+	//
+	// This call does not block.
+	AsyncWriteSome(fin bool, b []byte, mt MessageType, cb func(err error))
+
+	// AsyncWriteShared is like AsyncWrite but writes a payload shared with
+	// other streams, such as a message broadcast to many connections, without
+	// copying it. The stream holds a reference on the payload until the
+	// message is written.
+	AsyncWriteShared(p *RefCountedPayload, mt MessageType, cb func(err error))
+
+	// WriteShared is the synchronous counterpart of AsyncWriteShared.
+	//
+	// This call blocks.
+	WriteShared(p *RefCountedPayload, mt MessageType) error
+
 	// Flush writes any pending control frames to the underlying stream.
 	//
 	// This call blocks.
@@ -279,6 +331,15 @@ type Stream interface {
 	// Pending returns the number of currently pending operations.
 	Pending() int
 
+	// SetControlFlushDeadline sets how long the replies to control frames can
+	// stay pending before they are flushed automatically. A negative deadline
+	// disables automatic flushing.
+	SetControlFlushDeadline(d time.Duration)
+
+	// ControlFlushDeadline returns the deadline set with
+	// SetControlFlushDeadline.
+	ControlFlushDeadline() time.Duration
+
 	// State returns the state of the WebSocket connection.
 	State() StreamState
 
@@ -301,23 +362,50 @@ type Stream interface {
 	// using sonic.Post(...).
 	AsyncHandshake(addr string, cb func(error), extraHeaders ...Header)
 
-	// Accept performs the handshake in the server role.
+	// AsyncHandshakeContext is AsyncHandshake bound to ctx: the dial and the
+	// upgrade are interrupted once ctx is done, in which case the handler is
+	// called with ctx.Err(), which is context.Canceled or
+	// context.DeadlineExceeded. The stream is then terminated and its next
+	// layer, if any, should be closed with CloseNextLayer.
+	AsyncHandshakeContext(
+		ctx context.Context,
+		addr string,
+		cb func(error),
+		extraHeaders ...Header,
+	)
+
+	// SetProxy sets the function returning the HTTP proxy through which the
+	// handshakes of a client stream dial their address, see ProxyFromEnvironment.
+	SetProxy(proxy ProxyFunc)
+
+	// Subprotocol returns the subprotocol negotiated by the handshake, or the
+	// empty string if none was. Clients offer subprotocols by passing the
+	// Subprotocols header to the handshake; servers select one with
+	// SetSubprotocolSelector, or per route with UpgradeRouter.
+	Subprotocol() string
+
+	// SetSubprotocolSelector sets the function picking the subprotocol of a
+	// server stream among those offered by the client, see Accept.
+	SetSubprotocolSelector(selector SubprotocolSelector)
+
+	// Accept performs the handshake in the server role over stream, typically
+	// a connection accepted from a sonic.Listener.
 	//
 	// The call blocks until one of the following conditions is true:
-	//	- the request is sent and the response is received
+	//	- the request is received and the response is sent
 	//	- an error occurs
-	Accept() error
+	//
+	// Once the handshake succeeds, the stream owns the next layer, which
+	// should be closed with CloseNextLayer.
+	Accept(stream sonic.Stream) error
 
-	// AsyncAccept performs the handshake asynchronously in the server role.
+	// AsyncAccept performs the handshake asynchronously in the server role
+	// over stream.
 	//
 	// This call does not block. The provided completion handler is called when
-	// the request is send and the response is received or when an error occurs.
-	//
-	// Regardless of  whether the asynchronous operation completes immediately
-	// or not, the handler will not be invoked from within this function.
-	// Invocation of the handler will be performed in a manner equivalent to
-	// using sonic.Post(...).
-	AsyncAccept(func(error))
+	// the request is received and the response is sent or when an error
+	// occurs.
+	AsyncAccept(stream sonic.Stream, cb func(error))
 
 	// AsyncClose sends a websocket close control frame asynchronously.
 	//
@@ -379,13 +467,57 @@ type Stream interface {
 	UpgradeResponseCallback() UpgradeResponseCallback
 
 	// SetMaxMessageSize sets the maximum size of a message that can be read
-	// from or written to a peer.
-	//  - If a message exceeds the limit while reading, the connection is
-	//    closed abnormally.
+	// from or written to a peer. The default is MaxMessageSize.
+	//  - If a message exceeds the limit while reading, the read fails with
+	//    ErrMessageTooBig and the connection is closed with CloseTooBig.
 	//  - If a message exceeds the limit while writing, the operation is
 	//    cancelled.
 	SetMaxMessageSize(bytes int)
 
+	// MaxMessageSize returns the limit set with SetMaxMessageSize.
+	MaxMessageSize() int
+
+	// SetMaxFrameSize sets the maximum payload length of a frame read from
+	// the peer, which is otherwise bounded by the maximum size of a message. A
+	// frame advertising a longer payload in its header is rejected right
+	// away, before any of its payload is buffered: the read fails with
+	// ErrPayloadOverMaxSize and the connection is closed with CloseTooBig.
+	// This protects servers against peers advertising huge payloads.
+	//
+	// The payload of a rejected frame is never read, so the stream cannot be
+	// read from anymore: the caller should close the next layer.
+	//
+	// A limit of 0 or less means frames are only bounded by the maximum size
+	// of a message, which is the default.
+	SetMaxFrameSize(bytes int)
+
+	// MaxFrameSize returns the limit set with SetMaxFrameSize.
+	MaxFrameSize() int
+
+	// SetTracer traces the raw bytes of the frames exchanged with the peer,
+	// to diagnose the interoperability issues of peers which subtly violate
+	// the RFC. A nil cfg disables tracing.
+	SetTracer(cfg *sonic.TraceConfig)
+
+	// SetMaxMessageFragments sets the maximum number of frames a message read
+	// from the peer can be fragmented into. This defends against peers sending
+	// a message as a very large number of tiny continuation frames. If a
+	// message exceeds the limit, the connection is closed with CloseTooBig.
+	//
+	// A limit of 0 or less means the number of fragments is not limited. The
+	// default is MaxMessageFragments.
+	SetMaxMessageFragments(n int)
+
+	// MaxMessageFragments returns the limit set with SetMaxMessageFragments.
+	MaxMessageFragments() int
+
+	// SetUserData attaches application state, such as a chat session, to the
+	// stream. Retrieve it with UserData or sonic.UserDataOf.
+	SetUserData(data any)
+
+	// UserData returns the value attached with SetUserData, or nil.
+	UserData() any
+
 	RemoteAddr() net.Addr
 
 	LocalAddr() net.Addr
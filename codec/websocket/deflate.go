@@ -0,0 +1,496 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/csdenboer/sonic"
+)
+
+// DefaultDeflateThreshold is the size under which messages are sent
+// uncompressed if DeflateConfig.Threshold is not positive. Compressing small
+// messages is not worth the CPU time and can even make them bigger.
+const DefaultDeflateThreshold = 128
+
+// deflateExtension is the name of the permessage-deflate extension.
+const deflateExtension = "permessage-deflate"
+
+// deflateEnd is appended to the payload of a compressed message before it is
+// decompressed: the end of the sync flush block which the sender strips, see
+// RFC 7692 section 7.2.2, followed by an empty final block such that the
+// decompressor ends cleanly.
+var deflateEnd = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+// deflateWindow is the size of the window of the compressor, which is the
+// largest window of permessage-deflate.
+const deflateWindow = 32 * 1024
+
+// DeflateConfig configures the permessage-deflate extension of RFC 7692, which
+// compresses the payload of messages. See SetDeflate and
+// HandshakePolicy.Deflate.
+type DeflateConfig struct {
+	// Threshold is the size from which messages are sent compressed.
+	// DefaultDeflateThreshold is used if Threshold <= 0.
+	Threshold int
+
+	// Level is the compression level, see compress/flate. 0 means
+	// flate.DefaultCompression.
+	Level int
+
+	// ServerNoContextTakeover and ClientNoContextTakeover make the server,
+	// respectively the client, compress each message on its own instead of
+	// referring to the previous messages, which costs compression but saves
+	// the memory of the compression window between messages. A client offers
+	// them to the server, and a server imposes them.
+	ServerNoContextTakeover bool
+	ClientNoContextTakeover bool
+}
+
+func (c *DeflateConfig) threshold() int {
+	if c.Threshold <= 0 {
+		return DefaultDeflateThreshold
+	}
+	return c.Threshold
+}
+
+func (c *DeflateConfig) level() int {
+	if c.Level == 0 {
+		return flate.DefaultCompression
+	}
+	return c.Level
+}
+
+func (c *DeflateConfig) validate() error {
+	if c.Level < flate.HuffmanOnly || c.Level > flate.BestCompression {
+		return fmt.Errorf("invalid compression level %d", c.Level)
+	}
+	return nil
+}
+
+// deflateParams are the negotiated parameters of permessage-deflate, from the
+// point of view of one side of the connection.
+type deflateParams struct {
+	ownNoTakeover  bool // we compress each message on its own
+	peerNoTakeover bool // the peer compresses each message on its own
+}
+
+// deflateState holds the compression state of a stream.
+type deflateState struct {
+	cfg     *DeflateConfig // nil if the extension is not used
+	enabled bool           // true if the extension was negotiated
+	params  deflateParams
+
+	fw  *flate.Writer
+	out bytes.Buffer
+
+	fr    io.ReadCloser
+	dict  []byte // the last bytes decompressed, if the peer takes over its context
+	chunk []byte
+
+	// inflating is true while the message being read is compressed, in which
+	// case its compressed payload is gathered in in.
+	inflating bool
+	in        []byte
+}
+
+func (d *deflateState) enable(cfg *DeflateConfig, params deflateParams) {
+	d.reset()
+	d.cfg = cfg
+	d.enabled = true
+	d.params = params
+}
+
+// reset drops the state of the previous connection.
+func (d *deflateState) reset() {
+	d.enabled = false
+	d.params = deflateParams{}
+	d.fw = nil
+	d.fr = nil
+	d.dict = d.dict[:0]
+	d.inflating = false
+	d.in = d.in[:0]
+}
+
+// SetDeflate sets the configuration of the permessage-deflate extension, which
+// is then offered in the handshakes of a client stream, and used if the
+// server accepts it. Server streams negotiate the extension when they are
+// upgraded, see HandshakePolicy.Deflate. nil, the default, disables the
+// extension in the next handshakes.
+//
+// Once negotiated, the messages written with Write and AsyncWrite are
+// compressed if they are at least as big as the threshold of cfg, and the
+// compressed messages read with NextMessage, AsyncNextMessage and their
+// variants are decompressed. The frame level methods, such as NextFrame and
+// WriteFrame, deal with the frames as they are sent: the compressed frames
+// have the RSV1 bit set. Unless the peer compresses each message on its own,
+// a compressed message refers to the previous ones, so once a compressed
+// message is read frame by frame, the next ones cannot be decompressed.
+//
+// The compressor always uses a 32KB window, so a server stream declines the
+// offers which bound the window of the server to less than that.
+func (s *WebsocketStream) SetDeflate(cfg *DeflateConfig) error {
+	if cfg != nil {
+		if err := cfg.validate(); err != nil {
+			return err
+		}
+	}
+	s.deflate.cfg = cfg
+	return nil
+}
+
+// DeflateNegotiated returns true if the permessage-deflate extension was
+// negotiated in the handshake of the stream.
+func (s *WebsocketStream) DeflateNegotiated() bool {
+	return s.deflate.enabled
+}
+
+// compresses returns true if a message of n bytes is sent compressed.
+func (d *deflateState) compresses(n int) bool {
+	return d.enabled && n >= d.cfg.threshold()
+}
+
+// compress returns the compressed payload of the message b. The returned
+// slice is only valid until the next call.
+func (d *deflateState) compress(b []byte) ([]byte, error) {
+	d.out.Reset()
+	if d.fw == nil {
+		fw, err := flate.NewWriter(&d.out, d.cfg.level())
+		if err != nil {
+			return nil, err
+		}
+		d.fw = fw
+	} else if d.params.ownNoTakeover {
+		d.fw.Reset(&d.out)
+	}
+
+	_, err := d.fw.Write(b)
+	if err == nil {
+		err = d.fw.Flush()
+	}
+	if err != nil {
+		// A new compressor does not refer to what the peer has not seen.
+		d.fw = nil
+		return nil, err
+	}
+
+	p := d.out.Bytes()
+	return bytes.TrimSuffix(p, deflateEnd[:4]), nil
+}
+
+// inflate decompresses the compressed payload of a message read from r, and
+// hands the decompressed bytes to write as they come.
+func (d *deflateState) inflate(r io.Reader, write func(b []byte) error) error {
+	src := io.MultiReader(r, bytes.NewReader(deflateEnd))
+
+	var dict []byte
+	if !d.params.peerNoTakeover {
+		dict = d.dict
+	}
+	if d.fr == nil {
+		d.fr = flate.NewReaderDict(src, dict)
+	} else if err := d.fr.(flate.Resetter).Reset(src, dict); err != nil {
+		return err
+	}
+	if d.chunk == nil {
+		d.chunk = make([]byte, 4096)
+	}
+
+	for {
+		n, err := d.fr.Read(d.chunk)
+		if n > 0 {
+			if !d.params.peerNoTakeover {
+				d.keep(d.chunk[:n])
+			}
+			if werr := write(d.chunk[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidCompressedPayload, err)
+		}
+	}
+}
+
+// keep adds b to the window of the decompressor for the next message.
+func (d *deflateState) keep(b []byte) {
+	d.dict = append(d.dict, b...)
+	if len(d.dict) > deflateWindow {
+		d.dict = d.dict[:copy(d.dict, d.dict[len(d.dict)-deflateWindow:])]
+	}
+}
+
+// setMessagePayload sets b as the payload of the single frame message f,
+// compressed if the extension is negotiated and b is big enough.
+func (s *WebsocketStream) setMessagePayload(f *Frame, b []byte) {
+	if s.deflate.compresses(len(b)) {
+		if p, err := s.deflate.compress(b); err == nil {
+			f.SetRSV1()
+			f.SetPayload(p)
+			return
+		}
+	}
+	f.SetPayload(b)
+}
+
+// appendMessagePayload appends the payload of the data frame f to the message
+// being read, like appendPayload. The payload of a compressed message is held
+// until the message is complete, see inflateMessage. It returns false if the
+// payload does not fit.
+func (s *WebsocketStream) appendMessagePayload(
+	b []byte,
+	off int,
+	dst *sonic.ByteBuffer,
+	f *Frame,
+	first bool,
+) (n int, ok bool) {
+	d := &s.deflate
+	if first {
+		d.inflating = d.enabled && f.IsRSV1()
+		d.in = d.in[:0]
+	}
+	if d.inflating {
+		d.in = append(d.in, f.Payload()...)
+		return 0, len(d.in) <= s.maxMessageSize
+	}
+
+	n = appendPayload(b, off, dst, f.Payload())
+	return n, n == f.PayloadLen()
+}
+
+// inflateMessage decompresses the compressed message which was just read into
+// b, or appends it to dst if dst is not nil, and returns its size.
+func (s *WebsocketStream) inflateMessage(
+	b []byte,
+	dst *sonic.ByteBuffer,
+) (n int, err error) {
+	d := &s.deflate
+	d.inflating = false
+
+	err = d.inflate(bytes.NewReader(d.in), func(p []byte) error {
+		if n+len(p) > s.maxMessageSize {
+			return ErrMessageTooBig
+		}
+		m := appendPayload(b, n, dst, p)
+		n += m
+		if m != len(p) {
+			return ErrMessageTooBig
+		}
+		return s.validateTextPayload(p, false, true)
+	})
+	if err == nil {
+		err = s.validateTextPayload(nil, true, true)
+	}
+
+	if cap(d.in) > maxPooledBufferSize {
+		d.in = nil
+	} else {
+		d.in = d.in[:0]
+	}
+	return n, err
+}
+
+// inflateClose returns the close code and reason of a stream which failed to
+// decompress or to validate a message with err.
+func inflateClose(err error) (CloseCode, string) {
+	switch err {
+	case ErrMessageTooBig:
+		return CloseTooBig, "message too big"
+	case ErrInvalidUTF8:
+		return CloseBadPayload, "invalid UTF-8"
+	default:
+		return CloseBadPayload, "invalid compressed payload"
+	}
+}
+
+// inflateSpill decompresses the compressed message p read by
+// NextMessageSpill into a new payload, which spills as well.
+func (s *WebsocketStream) inflateSpill(p *MessagePayload) (*MessagePayload, error) {
+	defer p.Close()
+
+	cfg := &s.spill.cfg
+	out := &MessagePayload{mt: p.mt, w: s.spill.w}
+	err := s.deflate.inflate(p.Reader(), func(b []byte) error {
+		if cfg.MaxSize > 0 && out.size+int64(len(b)) > cfg.MaxSize {
+			return ErrMessageTooBig
+		}
+		if err := s.validateTextPayload(b, false, true); err != nil {
+			return err
+		}
+		return out.write(b, cfg)
+	})
+	if err == nil {
+		err = s.validateTextPayload(nil, true, true)
+	}
+	if err != nil {
+		_ = out.Close()
+		return nil, err
+	}
+	return out, nil
+}
+
+// offer returns the permessage-deflate offer of a client stream.
+func (c *DeflateConfig) offer() string {
+	offer := deflateExtension
+	if c.ClientNoContextTakeover {
+		offer += "; client_no_context_takeover"
+	}
+	if c.ServerNoContextTakeover {
+		offer += "; server_no_context_takeover"
+	}
+	return offer
+}
+
+// acceptResponse enables the extension if the server accepted the offer of
+// the client stream, as told by the Sec-WebSocket-Extensions headers of its
+// response.
+func (d *deflateState) acceptResponse(header http.Header) error {
+	var accepted *extension
+	for _, ext := range parseExtensions(header) {
+		if ext.name != deflateExtension {
+			continue
+		}
+		if accepted != nil {
+			return fmt.Errorf("%w: %s accepted twice", ErrCannotUpgrade, deflateExtension)
+		}
+		ext := ext
+		accepted = &ext
+	}
+	if accepted == nil {
+		return nil
+	}
+	if d.cfg == nil {
+		return fmt.Errorf("%w: %s not offered", ErrCannotUpgrade, deflateExtension)
+	}
+
+	params := deflateParams{ownNoTakeover: d.cfg.ClientNoContextTakeover}
+	for k, v := range accepted.params {
+		switch k {
+		case "server_no_context_takeover":
+			params.peerNoTakeover = true
+		case "client_no_context_takeover":
+			params.ownNoTakeover = true
+		case "server_max_window_bits":
+			// Any window can be decompressed.
+			if _, ok := parseWindowBits(v); !ok {
+				return fmt.Errorf("%w: invalid %s=%q", ErrCannotUpgrade, k, v)
+			}
+		default:
+			// client_max_window_bits is never offered, so it cannot be imposed.
+			return fmt.Errorf("%w: unexpected %s parameter %s", ErrCannotUpgrade, deflateExtension, k)
+		}
+	}
+
+	d.enable(d.cfg, params)
+	return nil
+}
+
+// acceptDeflateOffer returns the response of a server to the first
+// permessage-deflate offer of the Sec-WebSocket-Extensions headers of an
+// upgrade request which it can accept, along with the negotiated parameters.
+// It returns false if no offer can be accepted.
+func acceptDeflateOffer(cfg *DeflateConfig, header http.Header) (string, deflateParams, bool) {
+next:
+	for _, ext := range parseExtensions(header) {
+		if ext.name != deflateExtension {
+			continue
+		}
+
+		params := deflateParams{
+			ownNoTakeover:  cfg.ServerNoContextTakeover,
+			peerNoTakeover: cfg.ClientNoContextTakeover,
+		}
+		windowBits := false
+		for k, v := range ext.params {
+			switch k {
+			case "server_no_context_takeover":
+				if v != "" {
+					continue next
+				}
+				params.ownNoTakeover = true
+			case "client_no_context_takeover":
+				if v != "" {
+					continue next
+				}
+				params.peerNoTakeover = true
+			case "server_max_window_bits":
+				// The compressor only has a 32KB window.
+				if bits, ok := parseWindowBits(v); !ok || bits < 15 {
+					continue next
+				}
+				windowBits = true
+			case "client_max_window_bits":
+				// Any window can be decompressed, so the client's is not
+				// bounded.
+				if _, ok := parseWindowBits(v); v != "" && !ok {
+					continue next
+				}
+			default:
+				continue next
+			}
+		}
+
+		response := deflateExtension
+		if params.ownNoTakeover {
+			response += "; server_no_context_takeover"
+		}
+		if params.peerNoTakeover {
+			response += "; client_no_context_takeover"
+		}
+		if windowBits {
+			response += "; server_max_window_bits=15"
+		}
+		return response, params, true
+	}
+	return "", deflateParams{}, false
+}
+
+func parseWindowBits(v string) (int, bool) {
+	bits, err := strconv.Atoi(v)
+	return bits, err == nil && bits >= 8 && bits <= 15
+}
+
+// extension is an element of a Sec-WebSocket-Extensions header: the name of an
+// extension and its parameters, which map to the empty string if they have no
+// value.
+type extension struct {
+	name   string
+	params map[string]string
+}
+
+// parseExtensions returns the extensions of the Sec-WebSocket-Extensions
+// headers, in order. Names are lowercased and quoted values unquoted.
+// Extensions with a repeated parameter are dropped.
+func parseExtensions(header http.Header) (exts []extension) {
+	for _, v := range header.Values("Sec-WebSocket-Extensions") {
+	next:
+		for _, e := range strings.Split(v, ",") {
+			parts := strings.Split(e, ";")
+			ext := extension{
+				name:   strings.ToLower(strings.TrimSpace(parts[0])),
+				params: make(map[string]string),
+			}
+			if ext.name == "" {
+				continue
+			}
+			for _, p := range parts[1:] {
+				k, v, _ := strings.Cut(p, "=")
+				k = strings.ToLower(strings.TrimSpace(k))
+				v = strings.Trim(strings.TrimSpace(v), `"`)
+				if _, ok := ext.params[k]; ok || k == "" {
+					continue next
+				}
+				ext.params[k] = v
+			}
+			exts = append(exts, ext)
+		}
+	}
+	return exts
+}
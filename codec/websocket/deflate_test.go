@@ -0,0 +1,125 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestAcceptDeflateOffer(t *testing.T) {
+	cases := []struct {
+		offer    string
+		accepted bool
+		response string
+		params   deflateParams
+	}{
+		{"", false, "", deflateParams{}},
+		{"x-webkit-deflate-frame", false, "", deflateParams{}},
+		{"permessage-deflate", true, "permessage-deflate", deflateParams{}},
+		{
+			"permessage-deflate; client_max_window_bits",
+			true, "permessage-deflate", deflateParams{},
+		},
+		{
+			"permessage-deflate; server_no_context_takeover; client_no_context_takeover",
+			true, "permessage-deflate; server_no_context_takeover; client_no_context_takeover",
+			deflateParams{ownNoTakeover: true, peerNoTakeover: true},
+		},
+		{
+			// The compressor window cannot be bounded, so the first offer is declined.
+			"permessage-deflate; server_max_window_bits=10, permessage-deflate; server_max_window_bits=\"15\"",
+			true, "permessage-deflate; server_max_window_bits=15", deflateParams{},
+		},
+		{"permessage-deflate; server_max_window_bits=16", false, "", deflateParams{}},
+		{"permessage-deflate; unknown", false, "", deflateParams{}},
+		{"permessage-deflate; server_no_context_takeover; server_no_context_takeover", false, "", deflateParams{}},
+	}
+
+	for _, c := range cases {
+		header := http.Header{}
+		if c.offer != "" {
+			header.Set("Sec-WebSocket-Extensions", c.offer)
+		}
+		response, params, ok := acceptDeflateOffer(&DeflateConfig{}, header)
+		if ok != c.accepted || response != c.response || params != c.params {
+			t.Fatalf("offer %q: got accepted=%v response=%q params=%+v", c.offer, ok, response, params)
+		}
+	}
+
+	// The server imposes its own parameters.
+	cfg := &DeflateConfig{ServerNoContextTakeover: true}
+	header := http.Header{"Sec-Websocket-Extensions": {"permessage-deflate"}}
+	if response, params, _ := acceptDeflateOffer(cfg, header); response != "permessage-deflate; server_no_context_takeover" ||
+		params != (deflateParams{ownNoTakeover: true}) {
+		t.Fatalf("got response=%q params=%+v", response, params)
+	}
+}
+
+func TestDeflateAcceptResponse(t *testing.T) {
+	cases := []struct {
+		cfg      *DeflateConfig
+		response string
+		enabled  bool
+		err      bool
+	}{
+		{&DeflateConfig{}, "", false, false},
+		{nil, "", false, false},
+		{nil, "permessage-deflate", false, true},
+		{&DeflateConfig{}, "permessage-deflate; server_max_window_bits=12", true, false},
+		{&DeflateConfig{}, "permessage-deflate; client_max_window_bits=12", false, true},
+		{&DeflateConfig{}, "permessage-deflate, permessage-deflate", false, true},
+	}
+
+	for _, c := range cases {
+		d := deflateState{cfg: c.cfg}
+		header := http.Header{}
+		if c.response != "" {
+			header.Set("Sec-WebSocket-Extensions", c.response)
+		}
+		err := d.acceptResponse(header)
+		if (err != nil) != c.err || d.enabled != c.enabled {
+			t.Fatalf("response %q: got enabled=%v err=%v", c.response, d.enabled, err)
+		}
+		if err != nil && !errors.Is(err, ErrCannotUpgrade) {
+			t.Fatalf("response %q: expected ErrCannotUpgrade got=%v", c.response, err)
+		}
+	}
+}
+
+func TestDeflateCompressInflate(t *testing.T) {
+	msg := []byte("the same words, over and over: the same words, over and over")
+
+	for _, noTakeover := range []bool{false, true} {
+		params := deflateParams{ownNoTakeover: noTakeover, peerNoTakeover: noTakeover}
+		var tx, rx deflateState
+		tx.enable(&DeflateConfig{}, params)
+		rx.enable(&DeflateConfig{}, params)
+
+		for i := 0; i < 3; i++ {
+			p, err := tx.compress(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []byte
+			err = rx.inflate(bytes.NewReader(p), func(b []byte) error {
+				got = append(got, b...)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(msg) {
+				t.Fatalf("noTakeover=%v message %d: got %q", noTakeover, i, got)
+			}
+		}
+	}
+
+	var rx deflateState
+	rx.enable(&DeflateConfig{}, deflateParams{})
+	err := rx.inflate(bytes.NewReader([]byte{0xff, 0xff, 0xff}), func([]byte) error { return nil })
+	if !errors.Is(err, ErrInvalidCompressedPayload) {
+		t.Fatalf("expected ErrInvalidCompressedPayload got=%v", err)
+	}
+}
@@ -17,6 +17,8 @@ var (
 
 	ErrMessageTooBig = errors.New("message too big")
 
+	ErrTooManyFragments = errors.New("message has too many fragments")
+
 	ErrInvalidControlFrame = errors.New("invalid control frame")
 
 	ErrControlFrameTooBig = errors.New("control frame too big")
@@ -38,4 +40,18 @@ var (
 	ErrExpectedContinuation = errors.New("expected continue frame")
 
 	ErrInvalidAddress = errors.New("invalid address")
+
+	ErrUnexpectedMessageType = errors.New("unexpected message type")
+
+	ErrRateLimited = errors.New("inbound message rate limit exceeded")
+
+	ErrInvalidCompressedPayload = errors.New("invalid compressed payload")
+
+	ErrInvalidUTF8 = errors.New("invalid UTF-8 in text message")
+
+	ErrInvalidClosePayload = errors.New("invalid close frame payload")
+
+	ErrKeepAliveTimeout = errors.New("no pong received in time")
+
+	ErrProxyConnect = errors.New("proxy refused to open the tunnel")
 )
@@ -15,6 +15,11 @@ type Frame struct {
 	header  []byte
 	mask    []byte
 	payload []byte
+
+	// Set when the payload is shared with other frames, in which case own
+	// holds the frame's own payload buffer until the frame is reset.
+	shared *RefCountedPayload
+	own    []byte
 }
 
 func NewFrame() *Frame {
@@ -29,6 +34,11 @@ func NewFrame() *Frame {
 func (f *Frame) Reset() {
 	copy(f.header, zeroBytes)
 	copy(f.mask, zeroBytes)
+	if f.shared != nil {
+		f.shared.Release()
+		f.shared = nil
+		f.payload, f.own = f.own, nil
+	}
 	f.payload = f.payload[:0]
 }
 
@@ -241,6 +251,19 @@ func (f *Frame) SetPayload(b []byte) {
 	f.payload = append(f.payload[:0], b...)
 }
 
+// setSharedPayload makes the frame reference the shared payload, without
+// copying it, until the frame is reset. The frame holds a reference on p.
+func (f *Frame) setSharedPayload(p *RefCountedPayload) {
+	p.Retain()
+	if f.shared == nil {
+		f.own = f.payload
+	} else {
+		f.shared.Release()
+	}
+	f.shared = p
+	f.payload = p.Bytes()
+}
+
 func (f *Frame) MaskKey() []byte {
 	return f.mask[:]
 }
@@ -250,10 +273,16 @@ func (f *Frame) Payload() []byte {
 }
 
 func (f *Frame) Mask() {
-	f.header[1] |= maskBit
 	GenMask(f.mask[:])
+	f.MaskWith(f.mask[:])
+}
+
+// MaskWith masks the payload of the frame with the given 4 byte key.
+func (f *Frame) MaskWith(key []byte) {
+	f.header[1] |= maskBit
+	copy(f.mask[:4], key)
 	if len(f.payload) > 0 {
-		Mask(f.mask[:], f.payload)
+		Mask(f.mask[:4], f.payload)
 	}
 }
 
@@ -21,6 +21,8 @@ type FrameCodec struct {
 	decodeFrame *Frame // frame we decode into
 	decodeBytes int    // number of bytes of the last successfully decoded frame
 	decodeReset bool   // true if we must reset the state on the next decode
+
+	maxPayload int // the maximum payload length of a decoded frame, see SetMaxPayloadLen
 }
 
 func NewFrameCodec(src, dst *sonic.ByteBuffer) *FrameCodec {
@@ -31,6 +33,21 @@ func NewFrameCodec(src, dst *sonic.ByteBuffer) *FrameCodec {
 	}
 }
 
+// SetMaxPayloadLen bounds the payload length of the decoded frames. A frame
+// advertising a longer payload fails to decode with ErrPayloadOverMaxSize as
+// soon as its header is decoded, before any of its payload is buffered. The
+// default, and a bound of 0 or less, is MaxMessageSize.
+func (c *FrameCodec) SetMaxPayloadLen(n int) {
+	c.maxPayload = n
+}
+
+func (c *FrameCodec) maxPayloadLen() int {
+	if c.maxPayload <= 0 {
+		return MaxMessageSize
+	}
+	return c.maxPayload
+}
+
 func (c *FrameCodec) resetDecode() {
 	if c.decodeReset {
 		c.decodeReset = false
@@ -59,32 +76,41 @@ func (c *FrameCodec) resetDecode() {
 func (c *FrameCodec) Decode(src *sonic.ByteBuffer) (*Frame, error) {
 	c.resetDecode()
 
-	n := 2
-	err := src.PrepareRead(n)
+	// The bytes of an incomplete frame are left uncommitted, such that the
+	// next Decode starts from the beginning of the frame.
+	mark := src.Mark()
+	fr, err := c.decode(src)
 	if err != nil {
+		_ = src.Rollback(mark)
 		return nil, err
 	}
-	c.decodeFrame.header = src.Data()[:n]
+	return fr, nil
+}
 
-	// read extra header length
-	n += c.decodeFrame.ExtraHeaderLen()
-	if err := src.PrepareRead(n); err != nil {
-		return nil, err
+// peekHeader decodes the header of the next frame in src without consuming it
+// and without waiting for the payload. It returns the frame, whose header and
+// mask point into src, and the length of the header including the mask.
+func (c *FrameCodec) peekHeader(src *sonic.ByteBuffer) (*Frame, int, error) {
+	c.resetDecode()
+
+	mark := src.Mark()
+	n, err := c.decodeHeader(src)
+	_ = src.Rollback(mark)
+	if err != nil {
+		return nil, 0, err
 	}
-	c.decodeFrame.header = src.Data()[:n]
+	return c.decodeFrame, n, nil
+}
 
-	// read mask if any
-	if c.decodeFrame.IsMasked() {
-		n += 4
-		if err := src.PrepareRead(n); err != nil {
-			return nil, err
-		}
-		c.decodeFrame.mask = src.Data()[n-4 : n]
+func (c *FrameCodec) decode(src *sonic.ByteBuffer) (*Frame, error) {
+	n, err := c.decodeHeader(src)
+	if err != nil {
+		return nil, err
 	}
 
 	// check payload length
 	npayload := c.decodeFrame.PayloadLen()
-	if npayload > MaxMessageSize {
+	if npayload > c.maxPayloadLen() {
 		return nil, ErrPayloadOverMaxSize
 	}
 
@@ -105,6 +131,34 @@ func (c *FrameCodec) Decode(src *sonic.ByteBuffer) (*Frame, error) {
 	return c.decodeFrame, nil
 }
 
+// decodeHeader commits the header of the next frame, including the mask, and
+// returns its length.
+func (c *FrameCodec) decodeHeader(src *sonic.ByteBuffer) (int, error) {
+	n := 2
+	if err := src.PrepareRead(n); err != nil {
+		return 0, err
+	}
+	c.decodeFrame.header = src.Data()[:n]
+
+	// read extra header length
+	n += c.decodeFrame.ExtraHeaderLen()
+	if err := src.PrepareRead(n); err != nil {
+		return 0, err
+	}
+	c.decodeFrame.header = src.Data()[:n]
+
+	// read mask if any
+	if c.decodeFrame.IsMasked() {
+		n += 4
+		if err := src.PrepareRead(n); err != nil {
+			return 0, err
+		}
+		c.decodeFrame.mask = src.Data()[n-4 : n]
+	}
+
+	return n, nil
+}
+
 // Encode encodes the frame and place the raw bytes into `dst`.
 func (c *FrameCodec) Encode(fr *Frame, dst *sonic.ByteBuffer) error {
 	// Make sure there is enough space in the buffer to hold the serialized
@@ -28,6 +28,9 @@ func TestDecodeShortFrame(t *testing.T) {
 	if codec.decodeBytes != 0 {
 		t.Fatal("should have not decoded any bytes")
 	}
+	if src.ReadLen() != 0 || src.WriteLen() != 2 {
+		t.Fatal("the bytes of the short frame should not be committed")
+	}
 }
 
 func TestDecodeExactlyOneFrame(t *testing.T) {
@@ -153,10 +156,10 @@ func TestDecodeTwoFrames(t *testing.T) {
 	if f != nil {
 		t.Fatal("should not have gotten a frame")
 	}
-	if src.ReadLen() != 2 {
-		t.Fatal("should have 2 bytes in the read area")
+	if src.ReadLen() != 0 {
+		t.Fatal("should have 0 bytes in the read area")
 	}
-	if src.WriteLen() != 0 {
-		t.Fatal("should have 0 bytes in the write area")
+	if src.WriteLen() != 2 {
+		t.Fatal("should have 2 bytes in the write area")
 	}
 }
@@ -0,0 +1,39 @@
+package websocket
+
+// smallHeaders caches the encoded headers of final unmasked frames whose
+// payload length fits in the fixed size header, indexed by opcode and payload
+// length. Servers send such frames the most, pong replies included.
+var smallHeaders [16][126][2]byte
+
+func init() {
+	for opcode := range smallHeaders {
+		for n := range smallHeaders[opcode] {
+			smallHeaders[opcode][n] = [2]byte{finBit | byte(opcode), byte(n)}
+		}
+	}
+}
+
+// encodedHeader returns the encoded header of an outgoing frame, without the
+// mask key. The headers of small final unmasked frames come from smallHeaders,
+// the others are encoded in the frame, as WriteTo does.
+func (f *Frame) encodedHeader() []byte {
+	if n := len(f.payload); n <= 125 &&
+		f.header[0]&^15 == finBit &&
+		f.header[1]&maskBit == 0 {
+		return smallHeaders[f.header[0]&15][n][:]
+	}
+	return f.header[:2+f.SetPayloadLen()]
+}
+
+// vectored appends the buffers holding the encoded frame to bufs, such that
+// the frame is written without being copied.
+func (f *Frame) vectored(bufs [][]byte) [][]byte {
+	bufs = append(bufs, f.encodedHeader())
+	if f.IsMasked() {
+		bufs = append(bufs, f.mask[:4])
+	}
+	if len(f.payload) > 0 {
+		bufs = append(bufs, f.payload)
+	}
+	return bufs
+}
@@ -0,0 +1,93 @@
+package websocket
+
+import (
+	"github.com/csdenboer/sonic"
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+// FrameParser parses the WebSocket frames of an arbitrary stream of bytes,
+// without a Stream or a transport, such that tools like sniffers and test
+// harnesses can parse captured traffic. Bytes are pushed into the parser as
+// they come, in chunks of any size, and frames are pulled out of it once
+// complete:
+//
+//	p := websocket.NewFrameParser()
+//	err := p.Feed(chunk, func(f *websocket.Frame) error {
+//		fmt.Println(f)
+//		return nil
+//	})
+//
+// The parser does not verify the frames beyond their encoding: the frames of
+// both directions of a connection can be parsed, masked or not. Masked
+// payloads are left masked, see Frame.Unmask.
+//
+// A frame returned by the parser points into the parser's buffer. It is valid
+// until the next call to a method of the parser, and must be copied to be kept
+// longer.
+type FrameParser struct {
+	buf   *sonic.ByteBuffer
+	codec *FrameCodec
+}
+
+// NewFrameParser creates a FrameParser with an empty buffer.
+func NewFrameParser() *FrameParser {
+	buf := sonic.NewByteBuffer()
+	return &FrameParser{
+		buf:   buf,
+		codec: NewFrameCodec(buf, nil),
+	}
+}
+
+// Write appends b to the bytes to be parsed. It never fails.
+func (p *FrameParser) Write(b []byte) (int, error) {
+	return p.buf.Write(b)
+}
+
+// Next returns the next complete frame. sonicerrors.ErrNeedMore is returned if
+// the bytes written so far do not hold a complete frame, in which case more
+// bytes must be written before calling Next again.
+//
+// Any other error means the stream is corrupt, for example because a frame is
+// bigger than MaxMessageSize. The parser must then be Reset before parsing
+// anything else.
+func (p *FrameParser) Next() (*Frame, error) {
+	return p.codec.Decode(p.buf)
+}
+
+// Feed writes b and invokes fn with every frame which is complete afterwards,
+// in order. It returns nil once the remaining bytes do not hold a complete
+// frame, which stay buffered until the next Feed, or the first error of
+// either the parser or fn.
+func (p *FrameParser) Feed(b []byte, fn func(f *Frame) error) error {
+	_, _ = p.Write(b)
+	for {
+		f, err := p.Next()
+		if err == sonicerrors.ErrNeedMore {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+}
+
+// Buffered returns the number of bytes written and not yet parsed into a
+// frame, such as the bytes of an incomplete frame.
+func (p *FrameParser) Buffered() int {
+	n := p.buf.ReadLen() + p.buf.WriteLen()
+	if p.codec.decodeReset {
+		n -= p.codec.decodeBytes
+	}
+	return n
+}
+
+// Reset discards the buffered bytes, such that the parser can parse another
+// stream.
+func (p *FrameParser) Reset() {
+	p.buf.Reset()
+	p.codec.decodeReset = false
+	p.codec.decodeBytes = 0
+}
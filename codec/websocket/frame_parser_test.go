@@ -0,0 +1,104 @@
+package websocket
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+func TestFrameParserFeed(t *testing.T) {
+	var stream bytes.Buffer
+
+	payloads := [][]byte{[]byte("hello"), {}, bytes.Repeat([]byte{'x'}, 1024)}
+	for i, payload := range payloads {
+		f := AcquireFrame()
+		f.SetFin()
+		f.SetText()
+		f.SetPayload(payload)
+		if i%2 == 1 {
+			f.Mask()
+		}
+		if _, err := f.WriteTo(&stream); err != nil {
+			t.Fatal(err)
+		}
+		ReleaseFrame(f)
+	}
+
+	// Feed the bytes in chunks which split the frames anywhere.
+	p := NewFrameParser()
+	var got [][]byte
+	raw := stream.Bytes()
+	for len(raw) > 0 {
+		n := 3
+		if n > len(raw) {
+			n = len(raw)
+		}
+		err := p.Feed(raw[:n], func(f *Frame) error {
+			if !f.IsFin() || !f.IsText() {
+				t.Fatalf("wrong frame %s", f)
+			}
+			if f.IsMasked() {
+				f.Unmask()
+			}
+			got = append(got, append([]byte{}, f.Payload()...))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw = raw[n:]
+	}
+
+	if len(got) != len(payloads) {
+		t.Fatalf("expected %d frames got=%d", len(payloads), len(got))
+	}
+	for i := range payloads {
+		if !bytes.Equal(got[i], payloads[i]) {
+			t.Fatalf("wrong payload of frame %d", i)
+		}
+	}
+	if p.Buffered() != 0 {
+		t.Fatalf("expected no buffered bytes got=%d", p.Buffered())
+	}
+}
+
+func TestFrameParserNext(t *testing.T) {
+	p := NewFrameParser()
+
+	p.Write([]byte{0x89, 2, 0x01}) // fin=1 opcode=9 (ping) payload_len=2
+	if _, err := p.Next(); err != sonicerrors.ErrNeedMore {
+		t.Fatalf("expected ErrNeedMore got=%v", err)
+	}
+	if p.Buffered() != 3 {
+		t.Fatalf("expected 3 buffered bytes got=%d", p.Buffered())
+	}
+
+	p.Write([]byte{0x02, 0x81})
+	f, err := p.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.IsPing() || !bytes.Equal(f.Payload(), []byte{0x01, 0x02}) {
+		t.Fatalf("wrong frame %s", f)
+	}
+	if p.Buffered() != 1 {
+		t.Fatalf("expected 1 buffered byte got=%d", p.Buffered())
+	}
+
+	// A frame bigger than MaxMessageSize corrupts the stream.
+	p.Reset()
+	p.Write([]byte{0x82, 127, 0, 0, 0, 0, 0xFF, 0, 0, 0})
+	if _, err := p.Next(); err != ErrPayloadOverMaxSize {
+		t.Fatalf("expected ErrPayloadOverMaxSize got=%v", err)
+	}
+
+	p.Reset()
+	if p.Buffered() != 0 {
+		t.Fatalf("expected no buffered bytes got=%d", p.Buffered())
+	}
+	p.Write([]byte{0x88, 0})
+	if f, err := p.Next(); err != nil || !f.IsClose() {
+		t.Fatalf("expected a close frame got=%v %v", f, err)
+	}
+}
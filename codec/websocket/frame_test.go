@@ -160,3 +160,63 @@ func genRandBytes(n int) []byte {
 	rand.Read(b)
 	return b
 }
+
+func TestFrameVectoredMatchesWriteTo(t *testing.T) {
+	cases := []struct {
+		name    string
+		setup   func(f *Frame)
+		payload int
+		masked  bool
+	}{
+		{"small text", func(f *Frame) { f.SetFin(); f.SetText() }, 5, false},
+		{"empty pong", func(f *Frame) { f.SetFin(); f.SetPong() }, 0, false},
+		{"largest small binary", func(f *Frame) { f.SetFin(); f.SetBinary() }, 125, false},
+		{"extended length", func(f *Frame) { f.SetFin(); f.SetBinary() }, 200, false},
+		{"fragment", func(f *Frame) { f.SetText() }, 5, false},
+		{"masked", func(f *Frame) { f.SetFin(); f.SetText() }, 5, true},
+	}
+
+	for _, c := range cases {
+		payload := genRandBytes(c.payload)
+
+		expected := NewFrame()
+		c.setup(expected)
+		expected.SetPayload(payload)
+		if c.masked {
+			expected.Mask()
+		}
+		var want bytes.Buffer
+		if _, err := expected.WriteTo(&want); err != nil {
+			t.Fatal(err)
+		}
+
+		f := NewFrame()
+		c.setup(f)
+		f.SetPayload(payload)
+		if c.masked {
+			f.Mask()
+			copy(f.mask, expected.mask)
+			copy(f.payload, expected.payload)
+		}
+		got := bytes.Join(f.vectored(nil), nil)
+
+		if !bytes.Equal(got, want.Bytes()) {
+			t.Fatalf("%s: expected %x got=%x", c.name, want.Bytes(), got)
+		}
+	}
+}
+
+func TestFrameEncodedHeaderDoesNotAllocate(t *testing.T) {
+	f := NewFrame()
+	f.SetFin()
+	f.SetText()
+	f.SetPayload([]byte("hello"))
+
+	var iov [3][]byte
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = f.vectored(iov[:0])
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations got=%v", allocs)
+	}
+}
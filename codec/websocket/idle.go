@@ -0,0 +1,163 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/csdenboer/sonic"
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+const (
+	// bufferSize is the capacity the read and write buffers of a stream start
+	// with, and the capacity of the buffers handed out by bufferPool.
+	bufferSize = 4096
+
+	// maxPooledBufferSize bounds the capacity of the buffers put back into
+	// bufferPool. Bigger buffers, grown by big messages, are left to the GC
+	// such that a parked stream wakes up with a buffer of the usual size.
+	maxPooledBufferSize = 4 * bufferSize
+)
+
+// bufferPool holds the backing arrays of the buffers released by idle
+// streams. See SetIdleRelease.
+var bufferPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, bufferSize)
+		return &b
+	},
+}
+
+func getBuffer() []byte {
+	return *(bufferPool.Get().(*[]byte))
+}
+
+func putBuffer(b []byte) {
+	if cap(b) <= maxPooledBufferSize {
+		bufferPool.Put(&b)
+	}
+}
+
+// SetIdleRelease makes the stream release its read and write buffers back to a
+// pool after it has neither read nor written for at least d. The buffers are
+// taken back from the pool on the next read or write.
+//
+// A stream waiting in AsyncNextFrame or AsyncNextMessage releases its read
+// buffer too: the outstanding read is cancelled internally and replaced by
+// a one byte read which does not need the buffer. The caller's callback is
+// not invoked until the peer sends something. Buffers which hold bytes, such
+// as the bytes of a partially received frame, are never released.
+//
+// By default, or if d <= 0, the buffers are never released.
+func (s *WebsocketStream) SetIdleRelease(d time.Duration) error {
+	s.idleRelease = d
+	if d <= 0 {
+		if s.idleTimer != nil {
+			_ = s.idleTimer.Close()
+			s.idleTimer = nil
+		}
+		return nil
+	}
+
+	if s.idleTimer == nil {
+		timer, err := sonic.NewTimer(s.ioc)
+		if err != nil {
+			return err
+		}
+		s.idleTimer = timer
+	} else {
+		_ = s.idleTimer.Cancel()
+	}
+
+	// The stream is released on the first tick during which it stayed idle,
+	// so after at least d and at most 2*d of inactivity.
+	s.active = true
+	return s.idleTimer.ScheduleRepeating(d, s.checkIdle)
+}
+
+// IdleRelease returns the duration set with SetIdleRelease.
+func (s *WebsocketStream) IdleRelease() time.Duration {
+	return s.idleRelease
+}
+
+func (s *WebsocketStream) checkIdle() {
+	if s.active {
+		s.active = false
+		return
+	}
+	if s.state != StateActive && s.state != StateClosedByUs {
+		return
+	}
+	if s.flushing || len(s.pending) > 0 {
+		return
+	}
+
+	if s.reading && s.src.Len() == 0 && s.src.Cap() > 0 {
+		// Cancelling hands the outstanding read back to asyncNextFrame which
+		// then waits on the probe instead. Nothing else is outstanding, so
+		// nothing else is cancelled.
+		s.parking = true
+		s.stream.Cancel()
+		if s.parking {
+			// The read was not waiting on the IO, it is about to complete.
+			s.parking = false
+			return
+		}
+	}
+	s.releaseBuffers()
+}
+
+func (s *WebsocketStream) releaseBuffers() {
+	if b := s.dst.Detach(); b != nil {
+		putBuffer(b)
+	}
+	if !s.reading || s.probing {
+		if b := s.src.Detach(); b != nil {
+			putBuffer(b)
+		}
+	}
+	s.accountMemory()
+}
+
+// acquireBuffer takes a buffer back from the pool if b was released.
+func (s *WebsocketStream) acquireBuffer(b *sonic.ByteBuffer) {
+	s.active = true
+	if b.Cap() == 0 && b.Attach(getBuffer()) {
+		s.accountMemory()
+	}
+}
+
+// asyncProbe waits for the peer on a one byte read while the read buffer is
+// released. The byte is handed to the read buffer once it is taken back.
+func (s *WebsocketStream) asyncProbe(cb AsyncFrameHandler) {
+	s.reading, s.probing = true, true
+	s.stream.AsyncRead(s.probe[:], func(err error, n int) {
+		s.reading, s.probing = false, false
+
+		s.acquireBuffer(s.src)
+		if n > 0 {
+			_, _ = s.src.Write(s.probe[:n])
+		}
+
+		if err == nil {
+			s.asyncNextFrame(cb)
+			return
+		}
+		if errors.Is(err, io.EOF) {
+			s.state = StateTerminated
+		}
+		cb(err, nil)
+	})
+}
+
+// cancelledByPark returns true if the cancellation of an outstanding read was
+// requested by checkIdle, in which case the read is replaced by a probe.
+func (s *WebsocketStream) cancelledByPark(err error) bool {
+	if s.parking && errors.Is(err, sonicerrors.ErrCancelled) {
+		s.parking = false
+		return true
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/csdenboer/sonic"
+)
+
+// keepAlive pings the peer of a stream, see SetKeepAlive.
+type keepAlive struct {
+	interval time.Duration
+	timeout  time.Duration
+	timer    *sonic.Timer
+
+	// awaiting is true from the moment a ping is sent until a pong arrives.
+	awaiting bool
+
+	// expired is true once the stream is terminated because no pong arrived
+	// in time.
+	expired bool
+
+	onPing, onTimeout func()
+}
+
+// SetKeepAlive makes the stream ping its peer every interval, on a timer of
+// its IO, and terminate the connection if no pong arrives within timeout of a
+// ping. A timeout which is not positive is the same as interval.
+//
+// The next ping is sent interval after the pong of the previous one. Any pong
+// counts, including unsolicited ones. Once the connection is terminated, the
+// outstanding read, or the next one, fails with ErrKeepAliveTimeout. The
+// connection is only monitored while the stream is active and the IO runs, so
+// it is meant for streams read with AsyncNextFrame or AsyncNextMessage.
+//
+// By default, or if interval <= 0, the stream does not ping its peer.
+func (s *WebsocketStream) SetKeepAlive(interval, timeout time.Duration) error {
+	k := &s.keepAlive
+	if timeout <= 0 {
+		timeout = interval
+	}
+	k.interval, k.timeout = interval, timeout
+	k.awaiting = false
+
+	if interval <= 0 {
+		if k.timer != nil {
+			_ = k.timer.Close()
+			k.timer = nil
+		}
+		return nil
+	}
+
+	if k.timer == nil {
+		timer, err := sonic.NewTimer(s.ioc)
+		if err != nil {
+			return err
+		}
+		k.timer = timer
+		k.onPing = s.keepAlivePing
+		k.onTimeout = s.keepAliveExpire
+	} else {
+		_ = k.timer.Cancel()
+	}
+	return k.timer.ScheduleOnce(interval, k.onPing)
+}
+
+// KeepAlive returns the interval and the timeout set with SetKeepAlive.
+func (s *WebsocketStream) KeepAlive() (interval, timeout time.Duration) {
+	return s.keepAlive.interval, s.keepAlive.timeout
+}
+
+func (s *WebsocketStream) keepAlivePing() {
+	if s.state != StateActive {
+		return
+	}
+
+	f := AcquireFrame()
+	f.SetFin()
+	f.SetPing()
+	s.prepareWrite(f)
+	s.AsyncFlush(func(error) {})
+
+	s.keepAlive.awaiting = true
+	_ = s.keepAlive.timer.ScheduleOnce(s.keepAlive.timeout, s.keepAlive.onTimeout)
+}
+
+// keepAlivePong schedules the next ping once a pong arrives.
+func (s *WebsocketStream) keepAlivePong() {
+	k := &s.keepAlive
+	if !k.awaiting {
+		return
+	}
+	k.awaiting = false
+	_ = k.timer.Cancel()
+	_ = k.timer.ScheduleOnce(k.interval, k.onPing)
+}
+
+// keepAliveExpire terminates the connection, which fails the outstanding read
+// with ErrKeepAliveTimeout.
+func (s *WebsocketStream) keepAliveExpire() {
+	if !s.keepAlive.awaiting || s.state == StateTerminated {
+		return
+	}
+	s.keepAlive.expired = true
+	s.state = StateTerminated
+	if s.stream != nil {
+		s.stream.Cancel()
+	}
+	_ = s.CloseNextLayer()
+}
+
+// keepAliveErr returns ErrKeepAliveTimeout in place of err if the connection
+// was terminated because no pong arrived in time.
+func (s *WebsocketStream) keepAliveErr(err error) error {
+	if err != nil && s.keepAlive.expired {
+		return ErrKeepAliveTimeout
+	}
+	return err
+}
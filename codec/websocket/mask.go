@@ -0,0 +1,107 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+// MaskPolicy tells a client stream how often it generates a new masking key.
+//
+// RFC 6455 requires each frame sent by a client to be masked with a fresh,
+// unpredictable key. Masking does not protect the payload, which can be
+// unmasked by anyone who reads the frame. Its purpose is to keep a script
+// running in the client, such as JavaScript in a browser, from choosing the
+// bytes that go on the wire, which could otherwise be crafted to look like
+// HTTP requests and poison the caches of intermediaries which do not speak
+// the websocket protocol.
+//
+// Applications which do not send payloads chosen by untrusted parties, or
+// which only talk to known servers over TLS, can trade some of that
+// protection for speed with MaskPerBurst.
+type MaskPolicy uint8
+
+const (
+	// MaskPerFrame generates a new masking key for every frame. This is the
+	// default and what RFC 6455 mandates.
+	MaskPerFrame MaskPolicy = iota
+
+	// MaskPerBurst generates one masking key per burst, the frames written
+	// between two flushes, and masks all frames of the burst with it. Anyone
+	// who controls the payloads of a burst can predict the masked bytes of
+	// all frames of the burst after the first.
+	MaskPerBurst
+)
+
+func (p MaskPolicy) String() string {
+	switch p {
+	case MaskPerFrame:
+		return "mask_per_frame"
+	case MaskPerBurst:
+		return "mask_per_burst"
+	default:
+		return fmt.Sprintf("mask_policy(%d)", uint8(p))
+	}
+}
+
+// SetMaskKeySource sets the source of the masking keys of the frames written
+// by a client stream. Keys are read 4 bytes at a time. If reading from r
+// fails, the key is read from crypto/rand instead.
+//
+// By default, or if r is nil, keys are read from crypto/rand. A deterministic
+// source, such as a math/rand.Rand with a fixed seed, makes the bytes written
+// by the stream reproducible in tests. A faster, non-cryptographic source
+// makes the keys predictable, with the same tradeoffs as MaskPerBurst.
+//
+// Server streams do not mask frames and never read from r.
+func (s *WebsocketStream) SetMaskKeySource(r io.Reader) {
+	s.maskKeySource = r
+}
+
+// MaskKeySource returns the source set with SetMaskKeySource; nil means
+// crypto/rand.
+func (s *WebsocketStream) MaskKeySource() io.Reader {
+	return s.maskKeySource
+}
+
+// SetMaskPolicy sets how often a client stream generates a new masking key.
+// See MaskPolicy for the tradeoffs. The default is MaskPerFrame.
+func (s *WebsocketStream) SetMaskPolicy(p MaskPolicy) {
+	s.maskPolicy = p
+	s.burstKeyValid = false
+}
+
+// MaskPolicy returns the policy set with SetMaskPolicy.
+func (s *WebsocketStream) MaskPolicy() MaskPolicy {
+	return s.maskPolicy
+}
+
+// mask masks the payload of f with a key chosen according to the mask policy
+// of the stream.
+func (s *WebsocketStream) mask(f *Frame) {
+	if s.maskPolicy == MaskPerBurst {
+		if !s.burstKeyValid {
+			s.genMaskKey(s.burstKey[:])
+			s.burstKeyValid = true
+		}
+		f.MaskWith(s.burstKey[:])
+		return
+	}
+
+	s.genMaskKey(f.mask[:4])
+	f.MaskWith(f.mask[:4])
+}
+
+func (s *WebsocketStream) genMaskKey(b []byte) {
+	if s.maskKeySource != nil {
+		if _, err := io.ReadFull(s.maskKeySource, b); err == nil {
+			return
+		}
+	}
+	_, _ = rand.Read(b)
+}
+
+// endBurst makes the next frame masked under MaskPerBurst use a new key.
+func (s *WebsocketStream) endBurst() {
+	s.burstKeyValid = false
+}
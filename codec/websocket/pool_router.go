@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/csdenboer/sonic"
+)
+
+// PoolRouter is an UpgradeRouter serving its connections on the IOs of a
+// sonic.IOPool. Each accepted connection is handed over to an IO of the pool,
+// picked by the policy or the affinity of the pool, see
+// sonic.IOPool.SetAffinity. Its handshake, its stream, the buffers and the
+// timers of the stream are then created on that IO, so all the callbacks of
+// the connection run on the goroutine of that IO.
+//
+// Serve is a sonic.ConnHandler, so the router is the handler of a
+// sonic.Acceptor, which runs on an IO of its own:
+//
+//	router := websocket.NewPoolRouter(pool)
+//	router.Handle("/feed", websocket.HandshakePolicy{}, onFeed)
+//	sonic.NewAcceptor(ln, router.Serve, onError).Start()
+//
+// The PoolRouter holds an UpgradeRouter per IO of the pool. The limits of a
+// route, like MaxConns, and its counters apply per IO, see Router. The routes
+// and the settings of the PoolRouter must be set before it serves
+// connections.
+type PoolRouter struct {
+	pool    *sonic.IOPool
+	routers []*UpgradeRouter
+	onError func(err error)
+}
+
+// NewPoolRouter creates a PoolRouter serving its connections on the IOs of
+// pool.
+func NewPoolRouter(pool *sonic.IOPool) *PoolRouter {
+	r := &PoolRouter{pool: pool}
+	for i := 0; i < pool.Size(); i++ {
+		r.routers = append(r.routers, NewUpgradeRouter(pool.IO(i)))
+	}
+	return r
+}
+
+// Router returns the UpgradeRouter of the IO with the given index, which must
+// only be used from the goroutine of that IO, for example to read the counters
+// of its routes.
+func (r *PoolRouter) Router(i int) *UpgradeRouter {
+	return r.routers[i]
+}
+
+// Handle registers the handler of the upgrade requests for path on the
+// routers of all the IOs, see UpgradeRouter.Handle. The handler is invoked on
+// the goroutine of the IO of the stream.
+func (r *PoolRouter) Handle(path string, policy HandshakePolicy, handler UpgradeHandler) {
+	for _, router := range r.routers {
+		router.Handle(path, policy, handler)
+	}
+}
+
+// SetMaxRequestSize bounds the size of an upgrade request, see
+// UpgradeRouter.SetMaxRequestSize.
+func (r *PoolRouter) SetMaxRequestSize(n int) {
+	for _, router := range r.routers {
+		router.SetMaxRequestSize(n)
+	}
+}
+
+// SetHandshakeTimeout bounds the time a connection has to send its upgrade
+// request, see UpgradeRouter.SetHandshakeTimeout.
+func (r *PoolRouter) SetHandshakeTimeout(timeout time.Duration) {
+	for _, router := range r.routers {
+		router.SetHandshakeTimeout(timeout)
+	}
+}
+
+// SetErrorHandler sets a handler invoked with the reason of every failed
+// handshake, and of every connection which cannot be handed over to an IO of
+// the pool. It is invoked on the goroutine of the IO the handshake runs on, or
+// of the Acceptor if the connection cannot be handed over, so it must be safe
+// to call concurrently.
+func (r *PoolRouter) SetErrorHandler(onError func(err error)) {
+	r.onError = onError
+	for _, router := range r.routers {
+		router.SetErrorHandler(onError)
+	}
+}
+
+// Serve hands conn over to an IO of the pool, on which its handshake is
+// performed, see UpgradeRouter.Serve. conn is closed if it cannot be handed
+// over.
+func (r *PoolRouter) Serve(conn sonic.Conn) {
+	_, err := r.pool.AdoptIndexed(conn, func(i int, conn sonic.Conn) {
+		r.routers[i].Serve(conn)
+	})
+	if err != nil {
+		_ = conn.Close()
+		if r.onError != nil {
+			r.onError(err)
+		}
+	}
+}
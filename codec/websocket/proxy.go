@@ -0,0 +1,175 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ProxyFunc returns the URL of the proxy through which a client stream dials
+// target, whose scheme is http for ws:// addresses and https for wss://
+// addresses, or nil to dial target directly.
+type ProxyFunc func(target *url.URL) (*url.URL, error)
+
+// ProxyURL returns a ProxyFunc dialing every address through proxy.
+func ProxyURL(proxy *url.URL) ProxyFunc {
+	return func(*url.URL) (*url.URL, error) {
+		return proxy, nil
+	}
+}
+
+// ProxyFromEnvironment is a ProxyFunc honoring the HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables, like http.ProxyFromEnvironment: ws://
+// addresses are dialed through HTTP_PROXY and wss:// addresses through
+// HTTPS_PROXY. As with net/http, requests to localhost are never proxied.
+func ProxyFromEnvironment(target *url.URL) (*url.URL, error) {
+	return http.ProxyFromEnvironment(&http.Request{URL: target})
+}
+
+// SetProxy sets the function returning the proxy through which the next
+// handshakes of a client stream dial their address. nil, the default, dials
+// every address directly.
+//
+// The stream connects to the proxy, over TLS if the scheme of the proxy URL is
+// https, and asks it to open a tunnel to the address with a CONNECT request,
+// authenticated with the user and password of the proxy URL, if any. The TLS
+// handshake of wss:// addresses and the upgrade then go through the tunnel.
+// The handshake fails with an error wrapping ErrProxyConnect if the proxy
+// refuses to open the tunnel.
+func (s *WebsocketStream) SetProxy(proxy ProxyFunc) {
+	s.proxy = proxy
+}
+
+// proxyFor returns the proxy through which target is dialed, or nil.
+func (s *WebsocketStream) proxyFor(target *url.URL) (*url.URL, error) {
+	if s.proxy == nil {
+		return nil, nil
+	}
+	return s.proxy(target)
+}
+
+// dialProxy dials target through a tunnel opened by proxy, and sets the
+// connection of the stream.
+func (s *WebsocketStream) dialProxy(
+	ctx context.Context,
+	target, proxy *url.URL,
+) (syscall.Conn, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	switch proxy.Scheme {
+	case "http":
+		conn, err = s.dialer.DialContext(ctx, "tcp", hostPort(proxy, "80"))
+	case "https":
+		cfg := &tls.Config{}
+		if s.tls != nil {
+			cfg = s.tls.Clone()
+		}
+		cfg.ServerName = proxy.Hostname()
+		dialer := &tls.Dialer{NetDialer: s.dialer, Config: cfg}
+		conn, err = dialer.DialContext(ctx, "tcp", hostPort(proxy, "443"))
+	default:
+		err = fmt.Errorf("invalid proxy scheme=%s", proxy.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	raw := conn
+	if tc, ok := conn.(*tls.Conn); ok {
+		raw = tc.NetConn()
+	}
+
+	if err = connectTunnel(ctx, conn, target, proxy.User); err == nil && target.Scheme == "https" {
+		if s.tls == nil {
+			err = fmt.Errorf("wss:// scheme endpoints require a TLS configuration")
+		} else {
+			cfg := s.tlsConfig()
+			if cfg.ServerName == "" {
+				cfg = cfg.Clone()
+				cfg.ServerName = target.Hostname()
+			}
+			tc := tls.Client(conn, cfg)
+			if err = tc.HandshakeContext(ctx); err == nil {
+				conn = tc
+			}
+		}
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	s.conn = conn
+	return raw.(syscall.Conn), nil
+}
+
+// connectTunnel asks the proxy at the other end of conn to open a tunnel to
+// target with a CONNECT request.
+func connectTunnel(
+	ctx context.Context,
+	conn net.Conn,
+	target *url.URL,
+	user *url.Userinfo,
+) error {
+	deadline := time.Now().Add(DialTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+	defer conn.SetDeadline(time.Time{})
+
+	port := "80"
+	if target.Scheme == "https" {
+		port = "443"
+	}
+	addr := hostPort(target, port)
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+
+	// The peer only talks once the upgrade, or the TLS handshake, is sent
+	// through the tunnel, so nothing follows the response.
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s", ErrProxyConnect, res.Status)
+	}
+	if br.Buffered() > 0 {
+		return fmt.Errorf("%w: unexpected bytes after the response", ErrProxyConnect)
+	}
+	return nil
+}
+
+// hostPort returns the host and port of u, or of u with the given port if it
+// has none.
+func hostPort(u *url.URL, port string) string {
+	if p := u.Port(); p != "" {
+		port = p
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
@@ -0,0 +1,153 @@
+package websocket
+
+import (
+	"io"
+	"time"
+
+	"github.com/csdenboer/sonic"
+)
+
+// RateLimitPolicy is what a stream does once its peer sends messages faster
+// than the rate set with SetInboundRateLimit.
+type RateLimitPolicy uint8
+
+const (
+	// RateLimitDelay delays the next read until the stream is back under its
+	// rate. The stream does not read from its connection in the meantime, so
+	// TCP flow control eventually slows the peer down.
+	RateLimitDelay RateLimitPolicy = iota
+
+	// RateLimitClose closes the stream with ClosePolicyError as soon as a
+	// message over the rate is received. The read fails with ErrRateLimited.
+	RateLimitClose
+)
+
+func (p RateLimitPolicy) String() string {
+	switch p {
+	case RateLimitDelay:
+		return "delay"
+	case RateLimitClose:
+		return "close"
+	default:
+		return "unknown"
+	}
+}
+
+// inboundLimiter is the token bucket bounding the rate of the messages a
+// stream receives. Each message takes a token, and tokens are added at rate
+// per second, up to burst.
+type inboundLimiter struct {
+	rate   float64 // 0 means no limit
+	burst  float64
+	policy RateLimitPolicy
+
+	tokens float64
+	last   time.Time
+
+	// Delays the reads under RateLimitDelay.
+	timer *sonic.Timer
+
+	// The number of delayed reads or rejected messages.
+	limited uint64
+}
+
+// SetInboundRateLimit bounds the rate of the messages received by the stream
+// to rate messages per second, with bursts of up to burst messages. Every data
+// message, whatever the number of its frames, and every control frame counts
+// as one message, such that a peer flooding the stream with pings is limited
+// too. policy is what the stream does once the peer goes over the rate.
+//
+// The stream starts with a full burst. By default, or if rate <= 0, the
+// inbound messages are not limited.
+func (s *WebsocketStream) SetInboundRateLimit(
+	rate float64,
+	burst int,
+	policy RateLimitPolicy,
+) {
+	if burst < 1 {
+		burst = 1
+	}
+	l := &s.inbound
+	l.rate = rate
+	l.burst = float64(burst)
+	l.policy = policy
+	l.tokens = l.burst
+	l.last = time.Now()
+}
+
+// InboundRateLimit returns the values set with SetInboundRateLimit.
+func (s *WebsocketStream) InboundRateLimit() (
+	rate float64,
+	burst int,
+	policy RateLimitPolicy,
+) {
+	return s.inbound.rate, int(s.inbound.burst), s.inbound.policy
+}
+
+// InboundRateLimited returns the number of reads delayed, or of messages
+// rejected, because the peer went over the rate set with SetInboundRateLimit.
+func (s *WebsocketStream) InboundRateLimited() uint64 {
+	return s.inbound.limited
+}
+
+func (l *inboundLimiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
+// delay returns how long the next read must wait for a token under
+// RateLimitDelay.
+func (l *inboundLimiter) delay() time.Duration {
+	if l.rate <= 0 || l.policy != RateLimitDelay {
+		return 0
+	}
+	l.refill(time.Now())
+	if l.tokens >= 1 {
+		return 0
+	}
+	l.limited++
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// take accounts the received frame f, which takes a token if it ends a data
+// message or if it is a control frame. It returns false if f is over the rate
+// under RateLimitClose.
+func (l *inboundLimiter) take(f *Frame) bool {
+	if l.rate <= 0 || !(f.IsControl() || f.IsFin()) {
+		return true
+	}
+	l.refill(time.Now())
+	if l.tokens < 1 && l.policy == RateLimitClose {
+		l.limited++
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// asyncDelayRead reads the next frame once d elapsed, see RateLimitDelay.
+func (s *WebsocketStream) asyncDelayRead(d time.Duration, cb AsyncFrameHandler) {
+	l := &s.inbound
+	if l.timer == nil {
+		timer, err := sonic.NewTimer(s.ioc)
+		if err != nil {
+			cb(err, nil)
+			return
+		}
+		l.timer = timer
+	}
+
+	err := l.timer.ScheduleOnce(d, func() {
+		if s.state.CanRead() {
+			s.asyncNextFrame(cb)
+		} else {
+			cb(io.EOF, nil)
+		}
+	})
+	if err != nil {
+		cb(err, nil)
+	}
+}
@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"errors"
+)
+
+// UnknownFramePolicy is how a stream handles the frames RFC 6455 leaves to
+// extensions: the frames with a reserved opcode and the frames with any of the
+// RSV1, RSV2 and RSV3 bits set. See SetUnknownFramePolicy.
+type UnknownFramePolicy uint8
+
+const (
+	// UnknownFrameStrict fails the read on such a frame, with
+	// ErrReservedOpcode, ErrInvalidControlFrame or ErrNonZeroReservedBits, and
+	// closes the stream with CloseProtocolError, as RFC 6455 mandates when no
+	// extension was negotiated. This is the default.
+	UnknownFrameStrict UnknownFramePolicy = iota
+
+	// UnknownFrameIgnore drops the frames with a reserved opcode and ignores
+	// the reserved bits of the other frames, which are then handled as usual.
+	UnknownFrameIgnore
+
+	// UnknownFrameDeliver hands such frames to the callback set with
+	// SetUnknownFramePolicy instead of handling them. They are neither
+	// returned by the reads nor part of a message.
+	UnknownFrameDeliver
+)
+
+func (p UnknownFramePolicy) String() string {
+	switch p {
+	case UnknownFrameStrict:
+		return "strict"
+	case UnknownFrameIgnore:
+		return "ignore"
+	case UnknownFrameDeliver:
+		return "deliver"
+	default:
+		return "unknown"
+	}
+}
+
+// UnknownFrameCallback is invoked with the frames delivered under
+// UnknownFrameDeliver. The frame is unmasked. It is only valid until the
+// callback returns, so its payload must be copied to be kept.
+type UnknownFrameCallback = func(f *Frame)
+
+// errSkipFrame is returned by handleFrame for a frame which the reads skip,
+// see UnknownFramePolicy.
+var errSkipFrame = errors.New("frame skipped")
+
+// SetUnknownFramePolicy sets how the stream handles the frames with a reserved
+// opcode or with reserved bits set, such that experimental extensions and
+// non-conforming peers can be handled without changing the parser. cb is only
+// used under UnknownFrameDeliver.
+//
+// Under a policy other than UnknownFrameStrict, the data frames too big to be
+// buffered by NextMessageSpill and AsyncNextMessageSpill, whose payload is
+// streamed, fail the read if their opcode is reserved, and have their reserved
+// bits ignored otherwise.
+func (s *WebsocketStream) SetUnknownFramePolicy(
+	policy UnknownFramePolicy,
+	cb UnknownFrameCallback,
+) {
+	s.unknownPolicy = policy
+	s.unknownCb = cb
+}
+
+// UnknownFramePolicy returns the policy set with SetUnknownFramePolicy.
+func (s *WebsocketStream) UnknownFramePolicy() UnknownFramePolicy {
+	return s.unknownPolicy
+}
+
+// hasUnknownBits returns true if f has reserved bits set which are not used
+// by a negotiated extension. permessage-deflate sets RSV1 on the first frame
+// of the compressed messages, see SetDeflate.
+func (s *WebsocketStream) hasUnknownBits(f *Frame) bool {
+	if f.IsRSV2() || f.IsRSV3() {
+		return true
+	}
+	return f.IsRSV1() && !(s.deflate.enabled && !f.IsControl() && !f.IsContinuation())
+}
+
+// skipUnknownFrame returns true if f is skipped by the reads, in which case it
+// has been handed to the callback under UnknownFrameDeliver.
+func (s *WebsocketStream) skipUnknownFrame(f *Frame) bool {
+	switch s.unknownPolicy {
+	case UnknownFrameIgnore:
+		return IsReserved(f.Opcode())
+	case UnknownFrameDeliver:
+		if !IsReserved(f.Opcode()) && !s.hasUnknownBits(f) {
+			return false
+		}
+		if s.unknownCb != nil {
+			s.unknownCb(f)
+		}
+		return true
+	default:
+		return false
+	}
+}
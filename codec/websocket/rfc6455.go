@@ -129,6 +129,20 @@ const (
 	CloseReserved3 CloseCode = 1015
 )
 
+// IsValidCloseCode returns true if cc can be sent in a close frame: a code
+// defined by RFC 6455 which is not reserved, or a code of the 3000-4999 range
+// used by libraries, frameworks and applications.
+func IsValidCloseCode(cc CloseCode) bool {
+	switch {
+	case cc >= CloseNormal && cc <= CloseUnknownData:
+		return true
+	case cc >= CloseBadPayload && cc <= CloseTryAgainLater:
+		return true
+	default:
+		return cc >= 3000 && cc <= 4999
+	}
+}
+
 func EncodeCloseCode(cc CloseCode) []byte {
 	b := make([]byte, 2)
 	binary.BigEndian.PutUint16(b, uint16(cc))
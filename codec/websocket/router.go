@@ -0,0 +1,379 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/csdenboer/sonic"
+)
+
+// DefaultMaxUpgradeRequestSize bounds the size of the upgrade requests read by
+// an UpgradeRouter, see SetMaxRequestSize.
+const DefaultMaxUpgradeRequestSize = 8 * 1024
+
+// UpgradeHandler handles the stream of a connection upgraded by an
+// UpgradeRouter. The stream is active and owned by the handler. req is the
+// upgrade request and subprotocol the subprotocol selected for the stream, or
+// the empty string if none was.
+type UpgradeHandler func(ws *WebsocketStream, req *http.Request, subprotocol string)
+
+// HandshakePolicy decides which upgrade requests a route of an UpgradeRouter
+// accepts, and how the handshake is answered.
+type HandshakePolicy struct {
+	// CheckOrigin vets the Origin header of the request. The request is
+	// rejected with 403 Forbidden if it returns false. All origins are
+	// accepted if it is nil.
+	CheckOrigin func(origin string) bool
+
+	// Authorize vets the request, for example its cookies or its query. The
+	// request is rejected with 403 Forbidden if it returns an error.
+	Authorize func(req *http.Request) error
+
+	// Subprotocols are the subprotocols the route speaks, in order of
+	// preference. The first one also offered by the client is selected. If
+	// none is, the handshake succeeds without a subprotocol, unless
+	// RequireSubprotocol is set, in which case the request is rejected with
+	// 400 Bad Request.
+	Subprotocols       []string
+	RequireSubprotocol bool
+
+	// Header holds the headers added to the 101 Switching Protocols response.
+	Header http.Header
+
+	// Deflate enables the permessage-deflate extension, see SetDeflate. The
+	// first offer of the client which the route can accept is accepted, and
+	// its ServerNoContextTakeover and ClientNoContextTakeover are imposed on
+	// the client. nil means the offers are declined.
+	Deflate *DeflateConfig
+
+	// MaxConns bounds the streams of the route open at the same time. Upgrade
+	// requests over the bound are rejected with 429 Too Many Requests. A
+	// stream is open until its next layer is closed, see CloseNextLayer. 0
+	// means no bound.
+	//
+	// The connections per remote IP address and the connections of all routes
+	// are bounded when they are accepted, see sonic.ConnLimiter.
+	MaxConns int
+}
+
+// RouteStats are the counters of a route of an UpgradeRouter.
+type RouteStats struct {
+	// Open is the number of streams of the route which are open.
+	Open int
+
+	// Upgraded counts the upgraded connections and Rejected the rejected
+	// upgrade requests, of which RejectedLimit were rejected because the
+	// route had MaxConns open streams.
+	Upgraded      uint64
+	Rejected      uint64
+	RejectedLimit uint64
+}
+
+type upgradeRoute struct {
+	policy  HandshakePolicy
+	handler UpgradeHandler
+	stats   RouteStats
+}
+
+// UpgradeRouter accepts the websocket handshakes of the connections it is
+// given and routes each upgraded stream to the handler registered for the
+// path of its upgrade request, such that several websocket services can share
+// a single port. Requests for an unknown path are answered with 404 Not Found.
+//
+// Serve is a sonic.ConnHandler, so the router is the handler of a
+// sonic.Acceptor:
+//
+//	router := websocket.NewUpgradeRouter(ioc)
+//	router.Handle("/feed", websocket.HandshakePolicy{}, onFeed)
+//	router.Handle("/orders", websocket.HandshakePolicy{Authorize: auth}, onOrders)
+//	sonic.NewAcceptor(ln, router.Serve, onError).Start()
+//
+// An UpgradeRouter must only be used from the goroutine running the IO.
+type UpgradeRouter struct {
+	ioc    *sonic.IO
+	routes map[string]*upgradeRoute
+
+	maxRequestSize int
+	timeout        time.Duration
+	onError        func(err error)
+}
+
+// NewUpgradeRouter creates an UpgradeRouter whose streams run on ioc.
+func NewUpgradeRouter(ioc *sonic.IO) *UpgradeRouter {
+	return &UpgradeRouter{
+		ioc:            ioc,
+		routes:         make(map[string]*upgradeRoute),
+		maxRequestSize: DefaultMaxUpgradeRequestSize,
+	}
+}
+
+// Handle registers the handler of the upgrade requests for path, which must
+// match the path of the request exactly. It replaces the handler previously
+// registered for path, if any.
+func (r *UpgradeRouter) Handle(path string, policy HandshakePolicy, handler UpgradeHandler) {
+	r.routes[path] = &upgradeRoute{policy: policy, handler: handler}
+}
+
+// Stats returns the counters of the route of path, and false if no route is
+// registered for it.
+func (r *UpgradeRouter) Stats(path string) (RouteStats, bool) {
+	route, ok := r.routes[path]
+	if !ok {
+		return RouteStats{}, false
+	}
+	return route.stats, true
+}
+
+// SetMaxRequestSize bounds the size of an upgrade request. Bigger requests are
+// rejected with 431 Request Header Fields Too Large.
+func (r *UpgradeRouter) SetMaxRequestSize(n int) {
+	if n <= 0 {
+		n = DefaultMaxUpgradeRequestSize
+	}
+	r.maxRequestSize = n
+}
+
+// SetHandshakeTimeout bounds the time a connection has to send its upgrade
+// request, after which it is closed. 0, the default, means no bound.
+func (r *UpgradeRouter) SetHandshakeTimeout(timeout time.Duration) {
+	r.timeout = timeout
+}
+
+// SetErrorHandler sets a handler invoked with the reason of every failed
+// handshake. The connection is closed by then.
+func (r *UpgradeRouter) SetErrorHandler(onError func(err error)) {
+	r.onError = onError
+}
+
+// Serve performs the websocket handshake of conn and hands the upgraded stream
+// to the handler of the requested path. The router owns conn until then: it
+// closes it if the handshake fails.
+func (r *UpgradeRouter) Serve(conn sonic.Conn) {
+	h := &routedHandshake{
+		r:    r,
+		conn: conn,
+		b:    make([]byte, r.maxRequestSize),
+	}
+
+	if r.timeout > 0 {
+		timer, err := sonic.NewTimer(r.ioc)
+		if err == nil {
+			err = timer.ScheduleOnce(r.timeout, func() {
+				h.fail(fmt.Errorf("%w: handshake timed out", ErrCannotUpgrade))
+			})
+		}
+		if err != nil {
+			h.fail(err)
+			return
+		}
+		h.timer = timer
+	}
+
+	h.read()
+}
+
+// routedHandshake is the server side of the handshake of a connection served
+// by an UpgradeRouter.
+type routedHandshake struct {
+	r     *UpgradeRouter
+	conn  sonic.Conn
+	timer *sonic.Timer
+
+	b    []byte
+	n    int
+	done bool
+}
+
+func (h *routedHandshake) read() {
+	h.conn.AsyncRead(h.b[h.n:], func(err error, n int) {
+		if h.done {
+			return
+		}
+		if err != nil {
+			h.fail(err)
+			return
+		}
+
+		h.n += n
+		if end := bytes.Index(h.b[:h.n], []byte("\r\n\r\n")); end >= 0 {
+			h.handle(end + 4)
+		} else if h.n == len(h.b) {
+			h.reject(http.StatusRequestHeaderFieldsTooLarge, "request too big", nil)
+		} else {
+			h.read()
+		}
+	})
+}
+
+func (h *routedHandshake) handle(end int) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(h.b[:end])))
+	if err != nil {
+		h.reject(http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	if status, reason, header := checkUpgradeRequest(req); status != 0 {
+		h.reject(status, reason, header)
+		return
+	}
+
+	route, ok := h.r.routes[req.URL.Path]
+	if !ok {
+		h.reject(http.StatusNotFound, "no route for "+req.URL.Path, nil)
+		return
+	}
+
+	policy := &route.policy
+	if policy.MaxConns > 0 && route.stats.Open >= policy.MaxConns {
+		route.stats.Rejected++
+		route.stats.RejectedLimit++
+		h.reject(http.StatusTooManyRequests, "too many connections to "+req.URL.Path, nil)
+		return
+	}
+	if policy.CheckOrigin != nil && !policy.CheckOrigin(req.Header.Get("Origin")) {
+		route.stats.Rejected++
+		h.reject(http.StatusForbidden, "origin not allowed", nil)
+		return
+	}
+	if policy.Authorize != nil {
+		if err := policy.Authorize(req); err != nil {
+			route.stats.Rejected++
+			h.reject(http.StatusForbidden, err.Error(), nil)
+			return
+		}
+	}
+
+	subprotocol := selectSubprotocol(policy.Subprotocols, req.Header)
+	if subprotocol == "" && policy.RequireSubprotocol {
+		route.stats.Rejected++
+		h.reject(http.StatusBadRequest, "no supported subprotocol", nil)
+		return
+	}
+
+	header := switchingProtocolsHeader(req.Header.Get("Sec-WebSocket-Key"))
+	if subprotocol != "" {
+		header.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	var (
+		deflate bool
+		params  deflateParams
+	)
+	if policy.Deflate != nil {
+		var ext string
+		if ext, params, deflate = acceptDeflateOffer(policy.Deflate, req.Header); deflate {
+			header.Set("Sec-WebSocket-Extensions", ext)
+		}
+	}
+	for k, v := range policy.Header {
+		header[k] = append(header[k], v...)
+	}
+	res := switchingProtocolsResponse(header)
+
+	// The client may send frames right after its request.
+	extra := h.b[end:h.n]
+
+	// The stream is counted from now on, such that the upgrades in progress
+	// count towards MaxConns as well.
+	route.stats.Open++
+	h.conn = &routedConn{Conn: h.conn, route: route}
+
+	h.stop()
+	h.conn.AsyncWriteAll(res, func(err error, _ int) {
+		if err != nil {
+			h.fail(err)
+			return
+		}
+
+		ws, err := NewWebsocketStream(h.r.ioc, nil, RoleServer)
+		if err == nil {
+			ws.state = StateActive
+			err = ws.init(h.conn)
+		}
+		if err == nil && deflate {
+			ws.deflate.enable(policy.Deflate, params)
+		}
+		if err != nil {
+			if ws != nil {
+				ws.releaseMemory()
+			}
+			h.fail(err)
+			return
+		}
+		_, _ = ws.src.Write(extra)
+		ws.subprotocol = subprotocol
+
+		route.stats.Upgraded++
+		route.handler(ws, req, subprotocol)
+	})
+}
+
+// routedConn is the connection of a stream of a route, which counts towards
+// the open streams of the route until it is closed.
+type routedConn struct {
+	sonic.Conn
+	route *upgradeRoute
+}
+
+func (c *routedConn) Close() error {
+	if c.route != nil {
+		c.route.stats.Open--
+		c.route = nil
+	}
+	return c.Conn.Close()
+}
+
+// reject answers the request with the given status and closes the connection.
+func (h *routedHandshake) reject(status int, reason string, header http.Header) {
+	h.stop()
+
+	res, err := rejectResponse(status, reason, header)
+	h.conn.AsyncWriteAll(res, func(error, int) {
+		h.fail(err)
+	})
+}
+
+// fail closes the connection and reports err.
+func (h *routedHandshake) fail(err error) {
+	h.stop()
+	_ = h.conn.Close()
+	if h.r.onError != nil {
+		h.r.onError(err)
+	}
+}
+
+// stop ends the handshake, such that the timeout and pending reads are ignored.
+func (h *routedHandshake) stop() {
+	h.done = true
+	if h.timer != nil {
+		_ = h.timer.Close()
+		h.timer = nil
+	}
+}
+
+// headerHasToken returns true if the comma separated values of the header
+// contain token, which is compared case insensitively.
+func headerHasToken(header http.Header, key, token string) bool {
+	for _, v := range header.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selectSubprotocol returns the first of the supported subprotocols offered
+// in the Sec-WebSocket-Protocol headers, or the empty string if none is.
+func selectSubprotocol(supported []string, header http.Header) string {
+	for _, p := range supported {
+		if headerHasToken(header, "Sec-WebSocket-Protocol", p) {
+			return p
+		}
+	}
+	return ""
+}
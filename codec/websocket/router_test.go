@@ -0,0 +1,395 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic"
+	"github.com/csdenboer/sonic/sonicopts"
+)
+
+func TestUpgradeRouter(t *testing.T) {
+	const addr = "localhost:8082"
+
+	// The server runs on its own IO, as the client handshake blocks.
+	ready, stop, stopped := make(chan error, 1), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ioc := sonic.MustIO()
+		defer ioc.Close()
+
+		ln, err := sonic.Listen(ioc, "tcp", addr, sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+		if err != nil {
+			ready <- err
+			return
+		}
+		defer ln.Close()
+
+		echo := func(prefix string) UpgradeHandler {
+			return func(ws *WebsocketStream, _ *http.Request, _ string) {
+				b := make([]byte, 128)
+				ws.AsyncNextMessage(b, func(err error, n int, mt MessageType) {
+					if err != nil {
+						_ = ws.CloseNextLayer()
+						return
+					}
+					ws.AsyncWrite([]byte(prefix+ws.Subprotocol()+":"+string(b[:n])), mt, func(error) {})
+				})
+			}
+		}
+
+		router := NewUpgradeRouter(ioc)
+		router.Handle("/feed", HandshakePolicy{Subprotocols: []string{"v2", "v1"}}, echo("feed/"))
+		router.Handle("/orders", HandshakePolicy{
+			Authorize: func(req *http.Request) error {
+				if req.URL.Query().Get("token") != "secret" {
+					return errors.New("bad token")
+				}
+				return nil
+			},
+		}, echo("orders/"))
+		router.Handle("/limited", HandshakePolicy{MaxConns: 1}, echo("limited/"))
+		router.SetHandshakeTimeout(time.Second)
+		sonic.NewAcceptor(ln, router.Serve, nil).Start()
+
+		ready <- nil
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = ioc.RunOneFor(time.Millisecond)
+			}
+		}
+	}()
+	if err := <-ready; err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	roundTrip := func(path string, headers ...Header) (string, error) {
+		ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+		if err != nil {
+			return "", err
+		}
+		if err := ws.Handshake("ws://"+addr+path, headers...); err != nil {
+			return "", err
+		}
+		defer ws.CloseNextLayer()
+
+		if err := ws.Write([]byte("hello"), TypeText); err != nil {
+			return "", err
+		}
+		b := make([]byte, 128)
+		_, n, err := ws.NextMessage(b)
+		return string(b[:n]), err
+	}
+
+	if msg, err := roundTrip("/feed", ExtraHeader(true, "Sec-WebSocket-Protocol", "v0, v1")); err != nil {
+		t.Fatal(err)
+	} else if msg != "feed/v1:hello" {
+		t.Fatalf("expected feed/v1:hello got=%s", msg)
+	}
+	if msg, err := roundTrip("/orders?token=secret"); err != nil {
+		t.Fatal(err)
+	} else if msg != "orders/:hello" {
+		t.Fatalf("expected orders/:hello got=%s", msg)
+	}
+
+	// The rejected requests are answered with an HTTP error.
+	status := func(path string) int {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", path, addr, MakeRequestKey())
+		res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode
+	}
+	if code := status("/unknown"); code != http.StatusNotFound {
+		t.Fatalf("expected 404 got=%d", code)
+	}
+	if code := status("/orders?token=wrong"); code != http.StatusForbidden {
+		t.Fatalf("expected 403 got=%d", code)
+	}
+	if code := status("/feed"); code != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101 got=%d", code)
+	}
+
+	// The streams of a route are bounded until they are closed.
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Handshake("ws://" + addr + "/limited"); err != nil {
+		t.Fatal(err)
+	}
+	if code := status("/limited"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 got=%d", code)
+	}
+	_ = ws.CloseNextLayer()
+
+	code := 0
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if code = status("/limited"); code != http.StatusTooManyRequests {
+			break
+		}
+	}
+	if code != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101 once the stream is closed got=%d", code)
+	}
+}
+
+func TestUpgradeRouterDeflate(t *testing.T) {
+	const addr = "localhost:8089"
+
+	ready, stop, stopped := make(chan error, 1), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ioc := sonic.MustIO()
+		defer ioc.Close()
+
+		ln, err := sonic.Listen(ioc, "tcp", addr, sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+		if err != nil {
+			ready <- err
+			return
+		}
+		defer ln.Close()
+
+		echo := func(ws *WebsocketStream, _ *http.Request, _ string) {
+			b := make([]byte, 64*1024)
+			var next func()
+			next = func() {
+				ws.AsyncNextMessage(b, func(err error, n int, mt MessageType) {
+					if err != nil {
+						_ = ws.CloseNextLayer()
+						return
+					}
+					ws.AsyncWrite(b[:n], mt, func(error) { next() })
+				})
+			}
+			next()
+		}
+
+		router := NewUpgradeRouter(ioc)
+		router.Handle("/deflate", HandshakePolicy{Deflate: &DeflateConfig{Threshold: 16}}, echo)
+		router.Handle("/no-takeover", HandshakePolicy{
+			Deflate: &DeflateConfig{Threshold: 16, ServerNoContextTakeover: true, ClientNoContextTakeover: true},
+		}, echo)
+		router.Handle("/plain", HandshakePolicy{}, echo)
+		sonic.NewAcceptor(ln, router.Serve, nil).Start()
+
+		ready <- nil
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = ioc.RunOneFor(time.Millisecond)
+			}
+		}
+	}()
+	if err := <-ready; err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	big := []byte{}
+	for i := 0; len(big) < 8*1024; i++ {
+		big = append(big, fmt.Sprintf("message %d of the compressed stream; ", i%32)...)
+	}
+
+	for _, path := range []string{"/deflate", "/no-takeover", "/plain"} {
+		ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ws.SetDeflate(&DeflateConfig{Threshold: 16}); err != nil {
+			t.Fatal(err)
+		}
+		if err := ws.Handshake("ws://" + addr + path); err != nil {
+			t.Fatal(err)
+		}
+		if negotiated := ws.DeflateNegotiated(); negotiated != (path != "/plain") {
+			t.Fatalf("%s: expected negotiated=%v", path, !negotiated)
+		}
+
+		// Several messages, such that the compression context is carried over, or not.
+		b := make([]byte, 64*1024)
+		for i, msg := range [][]byte{[]byte("tiny"), big, []byte("tiny again but longer"), big, big} {
+			if err := ws.Write(msg, TypeText); err != nil {
+				t.Fatal(err)
+			}
+
+			if i == 4 {
+				// The frame level API sees the frames as they are sent.
+				f, err := ws.NextFrame()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if compressed := f.IsRSV1(); compressed != ws.DeflateNegotiated() {
+					t.Fatalf("%s: expected compressed=%v", path, !compressed)
+				}
+				if ws.DeflateNegotiated() && f.PayloadLen() >= len(big)/4 {
+					t.Fatalf("%s: expected a compressed payload got=%d bytes", path, f.PayloadLen())
+				}
+				continue
+			}
+			if i == 3 {
+				p, err := ws.NextMessageSpill()
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, _ := io.ReadAll(p.Reader())
+				_ = p.Close()
+				if !bytes.Equal(got, msg) {
+					t.Fatalf("%s: message %d spilled wrong payload", path, i)
+				}
+				continue
+			}
+
+			mt, n, err := ws.NextMessage(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mt != TypeText || !bytes.Equal(b[:n], msg) {
+				t.Fatalf("%s: message %d echoed wrong, got %d bytes", path, i, n)
+			}
+		}
+		_ = ws.CloseNextLayer()
+	}
+}
+
+func TestPoolRouter(t *testing.T) {
+	const addr = "localhost:8091"
+
+	pool, err := sonic.NewIOPool(2, sonic.PoolRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	// All the connections are served by the second IO.
+	pool.SetAffinity(func(sonic.Conn) int { return 1 })
+	if err := pool.Run(false); err != nil {
+		t.Fatal(err)
+	}
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(ioc, "tcp", addr, sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	served := make(chan int, 4)
+	router := NewPoolRouter(pool)
+	router.Handle("/echo", HandshakePolicy{}, func(ws *WebsocketStream, _ *http.Request, _ string) {
+		for i := 0; i < pool.Size(); i++ {
+			if ws.ioc == pool.IO(i) {
+				served <- i
+			}
+		}
+		b := make([]byte, 128)
+		ws.AsyncNextMessage(b, func(err error, n int, mt MessageType) {
+			if err != nil {
+				_ = ws.CloseNextLayer()
+				return
+			}
+			ws.AsyncWrite(b[:n], mt, func(error) {})
+		})
+	})
+	sonic.NewAcceptor(ln, router.Serve, func(err error) { t.Error(err) }).Start()
+
+	const nClients = 4
+	results := make(chan error, nClients)
+	for c := 0; c < nClients; c++ {
+		go func() {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				results <- err
+				return
+			}
+			defer conn.Close()
+			_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			fmt.Fprintf(conn, "GET /echo HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+				"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", addr, MakeRequestKey())
+			rd := bufio.NewReader(conn)
+			res, err := http.ReadResponse(rd, nil)
+			if err != nil {
+				results <- err
+				return
+			}
+			if res.StatusCode != http.StatusSwitchingProtocols {
+				results <- fmt.Errorf("expected 101 got=%d", res.StatusCode)
+				return
+			}
+
+			f := NewFrame()
+			f.SetFin()
+			f.SetText()
+			f.SetPayload([]byte("hello"))
+			f.Mask()
+			if _, err := f.WriteTo(conn); err != nil {
+				results <- err
+				return
+			}
+			echo := NewFrame()
+			if _, err := echo.ReadFrom(rd); err != nil {
+				results <- err
+				return
+			}
+			if string(echo.Payload()) != "hello" {
+				results <- fmt.Errorf("expected the echo of hello got=%q", echo.Payload())
+				return
+			}
+			results <- nil
+		}()
+	}
+
+	for done := 0; done < nClients; {
+		select {
+		case err := <-results:
+			if err != nil {
+				t.Fatal(err)
+			}
+			done++
+		default:
+			_ = ioc.RunOneFor(time.Millisecond)
+		}
+	}
+	for c := 0; c < nClients; c++ {
+		if i := <-served; i != 1 {
+			t.Fatalf("expected the connection to be served by the second IO got=%d", i)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package websocket
+
+import "sync/atomic"
+
+// RefCountedPayload is a read-only message payload shared by the write queues
+// of many streams, such that broadcasting a message to N connections does not
+// copy its payload N times into pooled frames.
+//
+// The payload is freed, by invoking the callback given to
+// NewRefCountedPayload, once the last reference is released. Each stream
+// holds a reference from the moment the payload is queued with
+// AsyncWriteShared until its frame is written out.
+//
+// The payload must not be modified once shared. References may be held and
+// released from different IO loops.
+type RefCountedPayload struct {
+	b      []byte
+	refs   int32
+	onFree func(b []byte)
+}
+
+// NewRefCountedPayload creates a RefCountedPayload holding b, with a single
+// reference owned by the caller. onFree, if not nil, is invoked with b once
+// all references are released, for example to return b to a pool.
+func NewRefCountedPayload(b []byte, onFree func(b []byte)) *RefCountedPayload {
+	return &RefCountedPayload{
+		b:      b,
+		refs:   1,
+		onFree: onFree,
+	}
+}
+
+// Bytes returns the payload. It must not be modified.
+func (p *RefCountedPayload) Bytes() []byte {
+	return p.b
+}
+
+// Retain adds a reference to the payload.
+func (p *RefCountedPayload) Retain() {
+	atomic.AddInt32(&p.refs, 1)
+}
+
+// Release drops a reference to the payload, freeing it if it was the last
+// one. The payload must not be used by the caller after Release.
+func (p *RefCountedPayload) Release() {
+	refs := atomic.AddInt32(&p.refs, -1)
+	if refs == 0 {
+		if p.onFree != nil {
+			p.onFree(p.b)
+		}
+		p.b = nil
+	} else if refs < 0 {
+		panic("websocket: RefCountedPayload released too many times")
+	}
+}
+
+// Refs returns the number of references held on the payload.
+func (p *RefCountedPayload) Refs() int {
+	return int(atomic.LoadInt32(&p.refs))
+}
@@ -0,0 +1,142 @@
+package websocket
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic"
+)
+
+func TestRefCountedPayload(t *testing.T) {
+	freed := 0
+	p := NewRefCountedPayload([]byte("hello"), func([]byte) { freed++ })
+
+	p.Retain()
+	if p.Refs() != 2 {
+		t.Fatalf("expected 2 refs got=%d", p.Refs())
+	}
+
+	p.Release()
+	if freed != 0 {
+		t.Fatal("payload freed while referenced")
+	}
+
+	p.Release()
+	if freed != 1 {
+		t.Fatalf("expected payload to be freed once got=%d", freed)
+	}
+}
+
+func TestStreamAsyncWriteShared(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	const nStreams = 3
+
+	received := make(chan string, nStreams)
+	go func() {
+		for i := 0; i < nStreams; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				f := NewFrame()
+				if _, err := f.ReadFrom(conn); err != nil {
+					received <- err.Error()
+				} else {
+					received <- string(f.Payload())
+				}
+			}()
+		}
+	}()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	var streams []*WebsocketStream
+	for i := 0; i < nStreams; i++ {
+		conn, err := sonic.Dial(ioc, "tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws, err := NewWebsocketStream(ioc, nil, RoleServer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws.state = StateActive
+		if err := ws.init(conn); err != nil {
+			t.Fatal(err)
+		}
+		streams = append(streams, ws)
+	}
+
+	freed := false
+	p := NewRefCountedPayload([]byte("broadcast"), func([]byte) { freed = true })
+
+	written := 0
+	for _, ws := range streams {
+		ws.AsyncWriteShared(p, TypeBinary, func(err error) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			written++
+		})
+	}
+	p.Release()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && written < nStreams {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+
+	if written != nStreams {
+		t.Fatalf("expected %d writes got=%d", nStreams, written)
+	}
+	if !freed {
+		t.Fatal("expected the payload to be freed once written to all streams")
+	}
+	for i := 0; i < nStreams; i++ {
+		if msg := <-received; msg != "broadcast" {
+			t.Fatalf("expected broadcast got=%s", msg)
+		}
+	}
+}
+
+func TestStreamWriteShared(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.state = StateActive
+	mock := NewMockStream()
+	ws.init(mock)
+
+	freed := false
+	p := NewRefCountedPayload([]byte("broadcast"), func([]byte) { freed = true })
+	if err := ws.WriteShared(p, TypeBinary); err != nil {
+		t.Fatal(err)
+	}
+	p.Release()
+
+	if !freed {
+		t.Fatal("expected the payload to be freed once written")
+	}
+
+	mock.b.Commit(mock.b.WriteLen())
+	f := NewFrame()
+	if _, err := f.ReadFrom(mock.b); err != nil {
+		t.Fatal(err)
+	}
+	if !f.IsFin() || f.Opcode() != OpcodeBinary || string(f.Payload()) != "broadcast" {
+		t.Fatalf("wrong frame written got opcode=%s payload=%q", f.Opcode(), f.Payload())
+	}
+}
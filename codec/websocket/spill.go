@@ -0,0 +1,571 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+// DefaultSpillThreshold is the spill threshold used if SpillConfig.Threshold
+// is not positive.
+const DefaultSpillThreshold = 64 * 1024
+
+// SpillConfig configures how NextMessageSpill and AsyncNextMessageSpill read
+// messages which are too big to be kept in memory.
+type SpillConfig struct {
+	// Threshold is the number of payload bytes a message can hold in memory.
+	// The payload of a bigger message is written to a temporary file instead,
+	// as it arrives. DefaultSpillThreshold is used if Threshold <= 0.
+	Threshold int
+
+	// Dir is the directory of the temporary files. The default directory for
+	// temporary files, see os.TempDir, is used if Dir is empty.
+	Dir string
+
+	// MaxSize bounds the payload of a message read by NextMessageSpill or
+	// AsyncNextMessageSpill, which is otherwise only bounded by the disk.
+	// Bigger messages close the stream with CloseTooBig and fail with
+	// ErrMessageTooBig. 0 means no bound.
+	MaxSize int64
+}
+
+func (c SpillConfig) threshold() int {
+	if c.Threshold <= 0 {
+		return DefaultSpillThreshold
+	}
+	return c.Threshold
+}
+
+// MessagePayload is the payload of a message read by NextMessageSpill or
+// AsyncNextMessageSpill. It is held in memory if it is small enough, and in a
+// temporary file otherwise. The caller owns it and must Close it.
+type MessagePayload struct {
+	mt   MessageType
+	size int64
+	mem  []byte
+	file *os.File
+	r    *bytes.Reader
+
+	// w is where the payload is written instead, see NextMessageTo.
+	w io.Writer
+}
+
+// Type returns the type of the message.
+func (p *MessagePayload) Type() MessageType {
+	return p.mt
+}
+
+// Size returns the length of the payload.
+func (p *MessagePayload) Size() int64 {
+	return p.size
+}
+
+// Spilled returns true if the payload is held in a temporary file.
+func (p *MessagePayload) Spilled() bool {
+	return p.file != nil
+}
+
+// Bytes returns the payload if it is held in memory, and nil otherwise.
+func (p *MessagePayload) Bytes() []byte {
+	return p.mem
+}
+
+// File returns the temporary file holding the payload, positioned at its
+// start, or nil if the payload is held in memory. The file is removed by
+// Close.
+func (p *MessagePayload) File() *os.File {
+	return p.file
+}
+
+// Reader returns a reader of the payload, wherever it is held.
+func (p *MessagePayload) Reader() io.Reader {
+	if p.file != nil {
+		return p.file
+	}
+	if p.r == nil {
+		p.r = bytes.NewReader(p.mem)
+	}
+	return p.r
+}
+
+// Close closes and removes the temporary file holding the payload, if any.
+func (p *MessagePayload) Close() error {
+	if p.file == nil {
+		return nil
+	}
+	name := p.file.Name()
+	err := p.file.Close()
+	if rerr := os.Remove(name); err == nil {
+		err = rerr
+	}
+	p.file = nil
+	return err
+}
+
+func (p *MessagePayload) write(b []byte, cfg *SpillConfig) error {
+	if p.w != nil {
+		n, err := p.w.Write(b)
+		p.size += int64(n)
+		return err
+	}
+
+	if p.file == nil && len(p.mem)+len(b) <= cfg.threshold() {
+		p.mem = append(p.mem, b...)
+		p.size += int64(len(b))
+		return nil
+	}
+
+	if p.file == nil {
+		file, err := os.CreateTemp(cfg.Dir, "sonic-websocket-*")
+		if err != nil {
+			return err
+		}
+		p.file = file
+		if _, err := p.file.Write(p.mem); err != nil {
+			return err
+		}
+		p.mem = nil
+	}
+
+	n, err := p.file.Write(b)
+	p.size += int64(n)
+	return err
+}
+
+type AsyncPayloadHandler = func(err error, p *MessagePayload)
+
+// spillReader holds the state of the message read by NextMessageSpill or
+// AsyncNextMessageSpill.
+type spillReader struct {
+	cfg SpillConfig
+
+	msg          *MessagePayload
+	fragments    int
+	continuation bool
+	compressed   bool // msg holds the compressed payload, see SetDeflate
+
+	// w receives the payload of the message read by NextMessageTo or
+	// AsyncNextMessageTo.
+	w io.Writer
+
+	// The data frame whose payload is streamed from the read buffer to msg.
+	// remaining is the number of payload bytes left to stream and maskPos the
+	// offset of the next one in the frame's payload.
+	frame     *Frame
+	streaming bool
+	remaining int
+	maskPos   int
+}
+
+// SetSpill configures how NextMessageSpill and AsyncNextMessageSpill read
+// messages too big to be kept in memory.
+func (s *WebsocketStream) SetSpill(cfg SpillConfig) {
+	s.spill.cfg = cfg
+}
+
+// Spill returns the configuration set with SetSpill.
+func (s *WebsocketStream) Spill() SpillConfig {
+	return s.spill.cfg
+}
+
+// NextMessageSpill reads the next message like NextMessage does, except that
+// the payload of a message bigger than the spill threshold is written to a
+// temporary file as it arrives, see SetSpill. Big frames are not buffered in
+// full, so the memory used to read a message is bounded by the threshold and
+// the size of the read buffer, no matter how big the message is. This
+// protects gateways against huge uploads.
+//
+// The message size limit of SetMaxMessageSize does not apply; the MaxSize of
+// the spill configuration does. The frame size limit of SetMaxFrameSize does.
+//
+// Writing to the temporary file blocks the calling goroutine, which is the
+// goroutine running the IO for AsyncNextMessageSpill.
+func (s *WebsocketStream) NextMessageSpill() (p *MessagePayload, err error) {
+	for {
+		err = s.Flush()
+		if errors.Is(err, ErrMessageTooBig) {
+			_ = s.Close(CloseGoingAway, "payload too big")
+		}
+		if err == nil && !s.state.CanRead() {
+			err = io.EOF
+		}
+		if err != nil {
+			break
+		}
+
+		var done bool
+		done, err = s.spillStep()
+		if done {
+			if p, err = s.finishSpill(); err == nil {
+				return p, nil
+			}
+			break
+		}
+		if errors.Is(err, sonicerrors.ErrNeedMore) {
+			s.reserveSpillRead()
+			_, err = s.src.ReadFrom(s.stream)
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	if err == ErrTooManyFragments || err == ErrMessageTooBig {
+		_ = s.Close(CloseTooBig, "message too big")
+	} else if err == ErrPayloadOverMaxSize {
+		_ = s.Close(CloseTooBig, "frame too big")
+	} else if errors.Is(err, ErrInvalidCompressedPayload) || err == ErrInvalidUTF8 {
+		_ = s.Close(inflateClose(err))
+	}
+	return nil, s.failSpill(err)
+}
+
+// AsyncNextMessageSpill is the asynchronous version of NextMessageSpill.
+func (s *WebsocketStream) AsyncNextMessageSpill(cb AsyncPayloadHandler) {
+	s.AsyncFlush(func(err error) {
+		if errors.Is(err, ErrMessageTooBig) {
+			s.AsyncClose(CloseGoingAway, "payload too big", func(err error) {})
+		}
+		if err == nil && !s.state.CanRead() {
+			err = io.EOF
+		}
+		if err != nil {
+			cb(s.failSpill(err), nil)
+			return
+		}
+
+		done, err := s.spillStep()
+		switch {
+		case done:
+			var p *MessagePayload
+			if p, err = s.finishSpill(); err == nil {
+				cb(nil, p)
+				return
+			}
+		case errors.Is(err, sonicerrors.ErrNeedMore):
+			s.asyncSpillRead(cb)
+			return
+		}
+
+		if err == ErrTooManyFragments || err == ErrMessageTooBig {
+			s.AsyncClose(CloseTooBig, "message too big", func(err error) {})
+		} else if err == ErrPayloadOverMaxSize {
+			s.AsyncClose(CloseTooBig, "frame too big", func(err error) {})
+		} else if errors.Is(err, ErrInvalidCompressedPayload) || err == ErrInvalidUTF8 {
+			cc, reason := inflateClose(err)
+			s.AsyncClose(cc, reason, func(err error) {})
+		}
+		cb(s.failSpill(err), nil)
+	})
+}
+
+type AsyncStreamHandler = func(err error, n int64, mt MessageType)
+
+// NextMessageTo reads the next message and writes its payload to w as it
+// arrives, frame by frame, such that a message bigger than any buffer of the
+// caller is read without holding it in memory. It returns the length of the
+// payload and the type of the message.
+//
+// The frames are read like NextMessageSpill does, so big frames are not
+// buffered in full either, and the MaxSize of the spill configuration bounds
+// the payload. The payload of a compressed message is held, in memory or in a
+// temporary file as per the spill configuration, until it is complete and
+// decompressed to w.
+//
+// An error of w fails the read. The rest of the message is not read, so the
+// stream should then be closed.
+func (s *WebsocketStream) NextMessageTo(w io.Writer) (n int64, mt MessageType, err error) {
+	s.spill.w = w
+	p, err := s.NextMessageSpill()
+	s.spill.w = nil
+	if err != nil {
+		return 0, TypeNone, err
+	}
+	return p.size, p.mt, p.Close()
+}
+
+// AsyncNextMessageTo is the asynchronous version of NextMessageTo.
+func (s *WebsocketStream) AsyncNextMessageTo(w io.Writer, cb AsyncStreamHandler) {
+	s.spill.w = w
+	s.AsyncNextMessageSpill(func(err error, p *MessagePayload) {
+		s.spill.w = nil
+		if err != nil {
+			cb(err, 0, TypeNone)
+			return
+		}
+		cb(p.Close(), p.size, p.mt)
+	})
+}
+
+// AsyncNextMessageChunks reads the next message like AsyncNextMessageTo, and
+// hands its payload to onChunk as it arrives, one chunk at a time. A chunk is
+// only valid until onChunk returns. An error returned by onChunk fails the
+// read.
+func (s *WebsocketStream) AsyncNextMessageChunks(onChunk func(chunk []byte) error, cb AsyncStreamHandler) {
+	s.AsyncNextMessageTo(chunkWriter(onChunk), cb)
+}
+
+// chunkWriter is an io.Writer handing what is written to it to a function.
+type chunkWriter func(chunk []byte) error
+
+func (w chunkWriter) Write(b []byte) (int, error) {
+	if err := w(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (s *WebsocketStream) asyncSpillRead(cb AsyncPayloadHandler) {
+	s.reserveSpillRead()
+	s.reading = true
+	s.src.AsyncReadFrom(s.stream, func(err error, _ int) {
+		s.reading = false
+		if s.cancelledByPark(err) {
+			// The read buffer is taken back by reserveSpillRead.
+			s.asyncSpillRead(cb)
+			return
+		}
+		if err != nil {
+			cb(s.failSpill(err), nil)
+			return
+		}
+		s.AsyncNextMessageSpill(cb)
+	})
+}
+
+// reserveSpillRead makes room in the read buffer for the next read, without
+// reserving room for the whole payload of a streamed frame.
+func (s *WebsocketStream) reserveSpillRead() {
+	s.acquireBuffer(s.src)
+	if s.src.Reserved() == 0 {
+		s.src.Reserve(bufferSize)
+	}
+	s.accountMemory()
+}
+
+// spillStep reads the frames of the current message from the read buffer. It
+// returns true once the message is complete, and ErrNeedMore if more bytes
+// must be read from the peer first.
+func (s *WebsocketStream) spillStep() (done bool, err error) {
+	r := &s.spill
+	if r.msg == nil {
+		r.msg = &MessagePayload{mt: TypeNone}
+		r.fragments, r.continuation = 0, false
+		if r.frame == nil {
+			r.frame = NewFrame()
+		}
+	}
+
+	for {
+		if r.streaming {
+			if done, err = s.streamPayload(); err != nil || done || r.streaming {
+				return done, err
+			}
+			continue
+		}
+
+		hdr, n, err := s.codec.peekHeader(s.src)
+		if err != nil {
+			return false, err
+		}
+
+		payloadLen := hdr.PayloadLen()
+		if s.maxFrameSize > 0 && payloadLen > s.maxFrameSize {
+			return false, ErrPayloadOverMaxSize
+		}
+		inline := r.cfg.threshold()
+		if max := s.codec.maxPayloadLen(); max < inline {
+			inline = max
+		}
+
+		if hdr.IsControl() || (payloadLen >= 0 && payloadLen <= inline) {
+			f, err := s.codec.Decode(s.src)
+			if err != nil {
+				return false, err
+			}
+			if err = s.handleFrame(f); err == errSkipFrame {
+				continue
+			} else if err != nil {
+				return false, err
+			}
+
+			if f.IsControl() {
+				if s.ccb != nil {
+					s.ccb(MessageType(f.Opcode()), f.payload)
+				}
+				if !s.state.CanRead() {
+					return false, io.EOF
+				}
+				continue
+			}
+
+			if err = s.startSpillFrame(f, len(f.payload)); err != nil {
+				return false, err
+			}
+			if err = r.msg.write(f.payload, &r.cfg); err != nil {
+				return false, err
+			}
+			if !r.continuation {
+				return true, nil
+			}
+			continue
+		}
+
+		// The payload is too big to be buffered: the frame's header is copied
+		// and consumed, and its payload streamed to the message as it arrives.
+		f := r.frame
+		f.Reset()
+		copy(f.header, hdr.header)
+		if hdr.IsMasked() {
+			copy(f.mask, hdr.mask)
+		}
+		_ = s.src.PrepareRead(n)
+		s.src.Consume(n)
+
+		if payloadLen < 0 {
+			return false, ErrMessageTooBig
+		}
+		if err = s.verifyFrame(f); err == nil {
+			err = s.handleDataFrame(f)
+		}
+		if err == nil {
+			err = s.validateText(f, true)
+		}
+		if err != nil {
+			s.state = StateClosedByUs
+			s.prepareClose(EncodeCloseFramePayload(CloseProtocolError, ""))
+			return false, err
+		}
+		if err = s.startSpillFrame(f, payloadLen); err != nil {
+			return false, err
+		}
+
+		r.streaming, r.remaining, r.maskPos = true, payloadLen, 0
+	}
+}
+
+// startSpillFrame checks that the data frame f, with a payload of n bytes, can
+// be added to the current message.
+func (s *WebsocketStream) startSpillFrame(f *Frame, n int) (err error) {
+	r := &s.spill
+
+	if r.msg.mt == TypeNone {
+		r.msg.mt = MessageType(f.Opcode())
+		r.compressed = s.deflate.enabled && f.IsRSV1()
+		if !r.compressed {
+			// A compressed payload is held until it is decompressed to w.
+			r.msg.w = r.w
+		}
+	}
+
+	if r.cfg.MaxSize > 0 && r.msg.size+int64(n) > r.cfg.MaxSize {
+		return ErrMessageTooBig
+	}
+
+	r.fragments++
+	if s.tooManyFragments(r.fragments) {
+		return ErrTooManyFragments
+	}
+
+	if !r.continuation {
+		if f.IsContinuation() {
+			err = ErrUnexpectedContinuation
+		}
+	} else if !f.IsContinuation() {
+		err = ErrExpectedContinuation
+	}
+	r.continuation = !f.IsFin()
+
+	return err
+}
+
+// streamPayload moves the buffered bytes of the streamed frame's payload to
+// the message.
+func (s *WebsocketStream) streamPayload() (done bool, err error) {
+	r := &s.spill
+
+	n := s.src.ReadLen() + s.src.WriteLen()
+	if n > r.remaining {
+		n = r.remaining
+	}
+	if n == 0 && r.remaining > 0 {
+		return false, sonicerrors.ErrNeedMore
+	}
+
+	_ = s.src.PrepareRead(n)
+	b := s.src.Data()[:n]
+	if r.frame.IsMasked() {
+		for i := range b {
+			b[i] ^= r.frame.mask[(r.maskPos+i)&3]
+		}
+	}
+	err = s.validateTextPayload(b, false, false)
+	if err == nil {
+		err = r.msg.write(b, &r.cfg)
+	}
+	s.src.Consume(n)
+
+	r.remaining -= n
+	r.maskPos += n
+	if err != nil {
+		return false, err
+	}
+
+	if r.remaining == 0 {
+		r.streaming = false
+		if err = s.validateTextPayload(nil, r.frame.IsFin(), false); err != nil {
+			return false, err
+		}
+		return !r.continuation, nil
+	}
+	return false, sonicerrors.ErrNeedMore
+}
+
+// finishSpill hands the completed message over to the caller.
+func (s *WebsocketStream) finishSpill() (*MessagePayload, error) {
+	p := s.spill.msg
+	s.spill.msg = nil
+
+	if s.spill.compressed {
+		if err := p.seek(); err != nil {
+			_ = p.Close()
+			return nil, err
+		}
+		var err error
+		if p, err = s.inflateSpill(p); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.seek(); err != nil {
+		_ = p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// seek positions the temporary file holding the payload, if any, at its start.
+func (p *MessagePayload) seek() error {
+	if p.file != nil {
+		_, err := p.file.Seek(0, io.SeekStart)
+		return err
+	}
+	return nil
+}
+
+// failSpill drops the message being read and returns err.
+func (s *WebsocketStream) failSpill(err error) error {
+	if p := s.spill.msg; p != nil {
+		_ = p.Close()
+		s.spill.msg = nil
+	}
+	s.spill.streaming = false
+
+	if err == io.EOF {
+		s.state = StateTerminated
+	}
+	return err
+}
@@ -16,6 +16,7 @@ when the client cannot parse what the server sends.
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/sha1" //#nosec G505
 	"crypto/tls"
@@ -30,6 +31,7 @@ import (
 	"net/url"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/csdenboer/sonic"
 	"github.com/csdenboer/sonic/sonicerrors"
@@ -52,8 +54,9 @@ type WebsocketStream struct {
 	stream sonic.Stream
 	conn   net.Conn
 
-	// Codec stream wrapping the underlying transport stream.
-	cs *sonic.BlockingCodecConn[*Frame, *Frame]
+	// Codec stream wrapping the underlying transport stream, and its codec.
+	cs    *sonic.BlockingCodecConn[*Frame, *Frame]
+	codec *FrameCodec
 
 	// Websocket role: client or server.
 	role Role
@@ -87,11 +90,104 @@ type WebsocketStream struct {
 	// Optional callback invoked when an upgrade response is received.
 	upResCb UpgradeResponseCallback
 
+	// Optional function returning the proxy through which the client dials.
+	proxy ProxyFunc
+
+	// The subprotocol negotiated by the last handshake, and the function
+	// picking it on server streams.
+	subprotocol         string
+	subprotocolSelector SubprotocolSelector
+
 	// Used to establish a TCP connection to the peer with a timeout.
 	dialer *net.Dialer
 
 	// The size of the currently read message.
 	messageSize int
+
+	// The maximum number of frames a message can be fragmented into. 0 means
+	// there is no limit.
+	maxMessageFragments int
+
+	// The maximum size of a message, and of the payload of a frame, read from
+	// the peer. A maxFrameSize of 0 means frames are only bounded by
+	// maxMessageSize.
+	maxMessageSize int
+	maxFrameSize   int
+
+	// Optional tracing of the frames exchanged with the peer, see SetTracer.
+	trace *sonic.TraceConfig
+
+	// True while AsyncFlush writes the pending frames. Flushes requested in
+	// the meantime wait for the ongoing one to finish, in flushWaiters.
+	flushing     bool
+	flushWaiters []func(err error)
+
+	// How long control frame replies can stay pending before they are
+	// flushed automatically. See SetControlFlushDeadline.
+	controlFlushDeadline  time.Duration
+	controlFlushScheduled bool
+	controlFlushTimer     *sonic.Timer
+
+	// Application state attached with SetUserData.
+	userData any
+
+	// Accounts the bytes held by the buffers and the pending frames of the
+	// stream, under the memory account of the IO. See MemoryAccount.
+	// memReleased is true once the stream is closed or failed its handshake,
+	// until it is reused.
+	mem         *sonic.MemoryAccount
+	memReleased bool
+
+	// Releases the buffers of an idle stream, see SetIdleRelease. active is
+	// true if the stream read or wrote since the last check of idleTimer.
+	idleRelease time.Duration
+	idleTimer   *sonic.Timer
+	active      bool
+
+	// reading is true while an asynchronous read is outstanding. probing is
+	// true if that read waits on probe, with the read buffer released.
+	// parking is true while checkIdle cancels the outstanding read.
+	reading bool
+	probing bool
+	parking bool
+	probe   [1]byte
+
+	// Holds the buffers of a frame written with a vectored write, see
+	// asyncWriteFrame.
+	iov [3][]byte
+
+	// Generates the masking keys of a client stream, see SetMaskKeySource and
+	// SetMaskPolicy. burstKey masks the frames of the current burst under
+	// MaskPerBurst.
+	maskKeySource io.Reader
+	maskPolicy    MaskPolicy
+	burstKey      [4]byte
+	burstKeyValid bool
+
+	// Reads messages too big to be kept in memory, see SetSpill.
+	spill spillReader
+
+	// Bounds the rate of the received messages, see SetInboundRateLimit.
+	inbound inboundLimiter
+
+	// Handles the frames with a reserved opcode or reserved bits, see
+	// SetUnknownFramePolicy.
+	unknownPolicy UnknownFramePolicy
+	unknownCb     UnknownFrameCallback
+
+	// Compresses and decompresses the messages, see SetDeflate.
+	deflate deflateState
+
+	// Validates the text messages, see SetUTF8Validation.
+	noUTF8Check bool
+	utf8        utf8Validator
+
+	// Pings the peer, see SetKeepAlive.
+	keepAlive keepAlive
+
+	// True while the fragments of a message are written with WriteSome, until
+	// its last one.
+	fragmenting bool
 }
 
 func NewWebsocketStream(
@@ -112,10 +208,21 @@ func NewWebsocketStream(
 		dialer: &net.Dialer{
 			Timeout: DialTimeout,
 		},
+		maxMessageFragments: MaxMessageFragments,
+		maxMessageSize:      MaxMessageSize,
 	}
 
-	s.src.Reserve(4096)
-	s.dst.Reserve(4096)
+	s.src.Reserve(bufferSize)
+	s.dst.Reserve(bufferSize)
+
+	var parent *sonic.MemoryAccount
+	if ioc != nil {
+		parent = ioc.MemoryAccount()
+		s.src.SetDebugIO(ioc)
+		s.dst.SetDebugIO(ioc)
+	}
+	s.mem = sonic.NewMemoryAccount(parent)
+	s.accountMemory()
 
 	return s, nil
 }
@@ -127,8 +234,13 @@ func (s *WebsocketStream) init(stream sonic.Stream) (err error) {
 		return fmt.Errorf("stream must be in StateActive")
 	}
 
+	if s.trace != nil {
+		stream = sonic.NewTracedStream(stream, *s.trace)
+	}
 	s.stream = stream
 	codec := NewFrameCodec(s.src, s.dst)
+	codec.SetMaxPayloadLen(s.maxPayloadLen())
+	s.codec = codec
 	s.cs, err = sonic.NewBlockingCodecConn[*Frame, *Frame](
 		stream, codec, s.src, s.dst)
 	return
@@ -141,6 +253,16 @@ func (s *WebsocketStream) reset() {
 	s.conn = nil
 	s.src.Reset()
 	s.dst.Reset()
+	s.deflate.reset()
+	s.keepAlive.awaiting = false
+	s.keepAlive.expired = false
+	s.fragmenting = false
+	s.subprotocol = ""
+
+	if s.memReleased {
+		s.memReleased = false
+		s.accountMemory()
+	}
 }
 
 func (s *WebsocketStream) NextLayer() sonic.Stream {
@@ -151,16 +273,11 @@ func (s *WebsocketStream) NextLayer() sonic.Stream {
 }
 
 func (s *WebsocketStream) SupportsUTF8() bool {
-	return false
+	return true
 }
 
 func (s *WebsocketStream) SupportsDeflate() bool {
-	return false
-}
-
-func (s *WebsocketStream) canRead() bool {
-	// we can only read from non-terminal states after a successful handshake
-	return s.state == StateActive || s.state == StateClosedByUs
+	return true
 }
 
 func (s *WebsocketStream) NextFrame() (f *Frame, err error) {
@@ -171,11 +288,12 @@ func (s *WebsocketStream) NextFrame() (f *Frame, err error) {
 		return nil, err
 	}
 
-	if err == nil && !s.canRead() {
-		err = io.EOF
-	}
-
-	if err == nil {
+	if err != nil {
+		// The frames replying to the peer cannot be written.
+		s.state = StateTerminated
+	} else if !s.state.CanRead() {
+		err = s.keepAliveErr(io.EOF)
+	} else {
 		f, err = s.nextFrame()
 
 		if err == io.EOF {
@@ -187,11 +305,27 @@ func (s *WebsocketStream) NextFrame() (f *Frame, err error) {
 }
 
 func (s *WebsocketStream) nextFrame() (f *Frame, err error) {
-	f, err = s.cs.ReadNext()
-	if err == nil {
-		err = s.handleFrame(f)
+	for {
+		if d := s.inbound.delay(); d > 0 {
+			time.Sleep(d)
+		}
+
+		s.acquireBuffer(s.src)
+		f, err = s.cs.ReadNext()
+		s.accountMemory()
+		if err == nil {
+			err = s.handleFrame(f)
+		} else if err == ErrPayloadOverMaxSize {
+			_ = s.Close(CloseTooBig, "frame too big")
+		}
+		if (err == nil || err == errSkipFrame) && !s.inbound.take(f) {
+			_ = s.Close(ClosePolicyError, "rate limit exceeded")
+			err = ErrRateLimited
+		}
+		if err != errSkipFrame {
+			return
+		}
 	}
-	return
 }
 
 func (s *WebsocketStream) AsyncNextFrame(cb AsyncFrameHandler) {
@@ -210,36 +344,91 @@ func (s *WebsocketStream) AsyncNextFrame(cb AsyncFrameHandler) {
 			return
 		}
 
-		if err == nil && !s.canRead() {
-			err = io.EOF
-		}
-
-		if err == nil {
-			s.asyncNextFrame(cb)
-		} else {
+		if err != nil {
+			// The frames replying to the peer cannot be written.
 			s.state = StateTerminated
 			cb(err, nil)
+		} else if !s.state.CanRead() {
+			cb(s.keepAliveErr(io.EOF), nil)
+		} else {
+			s.asyncNextFrame(cb)
 		}
 	})
 }
 
 func (s *WebsocketStream) asyncNextFrame(cb AsyncFrameHandler) {
+	if d := s.inbound.delay(); d > 0 {
+		s.asyncDelayRead(d, cb)
+		return
+	}
+
+	if s.src.Cap() == 0 {
+		s.asyncProbe(cb)
+		return
+	}
+
+	s.reading = true
 	s.cs.AsyncReadNext(func(err error, f *Frame) {
+		s.reading = false
+		if s.cancelledByPark(err) {
+			s.asyncProbe(cb)
+			return
+		}
+		err = s.keepAliveErr(err)
+		s.active = true
+
+		// Reading might have grown the read buffer.
+		s.accountMemory()
+
 		if err == nil {
 			err = s.handleFrame(f)
+			if (err == nil || err == errSkipFrame) && !s.inbound.take(f) {
+				s.AsyncClose(ClosePolicyError, "rate limit exceeded", func(error) {})
+				err = ErrRateLimited
+			}
+		} else if err == ErrPayloadOverMaxSize {
+			s.AsyncClose(CloseTooBig, "frame too big", func(error) {})
 		} else if err == io.EOF {
 			s.state = StateTerminated
 		}
+
+		if err == errSkipFrame {
+			s.asyncNextFrame(cb)
+			return
+		}
 		cb(err, f)
 	})
 }
 
 func (s *WebsocketStream) NextMessage(
 	b []byte,
+) (mt MessageType, readBytes int, err error) {
+	return s.nextMessage(b, nil)
+}
+
+// NextMessageInto appends the payload of the next message to the write area of
+// dst, growing it as needed, like NextMessage does with a slice. The caller
+// commits the bytes when it sees fit, which lets it aggregate the payloads of
+// many messages in a buffer it controls.
+//
+// If an error occurs, the bytes of the message read so far are left in the
+// write area of dst.
+func (s *WebsocketStream) NextMessageInto(
+	dst *sonic.ByteBuffer,
+) (mt MessageType, readBytes int, err error) {
+	return s.nextMessage(nil, dst)
+}
+
+// nextMessage reads the payload of the next message into b, or appends it to
+// dst if dst is not nil.
+func (s *WebsocketStream) nextMessage(
+	b []byte,
+	dst *sonic.ByteBuffer,
 ) (mt MessageType, readBytes int, err error) {
 	var (
 		f            *Frame
 		continuation = false
+		fragments    = 0
 	)
 
 	mt = TypeNone
@@ -255,16 +444,24 @@ func (s *WebsocketStream) NextMessage(
 				s.ccb(MessageType(f.Opcode()), f.payload)
 			}
 		} else {
-			if mt == TypeNone {
+			first := mt == TypeNone
+			if first {
 				mt = MessageType(f.Opcode())
 			}
 
-			n := copy(b[readBytes:], f.Payload())
+			n, ok := s.appendMessagePayload(b, readBytes, dst, f, first)
 			readBytes += n
 
-			if readBytes > MaxMessageSize || n != f.PayloadLen() {
+			if readBytes > s.maxMessageSize || !ok {
 				err = ErrMessageTooBig
-				_ = s.Close(CloseGoingAway, "payload too big")
+				_ = s.Close(CloseTooBig, "message too big")
+				break
+			}
+
+			fragments++
+			if s.tooManyFragments(fragments) {
+				err = ErrTooManyFragments
+				_ = s.Close(CloseTooBig, "too many fragments")
 				break
 			}
 
@@ -285,6 +482,13 @@ func (s *WebsocketStream) NextMessage(
 
 			continuation = !f.IsFin()
 
+			if err == nil && !continuation && s.deflate.inflating {
+				readBytes, err = s.inflateMessage(b, dst)
+				if err != nil {
+					_ = s.Close(inflateClose(err))
+				}
+			}
+
 			if err != nil || !continuation {
 				break
 			}
@@ -295,12 +499,24 @@ func (s *WebsocketStream) NextMessage(
 }
 
 func (s *WebsocketStream) AsyncNextMessage(b []byte, cb AsyncMessageHandler) {
-	s.asyncNextMessage(b, 0, false, TypeNone, cb)
+	s.asyncNextMessage(b, nil, 0, 0, false, TypeNone, cb)
+}
+
+// AsyncNextMessageInto is the asynchronous version of NextMessageInto: it
+// appends the payload of the next message to the write area of dst, growing
+// it as needed.
+func (s *WebsocketStream) AsyncNextMessageInto(
+	dst *sonic.ByteBuffer,
+	cb AsyncMessageHandler,
+) {
+	s.asyncNextMessage(nil, dst, 0, 0, false, TypeNone, cb)
 }
 
 func (s *WebsocketStream) asyncNextMessage(
 	b []byte,
+	dst *sonic.ByteBuffer,
 	readBytes int,
+	fragments int,
 	continuation bool,
 	mt MessageType,
 	cb AsyncMessageHandler,
@@ -314,20 +530,33 @@ func (s *WebsocketStream) asyncNextMessage(
 					s.ccb(MessageType(f.Opcode()), f.payload)
 				}
 
-				s.asyncNextMessage(b, readBytes, continuation, mt, cb)
+				s.asyncNextMessage(b, dst, readBytes, fragments, continuation, mt, cb)
 			} else {
-				if mt == TypeNone {
+				first := mt == TypeNone
+				if first {
 					mt = MessageType(f.Opcode())
 				}
 
-				n := copy(b[readBytes:], f.Payload())
+				n, ok := s.appendMessagePayload(b, readBytes, dst, f, first)
 				readBytes += n
 
-				if readBytes > MaxMessageSize || n != f.PayloadLen() {
+				if readBytes > s.maxMessageSize || !ok {
 					err = ErrMessageTooBig
 					s.AsyncClose(
-						CloseGoingAway,
-						"payload too big",
+						CloseTooBig,
+						"message too big",
+						func(err error) {},
+					)
+					cb(err, readBytes, mt)
+					return
+				}
+
+				fragments++
+				if s.tooManyFragments(fragments) {
+					err = ErrTooManyFragments
+					s.AsyncClose(
+						CloseTooBig,
+						"too many fragments",
 						func(err error) {},
 					)
 					cb(err, readBytes, mt)
@@ -349,37 +578,73 @@ func (s *WebsocketStream) asyncNextMessage(
 					}
 				}
 
+				if err == nil && !continuation && s.deflate.inflating {
+					readBytes, err = s.inflateMessage(b, dst)
+					if err != nil {
+						cc, reason := inflateClose(err)
+						s.AsyncClose(cc, reason, func(err error) {})
+					}
+				}
+
 				if err != nil || !continuation {
 					cb(err, readBytes, mt)
 				} else {
-					s.asyncNextMessage(b, readBytes, continuation, mt, cb)
+					s.asyncNextMessage(b, dst, readBytes, fragments, continuation, mt, cb)
 				}
 			}
 		}
 	})
 }
 
+// appendPayload appends payload to dst if dst is not nil and copies it into b
+// at off otherwise. It returns the number of bytes appended or copied.
+func appendPayload(b []byte, off int, dst *sonic.ByteBuffer, payload []byte) int {
+	if dst != nil {
+		n, _ := dst.Write(payload)
+		return n
+	}
+	return copy(b[off:], payload)
+}
+
+func (s *WebsocketStream) tooManyFragments(fragments int) bool {
+	return s.maxMessageFragments > 0 && fragments > s.maxMessageFragments
+}
+
 func (s *WebsocketStream) handleFrame(f *Frame) (err error) {
+	s.debugRead(f)
 	err = s.verifyFrame(f)
 
 	if err == nil {
+		if f.IsMasked() {
+			// Only servers get masked frames, see verifyFrame.
+			f.Unmask()
+		}
+
+		if s.skipUnknownFrame(f) {
+			return errSkipFrame
+		}
+
 		if f.IsControl() {
 			err = s.handleControlFrame(f)
-		} else {
-			err = s.handleDataFrame(f)
+		} else if err = s.handleDataFrame(f); err == nil {
+			err = s.validateText(f, false)
 		}
 	}
 
-	if err != nil {
+	if err != nil && err != errSkipFrame {
+		cc := CloseProtocolError
+		if err == ErrInvalidUTF8 {
+			cc = CloseBadPayload
+		}
 		s.state = StateClosedByUs
-		s.prepareClose(EncodeCloseFramePayload(CloseProtocolError, ""))
+		s.prepareClose(EncodeCloseFramePayload(cc, ""))
 	}
 
 	return err
 }
 
 func (s *WebsocketStream) verifyFrame(f *Frame) error {
-	if f.IsRSV1() || f.IsRSV2() || f.IsRSV3() {
+	if s.unknownPolicy == UnknownFrameStrict && s.hasUnknownBits(f) {
 		return ErrNonZeroReservedBits
 	}
 
@@ -411,18 +676,25 @@ func (s *WebsocketStream) handleControlFrame(f *Frame) (err error) {
 			pongFrame.SetPong()
 			pongFrame.SetPayload(f.payload)
 			if s.role == RoleClient {
-				pongFrame.Mask()
+				s.mask(pongFrame)
 			}
+			s.acquireBuffer(s.dst)
 			s.pending = append(s.pending, pongFrame)
+			s.scheduleControlFlush()
 		}
 	case OpcodePong:
+		s.keepAlivePong()
 	case OpcodeClose:
+		if !s.validClosePayload(f.payload) {
+			return ErrInvalidClosePayload
+		}
 		switch s.state {
 		case StateHandshake:
 			panic("unreachable")
 		case StateActive:
 			s.state = StateClosedByPeer
 			s.prepareClose(f.payload)
+			s.scheduleControlFlush()
 		case StateClosedByPeer, StateCloseAcked:
 			// ignore
 		case StateClosedByUs:
@@ -438,6 +710,20 @@ func (s *WebsocketStream) handleControlFrame(f *Frame) (err error) {
 	return
 }
 
+// validClosePayload returns true if the payload of a close frame is empty, or
+// holds a valid close code followed by a UTF-8 reason, see IsValidCloseCode
+// and SetUTF8Validation.
+func (s *WebsocketStream) validClosePayload(p []byte) bool {
+	switch {
+	case len(p) == 0:
+		return true
+	case len(p) == 1 || !IsValidCloseCode(DecodeCloseCode(p)):
+		return false
+	default:
+		return s.noUTF8Check || utf8.Valid(p[2:])
+	}
+}
+
 func (s *WebsocketStream) handleDataFrame(f *Frame) error {
 	if IsReserved(f.Opcode()) {
 		return ErrReservedOpcode
@@ -446,15 +732,18 @@ func (s *WebsocketStream) handleDataFrame(f *Frame) error {
 }
 
 func (s *WebsocketStream) Write(b []byte, mt MessageType) error {
-	if len(b) > MaxMessageSize {
+	if len(b) > s.maxMessageSize {
 		return ErrMessageTooBig
 	}
+	if s.mem.OverLimit() {
+		return sonicerrors.ErrMemoryLimit
+	}
 
-	if s.state == StateActive {
+	if s.state.CanWrite() {
 		f := AcquireFrame()
 		f.SetFin()
 		f.SetOpcode(Opcode(mt))
-		f.SetPayload(b)
+		s.setMessagePayload(f, b)
 
 		s.prepareWrite(f)
 		return s.Flush()
@@ -464,7 +753,12 @@ func (s *WebsocketStream) Write(b []byte, mt MessageType) error {
 }
 
 func (s *WebsocketStream) WriteFrame(f *Frame) error {
-	if s.state == StateActive {
+	if s.mem.OverLimit() {
+		ReleaseFrame(f)
+		return sonicerrors.ErrMemoryLimit
+	}
+
+	if s.state.CanWrite() {
 		s.prepareWrite(f)
 		return s.Flush()
 	} else {
@@ -478,16 +772,150 @@ func (s *WebsocketStream) AsyncWrite(
 	mt MessageType,
 	cb func(err error),
 ) {
-	if len(b) > MaxMessageSize {
+	if len(b) > s.maxMessageSize {
 		cb(ErrMessageTooBig)
 		return
 	}
+	if s.mem.OverLimit() {
+		cb(sonicerrors.ErrMemoryLimit)
+		return
+	}
 
-	if s.state == StateActive {
+	if s.state.CanWrite() {
 		f := AcquireFrame()
 		f.SetFin()
 		f.SetOpcode(Opcode(mt))
-		f.SetPayload(b)
+		s.setMessagePayload(f, b)
+
+		s.prepareWrite(f)
+		s.AsyncFlush(cb)
+	} else {
+		cb(sonicerrors.ErrCancelled)
+	}
+}
+
+// WriteSome writes b as the next fragment of a message of type mt, which ends
+// with b if fin is true. This is how a big message, produced piece by piece,
+// is written without being held in a single buffer. The type of the message is
+// the one given with its first fragment; mt is ignored for the next ones.
+//
+// The fragments are written as they are, uncompressed, even if
+// permessage-deflate is negotiated. No other message must be written until the
+// last fragment is, as the fragments of a message cannot be interleaved with
+// other data frames. Control frames can be.
+func (s *WebsocketStream) WriteSome(fin bool, b []byte, mt MessageType) error {
+	if len(b) > s.maxMessageSize {
+		return ErrMessageTooBig
+	}
+	if s.mem.OverLimit() {
+		return sonicerrors.ErrMemoryLimit
+	}
+
+	if s.state.CanWrite() {
+		s.prepareSome(fin, b, mt)
+		return s.Flush()
+	}
+
+	return sonicerrors.ErrCancelled
+}
+
+// AsyncWriteSome is the asynchronous version of WriteSome. cb is invoked once
+// the fragment is written.
+func (s *WebsocketStream) AsyncWriteSome(
+	fin bool,
+	b []byte,
+	mt MessageType,
+	cb func(err error),
+) {
+	if len(b) > s.maxMessageSize {
+		cb(ErrMessageTooBig)
+		return
+	}
+	if s.mem.OverLimit() {
+		cb(sonicerrors.ErrMemoryLimit)
+		return
+	}
+
+	if s.state.CanWrite() {
+		s.prepareSome(fin, b, mt)
+		s.AsyncFlush(cb)
+	} else {
+		cb(sonicerrors.ErrCancelled)
+	}
+}
+
+func (s *WebsocketStream) prepareSome(fin bool, b []byte, mt MessageType) {
+	f := AcquireFrame()
+	if fin {
+		f.SetFin()
+	}
+	if s.fragmenting {
+		f.SetContinuation()
+	} else {
+		f.SetOpcode(Opcode(mt))
+	}
+	s.fragmenting = !fin
+	f.SetPayload(b)
+
+	s.prepareWrite(f)
+}
+
+// WriteShared is the synchronous counterpart of AsyncWriteShared.
+func (s *WebsocketStream) WriteShared(p *RefCountedPayload, mt MessageType) error {
+	if s.role == RoleClient || s.deflate.compresses(len(p.Bytes())) {
+		return s.Write(p.Bytes(), mt)
+	}
+
+	if len(p.Bytes()) > s.maxMessageSize {
+		return ErrMessageTooBig
+	}
+	if s.mem.OverLimit() {
+		return sonicerrors.ErrMemoryLimit
+	}
+
+	if s.state.CanWrite() {
+		f := AcquireFrame()
+		f.SetFin()
+		f.SetOpcode(Opcode(mt))
+		f.setSharedPayload(p)
+
+		s.prepareWrite(f)
+		return s.Flush()
+	}
+
+	return sonicerrors.ErrCancelled
+}
+
+// AsyncWriteShared writes p as a single message of type mt without copying
+// it. The stream holds a reference on p until the message is written, so the
+// caller may release its own reference as soon as AsyncWriteShared returns.
+//
+// Client streams must mask, and therefore modify, the payloads they write, so
+// they copy p like AsyncWrite does.
+func (s *WebsocketStream) AsyncWriteShared(
+	p *RefCountedPayload,
+	mt MessageType,
+	cb func(err error),
+) {
+	if s.role == RoleClient || s.deflate.compresses(len(p.Bytes())) {
+		s.AsyncWrite(p.Bytes(), mt, cb)
+		return
+	}
+
+	if len(p.Bytes()) > s.maxMessageSize {
+		cb(ErrMessageTooBig)
+		return
+	}
+	if s.mem.OverLimit() {
+		cb(sonicerrors.ErrMemoryLimit)
+		return
+	}
+
+	if s.state.CanWrite() {
+		f := AcquireFrame()
+		f.SetFin()
+		f.SetOpcode(Opcode(mt))
+		f.setSharedPayload(p)
 
 		s.prepareWrite(f)
 		s.AsyncFlush(cb)
@@ -497,7 +925,13 @@ func (s *WebsocketStream) AsyncWrite(
 }
 
 func (s *WebsocketStream) AsyncWriteFrame(f *Frame, cb func(err error)) {
-	if s.state == StateActive {
+	if s.mem.OverLimit() {
+		ReleaseFrame(f)
+		cb(sonicerrors.ErrMemoryLimit)
+		return
+	}
+
+	if s.state.CanWrite() {
 		s.prepareWrite(f)
 		s.AsyncFlush(cb)
 	} else {
@@ -510,15 +944,18 @@ func (s *WebsocketStream) prepareWrite(f *Frame) {
 	switch s.role {
 	case RoleClient:
 		if !f.IsMasked() {
-			f.Mask()
+			s.mask(f)
 		}
 	case RoleServer:
 		if f.IsMasked() {
 			f.Unmask()
 		}
 	}
+	s.debugFrame(f)
 
+	s.acquireBuffer(s.dst)
 	s.pending = append(s.pending, f)
+	s.accountMemory()
 }
 
 func (s *WebsocketStream) AsyncClose(
@@ -557,10 +994,13 @@ func (s *WebsocketStream) prepareClose(payload []byte) {
 	closeFrame.SetClose()
 	closeFrame.SetPayload(payload)
 	if s.role == RoleClient {
-		closeFrame.Mask()
+		s.mask(closeFrame)
 	}
+	s.debugFrame(closeFrame)
 
+	s.acquireBuffer(s.dst)
 	s.pending = append(s.pending, closeFrame)
+	s.accountMemory()
 }
 
 func (s *WebsocketStream) Flush() (err error) {
@@ -574,29 +1014,116 @@ func (s *WebsocketStream) Flush() (err error) {
 		flushed++
 	}
 	s.pending = s.pending[flushed:]
+	s.accountMemory()
+	s.endBurst()
 
 	return
 }
 
 func (s *WebsocketStream) AsyncFlush(cb func(err error)) {
+	if s.flushing {
+		// A flush is ongoing; it writes everything that is pending, including
+		// what was added after it started.
+		s.flushWaiters = append(s.flushWaiters, cb)
+		return
+	}
+
+	s.flushing = true
+	s.asyncFlush(cb)
+}
+
+func (s *WebsocketStream) asyncFlush(cb func(err error)) {
 	if len(s.pending) == 0 {
-		cb(nil)
+		s.completeFlush(nil, cb)
 	} else {
 		sent := s.pending[0]
 		s.pending = s.pending[1:]
 
-		s.cs.AsyncWriteNext(sent, func(err error, _ int) {
+		s.asyncWriteFrame(sent, func(err error, _ int) {
 			ReleaseFrame(sent)
 
 			if err != nil {
-				cb(err)
+				s.completeFlush(err, cb)
 			} else {
-				s.AsyncFlush(cb)
+				s.asyncFlush(cb)
 			}
 		})
 	}
 }
 
+// asyncWriteFrame writes f. If the next layer supports vectored writes, the
+// header and the payload of f are written with a single system call, without
+// being copied to the write buffer first. Bytes left in the write buffer by a
+// failed Flush go out first, through the codec.
+func (s *WebsocketStream) asyncWriteFrame(f *Frame, cb sonic.AsyncCallback) {
+	if w, ok := s.stream.(sonic.AsyncVectoredWriter); ok && s.dst.Len() == 0 {
+		w.AsyncWritev(f.vectored(s.iov[:0]), cb)
+	} else {
+		s.cs.AsyncWriteNext(f, cb)
+	}
+}
+
+func (s *WebsocketStream) completeFlush(err error, cb func(err error)) {
+	s.flushing = false
+	s.accountMemory()
+	s.endBurst()
+
+	waiters := s.flushWaiters
+	s.flushWaiters = nil
+
+	cb(err)
+	for _, waiter := range waiters {
+		waiter(err)
+	}
+}
+
+// SetControlFlushDeadline sets how long the replies to control frames, such
+// as pongs and close replies, can stay pending before they are flushed
+// automatically on the IO loop.
+//
+// By default, the deadline is 0 and replies are flushed in the next iteration
+// of the IO loop, so they are not delayed by an idle application which does
+// not read or write. A negative deadline disables automatic flushing, in which
+// case replies are only flushed by Flush, AsyncFlush or by the next read or
+// write.
+func (s *WebsocketStream) SetControlFlushDeadline(d time.Duration) {
+	s.controlFlushDeadline = d
+}
+
+// ControlFlushDeadline returns the deadline set with SetControlFlushDeadline.
+func (s *WebsocketStream) ControlFlushDeadline() time.Duration {
+	return s.controlFlushDeadline
+}
+
+func (s *WebsocketStream) scheduleControlFlush() {
+	if s.controlFlushDeadline < 0 || s.controlFlushScheduled || s.ioc == nil {
+		return
+	}
+
+	flush := func() {
+		s.controlFlushScheduled = false
+		if len(s.pending) > 0 && s.stream != nil && !s.flushing {
+			// A failed write surfaces on the next read or write.
+			s.AsyncFlush(func(error) {})
+		}
+	}
+
+	if s.controlFlushDeadline > 0 {
+		if s.controlFlushTimer == nil {
+			timer, err := sonic.NewTimer(s.ioc)
+			if err != nil {
+				return
+			}
+			s.controlFlushTimer = timer
+		}
+		if s.controlFlushTimer.ScheduleOnce(s.controlFlushDeadline, flush) == nil {
+			s.controlFlushScheduled = true
+		}
+	} else if s.ioc.Post(flush) == nil {
+		s.controlFlushScheduled = true
+	}
+}
+
 func (s *WebsocketStream) Pending() int {
 	return len(s.pending)
 }
@@ -618,7 +1145,7 @@ func (s *WebsocketStream) Handshake(
 	var stream sonic.Stream
 
 	done := make(chan struct{}, 1)
-	s.handshake(addr, extraHeaders, func(rerr error, rstream sonic.Stream) {
+	s.handshake(context.Background(), addr, extraHeaders, func(rerr error, rstream sonic.Stream) {
 		err = rerr
 		stream = rstream
 		done <- struct{}{}
@@ -631,6 +1158,9 @@ func (s *WebsocketStream) Handshake(
 		s.state = StateActive
 		err = s.init(stream)
 	}
+	if err != nil {
+		s.releaseMemory()
+	}
 
 	return
 }
@@ -639,6 +1169,15 @@ func (s *WebsocketStream) AsyncHandshake(
 	addr string,
 	cb func(error),
 	extraHeaders ...Header,
+) {
+	s.AsyncHandshakeContext(context.Background(), addr, cb, extraHeaders...)
+}
+
+func (s *WebsocketStream) AsyncHandshakeContext(
+	ctx context.Context,
+	addr string,
+	cb func(error),
+	extraHeaders ...Header,
 ) {
 	if s.role != RoleClient {
 		cb(ErrWrongHandshakeRole)
@@ -650,7 +1189,7 @@ func (s *WebsocketStream) AsyncHandshake(
 	// I know, this is horrible, but if you help me write a TLS client for sonic
 	// we can asynchronously dial endpoints and remove the need for a goroutine
 	go func() {
-		s.handshake(addr, extraHeaders, func(err error, stream sonic.Stream) {
+		s.handshake(ctx, addr, extraHeaders, func(err error, stream sonic.Stream) {
 			// TODO maybe report this error somehow although this is very fatal
 			_ = s.ioc.Post(func() {
 				if err != nil {
@@ -659,6 +1198,9 @@ func (s *WebsocketStream) AsyncHandshake(
 					s.state = StateActive
 					err = s.init(stream)
 				}
+				if err != nil {
+					s.releaseMemory()
+				}
 				cb(err)
 			})
 		})
@@ -666,6 +1208,7 @@ func (s *WebsocketStream) AsyncHandshake(
 }
 
 func (s *WebsocketStream) handshake(
+	ctx context.Context,
 	addr string,
 	headers []Header,
 	cb func(err error, stream sonic.Stream),
@@ -674,9 +1217,11 @@ func (s *WebsocketStream) handshake(
 	if err != nil {
 		cb(err, nil)
 	} else {
-		s.dial(url, func(err error, stream sonic.Stream) {
+		s.dial(ctx, url, func(err error, stream sonic.Stream) {
 			if err == nil {
-				err = s.upgrade(url, stream, headers)
+				err = s.upgradeContext(ctx, url, stream, headers)
+			} else if ctx.Err() != nil {
+				err = ctx.Err()
 			}
 			cb(err, stream)
 		})
@@ -699,7 +1244,20 @@ func (s *WebsocketStream) resolve(addr string) (url *url.URL, err error) {
 	return
 }
 
+// tlsConfig returns the TLS configuration of the stream, with the session
+// cache of the IO if the configuration does not have one, such that reconnects
+// resume the previous session.
+func (s *WebsocketStream) tlsConfig() *tls.Config {
+	if s.tls.ClientSessionCache != nil || s.ioc == nil {
+		return s.tls
+	}
+	cfg := s.tls.Clone()
+	cfg.ClientSessionCache = s.ioc.TLSSessionCache()
+	return cfg
+}
+
 func (s *WebsocketStream) dial(
+	ctx context.Context,
 	url *url.URL,
 	cb func(err error, stream sonic.Stream),
 ) {
@@ -710,13 +1268,18 @@ func (s *WebsocketStream) dial(
 		port = url.Port()
 	)
 
-	switch url.Scheme {
-	case "http":
+	proxy, err := s.proxyFor(url)
+	switch {
+	case err != nil:
+	case proxy != nil:
+		sc, err = s.dialProxy(ctx, url, proxy)
+	case url.Scheme == "http":
 		if port == "" {
 			port = "80"
 		}
 		addr := url.Hostname() + ":" + port
-		s.conn, err = net.DialTimeout("tcp", addr, DialTimeout)
+		dialer := &net.Dialer{Timeout: DialTimeout}
+		s.conn, err = dialer.DialContext(ctx, "tcp", addr)
 		if err == nil {
 			sc = s.conn.(syscall.Conn)
 		} else {
@@ -725,7 +1288,7 @@ func (s *WebsocketStream) dial(
 			// produce a panic then.
 			s.conn = nil
 		}
-	case "https":
+	case url.Scheme == "https":
 		if s.tls == nil {
 			err = fmt.Errorf(
 				"wss:// scheme endpoints require a TLS configuration",
@@ -737,7 +1300,8 @@ func (s *WebsocketStream) dial(
 				port = "443"
 			}
 			addr := url.Hostname() + ":" + port
-			s.conn, err = tls.DialWithDialer(s.dialer, "tcp", addr, s.tls)
+			dialer := &tls.Dialer{NetDialer: s.dialer, Config: s.tlsConfig()}
+			s.conn, err = dialer.DialContext(ctx, "tcp", addr)
 			if err == nil {
 				sc = s.conn.(*tls.Conn).NetConn().(syscall.Conn)
 			} else {
@@ -752,10 +1316,15 @@ func (s *WebsocketStream) dial(
 	}
 
 	if err == nil {
+		var rw io.ReadWriter = s.conn
+		if tc, ok := s.conn.(*tls.Conn); ok {
+			rw = newTLSReader(tc)
+		}
+
 		// s.ioc is not used by this constructor, so there is NO a race
 		// condition on the io context.
 		sonic.NewAsyncAdapter(
-			s.ioc, sc, s.conn, func(err error, stream *sonic.AsyncAdapter) {
+			s.ioc, sc, rw, func(err error, stream *sonic.AsyncAdapter) {
 				cb(err, stream)
 			}, sonicopts.NoDelay(true))
 	} else {
@@ -763,6 +1332,41 @@ func (s *WebsocketStream) dial(
 	}
 }
 
+// upgradeContext upgrades the dialed stream like upgrade, and interrupts the
+// upgrade once ctx is done, in which case ctx.Err() is returned.
+func (s *WebsocketStream) upgradeContext(
+	ctx context.Context,
+	uri *url.URL,
+	stream sonic.Stream,
+	headers []Header,
+) error {
+	done := ctx.Done()
+	if done == nil {
+		return s.upgrade(uri, stream, headers)
+	}
+
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-done:
+			// Unblocks the request write and the response read.
+			_ = s.conn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+
+	err := s.upgrade(uri, stream, headers)
+	close(stop)
+	<-stopped
+
+	if ctx.Err() != nil {
+		// The deadline may have been set, which fails the next reads.
+		return ctx.Err()
+	}
+	return err
+}
+
 func (s *WebsocketStream) upgrade(
 	uri *url.URL,
 	stream sonic.Stream,
@@ -778,6 +1382,9 @@ func (s *WebsocketStream) upgrade(
 	req.Header.Set("Connection", "upgrade")
 	req.Header.Set("Sec-WebSocket-Key", string(sentKey))
 	req.Header.Set("Sec-Websocket-Version", "13")
+	if s.deflate.cfg != nil {
+		req.Header.Set("Sec-WebSocket-Extensions", s.deflate.cfg.offer())
+	}
 
 	for _, header := range headers {
 		if header.CanonicalKey {
@@ -842,7 +1449,10 @@ func (s *WebsocketStream) upgrade(
 		return ErrCannotUpgrade
 	}
 
-	return nil
+	if err := s.acceptSubprotocol(req, res); err != nil {
+		return err
+	}
+	return s.deflate.acceptResponse(res.Header)
 }
 
 // makeHandshakeKey generates the key of Sec-WebSocket-Key header as well as the
@@ -865,14 +1475,6 @@ func (s *WebsocketStream) makeHandshakeKey() (req, res string) {
 	return
 }
 
-func (s *WebsocketStream) Accept() error {
-	panic("implement me")
-}
-
-func (s *WebsocketStream) AsyncAccept(func(error)) {
-	panic("implement me")
-}
-
 func (s *WebsocketStream) SetControlCallback(ccb ControlCallback) {
 	s.ccb = ccb
 }
@@ -901,28 +1503,176 @@ func (s *WebsocketStream) SetMaxMessageSize(bytes int) {
 	// This is just for checking against the length returned in the frame
 	// header. The sizes of the buffers in which we read or write the messages
 	// are dynamically adjusted in frame_codec.
-	MaxMessageSize = bytes
+	s.maxMessageSize = bytes
+	if s.codec != nil {
+		s.codec.SetMaxPayloadLen(s.maxPayloadLen())
+	}
+}
+
+func (s *WebsocketStream) MaxMessageSize() int {
+	return s.maxMessageSize
 }
 
+func (s *WebsocketStream) SetMaxFrameSize(bytes int) {
+	s.maxFrameSize = bytes
+	if s.codec != nil {
+		s.codec.SetMaxPayloadLen(s.maxPayloadLen())
+	}
+}
+
+func (s *WebsocketStream) MaxFrameSize() int {
+	return s.maxFrameSize
+}
+
+// SetTracer traces the raw bytes of the frames read from and written to the
+// peer, as configured by cfg, see sonic.TracedStream. It applies to the
+// streams initialized by the next handshakes, the upgrade request and
+// response not being traced. A nil cfg disables tracing.
+func (s *WebsocketStream) SetTracer(cfg *sonic.TraceConfig) {
+	s.trace = cfg
+}
+
+// maxPayloadLen returns the maximum length of the payload of a frame read
+// from the peer, past which the frame is rejected from its header alone.
+func (s *WebsocketStream) maxPayloadLen() int {
+	if s.maxFrameSize > 0 && s.maxFrameSize < s.maxMessageSize {
+		return s.maxFrameSize
+	}
+	return s.maxMessageSize
+}
+
+func (s *WebsocketStream) SetMaxMessageFragments(n int) {
+	s.maxMessageFragments = n
+}
+
+func (s *WebsocketStream) MaxMessageFragments() int {
+	return s.maxMessageFragments
+}
+
+// MemoryAccount returns the memory account of the stream, which holds the
+// bytes of its read and write buffers and of its pending frames. The bytes
+// are released once the next layer is closed with CloseNextLayer, or once
+// the handshake fails.
+//
+// Writes fail with sonicerrors.ErrMemoryLimit while the account, or the
+// account of the IO, is over its limit. Control frames are not affected.
+func (s *WebsocketStream) MemoryAccount() *sonic.MemoryAccount {
+	return s.mem
+}
+
+func (s *WebsocketStream) accountMemory() {
+	if s.memReleased {
+		return
+	}
+
+	n := s.src.Cap() + s.dst.Cap() + cap(s.hb)
+	for _, f := range s.pending {
+		n += len(f.header) + len(f.mask)
+		if f.shared == nil {
+			// Shared payloads are accounted by their owner.
+			n += cap(f.payload)
+		}
+	}
+	s.mem.Set(n)
+}
+
+// releaseMemory releases the bytes of the stream from its memory account, such
+// that a stream which is closed, or which failed its handshake, no longer
+// counts towards the memory of the IO. They are accounted again if the stream
+// is reused.
+func (s *WebsocketStream) releaseMemory() {
+	if !s.memReleased {
+		s.mem.Close()
+		s.memReleased = true
+	}
+}
+
+func (s *WebsocketStream) SetUserData(data any) {
+	s.userData = data
+}
+
+func (s *WebsocketStream) UserData() any {
+	return s.userData
+}
+
+// RemoteAddr returns the address of the peer, or nil if the stream has no
+// connection.
 func (s *WebsocketStream) RemoteAddr() net.Addr {
-	return s.conn.RemoteAddr()
+	if c := s.addressable(); c != nil {
+		return c.RemoteAddr()
+	}
+	return nil
 }
 
+// LocalAddr returns the local address of the connection, or nil if the stream
+// has no connection.
 func (s *WebsocketStream) LocalAddr() net.Addr {
-	return s.conn.LocalAddr()
+	if c := s.addressable(); c != nil {
+		return c.LocalAddr()
+	}
+	return nil
+}
+
+type addressable interface {
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+}
+
+// addressable returns the connection of the stream: the net.Conn dialed by a
+// client stream, or the sonic.Conn a server stream was given, which may be
+// wrapped, for example by a sonic.TracedStream.
+func (s *WebsocketStream) addressable() addressable {
+	if s.conn != nil {
+		return s.conn
+	}
+	for stream := s.stream; stream != nil; {
+		if c, ok := stream.(addressable); ok {
+			return c
+		}
+		layered, ok := stream.(interface{ NextLayer() sonic.Stream })
+		if !ok {
+			break
+		}
+		stream = layered.NextLayer()
+	}
+	return nil
 }
 
+// RawFd returns the file descriptor of the connection, or -1 if the stream has
+// no connection.
 func (s *WebsocketStream) RawFd() int {
-	if s.NextLayer() != nil {
-		return s.NextLayer().(*sonic.AsyncAdapter).RawFd()
+	if s.stream != nil {
+		return s.stream.RawFd()
 	}
 	return -1
 }
 
 func (s *WebsocketStream) CloseNextLayer() (err error) {
+	s.releaseMemory()
+
+	if s.controlFlushTimer != nil {
+		_ = s.controlFlushTimer.Close()
+		s.controlFlushTimer = nil
+		s.controlFlushScheduled = false
+	}
+	if s.idleTimer != nil {
+		_ = s.idleTimer.Close()
+		s.idleTimer = nil
+	}
+	if s.keepAlive.timer != nil {
+		_ = s.keepAlive.timer.Close()
+		s.keepAlive.timer = nil
+	}
+	if s.inbound.timer != nil {
+		_ = s.inbound.timer.Close()
+		s.inbound.timer = nil
+	}
 	if s.conn != nil {
 		err = s.conn.Close()
 		s.conn = nil
+	} else if s.stream != nil {
+		// Server streams are given their connection, see UpgradeRouter.
+		err = s.stream.Close()
 	}
 	return
 }
@@ -1,15 +1,23 @@
 package websocket
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
 	"testing"
 	"time"
 
 	"github.com/csdenboer/sonic"
+	"github.com/csdenboer/sonic/sonicerrors"
+	"github.com/csdenboer/sonic/sonicopts"
 )
 
 func assertState(t *testing.T, ws Stream, expected StreamState) {
@@ -154,6 +162,72 @@ func TestClientFailedHandshakeNoServer(t *testing.T) {
 	assertState(t, ws, StateTerminated)
 }
 
+func TestClientHandshakeContextCancelled(t *testing.T) {
+	// The server accepts the connection but never answers the upgrade request.
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			_, _ = io.Copy(io.Discard, conn)
+		}
+	}()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.CloseNextLayer()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := false
+	ws.AsyncHandshakeContext(ctx, "ws://"+ln.Addr().String(), func(err error) {
+		done = true
+		if err != context.DeadlineExceeded {
+			t.Fatalf("expected context.DeadlineExceeded got=%v", err)
+		}
+	})
+
+	for start := time.Now(); !done && time.Since(start) < 5*time.Second; {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if !done {
+		t.Fatal("handshake not interrupted")
+	}
+	assertState(t, ws, StateTerminated)
+
+	// A context which is done fails the handshake before dialing.
+	ws, err = NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	done = false
+	ws.AsyncHandshakeContext(ctx, "ws://"+ln.Addr().String(), func(err error) {
+		done = true
+		if err != context.Canceled {
+			t.Fatalf("expected context.Canceled got=%v", err)
+		}
+	})
+	for start := time.Now(); !done && time.Since(start) < 5*time.Second; {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if !done {
+		t.Fatal("handshake not completed")
+	}
+}
+
 func TestClientSuccessfulHandshake(t *testing.T) {
 	srv := &MockServer{}
 
@@ -849,7 +923,7 @@ func TestClientAsyncReadCloseFrame(t *testing.T) {
 			t.Fatal("should have received EOF")
 		}
 
-		assertState(t, ws, StateTerminated)
+		assertState(t, ws, StateClosedByPeer)
 	})
 
 	if !ran {
@@ -1047,6 +1121,65 @@ func TestClientAsyncWrite(t *testing.T) {
 	})
 }
 
+func TestClientAsyncWriteSome(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock := NewMockStream()
+	ws.state = StateActive
+	ws.init(mock)
+
+	written := 0
+	onWrite := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		written++
+	}
+	ws.AsyncWriteSome(false, []byte("hel"), TypeText, onWrite)
+	ws.AsyncWriteSome(false, []byte("lo "), TypeBinary, onWrite)
+	ws.AsyncWriteSome(true, []byte("world"), TypeText, onWrite)
+	if err := ws.WriteSome(true, []byte("bye"), TypeBinary); err != nil {
+		t.Fatal(err)
+	}
+	if written != 3 {
+		t.Fatalf("expected 3 written fragments got=%d", written)
+	}
+
+	expect := []struct {
+		opcode  Opcode
+		fin     bool
+		payload string
+	}{
+		{OpcodeText, false, "hel"},
+		{OpcodeContinuation, false, "lo "},
+		{OpcodeContinuation, true, "world"},
+		{OpcodeBinary, true, "bye"},
+	}
+
+	mock.b.Commit(mock.b.WriteLen())
+	for _, e := range expect {
+		f := AcquireFrame()
+		if _, err := f.ReadFrom(mock.b); err != nil {
+			t.Fatal(err)
+		}
+		if !f.IsMasked() {
+			t.Fatal("expected a masked frame")
+		}
+		f.Unmask()
+		if f.Opcode() != e.opcode || f.IsFin() != e.fin || string(f.Payload()) != e.payload {
+			t.Fatalf("expected opcode=%s fin=%t payload=%q got opcode=%s fin=%t payload=%q",
+				e.opcode, e.fin, e.payload, f.Opcode(), f.IsFin(), f.Payload())
+		}
+		ReleaseFrame(f)
+	}
+}
+
 func TestClientClose(t *testing.T) {
 	ioc := sonic.MustIO()
 	defer ioc.Close()
@@ -1343,3 +1476,1830 @@ func TestClientAsyncCloseHandshakePeerStarts(t *testing.T) {
 		}
 	})
 }
+
+func TestClientAutoFlushesPong(t *testing.T) {
+	for _, deadline := range []time.Duration{0, 5 * time.Millisecond} {
+		ioc := sonic.MustIO()
+
+		ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws.SetControlFlushDeadline(deadline)
+
+		ws.state = StateActive
+		mock := NewMockStream()
+		ws.init(mock)
+
+		ws.src.Write([]byte{
+			byte(OpcodePing) | 1<<7, 2, 0x01, 0x02, // fin=true, type=ping, payload_len=2
+		})
+
+		ws.AsyncNextFrame(func(err error, f *Frame) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !f.IsPing() {
+				t.Fatal("expected ping")
+			}
+		})
+
+		if ws.Pending() != 1 {
+			t.Fatal("should have a pending pong")
+		}
+
+		start := time.Now()
+		for time.Since(start) < time.Second && ws.Pending() > 0 {
+			_, _ = ioc.PollOne()
+		}
+
+		if ws.Pending() != 0 {
+			t.Fatalf("deadline=%s: pong was not flushed", deadline)
+		}
+		if mock.b.WriteLen() == 0 {
+			t.Fatalf("deadline=%s: pong was not written to the next layer", deadline)
+		}
+
+		_ = ws.CloseNextLayer()
+		ioc.Close()
+	}
+}
+
+func TestClientAutoFlushDisabled(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.SetControlFlushDeadline(-1)
+
+	ws.state = StateActive
+	mock := NewMockStream()
+	ws.init(mock)
+
+	ws.src.Write([]byte{
+		byte(OpcodePing) | 1<<7, 2, 0x01, 0x02, // fin=true, type=ping, payload_len=2
+	})
+
+	ws.AsyncNextFrame(func(err error, f *Frame) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	for i := 0; i < 10; i++ {
+		_, _ = ioc.PollOne()
+	}
+
+	if ws.Pending() != 1 {
+		t.Fatal("pong should still be pending")
+	}
+}
+
+func writeFragmentedMessage(ws *WebsocketStream, fragments int) {
+	for i := 0; i < fragments; i++ {
+		var header byte
+		if i == 0 {
+			header = byte(OpcodeText)
+		} else {
+			header = byte(OpcodeContinuation)
+		}
+		if i == fragments-1 {
+			header |= 1 << 7
+		}
+		ws.src.Write([]byte{header, 1, 'a'}) // payload_len=1
+	}
+}
+
+func assertClosedWithTooBig(t *testing.T, ws *WebsocketStream, mock *MockStream) {
+	if ws.Pending() != 0 {
+		t.Fatal("close frame should have been flushed")
+	}
+
+	mock.b.Commit(mock.b.WriteLen())
+	closeFrame := NewFrame()
+	if _, err := closeFrame.ReadFrom(mock.b); err != nil {
+		t.Fatal(err)
+	}
+	if !closeFrame.IsClose() {
+		t.Fatal("expected a close frame")
+	}
+	closeFrame.Unmask()
+	cc, _ := DecodeCloseFramePayload(closeFrame.payload)
+	if cc != CloseTooBig {
+		t.Fatalf("expected close code=%d got=%d", CloseTooBig, cc)
+	}
+	assertState(t, ws, StateClosedByUs)
+}
+
+func TestClientReadMessageWithinMaxFragments(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.SetMaxMessageFragments(3)
+
+	ws.state = StateActive
+	ws.init(NewMockStream())
+
+	writeFragmentedMessage(ws, 3)
+
+	b := make([]byte, 128)
+	mt, n, err := ws.NextMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mt != TypeText || string(b[:n]) != "aaa" {
+		t.Fatalf("invalid message type=%s payload=%s", mt, b[:n])
+	}
+}
+
+func TestClientReadMessageTooManyFragments(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.SetMaxMessageFragments(3)
+
+	ws.state = StateActive
+	mock := NewMockStream()
+	ws.init(mock)
+
+	writeFragmentedMessage(ws, 5)
+
+	b := make([]byte, 128)
+	_, _, err = ws.NextMessage(b)
+	if !errors.Is(err, ErrTooManyFragments) {
+		t.Fatalf("expected ErrTooManyFragments got=%v", err)
+	}
+	assertClosedWithTooBig(t, ws, mock)
+}
+
+func TestClientAsyncReadMessageTooManyFragments(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.SetMaxMessageFragments(3)
+
+	ws.state = StateActive
+	mock := NewMockStream()
+	ws.init(mock)
+
+	writeFragmentedMessage(ws, 5)
+
+	ran := false
+	b := make([]byte, 128)
+	ws.AsyncNextMessage(b, func(err error, _ int, _ MessageType) {
+		ran = true
+		if !errors.Is(err, ErrTooManyFragments) {
+			t.Fatalf("expected ErrTooManyFragments got=%v", err)
+		}
+	})
+	if !ran {
+		t.Fatal("async read did not run")
+	}
+	assertClosedWithTooBig(t, ws, mock)
+}
+
+func TestClientReadFrameTooBig(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws.state = StateActive
+	mock := NewMockStream()
+	ws.init(mock)
+	ws.SetMaxFrameSize(4)
+
+	ws.src.Write([]byte{0x82, 4, 1, 2, 3, 4})
+	if f, err := ws.NextFrame(); err != nil || f.PayloadLen() != 4 {
+		t.Fatalf("expected a frame within the limit got=%v", err)
+	}
+
+	// The header advertises 2^32 bytes, none of which are buffered.
+	ws.src.Write([]byte{0x82, 127, 0, 0, 0, 1, 0, 0, 0, 0})
+	if _, err := ws.NextFrame(); !errors.Is(err, ErrPayloadOverMaxSize) {
+		t.Fatalf("expected ErrPayloadOverMaxSize got=%v", err)
+	}
+	assertClosedWithTooBig(t, ws, mock)
+}
+
+func TestClientAsyncReadFrameTooBig(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The limit is set before the stream is active.
+	ws.SetMaxFrameSize(4)
+	if ws.MaxFrameSize() != 4 {
+		t.Fatalf("expected a max frame size of 4 got=%d", ws.MaxFrameSize())
+	}
+
+	ws.state = StateActive
+	mock := NewMockStream()
+	ws.init(mock)
+
+	ws.src.Write([]byte{0x82, 126, 0xFF, 0xFF})
+	ran := false
+	ws.AsyncNextFrame(func(err error, _ *Frame) {
+		ran = true
+		if !errors.Is(err, ErrPayloadOverMaxSize) {
+			t.Fatalf("expected ErrPayloadOverMaxSize got=%v", err)
+		}
+	})
+	if !ran {
+		t.Fatal("async read did not run")
+	}
+	assertClosedWithTooBig(t, ws, mock)
+}
+
+func TestClientReadMessageTooBig(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.SetMaxMessageSize(4)
+
+	ws.state = StateActive
+	mock := NewMockStream()
+	ws.init(mock)
+
+	// Every frame is within the limit, but not the message.
+	writeFragmentedMessage(ws, 5)
+
+	b := make([]byte, 128)
+	if _, _, err = ws.NextMessage(b); !errors.Is(err, ErrMessageTooBig) {
+		t.Fatalf("expected ErrMessageTooBig got=%v", err)
+	}
+	assertClosedWithTooBig(t, ws, mock)
+
+	if MaxMessageSize == 4 {
+		t.Fatal("the limit of a stream should not change the default")
+	}
+}
+
+func TestStreamUserData(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type session struct{ name string }
+
+	if _, ok := sonic.UserDataOf[*session](ws); ok {
+		t.Fatal("no user data should be attached")
+	}
+
+	ws.SetUserData(&session{name: "alice"})
+	s, ok := sonic.UserDataOf[*session](ws)
+	if !ok || s.name != "alice" {
+		t.Fatal("should have gotten the attached session")
+	}
+
+	if _, ok := sonic.UserDataOf[string](ws); ok {
+		t.Fatal("the user data is not a string")
+	}
+}
+
+func TestStreamMemoryAccounting(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inUse := ws.MemoryAccount().InUse()
+	if inUse < 2*4096 {
+		t.Fatalf("expected the buffers to be accounted got=%d", inUse)
+	}
+	if ioc.MemoryAccount().InUse() != inUse {
+		t.Fatal("expected the stream to be accounted by the IO")
+	}
+
+	ws.state = StateActive
+	ws.prepareWrite(NewFrame())
+	if ws.MemoryAccount().InUse() <= inUse {
+		t.Fatal("expected the pending frame to be accounted")
+	}
+
+	ioc.MemoryAccount().SetLimit(1)
+
+	var writeErr error
+	ws.AsyncWrite([]byte("hello"), TypeText, func(err error) {
+		writeErr = err
+	})
+	if writeErr != sonicerrors.ErrMemoryLimit {
+		t.Fatalf("expected ErrMemoryLimit got=%v", writeErr)
+	}
+}
+
+func TestStreamMemoryReleased(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	// Closed streams release their bytes.
+	for i := 0; i < 100; i++ {
+		ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws.state = StateActive
+		ws.init(NewMockStream())
+		ws.prepareWrite(NewFrame())
+		if err := ws.CloseNextLayer(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if inUse := ioc.MemoryAccount().InUse(); inUse != 0 {
+		t.Fatalf("expected the closed streams to release their memory got=%d", inUse)
+	}
+
+	// So do the streams which failed their handshake.
+	var ws *WebsocketStream
+	for i := 0; i < 100; i++ {
+		var err error
+		ws, err = NewWebsocketStream(ioc, nil, RoleServer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mock := NewMockStream()
+		_, _ = mock.Write([]byte("GET /chat HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		mock.b.Commit(mock.b.WriteLen())
+		if err := ws.Accept(mock); !errors.Is(err, ErrCannotUpgrade) {
+			t.Fatalf("expected ErrCannotUpgrade got=%v", err)
+		}
+	}
+	if inUse := ioc.MemoryAccount().InUse(); inUse != 0 {
+		t.Fatalf("expected the failed streams to release their memory got=%d", inUse)
+	}
+
+	// A reused stream is accounted again.
+	ws.reset()
+	if ws.MemoryAccount().InUse() == 0 || ioc.MemoryAccount().InUse() != ws.MemoryAccount().InUse() {
+		t.Fatal("expected the reused stream to be accounted")
+	}
+}
+
+func TestStreamIdleRelease(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	send := make(chan struct{})
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		<-send
+		f := NewFrame()
+		f.SetFin()
+		f.SetText()
+		f.SetPayload([]byte("hello"))
+		f.Mask()
+		_, _ = f.WriteTo(conn)
+		time.Sleep(time.Second)
+	}()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	conn, err := sonic.Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, err := NewWebsocketStream(ioc, nil, RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.state = StateActive
+	if err := ws.init(conn); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.CloseNextLayer()
+
+	if err := ws.SetIdleRelease(5 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		got     string
+		readErr error
+		done    bool
+	)
+	ws.AsyncNextFrame(func(err error, f *Frame) {
+		if err == nil {
+			got = string(f.Payload())
+		}
+		readErr, done = err, true
+	})
+
+	// The stream is parked while it waits for the peer.
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline) && ws.MemoryAccount().InUse() > 1024; {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if ws.src.Cap() != 0 || ws.dst.Cap() != 0 {
+		t.Fatal("expected the buffers of the idle stream to be released")
+	}
+	if done {
+		t.Fatal("parking should not complete the outstanding read")
+	}
+
+	close(send)
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline) && !done; {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if got != "hello" {
+		t.Fatalf("expected hello got=%s", got)
+	}
+	if ws.src.Cap() == 0 {
+		t.Fatal("expected the read buffer to be taken back")
+	}
+}
+
+func TestClientMaskKeySourceAndPolicy(t *testing.T) {
+	newClient := func(policy MaskPolicy) (*WebsocketStream, *MockStream) {
+		ws, err := NewWebsocketStream(nil, nil, RoleClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws.SetMaskKeySource(bytes.NewReader([]byte{1, 2, 3, 4, 5, 6, 7, 8}))
+		ws.SetMaskPolicy(policy)
+		ws.state = StateActive
+		mock := NewMockStream()
+		ws.init(mock)
+		return ws, mock
+	}
+	queue := func(ws *WebsocketStream, payload string) {
+		f := AcquireFrame()
+		f.SetFin()
+		f.SetText()
+		f.SetPayload([]byte(payload))
+		ws.prepareWrite(f)
+	}
+	assertFrame := func(mock *MockStream, key []byte, payload string) {
+		t.Helper()
+		mock.b.Commit(mock.b.WriteLen())
+		f := AcquireFrame()
+		defer ReleaseFrame(f)
+		if _, err := f.ReadFrom(mock.b); err != nil {
+			t.Fatal(err)
+		}
+		if !f.IsMasked() || !bytes.Equal(f.MaskKey()[:4], key) {
+			t.Fatalf("expected the frame to be masked with %v got=%v", key, f.MaskKey())
+		}
+		f.Unmask()
+		if string(f.Payload()) != payload {
+			t.Fatalf("expected payload %q got=%q", payload, f.Payload())
+		}
+	}
+
+	// One key per frame, read from the source in order.
+	ws, mock := newClient(MaskPerFrame)
+	queue(ws, "hello")
+	queue(ws, "world")
+	if err := ws.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	assertFrame(mock, []byte{1, 2, 3, 4}, "hello")
+	assertFrame(mock, []byte{5, 6, 7, 8}, "world")
+
+	// One key for the frames of a burst, and a new key for the next burst.
+	ws, mock = newClient(MaskPerBurst)
+	queue(ws, "hello")
+	queue(ws, "world")
+	if err := ws.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Write([]byte("again"), TypeText); err != nil {
+		t.Fatal(err)
+	}
+	assertFrame(mock, []byte{1, 2, 3, 4}, "hello")
+	assertFrame(mock, []byte{1, 2, 3, 4}, "world")
+	assertFrame(mock, []byte{5, 6, 7, 8}, "again")
+
+	// An exhausted source falls back to crypto/rand.
+	queue(ws, "random")
+	if err := ws.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	mock.b.Commit(mock.b.WriteLen())
+	f := AcquireFrame()
+	defer ReleaseFrame(f)
+	if _, err := f.ReadFrom(mock.b); err != nil {
+		t.Fatal(err)
+	}
+	f.Unmask()
+	if string(f.Payload()) != "random" {
+		t.Fatalf("expected payload %q got=%q", "random", f.Payload())
+	}
+}
+
+func TestClientNextMessageInto(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.state = StateActive
+	ws.init(NewMockStream())
+
+	writeFragmentedMessage(ws, 3)
+	writeFragmentedMessage(ws, 2)
+	writeFragmentedMessage(ws, 1)
+
+	// The payloads are appended to the write area, after what the caller
+	// already put in the buffer.
+	dst := sonic.NewByteBuffer()
+	dst.WriteString("batch:")
+	dst.Commit(len("batch:"))
+
+	mt, n, err := ws.NextMessageInto(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mt != TypeText || n != 3 {
+		t.Fatalf("expected a text message of 3 bytes got=%s %d", mt, n)
+	}
+
+	read := 0
+	var onMessage AsyncMessageHandler
+	onMessage = func(err error, n int, mt MessageType) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mt != TypeText {
+			t.Fatalf("expected a text message got=%s", mt)
+		}
+		read++
+		if read < 2 {
+			ws.AsyncNextMessageInto(dst, onMessage)
+		}
+	}
+	ws.AsyncNextMessageInto(dst, onMessage)
+
+	if read != 2 {
+		t.Fatalf("expected 2 messages got=%d", read)
+	}
+	if string(dst.Data()) != "batch:" {
+		t.Fatalf("expected the read area to be left untouched got=%q", dst.Data())
+	}
+	dst.Commit(dst.WriteLen())
+	if string(dst.Data()) != "batch:aaaaaa" {
+		t.Fatalf("expected the payloads to be appended got=%q", dst.Data())
+	}
+}
+
+func TestStreamNextMessageSpill(t *testing.T) {
+	dir := t.TempDir()
+
+	newStream := func(role Role, frames ...*Frame) (*WebsocketStream, *MockStream) {
+		ws, err := NewWebsocketStream(nil, nil, role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws.SetSpill(SpillConfig{Threshold: 64 * 1024, Dir: dir, MaxSize: 4 * 1024 * 1024})
+		ws.state = StateActive
+		mock := NewMockStream()
+		ws.init(mock)
+
+		for _, f := range frames {
+			if _, err := f.WriteTo(mock.b); err != nil {
+				t.Fatal(err)
+			}
+		}
+		mock.b.Commit(mock.b.WriteLen())
+		return ws, mock
+	}
+	newFrame := func(payload []byte, masked bool) *Frame {
+		f := NewFrame()
+		f.SetFin()
+		f.SetBinary()
+		f.SetPayload(append([]byte{}, payload...))
+		if masked {
+			f.Mask()
+		}
+		return f
+	}
+	assertPayload := func(p *MessagePayload, expected []byte) {
+		t.Helper()
+		b, err := io.ReadAll(p.Reader())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, expected) || p.Size() != int64(len(expected)) {
+			t.Fatalf("expected a payload of %d bytes got %d", len(expected), len(b))
+		}
+	}
+
+	big := make([]byte, 1024*1024)
+	for i := range big {
+		big[i] = byte(i % 251)
+	}
+
+	// A small message is held in memory.
+	ws, _ := newStream(RoleClient, newFrame([]byte("hello"), false))
+	p, err := ws.NextMessageSpill()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Spilled() || string(p.Bytes()) != "hello" || p.Type() != TypeBinary {
+		t.Fatalf("expected an in-memory binary message got spilled=%v %q", p.Spilled(), p.Bytes())
+	}
+	assertPayload(p, []byte("hello"))
+
+	// A frame bigger than the maximum message size is streamed to a file,
+	// without growing the read buffer.
+	ws, _ = newStream(RoleClient, newFrame(big, false))
+	p, err = ws.NextMessageSpill()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Spilled() || p.Bytes() != nil {
+		t.Fatal("expected the message to be spilled")
+	}
+	assertPayload(p, big)
+	if ws.src.Cap() > 4*bufferSize {
+		t.Fatalf("expected the read buffer to stay small got=%d", ws.src.Cap())
+	}
+	name := p.File().Name()
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Fatal("expected the file to be removed on close")
+	}
+
+	// Masked frames are unmasked as they are streamed, in fragments mixing
+	// small and big frames, and interleaved with control frames.
+	first := newFrame(big[:100], true)
+	first.header[0] &^= finBit
+	middle := newFrame(big[100:200000], true)
+	middle.header[0] = byte(OpcodeContinuation)
+	ping := NewFrame()
+	ping.SetFin()
+	ping.SetPing()
+	ping.Mask()
+	last := newFrame(big[200000:], true)
+	last.header[0] = finBit | byte(OpcodeContinuation)
+
+	ws, _ = newStream(RoleServer, first, middle, ping, last)
+	var pings int
+	ws.SetControlCallback(func(mt MessageType, _ []byte) {
+		if mt == TypePing {
+			pings++
+		}
+	})
+	var got *MessagePayload
+	ws.AsyncNextMessageSpill(func(err error, p *MessagePayload) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = p
+	})
+	if got == nil {
+		t.Fatal("expected the message to be read")
+	}
+	defer got.Close()
+	if !got.Spilled() || got.Type() != TypeBinary || pings != 1 {
+		t.Fatalf("expected a spilled binary message after a ping got spilled=%v type=%s pings=%d",
+			got.Spilled(), got.Type(), pings)
+	}
+	assertPayload(got, big)
+
+	// Messages over the maximum size are rejected.
+	ws, _ = newStream(RoleClient, newFrame(make([]byte, 5*1024*1024), false))
+	if _, err := ws.NextMessageSpill(); !errors.Is(err, ErrMessageTooBig) {
+		t.Fatalf("expected ErrMessageTooBig got=%v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the open spilled message to be left got=%d files", len(entries))
+	}
+}
+
+func TestClientInboundRateLimitDelay(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws.state = StateActive
+	mock := NewMockStream()
+	ws.init(mock)
+
+	const n = 5
+	for i := 0; i < n; i++ {
+		ws.src.Write([]byte{byte(OpcodeText) | 1<<7, 1, 'a'}) // fin=true, type=text, payload_len=1
+	}
+
+	ws.SetInboundRateLimit(100, 2, RateLimitDelay)
+
+	var (
+		start = time.Now()
+		read  = 0
+		b     = make([]byte, 128)
+		onMsg AsyncMessageHandler
+	)
+	onMsg = func(err error, _ int, _ MessageType) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		read++
+		if read < n {
+			ws.AsyncNextMessage(b, onMsg)
+		}
+	}
+	ws.AsyncNextMessage(b, onMsg)
+	if read != 2 {
+		t.Fatalf("expected a burst of 2 messages got=%d", read)
+	}
+
+	for read < n && time.Since(start) < 5*time.Second {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if read != n {
+		t.Fatalf("expected %d messages got=%d", n, read)
+	}
+
+	// 3 messages over the burst at 100 messages per second.
+	if elapsed := time.Since(start); elapsed < 25*time.Millisecond {
+		t.Fatalf("expected the reads to be delayed got=%s", elapsed)
+	}
+	if ws.InboundRateLimited() == 0 {
+		t.Fatal("expected delayed reads to be counted")
+	}
+	assertState(t, ws, StateActive)
+}
+
+func TestClientInboundRateLimitClose(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws.state = StateActive
+	mock := NewMockStream()
+	ws.init(mock)
+
+	// Pings count towards the rate as well.
+	ws.src.Write([]byte{byte(OpcodeText) | 1<<7, 1, 'a'})
+	ws.src.Write([]byte{byte(OpcodePing) | 1<<7, 0})
+	ws.src.Write([]byte{byte(OpcodeText) | 1<<7, 1, 'b'})
+
+	ws.SetInboundRateLimit(1, 2, RateLimitClose)
+
+	b := make([]byte, 128)
+	if _, _, err := ws.NextMessage(b); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ws.NextMessage(b); err != ErrRateLimited {
+		t.Fatalf("expected ErrRateLimited got=%v", err)
+	}
+	assertState(t, ws, StateClosedByUs)
+	if ws.InboundRateLimited() != 1 {
+		t.Fatalf("expected 1 rejected message got=%d", ws.InboundRateLimited())
+	}
+
+	// The pong reply went out before the close frame.
+	mock.b.Commit(mock.b.WriteLen())
+
+	f := AcquireFrame()
+	defer ReleaseFrame(f)
+	for {
+		f.Reset()
+		if _, err := f.ReadFrom(mock.b); err != nil {
+			t.Fatal(err)
+		}
+		if f.IsClose() {
+			break
+		}
+	}
+	f.Unmask()
+	if cc, _ := DecodeCloseFramePayload(f.payload); cc != ClosePolicyError {
+		t.Fatalf("expected close code %d got=%d", ClosePolicyError, cc)
+	}
+}
+
+func TestClientUnknownFramePolicy(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	// A text frame with RSV1 set, a frame with the reserved data opcode 0x3, a
+	// frame with the reserved control opcode 0xB, then a plain text frame.
+	frames := []byte{
+		byte(OpcodeText) | 1<<7 | 1<<6, 1, 'a',
+		byte(OpcodeRsv3) | 1<<7, 1, 'b',
+		byte(OpcodeCrsvb) | 1<<7, 1, 'c',
+		byte(OpcodeText) | 1<<7, 1, 'd',
+	}
+
+	newStream := func(policy UnknownFramePolicy, cb UnknownFrameCallback) *WebsocketStream {
+		ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws.state = StateActive
+		ws.init(NewMockStream())
+		ws.src.Write(frames)
+		ws.SetUnknownFramePolicy(policy, cb)
+		return ws
+	}
+
+	readAll := func(ws *WebsocketStream) (payloads string, err error) {
+		b := make([]byte, 128)
+		for i := 0; i < 4; i++ {
+			var n int
+			_, n, err = ws.NextMessage(b)
+			if err != nil {
+				return
+			}
+			payloads += string(b[:n])
+		}
+		return
+	}
+
+	// Strict by default.
+	ws := newStream(UnknownFrameStrict, nil)
+	if ws.UnknownFramePolicy() != UnknownFrameStrict {
+		t.Fatal("expected the strict policy by default")
+	}
+	if _, err := readAll(ws); err != ErrNonZeroReservedBits {
+		t.Fatalf("expected ErrNonZeroReservedBits got=%v", err)
+	}
+	assertState(t, ws, StateClosedByUs)
+	ws.pending[0].Unmask()
+	if cc, _ := DecodeCloseFramePayload(ws.pending[0].payload); cc != CloseProtocolError {
+		t.Fatalf("expected a close with %d got=%d", CloseProtocolError, cc)
+	}
+
+	ws = newStream(UnknownFrameIgnore, nil)
+	if payloads, err := readAll(ws); err != io.EOF || payloads != "ad" {
+		t.Fatalf("expected the payloads ad before EOF got=%q %v", payloads, err)
+	}
+
+	// The asynchronous reads skip the frames too.
+	ws = newStream(UnknownFrameIgnore, nil)
+	b, reads := make([]byte, 128), 0
+	ws.AsyncNextMessage(b, func(err error, n int, _ MessageType) {
+		reads++
+		if err != nil || string(b[:n]) != "a" {
+			t.Fatalf("expected the payload a got=%q %v", b[:n], err)
+		}
+		ws.AsyncNextMessage(b, func(err error, n int, _ MessageType) {
+			reads++
+			if err != nil || string(b[:n]) != "d" {
+				t.Fatalf("expected the payload d got=%q %v", b[:n], err)
+			}
+		})
+	})
+	if reads != 2 {
+		t.Fatalf("expected 2 reads got=%d", reads)
+	}
+
+	var delivered []Opcode
+	ws = newStream(UnknownFrameDeliver, func(f *Frame) {
+		delivered = append(delivered, f.Opcode())
+	})
+	if payloads, err := readAll(ws); err != io.EOF || payloads != "d" {
+		t.Fatalf("expected the payload d before EOF got=%q %v", payloads, err)
+	}
+	if len(delivered) != 3 || delivered[0] != OpcodeText || delivered[1] != OpcodeRsv3 || delivered[2] != OpcodeCrsvb {
+		t.Fatalf("wrong delivered frames %v", delivered)
+	}
+}
+
+func TestServerAccept(t *testing.T) {
+	const addr = "localhost:8090"
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(ioc, "tcp", addr, sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var accepted []error
+	var onAccept sonic.AcceptCallback
+	onAccept = func(err error, conn sonic.Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln.AsyncAccept(onAccept)
+
+		ws, err := NewWebsocketStream(ioc, nil, RoleServer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = ws.SetDeflate(&DeflateConfig{})
+		ws.SetUpgradeResponseCallback(func(res *http.Response) {
+			res.Header.Set("X-Server", "sonic")
+		})
+		ws.AsyncAccept(conn, func(err error) {
+			accepted = append(accepted, err)
+			if err != nil {
+				_ = conn.Close()
+				return
+			}
+			assertState(t, ws, StateActive)
+
+			b := make([]byte, 128)
+			ws.AsyncNextMessage(b, func(err error, n int, mt MessageType) {
+				if err != nil {
+					_ = ws.CloseNextLayer()
+					return
+				}
+				ws.AsyncWrite(b[:n], mt, func(error) {})
+			})
+		})
+	}
+	ln.AsyncAccept(onAccept)
+
+	results := make(chan error, 2)
+	go func() {
+		cioc := sonic.MustIO()
+		defer cioc.Close()
+
+		ws, err := NewWebsocketStream(cioc, nil, RoleClient)
+		if err != nil {
+			results <- err
+			return
+		}
+		_ = ws.SetDeflate(&DeflateConfig{})
+		var header string
+		ws.SetUpgradeResponseCallback(func(res *http.Response) {
+			header = res.Header.Get("X-Server")
+		})
+		if err := ws.Handshake("ws://" + addr); err != nil {
+			results <- err
+			return
+		}
+		defer ws.CloseNextLayer()
+
+		if err := ws.Write([]byte("hello"), TypeText); err != nil {
+			results <- err
+			return
+		}
+		b := make([]byte, 128)
+		mt, n, err := ws.NextMessage(b)
+		switch {
+		case err != nil:
+			results <- err
+		case mt != TypeText || string(b[:n]) != "hello":
+			results <- fmt.Errorf("expected hello got=%q", b[:n])
+		case header != "sonic" || !ws.DeflateNegotiated():
+			results <- fmt.Errorf("expected the response of the server got X-Server=%q", header)
+		default:
+			results <- nil
+		}
+	}()
+	go func() {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			results <- err
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Version: 13\r\n\r\n", addr)
+		res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err == nil && res.StatusCode != http.StatusBadRequest {
+			err = fmt.Errorf("expected 400 got=%d", res.StatusCode)
+		}
+		results <- err
+	}()
+
+	for done := 0; done < 2; {
+		select {
+		case err := <-results:
+			if err != nil {
+				t.Fatal(err)
+			}
+			done++
+		default:
+			_ = ioc.RunOneFor(time.Millisecond)
+		}
+	}
+
+	if len(accepted) != 2 {
+		t.Fatalf("expected 2 handshakes got=%v", accepted)
+	}
+	failed := 0
+	for _, err := range accepted {
+		if err != nil {
+			if !errors.Is(err, ErrCannotUpgrade) {
+				t.Fatalf("expected ErrCannotUpgrade got=%v", err)
+			}
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Fatalf("expected 1 rejected handshake got=%v", accepted)
+	}
+
+	// Only server streams accept.
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Accept(NewMockStream()); err != ErrWrongHandshakeRole {
+		t.Fatalf("expected ErrWrongHandshakeRole got=%v", err)
+	}
+}
+
+func TestClientUTF8Validation(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	newStream := func(frames ...[]byte) *WebsocketStream {
+		ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws.state = StateActive
+		ws.init(NewMockStream())
+		for _, f := range frames {
+			ws.src.Write(f)
+		}
+		return ws
+	}
+	frame := func(op Opcode, fin bool, payload string) []byte {
+		b := byte(op)
+		if fin {
+			b |= 1 << 7
+		}
+		return append([]byte{b, byte(len(payload))}, payload...)
+	}
+
+	// A code point split across the fragments of a message.
+	euro := "€"
+	ws := newStream(
+		frame(OpcodeText, false, "price: "+euro[:1]),
+		frame(OpcodeContinuation, false, euro[1:2]),
+		frame(OpcodeContinuation, true, euro[2:]+"10"),
+	)
+	if !ws.UTF8Validation() {
+		t.Fatal("expected the validation to be enabled by default")
+	}
+	b := make([]byte, 128)
+	if _, n, err := ws.NextMessage(b); err != nil || string(b[:n]) != "price: "+euro+"10" {
+		t.Fatalf("expected the message got=%q %v", b[:n], err)
+	}
+
+	// Invalid text fails and closes the stream with CloseBadPayload.
+	invalid := []struct {
+		name   string
+		frames [][]byte
+	}{
+		{"invalid byte", [][]byte{frame(OpcodeText, true, "a\xffb")}},
+		{"truncated", [][]byte{frame(OpcodeText, true, euro[:2])}},
+		{"split surrogate", [][]byte{frame(OpcodeText, false, "\xed"), frame(OpcodeContinuation, true, "\xa0\x80")}},
+	}
+	for _, c := range invalid {
+		ws = newStream(c.frames...)
+		if _, _, err := ws.NextMessage(b); err != ErrInvalidUTF8 {
+			t.Fatalf("%s: expected ErrInvalidUTF8 got=%v", c.name, err)
+		}
+		assertState(t, ws, StateClosedByUs)
+		ws.pending[0].Unmask()
+		if cc, _ := DecodeCloseFramePayload(ws.pending[0].payload); cc != CloseBadPayload {
+			t.Fatalf("%s: expected a close with %d got=%d", c.name, CloseBadPayload, cc)
+		}
+	}
+
+	// The invalid byte is reported with the frame it is in, before the message ends.
+	ws = newStream(frame(OpcodeText, false, "\xc0"), frame(OpcodeContinuation, true, "a"))
+	if _, err := ws.NextFrame(); err != ErrInvalidUTF8 {
+		t.Fatalf("expected ErrInvalidUTF8 on the first frame got=%v", err)
+	}
+
+	// Binary messages are not validated, nor is text once the validation is disabled.
+	ws = newStream(frame(OpcodeBinary, true, "\xff"))
+	if _, _, err := ws.NextMessage(b); err != nil {
+		t.Fatal(err)
+	}
+	ws = newStream(frame(OpcodeText, true, "\xff"))
+	ws.SetUTF8Validation(false)
+	if _, n, err := ws.NextMessage(b); err != nil || n != 1 {
+		t.Fatalf("expected the unvalidated message got=%d %v", n, err)
+	}
+}
+
+func TestClientCloseCodeValidation(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	closeFrame := func(payload string) []byte {
+		return append([]byte{byte(OpcodeClose) | 1<<7, byte(len(payload))}, payload...)
+	}
+	code := func(cc CloseCode) string {
+		return string(EncodeCloseCode(cc))
+	}
+
+	cases := []struct {
+		payload string
+		valid   bool
+	}{
+		{"", true},
+		{code(CloseNormal), true},
+		{code(CloseGoingAway) + "bye", true},
+		{code(CloseTryAgainLater), true},
+		{code(3000), true},
+		{code(4999) + "é", true},
+		{"\x03", false},
+		{code(0), false},
+		{code(999), false},
+		{code(CloseReserved1), false},
+		{code(CloseNoStatus), false},
+		{code(CloseAbnormal), false},
+		{code(CloseReserved2), false},
+		{code(CloseReserved3), false},
+		{code(1016), false},
+		{code(2999), false},
+		{code(5000), false},
+		{code(CloseNormal) + "\xff", false},
+	}
+
+	for _, c := range cases {
+		ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws.state = StateActive
+		ws.init(NewMockStream())
+		ws.src.Write(closeFrame(c.payload))
+
+		_, err = ws.NextFrame()
+		if c.valid {
+			if err != nil {
+				t.Fatalf("payload %q: expected a valid close frame got=%v", c.payload, err)
+			}
+			assertState(t, ws, StateClosedByPeer)
+			continue
+		}
+
+		if err != ErrInvalidClosePayload {
+			t.Fatalf("payload %q: expected ErrInvalidClosePayload got=%v", c.payload, err)
+		}
+		assertState(t, ws, StateClosedByUs)
+		ws.pending[0].Unmask()
+		if cc, _ := DecodeCloseFramePayload(ws.pending[0].payload); cc != CloseProtocolError {
+			t.Fatalf("payload %q: expected a close with %d got=%d", c.payload, CloseProtocolError, cc)
+		}
+	}
+}
+
+func TestDebugFrameRoundTrip(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	var violations []error
+	ioc.SetDebugOutput(io.Discard)
+	ioc.SetErrorHandler(func(fd int, err error) {
+		violations = append(violations, err)
+	})
+	ioc.SetDebug(true)
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.state = StateActive
+	if err := ws.init(NewMockStream()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ws.Write(make([]byte, 200), TypeBinary); err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("expected no violation got=%v", violations)
+	}
+
+	// The header holds the length of a previous, bigger payload, which would
+	// silently write bytes past the payload.
+	f := AcquireFrame()
+	f.SetFin()
+	f.SetText()
+	f.header[1] = 3
+	f.SetPayload([]byte("a"))
+	_ = ws.WriteFrame(f)
+	if len(violations) != 1 || !errors.Is(violations[0], sonicerrors.ErrInvariantViolation) {
+		t.Fatalf("expected a violation got=%v", violations)
+	}
+}
+
+func TestStreamKeepAlive(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The peer answers the first ping only.
+	pings := make(chan int, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		n := 0
+		for {
+			f := NewFrame()
+			if _, err := f.ReadFrom(conn); err != nil {
+				pings <- n
+				return
+			}
+			if !f.IsPing() {
+				continue
+			}
+			n++
+			if n == 1 {
+				pong := NewFrame()
+				pong.SetFin()
+				pong.SetPong()
+				pong.Mask()
+				_, _ = pong.WriteTo(conn)
+			}
+		}
+	}()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	conn, err := sonic.Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, err := NewWebsocketStream(ioc, nil, RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.state = StateActive
+	if err := ws.init(conn); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.CloseNextLayer()
+
+	if err := ws.SetKeepAlive(5*time.Millisecond, 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if interval, timeout := ws.KeepAlive(); interval != 5*time.Millisecond || timeout != 20*time.Millisecond {
+		t.Fatalf("unexpected keep-alive interval=%s timeout=%s", interval, timeout)
+	}
+
+	var (
+		pongs   int
+		readErr error
+	)
+	var read func()
+	read = func() {
+		ws.AsyncNextFrame(func(err error, f *Frame) {
+			if err != nil {
+				readErr = err
+				return
+			}
+			if f.IsPong() {
+				pongs++
+			}
+			read()
+		})
+	}
+	read()
+
+	for deadline := time.Now().Add(5 * time.Second); readErr == nil && time.Now().Before(deadline); {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if !errors.Is(readErr, ErrKeepAliveTimeout) {
+		t.Fatalf("expected ErrKeepAliveTimeout got=%v", readErr)
+	}
+	if pongs != 1 {
+		t.Fatalf("expected 1 pong got=%d", pongs)
+	}
+	if ws.State() != StateTerminated {
+		t.Fatalf("expected a terminated stream got=%s", ws.State())
+	}
+	if n := <-pings; n != 2 {
+		t.Fatalf("expected 2 pings got=%d", n)
+	}
+}
+
+func TestStreamNextMessageTo(t *testing.T) {
+	big := make([]byte, 256*1024)
+	for i := range big {
+		big[i] = byte(i % 251)
+	}
+
+	newStream := func(frames ...*Frame) *WebsocketStream {
+		ws, err := NewWebsocketStream(nil, nil, RoleClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws.SetSpill(SpillConfig{Threshold: 1024})
+		ws.state = StateActive
+		mock := NewMockStream()
+		ws.init(mock)
+		for _, f := range frames {
+			if _, err := f.WriteTo(mock.b); err != nil {
+				t.Fatal(err)
+			}
+		}
+		mock.b.Commit(mock.b.WriteLen())
+		return ws
+	}
+	newFrame := func(opcode Opcode, fin bool, payload []byte) *Frame {
+		f := NewFrame()
+		f.SetOpcode(opcode)
+		if fin {
+			f.SetFin()
+		}
+		f.SetPayload(append([]byte{}, payload...))
+		return f
+	}
+
+	// A fragmented message, with a frame too big to be buffered, is written as
+	// it arrives.
+	ws := newStream(
+		newFrame(OpcodeBinary, false, big[:100]),
+		newFrame(OpcodeContinuation, false, big[100:200000]),
+		newFrame(OpcodeContinuation, true, big[200000:]),
+	)
+	var out bytes.Buffer
+	n, mt, err := ws.NextMessageTo(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(big)) || mt != TypeBinary || !bytes.Equal(out.Bytes(), big) {
+		t.Fatalf("expected a binary message of %d bytes got n=%d mt=%s", len(big), n, mt)
+	}
+	if ws.src.Cap() > 4*bufferSize {
+		t.Fatalf("expected the read buffer to stay small got=%d", ws.src.Cap())
+	}
+
+	// The chunks of the message are handed over as they arrive.
+	ws = newStream(newFrame(OpcodeText, false, []byte("hello ")), newFrame(OpcodeContinuation, true, []byte("world")))
+	var chunks []string
+	done := false
+	ws.AsyncNextMessageChunks(func(chunk []byte) error {
+		chunks = append(chunks, string(chunk))
+		return nil
+	}, func(err error, n int64, mt MessageType) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 11 || mt != TypeText {
+			t.Fatalf("expected a text message of 11 bytes got n=%d mt=%s", n, mt)
+		}
+		done = true
+	})
+	if !done || len(chunks) != 2 || chunks[0] != "hello " || chunks[1] != "world" {
+		t.Fatalf("expected the chunks of hello world got=%q", chunks)
+	}
+
+	// A compressed message is decompressed to the writer once complete.
+	var tx deflateState
+	tx.enable(&DeflateConfig{}, deflateParams{})
+	compressed, err := tx.compress(big)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newFrame(OpcodeBinary, true, compressed)
+	f.SetRSV1()
+	ws = newStream(f)
+	ws.deflate.enable(&DeflateConfig{}, deflateParams{})
+	out.Reset()
+	if n, _, err = ws.NextMessageTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(big)) || !bytes.Equal(out.Bytes(), big) {
+		t.Fatalf("expected the decompressed message of %d bytes got=%d", len(big), n)
+	}
+
+	// An error of the writer fails the read.
+	ws = newStream(newFrame(OpcodeText, true, []byte("hello")))
+	failed := errors.New("failed")
+	var readErr error
+	ws.AsyncNextMessageChunks(func([]byte) error { return failed }, func(err error, _ int64, _ MessageType) {
+		readErr = err
+	})
+	if !errors.Is(readErr, failed) {
+		t.Fatalf("expected the error of the writer got=%v", readErr)
+	}
+}
+
+func TestServerAcceptBlocking(t *testing.T) {
+	const addr = "localhost:8096"
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(ioc, "tcp", addr, sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The client sends its request late, such that Accept has to wait for the nonblocking connection.
+	results := make(chan error, 1)
+	go func() {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			results <- err
+			return
+		}
+		defer conn.Close()
+
+		time.Sleep(50 * time.Millisecond)
+		fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", addr)
+		res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err == nil && res.StatusCode != http.StatusSwitchingProtocols {
+			err = fmt.Errorf("expected 101 got=%d", res.StatusCode)
+		}
+		results <- err
+	}()
+
+	var conn sonic.Conn
+	ln.AsyncAccept(func(err error, c sonic.Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn = c
+	})
+	for conn == nil {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+
+	// The connection is nonblocking, and the request may not have arrived yet.
+	ws, err := NewWebsocketStream(ioc, nil, RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Accept(conn); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.CloseNextLayer()
+
+	if ws.RemoteAddr().String() != conn.RemoteAddr().String() || ws.LocalAddr().String() != conn.LocalAddr().String() {
+		t.Fatalf("wrong addresses remote=%v local=%v", ws.RemoteAddr(), ws.LocalAddr())
+	}
+	if ws.RawFd() != conn.RawFd() {
+		t.Fatalf("expected fd=%d got=%d", conn.RawFd(), ws.RawFd())
+	}
+	if err := <-results; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerAcceptSubprotocol(t *testing.T) {
+	const addr = "localhost:8092"
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(ioc, "tcp", addr, sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var (
+		offered  []string
+		selected string
+		accepted = false
+	)
+	ln.AsyncAccept(func(err error, conn sonic.Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws, err := NewWebsocketStream(ioc, nil, RoleServer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The server only speaks chat.v1.
+		ws.SetSubprotocolSelector(func(protocols []string) string {
+			offered = protocols
+			return "chat.v1"
+		})
+		ws.AsyncAccept(conn, func(err error) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			accepted, selected = true, ws.Subprotocol()
+			_ = ws.CloseNextLayer()
+		})
+	})
+
+	result := make(chan error, 1)
+	go func() {
+		cioc := sonic.MustIO()
+		defer cioc.Close()
+
+		ws, err := NewWebsocketStream(cioc, nil, RoleClient)
+		if err != nil {
+			result <- err
+			return
+		}
+		if err := ws.Handshake("ws://"+addr, Subprotocols("chat.v2", "chat.v1")); err != nil {
+			result <- err
+			return
+		}
+		defer ws.CloseNextLayer()
+
+		if p := ws.Subprotocol(); p != "chat.v1" {
+			result <- fmt.Errorf("expected the subprotocol chat.v1 got=%q", p)
+			return
+		}
+		result <- nil
+	}()
+
+	for done := false; !done || !accepted; {
+		select {
+		case err := <-result:
+			if err != nil {
+				t.Fatal(err)
+			}
+			done = true
+		default:
+			_ = ioc.RunOneFor(time.Millisecond)
+		}
+	}
+	if len(offered) != 2 || offered[0] != "chat.v2" || offered[1] != "chat.v1" || selected != "chat.v1" {
+		t.Fatalf("unexpected negotiation offered=%v selected=%q", offered, selected)
+	}
+}
+
+func TestClientRejectsSubprotocolNotOffered(t *testing.T) {
+	ws, err := NewWebsocketStream(nil, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &http.Request{Header: http.Header{"Sec-Websocket-Protocol": {"chat.v2, chat.v1"}}}
+	res := &http.Response{Header: http.Header{"Sec-Websocket-Protocol": {"chat.v3"}}}
+	if err := ws.acceptSubprotocol(req, res); !errors.Is(err, ErrCannotUpgrade) {
+		t.Fatalf("expected ErrCannotUpgrade got=%v", err)
+	}
+	if ws.Subprotocol() != "" {
+		t.Fatalf("expected no subprotocol got=%q", ws.Subprotocol())
+	}
+
+	res.Header.Set("Sec-WebSocket-Protocol", "chat.v1")
+	if err := ws.acceptSubprotocol(req, res); err != nil || ws.Subprotocol() != "chat.v1" {
+		t.Fatalf("expected the subprotocol chat.v1 got=%q %v", ws.Subprotocol(), err)
+	}
+}
+
+// connectProxy is an HTTP proxy opening tunnels with CONNECT, which answers
+// with status the first CONNECT request it receives.
+func connectProxy(t *testing.T, status int) (proxy *url.URL, requests chan *http.Request) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	requests = make(chan *http.Request, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		requests <- req
+		if status != http.StatusOK {
+			fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nContent-Length: 0\r\n\r\n", status, http.StatusText(status))
+			return
+		}
+
+		upstream, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			fmt.Fprintf(conn, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n")
+			return
+		}
+		defer upstream.Close()
+
+		fmt.Fprintf(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() { _, _ = io.Copy(upstream, conn) }()
+		_, _ = io.Copy(conn, upstream)
+	}()
+
+	return &url.URL{Scheme: "http", Host: ln.Addr().String(), User: url.UserPassword("user", "secret")}, requests
+}
+
+func TestClientHandshakeThroughProxy(t *testing.T) {
+	srv := &MockServer{}
+	go func() {
+		defer srv.Close()
+		if err := srv.Accept("localhost:8093"); err != nil {
+			panic(err)
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	proxy, requests := connectProxy(t, http.StatusOK)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.SetProxy(ProxyURL(proxy))
+
+	if err := ws.Handshake("ws://localhost:8093"); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.CloseNextLayer()
+	assertState(t, ws, StateActive)
+
+	req := <-requests
+	if req.Method != http.MethodConnect || req.Host != "localhost:8093" {
+		t.Fatalf("unexpected proxy request method=%s host=%s", req.Method, req.Host)
+	}
+	if user, password, ok := (&http.Request{Header: http.Header{
+		"Authorization": req.Header["Proxy-Authorization"],
+	}}).BasicAuth(); !ok || user != "user" || password != "secret" {
+		t.Fatalf("unexpected proxy credentials %q", req.Header.Get("Proxy-Authorization"))
+	}
+	if srv.Upgrade == nil || srv.Upgrade.Host != "localhost:8093" {
+		t.Fatal("expected the upgrade to go through the tunnel")
+	}
+}
+
+func TestClientHandshakeProxyRefused(t *testing.T) {
+	proxy, _ := connectProxy(t, http.StatusForbidden)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.SetProxy(ProxyURL(proxy))
+
+	if err := ws.Handshake("ws://localhost:8094"); !errors.Is(err, ErrProxyConnect) {
+		t.Fatalf("expected ErrProxyConnect got=%v", err)
+	}
+	assertState(t, ws, StateTerminated)
+}
+
+func TestClientTracesFrames(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var written []byte
+	ws.SetTracer(&sonic.TraceConfig{
+		OnTrace: func(dir sonic.TraceDirection, b []byte, n int) {
+			if dir == sonic.TraceWrite {
+				written = append(written, b...)
+			}
+		},
+	})
+
+	ws.state = StateActive
+	ws.init(NewMockStream())
+
+	if err := ws.Write([]byte("hello"), TypeText); err != nil {
+		t.Fatal(err)
+	}
+
+	// A masked text frame: 2 bytes of header, 4 of mask and the payload.
+	if len(written) != 11 || written[0] != 0x81 || written[1] != 0x80|5 {
+		t.Fatalf("expected the frame to be traced got=%x", written)
+	}
+}
+
+func TestClientReadWriteAfterClose(t *testing.T) {
+	type result struct {
+		read, write, close error
+	}
+	for _, tc := range []struct {
+		state StreamState
+		want  result
+	}{
+		{
+			state: StateClosedByUs,
+			want:  result{nil, sonicerrors.ErrCancelled, sonicerrors.ErrCancelled},
+		},
+		{
+			state: StateCloseAcked,
+			want:  result{io.EOF, sonicerrors.ErrCancelled, io.EOF},
+		},
+		{
+			state: StateClosedByPeer,
+			want:  result{io.EOF, sonicerrors.ErrCancelled, io.EOF},
+		},
+		{
+			state: StateTerminated,
+			want:  result{io.EOF, sonicerrors.ErrCancelled, io.EOF},
+		},
+	} {
+		t.Run(tc.state.String(), func(t *testing.T) {
+			ioc := sonic.MustIO()
+			defer ioc.Close()
+
+			setup := func() *WebsocketStream {
+				ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ws.state = StateActive
+				ws.init(NewMockStream())
+				ws.state = tc.state
+
+				// A message from the peer, which is only read while the
+				// state allows it.
+				ws.src.Write([]byte{byte(OpcodeText) | 1<<7, 2, 'h', 'i'})
+				return ws
+			}
+
+			var sync result
+			ws := setup()
+			_, sync.read = ws.NextFrame()
+			sync.write = ws.Write([]byte("hi"), TypeText)
+			sync.close = ws.Close(CloseNormal, "bye")
+			if sync != tc.want {
+				t.Fatalf("wrong sync errors: given=%v expected=%v", sync, tc.want)
+			}
+			assertState(t, ws, tc.state)
+
+			var async result
+			ws = setup()
+			ws.AsyncNextFrame(func(err error, _ *Frame) { async.read = err })
+			ws.AsyncWrite([]byte("hi"), TypeText, func(err error) { async.write = err })
+			ws.AsyncClose(CloseNormal, "bye", func(err error) { async.close = err })
+			if async != tc.want {
+				t.Fatalf("wrong async errors: given=%v expected=%v", async, tc.want)
+			}
+			assertState(t, ws, tc.state)
+
+			if ws.state.CanRead() != (tc.state == StateClosedByUs) || ws.state.CanWrite() {
+				t.Fatal("wrong read and write capabilities")
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package websocket
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// SubprotocolSelector picks the subprotocol of a server stream among the
+// subprotocols offered by the client, in the order in which the client
+// prefers them. It returns the empty string to accept the upgrade without a
+// subprotocol.
+type SubprotocolSelector func(offered []string) string
+
+// Subprotocols returns the header offering the given subprotocols, in order
+// of preference, to pass to Handshake, AsyncHandshake or
+// AsyncHandshakeContext. The handshake fails with ErrCannotUpgrade if the
+// server selects a subprotocol which was not offered. Once the handshake
+// succeeds, Subprotocol returns the subprotocol selected by the server, if
+// any.
+func Subprotocols(protocols ...string) Header {
+	return ExtraHeader(true, "Sec-WebSocket-Protocol", strings.Join(protocols, ", "))
+}
+
+// SetSubprotocolSelector sets the function picking the subprotocol of a
+// server stream during Accept and AsyncAccept. Without one, the upgrade is
+// accepted without a subprotocol. Use UpgradeRouter to select the subprotocol
+// per route instead.
+func (s *WebsocketStream) SetSubprotocolSelector(selector SubprotocolSelector) {
+	s.subprotocolSelector = selector
+}
+
+// Subprotocol returns the subprotocol negotiated by the last handshake of the
+// stream, or the empty string if none was.
+func (s *WebsocketStream) Subprotocol() string {
+	return s.subprotocol
+}
+
+// headerTokens returns the comma separated values of the header.
+func headerTokens(header http.Header, key string) (tokens []string) {
+	for _, v := range header.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tokens = append(tokens, t)
+			}
+		}
+	}
+	return tokens
+}
+
+// acceptSubprotocol checks the subprotocol selected by the server in res among
+// those offered in req, and sets it as the subprotocol of the stream.
+func (s *WebsocketStream) acceptSubprotocol(req *http.Request, res *http.Response) error {
+	selected := res.Header.Get("Sec-WebSocket-Protocol")
+	if selected == "" {
+		return nil
+	}
+	for _, p := range headerTokens(req.Header, "Sec-WebSocket-Protocol") {
+		if p == selected {
+			s.subprotocol = selected
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: subprotocol %s was not offered", ErrCannotUpgrade, selected)
+}
+
+// selectSubprotocolFor picks the subprotocol of a server stream upgraded by
+// req with the selector of the stream, and sets it in the response header.
+func (s *WebsocketStream) selectSubprotocolFor(req *http.Request, header http.Header) {
+	if s.subprotocolSelector == nil {
+		return
+	}
+	offered := headerTokens(req.Header, "Sec-WebSocket-Protocol")
+	if len(offered) == 0 {
+		return
+	}
+	if p := s.subprotocolSelector(offered); p != "" {
+		s.subprotocol = p
+		header.Set("Sec-WebSocket-Protocol", p)
+	}
+}
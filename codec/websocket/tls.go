@@ -0,0 +1,71 @@
+package websocket
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"time"
+)
+
+// tlsReader reads the records of the tls.Conn of a wss:// stream for its
+// sonic.AsyncAdapter.
+//
+// A tls.Conn reads records ahead of its caller, and the file descriptor is not
+// readable anymore once they are buffered, so the adapter would wait for bytes
+// it already read. tlsReader is a sonic.BufferedReader which tells whether a
+// record is buffered by decrypting it ahead, without blocking. The adapter then
+// reads the buffered records right away, a bounded number of them per run of
+// the IO, see sonic.AsyncAdapter.SetMaxBufferedReadsPerRun.
+type tlsReader struct {
+	*tls.Conn
+
+	b     []byte
+	ahead []byte // decrypted and not yet returned
+	err   error  // the error of the read ahead, once ahead is consumed
+}
+
+func newTLSReader(conn *tls.Conn) *tlsReader {
+	return &tlsReader{Conn: conn, b: make([]byte, 4096)}
+}
+
+func (r *tlsReader) Read(b []byte) (int, error) {
+	if len(r.ahead) > 0 {
+		n := copy(b, r.ahead)
+		r.ahead = r.ahead[n:]
+		return n, nil
+	}
+	if r.err != nil {
+		err := r.err
+		r.err = nil
+		return 0, err
+	}
+	return r.Conn.Read(b)
+}
+
+// Buffered returns the number of bytes which can be read without waiting for
+// the file descriptor to become readable.
+func (r *tlsReader) Buffered() int {
+	if len(r.ahead) == 0 && r.err == nil {
+		r.readAhead()
+	}
+	if len(r.ahead) == 0 && r.err != nil {
+		// The error, such as the close_notify of the peer, is returned by the
+		// next read, which must not wait for the file descriptor either.
+		return 1
+	}
+	return len(r.ahead)
+}
+
+func (r *tlsReader) readAhead() {
+	// An expired deadline fails the read right away, before the connection is
+	// read, unless a whole record is buffered. Timeouts leave the tls.Conn
+	// usable.
+	_ = r.Conn.SetReadDeadline(time.Unix(1, 0))
+	n, err := r.Conn.Read(r.b)
+	_ = r.Conn.SetReadDeadline(time.Time{})
+
+	r.ahead = r.b[:n]
+	if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+		r.err = err
+	}
+}
@@ -0,0 +1,132 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic"
+)
+
+func trustedTLSConfigs(t *testing.T) (server, client *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client = &tls.Config{RootCAs: roots, ServerName: "localhost"}
+	return server, client
+}
+
+func TestClientReadBufferedTLSRecords(t *testing.T) {
+	serverCfg, clientCfg := trustedTLSConfigs(t)
+
+	ln, err := tls.Listen("tcp", "localhost:0", serverCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		key := MakeResponseKey([]byte(req.Header.Get("Sec-WebSocket-Key")))
+		if _, err := fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+			"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", key); err != nil {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+
+		// Each frame is a record of its own. They arrive at once and then the
+		// peer is silent, so the client reads them without read events.
+		for i := 0; i < 10; i++ {
+			if _, err := conn.Write([]byte{0x81, 1, '0' + byte(i)}); err != nil {
+				return
+			}
+		}
+		time.Sleep(2 * time.Second)
+	}()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, clientCfg, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Handshake("wss://" + ln.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.CloseNextLayer()
+	ws.stream.(*sonic.AsyncAdapter).SetMaxBufferedReadsPerRun(2)
+
+	// Let all records arrive, such that they are read at once from the
+	// connection.
+	time.Sleep(100 * time.Millisecond)
+
+	var (
+		read        []byte
+		readAtOther = -1
+		b           = make([]byte, 128)
+		onMessage   AsyncMessageHandler
+	)
+	onMessage = func(err error, n int, _ MessageType) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		read = append(read, b[:n]...)
+		if len(read) == 1 {
+			// Another handler which must not wait for all buffered records to
+			// be read.
+			_ = ioc.Post(func() { readAtOther = len(read) })
+		}
+		if len(read) < 10 {
+			ws.AsyncNextMessage(b, onMessage)
+		}
+	}
+	ws.AsyncNextMessage(b, onMessage)
+
+	for deadline := time.Now().Add(500 * time.Millisecond); len(read) < 10 && time.Now().Before(deadline); {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if string(read) != "0123456789" {
+		t.Fatalf("expected to read 0123456789 got=%s", read)
+	}
+	if readAtOther < 0 || readAtOther >= 10 {
+		t.Fatalf("posted handler ran after %d reads", readAtOther)
+	}
+}
@@ -0,0 +1,147 @@
+package websocket
+
+import (
+	"unicode/utf8"
+)
+
+// utf8Validator validates the payload of a text message as it is read, frame
+// by frame. A code point may be split across frames, so the bytes of an
+// incomplete code point at the end of a frame are held until the next one.
+//
+// Invalid bytes are reported as soon as they are seen: an incomplete code
+// point is only held if it can still be completed into a valid one.
+type utf8Validator struct {
+	active   bool // the message being read is text and is validated
+	inflated bool // the message is compressed, so its payload is validated once decompressed
+
+	partial [utf8.UTFMax]byte
+	n       int
+}
+
+func (v *utf8Validator) reset(active, inflated bool) {
+	v.active = active
+	v.inflated = inflated
+	v.n = 0
+}
+
+// write validates the next bytes of the message.
+func (v *utf8Validator) write(b []byte) bool {
+	if v.n > 0 {
+		// Complete the code point held from the previous bytes.
+		for len(b) > 0 && !utf8.FullRune(v.partial[:v.n]) {
+			v.partial[v.n] = b[0]
+			v.n++
+			b = b[1:]
+		}
+		if !utf8.FullRune(v.partial[:v.n]) {
+			return validPrefix(v.partial[:v.n])
+		}
+		if !utf8.Valid(v.partial[:v.n]) {
+			return false
+		}
+		v.n = 0
+	}
+
+	// Hold the incomplete code point at the end of b, if any.
+	tail := len(b)
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax+1; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				tail = i
+			}
+			break
+		}
+	}
+	if !utf8.Valid(b[:tail]) {
+		return false
+	}
+	v.n = copy(v.partial[:], b[tail:])
+	return v.n == 0 || validPrefix(v.partial[:v.n])
+}
+
+// finish returns true if the message does not end with an incomplete code
+// point.
+func (v *utf8Validator) finish() bool {
+	ok := v.n == 0
+	v.n = 0
+	return ok
+}
+
+// validPrefix returns true if the incomplete code point p can be completed into
+// a valid one.
+func validPrefix(p []byte) bool {
+	var b [utf8.UTFMax]byte
+	n := copy(b[:], p)
+
+	// The second byte of a code point is the most constrained one, see the
+	// table of RFC 3629 section 4.
+	for _, second := range []byte{0x80, 0x90, 0xa0} {
+		m := n
+		if m == 1 {
+			b[m] = second
+			m++
+		}
+		for ; m < utf8.UTFMax; m++ {
+			b[m] = 0x80
+		}
+		if r, size := utf8.DecodeRune(b[:]); r != utf8.RuneError && size > n {
+			return true
+		}
+		if n > 1 {
+			break
+		}
+	}
+	return false
+}
+
+// SetUTF8Validation enables or disables the validation of the payload of the
+// text messages, which must be valid UTF-8 as per RFC 6455. The validation is
+// enabled by default. Disabling it saves a pass over the payload of every text
+// message, for latency sensitive applications which trust their peer.
+//
+// A text message which is not valid UTF-8 fails the read with ErrInvalidUTF8,
+// and the stream is closed with CloseBadPayload. The reason of a close frame
+// which is not valid UTF-8 fails the read with ErrInvalidClosePayload. Messages read with NextFrame and AsyncNextFrame are
+// validated frame by frame, except compressed messages, whose payload is only
+// validated once it is decompressed, see SetDeflate.
+func (s *WebsocketStream) SetUTF8Validation(enabled bool) {
+	s.noUTF8Check = !enabled
+}
+
+// UTF8Validation returns true if the payload of the text messages is
+// validated, see SetUTF8Validation.
+func (s *WebsocketStream) UTF8Validation() bool {
+	return !s.noUTF8Check
+}
+
+// validateText validates the payload of the data frame f, unless it is
+// streamed, in which case it is handed to validateTextPayload as it arrives.
+func (s *WebsocketStream) validateText(f *Frame, streamed bool) error {
+	v := &s.utf8
+	if !f.IsContinuation() {
+		v.reset(
+			!s.noUTF8Check && f.Opcode() == OpcodeText,
+			s.deflate.enabled && f.IsRSV1(),
+		)
+	}
+	if streamed {
+		return nil
+	}
+	return s.validateTextPayload(f.payload, f.IsFin(), false)
+}
+
+// validateTextPayload validates the next bytes of the payload of the message
+// being read, which ends with them if fin is true. inflated tells whether the
+// bytes are decompressed: the payload of a compressed message is only
+// validated once decompressed.
+func (s *WebsocketStream) validateTextPayload(b []byte, fin, inflated bool) error {
+	v := &s.utf8
+	if !v.active || v.inflated != inflated {
+		return nil
+	}
+	if !v.write(b) || (fin && !v.finish()) {
+		v.active = false
+		return ErrInvalidUTF8
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package websocket
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestUTF8ValidatorSplits(t *testing.T) {
+	texts := []string{
+		"",
+		"plain ascii",
+		"été € \U0001F600 �",
+		"\xff",
+		"a\xc0\x80",
+		"\xed\xa0\x80",
+		"\xf4\x90\x80\x80",
+		"\xe2\x82",
+		"\xf0\x9f\x98",
+	}
+
+	// Every split of each text in two writes gives the same verdict as utf8.Valid.
+	for _, text := range texts {
+		b := []byte(text)
+		for i := 0; i <= len(b); i++ {
+			var v utf8Validator
+			v.reset(true, false)
+			ok := v.write(b[:i]) && v.write(b[i:]) && v.finish()
+			if ok != utf8.Valid(b) {
+				t.Fatalf("%q split at %d: expected valid=%v", text, i, !ok)
+			}
+		}
+	}
+}
+
+func TestUTF8ValidPrefix(t *testing.T) {
+	cases := []struct {
+		p     string
+		valid bool
+	}{
+		{"\xc2", true},
+		{"\xe0", true},
+		{"\xe0\xa0", true},
+		{"\xe0\x80", false},
+		{"\xed\x9f", true},
+		{"\xed\xa0", false},
+		{"\xf0\x90\x80", true},
+		{"\xf4\x8f", true},
+		{"\xf4\x90", false},
+		{"\xf5", false},
+		{"\xc0", false},
+	}
+	for _, c := range cases {
+		if validPrefix([]byte(c.p)) != c.valid {
+			t.Fatalf("expected validPrefix(%q)=%v", c.p, c.valid)
+		}
+	}
+}
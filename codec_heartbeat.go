@@ -0,0 +1,167 @@
+package sonic
+
+import (
+	"fmt"
+	"time"
+)
+
+// HeartbeatConfig configures a HeartbeatCodecConn.
+type HeartbeatConfig[Enc, Dec any] struct {
+	// Interval is the write inactivity after which Heartbeat is written. 0 disables outgoing heartbeats.
+	Interval time.Duration
+
+	// Heartbeat returns the heartbeat message to write.
+	Heartbeat func() Enc
+
+	// Timeout is the read inactivity after which OnTimeout is invoked. 0 disables the timeout.
+	Timeout time.Duration
+
+	// OnTimeout is invoked once the peer has been silent for Timeout. It is invoked again only after the peer sent
+	// something.
+	OnTimeout func()
+
+	// IsHeartbeat returns true if a message read from the peer is a heartbeat. Heartbeats count as activity but are
+	// not delivered by AsyncReadNext and ReadNext. If nil, all messages are delivered.
+	IsHeartbeat func(Dec) bool
+}
+
+// HeartbeatCodecConn adds the heartbeats of a session protocol, such as FIX, to a codec stream: it writes a heartbeat
+// whenever nothing was written for an interval and reports a peer which did not send anything within a timeout.
+//
+// Activity is only observed through the HeartbeatCodecConn, so a read must be kept outstanding with AsyncReadNext for
+// the peer's messages to reset the timeout. Heartbeats are not written while a write is in progress, as that write
+// is activity already.
+//
+// A HeartbeatCodecConn must only be used from the goroutine running the IO.
+type HeartbeatCodecConn[Enc, Dec any] struct {
+	conn CodecConn[Enc, Dec]
+	cfg  HeartbeatConfig[Enc, Dec]
+
+	timer     *Timer
+	lastRead  time.Time
+	lastWrite time.Time
+	writing   int
+	timedOut  bool
+
+	readCb AsyncItemCallback[Dec]
+	onNext AsyncItemCallback[Dec]
+}
+
+var _ CodecConn[any, any] = &HeartbeatCodecConn[any, any]{}
+
+// NewHeartbeatCodecConn wraps conn, checking for write and read inactivity on the IO's timers.
+func NewHeartbeatCodecConn[Enc, Dec any](
+	ioc *IO,
+	conn CodecConn[Enc, Dec],
+	cfg HeartbeatConfig[Enc, Dec],
+) (*HeartbeatCodecConn[Enc, Dec], error) {
+	if cfg.Interval > 0 && cfg.Heartbeat == nil {
+		return nil, fmt.Errorf("a heartbeat interval requires a heartbeat message")
+	}
+	if cfg.Timeout > 0 && cfg.OnTimeout == nil {
+		return nil, fmt.Errorf("a heartbeat timeout requires a timeout callback")
+	}
+
+	c := &HeartbeatCodecConn[Enc, Dec]{
+		conn: conn,
+		cfg:  cfg,
+	}
+	c.onNext = c.readNext
+	now := time.Now()
+	c.lastRead, c.lastWrite = now, now
+
+	// Check often enough for heartbeats and timeouts to be late by a fraction of their period at most.
+	tick := cfg.Interval
+	if tick == 0 || (cfg.Timeout > 0 && cfg.Timeout < tick) {
+		tick = cfg.Timeout
+	}
+	if tick == 0 {
+		return c, nil
+	}
+	tick /= 4
+	if tick < time.Millisecond {
+		tick = time.Millisecond
+	}
+
+	timer, err := NewTimer(ioc)
+	if err != nil {
+		return nil, err
+	}
+	if err := timer.ScheduleRepeating(tick, c.check); err != nil {
+		_ = timer.Close()
+		return nil, err
+	}
+	c.timer = timer
+
+	return c, nil
+}
+
+func (c *HeartbeatCodecConn[Enc, Dec]) check() {
+	now := time.Now()
+
+	if c.cfg.Interval > 0 && c.writing == 0 && now.Sub(c.lastWrite) >= c.cfg.Interval {
+		// A failed heartbeat is reported by the next read or write.
+		c.AsyncWriteNext(c.cfg.Heartbeat(), func(error, int) {})
+	}
+
+	if c.cfg.Timeout > 0 && !c.timedOut && now.Sub(c.lastRead) >= c.cfg.Timeout {
+		c.timedOut = true
+		c.cfg.OnTimeout()
+	}
+}
+
+func (c *HeartbeatCodecConn[Enc, Dec]) onRead(m Dec) (heartbeat bool) {
+	c.lastRead = time.Now()
+	c.timedOut = false
+	return c.cfg.IsHeartbeat != nil && c.cfg.IsHeartbeat(m)
+}
+
+func (c *HeartbeatCodecConn[Enc, Dec]) AsyncReadNext(cb AsyncItemCallback[Dec]) {
+	c.readCb = cb
+	c.conn.AsyncReadNext(c.onNext)
+}
+
+func (c *HeartbeatCodecConn[Enc, Dec]) readNext(err error, m Dec) {
+	if err == nil && c.onRead(m) {
+		c.conn.AsyncReadNext(c.onNext)
+		return
+	}
+	c.readCb(err, m)
+}
+
+func (c *HeartbeatCodecConn[Enc, Dec]) ReadNext() (Dec, error) {
+	for {
+		m, err := c.conn.ReadNext()
+		if err != nil || !c.onRead(m) {
+			return m, err
+		}
+	}
+}
+
+func (c *HeartbeatCodecConn[Enc, Dec]) AsyncWriteNext(m Enc, cb AsyncCallback) {
+	c.lastWrite = time.Now()
+	c.writing++
+	c.conn.AsyncWriteNext(m, func(err error, n int) {
+		c.writing--
+		c.lastWrite = time.Now()
+		cb(err, n)
+	})
+}
+
+func (c *HeartbeatCodecConn[Enc, Dec]) WriteNext(m Enc) (int, error) {
+	c.lastWrite = time.Now()
+	return c.conn.WriteNext(m)
+}
+
+func (c *HeartbeatCodecConn[Enc, Dec]) NextLayer() Stream {
+	return c.conn.NextLayer()
+}
+
+// Close stops the heartbeats and closes the wrapped codec stream.
+func (c *HeartbeatCodecConn[Enc, Dec]) Close() error {
+	if c.timer != nil {
+		_ = c.timer.Close()
+		c.timer = nil
+	}
+	return c.conn.Close()
+}
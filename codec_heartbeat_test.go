@@ -0,0 +1,107 @@
+package sonic
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatCodecConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	peerGotHeartbeat := make(chan bool, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		b := make([]byte, 5)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return
+		}
+		peerGotHeartbeat <- string(b) == "HBEAT"
+
+		// Reply with a heartbeat and a message, then go silent.
+		_, _ = conn.Write([]byte("HBEAThello"))
+		time.Sleep(time.Second)
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, dst := NewByteBuffer(), NewByteBuffer()
+	cc, err := NewNonblockingCodecConn[TestItem, TestItem](conn, &TestCodec{}, src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var heartbeat TestItem
+	copy(heartbeat.V[:], "HBEAT")
+
+	timedOut := false
+	hc, err := NewHeartbeatCodecConn[TestItem, TestItem](ioc, cc, HeartbeatConfig[TestItem, TestItem]{
+		Interval:    10 * time.Millisecond,
+		Heartbeat:   func() TestItem { return heartbeat },
+		Timeout:     50 * time.Millisecond,
+		OnTimeout:   func() { timedOut = true },
+		IsHeartbeat: func(item TestItem) bool { return item == heartbeat },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hc.Close()
+
+	var got []string
+	var onRead func(error, TestItem)
+	onRead = func(err error, item TestItem) {
+		if err != nil {
+			return
+		}
+		got = append(got, string(item.V[:]))
+		hc.AsyncReadNext(onRead)
+	}
+	hc.AsyncReadNext(onRead)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && !timedOut {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+
+	select {
+	case ok := <-peerGotHeartbeat:
+		if !ok {
+			t.Fatal("expected the peer to get a heartbeat")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the peer got nothing")
+	}
+	if len(got) != 1 || got[0] != "hello" {
+		t.Fatalf("expected only hello to be delivered got=%v", got)
+	}
+	if !timedOut {
+		t.Fatal("expected the silent peer to time out")
+	}
+}
+
+func TestHeartbeatCodecConnInvalidConfig(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	if _, err := NewHeartbeatCodecConn[TestItem, TestItem](ioc, nil, HeartbeatConfig[TestItem, TestItem]{
+		Interval: time.Second,
+	}); err == nil {
+		t.Fatal("expected an error without a heartbeat message")
+	}
+}
@@ -1,6 +1,8 @@
 package sonic
 
 import (
+	"encoding/binary"
+	"errors"
 	"net"
 	"testing"
 	"time"
@@ -113,6 +115,92 @@ func setupCodecTestWriter() chan struct{} {
 	return mark
 }
 
+// lengthCodec decodes messages prefixed with their length on 4 bytes.
+type lengthCodec struct{}
+
+func (lengthCodec) Encode(item []byte, dst *ByteBuffer) error {
+	return nil
+}
+
+func (lengthCodec) Decode(src *ByteBuffer) ([]byte, error) {
+	if err := src.PrepareRead(4); err != nil {
+		return nil, err
+	}
+	n := 4 + int(binary.BigEndian.Uint32(src.Data()))
+	if err := src.PrepareRead(n); err != nil {
+		src.Reserve(n)
+		return nil, err
+	}
+	b := append([]byte(nil), src.Data()[4:n]...)
+	src.Consume(n)
+	return b, nil
+}
+
+func TestCodecConnBufferMaxSize(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// A small message, then one announcing a size far bigger than the buffer.
+		_, _ = conn.Write([]byte{0, 0, 0, 2, 'h', 'i', 0, 0x10, 0, 0})
+		_, _ = conn.Write(make([]byte, 64*1024))
+		time.Sleep(time.Second)
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	src := NewByteBuffer()
+	src.SetMaxSize(4096)
+	codecConn, err := NewNonblockingCodecConn[[]byte, []byte](conn, lengthCodec{}, src, NewByteBuffer())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		messages []string
+		result   error
+	)
+	var onRead AsyncItemCallback[[]byte]
+	onRead = func(err error, b []byte) {
+		if err != nil {
+			result = err
+			return
+		}
+		messages = append(messages, string(b))
+		codecConn.AsyncReadNext(onRead)
+	}
+	codecConn.AsyncReadNext(onRead)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for result == nil && time.Now().Before(deadline) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if len(messages) != 1 || messages[0] != "hi" {
+		t.Fatalf("expected the first message to be read got=%q", messages)
+	}
+	if !errors.Is(result, sonicerrors.ErrBufferMaxSize) {
+		t.Fatalf("expected ErrBufferMaxSize got=%v", result)
+	}
+	if src.Cap() > 4096 {
+		t.Fatalf("expected the buffer not to grow past its max size got=%d", src.Cap())
+	}
+}
+
 func TestNonblockingCodecConnAsyncReadNext(t *testing.T) {
 	mark := setupCodecTestWriter()
 	defer func() { <-mark /* wait for the listener to close*/ }()
@@ -391,3 +479,185 @@ func TestNonblockingCodecConnWriteNext(t *testing.T) {
 		}
 	}
 }
+
+func TestCodecConnAsyncReadNextAllocs(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	const runs = 100
+	src, dst := NewByteBuffer(), NewByteBuffer()
+	codec := &TestCodec{}
+	for i := 0; i < runs+1; i++ {
+		if err := codec.Encode(TestItem{V: [5]byte{byte(i)}}, src); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// All items are buffered, so the stream is never read from.
+	conn, err := NewBlockingCodecConn[TestItem, TestItem](nil, codec, src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetMaxMessagesPerRun(ioc, 1000)
+
+	read := 0
+	onRead := func(err error, _ TestItem) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		read++
+	}
+	if allocs := testing.AllocsPerRun(runs, func() { conn.AsyncReadNext(onRead) }); allocs != 0 {
+		t.Fatalf("expected AsyncReadNext not to allocate got=%v", allocs)
+	}
+	if read != runs+1 {
+		t.Fatalf("expected %d items got=%d", runs+1, read)
+	}
+}
+
+func TestCodecConnMaxMessagesPerRun(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	src, dst := NewByteBuffer(), NewByteBuffer()
+	codec := &TestCodec{}
+	for i := 0; i < 5; i++ {
+		if err := codec.Encode(TestItem{V: [5]byte{byte(i)}}, src); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// All items are buffered, so the stream is never read from.
+	conn, err := NewBlockingCodecConn[TestItem, TestItem](nil, codec, src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetMaxMessagesPerRun(ioc, 2)
+
+	var got []byte
+	var onRead func(error, TestItem)
+	onRead = func(err error, item TestItem) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, item.V[0])
+		if len(got) < 5 {
+			conn.AsyncReadNext(onRead)
+		}
+	}
+	conn.AsyncReadNext(onRead)
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 items before yielding got=%d", len(got))
+	}
+
+	for i := 0; i < 10 && len(got) < 5; i++ {
+		_, _ = ioc.PollOne()
+	}
+
+	if len(got) != 5 {
+		t.Fatalf("expected 5 items got=%d", len(got))
+	}
+	for i, v := range got {
+		if v != byte(i) {
+			t.Fatalf("expected item %d got=%d", i, v)
+		}
+	}
+}
+
+func TestCodecConnReadTimeouts(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	// connect returns a codec stream reading what the peer is given to write. The peer sleeps for the given delay
+	// before writing each chunk.
+	type chunk struct {
+		delay time.Duration
+		b     []byte
+	}
+	connect := func(chunks ...chunk) *NonblockingCodecConn[TestItem, TestItem] {
+		go func() {
+			peer, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer peer.Close()
+			for _, c := range chunks {
+				time.Sleep(c.delay)
+				if _, err := peer.Write(c.b); err != nil {
+					return
+				}
+			}
+			time.Sleep(time.Second)
+		}()
+
+		conn, err := Dial(ioc, "tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		codecConn, err := NewNonblockingCodecConn[TestItem, TestItem](
+			conn, &TestCodec{}, NewByteBuffer(), NewByteBuffer())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := codecConn.SetReadTimeouts(ioc, 100*time.Millisecond, 200*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		return codecConn
+	}
+	readNext := func(conn *NonblockingCodecConn[TestItem, TestItem]) (error, time.Duration) {
+		start := time.Now()
+		done := false
+		var readErr error
+		conn.AsyncReadNext(func(err error, _ TestItem) {
+			done = true
+			readErr = err
+		})
+		for !done && time.Since(start) < 2*time.Second {
+			_ = ioc.RunOneFor(time.Millisecond)
+		}
+		if !done {
+			t.Fatal("the read did not complete")
+		}
+		return readErr, time.Since(start)
+	}
+
+	// A silent peer hits the idle timeout.
+	conn := connect()
+	err, took := readNext(conn)
+	if !errors.Is(err, sonicerrors.ErrIdleTimeout) || !errors.Is(err, sonicerrors.ErrTimeout) {
+		t.Fatalf("expected ErrIdleTimeout got=%v", err)
+	}
+	if took < 100*time.Millisecond {
+		t.Fatalf("the idle timeout expired early after %s", took)
+	}
+	conn.Close()
+
+	// A peer which trickles in a message, a byte at a time and more often than the idle timeout, hits the stall
+	// timeout. Quiet periods between messages do not count towards the stall timeout.
+	conn = connect(
+		chunk{delay: 50 * time.Millisecond, b: []byte{1, 2, 3, 4, 5}},
+		chunk{delay: 80 * time.Millisecond, b: []byte{1}},
+		chunk{delay: 80 * time.Millisecond, b: []byte{2}},
+		chunk{delay: 80 * time.Millisecond, b: []byte{3}},
+		chunk{delay: 80 * time.Millisecond, b: []byte{4}},
+		chunk{delay: 80 * time.Millisecond, b: []byte{5}},
+	)
+	if err, _ := readNext(conn); err != nil {
+		t.Fatal(err)
+	}
+	err, took = readNext(conn)
+	if !errors.Is(err, sonicerrors.ErrStallTimeout) || !errors.Is(err, sonicerrors.ErrTimeout) {
+		t.Fatalf("expected ErrStallTimeout got=%v", err)
+	}
+	if took < 200*time.Millisecond {
+		t.Fatalf("the stall timeout expired early after %s", took)
+	}
+	conn.Close()
+}
@@ -0,0 +1,116 @@
+package sonic
+
+import (
+	"time"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+type readPhase uint8
+
+const (
+	readNone  readPhase = iota // no read outstanding, or no timeout applies
+	readIdle                   // waiting for the first byte of the next message
+	readStall                  // waiting for the rest of a started message
+)
+
+// readTimeouts bounds the time AsyncReadNext of a codec stream waits for a message.
+//
+// A read is idle while no byte of the next message is buffered, and stalled once some are but not enough to decode
+// the message. The idle clock starts with AsyncReadNext. The stall clock starts with the first read which brings in
+// bytes of the message and is not restarted by the reads which bring in the rest of it, so a peer trickling in one
+// byte at a time cannot hold the message open past the stall timeout.
+//
+// When a timeout expires, the stream is cancelled and AsyncReadNext completes with sonicerrors.ErrIdleTimeout or
+// sonicerrors.ErrStallTimeout.
+type readTimeouts struct {
+	idle, stall time.Duration
+
+	timer    *Timer
+	cancel   func()
+	onExpire func()
+
+	phase   readPhase
+	expired error
+}
+
+func (t *readTimeouts) set(ioc *IO, stream Stream, idle, stall time.Duration) error {
+	t.disarm()
+	t.idle, t.stall = idle, stall
+
+	if idle <= 0 && stall <= 0 {
+		t.close()
+		return nil
+	}
+
+	if t.timer == nil {
+		timer, err := NewTimer(ioc)
+		if err != nil {
+			return err
+		}
+		t.timer = timer
+		t.cancel = stream.Cancel
+		t.onExpire = t.expire
+	}
+	return nil
+}
+
+// arm starts the clock of the phase the next read is in, unless it already runs.
+func (t *readTimeouts) arm(started bool) {
+	if t.timer == nil {
+		return
+	}
+
+	phase, d := readIdle, t.idle
+	if started {
+		phase, d = readStall, t.stall
+	}
+	if t.phase == phase {
+		return
+	}
+
+	_ = t.timer.Cancel()
+	t.phase = phase
+	if d > 0 {
+		_ = t.timer.ScheduleOnce(d, t.onExpire)
+	}
+}
+
+func (t *readTimeouts) disarm() {
+	if t.timer != nil && t.phase != readNone {
+		_ = t.timer.Cancel()
+	}
+	t.phase = readNone
+}
+
+func (t *readTimeouts) expire() {
+	switch t.phase {
+	case readIdle:
+		t.expired = sonicerrors.ErrIdleTimeout
+	case readStall:
+		t.expired = sonicerrors.ErrStallTimeout
+	default:
+		return
+	}
+	t.phase = readNone
+	t.cancel()
+}
+
+// readFailed disarms the timeouts after a failed read and returns the error to report, which is the expired timeout
+// if the read was cancelled because of it.
+func (t *readTimeouts) readFailed(err error) error {
+	t.disarm()
+	if t.expired != nil {
+		err, t.expired = t.expired, nil
+	}
+	return err
+}
+
+func (t *readTimeouts) close() {
+	if t.timer != nil {
+		_ = t.timer.Close()
+		t.timer = nil
+	}
+	t.phase = readNone
+	t.expired = nil
+}
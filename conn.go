@@ -2,9 +2,13 @@ package sonic
 
 import (
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"syscall"
 	"time"
 
+	"github.com/csdenboer/sonic/sonicerrors"
 	"github.com/csdenboer/sonic/sonicopts"
 
 	"github.com/csdenboer/sonic/internal"
@@ -17,6 +21,7 @@ type conn struct {
 	fd         int
 	localAddr  net.Addr
 	remoteAddr net.Addr
+	userData   any
 }
 
 // Dial establishes a stream based connection to the specified address.
@@ -43,6 +48,224 @@ func DialTimeout(
 	return newConn(ioc, fd, localAddr, remoteAddr), nil
 }
 
+// AsyncDial establishes a TCP connection to the specified address without blocking the IO. cb is invoked with the
+// connection once it is established, or with the error which prevented it.
+//
+// If the host of addr is a name rather than an IP address, it is resolved on a goroutine of its own, as resolving
+// may block, and the connect starts once the result is posted back to the IO.
+//
+// The connect is not timed out by sonic; the kernel gives up after its SYN retries, which takes about two minutes
+// with the default settings of Linux. See AsyncDialTimeout.
+func AsyncDial(
+	ioc *IO,
+	network, addr string,
+	cb AcceptCallback,
+	opts ...sonicopts.Option,
+) {
+	AsyncDialTimeout(ioc, network, addr, 0, cb, opts...)
+}
+
+// AsyncDialTimeout is AsyncDial with a bound on the time the dial takes, including the resolution of addr. If the
+// connection is not established within timeout, the socket is closed and cb is invoked with sonicerrors.ErrTimeout.
+// A timeout <= 0 does not bound the dial.
+func AsyncDialTimeout(
+	ioc *IO,
+	network, addr string,
+	timeout time.Duration,
+	cb AcceptCallback,
+	opts ...sonicopts.Option,
+) {
+	if len(network) < 3 || network[:3] != "tcp" {
+		cb(fmt.Errorf("async dial only supports tcp, not %s", network), nil)
+		return
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		cb(err, nil)
+		return
+	}
+
+	d := &asyncDial{ioc: ioc, network: network, cb: cb, opts: opts}
+	if timeout > 0 {
+		if d.expiry, err = ioc.ScheduleAfter(timeout, d.expire); err != nil {
+			cb(err, nil)
+			return
+		}
+	}
+
+	if host == "" || net.ParseIP(host) != nil {
+		d.connect(addr)
+		return
+	}
+
+	go func() {
+		raddr, err := net.ResolveTCPAddr(network, addr)
+		_ = postOnce(ioc, func() {
+			if err != nil {
+				d.complete(err, nil)
+			} else {
+				d.connect(raddr.String())
+			}
+		})
+	}()
+}
+
+// asyncDial is a dial started by AsyncDialTimeout.
+type asyncDial struct {
+	ioc     *IO
+	network string
+	cb      AcceptCallback
+	opts    []sonicopts.Option
+
+	expiry *Timeout
+	conn   *conn // set while the connect is in progress
+	done   bool
+}
+
+// connect starts the connect to addr, whose host is an IP address.
+func (d *asyncDial) connect(addr string) {
+	if d.done {
+		// Timed out while resolving.
+		return
+	}
+
+	fd, remoteAddr, inProgress, err := internal.StartConnectTCP(d.network, addr, d.opts...)
+	if err != nil {
+		d.complete(err, nil)
+		return
+	}
+
+	c := newConn(d.ioc, fd, nil, remoteAddr)
+	if !inProgress {
+		d.connected(c, nil)
+		return
+	}
+
+	c.slot.Set(internal.WriteEvent, func(err error) {
+		if d.conn != c {
+			// Cancelled by closing the socket once the timeout expired.
+			return
+		}
+		d.ioc.Deregister(&c.slot)
+		d.conn = nil
+		d.connected(c, err)
+	})
+	if err := d.ioc.SetWrite(&c.slot); err != nil {
+		_ = c.Close()
+		d.complete(err, nil)
+		return
+	}
+	d.ioc.Register(&c.slot)
+	d.conn = c
+}
+
+func (d *asyncDial) connected(c *conn, err error) {
+	if err == nil {
+		err = internal.FinishConnect(c.fd)
+	}
+	if err == nil {
+		c.localAddr, err = internal.SocketAddress(c.fd)
+	}
+	if err != nil {
+		_ = c.Close()
+		d.complete(err, nil)
+	} else {
+		d.complete(nil, c)
+	}
+}
+
+// expire aborts the dial once its timeout expired.
+func (d *asyncDial) expire() {
+	d.expiry = nil
+	if c := d.conn; c != nil {
+		d.conn = nil
+		d.ioc.Deregister(&c.slot)
+		_ = c.Close()
+	}
+	d.complete(sonicerrors.ErrTimeout, nil)
+}
+
+func (d *asyncDial) complete(err error, c Conn) {
+	if d.done {
+		return
+	}
+	d.done = true
+	if d.expiry != nil {
+		d.expiry.Cancel()
+	}
+	d.cb(err, c)
+}
+
+// AdoptConn creates a Conn from the file descriptor of a connected stream socket. The Conn runs its asynchronous
+// operations on the provided IO and owns the file descriptor, which is made nonblocking.
+//
+// This is how a connection accepted on one IO is handed over to another, for example to the IO running on the CPU
+// which processes the connection's packets, see CPUSteering. The Conn the file descriptor was accepted with must not
+// be used, nor closed, afterwards.
+func AdoptConn(ioc *IO, fd int) (Conn, error) {
+	localAddr, err := internal.SocketAddress(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	peer, err := syscall.Getpeername(fd)
+	if err != nil {
+		return nil, os.NewSyscallError("getpeername", err)
+	}
+
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		return nil, os.NewSyscallError("set_nonblock", err)
+	}
+
+	return newConn(ioc, fd, localAddr, internal.FromSockaddr(peer)), nil
+}
+
+// AdoptFd creates a Conn from the file descriptor of a connected stream socket which the process got from elsewhere,
+// such as a socket passed by systemd with socket activation and Accept=yes, or received over a unix domain socket.
+// Like AdoptConn, the Conn owns the file descriptor, which is made nonblocking. It is also made close-on-exec, such
+// that it does not leak into the processes started by this one.
+func AdoptFd(ioc *IO, fd int) (Conn, error) {
+	syscall.CloseOnExec(fd)
+	return AdoptConn(ioc, fd)
+}
+
+// AdoptNetConn creates a Conn from a connected net.Conn, such as one accepted by a net.Listener. conn must expose its
+// file descriptor with syscall.Conn, as *net.TCPConn and *net.UnixConn do.
+//
+// The Conn owns a duplicate of the file descriptor of conn, and conn is closed, which releases its file descriptor
+// without shutting the connection down. The connection is then only served by the IO. The bytes which a wrapper of
+// conn, such as a bufio.Reader, read ahead are not carried over, so conn must not have been read through one.
+func AdoptNetConn(ioc *IO, conn net.Conn) (Conn, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("cannot adopt %T: it does not expose its file descriptor", conn)
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	fd := -1
+	var dupErr error
+	if err := rc.Control(func(s uintptr) {
+		fd, dupErr = internal.DupCloseOnExec(int(s))
+	}); err != nil {
+		return nil, err
+	}
+	if dupErr != nil {
+		return nil, dupErr
+	}
+
+	c, err := AdoptConn(ioc, fd)
+	if err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+	_ = conn.Close()
+	return c, nil
+}
+
 func newConn(
 	ioc *IO,
 	fd int,
@@ -63,6 +286,129 @@ func (c *conn) RemoteAddr() net.Addr {
 	return c.remoteAddr
 }
 
+// ShutdownRead shuts down the reading side of the connection with shutdown(2).
+//
+// A pending read is completed with io.EOF. Subsequent reads return io.EOF, even if the peer keeps writing.
+func (c *conn) ShutdownRead() error {
+	if c.Closed() {
+		return io.EOF
+	}
+	if c.readShutdown {
+		return nil
+	}
+
+	if err := syscall.Shutdown(c.fd, syscall.SHUT_RD); err != nil {
+		return os.NewSyscallError("shutdown", err)
+	}
+	c.readShutdown = true
+
+	if c.slot.Events&internal.PollerReadEvent == internal.PollerReadEvent {
+		err := c.ioc.poller.DelRead(&c.slot)
+		if err == nil {
+			err = io.EOF
+		}
+		c.slot.Handlers[internal.ReadEvent](err)
+	}
+
+	return nil
+}
+
+// ShutdownWrite shuts down the writing side of the connection with shutdown(2). The bytes already written to the
+// socket are sent before the FIN.
+//
+// A pending write is completed with sonicerrors.ErrCancelled, since its remaining bytes can no longer be sent.
+// Callers which want all their bytes sent should call ShutdownWrite only after their last write completes. Subsequent
+// writes fail with syscall.EPIPE.
+func (c *conn) ShutdownWrite() error {
+	if c.Closed() {
+		return io.EOF
+	}
+	if c.writeShutdown {
+		return nil
+	}
+
+	if err := syscall.Shutdown(c.fd, syscall.SHUT_WR); err != nil {
+		return os.NewSyscallError("shutdown", err)
+	}
+	c.writeShutdown = true
+
+	c.cancelWrites()
+
+	return nil
+}
+
+func (c *conn) ShutdownState() (s ShutdownState) {
+	if c.readShutdown {
+		s |= ShutRead
+	}
+	if c.writeShutdown {
+		s |= ShutWrite
+	}
+	return s
+}
+
+// Peek reads at most len(b) bytes from the connection with MSG_PEEK, leaving them in the socket's receive buffer.
+//
+// Peek is meant to sniff the first bytes of a connection, such as a TLS ClientHello or a PROXY protocol header,
+// before handing the connection to a protocol handler. sonicerrors.ErrWouldBlock is returned if no bytes are
+// available and io.EOF if the peer closed the connection.
+func (c *conn) Peek(b []byte) (int, error) {
+	if c.readShutdown || c.Closed() {
+		return 0, io.EOF
+	}
+
+	n, _, err := syscall.Recvfrom(c.fd, b, syscall.MSG_PEEK)
+	if err != nil {
+		if err == syscall.EWOULDBLOCK || err == syscall.EAGAIN {
+			c.slot.WouldBlock(internal.ReadEvent)
+			return 0, sonicerrors.ErrWouldBlock
+		}
+		return 0, err
+	}
+
+	if n == 0 && len(b) > 0 {
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
+
+// AsyncPeek peeks at most len(b) bytes from the connection, waiting for at least one byte to be available.
+//
+// The peeked bytes stay readable, so the read event of the connection keeps firing until they are consumed. Hence,
+// callers which need more bytes than were peeked should not call AsyncPeek again right away; they should read what
+// is available instead, or wait for more bytes with a Timer.
+func (c *conn) AsyncPeek(b []byte, cb AsyncCallback) {
+	n, err := c.Peek(b)
+	if err != sonicerrors.ErrWouldBlock {
+		cb(err, n)
+		return
+	}
+	c.schedulePeek(b, cb)
+}
+
+func (c *conn) schedulePeek(b []byte, cb AsyncCallback) {
+	if c.Closed() {
+		cb(io.EOF, 0)
+		return
+	}
+
+	c.slot.Set(internal.ReadEvent, func(err error) {
+		c.ioc.Deregister(&c.slot)
+		if err != nil {
+			cb(err, 0)
+		} else {
+			c.AsyncPeek(b, cb)
+		}
+	})
+
+	if err := c.ioc.SetRead(&c.slot); err != nil {
+		cb(err, 0)
+	} else {
+		c.ioc.Register(&c.slot)
+	}
+}
+
 func (c *conn) SetDeadline(t time.Time) error {
 	return fmt.Errorf("not supported")
 }
@@ -73,6 +419,14 @@ func (c *conn) SetWriteDeadline(t time.Time) error {
 	return fmt.Errorf("not supported")
 }
 
+func (c *conn) SetUserData(data any) {
+	c.userData = data
+}
+
+func (c *conn) UserData() any {
+	return c.userData
+}
+
 func (c *conn) RawFd() int {
 	return c.fd
 }
@@ -1,6 +1,8 @@
 package sonic
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -10,6 +12,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/csdenboer/sonic/sonicerrors"
 	"github.com/csdenboer/sonic/sonicopts"
 )
 
@@ -205,7 +208,8 @@ func TestConnAsyncTCPEchoClient(t *testing.T) {
 	var onAsyncRead AsyncCallback
 	onAsyncRead = func(err error, n int) {
 		if err != nil {
-			if err != io.EOF {
+			// The read pending when the connection is closed is cancelled.
+			if err != io.EOF && err != sonicerrors.ErrCancelled {
 				t.Fatal(err)
 			}
 		} else {
@@ -349,3 +353,1488 @@ func TestConnWriteHandlesError(t *testing.T) {
 		t.Fatal("test did not run to completion")
 	}
 }
+
+func TestConnTCPBindSocket(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 42100}
+	conn, err := Dial(ioc, "tcp", ln.Addr().String(), sonicopts.BindSocket(local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if port := conn.LocalAddr().(*net.TCPAddr).Port; port != local.Port {
+		t.Fatalf("expected local port=%d got=%d", local.Port, port)
+	}
+}
+
+func TestConnTCPBindPortRange(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// Occupy the first port of the range such that the dialer must retry on the second one.
+	busy, err := net.Listen("tcp", "127.0.0.1:42110")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	for i := 0; i < 10; i++ {
+		conn, err := Dial(ioc, "tcp", ln.Addr().String(),
+			sonicopts.BindPortRange(net.IPv4(127, 0, 0, 1), 42110, 42111))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if port := conn.LocalAddr().(*net.TCPAddr).Port; port != 42111 {
+			t.Fatalf("expected local port=42111 got=%d", port)
+		}
+
+		// Close with RST so the port does not linger in TIME_WAIT.
+		_ = syscall.SetsockoptLinger(conn.RawFd(), syscall.SOL_SOCKET, syscall.SO_LINGER, &syscall.Linger{Onoff: 1})
+		_ = conn.Close()
+	}
+}
+
+func TestConnTCPBindPortRangeExhausted(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	busy, err := net.Listen("tcp", "127.0.0.1:42120")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	_, err = Dial(ioc, "tcp", ln.Addr().String(), sonicopts.BindPortRange(net.IPv4(127, 0, 0, 1), 42120, 42120))
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		t.Fatalf("expected EADDRINUSE got=%v", err)
+	}
+	if !errors.Is(err, sonicerrors.ErrPortsExhausted) {
+		t.Fatalf("expected ErrPortsExhausted got=%v", err)
+	}
+}
+
+func TestConnTCPBindPortRangeCollision(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	// With SO_REUSEADDR, the dialer can bind to the port of this connection, and the collision with it, which has the
+	// same peer, only shows at connect. The dialer must then retry on the next port of the range.
+	local := net.IPv4(127, 0, 0, 1)
+	taken, err := Dial(ioc, "tcp", ln.Addr().String(), sonicopts.ReuseAddr(true), sonicopts.BindPortRange(local, 42130, 42130))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	opts := []sonicopts.Option{sonicopts.ReuseAddr(true), sonicopts.BindPortRange(local, 42130, 42131)}
+	check := func(err error, conn Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if port := conn.LocalAddr().(*net.TCPAddr).Port; port != 42131 {
+			t.Fatalf("expected local port=42131 got=%d", port)
+		}
+		_ = syscall.SetsockoptLinger(conn.RawFd(), syscall.SOL_SOCKET, syscall.SO_LINGER, &syscall.Linger{Onoff: 1})
+		_ = conn.Close()
+	}
+
+	for i := 0; i < 10; i++ {
+		conn, err := Dial(ioc, "tcp", ln.Addr().String(), opts...)
+		check(err, conn)
+
+		done := false
+		AsyncDial(ioc, "tcp", ln.Addr().String(), func(err error, conn Conn) {
+			done = true
+			check(err, conn)
+		}, opts...)
+		for j := 0; j < 100 && !done; j++ {
+			_ = ioc.RunOneFor(10 * time.Millisecond)
+		}
+		if !done {
+			t.Fatal("the async dial did not complete")
+		}
+	}
+
+	// Without another port to retry on, the collision fails the dial.
+	_, err = Dial(ioc, "tcp", ln.Addr().String(), sonicopts.ReuseAddr(true), sonicopts.BindPortRange(local, 42130, 42130))
+	if !errors.Is(err, syscall.EADDRNOTAVAIL) {
+		t.Fatalf("expected EADDRNOTAVAIL got=%v", err)
+	}
+}
+
+func TestConnShutdownWrite(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Reply only once the client half-closes.
+		b, err := io.ReadAll(conn)
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write(append(b, " world"...))
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.ShutdownWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("hello")); !errors.Is(err, syscall.EPIPE) {
+		t.Fatalf("expected EPIPE got=%v", err)
+	}
+
+	var (
+		got  []byte
+		done bool
+		b    = make([]byte, 128)
+	)
+	var onRead AsyncCallback
+	onRead = func(err error, n int) {
+		if err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			done = true
+			return
+		}
+		got = append(got, b[:n]...)
+		conn.AsyncRead(b, onRead)
+	}
+	conn.AsyncRead(b, onRead)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && !done {
+		_, _ = ioc.PollOne()
+	}
+
+	if !done {
+		t.Fatal("test did not run to completion")
+	}
+	if string(got) != "hello world" {
+		t.Fatalf("expected reply=hello world got=%s", got)
+	}
+}
+
+func TestConnShutdownReadCompletesPendingRead(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var readErr error
+	conn.AsyncRead(make([]byte, 128), func(err error, _ int) {
+		readErr = err
+	})
+	if readErr != nil {
+		t.Fatal("read should be pending")
+	}
+
+	if err := conn.ShutdownRead(); err != nil {
+		t.Fatal(err)
+	}
+	if readErr != io.EOF {
+		t.Fatalf("expected pending read to complete with EOF got=%v", readErr)
+	}
+
+	if _, err := conn.Read(make([]byte, 128)); err != io.EOF {
+		t.Fatalf("expected EOF got=%v", err)
+	}
+
+	// The writing side is still open.
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnAsyncPeek(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Give the client the time to schedule the peek.
+		time.Sleep(10 * time.Millisecond)
+		_, _ = conn.Write([]byte("GET / HTTP/1.1\r\n"))
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Peek(make([]byte, 3)); err != sonicerrors.ErrWouldBlock {
+		t.Fatalf("expected ErrWouldBlock got=%v", err)
+	}
+
+	done := false
+	peeked := make([]byte, 3)
+	conn.AsyncPeek(peeked, func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(peeked[:n]) != "GET" {
+			t.Fatalf("expected to peek GET got=%s", peeked[:n])
+		}
+		done = true
+	})
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && !done {
+		_, _ = ioc.PollOne()
+	}
+	if !done {
+		t.Fatal("test did not run to completion")
+	}
+
+	// The peeked bytes are not consumed.
+	b := make([]byte, 128)
+	n, err := conn.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:n]) != "GET / HTTP/1.1\r\n" {
+		t.Fatalf("unexpected read=%q", b[:n])
+	}
+}
+
+func TestConnUserData(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if conn.UserData() != nil {
+		t.Fatal("no user data should be attached")
+	}
+
+	conn.SetUserData(42)
+	if v, ok := UserDataOf[int](conn); !ok || v != 42 {
+		t.Fatal("should have gotten the attached value")
+	}
+}
+
+func TestConnAsyncWritesAreSerialized(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	const (
+		nWrites = 8
+		size    = 1024 * 1024
+	)
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		b := make([]byte, nWrites*size)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return
+		}
+		received <- b
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Each write is far bigger than the socket's send buffer, so all but the first are issued while another write is
+	// in progress.
+	var completed []int
+	for i := 0; i < nWrites; i++ {
+		i := i
+		conn.AsyncWriteAll(bytes.Repeat([]byte{byte(i)}, size), func(err error, n int) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != size {
+				t.Fatalf("expected to write %d bytes got=%d", size, n)
+			}
+			completed = append(completed, i)
+		})
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && len(completed) < nWrites {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+
+	for i, w := range completed {
+		if i != w {
+			t.Fatalf("writes completed out of order=%v", completed)
+		}
+	}
+	if len(completed) != nWrites {
+		t.Fatalf("expected %d writes got=%d", nWrites, len(completed))
+	}
+
+	var b []byte
+	select {
+	case b = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the peer did not receive all writes")
+	}
+	for i := 0; i < nWrites; i++ {
+		if !bytes.Equal(b[i*size:(i+1)*size], bytes.Repeat([]byte{byte(i)}, size)) {
+			t.Fatalf("the bytes of write %d are interleaved with other writes", i)
+		}
+	}
+}
+
+func TestConnAsyncWritev(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The middle buffer is far bigger than the socket's send buffer, so the write resumes from the middle of it.
+	bufs := [][]byte{
+		[]byte("head"),
+		bytes.Repeat([]byte{1}, 4*1024*1024),
+		[]byte("tail"),
+	}
+	expected := append([]byte("first"), bytes.Join(bufs, nil)...)
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		b := make([]byte, len(expected))
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return
+		}
+		received <- b
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var order []string
+	conn.AsyncWriteAll([]byte("first"), func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		order = append(order, "first")
+	})
+	written := 0
+	conn.(AsyncVectoredWriter).AsyncWritev(bufs, func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		written = n
+		order = append(order, "writev")
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && len(order) < 2 {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+
+	if len(order) != 2 || order[0] != "first" {
+		t.Fatalf("expected the vectored write to complete after the first write got=%v", order)
+	}
+	if written != len(expected)-len("first") {
+		t.Fatalf("expected %d bytes to be written got=%d", len(expected)-len("first"), written)
+	}
+
+	select {
+	case b := <-received:
+		if !bytes.Equal(b, expected) {
+			t.Fatal("the peer did not receive the buffers in order")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the peer did not receive all bytes")
+	}
+}
+
+func TestConnWritev(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	bufs := [][]byte{[]byte("head"), {}, []byte("body"), []byte("tail")}
+	expected := bytes.Join(bufs, nil)
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		b := make([]byte, len(expected))
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return
+		}
+		received <- b
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	n, err := conn.(VectoredWriter).Writev(bufs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(expected) {
+		t.Fatalf("expected %d bytes to be written got=%d", len(expected), n)
+	}
+
+	select {
+	case b := <-received:
+		if !bytes.Equal(b, expected) {
+			t.Fatal("the peer did not receive the buffers in order")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the peer did not receive all bytes")
+	}
+}
+
+func TestConnAsyncReadv(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	header, payload := []byte("head"), []byte("payload")
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Wait for the read to be scheduled, such that it completes once the socket is readable.
+		time.Sleep(10 * time.Millisecond)
+		_, _ = conn.Write(append(append([]byte{}, header...), payload...))
+		time.Sleep(100 * time.Millisecond)
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	hb, pb := make([]byte, len(header)), make([]byte, 128)
+	bufs := [][]byte{hb, pb}
+	read := -1
+	conn.(AsyncVectoredReader).AsyncReadv(bufs, func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		read = n
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && read < 0 {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+
+	if read != len(header)+len(payload) {
+		t.Fatalf("expected %d bytes to be read got=%d", len(header)+len(payload), read)
+	}
+	if !bytes.Equal(hb, header) || !bytes.Equal(pb[:len(payload)], payload) {
+		t.Fatalf("the bytes were not scattered in order got=%q %q", hb, pb[:len(payload)])
+	}
+	if len(bufs) != 2 || len(bufs[0]) != len(header) || len(bufs[1]) != 128 {
+		t.Fatal("expected the buffers to be left unmodified")
+	}
+}
+
+func TestConnReadv(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	done := make(chan struct{})
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte("abcdef"))
+		_ = conn.Close()
+		close(done)
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-done
+
+	if n, err := conn.(VectoredReader).Readv(nil); n != 0 || err != nil {
+		t.Fatalf("expected an empty read got n=%d err=%v", n, err)
+	}
+
+	a, b, c := make([]byte, 2), make([]byte, 0), make([]byte, 3)
+	var got []byte
+	for len(got) < 6 {
+		n, err := conn.(VectoredReader).Readv([][]byte{a, b, c})
+		if err == sonicerrors.ErrWouldBlock {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, bytes.Join([][]byte{a, b, c}, nil)[:n]...)
+	}
+	if string(got) != "abcdef" {
+		t.Fatalf("wrong bytes got=%q", got)
+	}
+
+	if _, err := conn.(VectoredReader).Readv([][]byte{a, c}); err != io.EOF {
+		t.Fatalf("expected io.EOF once the peer closed got=%v", err)
+	}
+}
+
+// connPair returns two connected nonblocking unix domain stream connections.
+func connPair(t testing.TB, ioc *IO) (*conn, *conn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fd := range fds {
+		if err := syscall.SetNonblock(fd, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return newConn(ioc, fds[0], nil, nil), newConn(ioc, fds[1], nil, nil)
+}
+
+func TestConnAsyncOpsDoNotAllocate(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	a, b := connPair(t, ioc)
+	defer a.Close()
+	defer b.Close()
+
+	const runs = 100
+	buf, rbuf := []byte("x"), make([]byte, 1)
+	bufs := [][]byte{buf, buf}
+	var failed error
+	onDone := func(err error, _ int) {
+		if err != nil {
+			failed = err
+		}
+	}
+
+	tests := []struct {
+		name string
+		run  func()
+	}{
+		{"AsyncWrite", func() { a.AsyncWrite(buf, onDone) }},
+		{"AsyncWriteAll", func() { a.AsyncWriteAll(buf, onDone) }},
+		{"AsyncRead", func() { b.AsyncRead(rbuf, onDone) }},
+		{"AsyncReadAll", func() { a.AsyncWrite(buf, onDone); b.AsyncReadAll(rbuf, onDone) }},
+		{"scheduled AsyncRead", func() {
+			b.AsyncRead(rbuf, onDone)
+			_, _ = a.Write(buf)
+			_ = ioc.RunOne()
+		}},
+		{"AsyncWritev", func() {
+			// The slice of buffers is consumed by the write.
+			bufs[0], bufs[1] = buf, buf
+			a.AsyncWritev(bufs, onDone)
+			_, _ = b.Read(rbuf)
+			_, _ = b.Read(rbuf)
+		}},
+	}
+	drain := make([]byte, 4096)
+	for _, test := range tests {
+		for {
+			if _, err := b.Read(drain); err != nil {
+				break
+			}
+		}
+
+		// Warm up, such that the state of the reads and writes is allocated.
+		test.run()
+		if allocs := testing.AllocsPerRun(runs, test.run); allocs != 0 {
+			t.Errorf("%s: expected no allocation got=%v", test.name, allocs)
+		}
+		if failed != nil {
+			t.Fatalf("%s: %v", test.name, failed)
+		}
+	}
+}
+
+func TestConnExecutionBudget(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	const n = 64
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write(make([]byte, 2*n))
+		<-done
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Wait for all bytes to be buffered, such that every read completes right away.
+	b := make([]byte, 2*n)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if m, _ := conn.Peek(b); m == len(b) {
+			break
+		}
+	}
+
+	reads := 0
+	var onRead AsyncCallback
+	onRead = func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		reads++
+		if reads < n {
+			conn.AsyncRead(b[:1], onRead)
+		}
+	}
+
+	conn.SetExecutionBudget(4, 0)
+	conn.AsyncRead(b[:1], onRead)
+	if reads != 4 {
+		t.Fatalf("expected 4 reads before yielding got=%d", reads)
+	}
+
+	// The handlers posted while the connection waits for its turn run before its next run.
+	marker := -1
+	_ = ioc.Post(func() { marker = reads })
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline) && reads < n; {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if reads != n {
+		t.Fatalf("expected %d reads got=%d", n, reads)
+	}
+	if marker != 8 {
+		t.Fatalf("expected the posted handler to run after the next run of 4 reads got=%d", marker)
+	}
+
+	// The time budget is exhausted right after the first read.
+	reads = 0
+	conn.SetExecutionBudget(0, time.Nanosecond)
+	conn.AsyncRead(b[:1], onRead)
+	if reads != 1 {
+		t.Fatalf("expected 1 read before yielding got=%d", reads)
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline) && reads < n; {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if reads != n {
+		t.Fatalf("expected %d reads got=%d", n, reads)
+	}
+}
+
+func TestConnContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The peer neither reads nor writes, such that reads and big writes stay pending.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		<-done
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	run := func(cond func() bool) {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline) && !cond(); {
+			_ = ioc.RunOneFor(time.Millisecond)
+		}
+	}
+
+	// A pending read is cancelled with the context.
+	ctx, cancel := context.WithCancel(context.Background())
+	var readErr error
+	conn.AsyncReadContext(ctx, make([]byte, 128), func(err error, _ int) {
+		readErr = err
+	})
+	_ = ioc.RunOneFor(10 * time.Millisecond)
+	if readErr != nil {
+		t.Fatalf("read should be pending got=%v", readErr)
+	}
+	cancel()
+	run(func() bool { return readErr != nil })
+	if readErr != context.Canceled {
+		t.Fatalf("expected context.Canceled got=%v", readErr)
+	}
+
+	// A context which is already done fails the read right away.
+	readErr = nil
+	conn.AsyncReadContext(ctx, make([]byte, 128), func(err error, _ int) {
+		readErr = err
+	})
+	if readErr != context.Canceled {
+		t.Fatalf("expected context.Canceled got=%v", readErr)
+	}
+
+	readErr = nil
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	conn.AsyncReadAllContext(ctx, make([]byte, 128), func(err error, _ int) {
+		readErr = err
+	})
+	run(func() bool { return readErr != nil })
+	if readErr != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded got=%v", readErr)
+	}
+
+	// Cancelling a queued write, then the write in progress, leaves the other writes untouched.
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+
+	var (
+		errs    [3]error
+		written [3]int
+		calls   [3]int
+	)
+	onWrite := func(i int) AsyncCallback {
+		return func(err error, n int) {
+			errs[i], written[i] = err, n
+			calls[i]++
+		}
+	}
+	conn.AsyncWriteAllContext(ctx1, make([]byte, 64*1024*1024), onWrite(0))
+	conn.AsyncWriteAllContext(ctx2, []byte("hello"), onWrite(1))
+	conn.AsyncWriteAll(make([]byte, 64*1024*1024), onWrite(2))
+
+	cancel2()
+	run(func() bool { return calls[1] > 0 })
+	if calls != [3]int{0, 1, 0} || errs[1] != context.Canceled || written[1] != 0 {
+		t.Fatalf("expected only the queued write to be cancelled got=%v %v %v", calls, errs, written)
+	}
+
+	cancel1()
+	run(func() bool { return calls[0] > 0 })
+	if calls != [3]int{1, 1, 0} || errs[0] != context.Canceled || written[0] == 0 {
+		t.Fatalf("expected the write in progress to be cancelled got=%v %v %v", calls, errs, written)
+	}
+
+	// The remaining write started once the cancelled one completed.
+	conn.Cancel()
+	if calls[2] != 1 || errs[2] != sonicerrors.ErrCancelled {
+		t.Fatalf("expected the last write to be in progress got=%v %v", calls, errs)
+	}
+}
+
+func TestConnCancelHandle(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The peer neither reads nor writes, such that reads and big writes stay pending.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		<-done
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var readErr error
+	h := conn.AsyncReadCancellable(make([]byte, 128), func(err error, _ int) {
+		readErr = err
+	})
+	if !h.Pending() {
+		t.Fatal("read should be pending")
+	}
+	if !h.Cancel() || readErr != sonicerrors.ErrCancelled {
+		t.Fatalf("expected the read to be cancelled got=%v", readErr)
+	}
+	if h.Pending() || h.Cancel() {
+		t.Fatal("expected a cancelled read to be complete")
+	}
+	if (CancelHandle{}).Cancel() {
+		t.Fatal("expected the zero handle to cancel nothing")
+	}
+
+	// The connection is still usable.
+	var written int
+	h = conn.AsyncWriteCancellable([]byte("hello"), func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		written = n
+	})
+	if written != 5 || h.Cancel() {
+		t.Fatalf("expected a complete write of 5 bytes got=%d", written)
+	}
+
+	var (
+		errs  [3]error
+		calls [3]int
+		n0    int
+	)
+	h0 := conn.AsyncWriteAllCancellable(make([]byte, 64*1024*1024), func(err error, n int) {
+		errs[0], n0 = err, n
+		calls[0]++
+	})
+	h1 := conn.AsyncWritevCancellable([][]byte{[]byte("a"), []byte("b")}, func(err error, _ int) {
+		errs[1] = err
+		calls[1]++
+	})
+	conn.AsyncWriteAll(make([]byte, 64*1024*1024), func(err error, _ int) {
+		errs[2] = err
+		calls[2]++
+	})
+
+	if !h1.Cancel() || calls != [3]int{0, 1, 0} || errs[1] != sonicerrors.ErrCancelled {
+		t.Fatalf("expected only the queued write to be cancelled got=%v %v", calls, errs)
+	}
+	if !h0.Cancel() || calls != [3]int{1, 1, 0} || errs[0] != sonicerrors.ErrCancelled || n0 == 0 {
+		t.Fatalf("expected the write in progress to be cancelled got=%v %v %d", calls, errs, n0)
+	}
+
+	// The remaining write started once the cancelled one completed.
+	conn.Cancel()
+	if calls[2] != 1 || errs[2] != sonicerrors.ErrCancelled {
+		t.Fatalf("expected the last write to be in progress got=%v %v", calls, errs)
+	}
+}
+
+func TestConnEdgeTriggered(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The peer echoes everything back, in chunks of its own.
+	const size = 4 << 20
+	go func() {
+		peer, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer peer.Close()
+		_, _ = io.Copy(peer, peer)
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.SetEdgeTriggered(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The write is larger than the socket buffers, so it waits for the connection to become writable, while the
+	// small reads leave bytes behind, so they are dispatched without waiting for an edge.
+	out := make([]byte, size)
+	for i := range out {
+		out[i] = byte(i)
+	}
+	written := false
+	conn.AsyncWriteAll(out, func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		written = true
+	})
+
+	var (
+		in    []byte
+		b     = make([]byte, 1500)
+		start = time.Now()
+	)
+	var onRead AsyncCallback
+	onRead = func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		in = append(in, b[:n]...)
+		if len(in) < size {
+			conn.AsyncRead(b, onRead)
+		}
+	}
+	conn.AsyncRead(b, onRead)
+
+	for (!written || len(in) < size) && time.Since(start) < 10*time.Second {
+		_ = ioc.RunOneFor(10 * time.Millisecond)
+	}
+	if !written || !bytes.Equal(in, out) {
+		t.Fatalf("expected %d bytes echoed back got=%d written=%v", size, len(in), written)
+	}
+
+	// The registration cannot change while an operation is pending.
+	conn.AsyncRead(b, func(error, int) {})
+	if err := conn.SetEdgeTriggered(false); err == nil {
+		t.Fatal("expected the registration not to change while a read is pending")
+	}
+	conn.Cancel()
+	if err := conn.SetEdgeTriggered(false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnHalfDuplexMatrix(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	type op struct {
+		name string
+		run  func(c Conn) error
+	}
+	b := make([]byte, 1)
+	syncErr := func(n int, err error) error { return err }
+	asyncErr := func(start func(AsyncCallback)) error {
+		var (
+			done   bool
+			result error
+		)
+		// The operations below can complete right away, so their handler is invoked before they return.
+		start(func(err error, _ int) { done, result = true, err })
+		if !done {
+			return errors.New("not completed")
+		}
+		return result
+	}
+	ops := []op{
+		{"Read", func(c Conn) error { return syncErr(c.Read(b)) }},
+		{"AsyncRead", func(c Conn) error { return asyncErr(func(cb AsyncCallback) { c.AsyncRead(b, cb) }) }},
+		{"Peek", func(c Conn) error { return syncErr(c.Peek(b)) }},
+		{"Readv", func(c Conn) error { return syncErr(c.Readv([][]byte{b})) }},
+		{"AsyncReadv", func(c Conn) error { return asyncErr(func(cb AsyncCallback) { c.AsyncReadv([][]byte{b}, cb) }) }},
+		{"Write", func(c Conn) error { return syncErr(c.Write(b)) }},
+		{"AsyncWrite", func(c Conn) error { return asyncErr(func(cb AsyncCallback) { c.AsyncWrite(b, cb) }) }},
+		{"Writev", func(c Conn) error { return syncErr(c.Writev([][]byte{b})) }},
+		{"AsyncWritev", func(c Conn) error { return asyncErr(func(cb AsyncCallback) { c.AsyncWritev([][]byte{b}, cb) }) }},
+	}
+
+	// The expected errors of ops, for each state.
+	var eof, epipe error = io.EOF, syscall.EPIPE
+	states := []struct {
+		name     string
+		enter    func(c Conn) error
+		state    ShutdownState
+		expected []error
+	}{
+		{"open", func(Conn) error { return nil }, ShutNone, []error{nil, nil, nil, nil, nil, nil, nil, nil, nil}},
+		{"read shutdown", func(c Conn) error {
+			return c.ShutdownRead()
+		}, ShutRead, []error{eof, eof, eof, eof, eof, nil, nil, nil, nil}},
+		{"write shutdown", func(c Conn) error {
+			return c.ShutdownWrite()
+		}, ShutWrite, []error{nil, nil, nil, nil, nil, epipe, epipe, epipe, epipe}},
+		{"both shutdown", func(c Conn) error {
+			if err := c.ShutdownRead(); err != nil {
+				return err
+			}
+			return c.ShutdownWrite()
+		}, ShutBoth, []error{eof, eof, eof, eof, eof, epipe, epipe, epipe, epipe}},
+		{"closed", func(c Conn) error {
+			return c.Close()
+		}, ShutNone, []error{eof, eof, eof, eof, eof, eof, eof, eof, eof}},
+	}
+
+	for _, state := range states {
+		t.Run(state.name, func(t *testing.T) {
+			accepted := make(chan net.Conn, 1)
+			go func() {
+				peer, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				// Enough bytes for every read of the matrix.
+				_, _ = peer.Write(make([]byte, 16))
+				accepted <- peer
+			}()
+
+			conn, err := Dial(ioc, "tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			peer := <-accepted
+			defer peer.Close()
+
+			// Wait for the bytes of the peer to arrive.
+			for {
+				if n, err := conn.Peek(b); n == 1 && err == nil {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			if err := state.enter(conn); err != nil {
+				t.Fatal(err)
+			}
+			if s := conn.ShutdownState(); s != state.state {
+				t.Fatalf("expected the shutdown state %s got=%s", state.state, s)
+			}
+			for i, op := range ops {
+				if err := op.run(conn); !errors.Is(err, state.expected[i]) {
+					t.Errorf("%s: expected err=%v got=%v", op.name, state.expected[i], err)
+				}
+			}
+		})
+	}
+}
+
+func TestConnIPv6(t *testing.T) {
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = io.Copy(conn, conn)
+			conn.Close()
+		}
+	}()
+
+	udp, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	for _, dial := range []struct {
+		network, addr string
+		opts          []sonicopts.Option
+	}{
+		{"tcp", ln.Addr().String(), nil},
+		{"tcp6", ln.Addr().String(), []sonicopts.Option{sonicopts.BindSocket(&net.TCPAddr{IP: net.IPv6loopback})}},
+		{"udp", udp.LocalAddr().String(), nil},
+	} {
+		conn, err := Dial(ioc, dial.network, dial.addr, dial.opts...)
+		if err != nil {
+			t.Fatalf("dial %s %s: %v", dial.network, dial.addr, err)
+		}
+
+		for _, addr := range []net.Addr{conn.LocalAddr(), conn.RemoteAddr()} {
+			if ip := addrIP(addr); ip == nil || !ip.Equal(net.IPv6loopback) {
+				t.Fatalf("dial %s %s: expected an address on ::1 got=%v", dial.network, dial.addr, addr)
+			}
+		}
+
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+
+	b := make([]byte, 128)
+	n, from, err := udp.ReadFromUDP(b)
+	if err != nil || string(b[:n]) != "hello" || !from.IP.Equal(net.IPv6loopback) {
+		t.Fatalf("wrong datagram %q from=%v err=%v", b[:n], from, err)
+	}
+}
+
+func TestListenIPv6Unspecified(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	// An address without an IP is the unspecified address of the family of the network.
+	for network, domain := range map[string]int{"tcp": syscall.AF_INET, "tcp6": syscall.AF_INET6} {
+		ln, err := Listen(ioc, network, ":0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sa, err := syscall.Getsockname(ln.RawFd())
+		ln.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ipv6 := sa.(*syscall.SockaddrInet6); ipv6 != (domain == syscall.AF_INET6) {
+			t.Fatalf("listen %s: wrong family of %#v", network, sa)
+		}
+	}
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	default:
+		return nil
+	}
+}
+
+func TestAdoptNetConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	accepted, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := AdoptNetConn(ioc, accepted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if conn.RemoteAddr().String() != client.LocalAddr().String() {
+		t.Fatalf("wrong remote address got=%s expected=%s", conn.RemoteAddr(), client.LocalAddr())
+	}
+	if _, err := accepted.Write([]byte("x")); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected the adopted net.Conn to be closed got=%v", err)
+	}
+	flags, err := fcntl(conn.RawFd(), syscall.F_GETFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags&syscall.FD_CLOEXEC == 0 {
+		t.Fatal("expected the adopted file descriptor to be close-on-exec")
+	}
+
+	// Closing the net.Conn did not shut the connection down: it is now served by the IO.
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	var echoed string
+	b := make([]byte, 128)
+	conn.AsyncRead(b, func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.AsyncWriteAll(b[:n], func(err error, _ int) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			echoed = string(b[:n])
+		})
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for echoed == "" && time.Now().Before(deadline) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if echoed != "hello" {
+		t.Fatalf("wrong echo got=%q", echoed)
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := client.Read(b); err != nil || string(b[:n]) != "hello" {
+		t.Fatalf("wrong echo read by the client got=%q err=%v", b[:n], err)
+	}
+
+	if _, err := AdoptNetConn(ioc, struct{ net.Conn }{client}); err == nil {
+		t.Fatal("expected a net.Conn which does not expose its file descriptor to be rejected")
+	}
+}
+
+func TestAdoptFd(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[1])
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := AdoptFd(ioc, fds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	flags, err := fcntl(fds[0], syscall.F_GETFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags&syscall.FD_CLOEXEC == 0 {
+		t.Fatal("expected the adopted file descriptor to be close-on-exec")
+	}
+
+	// The file descriptor is nonblocking.
+	if _, err := conn.Read(make([]byte, 8)); err != sonicerrors.ErrWouldBlock {
+		t.Fatalf("expected ErrWouldBlock got=%v", err)
+	}
+
+	if _, err := syscall.Write(fds[1], []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	var read string
+	b := make([]byte, 128)
+	conn.AsyncRead(b, func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		read = string(b[:n])
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for read == "" && time.Now().Before(deadline) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if read != "hello" {
+		t.Fatalf("wrong read got=%q", read)
+	}
+}
+
+func fcntl(fd int, cmd int) (int, error) {
+	r, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), uintptr(cmd), 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
+
+func TestConnClosePending(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		peer, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- peer
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The peer neither writes nor reads, so the read below waits for bytes and the writes for room.
+	peer := <-accepted
+	defer peer.Close()
+
+	var errs []error
+	record := func(err error, _ int) { errs = append(errs, err) }
+
+	conn.AsyncRead(make([]byte, 128), record)
+	conn.AsyncWriteAll(make([]byte, 64*1024*1024), record)
+	conn.AsyncWrite(make([]byte, 128), record)
+	if len(errs) != 0 {
+		t.Fatalf("expected the operations to be pending got=%v", errs)
+	}
+
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 3 {
+		t.Fatalf("expected Close to complete the 3 pending operations got=%d", len(errs))
+	}
+	for _, err := range errs {
+		if err != sonicerrors.ErrCancelled {
+			t.Fatalf("expected ErrCancelled got=%v", err)
+		}
+	}
+}
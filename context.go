@@ -0,0 +1,68 @@
+package sonic
+
+import (
+	"context"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+// watchContext cancels op, on the goroutine running ioc, once ctx is done. The goroutine watching ctx ends once op
+// completes.
+func (op *asyncOp) watchContext(ioc *IO, ctx context.Context) {
+	done := ctx.Done()
+	if op.done || done == nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	op.stop = stop
+	go func() {
+		select {
+		case <-done:
+			_ = ioc.Post(func() { CancelHandle{op}.Cancel() })
+		case <-stop:
+		}
+	}()
+}
+
+// contextHandler returns cb adapted to report ctx.Err() instead of sonicerrors.ErrCancelled once ctx is done.
+func contextHandler(ctx context.Context, cb AsyncCallback) AsyncCallback {
+	return func(err error, n int) {
+		if err == sonicerrors.ErrCancelled && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		cb(err, n)
+	}
+}
+
+func (f *file) AsyncReadContext(ctx context.Context, b []byte, cb AsyncCallback) {
+	f.asyncReadContext(ctx, b, false, cb)
+}
+
+func (f *file) AsyncReadAllContext(ctx context.Context, b []byte, cb AsyncCallback) {
+	f.asyncReadContext(ctx, b, true, cb)
+}
+
+func (f *file) asyncReadContext(ctx context.Context, b []byte, readAll bool, cb AsyncCallback) {
+	if err := ctx.Err(); err != nil {
+		cb(err, 0)
+		return
+	}
+	f.readOp(b, readAll, contextHandler(ctx, cb)).watchContext(f.ioc, ctx)
+}
+
+func (f *file) AsyncWriteContext(ctx context.Context, b []byte, cb AsyncCallback) {
+	f.asyncWriteContext(ctx, b, false, cb)
+}
+
+func (f *file) AsyncWriteAllContext(ctx context.Context, b []byte, cb AsyncCallback) {
+	f.asyncWriteContext(ctx, b, true, cb)
+}
+
+func (f *file) asyncWriteContext(ctx context.Context, b []byte, writeAll bool, cb AsyncCallback) {
+	if err := ctx.Err(); err != nil {
+		cb(err, 0)
+		return
+	}
+	f.writeOp(b, writeAll, contextHandler(ctx, cb)).watchContext(f.ioc, ctx)
+}
@@ -0,0 +1,105 @@
+package sonic
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/csdenboer/sonic/internal"
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+// SetDebug enables or disables the debug mode of the IO, which can be switched at any time, without a rebuild, to
+// chase protocol issues only seen in production.
+//
+// In debug mode, the IO and the objects running on it check invariants which are too expensive to check otherwise:
+//   - the Slots registered with the IO, such that a file descriptor is not registered by two Slots, which happens
+//     when it is reused while still registered, and that events are only set on Slots which handle them.
+//   - the commits and consumes of the ByteBuffers attached with ByteBuffer.SetDebugIO, which must not exceed the
+//     bytes available, and the areas of those buffers.
+//   - the frames of the codecs which support it, such as websocket, which must decode back to themselves once
+//     encoded.
+//
+// A violated invariant is reported with Violation. Debug mode also writes verbose diagnostics to the output set with
+// SetDebugOutput.
+func (ioc *IO) SetDebug(enabled bool) {
+	ioc.debug = enabled
+}
+
+// Debug returns true if the IO is in debug mode, see SetDebug.
+func (ioc *IO) Debug() bool {
+	return ioc.debug
+}
+
+// SetDebugOutput sets where the diagnostics of the debug mode are written. The default is os.Stderr. A nil writer
+// discards them.
+func (ioc *IO) SetDebugOutput(w io.Writer) {
+	if w == nil {
+		w = io.Discard
+	}
+	ioc.debugOutput = w
+}
+
+// Debugf writes a diagnostic line to the debug output if the IO is in debug mode. Like any other method of the IO,
+// it must be called from the goroutine running the IO.
+func (ioc *IO) Debugf(format string, args ...interface{}) {
+	if !ioc.debug {
+		return
+	}
+	w := ioc.debugOutput
+	if w == nil {
+		w = os.Stderr
+	}
+	_, _ = fmt.Fprintf(w, "sonic: "+format+"\n", args...)
+}
+
+// Violation reports that an invariant checked in debug mode does not hold, for the given file descriptor, or -1 if the
+// invariant does not concern one. The violation is written to the debug output and passed to the handler set with
+// SetErrorHandler as an error wrapping sonicerrors.ErrInvariantViolation.
+func (ioc *IO) Violation(fd int, format string, args ...interface{}) {
+	err := fmt.Errorf("%w: %s", sonicerrors.ErrInvariantViolation, fmt.Sprintf(format, args...))
+	ioc.Debugf("fd=%d %v", fd, err)
+	if ioc.onError != nil {
+		ioc.onError(fd, err)
+	}
+}
+
+// registeredByOther returns true if the file descriptor of slot is registered by another Slot.
+func (ioc *IO) registeredByOther(slot *internal.Slot) bool {
+	var other *internal.Slot
+	if slot.Fd >= len(ioc.pending.static) {
+		other = ioc.pending.dynamic.lookup(slot.Fd)
+	} else {
+		other = ioc.pending.static[slot.Fd]
+	}
+	return other != nil && other != slot
+}
+
+func (ioc *IO) checkRegister(slot *internal.Slot) {
+	ioc.Debugf("fd=%d register events=%d", slot.Fd, slot.Events)
+	if slot.Fd < 0 {
+		ioc.Violation(slot.Fd, "register of a slot without a file descriptor")
+	} else if ioc.registeredByOther(slot) {
+		ioc.Violation(slot.Fd, "register of a file descriptor already registered by another slot")
+	}
+}
+
+func (ioc *IO) checkDeregister(slot *internal.Slot) {
+	ioc.Debugf("fd=%d deregister events=%d", slot.Fd, slot.Events)
+	if slot.Fd < 0 {
+		ioc.Violation(slot.Fd, "deregister of a slot without a file descriptor")
+	} else if ioc.registeredByOther(slot) {
+		ioc.Violation(slot.Fd, "deregister of a file descriptor registered by another slot")
+	}
+}
+
+func (ioc *IO) checkSet(slot *internal.Slot, et internal.EventType) {
+	if et == internal.ReadEvent {
+		ioc.Debugf("fd=%d set read", slot.Fd)
+	} else {
+		ioc.Debugf("fd=%d set write", slot.Fd)
+	}
+	if slot.Handlers[et] == nil {
+		ioc.Violation(slot.Fd, "event set on a slot without a handler for it")
+	}
+}
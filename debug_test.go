@@ -0,0 +1,90 @@
+package sonic
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/csdenboer/sonic/internal"
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+func TestIODebug(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	var (
+		out        bytes.Buffer
+		violations []error
+	)
+	ioc.SetDebugOutput(&out)
+	ioc.SetErrorHandler(func(fd int, err error) {
+		violations = append(violations, err)
+	})
+
+	b := NewByteBuffer()
+	b.SetDebugIO(ioc)
+
+	// Nothing is checked outside of debug mode.
+	a, c := &internal.Slot{Fd: 3000}, &internal.Slot{Fd: 3000}
+	ioc.Register(a)
+	ioc.Register(c)
+	ioc.Deregister(c)
+	b.Commit(10)
+	if len(violations) != 0 || out.Len() != 0 {
+		t.Fatalf("expected no violation got=%v out=%q", violations, out.String())
+	}
+
+	ioc.SetDebug(true)
+	if !ioc.Debug() {
+		t.Fatal("expected the debug mode")
+	}
+
+	// Two slots register the same file descriptor, in the static and in the dynamic ranges.
+	for _, fd := range []int{3000, 5000} {
+		violations = nil
+		a, c := &internal.Slot{Fd: fd}, &internal.Slot{Fd: fd}
+		ioc.Register(a)
+		ioc.Register(a)
+		if len(violations) != 0 {
+			t.Fatalf("fd=%d expected no violation got=%v", fd, violations)
+		}
+		ioc.Register(c)
+		ioc.Deregister(a)
+		if len(violations) != 2 {
+			t.Fatalf("fd=%d expected 2 violations got=%v", fd, violations)
+		}
+		ioc.Deregister(c)
+	}
+
+	// An event is set on a slot which does not handle it.
+	violations = nil
+	_ = ioc.SetRead(&internal.Slot{Fd: -1})
+	if len(violations) != 1 {
+		t.Fatalf("expected 1 violation got=%v", violations)
+	}
+
+	// More bytes are committed and consumed than available.
+	violations = nil
+	_, _ = b.Write([]byte("hello"))
+	b.Commit(3)
+	b.Consume(2)
+	if len(violations) != 0 {
+		t.Fatalf("expected no violation got=%v", violations)
+	}
+	b.Commit(3)
+	b.Consume(4)
+	if len(violations) != 2 {
+		t.Fatalf("expected 2 violations got=%v", violations)
+	}
+	for _, err := range violations {
+		if !errors.Is(err, sonicerrors.ErrInvariantViolation) {
+			t.Fatalf("expected ErrInvariantViolation got=%v", err)
+		}
+	}
+
+	if !strings.Contains(out.String(), "fd=5000 register") {
+		t.Fatalf("expected the registrations in the diagnostics got=%q", out.String())
+	}
+}
@@ -1,8 +1,12 @@
 package sonic
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"net"
+	"os"
+	"time"
 )
 
 const (
@@ -16,6 +20,12 @@ const (
 // streams can be "async-adapted".
 
 type AsyncCallback func(error, int)
+
+// AsyncItemCallback is the completion handler of an asynchronous operation producing a typed result, like the messages
+// read by a CodecConn. The result is passed as is, not boxed into an interface, so completing the operation does not
+// allocate.
+type AsyncItemCallback[T any] func(error, T)
+
 type AcceptCallback func(error, Conn)
 type AcceptPacketCallback func(error, PacketConn)
 
@@ -63,11 +73,84 @@ type AsyncWriter interface {
 	AsyncWriteAll(b []byte, cb AsyncCallback)
 }
 
+// AsyncVectoredWriter is implemented by the streams which can write several buffers with a single system call, such
+// as Conn.
+type AsyncVectoredWriter interface {
+	// AsyncWritev writes all the bytes of bufs, in order, as AsyncWriteAll would write their concatenation but without
+	// copying them into a single buffer. The handler gets the number of bytes written.
+	//
+	// bufs itself, but not the bytes it refers to, is modified as the write progresses, as with net.Buffers. Both must
+	// remain valid until the handler is called.
+	AsyncWritev(bufs [][]byte, cb AsyncCallback)
+}
+
+// VectoredWriter is the synchronous counterpart of AsyncVectoredWriter.
+type VectoredWriter interface {
+	// Writev writes the bytes of bufs, in order, and returns the number of bytes written. bufs is modified as with
+	// AsyncWritev. A nonblocking stream returns sonicerrors.ErrWouldBlock, with the bytes written so far, once the
+	// underlying stream is full.
+	Writev(bufs [][]byte) (int, error)
+}
+
+// AsyncVectoredReader is implemented by the streams which can read into several buffers with a single system call,
+// such as Conn.
+type AsyncVectoredReader interface {
+	// AsyncReadv reads some bytes into bufs, as AsyncRead would read them into their concatenation: the first buffer
+	// is filled first, then the second one, and so on. The handler gets the number of bytes read, which is 0 only if
+	// bufs holds no byte. This lets a protocol read a header and its payload into separate buffers without copying.
+	//
+	// bufs is not modified. Both bufs and the bytes it refers to must remain valid until the handler is called.
+	AsyncReadv(bufs [][]byte, cb AsyncCallback)
+}
+
+// VectoredReader is the synchronous counterpart of AsyncVectoredReader.
+type VectoredReader interface {
+	// Readv reads some bytes into bufs, in order, and returns the number of bytes read. A nonblocking stream returns
+	// sonicerrors.ErrWouldBlock if nothing can be read.
+	Readv(bufs [][]byte) (int, error)
+}
+
 type AsyncReadWriter interface {
 	AsyncReader
 	AsyncWriter
 }
 
+// AsyncCancellableReadWriter is implemented by the streams whose asynchronous operations can be cancelled one by one,
+// such as Conn. Each method starts the operation of its counterpart and returns a CancelHandle which aborts just that
+// operation, leaving the other operations and the file descriptor untouched. The Cancel method of AsyncCanceller
+// instead aborts all operations at once.
+type AsyncCancellableReadWriter interface {
+	AsyncReadCancellable(b []byte, cb AsyncCallback) CancelHandle
+	AsyncReadAllCancellable(b []byte, cb AsyncCallback) CancelHandle
+	AsyncWriteCancellable(b []byte, cb AsyncCallback) CancelHandle
+	AsyncWriteAllCancellable(b []byte, cb AsyncCallback) CancelHandle
+	AsyncWritevCancellable(bufs [][]byte, cb AsyncCallback) CancelHandle
+}
+
+// AsyncContextReadWriter is implemented by the streams whose asynchronous operations can be bound to a
+// context.Context, such as Conn, which is how sonic integrates with servers propagating request contexts.
+//
+// An operation bound to ctx is cancelled once ctx is done, and its handler is then invoked with ctx.Err(), which is
+// context.Canceled or context.DeadlineExceeded, along with the bytes transferred so far. If ctx is already done, the
+// handler is invoked right away and nothing is transferred. An operation which completes before the cancellation
+// reaches the IO invokes its handler as usual. Other operations on the same stream are not affected: a cancelled
+// write is removed from the queue of writes, see Conn.
+//
+// A partially cancelled write leaves the peer with an incomplete message, so the stream is usually closed then.
+type AsyncContextReadWriter interface {
+	// AsyncReadContext is AsyncRead bound to ctx.
+	AsyncReadContext(ctx context.Context, b []byte, cb AsyncCallback)
+
+	// AsyncReadAllContext is AsyncReadAll bound to ctx.
+	AsyncReadAllContext(ctx context.Context, b []byte, cb AsyncCallback)
+
+	// AsyncWriteContext is AsyncWrite bound to ctx.
+	AsyncWriteContext(ctx context.Context, b []byte, cb AsyncCallback)
+
+	// AsyncWriteAllContext is AsyncWriteAll bound to ctx.
+	AsyncWriteAllContext(ctx context.Context, b []byte, cb AsyncCallback)
+}
+
 type AsyncReaderFrom interface {
 	AsyncReadFrom(AsyncReader, AsyncCallback)
 }
@@ -139,24 +222,140 @@ type SyncWriteStream interface {
 	io.Closer
 }
 
+// UserDataHolder is implemented by objects to which applications can attach their own state, such as the session of
+// a connection, instead of maintaining a map keyed by the object's pointer.
+type UserDataHolder interface {
+	// SetUserData attaches the provided value. It replaces any previously attached value.
+	SetUserData(data any)
+
+	// UserData returns the value attached with SetUserData, or nil if none is attached.
+	UserData() any
+}
+
+// UserDataOf returns the value attached to h with SetUserData as a T. false is returned if no value is attached or if
+// the value is not a T.
+func UserDataOf[T any](h UserDataHolder) (data T, ok bool) {
+	data, ok = h.UserData().(T)
+	return
+}
+
+// ShutdownState tells which sides of a connection are shut down, see Conn.ShutdownRead and Conn.ShutdownWrite.
+type ShutdownState uint8
+
+const (
+	ShutNone  ShutdownState = 0
+	ShutRead  ShutdownState = 1 << 0
+	ShutWrite ShutdownState = 1 << 1
+	ShutBoth                = ShutRead | ShutWrite
+)
+
+func (s ShutdownState) String() string {
+	switch s {
+	case ShutNone:
+		return "none"
+	case ShutRead:
+		return "read"
+	case ShutWrite:
+		return "write"
+	case ShutBoth:
+		return "both"
+	default:
+		return fmt.Sprintf("shutdown(%d)", uint8(s))
+	}
+}
+
 // Conn is a generic stream-oriented network connection.
+//
+// Asynchronous writes are serialized: a write issued while another one is in progress is queued and started once
+// all writes issued before it complete, so writes can be issued from several handlers without their bytes being
+// interleaved. Cancel completes the queued writes with sonicerrors.ErrCancelled.
+//
+// The synchronous and asynchronous operations complete alike once a side of the connection is shut down or the
+// connection is closed:
+//   - after ShutdownRead, reads and peeks fail with io.EOF. Writes are not affected.
+//   - after ShutdownWrite, writes fail with syscall.EPIPE. Reads are not affected, so the bytes the peer sends until
+//     it shuts down its own side are read, after which reads fail with io.EOF.
+//   - after Close, reads and writes fail with io.EOF. The asynchronous operations pending at the time of the Close,
+//     the queued writes included, complete with sonicerrors.ErrCancelled before Close returns.
 type Conn interface {
 	FileDescriptor
 	net.Conn
+	UserDataHolder
+	AsyncVectoredWriter
+	VectoredWriter
+	AsyncVectoredReader
+	VectoredReader
+	AsyncContextReadWriter
+	AsyncCancellableReadWriter
+
+	// ShutdownRead shuts down the reading side of the connection. Pending and subsequent reads complete with io.EOF.
+	ShutdownRead() error
+
+	// ShutdownWrite shuts down the writing side of the connection, which sends a FIN to the peer once all the bytes
+	// already handed to the kernel are sent. Pending writes complete with sonicerrors.ErrCancelled and subsequent
+	// writes fail with syscall.EPIPE. The peer can keep writing, so reads are not affected.
+	ShutdownWrite() error
+
+	// ShutdownState returns the sides of the connection shut down with ShutdownRead and ShutdownWrite.
+	ShutdownState() ShutdownState
+
+	// Peek reads bytes from the connection without consuming them: the next read returns the same bytes.
+	Peek(b []byte) (n int, err error)
+
+	// AsyncPeek is the asynchronous version of Peek. The callback is invoked once at least one byte can be peeked.
+	AsyncPeek(b []byte, cb AsyncCallback)
+
+	// AsyncForwardTo forwards n bytes read from the connection to dst, or all of them until the peer closes the
+	// connection if n is negative, for proxies which do not look at the bytes they relay. On Linux, the bytes are
+	// spliced into dst without being copied to user space if dst is a Conn or a File of this package.
+	AsyncForwardTo(dst AsyncWriteStream, n int, cb AsyncCallback)
+
+	// AsyncSendFile writes n bytes of src, starting at offset off, to the connection, or all of them until the end of
+	// src if n is negative, for servers of static content and large files. The bytes are sent with sendfile(2)
+	// without being copied to user space where the platform supports it, and through a buffer otherwise.
+	AsyncSendFile(src *os.File, off int64, n int, cb AsyncCallback)
+
+	// SetExecutionBudget bounds the work the connection does back-to-back without yielding to the IO loop, such that
+	// a connection with an endless stream of ready data cannot monopolize the loop. After maxOps asynchronous reads
+	// and writes completed right away, or after maxTime, the next one is posted to the IO, behind the handlers of the
+	// other connections. 0 means no bound, which is the default.
+	SetExecutionBudget(maxOps int, maxTime time.Duration)
+
+	// SetEdgeTriggered registers the connection with the IO once, in edge-triggered mode, for connections with
+	// continuous read interest such as market data feeds. By default, every read and write which would block arms a
+	// one-shot registration, which costs a syscall per operation on Linux. A persistent registration costs none, as
+	// the IO is notified each time the connection becomes ready. It fails if an asynchronous read or write is waiting
+	// for the connection.
+	SetEdgeTriggered(enabled bool) error
+
+	// MemoryAccount returns the memory account of the connection, under the memory account of the IO. It holds the
+	// bytes of the asynchronous writes queued behind the one in progress, and those of the accounts created under it,
+	// such as the one of a codec connection over this connection. It is released once the connection is closed.
+	MemoryAccount() *MemoryAccount
 }
 
 type AsyncReadCallbackPacket func(error, int, net.Addr)
 type AsyncWriteCallbackPacket func(error)
 
-// PacketConn is a generic packet-oriented connection.
+// PacketConn is a generic packet-oriented connection, such as a UDP socket, whose asynchronous operations run on the
+// IO like those of a Conn, so datagram protocols do not need goroutines reading from a net.UDPConn.
 type PacketConn interface {
+	// ReadFrom and AsyncReadFrom read a datagram into the provided buffer, and report the address it was sent from,
+	// a *net.UDPAddr for UDP sockets. A datagram bigger than the buffer is truncated, the truncated bytes being lost.
+	// Empty datagrams are read like any other, so a read of 0 bytes does not mean io.EOF.
 	ReadFrom([]byte) (n int, addr net.Addr, err error)
 	AsyncReadFrom([]byte, AsyncReadCallbackPacket)
 	AsyncReadAllFrom([]byte, AsyncReadCallbackPacket)
 
+	// WriteTo and AsyncWriteTo send the provided buffer as a single datagram to addr.
 	WriteTo([]byte, net.Addr) error
 	AsyncWriteTo([]byte, net.Addr, AsyncWriteCallbackPacket)
 
+	// Cancel completes the pending asynchronous read and write with sonicerrors.ErrCancelled.
+	Cancel()
+
+	// Close closes the connection. The pending asynchronous operations are not completed, and the operations issued
+	// afterwards fail with io.EOF.
 	Close() error
 	Closed() bool
 
@@ -170,6 +369,10 @@ type Listener interface {
 	Accept() (Conn, error)
 
 	// AsyncAccept waits for and returns the next connection to the listener asynchronously.
+	//
+	// At most one AsyncAccept can be pending: calling AsyncAccept while a previous one waits for a connection, or is
+	// paused, see SetAcceptRateLimit and SetAcceptRetryDelay, fails with sonicerrors.ErrAcceptPending. The next
+	// AsyncAccept is usually issued from the callback of the previous one.
 	AsyncAccept(AcceptCallback)
 
 	// Close closes the listener.
@@ -178,9 +381,61 @@ type Listener interface {
 	// Addr returns the listener's network address.
 	Addr() net.Addr
 
+	// Stats returns the accept metrics of the listener.
+	Stats() ListenerStats
+
+	// SetAcceptRateLimit limits the rate at which AsyncAccept accepts connections to rate connections per second,
+	// with bursts of at most burst connections. Connections which arrive faster stay in the kernel's accept queue,
+	// such that a reconnect storm cannot starve the handlers of the established connections. A rate of 0 or less
+	// removes the limit.
+	//
+	// The limit does not apply to Accept, and the connections accepted by Accept do not count against it.
+	SetAcceptRateLimit(rate float64, burst int)
+
+	// SetAcceptRetryDelay sets how AsyncAccept pauses when an accept fails because the process or the system ran out
+	// of file descriptors or memory. Instead of handing the error to the callback, AsyncAccept pauses for min, then
+	// retries, doubling the pause up to max while the accepts keep failing. The connection stays in the kernel's
+	// accept queue meanwhile, and the accepts resume once resources are freed, for example by closing connections.
+	// The defaults are DefaultAcceptRetryMinDelay and DefaultAcceptRetryMaxDelay. A min of 0 or less disables the
+	// pauses, such that the errors are handed to the callback.
+	SetAcceptRetryDelay(min, max time.Duration)
+
 	RawFd() int
 }
 
+// ListenerStats are the accept metrics of a Listener.
+type ListenerStats struct {
+	// Accepted is the number of accepted connections.
+	Accepted uint64
+
+	// AcceptErrors is the number of failed accepts. Accepts which would block are not counted.
+	AcceptErrors uint64
+
+	// Throttled is the number of times AsyncAccept deferred an accept because of the rate limit.
+	Throttled uint64
+
+	// AcceptPauses is the number of times AsyncAccept paused because the process or the system ran out of file
+	// descriptors or memory, see Listener.SetAcceptRetryDelay. These failed accepts are counted in AcceptErrors too.
+	AcceptPauses uint64
+
+	// AcceptRate is the number of connections accepted in the last complete second.
+	AcceptRate uint64
+
+	// Backlog is the number of connections waiting to be accepted and BacklogCapacity is the size of the kernel's
+	// accept queue. Connections which arrive while the queue is full are dropped by the kernel, so a Backlog close to
+	// BacklogCapacity means the listener does not accept fast enough. Both are -1 if they cannot be queried, which is
+	// the case on all platforms but linux, and for non-TCP listeners.
+	Backlog         int
+	BacklogCapacity int
+
+	// HostListenOverflows is the number of connections the kernel dropped because the accept queue of any listener of
+	// the host, more precisely of its network namespace, was full. It is not specific to this listener: the kernel
+	// does not count the overflows per listener. A HostListenOverflows which grows while Backlog is close to
+	// BacklogCapacity points to this listener. It is -1 if it cannot be queried, which is the case on all platforms
+	// but linux.
+	HostListenOverflows int64
+}
+
 // UDPMulticastClient defines a UDP multicast client that can read data from one or multiple multicast groups,
 // optionally filtering packets on the source IP.
 type UDPMulticastClient interface {
@@ -0,0 +1,131 @@
+package sonic
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+	"github.com/csdenboer/sonic/sonicopts"
+)
+
+// DialSourcesStats counts the dials of a DialSources.
+type DialSourcesStats struct {
+	// Dials is the number of dials started.
+	Dials uint64
+
+	// Exhausted is the number of times a source IP had no ephemeral port left to connect from.
+	Exhausted uint64
+
+	// Rotations is the number of dials retried from the next source IP, because the previous one was exhausted.
+	Rotations uint64
+
+	// Failed is the number of dials which failed because all the source IPs were exhausted.
+	Failed uint64
+}
+
+// DialSources spreads the connections of a client over several local source IPs, for clients maintaining tens of
+// thousands of outbound connections. The ephemeral ports of the host are a per source IP resource, so a client dialing
+// from a single IP cannot have more connections to a destination than there are ports in its ephemeral range.
+//
+// Each dial is bound to the next source IP, in turn. On Linux, the socket is also bound with
+// sonicopts.BindAddressNoPort, such that the ephemeral port is only picked when connecting. The same port can then be
+// shared by connections to different destinations, instead of being reserved by the bind.
+//
+// A dial which fails with sonicerrors.ErrPortsExhausted is retried from the next source IP, until all of them are
+// tried. Stats tells how often the sources are exhausted: a client seeing it grow should reuse its connections, or
+// dial from more source IPs.
+//
+// A DialSources must only be used from the goroutine running the IO it dials on.
+type DialSources struct {
+	ips   []net.IP
+	next  int
+	stats DialSourcesStats
+
+	// dial starts a dial, which is AsyncDial unless replaced in tests.
+	dial func(ioc *IO, network, addr string, cb AcceptCallback, opts ...sonicopts.Option)
+}
+
+// NewDialSources creates a DialSources dialing from the given local IPs, which must be assigned to the host.
+func NewDialSources(ips ...net.IP) (*DialSources, error) {
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no source IP to dial from")
+	}
+	return &DialSources{
+		ips:  append([]net.IP(nil), ips...),
+		dial: AsyncDial,
+	}, nil
+}
+
+// AsyncDial dials addr, see AsyncDial, from the next source IP. opts must not bind the socket, as DialSources does.
+func (s *DialSources) AsyncDial(ioc *IO, network, addr string, cb AcceptCallback, opts ...sonicopts.Option) {
+	s.stats.Dials++
+	s.asyncDial(ioc, network, addr, 0, cb, opts)
+}
+
+func (s *DialSources) asyncDial(
+	ioc *IO,
+	network, addr string,
+	tried int,
+	cb AcceptCallback,
+	opts []sonicopts.Option,
+) {
+	ip := s.ips[s.next]
+	s.next = (s.next + 1) % len(s.ips)
+
+	s.dial(ioc, network, addr, func(err error, conn Conn) {
+		if err == nil || !errors.Is(err, sonicerrors.ErrPortsExhausted) {
+			cb(err, conn)
+			return
+		}
+
+		s.stats.Exhausted++
+		if tried++; tried == len(s.ips) {
+			s.stats.Failed++
+			cb(err, nil)
+			return
+		}
+		s.stats.Rotations++
+		s.asyncDial(ioc, network, addr, tried, cb, opts)
+	}, s.sourceOpts(ip, opts)...)
+}
+
+// sourceOpts returns opts followed by the options binding a socket to ip.
+func (s *DialSources) sourceOpts(ip net.IP, opts []sonicopts.Option) []sonicopts.Option {
+	all := make([]sonicopts.Option, 0, len(opts)+2)
+	all = append(all, opts...)
+	all = append(all, sonicopts.BindSocket(&net.TCPAddr{IP: ip}))
+	if bindNoPort {
+		all = append(all, sonicopts.BindAddressNoPort(true))
+	}
+	return all
+}
+
+// Dial is the synchronous counterpart of AsyncDial: it blocks the calling goroutine, which must be the one running the
+// IO, until the dial completes. The IO is run in the meantime.
+//
+// sonicerrors.ErrReentrantWait is returned, without dialing, if Dial is called from a handler of the IO.
+func (s *DialSources) Dial(ioc *IO, network, addr string, opts ...sonicopts.Option) (Conn, error) {
+	if ioc.Dispatching() {
+		return nil, sonicerrors.ErrReentrantWait
+	}
+
+	var (
+		done bool
+		conn Conn
+		err  error
+	)
+	s.AsyncDial(ioc, network, addr, func(dialErr error, c Conn) {
+		done, err, conn = true, dialErr, c
+	}, opts...)
+
+	if runErr := ioc.RunUntil(func() bool { return done }); runErr != nil {
+		return nil, runErr
+	}
+	return conn, err
+}
+
+// Stats returns the counters of the dials.
+func (s *DialSources) Stats() DialSourcesStats {
+	return s.stats
+}
@@ -0,0 +1,93 @@
+package sonic
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+	"github.com/csdenboer/sonic/sonicopts"
+)
+
+func TestDialSources(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	s, err := NewDialSources(net.IPv4(127, 0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		conn, err := s.Dial(ioc, "tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ip := conn.LocalAddr().(*net.TCPAddr).IP; !ip.Equal(net.IPv4(127, 0, 0, 1)) {
+			t.Fatalf("expected to dial from 127.0.0.1 got=%s", ip)
+		}
+		_ = conn.Close()
+	}
+	if stats := s.Stats(); stats.Dials != 2 || stats.Exhausted != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestDialSourcesRotation(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	a, b := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+	s, err := NewDialSources(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a has no port left, b has some unless full is true.
+	full := false
+	var sources []net.IP
+	s.dial = func(ioc *IO, network, addr string, cb AcceptCallback, opts ...sonicopts.Option) {
+		var ip net.IP
+		for _, opt := range opts {
+			if opt.Type() == sonicopts.TypeBindSocket {
+				ip = opt.Value().(*net.TCPAddr).IP
+			}
+		}
+		sources = append(sources, ip)
+		if ip.Equal(a) || full {
+			cb(fmt.Errorf("%w: %w", sonicerrors.ErrPortsExhausted, syscall.EADDRNOTAVAIL), nil)
+		} else {
+			cb(nil, nil)
+		}
+	}
+
+	// The first dial is from a, which is exhausted, so it is retried from b.
+	s.AsyncDial(ioc, "tcp", "localhost:1", func(err error, _ Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if len(sources) != 2 || !sources[0].Equal(a) || !sources[1].Equal(b) {
+		t.Fatalf("expected to dial from a then b got=%v", sources)
+	}
+
+	full = true
+	s.AsyncDial(ioc, "tcp", "localhost:1", func(err error, _ Conn) {
+		if !errors.Is(err, sonicerrors.ErrPortsExhausted) {
+			t.Fatalf("expected ErrPortsExhausted got=%v", err)
+		}
+	})
+
+	stats := s.Stats()
+	if stats.Dials != 2 || stats.Exhausted != 3 || stats.Rotations != 2 || stats.Failed != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
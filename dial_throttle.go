@@ -0,0 +1,188 @@
+package sonic
+
+import (
+	"math/rand" //#nosec G404 -- jitter does not need a cryptographically secure source
+	"time"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+	"github.com/csdenboer/sonic/sonicopts"
+)
+
+type pendingDial struct {
+	network, addr string
+	opts          []sonicopts.Option
+	cb            AcceptCallback
+}
+
+// DialThrottle paces the connects of a client with many upstreams, such that reconnecting to all of them after a
+// network outage does not flood the network or exhaust the local ephemeral ports.
+//
+// It bounds the number of connects in progress and the rate at which connects start. Dials beyond those bounds are
+// queued and started in order. Each start is delayed by a random jitter, such that clients restarted at the same time
+// do not connect in lockstep.
+//
+// A DialThrottle is meant to be shared by all the dialers of an IO. It must only be used from the goroutine running
+// the IO.
+type DialThrottle struct {
+	ioc  *IO
+	rand *rand.Rand
+
+	maxInflight int
+	inflight    int
+	rate        rateThrottle
+	jitter      time.Duration
+	timeout     time.Duration
+
+	queue     []pendingDial
+	scheduled bool
+}
+
+// NewDialThrottle creates a DialThrottle allowing at most maxInflight connects in progress, 0 meaning no bound, and
+// starting at most rate connects per second with bursts of burst connects, a rate of 0 meaning no bound.
+func NewDialThrottle(ioc *IO, maxInflight int, rate float64, burst int) (*DialThrottle, error) {
+	if burst < 1 {
+		burst = 1
+	}
+
+	timer, err := NewTimer(ioc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DialThrottle{
+		ioc: ioc,
+		/* #nosec G404 -- jitter does not need a cryptographically secure source */
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		maxInflight: maxInflight,
+		rate: rateThrottle{
+			rate:   rate,
+			burst:  float64(burst),
+			tokens: float64(burst),
+			last:   time.Now(),
+			timer:  timer,
+		},
+	}, nil
+}
+
+// SetJitter sets the maximum random delay added before each connect. 0, the default, disables the jitter.
+func (t *DialThrottle) SetJitter(jitter time.Duration) {
+	if jitter < 0 {
+		jitter = 0
+	}
+	t.jitter = jitter
+}
+
+// SetConnectTimeout bounds the time each dial takes once started, see AsyncDialTimeout. A dial which times out
+// completes with sonicerrors.ErrTimeout and frees its slot for the queued dials. 0, the default, does not bound the
+// dials.
+func (t *DialThrottle) SetConnectTimeout(timeout time.Duration) {
+	if timeout < 0 {
+		timeout = 0
+	}
+	t.timeout = timeout
+}
+
+// AsyncDial queues a dial, see AsyncDial, which is started once the bounds of the throttle allow it.
+func (t *DialThrottle) AsyncDial(network, addr string, cb AcceptCallback, opts ...sonicopts.Option) {
+	t.queue = append(t.queue, pendingDial{network: network, addr: addr, opts: opts, cb: cb})
+	t.dispatch()
+}
+
+// Dial is the synchronous counterpart of AsyncDial: it blocks the calling goroutine, which must be the one running the
+// IO, until the queued dial completes. The IO is run in the meantime, so the dials queued before this one, and the
+// handlers of other operations, are executed while waiting.
+//
+// sonicerrors.ErrReentrantWait is returned, without dialing, if Dial is called from a handler of the IO.
+func (t *DialThrottle) Dial(network, addr string, opts ...sonicopts.Option) (Conn, error) {
+	if t.ioc.Dispatching() {
+		return nil, sonicerrors.ErrReentrantWait
+	}
+
+	var (
+		done bool
+		conn Conn
+		err  error
+	)
+	t.AsyncDial(network, addr, func(dialErr error, c Conn) {
+		done, err, conn = true, dialErr, c
+	}, opts...)
+
+	if runErr := t.ioc.RunUntil(func() bool { return done }); runErr != nil {
+		return nil, runErr
+	}
+	return conn, err
+}
+
+// Inflight returns the number of connects in progress.
+func (t *DialThrottle) Inflight() int {
+	return t.inflight
+}
+
+// Queued returns the number of dials waiting for the bounds of the throttle to allow them.
+func (t *DialThrottle) Queued() int {
+	return len(t.queue)
+}
+
+func (t *DialThrottle) dispatch() {
+	for len(t.queue) > 0 && !t.scheduled {
+		if t.maxInflight > 0 && t.inflight >= t.maxInflight {
+			return
+		}
+
+		wait := t.rate.wait(time.Now())
+		if wait == 0 && t.jitter > 0 {
+			wait = time.Duration(t.rand.Int63n(int64(t.jitter) + 1))
+		}
+		if wait > 0 {
+			t.scheduled = true
+			if err := t.rate.timer.ScheduleOnce(wait, t.onTimer); err != nil {
+				t.scheduled = false
+				t.failQueue(err)
+			}
+			return
+		}
+
+		t.start()
+	}
+}
+
+func (t *DialThrottle) onTimer() {
+	t.scheduled = false
+
+	// The jitter, if any, elapsed, so start the next dial if the rate allows it.
+	if len(t.queue) > 0 && (t.maxInflight == 0 || t.inflight < t.maxInflight) && t.rate.wait(time.Now()) == 0 {
+		t.start()
+	}
+	t.dispatch()
+}
+
+func (t *DialThrottle) start() {
+	d := t.queue[0]
+	t.queue[0] = pendingDial{}
+	t.queue = t.queue[1:]
+
+	if t.rate.rate > 0 {
+		t.rate.tokens--
+	}
+	t.inflight++
+
+	AsyncDialTimeout(t.ioc, d.network, d.addr, t.timeout, func(err error, conn Conn) {
+		t.inflight--
+		d.cb(err, conn)
+		t.dispatch()
+	}, d.opts...)
+}
+
+func (t *DialThrottle) failQueue(err error) {
+	queue := t.queue
+	t.queue = nil
+	for _, d := range queue {
+		d.cb(err, nil)
+	}
+}
+
+// Close closes the throttle. Queued dials are never started.
+func (t *DialThrottle) Close() error {
+	t.queue = nil
+	return t.rate.timer.Close()
+}
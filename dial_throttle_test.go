@@ -0,0 +1,275 @@
+package sonic
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+func TestAsyncDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	var (
+		conn Conn
+		done bool
+	)
+	AsyncDial(ioc, "tcp", addr, func(err error, c Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, done = c, true
+	})
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline) && !done; {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if conn == nil || conn.LocalAddr() == nil {
+		t.Fatal("expected a connection")
+	}
+	conn.Close()
+
+	// Refused once nobody listens.
+	ln.Close()
+	var dialErr error
+	done = false
+	AsyncDial(ioc, "tcp", addr, func(err error, _ Conn) {
+		dialErr, done = err, true
+	})
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline) && !done; {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if dialErr == nil {
+		t.Fatal("expected the dial to be refused")
+	}
+}
+
+func TestDialThrottle(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	throttle, err := NewDialThrottle(ioc, 2, 200, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer throttle.Close()
+	throttle.SetJitter(time.Millisecond)
+
+	const n = 10
+
+	var (
+		conns       []Conn
+		maxInflight int
+	)
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		throttle.AsyncDial("tcp", ln.Addr().String(), func(err error, conn Conn) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			conns = append(conns, conn)
+		})
+		if throttle.Inflight() > maxInflight {
+			maxInflight = throttle.Inflight()
+		}
+	}
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline) && len(conns) < n; {
+		_ = ioc.RunOneFor(time.Millisecond)
+		if throttle.Inflight() > maxInflight {
+			maxInflight = throttle.Inflight()
+		}
+	}
+
+	if len(conns) != n {
+		t.Fatalf("expected %d connections got=%d", n, len(conns))
+	}
+	if maxInflight > 2 {
+		t.Fatalf("expected at most 2 connects in progress got=%d", maxInflight)
+	}
+	// 200 connects per second with a burst of 1 spaces the connects by 5ms.
+	if elapsed := time.Since(start); elapsed < (n-1)*5*time.Millisecond {
+		t.Fatalf("connects were not paced, took %s", elapsed)
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+func TestDialThrottleDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	throttle, err := NewDialThrottle(ioc, 1, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer throttle.Close()
+
+	// The dial queued first completes while the blocking dial waits for its turn.
+	var queued Conn
+	throttle.AsyncDial("tcp", ln.Addr().String(), func(err error, conn Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		queued = conn
+	})
+
+	conn, err := throttle.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if queued == nil {
+		t.Fatal("expected the queued dial to complete first")
+	}
+	queued.Close()
+	if throttle.Inflight() != 0 || throttle.Queued() != 0 {
+		t.Fatalf("expected no dials in progress got inflight=%d queued=%d", throttle.Inflight(), throttle.Queued())
+	}
+}
+
+func TestAsyncDialResolve(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	addr := net.JoinHostPort("localhost", strconv.Itoa(ln.Addr().(*net.TCPAddr).Port))
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	var (
+		conn    Conn
+		dialErr error
+		done    bool
+	)
+	AsyncDial(ioc, "tcp", addr, func(err error, c Conn) {
+		conn, dialErr, done = c, err, true
+	})
+	if done {
+		t.Fatal("expected the name to be resolved off the IO")
+	}
+	if err := ioc.RunUntil(func() bool { return done }); err != nil {
+		t.Fatal(err)
+	}
+	if dialErr != nil {
+		t.Fatal(dialErr)
+	}
+	conn.Close()
+}
+
+// fullListener returns the address of a listener whose accept queue is full, such that the kernel drops the SYNs of
+// the next connects, which then stay in progress.
+func fullListener(t *testing.T) string {
+	if runtime.GOOS != "linux" {
+		t.Skip("relies on linux dropping the SYNs once the accept queue is full")
+	}
+
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { syscall.Close(fd) })
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Listen(fd, 0); err != nil {
+		t.Fatal(err)
+	}
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := fmt.Sprintf("127.0.0.1:%d", sa.(*syscall.SockaddrInet4).Port)
+
+	for i := 0; i < 8; i++ {
+		conn, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if err != nil {
+			return addr
+		}
+		t.Cleanup(func() { conn.Close() })
+	}
+	t.Fatal("could not fill the accept queue")
+	return ""
+}
+
+func TestAsyncDialTimeout(t *testing.T) {
+	addr := fullListener(t)
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	var (
+		dialErr error
+		done    bool
+	)
+	AsyncDialTimeout(ioc, "tcp", addr, 50*time.Millisecond, func(err error, _ Conn) {
+		dialErr, done = err, true
+	})
+	if err := ioc.RunUntil(func() bool { return done }); err != nil {
+		t.Fatal(err)
+	}
+	if dialErr != sonicerrors.ErrTimeout {
+		t.Fatalf("expected ErrTimeout got=%v", dialErr)
+	}
+}
+
+func TestDialThrottleConnectTimeout(t *testing.T) {
+	addr := fullListener(t)
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	throttle, err := NewDialThrottle(ioc, 1, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer throttle.Close()
+	throttle.SetConnectTimeout(50 * time.Millisecond)
+
+	var errs []error
+	for i := 0; i < 2; i++ {
+		throttle.AsyncDial("tcp", addr, func(err error, _ Conn) {
+			errs = append(errs, err)
+		})
+	}
+	if err := ioc.RunUntil(func() bool { return len(errs) == 2 }); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second dial only started once the first one timed out and freed the slot.
+	for _, err := range errs {
+		if err != sonicerrors.ErrTimeout {
+			t.Fatalf("expected ErrTimeout got=%v", err)
+		}
+	}
+	if throttle.Inflight() != 0 || throttle.Queued() != 0 {
+		t.Fatalf("expected no dial left got inflight=%d queued=%d", throttle.Inflight(), throttle.Queued())
+	}
+}
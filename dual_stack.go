@@ -0,0 +1,195 @@
+package sonic
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/csdenboer/sonic/internal"
+	"github.com/csdenboer/sonic/sonicopts"
+)
+
+// Family is the address family of an endpoint of a DualStackListener.
+type Family uint8
+
+const (
+	FamilyIPv4 Family = iota
+	FamilyIPv6
+	FamilyUnix
+
+	numFamilies
+)
+
+func (f Family) String() string {
+	switch f {
+	case FamilyIPv4:
+		return "ipv4"
+	case FamilyIPv6:
+		return "ipv6"
+	case FamilyUnix:
+		return "unix"
+	default:
+		return fmt.Sprintf("family(%d)", uint8(f))
+	}
+}
+
+// DualStackConfig configures the endpoints of a DualStackListener. An endpoint with an empty address is not bound.
+type DualStackConfig struct {
+	// IPv4 and IPv6 are the IP addresses of the IPv4 and IPv6 endpoints, such as "0.0.0.0" and "::".
+	IPv4, IPv6 string
+
+	// Port is the port of both IP endpoints. If it is 0, the IPv4 endpoint is bound to an ephemeral port, to which
+	// the IPv6 endpoint is then bound as well.
+	Port int
+
+	// UnixPath is the path of the Unix domain endpoint.
+	UnixPath string
+}
+
+// DualStackListener listens for the connections to the same service on an IPv4, an IPv6 and a Unix domain endpoint,
+// each of which is optional. The connections of each endpoint are handed to the callback of its family, and the
+// accept metrics are kept per family.
+//
+// A DualStackListener must only be used from the goroutine running the IO.
+type DualStackListener struct {
+	listeners [numFamilies]Listener
+	callbacks [numFamilies]AcceptCallback
+	accept    [numFamilies]AcceptCallback
+	accepting [numFamilies]bool
+	stopped   bool
+}
+
+// ListenDualStack binds the endpoints of cfg, with the given options, in one call. Either all endpoints are bound or
+// none is. The endpoints are nonblocking, see Start.
+func ListenDualStack(ioc *IO, cfg DualStackConfig, opts ...sonicopts.Option) (*DualStackListener, error) {
+	if cfg.IPv4 == "" && cfg.IPv6 == "" && cfg.UnixPath == "" {
+		return nil, fmt.Errorf("no endpoint to listen on")
+	}
+
+	opts = append(opts[:len(opts):len(opts)], sonicopts.Nonblocking(true))
+	l := &DualStackListener{}
+
+	port := cfg.Port
+	listen := func(f Family, network, addr string) error {
+		ln, err := Listen(ioc, network, addr, opts...)
+		if err != nil {
+			return fmt.Errorf("could not listen on %s endpoint %s: %w", f, addr, err)
+		}
+		l.listeners[f] = ln
+		return nil
+	}
+
+	var err error
+	if cfg.IPv4 != "" {
+		if err = listen(FamilyIPv4, "tcp4", net.JoinHostPort(cfg.IPv4, strconv.Itoa(port))); err == nil && port == 0 {
+			port = l.Addr(FamilyIPv4).(*net.TCPAddr).Port
+		}
+	}
+	if err == nil && cfg.IPv6 != "" {
+		err = listen(FamilyIPv6, "tcp6", net.JoinHostPort(cfg.IPv6, strconv.Itoa(port)))
+	}
+	if err == nil && cfg.UnixPath != "" {
+		err = listen(FamilyUnix, "unix", cfg.UnixPath)
+	}
+	if err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+
+	for f := range l.accept {
+		f := Family(f)
+		l.accept[f] = func(err error, conn Conn) { l.onAccept(f, err, conn) }
+	}
+
+	return l, nil
+}
+
+// Listener returns the listener of the endpoint of the given family, or nil if it is not bound.
+func (l *DualStackListener) Listener(f Family) Listener {
+	if f >= numFamilies {
+		return nil
+	}
+	return l.listeners[f]
+}
+
+// Addr returns the local address of the endpoint of the given family, with the port it is bound to, or nil if it is
+// not bound.
+func (l *DualStackListener) Addr(f Family) net.Addr {
+	ln := l.Listener(f)
+	if ln == nil {
+		return nil
+	}
+	if addr, err := internal.SocketAddress(ln.RawFd()); err == nil && addr != nil {
+		return addr
+	}
+	return ln.Addr()
+}
+
+// Stats returns the accept metrics of the endpoint of the given family. They are zero if it is not bound.
+func (l *DualStackListener) Stats(f Family) ListenerStats {
+	ln := l.Listener(f)
+	if ln == nil {
+		return ListenerStats{}
+	}
+	return ln.Stats()
+}
+
+// SetAcceptCallback sets the callback invoked with the connections accepted, and the accept errors, on the endpoint of
+// the given family.
+func (l *DualStackListener) SetAcceptCallback(f Family, cb AcceptCallback) {
+	if f < numFamilies {
+		l.callbacks[f] = cb
+	}
+}
+
+// Start accepts connections on all bound endpoints. Every bound endpoint must have an accept callback. An endpoint
+// stops accepting after an accept error, which is handed to its callback, until Start is called again.
+func (l *DualStackListener) Start() error {
+	for f, ln := range l.listeners {
+		if ln != nil && l.callbacks[f] == nil {
+			return fmt.Errorf("no accept callback for the %s endpoint", Family(f))
+		}
+	}
+
+	l.stopped = false
+	for f, ln := range l.listeners {
+		if ln != nil && !l.accepting[f] {
+			l.accepting[f] = true
+			ln.AsyncAccept(l.accept[f])
+		}
+	}
+	return nil
+}
+
+// Stop stops accepting connections once the pending accepts complete.
+func (l *DualStackListener) Stop() {
+	l.stopped = true
+}
+
+func (l *DualStackListener) onAccept(f Family, err error, conn Conn) {
+	if err != nil {
+		l.accepting[f] = false
+		l.callbacks[f](err, nil)
+		return
+	}
+
+	l.callbacks[f](nil, conn)
+	if l.stopped || l.listeners[f] == nil {
+		l.accepting[f] = false
+	} else {
+		l.listeners[f].AsyncAccept(l.accept[f])
+	}
+}
+
+// Close closes the listeners of all endpoints.
+func (l *DualStackListener) Close() error {
+	var errs []error
+	for f, ln := range l.listeners {
+		if ln != nil {
+			errs = append(errs, ln.Close())
+			l.listeners[f] = nil
+		}
+	}
+	return errors.Join(errs...)
+}
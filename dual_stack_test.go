@@ -0,0 +1,90 @@
+package sonic
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic/internal"
+)
+
+func TestDualStackListener(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := ListenDualStack(ioc, DualStackConfig{
+		IPv4:     "127.0.0.1",
+		IPv6:     "::1",
+		UnixPath: filepath.Join(t.TempDir(), "sonic.sock"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	v4, v6 := ln.Addr(FamilyIPv4).(*net.TCPAddr), ln.Addr(FamilyIPv6).(*net.TCPAddr)
+	if v4.Port == 0 || v4.Port != v6.Port {
+		t.Fatalf("expected both IP endpoints on the same port got=%s %s", v4, v6)
+	}
+	if internal.IsIPv6(v4.IP) || !internal.IsIPv6(v6.IP) {
+		t.Fatalf("wrong endpoint families got=%s %s", v4, v6)
+	}
+
+	if err := ln.Start(); err == nil {
+		t.Fatal("expected Start to fail without accept callbacks")
+	}
+
+	var accepted [numFamilies]int
+	var remote [numFamilies]net.Addr
+	for f := Family(0); f < numFamilies; f++ {
+		f := f
+		ln.SetAcceptCallback(f, func(err error, conn Conn) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			accepted[f]++
+			remote[f] = conn.RemoteAddr()
+			conn.Close()
+		})
+	}
+	if err := ln.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	dials := []struct {
+		network string
+		addr    string
+	}{
+		{"tcp4", v4.String()},
+		{"tcp4", v4.String()},
+		{"tcp6", v6.String()},
+		{"unix", ln.Addr(FamilyUnix).String()},
+	}
+	for _, d := range dials {
+		go func(network, addr string) {
+			conn, err := net.Dial(network, addr)
+			if err == nil {
+				time.Sleep(100 * time.Millisecond)
+				conn.Close()
+			}
+		}(d.network, d.addr)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && accepted[FamilyIPv4]+accepted[FamilyIPv6]+accepted[FamilyUnix] < len(dials) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+
+	if accepted != [numFamilies]int{2, 1, 1} {
+		t.Fatalf("expected 2 ipv4, 1 ipv6 and 1 unix connections got=%v", accepted)
+	}
+	if addr, ok := remote[FamilyIPv6].(*net.TCPAddr); !ok || !internal.IsIPv6(addr.IP) {
+		t.Fatalf("expected an IPv6 peer got=%v", remote[FamilyIPv6])
+	}
+	for f, n := range accepted {
+		if stats := ln.Stats(Family(f)); stats.Accepted != uint64(n) {
+			t.Fatalf("expected %d accepted connections on the %s endpoint got=%d", n, Family(f), stats.Accepted)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+
+	"github.com/csdenboer/sonic"
+	"github.com/csdenboer/sonic/sonicopts"
+)
+
+var addr = flag.String("addr", ":8080", "address to listen on")
+
+// session echoes everything it reads back to the peer. Once the peer half-closes the connection, the session flushes
+// what it has left, half-closes its side as well and closes the connection.
+type session struct {
+	conn sonic.Conn
+	b    []byte
+}
+
+func (s *session) start() {
+	s.conn.AsyncRead(s.b[:cap(s.b)], s.onRead)
+}
+
+func (s *session) onRead(err error, n int) {
+	if err == io.EOF {
+		if err := s.conn.ShutdownWrite(); err != nil {
+			log.Printf("could not shutdown err=%v", err)
+		}
+		_ = s.conn.Close()
+		return
+	}
+	if err != nil {
+		log.Printf("could not read err=%v", err)
+		_ = s.conn.Close()
+		return
+	}
+
+	s.conn.AsyncWriteAll(s.b[:n], func(err error, _ int) {
+		if err != nil {
+			log.Printf("could not write err=%v", err)
+			_ = s.conn.Close()
+		} else {
+			s.start()
+		}
+	})
+}
+
+// serve starts an echo session for each connection accepted from ln.
+func serve(ln sonic.Listener) *sonic.Acceptor {
+	acceptor := sonic.NewAcceptor(ln, func(conn sonic.Conn) {
+		log.Printf("accepted connection from %s", conn.RemoteAddr())
+		(&session{conn: conn, b: make([]byte, 0, 4096)}).start()
+	}, func(err error) {
+		log.Printf("could not accept err=%v", err)
+	})
+	acceptor.Start()
+	return acceptor
+}
+
+func main() {
+	flag.Parse()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(ioc, "tcp", *addr, sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		panic(err)
+	}
+	defer ln.Close()
+
+	serve(ln)
+	log.Printf("listening on %s", ln.Addr())
+
+	ioc.Run()
+}
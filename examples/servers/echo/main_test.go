@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic"
+	"github.com/csdenboer/sonic/sonicopts"
+)
+
+func TestEcho(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(ioc, "tcp", "localhost:8097", sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	acceptor := serve(ln)
+	defer acceptor.Stop()
+
+	// The client echoes a message, then half-closes the connection and expects the server to do the same.
+	type result struct {
+		echoed string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := net.Dial("tcp", "localhost:8097")
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			done <- result{err: err}
+			return
+		}
+		b := make([]byte, 5)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			done <- result{err: err}
+			return
+		}
+		if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+			done <- result{err: err}
+			return
+		}
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			done <- result{err: err}
+			return
+		}
+		done <- result{echoed: string(b)}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		_ = ioc.RunOneFor(time.Millisecond)
+
+		select {
+		case res := <-done:
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			if res.echoed != "hello" {
+				t.Fatalf("expected hello got=%s", res.echoed)
+			}
+			return
+		default:
+		}
+	}
+	t.Fatal("timed out waiting for the echo")
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"log"
+	"net/netip"
+	"time"
+
+	"github.com/csdenboer/sonic"
+	"github.com/csdenboer/sonic/multicast"
+)
+
+var (
+	addr     = flag.String("addr", "224.0.1.0:5001", "multicast group to join")
+	iname    = flag.String("iname", "", "interface to join the group on, the default interface if empty")
+	every    = flag.Duration("every", time.Second, "interval at which the sequencing stats are printed")
+	maxSlots = flag.Int("max-slots", 1024, "maximum number of packets held while waiting for a missing one")
+	bufSize  = flag.Int("bufsize", 1024*1024, "maximum number of bytes held while waiting for a missing one")
+)
+
+// sequencer delivers packets in the order of the sequence number carried in their first 8 bytes, in big endian.
+// Packets which arrive ahead of a missing one are saved in a ByteBuffer and ordered by a SlotSequencer, until the
+// missing one arrives. A gap which does not fill before maxSlots later packets arrive, or before the saved packets
+// take bufSize bytes, is given up on: its packets are counted as lost and the saved packets are delivered.
+type sequencer struct {
+	b        *sonic.ByteBuffer
+	slots    *sonic.SlotSequencer
+	window   int
+	expected int
+
+	received   int
+	delivered  int
+	reordered  int
+	gaps       int
+	lost       int
+	duplicates int
+}
+
+func newSequencer(maxSlots, bufSize int) *sequencer {
+	b := sonic.NewByteBuffer()
+	_ = b.Reserve(bufSize)
+	return &sequencer{
+		b:      b,
+		slots:  sonic.NewSlotSequencer(maxSlots, bufSize),
+		window: maxSlots,
+	}
+}
+
+func (s *sequencer) on(seq int, packet []byte) {
+	s.received++
+	if s.expected == 0 {
+		s.expected = seq
+	}
+
+	if seq >= s.expected+s.window {
+		s.skipTo(seq)
+	}
+
+	switch {
+	case seq < s.expected:
+		// Either a duplicate or a packet of a gap which was given up on.
+		s.duplicates++
+	case seq == s.expected:
+		s.deliver(packet)
+		s.drain()
+	default:
+		_, _ = s.b.Write(packet)
+		s.b.Commit(len(packet))
+		slot := s.b.Save(len(packet))
+		if ok, err := s.slots.Push(seq, slot); !ok || err != nil {
+			s.b.Discard(slot)
+			if err == nil {
+				s.duplicates++
+				return
+			}
+			// Out of space: give up on the gap.
+			s.skipTo(seq)
+			if seq == s.expected {
+				s.deliver(packet)
+			}
+		}
+	}
+}
+
+// skipTo gives up on the packets missing before seq.
+func (s *sequencer) skipTo(seq int) {
+	lost := s.lost
+	s.skipGap()
+	if seq > s.expected {
+		s.lost += seq - s.expected
+		s.expected = seq
+	}
+	if s.lost > lost {
+		s.gaps++
+	}
+}
+
+// drain delivers the saved packets which follow the last delivered one.
+func (s *sequencer) drain() {
+	for {
+		slot, ok := s.slots.Pop(s.expected)
+		if !ok {
+			return
+		}
+		s.reordered++
+		s.deliver(s.b.SavedSlot(slot))
+		s.b.Discard(slot)
+	}
+}
+
+// skipGap gives up on the packets missing from the window, delivering the saved ones in order.
+func (s *sequencer) skipGap() {
+	for end := s.expected + s.window; s.expected < end && s.slots.Size() > 0; {
+		if slot, ok := s.slots.Pop(s.expected); ok {
+			s.reordered++
+			s.deliver(s.b.SavedSlot(slot))
+			s.b.Discard(slot)
+		} else {
+			s.lost++
+			s.expected++
+		}
+	}
+	s.drain()
+}
+
+// deliver processes the next packet in sequence. This is where the application would decode it.
+func (s *sequencer) deliver(packet []byte) {
+	s.delivered++
+	s.expected++
+}
+
+func main() {
+	flag.Parse()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	group, err := netip.ParseAddrPort(*addr)
+	if err != nil {
+		panic(err)
+	}
+
+	p, err := multicast.NewUDPPeer(ioc, "udp", group.String())
+	if err != nil {
+		panic(err)
+	}
+	defer p.Close()
+
+	if *iname == "" {
+		err = p.Join(multicast.IP(group.Addr().String()))
+	} else {
+		err = p.JoinOn(multicast.IP(group.Addr().String()), multicast.InterfaceName(*iname))
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	log.Printf("joined group %s", group)
+
+	seq := newSequencer(*maxSlots, *bufSize)
+
+	t, err := sonic.NewTimer(ioc)
+	if err != nil {
+		panic(err)
+	}
+	defer t.Close()
+
+	err = t.ScheduleRepeating(*every, func() {
+		log.Printf(
+			"received=%d delivered=%d reordered=%d buffered=%d next_expected=%d gaps=%d lost=%d duplicates=%d",
+			seq.received, seq.delivered, seq.reordered, seq.slots.Size(), seq.expected, seq.gaps, seq.lost,
+			seq.duplicates,
+		)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	b := make([]byte, 1500)
+	var onRead func(error, int, netip.AddrPort)
+	onRead = func(err error, n int, from netip.AddrPort) {
+		if err != nil {
+			panic(err)
+		}
+
+		if n < 8 {
+			log.Printf("dropping short packet from=%s n=%d", from, n)
+		} else {
+			seq.on(int(binary.BigEndian.Uint64(b[:8])), b[:n])
+		}
+
+		p.AsyncRead(b, onRead)
+	}
+	p.AsyncRead(b, onRead)
+
+	ioc.Run()
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestSequencer(t *testing.T) {
+	s := newSequencer(4, 1024)
+	packet := make([]byte, 16)
+
+	// Packets ahead of a missing one wait for it.
+	for _, seq := range []int{1, 2, 4, 5} {
+		s.on(seq, packet)
+	}
+	if s.delivered != 2 || s.slots.Size() != 2 || s.expected != 3 {
+		t.Fatalf("expected 2 delivered and 2 buffered got=%d %d", s.delivered, s.slots.Size())
+	}
+	s.on(3, packet)
+	if s.delivered != 5 || s.reordered != 2 || s.slots.Size() != 0 || s.expected != 6 {
+		t.Fatalf("expected the buffered packets to be delivered got=%d reordered=%d", s.delivered, s.reordered)
+	}
+
+	s.on(2, packet)
+	if s.duplicates != 1 {
+		t.Fatalf("expected a duplicate got=%d", s.duplicates)
+	}
+
+	// A gap which does not fill within the window is given up on.
+	s.on(8, packet)
+	s.on(12, packet)
+	if s.delivered != 7 || s.lost != 5 || s.gaps != 1 || s.expected != 13 {
+		t.Fatalf("expected the gap to be given up on got delivered=%d lost=%d gaps=%d expected=%d",
+			s.delivered, s.lost, s.gaps, s.expected)
+	}
+
+	// So is one which does not fit in the buffer.
+	s = newSequencer(4, 32)
+	s.on(1, packet)
+	s.on(3, packet)
+	s.on(4, packet)
+	s.on(5, packet)
+	if s.delivered != 4 || s.lost != 1 || s.gaps != 1 || s.slots.Size() != 0 {
+		t.Fatalf("expected the gap to be given up on got delivered=%d lost=%d gaps=%d buffered=%d",
+			s.delivered, s.lost, s.gaps, s.slots.Size())
+	}
+}
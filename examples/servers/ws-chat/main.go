@@ -0,0 +1,171 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/csdenboer/sonic"
+	"github.com/csdenboer/sonic/codec/websocket"
+	"github.com/csdenboer/sonic/sonicopts"
+)
+
+var (
+	addr      = flag.String("addr", ":8080", "address to listen on")
+	maxConns  = flag.Int("max-conns", 10000, "maximum number of connections")
+	maxPerIP  = flag.Int("max-conns-per-ip", 16, "maximum number of connections from the same IP address")
+	rate      = flag.Float64("rate", 10, "maximum number of messages per second a member can send")
+	burst     = flag.Int("burst", 20, "number of messages a member can send back-to-back")
+	maxMsgLen = flag.Int("max-msg-len", 4096, "maximum size of a message")
+)
+
+// Hub relays the messages of each member of the chat to all members. A message is encoded once and shared by the
+// write queues of all members, see websocket.RefCountedPayload.
+type Hub struct {
+	members map[*member]struct{}
+	closing bool
+}
+
+func NewHub() *Hub {
+	return &Hub{members: make(map[*member]struct{})}
+}
+
+// Join adds the stream of an upgraded connection to the chat. It is a websocket.UpgradeHandler.
+func (h *Hub) Join(ws *websocket.WebsocketStream, req *http.Request, _ string) {
+	if h.closing {
+		ws.AsyncClose(websocket.CloseGoingAway, "shutting down", func(error) { _ = ws.CloseNextLayer() })
+		return
+	}
+
+	ws.SetMaxMessageSize(*maxMsgLen)
+	ws.SetInboundRateLimit(*rate, *burst, websocket.RateLimitClose)
+
+	m := &member{hub: h, ws: ws, b: make([]byte, *maxMsgLen)}
+	h.members[m] = struct{}{}
+	log.Printf("%s joined from %s members=%d", req.URL.Path, ws.RemoteAddr(), len(h.members))
+
+	m.read()
+}
+
+// Broadcast writes b to all members.
+func (h *Hub) Broadcast(b []byte, mt websocket.MessageType) {
+	p := websocket.NewRefCountedPayload(append([]byte(nil), b...), nil)
+	for m := range h.members {
+		m := m
+		m.ws.AsyncWriteShared(p, mt, func(err error) {
+			if err != nil {
+				m.leave(err)
+			}
+		})
+	}
+	p.Release()
+}
+
+// Drain stops the hub from accepting members, and closes the streams of the current ones with 1001 Going Away.
+// onDrained is invoked once the last member left.
+func (h *Hub) Drain(onDrained func()) {
+	h.closing = true
+	if len(h.members) == 0 {
+		onDrained()
+		return
+	}
+	for m := range h.members {
+		m.onLeft = func() {
+			if len(h.members) == 0 {
+				onDrained()
+			}
+		}
+		m.ws.AsyncClose(websocket.CloseGoingAway, "shutting down", func(err error) {
+			if err != nil {
+				m.leave(err)
+			}
+		})
+	}
+}
+
+type member struct {
+	hub    *Hub
+	ws     *websocket.WebsocketStream
+	b      []byte
+	onLeft func()
+}
+
+func (m *member) read() {
+	m.ws.AsyncNextMessage(m.b, func(err error, n int, mt websocket.MessageType) {
+		if err != nil {
+			m.leave(err)
+			return
+		}
+		m.hub.Broadcast(m.b[:n], mt)
+		m.read()
+	})
+}
+
+func (m *member) leave(err error) {
+	if _, ok := m.hub.members[m]; !ok {
+		return
+	}
+	delete(m.hub.members, m)
+	_ = m.ws.CloseNextLayer()
+	log.Printf("member left err=%v members=%d", err, len(m.hub.members))
+
+	if m.onLeft != nil {
+		m.onLeft()
+	}
+}
+
+// serve upgrades the connections accepted from ln on the /chat path and hands them to the hub.
+func serve(ioc *sonic.IO, ln sonic.Listener, hub *Hub) *sonic.Acceptor {
+	router := websocket.NewUpgradeRouter(ioc)
+	router.Handle("/chat", websocket.HandshakePolicy{}, hub.Join)
+	router.SetErrorHandler(func(err error) {
+		log.Printf("could not upgrade err=%v", err)
+	})
+
+	limiter := sonic.NewConnLimiter(sonic.ConnLimits{MaxConns: *maxConns, MaxConnsPerIP: *maxPerIP})
+	acceptor := sonic.NewAcceptor(ln, router.Serve, func(err error) {
+		log.Printf("could not accept err=%v", err)
+	}, limiter.Middleware())
+	acceptor.Start()
+	return acceptor
+}
+
+func main() {
+	flag.Parse()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(ioc, "tcp", *addr, sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		panic(err)
+	}
+	defer ln.Close()
+
+	hub := NewHub()
+	acceptor := serve(ioc, ln, hub)
+
+	log.Printf("serving the chat on ws://%s/chat", ln.Addr())
+
+	// On SIGINT or SIGTERM, stop accepting and drain the members before exiting.
+	done := false
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		_ = ioc.Post(func() {
+			log.Printf("draining members=%d", len(hub.members))
+			acceptor.Stop()
+			hub.Drain(func() { done = true })
+		})
+	}()
+
+	for !done {
+		if err := ioc.RunOne(); err != nil {
+			panic(err)
+		}
+	}
+}
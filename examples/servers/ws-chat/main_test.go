@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic"
+	"github.com/csdenboer/sonic/codec/websocket"
+	"github.com/csdenboer/sonic/sonicopts"
+)
+
+func TestChat(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(ioc, "tcp", "localhost:8095", sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	hub := NewHub()
+	acceptor := serve(ioc, ln, hub)
+
+	run := func(what string, until func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !until() && time.Now().Before(deadline) {
+			_ = ioc.RunOneFor(time.Millisecond)
+		}
+		if !until() {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+
+	const nClients = 3
+	var (
+		clients  []*websocket.WebsocketStream
+		received = make([][]string, nClients)
+		upgraded = 0
+		closed   = 0
+	)
+	for i := 0; i < nClients; i++ {
+		i := i
+		ws, err := websocket.NewWebsocketStream(ioc, nil, websocket.RoleClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, ws)
+
+		b := make([]byte, 128)
+		var onMessage websocket.AsyncMessageHandler
+		onMessage = func(err error, n int, _ websocket.MessageType) {
+			if err != nil {
+				closed++
+				return
+			}
+			received[i] = append(received[i], string(b[:n]))
+			ws.AsyncNextMessage(b, onMessage)
+		}
+		ws.AsyncHandshake("ws://localhost:8095/chat", func(err error) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			upgraded++
+			ws.AsyncNextMessage(b, onMessage)
+		})
+	}
+	run("the clients to join", func() bool { return upgraded == nClients && len(hub.members) == nClients })
+
+	clients[0].AsyncWrite([]byte("hello"), websocket.TypeText, func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	run("the broadcast", func() bool {
+		for _, r := range received {
+			if len(r) == 0 {
+				return false
+			}
+		}
+		return true
+	})
+	for i, r := range received {
+		if len(r) != 1 || r[0] != "hello" {
+			t.Fatalf("client %d: expected to receive hello got=%v", i, r)
+		}
+	}
+
+	// Draining closes the streams of all members.
+	acceptor.Stop()
+	drained := false
+	hub.Drain(func() { drained = true })
+	run("the drain", func() bool { return drained && closed == nClients })
+}
@@ -0,0 +1,42 @@
+package sonic
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/csdenboer/sonic/internal"
+)
+
+// MkFIFO creates a named pipe, also known as a FIFO, at path with the given permissions.
+func MkFIFO(path string, mode os.FileMode) error {
+	return os.NewSyscallError("mkfifo", syscall.Mkfifo(path, uint32(mode.Perm())))
+}
+
+// OpenFIFO opens the named pipe at path as a nonblocking stream, such that local processes can exchange bytes through
+// it on the same IO as network traffic. flags must be os.O_RDONLY to open the read end or os.O_WRONLY to open the
+// write end.
+//
+// Opening the read end succeeds even if no process has the write end open; reads then complete with io.EOF until a
+// writer opens the FIFO. Opening the write end fails with ENXIO if no process has the read end open.
+func OpenFIFO(ioc *IO, path string, flags int) (File, error) {
+	fd, err := syscall.Open(path, flags|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(fd, &stat); err != nil {
+		_ = syscall.Close(fd)
+		return nil, os.NewSyscallError("fstat", err)
+	}
+	if stat.Mode&syscall.S_IFMT != syscall.S_IFIFO {
+		_ = syscall.Close(fd)
+		return nil, &os.PathError{Op: "open", Path: path, Err: syscall.EINVAL}
+	}
+
+	f := &file{
+		ioc:  ioc,
+		slot: internal.Slot{Fd: fd},
+	}
+	return f, nil
+}
@@ -0,0 +1,82 @@
+package sonic
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFIFO(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	path := filepath.Join(t.TempDir(), "fifo")
+	if err := MkFIFO(path, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenFIFO(ioc, path, os.O_WRONLY); !errors.Is(err, syscall.ENXIO) {
+		t.Fatalf("expected ENXIO without a reader got=%v", err)
+	}
+
+	r, err := OpenFIFO(ioc, path, os.O_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	w, err := OpenFIFO(ioc, path, os.O_WRONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		b       = make([]byte, 5)
+		readErr error
+		done    bool
+	)
+	r.AsyncReadAll(b, func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.AsyncRead(make([]byte, 1), func(err error, _ int) {
+			readErr = err
+			done = true
+		})
+	})
+	w.AsyncWriteAll([]byte("hello"), func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = w.Close()
+	})
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && !done {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+
+	if string(b) != "hello" {
+		t.Fatalf("expected to read hello got=%s", b)
+	}
+	if readErr != io.EOF {
+		t.Fatalf("expected EOF once the writer closed got=%v", readErr)
+	}
+}
+
+func TestOpenFIFONotAFIFO(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFIFO(ioc, path, os.O_RDONLY); !errors.Is(err, syscall.EINVAL) {
+		t.Fatalf("expected EINVAL got=%v", err)
+	}
+}
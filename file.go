@@ -1,16 +1,29 @@
 package sonic
 
 import (
+	"fmt"
 	"io"
 	"os"
 	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/csdenboer/sonic/internal"
 	"github.com/csdenboer/sonic/sonicerrors"
 )
 
-var _ File = &file{}
+var (
+	_ File                       = &file{}
+	_ AsyncVectoredWriter        = &file{}
+	_ VectoredWriter             = &file{}
+	_ AsyncVectoredReader        = &file{}
+	_ VectoredReader             = &file{}
+	_ AsyncContextReadWriter     = &file{}
+	_ AsyncCancellableReadWriter = &file{}
+)
+
+// maxIovecs bounds the number of buffers handed to a single readv or writev, as the kernel rejects more than IOV_MAX.
+const maxIovecs = 1024
 
 type file struct {
 	ioc    *IO
@@ -23,6 +36,42 @@ type file struct {
 	// we limit the number of dispatched reads to MaxCallbackDispatch.
 	// If we hit that limit, we schedule an async read/write which results in clearing the stack.
 	dispatched int
+
+	// readShutdown and writeShutdown are set when the corresponding side of the socket is shutdown, see
+	// conn.ShutdownRead and conn.ShutdownWrite.
+	readShutdown  bool
+	writeShutdown bool
+
+	// writing is true while an asynchronous write is in progress, in which case the next asynchronous writes are
+	// queued in writeQueue and started one after the other, in the order in which they were issued.
+	writing    bool
+	writeQueue []queuedWrite
+
+	// mem accounts the bytes of the writes in writeQueue, under the memory account of the IO. See MemoryAccount.
+	mem MemoryAccount
+
+	// budget bounds the reads and writes completed back-to-back. See SetExecutionBudget.
+	budget execBudget
+
+	// reader and writer hold the state of the pending asynchronous read and write, such that they do not allocate.
+	reader readReactor
+	writer writeReactor
+}
+
+type queuedWrite struct {
+	b        []byte
+	bufs     [][]byte // set for vectored writes, see AsyncWritev
+	writeAll bool
+	op       *asyncOp // set for the writes which can be cancelled on their own, see cancelWrite
+	cb       AsyncCallback
+}
+
+func (w *queuedWrite) size() int {
+	n := len(w.b)
+	for _, b := range w.bufs {
+		n += len(b)
+	}
+	return n
 }
 
 func Open(ioc *IO, path string, flags int, mode os.FileMode) (File, error) {
@@ -39,10 +88,16 @@ func Open(ioc *IO, path string, flags int, mode os.FileMode) (File, error) {
 }
 
 func (f *file) Read(b []byte) (int, error) {
+	if f.readShutdown || f.Closed() {
+		// The kernel might still return bytes which were buffered before the shutdown.
+		return 0, io.EOF
+	}
+
 	n, err := syscall.Read(f.slot.Fd, b)
 
 	if err != nil {
 		if err == syscall.EWOULDBLOCK || err == syscall.EAGAIN {
+			f.slot.WouldBlock(internal.ReadEvent)
 			return 0, sonicerrors.ErrWouldBlock
 		}
 
@@ -61,10 +116,19 @@ func (f *file) Read(b []byte) (int, error) {
 }
 
 func (f *file) Write(b []byte) (int, error) {
+	if f.Closed() {
+		// The file descriptor might have been reused already.
+		return 0, io.EOF
+	}
+	if f.writeShutdown {
+		return 0, syscall.EPIPE
+	}
+
 	n, err := syscall.Write(f.slot.Fd, b)
 
 	if err != nil {
 		if err == syscall.EWOULDBLOCK || err == syscall.EAGAIN {
+			f.slot.WouldBlock(internal.WriteEvent)
 			return 0, sonicerrors.ErrWouldBlock
 		}
 
@@ -91,17 +155,32 @@ func (f *file) AsyncReadAll(b []byte, cb AsyncCallback) {
 }
 
 func (f *file) asyncRead(b []byte, readAll bool, cb AsyncCallback) {
+	r := &f.reader
+	if r.f == nil {
+		r.init(f)
+	}
+	r.b, r.readAll, r.cb = b, readAll, cb
+
+	if f.budget.yield(r.resume) {
+		return
+	}
+
 	if f.dispatched < MaxCallbackDispatch {
-		f.asyncReadNow(b, 0, readAll, func(err error, n int) {
-			f.dispatched++
-			cb(err, n)
-			f.dispatched--
-		})
+		f.asyncReadNow(b, 0, readAll, r.dispatch)
 	} else {
 		f.scheduleRead(b, 0, readAll, cb)
 	}
 }
 
+// resumeRead starts a read which yielded to the IO loop, see SetExecutionBudget.
+func (f *file) resumeRead(b []byte, readAll bool, cb AsyncCallback) {
+	if f.Closed() {
+		cb(io.EOF, 0)
+	} else {
+		f.asyncRead(b, readAll, cb)
+	}
+}
+
 func (f *file) asyncReadNow(b []byte, readBytes int, readAll bool, cb AsyncCallback) {
 	n, err := f.Read(b[readBytes:])
 	readBytes += n
@@ -133,8 +212,9 @@ func (f *file) scheduleRead(b []byte, readBytes int, readAll bool, cb AsyncCallb
 		return
 	}
 
-	handler := f.getReadHandler(b, readBytes, readAll, cb)
-	f.slot.Set(internal.ReadEvent, handler)
+	r := &f.reader
+	r.b, r.readBytes, r.readAll, r.next = b, readBytes, readAll, cb
+	f.slot.Set(internal.ReadEvent, r.onReadable)
 
 	if err := f.ioc.SetRead(&f.slot); err != nil {
 		cb(err, readBytes)
@@ -143,34 +223,123 @@ func (f *file) scheduleRead(b []byte, readBytes int, readAll bool, cb AsyncCallb
 	}
 }
 
-func (f *file) getReadHandler(b []byte, readBytes int, readAll bool, cb AsyncCallback) internal.Handler {
-	return func(err error) {
+func (f *file) Readv(bufs [][]byte) (int, error) {
+	if f.readShutdown || f.Closed() {
+		return 0, io.EOF
+	}
+
+	size := 0
+	for _, b := range bufs {
+		size += len(b)
+	}
+	if size == 0 {
+		return 0, nil
+	}
+	if len(bufs) > maxIovecs {
+		bufs = bufs[:maxIovecs]
+	}
+
+	n, err := internal.Readv(f.slot.Fd, bufs)
+	if err != nil {
+		if err == syscall.EWOULDBLOCK || err == syscall.EAGAIN {
+			f.slot.WouldBlock(internal.ReadEvent)
+			return 0, sonicerrors.ErrWouldBlock
+		}
+		return 0, os.NewSyscallError("readv", err)
+	}
+	if n <= 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (f *file) AsyncReadv(bufs [][]byte, cb AsyncCallback) {
+	if f.budget.yield(func() {
+		if f.Closed() {
+			cb(io.EOF, 0)
+		} else {
+			f.AsyncReadv(bufs, cb)
+		}
+	}) {
+		return
+	}
+
+	if f.dispatched < MaxCallbackDispatch {
+		f.asyncReadvNow(bufs, func(err error, n int) {
+			f.dispatched++
+			cb(err, n)
+			f.dispatched--
+		})
+	} else {
+		f.scheduleReadv(bufs, cb)
+	}
+}
+
+func (f *file) asyncReadvNow(bufs [][]byte, cb AsyncCallback) {
+	n, err := f.Readv(bufs)
+	if err == sonicerrors.ErrWouldBlock {
+		f.scheduleReadv(bufs, cb)
+	} else {
+		cb(err, n)
+	}
+}
+
+func (f *file) scheduleReadv(bufs [][]byte, cb AsyncCallback) {
+	if f.Closed() {
+		cb(io.EOF, 0)
+		return
+	}
+
+	f.slot.Set(internal.ReadEvent, func(err error) {
 		f.ioc.Deregister(&f.slot)
 		if err != nil {
-			cb(err, readBytes)
+			cb(err, 0)
 		} else {
-			f.asyncReadNow(b, readBytes, readAll, cb)
+			f.asyncReadvNow(bufs, cb)
 		}
+	})
+
+	if err := f.ioc.SetRead(&f.slot); err != nil {
+		cb(err, 0)
+	} else {
+		f.ioc.Register(&f.slot)
 	}
 }
 
 func (f *file) AsyncWrite(b []byte, cb AsyncCallback) {
-	f.asyncWrite(b, false, cb)
+	f.asyncWrite(b, false, nil, cb)
 }
 
 func (f *file) AsyncWriteAll(b []byte, cb AsyncCallback) {
-	f.asyncWrite(b, true, cb)
+	f.asyncWrite(b, true, nil, cb)
+}
+
+// asyncWrite starts the write, or queues it if another asynchronous write is in progress, such that the bytes of
+// concurrent writes are never interleaved.
+func (f *file) asyncWrite(b []byte, writeAll bool, op *asyncOp, cb AsyncCallback) {
+	if f.writing {
+		f.queueWrite(queuedWrite{b: b, writeAll: writeAll, op: op, cb: cb})
+		return
+	}
+	f.writing = true
+	f.startWrite(b, writeAll, cb)
 }
 
-func (f *file) asyncWrite(b []byte, writeAll bool, cb AsyncCallback) {
+func (f *file) startWrite(b []byte, writeAll bool, cb AsyncCallback) {
+	w := &f.writer
+	if w.f == nil {
+		w.init(f)
+	}
+	w.b, w.bufs, w.writeAll, w.cb = b, nil, writeAll, cb
+
+	if f.budget.yield(w.resume) {
+		return
+	}
+
 	if f.dispatched < MaxCallbackDispatch {
-		f.asyncWriteNow(b, 0, writeAll, func(err error, n int) {
-			f.dispatched++
-			cb(err, n)
-			f.dispatched--
-		})
+		f.asyncWriteNow(b, 0, writeAll, w.dispatch)
 	} else {
-		f.scheduleWrite(b, 0, writeAll, cb)
+		f.scheduleWrite(b, 0, writeAll, w.complete)
 	}
 }
 
@@ -190,8 +359,8 @@ func (f *file) asyncWriteNow(b []byte, writtenBytes int, writeAll bool, cb Async
 	}
 
 	// handles (writeAll == false) and (writeAll == true && writtenBytes != len(b)).
-	if err == sonicerrors.ErrWouldBlock {
-		// If writeAll == true then wrote some without errors.
+	if err == nil || err == sonicerrors.ErrWouldBlock {
+		// If writeAll == true then wrote some without errors, possibly short of len(b) if the send buffer filled up.
 		// We schedule an asynchronous write.
 		f.scheduleWrite(b, writtenBytes, writeAll, cb)
 	} else {
@@ -205,8 +374,9 @@ func (f *file) scheduleWrite(b []byte, writtenBytes int, writeAll bool, cb Async
 		return
 	}
 
-	handler := f.getWriteHandler(b, writtenBytes, writeAll, cb)
-	f.slot.Set(internal.WriteEvent, handler)
+	w := &f.writer
+	w.b, w.bufs, w.writtenBytes, w.writeAll, w.next = b, nil, writtenBytes, writeAll, cb
+	f.slot.Set(internal.WriteEvent, w.onWritable)
 
 	if err := f.ioc.SetWrite(&f.slot); err != nil {
 		cb(err, writtenBytes)
@@ -215,16 +385,150 @@ func (f *file) scheduleWrite(b []byte, writtenBytes int, writeAll bool, cb Async
 	}
 }
 
-func (f *file) getWriteHandler(b []byte, writtenBytes int, writeAll bool, cb AsyncCallback) internal.Handler {
-	return func(err error) {
-		f.ioc.Deregister(&f.slot)
+// SetEdgeTriggered switches the file descriptor to a persistent, edge-triggered registration with the IO, see Conn.
+// It fails if an asynchronous read or write is waiting for the file descriptor.
+func (f *file) SetEdgeTriggered(enabled bool) error {
+	if f.slot.EdgeTriggered == enabled {
+		return nil
+	}
+	if f.slot.Events != 0 {
+		return fmt.Errorf("cannot change the registration of fd=%d while an operation is pending", f.slot.Fd)
+	}
+	if !enabled {
+		if err := f.ioc.poller.Del(&f.slot); err != nil {
+			return err
+		}
+	}
+	f.slot.EdgeTriggered = enabled
+	return nil
+}
+
+// SetExecutionBudget bounds the asynchronous reads and writes completed back-to-back, without going through the IO
+// loop, to maxOps operations and maxTime. The next operation then yields to the IO loop. 0 means no bound.
+func (f *file) SetExecutionBudget(maxOps int, maxTime time.Duration) {
+	f.budget.set(f.ioc, maxOps, maxTime)
+}
 
+func (f *file) Writev(bufs [][]byte) (n int, err error) {
+	for len(bufs) > 0 {
+		var written int
+		written, err = f.writev(bufs)
+		n += written
+		bufs = consumeBuffers(bufs, written)
 		if err != nil {
-			cb(err, writtenBytes)
-		} else {
-			f.asyncWriteNow(b, writtenBytes, writeAll, cb)
+			break
 		}
 	}
+	return n, err
+}
+
+func (f *file) AsyncWritev(bufs [][]byte, cb AsyncCallback) {
+	f.asyncWritev(bufs, nil, cb)
+}
+
+func (f *file) asyncWritev(bufs [][]byte, op *asyncOp, cb AsyncCallback) {
+	if bufs == nil {
+		bufs = [][]byte{}
+	}
+	if f.writing {
+		f.queueWrite(queuedWrite{bufs: bufs, op: op, cb: cb})
+		return
+	}
+	f.writing = true
+	f.startWritev(bufs, cb)
+}
+
+func (f *file) startWritev(bufs [][]byte, cb AsyncCallback) {
+	w := &f.writer
+	if w.f == nil {
+		w.init(f)
+	}
+	w.b, w.bufs, w.cb = nil, bufs, cb
+
+	if f.budget.yield(w.resume) {
+		return
+	}
+
+	if f.dispatched < MaxCallbackDispatch {
+		f.asyncWritevNow(bufs, 0, w.dispatch)
+	} else {
+		f.scheduleWritev(bufs, 0, w.complete)
+	}
+}
+
+func (f *file) asyncWritevNow(bufs [][]byte, writtenBytes int, cb AsyncCallback) {
+	n, err := f.writev(bufs)
+	writtenBytes += n
+	bufs = consumeBuffers(bufs, n)
+
+	if err == nil && len(bufs) == 0 {
+		cb(nil, writtenBytes)
+		return
+	}
+
+	if err == nil || err == sonicerrors.ErrWouldBlock {
+		f.scheduleWritev(bufs, writtenBytes, cb)
+	} else {
+		cb(err, writtenBytes)
+	}
+}
+
+func (f *file) scheduleWritev(bufs [][]byte, writtenBytes int, cb AsyncCallback) {
+	if f.Closed() {
+		cb(io.EOF, 0)
+		return
+	}
+
+	w := &f.writer
+	w.b, w.bufs, w.writtenBytes, w.next = nil, bufs, writtenBytes, cb
+	f.slot.Set(internal.WriteEvent, w.onWritable)
+
+	if err := f.ioc.SetWrite(&f.slot); err != nil {
+		cb(err, writtenBytes)
+	} else {
+		f.ioc.Register(&f.slot)
+	}
+}
+
+func (f *file) writev(bufs [][]byte) (int, error) {
+	if f.Closed() {
+		return 0, io.EOF
+	}
+	if f.writeShutdown {
+		return 0, syscall.EPIPE
+	}
+	if len(bufs) == 0 {
+		return 0, nil
+	}
+	if len(bufs) > maxIovecs {
+		bufs = bufs[:maxIovecs]
+	}
+
+	n, err := internal.Writev(f.slot.Fd, bufs)
+	if err != nil {
+		if err == syscall.EWOULDBLOCK || err == syscall.EAGAIN {
+			f.slot.WouldBlock(internal.WriteEvent)
+			return 0, sonicerrors.ErrWouldBlock
+		}
+		return 0, os.NewSyscallError("writev", err)
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n, nil
+}
+
+// consumeBuffers removes the first n bytes from bufs, like net.Buffers does once written.
+func consumeBuffers(bufs [][]byte, n int) [][]byte {
+	for len(bufs) > 0 && len(bufs[0]) <= n {
+		n -= len(bufs[0])
+		bufs[0] = nil
+		bufs = bufs[1:]
+	}
+	if len(bufs) > 0 {
+		bufs[0] = bufs[0][n:]
+	}
+	return bufs
 }
 
 func (f *file) Close() error {
@@ -232,6 +536,10 @@ func (f *file) Close() error {
 		return io.EOF
 	}
 
+	// The pending reads and writes, queued ones included, complete with ErrCancelled. The file is closed by now, so
+	// operations issued from their handlers fail right away.
+	f.Cancel()
+
 	err := f.ioc.poller.Del(&f.slot)
 	if err != nil {
 		return err
@@ -264,6 +572,19 @@ func (f *file) cancelReads() {
 }
 
 func (f *file) cancelWrites() {
+	queued := f.writeQueue
+	f.writeQueue = nil
+	f.mem.Close()
+
+	f.cancelPendingWrite()
+
+	for _, w := range queued {
+		w.cb(sonicerrors.ErrCancelled, 0)
+	}
+}
+
+// cancelPendingWrite cancels the write waiting for the file descriptor to become writable, if any.
+func (f *file) cancelPendingWrite() {
 	if f.slot.Events&internal.PollerWriteEvent == internal.PollerWriteEvent {
 		err := f.ioc.poller.DelWrite(&f.slot)
 		if err == nil {
@@ -273,6 +594,33 @@ func (f *file) cancelWrites() {
 	}
 }
 
+// queueWrite queues w behind the write in progress and accounts its bytes.
+func (f *file) queueWrite(w queuedWrite) {
+	f.writeQueue = append(f.writeQueue, w)
+	f.accountWrite(w.size())
+}
+
+// accountWrite adds n bytes, which can be negative, to the memory account.
+func (f *file) accountWrite(n int) {
+	if n == 0 {
+		return
+	}
+	if f.mem.parent == nil {
+		f.mem.parent = &f.ioc.memory
+	}
+	f.mem.Set(int(f.mem.own) + n)
+}
+
+// MemoryAccount returns the memory account of the file, which holds the bytes of the asynchronous writes queued behind
+// the one in progress. These bytes belong to the callers' buffers, which the file holds on to until they are
+// written. The account is under the memory account of the IO, and is released once the file is closed.
+func (f *file) MemoryAccount() *MemoryAccount {
+	if f.mem.parent == nil {
+		f.mem.parent = &f.ioc.memory
+	}
+	return &f.mem
+}
+
 func (f *file) RawFd() int {
 	return f.slot.Fd
 }
@@ -0,0 +1,134 @@
+package sonic
+
+import (
+	"io"
+
+	"github.com/csdenboer/sonic/internal"
+)
+
+// readReactor holds the state of the pending asynchronous read of a file. A file has at most one pending read, so the
+// reactor is reused by all of them, and its handlers are bound once, such that steady-state reads do not allocate.
+//
+// A new read can only be issued by the handler of the previous one, after which the state of the previous read is
+// not used anymore, so the handlers read the state of the reactor when invoked.
+type readReactor struct {
+	f *file
+
+	b         []byte
+	readBytes int
+	readAll   bool
+	cb        AsyncCallback // the handler of the read
+	next      AsyncCallback // invoked by onReadable: either cb or dispatch
+
+	onReadable internal.Handler
+	dispatch   AsyncCallback
+	resume     func()
+}
+
+func (r *readReactor) init(f *file) {
+	r.f = f
+	r.onReadable = r.on
+	r.dispatch = r.dispatched
+	r.resume = r.resumed
+}
+
+func (r *readReactor) on(err error) {
+	f := r.f
+	f.ioc.Deregister(&f.slot)
+	if err != nil {
+		r.next(err, r.readBytes)
+	} else {
+		f.asyncReadNow(r.b, r.readBytes, r.readAll, r.next)
+	}
+}
+
+// dispatched invokes the handler of a read which completes right away, keeping track of the callbacks on the stack.
+func (r *readReactor) dispatched(err error, n int) {
+	cb := r.cb
+	r.f.dispatched++
+	cb(err, n)
+	r.f.dispatched--
+}
+
+// resumed starts a read which yielded to the IO loop, see SetExecutionBudget.
+func (r *readReactor) resumed() {
+	r.f.resumeRead(r.b, r.readAll, r.cb)
+}
+
+// writeReactor holds the state of the asynchronous write in progress on a file, see readReactor. The next writes are
+// queued until it completes, so the reactor is reused by all of them.
+type writeReactor struct {
+	f *file
+
+	b            []byte
+	bufs         [][]byte // set for vectored writes, see AsyncWritev
+	writtenBytes int
+	writeAll     bool
+	cb           AsyncCallback // the handler of the write
+	next         AsyncCallback // invoked by onWritable: either complete or dispatch
+
+	onWritable internal.Handler
+	complete   AsyncCallback
+	dispatch   AsyncCallback
+	resume     func()
+}
+
+func (w *writeReactor) init(f *file) {
+	w.f = f
+	w.onWritable = w.on
+	w.complete = w.completed
+	w.dispatch = w.dispatched
+	w.resume = w.resumed
+}
+
+func (w *writeReactor) on(err error) {
+	f := w.f
+	f.ioc.Deregister(&f.slot)
+	if err != nil {
+		w.next(err, w.writtenBytes)
+	} else if w.bufs != nil {
+		f.asyncWritevNow(w.bufs, w.writtenBytes, w.next)
+	} else {
+		f.asyncWriteNow(w.b, w.writtenBytes, w.writeAll, w.next)
+	}
+}
+
+// completed invokes the handler of the write, after which the next queued write is started. Writes issued by the
+// handler are thus queued after the writes which were already queued.
+func (w *writeReactor) completed(err error, n int) {
+	f := w.f
+	w.cb(err, n)
+
+	if len(f.writeQueue) == 0 {
+		f.writing = false
+		return
+	}
+	next := f.writeQueue[0]
+	f.writeQueue[0] = queuedWrite{}
+	f.writeQueue = f.writeQueue[1:]
+	f.accountWrite(-next.size())
+	if next.bufs != nil {
+		f.startWritev(next.bufs, next.cb)
+	} else {
+		f.startWrite(next.b, next.writeAll, next.cb)
+	}
+}
+
+func (w *writeReactor) dispatched(err error, n int) {
+	w.f.dispatched++
+	w.completed(err, n)
+	w.f.dispatched--
+}
+
+// resumed starts a write which yielded to the IO loop, see SetExecutionBudget.
+func (w *writeReactor) resumed() {
+	f := w.f
+	switch {
+	case f.Closed():
+		w.completed(io.EOF, 0)
+	case w.bufs != nil:
+		f.startWritev(w.bufs, w.cb)
+	default:
+		f.startWrite(w.b, w.writeAll, w.cb)
+	}
+}
@@ -0,0 +1,84 @@
+package sonic
+
+import (
+	"io"
+)
+
+// forwardChunk bounds the bytes moved by a single splice, or copied through the buffer of AsyncForwardTo when they
+// cannot be spliced.
+const forwardChunk = 64 * 1024
+
+// fileHolder is implemented by the streams backed by a file descriptor of this package, whose bytes can be spliced.
+type fileHolder interface {
+	rawFile() *file
+}
+
+func (f *file) rawFile() *file {
+	return f
+}
+
+// AsyncForwardTo forwards n bytes read from the file to dst, or all the bytes until the end of the file if n is
+// negative. cb is invoked with the number of bytes written to dst once they are all forwarded, or once forwarding
+// fails. If the end of the file is reached before n bytes are forwarded, cb is invoked with io.EOF. If n is negative,
+// reaching the end of the file is not an error.
+//
+// On Linux, if dst is a Conn or a File of this package, the bytes are spliced from one file descriptor to the other
+// and are never copied to user space, see Splicer. Otherwise, they are read into a buffer and written to dst.
+//
+// No other read must be issued on the file, and no other write on dst, until cb is invoked. Cancelling the pending
+// reads of the file or the pending writes of dst fails the forwarding with sonicerrors.ErrCancelled. The bytes read
+// but not yet written when forwarding fails are lost.
+func (f *file) AsyncForwardTo(dst AsyncWriteStream, n int, cb AsyncCallback) {
+	if n == 0 {
+		cb(nil, 0)
+		return
+	}
+	if d, ok := dst.(fileHolder); ok && f.asyncSplice(d.rawFile(), n, cb) {
+		return
+	}
+	f.asyncCopy(dst, n, cb)
+}
+
+// asyncCopy forwards the bytes of the file to dst through a buffer, see AsyncForwardTo.
+func (f *file) asyncCopy(dst AsyncWriteStream, n int, cb AsyncCallback) {
+	size := forwardChunk
+	if n > 0 && n < size {
+		size = n
+	}
+	b := make([]byte, size)
+
+	forwarded := 0
+	next := func() []byte {
+		if n > 0 && n-forwarded < len(b) {
+			return b[:n-forwarded]
+		}
+		return b
+	}
+
+	var onRead AsyncCallback
+	onRead = func(err error, nr int) {
+		if err != nil {
+			cb(forwardError(err, n), forwarded)
+			return
+		}
+		dst.AsyncWriteAll(b[:nr], func(err error, nw int) {
+			forwarded += nw
+			if err != nil {
+				cb(err, forwarded)
+			} else if n > 0 && forwarded >= n {
+				cb(nil, forwarded)
+			} else {
+				f.AsyncRead(next(), onRead)
+			}
+		})
+	}
+	f.AsyncRead(next(), onRead)
+}
+
+// forwardError returns the error with which forwarding n bytes completes when reading fails with err.
+func forwardError(err error, n int) error {
+	if err == io.EOF && n < 0 {
+		return nil
+	}
+	return err
+}
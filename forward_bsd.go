@@ -0,0 +1,8 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package sonic
+
+// asyncSplice returns false, as bytes are only spliced on Linux, so AsyncForwardTo copies them.
+func (f *file) asyncSplice(dst *file, n int, cb AsyncCallback) bool {
+	return false
+}
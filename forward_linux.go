@@ -0,0 +1,123 @@
+//go:build linux
+
+package sonic
+
+import (
+	"io"
+
+	"github.com/csdenboer/sonic/internal"
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+// splicedForward splices the bytes of a file into another one, see AsyncForwardTo.
+type splicedForward struct {
+	src, dst *file
+	splicer  *Splicer
+
+	n         int // negative if all the bytes are forwarded, until the end of src
+	forwarded int
+	cb        AsyncCallback
+
+	onReadable internal.Handler
+	onWritable internal.Handler
+	resume     func()
+}
+
+// asyncSplice forwards the bytes of the file to dst with a Splicer, see AsyncForwardTo. It returns true as the bytes
+// of two files can always be spliced on Linux.
+func (f *file) asyncSplice(dst *file, n int, cb AsyncCallback) bool {
+	splicer, err := NewSplicer()
+	if err != nil {
+		cb(err, 0)
+		return true
+	}
+
+	fw := &splicedForward{
+		src:     f,
+		dst:     dst,
+		splicer: splicer,
+		n:       n,
+		cb:      cb,
+	}
+	fw.onReadable = fw.onReady(f)
+	fw.onWritable = fw.onReady(dst)
+	fw.resume = func() {
+		if f.Closed() {
+			fw.done(io.EOF)
+		} else {
+			fw.splice()
+		}
+	}
+	fw.splice()
+	return true
+}
+
+func (fw *splicedForward) splice() {
+	for fw.n < 0 || fw.forwarded < fw.n {
+		if fw.src.budget.yield(fw.resume) {
+			return
+		}
+		if fw.src.readShutdown {
+			fw.done(forwardError(io.EOF, fw.n))
+			return
+		}
+
+		chunk := forwardChunk
+		if fw.n > 0 && fw.n-fw.forwarded < chunk {
+			chunk = fw.n - fw.forwarded
+		}
+		written, err := fw.splicer.Splice(fw.dst, fw.src, chunk)
+		fw.forwarded += int(written)
+
+		switch {
+		case err == sonicerrors.ErrWouldBlock && fw.splicer.Buffered() > 0:
+			fw.wait(fw.dst, internal.WriteEvent)
+			return
+		case err == sonicerrors.ErrWouldBlock:
+			fw.wait(fw.src, internal.ReadEvent)
+			return
+		case err != nil:
+			fw.done(forwardError(err, fw.n))
+			return
+		}
+	}
+	fw.done(nil)
+}
+
+// wait waits for f to become readable or writable, as given by et, before splicing again.
+func (fw *splicedForward) wait(f *file, et internal.EventType) {
+	if f.Closed() {
+		fw.done(io.EOF)
+		return
+	}
+
+	var err error
+	if et == internal.ReadEvent {
+		f.slot.Set(et, fw.onReadable)
+		err = f.ioc.SetRead(&f.slot)
+	} else {
+		f.slot.Set(et, fw.onWritable)
+		err = f.ioc.SetWrite(&f.slot)
+	}
+	if err != nil {
+		fw.done(err)
+	} else {
+		f.ioc.Register(&f.slot)
+	}
+}
+
+func (fw *splicedForward) onReady(f *file) internal.Handler {
+	return func(err error) {
+		f.ioc.Deregister(&f.slot)
+		if err != nil {
+			fw.done(err)
+		} else {
+			fw.splice()
+		}
+	}
+}
+
+func (fw *splicedForward) done(err error) {
+	_ = fw.splicer.Close()
+	fw.cb(err, fw.forwarded)
+}
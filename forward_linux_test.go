@@ -0,0 +1,224 @@
+//go:build linux
+
+package sonic
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// forwardSink is an AsyncWriteStream which is not backed by a file descriptor, so bytes are copied when forwarded to
+// it.
+type forwardSink struct {
+	bytes.Buffer
+}
+
+func (s *forwardSink) AsyncWrite(b []byte, cb AsyncCallback) {
+	n, err := s.Write(b)
+	cb(err, n)
+}
+
+func (s *forwardSink) AsyncWriteAll(b []byte, cb AsyncCallback) {
+	s.AsyncWrite(b, cb)
+}
+
+func (s *forwardSink) Cancel()      {}
+func (s *forwardSink) Close() error { return nil }
+
+func TestConnAsyncForwardTo(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	// client -> (in) proxy (out) -> server
+	client, in := socketPair(t, ioc)
+	defer in.Close()
+	out, server := socketPair(t, ioc)
+	defer out.Close()
+	defer server.Close()
+
+	payload := make([]byte, 1024*1024)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	forwarded, done := 0, false
+	in.AsyncForwardTo(out, -1, func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		forwarded, done = n, true
+	})
+
+	client.AsyncWriteAll(payload, func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = client.Close()
+	})
+
+	received := make([]byte, len(payload))
+	read := 0
+	var onRead AsyncCallback
+	onRead = func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		read += n
+		if read < len(received) {
+			server.AsyncRead(received[read:], onRead)
+		}
+	}
+	server.AsyncRead(received, onRead)
+
+	for !done || read < len(received) {
+		if err := ioc.RunOne(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if forwarded != len(payload) {
+		t.Fatalf("expected to forward %d bytes got=%d", len(payload), forwarded)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Fatal("the forwarded bytes differ from the written ones")
+	}
+}
+
+func TestConnAsyncForwardToBounded(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	client, in := socketPair(t, ioc)
+	defer client.Close()
+	defer in.Close()
+	out, server := socketPair(t, ioc)
+	defer out.Close()
+	defer server.Close()
+
+	if _, err := client.Write([]byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Spliced.
+	var errs []error
+	in.AsyncForwardTo(out, 5, func(err error, n int) {
+		if n != 5 {
+			t.Fatalf("expected to forward 5 bytes got=%d", n)
+		}
+		errs = append(errs, err)
+	})
+
+	// Copied.
+	sink := &forwardSink{}
+	in.AsyncForwardTo(sink, 3, func(err error, n int) {
+		if n != 3 {
+			t.Fatalf("expected to forward 3 bytes got=%d", n)
+		}
+		errs = append(errs, err)
+	})
+
+	for len(errs) < 2 {
+		if err := ioc.RunOne(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := make([]byte, 16)
+	n, err := server.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:n]) != "hello" || sink.String() != " wo" {
+		t.Fatalf("unexpected forwarded bytes spliced=%q copied=%q", b[:n], sink.String())
+	}
+
+	// The end of the stream is reached before the bytes are forwarded.
+	_ = client.Close()
+	var eof error
+	in.AsyncForwardTo(sink, 16, func(err error, n int) {
+		if n != 3 {
+			t.Fatalf("expected to forward 3 bytes got=%d", n)
+		}
+		eof = err
+	})
+	for eof == nil {
+		if err := ioc.RunOne(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if eof != io.EOF || sink.String() != " world" {
+		t.Fatalf("expected io.EOF got=%v copied=%q", eof, sink.String())
+	}
+}
+
+func BenchmarkConnAsyncForwardTo(b *testing.B) {
+	b.Run("splice", func(b *testing.B) {
+		benchmarkForward(b, func(out *conn) AsyncWriteStream { return out })
+	})
+	b.Run("copy", func(b *testing.B) {
+		// Hide the file of out, such that the bytes are copied.
+		benchmarkForward(b, func(out *conn) AsyncWriteStream { return struct{ AsyncWriteStream }{out} })
+	})
+}
+
+func benchmarkForward(b *testing.B, dst func(out *conn) AsyncWriteStream) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	client, in := socketPair(b, ioc)
+	defer in.Close()
+	out, server := socketPair(b, ioc)
+	defer server.Close()
+
+	payload := make([]byte, forwardChunk)
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+
+	done := false
+	in.AsyncForwardTo(dst(out), -1, func(err error, _ int) {
+		if err != nil {
+			b.Error(err)
+		}
+		done = true
+		_ = out.Close()
+	})
+
+	writes := 0
+	var onWrite AsyncCallback
+	onWrite = func(err error, _ int) {
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		writes++
+		if writes == b.N {
+			_ = client.Close()
+		} else {
+			client.AsyncWriteAll(payload, onWrite)
+		}
+	}
+	client.AsyncWriteAll(payload, onWrite)
+
+	received := make([]byte, len(payload))
+	eof := false
+	var onRead AsyncCallback
+	onRead = func(err error, _ int) {
+		if err != nil {
+			eof = true
+			return
+		}
+		server.AsyncRead(received, onRead)
+	}
+	server.AsyncRead(received, onRead)
+
+	for !done || !eof {
+		if err := ioc.RunOne(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
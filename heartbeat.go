@@ -0,0 +1,71 @@
+package sonic
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// heartbeat is a watchdog of the event processing loop. A goroutine posts a handler on the IO at a fixed interval,
+// which records the time at which the loop ran it. A loop which is stuck, for example in a handler looping forever or
+// in a blocking system call, does not run the handler, so the recorded time becomes stale.
+type heartbeat struct {
+	last     int64  // Unix nanoseconds of the last beat. Accessed atomically.
+	inflight uint32 // 1 if a beat is posted but not yet run. Accessed atomically.
+	stop     chan struct{}
+}
+
+// EnableHeartbeat starts a watchdog of the event processing loop which runs a no-op handler every interval and records
+// the time at which it ran, which is returned by LastLoopHeartbeat.
+//
+// A supervisor goroutine can then detect a wedged loop by checking how old the last heartbeat is, and take action,
+// such as dumping the pending operations. At most one heartbeat is posted at a time, so a wedged loop does not
+// accumulate handlers. A heartbeat which cannot be posted because the post queue is full, see SetMaxPosts, is posted
+// again shortly after. Calling EnableHeartbeat again changes the interval.
+//
+// The heartbeat is stopped by DisableHeartbeat or Close.
+func (ioc *IO) EnableHeartbeat(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("the heartbeat interval must be positive")
+	}
+
+	ioc.DisableHeartbeat()
+
+	hb := &ioc.heartbeat
+	atomic.StoreInt64(&hb.last, time.Now().UnixNano())
+	atomic.StoreUint32(&hb.inflight, 0)
+	hb.stop = make(chan struct{})
+
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		postLoop(ioc, stop, ticker.C, &hb.inflight, func() {
+			atomic.StoreInt64(&hb.last, time.Now().UnixNano())
+			atomic.StoreUint32(&hb.inflight, 0)
+		})
+	}(hb.stop)
+
+	return nil
+}
+
+// DisableHeartbeat stops the watchdog started by EnableHeartbeat, if any.
+func (ioc *IO) DisableHeartbeat() {
+	if hb := &ioc.heartbeat; hb.stop != nil {
+		close(hb.stop)
+		hb.stop = nil
+	}
+}
+
+// LastLoopHeartbeat returns the time at which the event processing loop last ran the heartbeat handler, or the time
+// at which the heartbeat was enabled if it did not run yet. The zero time is returned if the heartbeat was never
+// enabled.
+//
+// It is safe to call LastLoopHeartbeat concurrently.
+func (ioc *IO) LastLoopHeartbeat() time.Time {
+	last := atomic.LoadInt64(&ioc.heartbeat.last)
+	if last == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, last)
+}
@@ -0,0 +1,10 @@
+//go:build netbsd || freebsd || openbsd || dragonfly
+
+package internal
+
+import "fmt"
+
+// BindToDevice is not supported on the BSDs other than macOS.
+func BindToDevice(fd int, name string) error {
+	return fmt.Errorf("binding to a device is only supported on linux and macOS")
+}
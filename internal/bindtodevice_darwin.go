@@ -0,0 +1,36 @@
+//go:build darwin
+
+package internal
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// ipv6BoundIf is IPV6_BOUND_IF from <netinet6/in6.h>, which is not exported by the syscall package.
+const ipv6BoundIf = 0x7d
+
+// BindToDevice binds the socket to the network interface with the given name through IP_BOUND_IF or IPV6_BOUND_IF,
+// depending on the socket's address family.
+func BindToDevice(fd int, name string) error {
+	iff, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return os.NewSyscallError("getsockname", err)
+	}
+
+	if _, ok := sa.(*syscall.SockaddrInet6); ok {
+		err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, ipv6BoundIf, iff.Index)
+	} else {
+		err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_BOUND_IF, iff.Index)
+	}
+	if err != nil {
+		return os.NewSyscallError("bind_to_device", err)
+	}
+	return nil
+}
@@ -2,6 +2,9 @@ package internal
 
 import "time"
 
+// DefaultMaxEvents is the default maximum number of ready events dispatched by a single Poll call.
+const DefaultMaxEvents = 128
+
 type EventType int8
 
 const (
@@ -25,12 +28,34 @@ type Slot struct {
 	// Callbacks registered with this Slot. The poller dispatches the appropriate read or write callback when it
 	// receives an event that's in Events.
 	Handlers [MaxEvent]Handler
+
+	// EdgeTriggered registers the file descriptor with the Poller once, for both reads and writes, in edge-triggered
+	// mode, instead of arming a one-shot registration for every SetRead and SetWrite. Registering and deregistering
+	// interest in events then costs no syscall.
+	//
+	// An edge only occurs once the file descriptor becomes ready after an operation would block, which the owner of
+	// the Slot reports with WouldBlock. The events set while the file descriptor may still be ready are dispatched by
+	// the next Poll without waiting for an edge. EdgeTriggered must only be changed while no events are set.
+	EdgeTriggered bool
+
+	registered bool        // the file descriptor is registered with the Poller in edge-triggered mode
+	ready      PollerEvent // the events which may have occurred since the operation last would block
 }
 
 func (s *Slot) Set(et EventType, h Handler) {
 	s.Handlers[et] = h
 }
 
+// WouldBlock records that an operation on the file descriptor of an EdgeTriggered Slot would block, so the next
+// event of the given type is an edge reported by the Poller.
+func (s *Slot) WouldBlock(et EventType) {
+	if et == ReadEvent {
+		s.ready &^= PollerReadEvent
+	} else {
+		s.ready &^= PollerWriteEvent
+	}
+}
+
 type ITimer interface {
 	Set(time.Duration, func()) error
 	Unset() error
@@ -50,11 +75,30 @@ type Poller interface {
 	// Pending returns the number of registered events which have not yet occurred.
 	Pending() int64
 
+	// SetMaxEvents sets the maximum number of ready events, such as expired timers, returned and dispatched by a
+	// single Poll call. The change takes effect on the next Poll call.
+	SetMaxEvents(n int)
+
+	// MaxEvents returns the value set with SetMaxEvents.
+	MaxEvents() int
+
 	// Post instructs the Poller to execute the provided handler in the Poller's goroutine in the next Poll call.
 	//
+	// Only the Post which makes the queue of handlers non-empty wakes up the Poller, so a stalled Poller can have any
+	// number of handlers posted without the waker overflowing. sonicerrors.ErrPostQueueFull is returned if the queue
+	// holds MaxPosts handlers, and an error wrapping sonicerrors.ErrWakeupFailed if the Poller could not be woken up.
+	//
 	// Post is safe for concurrent use.
 	Post(func()) error
 
+	// SetMaxPosts bounds the number of handlers which can be posted and not yet executed. 0 means no bound.
+	//
+	// SetMaxPosts is safe for concurrent use.
+	SetMaxPosts(n int)
+
+	// MaxPosts returns the value set with SetMaxPosts.
+	MaxPosts() int
+
 	// Posted returns the number of handlers registered with Post.
 	//
 	// Posted is safe for concurrent use.
@@ -75,6 +119,11 @@ type Poller interface {
 	// Del deregisters interest in all events on the provided slot.
 	Del(slot *Slot) error
 
+	// SetErrorHandler sets the handler invoked by Poll with the file descriptor of the events it cannot dispatch, such
+	// as events on a Slot which is not registered for them, and of the errors it runs into while dispatching. The
+	// handler is invoked in the Poller's goroutine. nil, the default, ignores them.
+	SetErrorHandler(handler func(fd int, err error))
+
 	// Close closes the Poller. No calls to Poll should be made after Close.
 	//
 	// Close is safe for concurrent use.
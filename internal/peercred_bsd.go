@@ -0,0 +1,10 @@
+//go:build netbsd || freebsd || openbsd || dragonfly
+
+package internal
+
+import "fmt"
+
+// PeerCredentials is not supported on the BSDs other than macOS.
+func PeerCredentials(fd int) (uid, gid uint32, pid int, err error) {
+	return 0, 0, 0, fmt.Errorf("peer credentials are only supported on linux and macOS")
+}
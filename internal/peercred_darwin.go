@@ -0,0 +1,27 @@
+//go:build darwin
+
+package internal
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// PeerCredentials returns the credentials of the peer of a connected Unix domain socket through LOCAL_PEERCRED, which
+// is what getpeereid is built on, and LOCAL_PEERPID. The gid is the peer's effective group.
+func PeerCredentials(fd int) (uid, gid uint32, pid int, err error) {
+	cred, err := unix.GetsockoptXucred(fd, unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	if err != nil {
+		return 0, 0, 0, os.NewSyscallError("getsockopt", err)
+	}
+	if cred.Ngroups > 0 {
+		gid = cred.Groups[0]
+	}
+
+	pid, err = unix.GetsockoptInt(fd, unix.SOL_LOCAL, unix.LOCAL_PEERPID)
+	if err != nil {
+		return 0, 0, 0, os.NewSyscallError("getsockopt", err)
+	}
+	return cred.Uid, gid, pid, nil
+}
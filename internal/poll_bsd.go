@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -41,15 +42,23 @@ type poller struct {
 	// fd is the file descriptor returned by calling kqueue().
 	fd int
 
-	// changes contains events we want to watch for
+	// changes contains events we want to watch for. The changes made by SetRead, SetWrite and the Del functions are
+	// submitted together, with the next Poll, in a single kevent call.
 	changes []syscall.Kevent_t
 
+	// pendingChanges indexes changes by file descriptor and filter, such that a change which undoes a pending one is
+	// merged with it rather than submitted along with it. See set.
+	pendingChanges map[changeKey]pendingChange
+
 	// events contains the events which occured.
 	// events is a subset of changelist.
 	events []syscall.Kevent_t
 
-	// waker is used to wake up the process when the client
-	// calls ioc.Post(...), thus dispatching the provided handler.
+	// trigger is the change which triggers the EVFILT_USER event registered on the kqueue, thus waking up the process
+	// when the client calls ioc.Post(...). It is nil if EVFILT_USER is not supported, in which case waker is used.
+	trigger []syscall.Kevent_t
+
+	// waker is used to wake up the process when EVFILT_USER is not supported.
 	// The read end of the pipe is registered for reads with kqueue.
 	waker *Pipe
 
@@ -58,6 +67,17 @@ type poller struct {
 	// entails writing a single byte to the write end of the wakeupPipe.
 	posts []func()
 
+	// dispatching holds the posts currently executed. It is swapped with posts on each dispatch such that handlers
+	// can Post without deadlocking; such posts are executed on the next dispatch.
+	dispatching []func()
+
+	// maxPosts bounds the length of posts. 0 means no bound.
+	maxPosts int
+
+	// unwoken is 1 if the last attempt to wake up the poller failed, in which case the next Post retries.
+	// Accessed atomically.
+	unwoken uint32
+
 	// lck synchronizes access to the handlers slice.
 	// This is needed because multiple goroutines can call ioc.Post(...)
 	// on the same IO object.
@@ -68,66 +88,114 @@ type poller struct {
 
 	// closed is true if the close() has been called on fd
 	closed uint32
+
+	// maxEvents is the size events is going to have on the next Poll call.
+	maxEvents int
+
+	// onError is invoked with the events Poll cannot dispatch. See SetErrorHandler.
+	onError func(fd int, err error)
+
+	// ready holds the edge-triggered slots with events set while their file descriptor may still be ready, which
+	// are dispatched by the next Poll, see Slot.EdgeTriggered. readying is swapped with ready on each dispatch.
+	ready    []*Slot
+	readying []*Slot
 }
 
 func NewPoller() (Poller, error) {
-	pipe, err := NewPipe()
+	kqueueFd, err := syscall.Kqueue()
 	if err != nil {
 		return nil, err
 	}
 
-	if err := pipe.SetReadNonblock(); err != nil {
-		return nil, err
+	p := &poller{
+		fd:             kqueueFd,
+		changes:        make([]syscall.Kevent_t, 0, 128),
+		pendingChanges: make(map[changeKey]pendingChange),
+		events:         make([]syscall.Kevent_t, DefaultMaxEvents),
+		maxEvents:      DefaultMaxEvents,
 	}
 
-	if err := pipe.SetWriteNonblock(); err != nil {
-		return nil, err
+	if err := p.setUserWaker(); err != nil {
+		// EVFILT_USER is not supported by this kernel, so fall back to a pipe.
+		if err := p.setPipeWaker(); err != nil {
+			_ = syscall.Close(kqueueFd)
+			return nil, err
+		}
 	}
 
-	kqueueFd, err := syscall.Kqueue()
-	if err != nil {
-		return nil, err
-	}
+	return p, nil
+}
 
-	_, err = syscall.Kevent(kqueueFd, []syscall.Kevent_t{{
-		Ident:  uint64(kqueueFd),
+// setUserWaker registers an EVFILT_USER event on the kqueue. Triggering it wakes up the poller without the read and
+// write syscalls of a pipe.
+func (p *poller) setUserWaker() error {
+	_, err := syscall.Kevent(p.fd, []syscall.Kevent_t{{
+		Ident:  uint64(p.fd),
 		Filter: syscall.EVFILT_USER,
 		Flags:  syscall.EV_ADD | syscall.EV_CLEAR,
 	}}, nil, nil)
 	if err != nil {
+		return os.NewSyscallError("kevent", err)
+	}
+
+	p.trigger = []syscall.Kevent_t{{
+		Ident:  uint64(p.fd),
+		Filter: syscall.EVFILT_USER,
+		Fflags: syscall.NOTE_TRIGGER,
+	}}
+	return nil
+}
+
+func (p *poller) setPipeWaker() error {
+	pipe, err := NewPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := pipe.SetReadNonblock(); err != nil {
 		_ = pipe.Close()
-		_ = syscall.Close(kqueueFd)
-		return nil, err
+		return err
 	}
 
-	p := &poller{
-		waker:   pipe,
-		fd:      kqueueFd,
-		changes: make([]syscall.Kevent_t, 0, 128),
-		events:  make([]syscall.Kevent_t, 128),
+	if err := pipe.SetWriteNonblock(); err != nil {
+		_ = pipe.Close()
+		return err
 	}
 
-	err = p.setRead(p.waker.ReadFd(), syscall.EV_ADD, &p.waker.slot)
-	if err != nil {
-		_ = p.waker.Close()
-		_ = syscall.Close(kqueueFd)
-		return nil, err
+	if err := p.setRead(pipe.ReadFd(), syscall.EV_ADD, &pipe.slot); err != nil {
+		_ = pipe.Close()
+		return err
 	}
 	p.pending-- // ignore the pipe read
 
-	return p, nil
+	p.waker = pipe
+	return nil
 }
 
 func (p *poller) Pending() int64 {
 	return p.pending
 }
 
+func (p *poller) SetMaxEvents(n int) {
+	if n < 1 {
+		n = 1
+	}
+	// events is resized at the start of the next Poll call as it might be iterated over right now.
+	p.maxEvents = n
+}
+
+func (p *poller) MaxEvents() int {
+	return p.maxEvents
+}
+
 func (p *poller) Close() error {
 	if !atomic.CompareAndSwapUint32(&p.closed, 0, 1) {
 		return io.EOF
 	}
 
-	_ = p.waker.Close()
+	if p.waker != nil {
+		_ = p.waker.Close()
+	}
 	return syscall.Close(p.fd)
 }
 
@@ -136,15 +204,65 @@ func (p *poller) Closed() bool {
 }
 
 func (p *poller) Post(handler func()) error {
+	if p.Closed() {
+		return sonicerrors.ErrClosed
+	}
+
 	p.lck.Lock()
+	if p.maxPosts > 0 && len(p.posts) >= p.maxPosts {
+		p.lck.Unlock()
+		return sonicerrors.ErrPostQueueFull
+	}
+	// Only the Post which makes the queue non-empty must wake up the poller. The poller drains the waker before
+	// taking the queue, so the handlers posted in the meantime are executed as well.
+	wake := len(p.posts) == 0 || atomic.LoadUint32(&p.unwoken) == 1
 	p.posts = append(p.posts, handler)
 	p.pending++
 	p.lck.Unlock()
 
-	// Concurrent writes are thread safe for pipes if less
-	// than 512 bytes are written.
+	if !wake {
+		return nil
+	}
+
+	if err := p.wakeup(); err != nil {
+		atomic.StoreUint32(&p.unwoken, 1)
+		return fmt.Errorf("%w: %w", sonicerrors.ErrWakeupFailed, err)
+	}
+	atomic.StoreUint32(&p.unwoken, 0)
+	return nil
+}
+
+func (p *poller) wakeup() error {
+	if p.trigger != nil {
+		if _, err := syscall.Kevent(p.fd, p.trigger, nil, nil); err != nil {
+			return os.NewSyscallError("kevent", err)
+		}
+		return nil
+	}
+
 	_, err := p.waker.Write(oneByte[:])
-	return err
+	if err == nil || err == syscall.EAGAIN {
+		// The pipe is full, so it is readable and the poller is woken up anyway.
+		return nil
+	}
+	return os.NewSyscallError("write", err)
+}
+
+func (p *poller) SetMaxPosts(n int) {
+	if n < 0 {
+		n = 0
+	}
+
+	p.lck.Lock()
+	p.maxPosts = n
+	p.lck.Unlock()
+}
+
+func (p *poller) MaxPosts() int {
+	p.lck.Lock()
+	defer p.lck.Unlock()
+
+	return p.maxPosts
 }
 
 func (p *poller) Posted() int {
@@ -161,8 +279,21 @@ func (p *poller) Poll(timeoutMs int) (n int, err error) {
 		timeout = &ts
 	}
 
+	if len(p.events) != p.maxEvents {
+		p.events = make([]syscall.Kevent_t, p.maxEvents)
+	}
+
+	readied := p.dispatchReady()
+	if readied > 0 || len(p.ready) > 0 {
+		timeout = &syscall.Timespec{}
+		timeoutMs = 0
+	}
+
 	changelist := p.changes
 	p.changes = p.changes[:0]
+	for key := range p.pendingChanges {
+		delete(p.pendingChanges, key)
+	}
 
 	n, err = syscall.Kevent(p.fd, changelist, p.events, timeout)
 
@@ -176,57 +307,137 @@ func (p *poller) Poll(timeoutMs int) (n int, err error) {
 		return n, errors.New("unknown kevent error")
 	}
 
-	if n == 0 && timeoutMs >= 0 {
+	if n == 0 && readied == 0 && timeoutMs >= 0 {
 		return n, sonicerrors.ErrTimeout
 	}
 
 	for i := 0; i < n; i++ {
 		event := &p.events[i]
 
+		if event.Filter == syscall.EVFILT_USER {
+			p.executePost()
+			continue
+		}
+
+		if event.Flags&syscall.EV_ERROR == syscall.EV_ERROR {
+			// A change from the changelist could not be applied.
+			p.reportError(int(event.Ident), os.NewSyscallError("kevent", syscall.Errno(event.Data)))
+			continue
+		}
+
 		events := -PollerEvent(event.Filter)
 
 		/* #nosec G103 -- the use of unsafe has been audited */
 		slot := (*Slot)(unsafe.Pointer(event.Udata))
 
-		if slot.Fd == p.waker.ReadFd() {
+		if p.waker != nil && slot.Fd == p.waker.ReadFd() {
+			p.drainWaker()
 			p.executePost()
 			continue
 		}
 
+		if slot.EdgeTriggered {
+			// Edges are reported whether or not events are set, so they are recorded for the next SetRead and
+			// SetWrite rather than reported as unsolicited.
+			slot.ready |= events
+			p.dispatchSlot(slot, events)
+			continue
+		}
+
+		dispatched := false
+
 		if events&slot.Events&PollerReadEvent == PollerReadEvent {
+			dispatched = true
 			p.pending--
 			slot.Events ^= PollerReadEvent
 			slot.Handlers[ReadEvent](nil)
 		}
 
 		if events&slot.Events&PollerWriteEvent == PollerWriteEvent {
+			dispatched = true
 			p.pending--
 			slot.Events ^= PollerWriteEvent
 			slot.Handlers[WriteEvent](nil)
 		}
+
+		if !dispatched {
+			p.reportError(slot.Fd, fmt.Errorf(
+				"%w: filter=%d registered=%#x",
+				sonicerrors.ErrUnsolicitedEvent, event.Filter, uint32(slot.Events),
+			))
+		}
 	}
 
-	return n, nil
+	return n + readied, nil
 }
 
-func (p *poller) executePost() {
+// dispatchReady dispatches the events set on edge-triggered slots while their file descriptor may still be ready,
+// returning the number of slots dispatched.
+func (p *poller) dispatchReady() (n int) {
+	if len(p.ready) == 0 {
+		return 0
+	}
+
+	p.ready, p.readying = p.readying[:0], p.ready
+	for i, slot := range p.readying {
+		if p.dispatchSlot(slot, slot.ready) {
+			n++
+		}
+		p.readying[i] = nil
+	}
+	return n
+}
+
+// dispatchSlot invokes the handlers of the given events set on the edge-triggered slot.
+func (p *poller) dispatchSlot(slot *Slot, events PollerEvent) (dispatched bool) {
+	if events&slot.Events&PollerReadEvent == PollerReadEvent {
+		dispatched = true
+		_ = p.DelRead(slot)
+		slot.Handlers[ReadEvent](nil)
+	}
+	if events&slot.Events&PollerWriteEvent == PollerWriteEvent {
+		dispatched = true
+		_ = p.DelWrite(slot)
+		slot.Handlers[WriteEvent](nil)
+	}
+	return dispatched
+}
+
+func (p *poller) SetErrorHandler(handler func(fd int, err error)) {
+	p.onError = handler
+}
+
+func (p *poller) reportError(fd int, err error) {
+	if p.onError != nil {
+		p.onError(fd, err)
+	}
+}
+
+func (p *poller) drainWaker() {
 	for {
 		_, err := p.waker.Read(oneByte[:])
 		if err != nil {
 			break
 		}
 	}
+}
 
+func (p *poller) executePost() {
 	p.lck.Lock()
-	for _, handler := range p.posts {
+	p.posts, p.dispatching = p.dispatching[:0], p.posts
+	p.pending -= int64(len(p.dispatching))
+	p.lck.Unlock()
+
+	for i, handler := range p.dispatching {
 		handler()
-		p.pending--
+		p.dispatching[i] = nil
 	}
-	p.posts = p.posts[:0]
-	p.lck.Unlock()
 }
 
 func (p *poller) SetRead(slot *Slot) error {
+	if slot.EdgeTriggered {
+		return p.setEdgeTriggered(slot, PollerReadEvent)
+	}
 	return p.setRead(slot.Fd, syscall.EV_ADD|syscall.EV_ONESHOT, slot)
 }
 
@@ -241,6 +452,10 @@ func (p *poller) setRead(fd int, flags uint16, slot *Slot) error {
 }
 
 func (p *poller) SetWrite(slot *Slot) error {
+	if slot.EdgeTriggered {
+		return p.setEdgeTriggered(slot, PollerWriteEvent)
+	}
+
 	events := &slot.Events
 	if *events&PollerWriteEvent != PollerWriteEvent {
 		p.pending++
@@ -250,11 +465,34 @@ func (p *poller) SetWrite(slot *Slot) error {
 	return nil
 }
 
+func (p *poller) setEdgeTriggered(slot *Slot, flag PollerEvent) error {
+	if !slot.registered {
+		// Both filters stay registered until the file descriptor is closed. It may be ready already, as no operation
+		// would block yet.
+		_ = p.set(slot.Fd, createEvent(syscall.EV_ADD|syscall.EV_CLEAR, -PollerReadEvent, slot, 0))
+		_ = p.set(slot.Fd, createEvent(syscall.EV_ADD|syscall.EV_CLEAR, -PollerWriteEvent, slot, 0))
+		slot.registered = true
+		slot.ready = PollerReadEvent | PollerWriteEvent
+	}
+
+	if slot.Events&flag != flag {
+		p.pending++
+		slot.Events |= flag
+		if slot.ready&flag == flag {
+			p.ready = append(p.ready, slot)
+		}
+	}
+	return nil
+}
+
 func (p *poller) DelRead(slot *Slot) error {
 	events := &slot.Events
 	if *events&PollerReadEvent == PollerReadEvent {
 		p.pending--
 		*events ^= PollerReadEvent
+		if slot.EdgeTriggered {
+			return nil
+		}
 		return p.set(slot.Fd, createEvent(syscall.EV_DELETE, -PollerReadEvent, slot, 0))
 	}
 	return nil
@@ -265,12 +503,25 @@ func (p *poller) DelWrite(slot *Slot) error {
 	if *events&PollerWriteEvent == PollerWriteEvent {
 		p.pending--
 		*events ^= PollerWriteEvent
+		if slot.EdgeTriggered {
+			return nil
+		}
 		return p.set(slot.Fd, createEvent(syscall.EV_DELETE, -PollerWriteEvent, slot, 0))
 	}
 	return nil
 }
 
 func (p *poller) Del(slot *Slot) error {
+	if slot.EdgeTriggered {
+		_ = p.DelRead(slot)
+		_ = p.DelWrite(slot)
+		if slot.registered {
+			slot.registered = false
+			return p.delEdgeTriggered(slot)
+		}
+		return nil
+	}
+
 	err := p.DelRead(slot)
 	if err == nil {
 		return p.DelWrite(slot)
@@ -278,12 +529,88 @@ func (p *poller) Del(slot *Slot) error {
 	return nil
 }
 
+// delEdgeTriggered removes the filters of an edge-triggered slot right away, as the file descriptor is typically
+// closed next, which would fail the deletion if it were left in the changelist.
+func (p *poller) delEdgeTriggered(slot *Slot) error {
+	if p.drop(changeKey{uint64(slot.Fd), -int16(PollerReadEvent)}) {
+		// The filters were not added yet.
+		p.drop(changeKey{uint64(slot.Fd), -int16(PollerWriteEvent)})
+		return nil
+	}
+
+	dels := []syscall.Kevent_t{
+		createEvent(syscall.EV_DELETE, -PollerReadEvent, slot, 0),
+		createEvent(syscall.EV_DELETE, -PollerWriteEvent, slot, 0),
+	}
+	for i := range dels {
+		dels[i].Ident = uint64(slot.Fd)
+	}
+	_, err := syscall.Kevent(p.fd, dels, nil, nil)
+	if err != nil {
+		return os.NewSyscallError("kevent", err)
+	}
+	return nil
+}
+
+type changeKey struct {
+	ident  uint64
+	filter int16
+}
+
+type pendingChange struct {
+	index int // of the change in changes
+
+	// registered is true if the filter is registered with the kqueue, which is not the case if the change adds it.
+	registered bool
+}
+
+// set adds the change ev of the filter of fd to the changelist, merging it with the pending change of the same filter,
+// if any. Slots are added and deleted as connections come and go, so a filter added and then deleted before the
+// next Poll is never submitted to the kernel, and a filter deleted and then added again is only updated.
 func (p *poller) set(fd int, ev syscall.Kevent_t) error {
 	ev.Ident = uint64(fd)
-	p.changes = append(p.changes, ev)
+	key := changeKey{ev.Ident, ev.Filter}
+
+	pending, ok := p.pendingChanges[key]
+	if !ok {
+		p.pendingChanges[key] = pendingChange{
+			index:      len(p.changes),
+			registered: ev.Flags&syscall.EV_ADD == 0,
+		}
+		p.changes = append(p.changes, ev)
+		return nil
+	}
+
+	if ev.Flags&syscall.EV_DELETE == syscall.EV_DELETE && !pending.registered {
+		// The filter is deleted before being added.
+		p.drop(key)
+		return nil
+	}
+	p.changes[pending.index] = ev
 	return nil
 }
 
+// drop removes the pending change of the given filter, returning false if there is none.
+func (p *poller) drop(key changeKey) bool {
+	pending, ok := p.pendingChanges[key]
+	if !ok {
+		return false
+	}
+	delete(p.pendingChanges, key)
+
+	last := len(p.changes) - 1
+	if pending.index != last {
+		moved := p.changes[last]
+		p.changes[pending.index] = moved
+		movedKey := changeKey{moved.Ident, moved.Filter}
+		movedPending := p.pendingChanges[movedKey]
+		movedPending.index = pending.index
+		p.pendingChanges[movedKey] = movedPending
+	}
+	p.changes = p.changes[:last]
+	return true
+}
+
 func createEvent(flags uint16, filter PollerEvent, slot *Slot, dur time.Duration) syscall.Kevent_t {
 	ev := syscall.Kevent_t{
 		Flags:  flags,
@@ -0,0 +1,69 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package internal
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+func TestPollerMergesChanges(t *testing.T) {
+	pp, err := NewPoller()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pp.Close()
+	p := pp.(*poller)
+
+	var fds [2]int
+	if err := syscall.Pipe(fds[:]); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+
+	slot := &Slot{Fd: fds[0]}
+	slot.Set(ReadEvent, func(error) {})
+	base := len(p.changes)
+
+	// A filter added and deleted before the next Poll is never submitted.
+	if err := p.SetRead(slot); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.DelRead(slot); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.changes) != base {
+		t.Fatalf("expected the changes to cancel out got=%d", len(p.changes)-base)
+	}
+
+	// Once submitted, deleting and adding the filter again is a single update.
+	if err := p.SetRead(slot); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Poll(0); err != nil && err != sonicerrors.ErrTimeout {
+		t.Fatal(err)
+	}
+	if err := p.DelRead(slot); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetRead(slot); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.changes) != 1 || p.changes[0].Flags&syscall.EV_ADD != syscall.EV_ADD {
+		t.Fatalf("expected a single add got=%v", p.changes)
+	}
+
+	// Deleting it again is submitted, as the filter is registered.
+	if err := p.DelRead(slot); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.changes) != 1 || p.changes[0].Flags&syscall.EV_DELETE != syscall.EV_DELETE {
+		t.Fatalf("expected a single delete got=%v", p.changes)
+	}
+	if _, err := p.Poll(0); err != nil && err != sonicerrors.ErrTimeout {
+		t.Fatal(err)
+	}
+}
@@ -20,6 +20,9 @@ type PollerEvent uint32
 const (
 	PollerReadEvent  = PollerEvent(syscall.EPOLLIN)
 	PollerWriteEvent = PollerEvent(syscall.EPOLLOUT)
+
+	// pollerEdgeTriggered is EPOLLET, which the syscall package defines as a negative constant.
+	pollerEdgeTriggered = PollerEvent(1 << 31)
 )
 
 func init() {
@@ -65,6 +68,17 @@ type poller struct {
 	// entails writing a single byte to the write end of the wakeupPipe.
 	posts []func()
 
+	// dispatching holds the posts currently executed. It is swapped with posts on each dispatch such that handlers
+	// can Post without deadlocking; such posts are executed on the next dispatch.
+	dispatching []func()
+
+	// maxPosts bounds the length of posts. 0 means no bound.
+	maxPosts int
+
+	// unwoken is 1 if the last attempt to wake up the poller failed, in which case the next Post retries.
+	// Accessed atomically.
+	unwoken uint32
+
 	// lck synchronizes access to the posts slice.
 	// This is needed because multiple goroutines can call ioc.Post(...)
 	// on the same IO object.
@@ -76,6 +90,17 @@ type poller struct {
 	// closed is true if the close() has been called on fd
 	closed uint32
 
+	// maxEvents is the size events is going to have on the next Poll call.
+	maxEvents int
+
+	// onError is invoked with the events Poll cannot dispatch. See SetErrorHandler.
+	onError func(fd int, err error)
+
+	// ready holds the edge-triggered slots with events set while their file descriptor may still be ready, which
+	// are dispatched by the next Poll, see Slot.EdgeTriggered. readying is swapped with ready on each dispatch.
+	ready    []*Slot
+	readying []*Slot
+
 	// TODO proper waker interface
 	wakerBytes [8]byte
 }
@@ -93,9 +118,10 @@ func NewPoller() (Poller, error) {
 	}
 
 	p := &poller{
-		fd:     epollFd,
-		waker:  eventFd,
-		events: make([]Event, 128),
+		fd:        epollFd,
+		waker:     eventFd,
+		events:    make([]Event, DefaultMaxEvents),
+		maxEvents: DefaultMaxEvents,
 	}
 
 	err = p.SetRead(p.waker.Slot())
@@ -114,6 +140,18 @@ func (p *poller) Pending() int64 {
 	return p.pending
 }
 
+func (p *poller) SetMaxEvents(n int) {
+	if n < 1 {
+		n = 1
+	}
+	// events is resized at the start of the next Poll call as it might be iterated over right now.
+	p.maxEvents = n
+}
+
+func (p *poller) MaxEvents() int {
+	return p.maxEvents
+}
+
 func (p *poller) Close() error {
 	if !atomic.CompareAndSwapUint32(&p.closed, 0, 1) {
 		return io.EOF
@@ -128,14 +166,52 @@ func (p *poller) Closed() bool {
 }
 
 func (p *poller) Post(handler func()) error {
+	if p.Closed() {
+		return sonicerrors.ErrClosed
+	}
+
 	p.lck.Lock()
+	if p.maxPosts > 0 && len(p.posts) >= p.maxPosts {
+		p.lck.Unlock()
+		return sonicerrors.ErrPostQueueFull
+	}
+	// Only the Post which makes the queue non-empty must wake up the poller. The poller drains the waker before
+	// taking the queue, so the handlers posted in the meantime are executed as well.
+	wake := len(p.posts) == 0 || atomic.LoadUint32(&p.unwoken) == 1
 	p.posts = append(p.posts, handler)
 	p.pending++
 	p.lck.Unlock()
 
-	// Concurrent writes are thread safe for eventfds.
+	if !wake {
+		return nil
+	}
+
 	_, err := p.waker.Write(1)
-	return err
+	if err == nil || err == syscall.EAGAIN {
+		// The eventfd is a counter, so a failed write can only mean it is about to overflow, in which case it is
+		// readable and the poller is woken up anyway.
+		atomic.StoreUint32(&p.unwoken, 0)
+		return nil
+	}
+	atomic.StoreUint32(&p.unwoken, 1)
+	return fmt.Errorf("%w: %w", sonicerrors.ErrWakeupFailed, os.NewSyscallError("write", err))
+}
+
+func (p *poller) SetMaxPosts(n int) {
+	if n < 0 {
+		n = 0
+	}
+
+	p.lck.Lock()
+	p.maxPosts = n
+	p.lck.Unlock()
+}
+
+func (p *poller) MaxPosts() int {
+	p.lck.Lock()
+	defer p.lck.Unlock()
+
+	return p.maxPosts
 }
 
 func (p *poller) Posted() int {
@@ -146,6 +222,15 @@ func (p *poller) Posted() int {
 }
 
 func (p *poller) Poll(timeoutMs int) (n int, err error) {
+	if len(p.events) != p.maxEvents {
+		p.events = make([]Event, p.maxEvents)
+	}
+
+	readied := p.dispatchReady()
+	if readied > 0 || len(p.ready) > 0 {
+		timeoutMs = 0
+	}
+
 	/* #nosec G103 -- the use of unsafe has been audited */
 	nn, _, errno := syscall.Syscall6(
 		syscall.SYS_EPOLL_WAIT,
@@ -170,7 +255,7 @@ func (p *poller) Poll(timeoutMs int) (n int, err error) {
 		return n, errors.New("unknown epoll_wait error")
 	}
 
-	if n == 0 && timeoutMs >= 0 {
+	if n == 0 && readied == 0 && timeoutMs >= 0 {
 		return n, sonicerrors.ErrTimeout
 	}
 
@@ -186,20 +271,83 @@ func (p *poller) Poll(timeoutMs int) (n int, err error) {
 			continue
 		}
 
+		if slot.EdgeTriggered {
+			// Edges are reported whether or not events are set, so they are recorded for the next SetRead and
+			// SetWrite rather than reported as unsolicited.
+			slot.ready |= events & (PollerReadEvent | PollerWriteEvent)
+			p.dispatchSlot(slot, events)
+			continue
+		}
+
+		dispatched := false
+
 		if events&slot.Events&PollerReadEvent == PollerReadEvent {
-			// TODO this errors should be reported
-			_ = p.DelRead(slot)
+			dispatched = true
+			if err := p.DelRead(slot); err != nil {
+				p.reportError(slot.Fd, err)
+			}
 			slot.Handlers[ReadEvent](nil)
 		}
 
 		if events&slot.Events&PollerWriteEvent == PollerWriteEvent {
-			// TODO this errors should be reported
-			_ = p.DelWrite(slot)
+			dispatched = true
+			if err := p.DelWrite(slot); err != nil {
+				p.reportError(slot.Fd, err)
+			}
 			slot.Handlers[WriteEvent](nil)
 		}
+
+		if !dispatched {
+			p.reportError(slot.Fd, fmt.Errorf(
+				"%w: events=%#x registered=%#x",
+				sonicerrors.ErrUnsolicitedEvent, uint32(events), uint32(slot.Events),
+			))
+		}
+	}
+
+	return n + readied, nil
+}
+
+// dispatchReady dispatches the events set on edge-triggered slots while their file descriptor may still be ready,
+// returning the number of slots dispatched.
+func (p *poller) dispatchReady() (n int) {
+	if len(p.ready) == 0 {
+		return 0
 	}
 
-	return n, nil
+	p.ready, p.readying = p.readying[:0], p.ready
+	for i, slot := range p.readying {
+		if p.dispatchSlot(slot, slot.ready) {
+			n++
+		}
+		p.readying[i] = nil
+	}
+	return n
+}
+
+// dispatchSlot invokes the handlers of the given events set on the edge-triggered slot.
+func (p *poller) dispatchSlot(slot *Slot, events PollerEvent) (dispatched bool) {
+	if events&slot.Events&PollerReadEvent == PollerReadEvent {
+		dispatched = true
+		_ = p.DelRead(slot)
+		slot.Handlers[ReadEvent](nil)
+	}
+	if events&slot.Events&PollerWriteEvent == PollerWriteEvent {
+		dispatched = true
+		_ = p.DelWrite(slot)
+		slot.Handlers[WriteEvent](nil)
+	}
+	return dispatched
+}
+
+func (p *poller) SetErrorHandler(handler func(fd int, err error)) {
+	p.onError = handler
+}
+
+func (p *poller) reportError(fd int, err error) {
+	if p.onError != nil {
+		p.onError(fd, err)
+	}
 }
 
 func (p *poller) dispatch() {
@@ -211,12 +359,14 @@ func (p *poller) dispatch() {
 	}
 
 	p.lck.Lock()
-	for _, handler := range p.posts {
+	p.posts, p.dispatching = p.dispatching[:0], p.posts
+	p.pending -= int64(len(p.dispatching))
+	p.lck.Unlock()
+
+	for i, handler := range p.dispatching {
 		handler()
-		p.pending--
+		p.dispatching[i] = nil
 	}
-	p.posts = p.posts[:0]
-	p.lck.Unlock()
 }
 
 func (p *poller) SetRead(slot *Slot) error {
@@ -228,6 +378,10 @@ func (p *poller) SetWrite(slot *Slot) error {
 }
 
 func (p *poller) setRW(fd int, slot *Slot, flag PollerEvent) error {
+	if slot.EdgeTriggered {
+		return p.setEdgeTriggered(slot, flag)
+	}
+
 	events := &slot.Events
 	if *events&flag != flag {
 		p.pending++
@@ -243,6 +397,27 @@ func (p *poller) setRW(fd int, slot *Slot, flag PollerEvent) error {
 	return nil
 }
 
+func (p *poller) setEdgeTriggered(slot *Slot, flag PollerEvent) error {
+	if !slot.registered {
+		// The file descriptor may be ready already, as no operation would block yet.
+		err := p.add(slot.Fd, createEvent(PollerReadEvent|PollerWriteEvent|pollerEdgeTriggered, slot))
+		if err != nil {
+			return err
+		}
+		slot.registered = true
+		slot.ready = PollerReadEvent | PollerWriteEvent
+	}
+
+	if slot.Events&flag != flag {
+		p.pending++
+		slot.Events |= flag
+		if slot.ready&flag == flag {
+			p.ready = append(p.ready, slot)
+		}
+	}
+	return nil
+}
+
 func (p *poller) add(fd int, event Event) error {
 	/* #nosec G103 -- the use of unsafe has been audited */
 	_, _, errno := syscall.Syscall6(
@@ -277,6 +452,16 @@ func (p *poller) modify(fd int, event Event) error {
 }
 
 func (p *poller) Del(slot *Slot) error {
+	if slot.EdgeTriggered {
+		_ = p.DelRead(slot)
+		_ = p.DelWrite(slot)
+		if slot.registered {
+			slot.registered = false
+			return p.del(slot.Fd)
+		}
+		return nil
+	}
+
 	err := p.DelRead(slot)
 	if err == nil {
 		return p.DelWrite(slot)
@@ -289,6 +474,9 @@ func (p *poller) DelRead(slot *Slot) error {
 	if *events&PollerReadEvent == PollerReadEvent {
 		p.pending--
 		*events ^= PollerReadEvent
+		if slot.EdgeTriggered {
+			return nil
+		}
 		if *events != 0 {
 			return p.modify(slot.Fd, createEvent(*events, slot))
 		}
@@ -302,6 +490,9 @@ func (p *poller) DelWrite(slot *Slot) error {
 	if *events&PollerWriteEvent == PollerWriteEvent {
 		p.pending--
 		*events ^= PollerWriteEvent
+		if slot.EdgeTriggered {
+			return nil
+		}
 		if *events != 0 {
 			return p.modify(slot.Fd, createEvent(*events, slot))
 		}
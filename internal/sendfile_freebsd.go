@@ -0,0 +1,39 @@
+//go:build freebsd && (amd64 || arm64 || riscv64)
+
+package internal
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// sfNoDiskIO makes sendfile fail with EBUSY, instead of blocking, if the bytes to send are not in the page cache.
+const sfNoDiskIO = 0x00000001
+
+// Sendfile writes at most n bytes of the file in, starting at offset off, to out with a single sendfile(2) call and
+// returns the number of bytes written. The offset of in is not changed.
+//
+// The call never blocks on disk I/O: syscall.EBUSY is returned if the bytes are not in the page cache yet, in which
+// case the caller should read them itself.
+func Sendfile(out, in int, off int64, n int) (int, error) {
+	var written int64
+	_, _, errno := syscall.Syscall9(
+		syscall.SYS_SENDFILE,
+		uintptr(in), uintptr(out), uintptr(off), uintptr(n),
+		0, uintptr(unsafe.Pointer(&written)), sfNoDiskIO, 0, 0)
+	var err error
+	if errno != 0 {
+		err = errno
+	}
+	return sendfileResult(int(written), err)
+}
+
+func sendfileResult(written int, err error) (int, error) {
+	if written > 0 && (err == syscall.EAGAIN || err == syscall.EWOULDBLOCK || err == syscall.EBUSY) {
+		err = nil
+	}
+	if written < 0 {
+		written = 0
+	}
+	return written, err
+}
@@ -0,0 +1,28 @@
+//go:build linux || darwin || netbsd || openbsd || dragonfly || (freebsd && !(amd64 || arm64 || riscv64))
+
+package internal
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Sendfile writes at most n bytes of the file in, starting at offset off, to out with a single sendfile(2) call and
+// returns the number of bytes written. The offset of in is not changed.
+func Sendfile(out, in int, off int64, n int) (int, error) {
+	written, err := unix.Sendfile(out, in, &off, n)
+	return sendfileResult(written, err)
+}
+
+// sendfileResult reports the bytes written before a nonblocking out filled up as a successful short write. The BSDs
+// return them along with EAGAIN, while Linux only returns EAGAIN if nothing was written.
+func sendfileResult(written int, err error) (int, error) {
+	if written > 0 && (err == syscall.EAGAIN || err == syscall.EWOULDBLOCK) {
+		err = nil
+	}
+	if written < 0 {
+		written = 0
+	}
+	return written, err
+}
@@ -3,6 +3,7 @@ package internal
 import (
 	"errors"
 	"fmt"
+	"math/rand" //#nosec G404 -- used to pick a starting port
 	"net"
 	"os"
 	"syscall"
@@ -19,16 +20,86 @@ var (
 	errUnknownNetwork = errors.New("unknown network argument")
 )
 
-func maybeBindBeforeConnect(fd int, opts ...sonicopts.Option) error {
+func maybeBindBeforeConnect(fd int, ports *portRange, opts ...sonicopts.Option) error {
 	for _, opt := range opts {
-		if opt.Type() == sonicopts.TypeBindSocket {
+		switch opt.Type() {
+		case sonicopts.TypeBindSocket:
 			addr := opt.Value().(net.Addr)
-			return syscall.Bind(fd, ToSockaddr(addr))
+			if err := syscall.Bind(fd, ToSockaddr(addr)); err != nil {
+				err = os.NewSyscallError("bind", err)
+				if tcp, ok := addr.(*net.TCPAddr); ok && tcp.Port == 0 && errors.Is(err, syscall.EADDRINUSE) {
+					// The kernel could not pick an ephemeral port.
+					err = portsExhausted(err)
+				}
+				return err
+			}
+			return nil
+		case sonicopts.TypeBindPortRange:
+			return ports.bind(fd)
+		}
+	}
+	return nil
+}
+
+// portRange binds the sockets of a dial to the ports of a sonicopts.PortRange in turn, starting from a random port
+// such that concurrent dialers do not all contend for the lowest port. Each port is tried once per dial.
+type portRange struct {
+	r     sonicopts.PortRange
+	start int
+	tried int
+}
+
+// newPortRange returns the portRange of the sonicopts.BindPortRange option in opts, or nil if there is none.
+func newPortRange(opts []sonicopts.Option) *portRange {
+	for _, opt := range opts {
+		if opt.Type() == sonicopts.TypeBindPortRange {
+			p := &portRange{r: opt.Value().(sonicopts.PortRange)}
+			if n := p.size(); n > 0 {
+				/* #nosec G404 -- the starting port does not need a cryptographically secure source */
+				p.start = rand.Intn(n)
+			}
+			return p
 		}
 	}
 	return nil
 }
 
+func (p *portRange) size() int {
+	return p.r.High - p.r.Low + 1
+}
+
+// bind binds the socket to the next free port of the range.
+func (p *portRange) bind(fd int) error {
+	r := p.r
+	if r.Low <= 0 || r.High > 65535 || r.Low > r.High {
+		return fmt.Errorf("invalid port range [%d, %d]", r.Low, r.High)
+	}
+
+	addr := &net.TCPAddr{IP: r.IP}
+	for n := p.size(); p.tried < n; {
+		addr.Port = r.Low + (p.start+p.tried)%n
+		p.tried++
+		err := syscall.Bind(fd, ToSockaddr(addr))
+		if err == nil {
+			return nil
+		}
+		if err != syscall.EADDRINUSE {
+			return os.NewSyscallError("bind", err)
+		}
+	}
+	return portsExhausted(os.NewSyscallError(fmt.Sprintf("bind port_range=[%d, %d]", r.Low, r.High), syscall.EADDRINUSE))
+}
+
+// retry returns true if a dial from the range, whose connect failed with err, can be retried from the next port of the
+// range. With SO_REUSEADDR, binding to a port used by another connection succeeds, and connect then fails with
+// EADDRNOTAVAIL if that connection has the same peer, or EADDRINUSE on some platforms.
+func (p *portRange) retry(err error) bool {
+	if p == nil || p.tried >= p.size() {
+		return false
+	}
+	return errors.Is(err, syscall.EADDRNOTAVAIL) || errors.Is(err, syscall.EADDRINUSE)
+}
+
 func socket(domain, socketType, proto int, nonblock bool) (fd int, err error) {
 	fd, err = syscall.Socket(domain, socketType, proto)
 	if err != nil {
@@ -52,14 +123,14 @@ func CreateSocketTCP(
 			return -1, nil, err
 		}
 	}
+	if tcpAddr.IP == nil {
+		tcpAddr.IP = unspecifiedIP(network)
+	}
 
 	domain, socketType := syscall.AF_INET, syscall.SOCK_STREAM
-	if len(tcpAddr.Zone) > 0 {
+	if IsIPv6(tcpAddr.IP) {
 		domain = syscall.AF_INET6
 	}
-	if tcpAddr.IP.IsUnspecified() {
-		domain = syscall.AF_UNSPEC
-	}
 
 	fd, err = socket(domain, socketType, 0, nonblocking)
 
@@ -77,15 +148,14 @@ func CreateSocketUDP(network, addr string) (fd int, udpAddr *net.UDPAddr, err er
 			return -1, nil, err
 		}
 	}
+	if udpAddr.IP == nil {
+		udpAddr.IP = unspecifiedIP(network)
+	}
 
 	domain, socketType := syscall.AF_INET, syscall.SOCK_DGRAM
-	if len(udpAddr.Zone) > 0 {
+	if IsIPv6(udpAddr.IP) {
 		domain = syscall.AF_INET6
 	}
-	// TODO why this fails?
-	//if udpAddr.IP.IsUnspecified() {
-	//	domain = syscall.AF_UNSPEC
-	//}
 
 	fd, err = socket(domain, socketType, 0, true)
 
@@ -93,7 +163,8 @@ func CreateSocketUDP(network, addr string) (fd int, udpAddr *net.UDPAddr, err er
 }
 
 // Connect connects to the specified endpoint. The created connection can be optionally bound to a local address
-// by passing the option sonicopts.BindBeforeConnect(to net.Addr)
+// by passing the option sonicopts.BindSocket(to net.Addr), or to a port from a range by passing the option
+// sonicopts.BindPortRange(ip, low, high).
 //
 // If network is of type UDP, then addr is the address to which datagrams are sent by default, and the only address from
 // which datagrams are received.
@@ -115,18 +186,18 @@ func ConnectTimeout(
 	case "udp":
 		return ConnectUDP(network, addr, timeout, opts...)
 	case "uni":
-		return -1, nil, nil, fmt.Errorf("unix domain not supported")
+		return ConnectUnix(network, addr, timeout, opts...)
 	default:
 		return -1, nil, nil, errUnknownNetwork
 	}
 }
 
-func connect(fd int, remoteAddr net.Addr, timeout time.Duration, opts ...sonicopts.Option) error {
+func connect(fd int, remoteAddr net.Addr, timeout time.Duration, ports *portRange, opts ...sonicopts.Option) error {
 	if err := ApplyOpts(fd, opts...); err != nil {
 		return err
 	}
 
-	if err := maybeBindBeforeConnect(fd, opts...); err != nil {
+	if err := maybeBindBeforeConnect(fd, ports, opts...); err != nil {
 		return err
 	}
 
@@ -134,7 +205,7 @@ func connect(fd int, remoteAddr net.Addr, timeout time.Duration, opts ...sonicop
 		// this can happen if the socket is nonblocking, so we fix it with a select
 		// https://man7.org/linux/man-pages/man2/connect.2.html#EINPROGRESS
 		if err != syscall.EINPROGRESS && err != syscall.EAGAIN {
-			return os.NewSyscallError("connect", err)
+			return connectError(err)
 		}
 
 		var fds unix.FdSet
@@ -151,27 +222,93 @@ func connect(fd int, remoteAddr net.Addr, timeout time.Duration, opts ...sonicop
 			return sonicerrors.ErrTimeout
 		}
 
-		_, err = syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
+		// The socket is also writable if the connection failed, e.g. because it was refused.
+		return FinishConnect(fd)
+	}
+
+	return nil
+}
+
+// StartConnectTCP creates a nonblocking TCP socket and starts connecting it to addr. If inProgress is true, the
+// connection completes once the socket becomes writable, at which point FinishConnect must be called.
+func StartConnectTCP(
+	network, addr string,
+	opts ...sonicopts.Option,
+) (fd int, remoteAddr net.Addr, inProgress bool, err error) {
+	ports := newPortRange(opts)
+	for {
+		fd, remoteAddr, err = CreateSocketTCP(network, addr, true)
 		if err != nil {
-			return os.NewSyscallError("getsockopt", err)
+			return -1, nil, false, err
+		}
+
+		err = ApplyOpts(fd, opts...)
+		if err == nil {
+			err = maybeBindBeforeConnect(fd, ports, opts...)
+		}
+		if err == nil {
+			err = syscall.Connect(fd, ToSockaddr(remoteAddr))
+			if err == syscall.EINPROGRESS || err == syscall.EAGAIN {
+				return fd, remoteAddr, true, nil
+			} else if err != nil {
+				err = connectError(err)
+			}
+		}
+		if err == nil {
+			return fd, remoteAddr, false, nil
+		}
+		_ = syscall.Close(fd)
+		if !ports.retry(err) {
+			return -1, nil, false, err
 		}
 	}
+}
 
+// FinishConnect returns the outcome of a connect started by StartConnectTCP, once the socket is writable.
+func FinishConnect(fd int) error {
+	soErr, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
+	if err != nil {
+		return os.NewSyscallError("getsockopt", err)
+	}
+	if soErr != 0 {
+		return connectError(syscall.Errno(soErr))
+	}
 	return nil
 }
 
+// connectError returns the error of a failed connect. EADDRNOTAVAIL means no ephemeral port was left to connect from.
+func connectError(err error) error {
+	if err == syscall.EADDRNOTAVAIL {
+		return portsExhausted(os.NewSyscallError("connect", err))
+	}
+	return os.NewSyscallError("connect", err)
+}
+
+// portsExhausted wraps err, which failed a dial, with sonicerrors.ErrPortsExhausted.
+func portsExhausted(err error) error {
+	return fmt.Errorf("%w: %w", sonicerrors.ErrPortsExhausted, err)
+}
+
 func ConnectTCP(
 	network, addr string,
 	timeout time.Duration,
 	opts ...sonicopts.Option,
 ) (fd int, localAddr, remoteAddr net.Addr, err error) {
-	fd, remoteAddr, err = CreateSocketTCP(network, addr, true)
-	if err != nil {
-		return -1, nil, nil, err
-	}
+	ports := newPortRange(opts)
+	for {
+		fd, remoteAddr, err = CreateSocketTCP(network, addr, true)
+		if err != nil {
+			return -1, nil, nil, err
+		}
 
-	if err := connect(fd, remoteAddr, timeout, opts...); err != nil {
-		return -1, nil, nil, err
+		err = connect(fd, remoteAddr, timeout, ports, opts...)
+		if err == nil {
+			break
+		}
+		_ = syscall.Close(fd)
+		if !ports.retry(err) {
+			return -1, nil, nil, err
+		}
 	}
 
 	localAddr, err = SocketAddress(fd)
@@ -188,7 +325,8 @@ func ConnectUDP(
 		return -1, nil, nil, err
 	}
 
-	if err := connect(fd, remoteAddr, timeout, opts...); err != nil {
+	if err := connect(fd, remoteAddr, timeout, newPortRange(opts), opts...); err != nil {
+		_ = syscall.Close(fd)
 		return -1, nil, nil, err
 	}
 
@@ -200,6 +338,9 @@ func Listen(network, addr string, opts ...sonicopts.Option) (int, net.Addr, erro
 	if network[:3] != "tcp" && network[:3] != "uni" {
 		return -1, nil, fmt.Errorf("network %s not supported", network[:3])
 	}
+	if network[:3] == "uni" {
+		return listenUnix(network, addr, opts...)
+	}
 
 	// TODO unix datagram as well, not only TCP
 	fd, localAddr, err := CreateSocketTCP(network, addr, false)
@@ -212,6 +353,14 @@ func Listen(network, addr string, opts ...sonicopts.Option) (int, net.Addr, erro
 		return -1, nil, err
 	}
 
+	if IsIPv6(localAddr.IP) {
+		// IPv6 listeners only accept IPv6 connections, such that an IPv4 listener can be bound to the same port.
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, 1); err != nil {
+			_ = syscall.Close(fd)
+			return -1, nil, os.NewSyscallError("ipv6_v6only", err)
+		}
+	}
+
 	if err := syscall.Bind(fd, ToSockaddr(localAddr)); err != nil {
 		_ = syscall.Close(fd)
 		return -1, nil, os.NewSyscallError("bind", err)
@@ -225,6 +374,113 @@ func Listen(network, addr string, opts ...sonicopts.Option) (int, net.Addr, erro
 	return fd, localAddr, nil
 }
 
+// unixSocketType returns the socket type of the unix domain network: SOCK_STREAM for unix and SOCK_DGRAM for
+// unixgram.
+func unixSocketType(network string) (int, error) {
+	switch network {
+	case "unix":
+		return syscall.SOCK_STREAM, nil
+	case "unixgram":
+		return syscall.SOCK_DGRAM, nil
+	default:
+		return -1, fmt.Errorf("network %s not supported", network)
+	}
+}
+
+// ConnectUnix connects a unix domain socket of the given network, unix or unixgram, to the socket bound to path.
+func ConnectUnix(
+	network, path string,
+	timeout time.Duration,
+	opts ...sonicopts.Option,
+) (fd int, localAddr, remoteAddr net.Addr, err error) {
+	socketType, err := unixSocketType(network)
+	if err != nil {
+		return -1, nil, nil, err
+	}
+
+	fd, err = socket(syscall.AF_UNIX, socketType, 0, true)
+	if err != nil {
+		return -1, nil, nil, err
+	}
+
+	remoteAddr = &net.UnixAddr{Name: path, Net: network}
+	if err := connect(fd, remoteAddr, timeout, newPortRange(opts), opts...); err != nil {
+		_ = syscall.Close(fd)
+		return -1, nil, nil, err
+	}
+
+	localAddr, err = SocketAddress(fd)
+	if err != nil {
+		_ = syscall.Close(fd)
+		return -1, nil, nil, err
+	}
+	if addr, ok := localAddr.(*net.UnixAddr); ok {
+		addr.Net = network
+	}
+	return fd, localAddr, remoteAddr, nil
+}
+
+// ListenUnixgram creates a nonblocking unix datagram socket bound to path.
+func ListenUnixgram(path string, opts ...sonicopts.Option) (int, net.Addr, error) {
+	fd, err := socket(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0, true)
+	if err != nil {
+		return -1, nil, err
+	}
+
+	if err := ApplyOpts(fd, opts...); err != nil {
+		_ = syscall.Close(fd)
+		return -1, nil, err
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrUnix{Name: path}); err != nil {
+		_ = syscall.Close(fd)
+		return -1, nil, os.NewSyscallError("bind", err)
+	}
+
+	return fd, &net.UnixAddr{Name: path, Net: "unixgram"}, nil
+}
+
+// UnlinkUnix removes the file of the unix domain socket bound to path. Sockets in the abstract namespace, whose path
+// starts with @, and unnamed sockets have no file.
+func UnlinkUnix(path string) error {
+	if path == "" || path[0] == '@' {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// listenUnix listens on the Unix domain stream socket at path.
+func listenUnix(network, path string, opts ...sonicopts.Option) (int, net.Addr, error) {
+	if network != "unix" {
+		return -1, nil, fmt.Errorf("network %s not supported", network)
+	}
+
+	fd, err := socket(syscall.AF_UNIX, syscall.SOCK_STREAM, 0, false)
+	if err != nil {
+		return -1, nil, err
+	}
+
+	if err := ApplyOpts(fd, opts...); err != nil {
+		_ = syscall.Close(fd)
+		return -1, nil, err
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrUnix{Name: path}); err != nil {
+		_ = syscall.Close(fd)
+		return -1, nil, os.NewSyscallError("bind", err)
+	}
+
+	if err := syscall.Listen(fd, ListenBacklog); err != nil {
+		_ = syscall.Close(fd)
+		return -1, nil, os.NewSyscallError("listen", err)
+	}
+
+	return fd, &net.UnixAddr{Name: path, Net: network}, nil
+}
+
 func ListenUDP(network, addr string, opts ...sonicopts.Option) (int, net.Addr, error) {
 	if network[:3] != "udp" {
 		return -1, nil, fmt.Errorf("network %s not supported", network[:3])
@@ -303,9 +559,36 @@ func ApplyOpts(fd int, opts ...sonicopts.Option) error {
 			); err != nil {
 				return os.NewSyscallError(fmt.Sprintf("tcp_no_delay(%v)", v), err)
 			}
-		case sonicopts.TypeBindSocket:
-			addr := opt.Value().(net.Addr)
-			return syscall.Bind(fd, ToSockaddr(addr))
+		case sonicopts.TypeBindSocket, sonicopts.TypeBindPortRange:
+			// Applied by maybeBindBeforeConnect, right before connecting.
+		case sonicopts.TypeBindToDevice:
+			if err := BindToDevice(fd, opt.Value().(string)); err != nil {
+				return err
+			}
+		case sonicopts.TypeTransparent:
+			if err := SetTransparent(fd, opt.Value().(bool)); err != nil {
+				return err
+			}
+		case sonicopts.TypeFreeBind:
+			if err := SetFreeBind(fd, opt.Value().(bool)); err != nil {
+				return err
+			}
+		case sonicopts.TypeDSCP:
+			if err := SetDSCP(fd, opt.Value().(uint8)); err != nil {
+				return err
+			}
+		case sonicopts.TypePriority:
+			if err := SetPriority(fd, opt.Value().(int)); err != nil {
+				return err
+			}
+		case sonicopts.TypeBindAddressNoPort:
+			if err := SetBindAddressNoPort(fd, opt.Value().(bool)); err != nil {
+				return err
+			}
+		case sonicopts.TypeBusyPoll:
+			if err := SetBusyPoll(fd, opt.Value().(time.Duration)); err != nil {
+				return err
+			}
 		default:
 			return fmt.Errorf("unsupported socket option %s", t)
 		}
@@ -322,7 +605,66 @@ func SocketAddress(fd int) (net.Addr, error) {
 	return FromSockaddr(addr), nil
 }
 
+// DupCloseOnExec duplicates the file descriptor fd into a close-on-exec one.
+func DupCloseOnExec(fd int) (int, error) {
+	nfd, err := unix.FcntlInt(uintptr(fd), unix.F_DUPFD_CLOEXEC, 0)
+	if err != nil {
+		return -1, os.NewSyscallError("fcntl(F_DUPFD_CLOEXEC)", err)
+	}
+	return nfd, nil
+}
+
+// PeerAddress returns the address of the peer the socket fd is connected to.
+func PeerAddress(fd int) (net.Addr, error) {
+	addr, err := syscall.Getpeername(fd)
+	if err != nil {
+		return nil, err
+	}
+	return FromSockaddr(addr), nil
+}
+
 func Sockaddr(fd int) (syscall.Sockaddr, error) {
 	addr, err := syscall.Getsockname(fd)
 	return addr, err
 }
+
+func isIPv6(fd int) bool {
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return false
+	}
+	_, ok := sa.(*syscall.SockaddrInet6)
+	return ok
+}
+
+// SetDSCP sets the DSCP of the packets sent by the socket through IP_TOS, or IPV6_TCLASS for IPv6 sockets. The two
+// lower bits of the traffic class are left for ECN.
+func SetDSCP(fd int, dscp uint8) (err error) {
+	tos := int(dscp&0x3f) << 2
+	if isIPv6(fd) {
+		err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+	} else {
+		err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+	}
+	if err != nil {
+		return os.NewSyscallError(fmt.Sprintf("dscp(%d)", dscp), err)
+	}
+	return nil
+}
+
+// GetDSCP returns the DSCP set with SetDSCP.
+func GetDSCP(fd int) (uint8, error) {
+	var (
+		tos int
+		err error
+	)
+	if isIPv6(fd) {
+		tos, err = syscall.GetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS)
+	} else {
+		tos, err = syscall.GetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_TOS)
+	}
+	if err != nil {
+		return 0, os.NewSyscallError("getsockopt", err)
+	}
+	return uint8(tos>>2) & 0x3f, nil
+}
@@ -0,0 +1,43 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package internal
+
+import (
+	"fmt"
+	"time"
+)
+
+// SetTransparent is not supported on BSD and macOS.
+func SetTransparent(fd int, v bool) error {
+	return fmt.Errorf("transparent sockets are only supported on linux")
+}
+
+// SetPriority is not supported on BSD and macOS.
+func SetPriority(fd int, priority int) error {
+	return fmt.Errorf("socket priorities are only supported on linux")
+}
+
+// SetBusyPoll is not supported on BSD and macOS.
+func SetBusyPoll(fd int, d time.Duration) error {
+	return fmt.Errorf("busy polling sockets is only supported on linux")
+}
+
+// SetFreeBind is not supported on BSD and macOS.
+func SetFreeBind(fd int, v bool) error {
+	return fmt.Errorf("free bind sockets are only supported on linux")
+}
+
+// SetBindAddressNoPort is not supported on BSD and macOS.
+func SetBindAddressNoPort(fd int, v bool) error {
+	return fmt.Errorf("binding without a port is only supported on linux")
+}
+
+// AcceptBacklog is not supported on BSD and macOS.
+func AcceptBacklog(fd int) (queued, capacity int, err error) {
+	return 0, 0, fmt.Errorf("the accept backlog can only be queried on linux")
+}
+
+// ListenOverflows is not supported on BSD and macOS.
+func ListenOverflows() (uint64, error) {
+	return 0, fmt.Errorf("the listen overflows can only be queried on linux")
+}
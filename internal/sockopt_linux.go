@@ -0,0 +1,185 @@
+//go:build linux
+
+package internal
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ip6tSoOriginalDst is IP6T_SO_ORIGINAL_DST from <linux/netfilter_ipv6/ip6_tables.h>.
+const ip6tSoOriginalDst = 80
+
+// BindToDevice binds the socket to the network interface with the given name through SO_BINDTODEVICE. The name can
+// also be that of a VRF device, in which case the socket is scoped to the VRF.
+func BindToDevice(fd int, name string) error {
+	if err := syscall.SetsockoptString(fd, syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, name); err != nil {
+		return os.NewSyscallError("bind_to_device", err)
+	}
+	return nil
+}
+
+func boolToInt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// SetTransparent sets IP_TRANSPARENT, or IPV6_TRANSPARENT for IPv6 sockets.
+func SetTransparent(fd int, v bool) (err error) {
+	if isIPv6(fd) {
+		err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, unix.IPV6_TRANSPARENT, boolToInt(v))
+	} else {
+		err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, unix.IP_TRANSPARENT, boolToInt(v))
+	}
+	if err != nil {
+		return os.NewSyscallError(fmt.Sprintf("transparent(%v)", v), err)
+	}
+	return nil
+}
+
+// SetPriority sets SO_PRIORITY, the priority of the socket's packets in the queueing discipline of the interface.
+func SetPriority(fd int, priority int) error {
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_PRIORITY, priority); err != nil {
+		return os.NewSyscallError(fmt.Sprintf("priority(%d)", priority), err)
+	}
+	return nil
+}
+
+// SetBusyPoll sets SO_BUSY_POLL, the time a read busy-polls the device queue for data before it gives up.
+func SetBusyPoll(fd int, d time.Duration) error {
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_BUSY_POLL, int(d.Microseconds())); err != nil {
+		return os.NewSyscallError(fmt.Sprintf("busy_poll(%v)", d), err)
+	}
+	return nil
+}
+
+// SetFreeBind sets IP_FREEBIND, or IPV6_FREEBIND for IPv6 sockets.
+func SetFreeBind(fd int, v bool) (err error) {
+	if isIPv6(fd) {
+		err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, unix.IPV6_FREEBIND, boolToInt(v))
+	} else {
+		err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, unix.IP_FREEBIND, boolToInt(v))
+	}
+	if err != nil {
+		return os.NewSyscallError(fmt.Sprintf("free_bind(%v)", v), err)
+	}
+	return nil
+}
+
+// SetBindAddressNoPort sets IP_BIND_ADDRESS_NO_PORT, which applies to IPv4 and IPv6 sockets alike.
+func SetBindAddressNoPort(fd int, v bool) error {
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, unix.IP_BIND_ADDRESS_NO_PORT, boolToInt(v)); err != nil {
+		return os.NewSyscallError(fmt.Sprintf("bind_address_no_port(%v)", v), err)
+	}
+	return nil
+}
+
+// OriginalDestination returns the destination address of a connection before it was redirected to us by netfilter
+// (REDIRECT or DNAT), through SO_ORIGINAL_DST.
+func OriginalDestination(fd int) (*net.TCPAddr, error) {
+	if isIPv6(fd) {
+		var sa syscall.RawSockaddrInet6
+		if err := getsockoptRaw(fd, syscall.IPPROTO_IPV6, ip6tSoOriginalDst,
+			/* #nosec G103 -- the use of unsafe has been audited */
+			unsafe.Pointer(&sa), unsafe.Sizeof(sa)); err != nil {
+			return nil, err
+		}
+		/* #nosec G103 -- the use of unsafe has been audited */
+		port := (*[2]byte)(unsafe.Pointer(&sa.Port))
+		return &net.TCPAddr{
+			IP:   append(net.IP{}, sa.Addr[:]...),
+			Port: int(port[0])<<8 | int(port[1]),
+		}, nil
+	}
+
+	var sa syscall.RawSockaddrInet4
+	if err := getsockoptRaw(fd, syscall.IPPROTO_IP, unix.SO_ORIGINAL_DST,
+		/* #nosec G103 -- the use of unsafe has been audited */
+		unsafe.Pointer(&sa), unsafe.Sizeof(sa)); err != nil {
+		return nil, err
+	}
+	/* #nosec G103 -- the use of unsafe has been audited */
+	port := (*[2]byte)(unsafe.Pointer(&sa.Port))
+	return &net.TCPAddr{
+		IP:   append(net.IP{}, sa.Addr[:]...),
+		Port: int(port[0])<<8 | int(port[1]),
+	}, nil
+}
+
+func getsockoptRaw(fd, level, opt int, val unsafe.Pointer, size uintptr) error {
+	n := uint32(size)
+
+	/* #nosec G103 -- the use of unsafe has been audited */
+	_, _, errno := syscall.Syscall6(
+		syscall.SYS_GETSOCKOPT,
+		uintptr(fd),
+		uintptr(level),
+		uintptr(opt),
+		uintptr(val),
+		uintptr(unsafe.Pointer(&n)),
+		0,
+	)
+	if errno != 0 {
+		return os.NewSyscallError("getsockopt", errno)
+	}
+	return nil
+}
+
+// PeerCredentials returns the credentials of the peer of a connected Unix domain socket through SO_PEERCRED.
+func PeerCredentials(fd int) (uid, gid uint32, pid int, err error) {
+	cred, err := unix.GetsockoptUcred(fd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+	if err != nil {
+		return 0, 0, 0, os.NewSyscallError("getsockopt", err)
+	}
+	return cred.Uid, cred.Gid, int(cred.Pid), nil
+}
+
+// AcceptBacklog returns the number of connections waiting in the accept queue of a listening TCP socket and the
+// capacity of that queue, through TCP_INFO.
+func AcceptBacklog(fd int) (queued, capacity int, err error) {
+	info, err := unix.GetsockoptTCPInfo(fd, unix.IPPROTO_TCP, unix.TCP_INFO)
+	if err != nil {
+		return 0, 0, os.NewSyscallError("getsockopt", err)
+	}
+	// For listening sockets, the kernel reports the accept queue's length and capacity in these two fields.
+	return int(info.Unacked), int(info.Sacked), nil
+}
+
+// ListenOverflows returns the number of connections the kernel dropped because the accept queue of a listening socket
+// was full. The kernel counts them over all the sockets of the network namespace, in the ListenOverflows counter of
+// /proc/net/netstat.
+func ListenOverflows() (uint64, error) {
+	b, err := os.ReadFile("/proc/net/netstat")
+	if err != nil {
+		return 0, err
+	}
+	return netstatCounter(b, "TcpExt", "ListenOverflows")
+}
+
+// netstatCounter returns the counter name of the group in b, the content of /proc/net/netstat. Each group is a line of
+// names followed by a line of values, both prefixed by the group.
+func netstatCounter(b []byte, group, name string) (uint64, error) {
+	lines := strings.Split(string(b), "\n")
+	for i := 0; i+1 < len(lines); i += 2 {
+		names, values := strings.Fields(lines[i]), strings.Fields(lines[i+1])
+		if len(names) == 0 || names[0] != group+":" || len(values) != len(names) {
+			continue
+		}
+		for j := 1; j < len(names); j++ {
+			if names[j] == name {
+				return strconv.ParseUint(values[j], 10, 64)
+			}
+		}
+	}
+	return 0, fmt.Errorf("no %s %s counter in /proc/net/netstat", group, name)
+}
@@ -6,35 +6,22 @@ import (
 	"fmt"
 	"net"
 	"reflect"
+	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/csdenboer/sonic/util"
 	"golang.org/x/sys/unix"
 )
 
-// TODO Handle IPv6
-
 func ToSockaddr(addr net.Addr) syscall.Sockaddr {
 	switch addr := addr.(type) {
 	case *net.TCPAddr:
-		return &syscall.SockaddrInet4{
-			Port: addr.Port,
-			Addr: func() (b [4]byte) {
-				copy(b[:], addr.IP.To4())
-				return
-			}(),
-		}
+		return ipSockaddr(addr.IP, addr.Port, addr.Zone)
 	case *net.UDPAddr:
-		return &syscall.SockaddrInet4{
-			Port: addr.Port,
-			Addr: func() (b [4]byte) {
-				copy(b[:], addr.IP.To4())
-				return
-			}(),
-		}
+		return ipSockaddr(addr.IP, addr.Port, addr.Zone)
 	case *net.UnixAddr:
-		panic("unix not supported")
-		return nil
+		return &syscall.SockaddrUnix{Name: addr.Name}
 	default:
 		panic(fmt.Sprintf("unsupported address type: %s", reflect.TypeOf(addr)))
 	}
@@ -48,7 +35,11 @@ func FromSockaddr(sockAddr syscall.Sockaddr) net.Addr {
 			Port: addr.Port,
 		}
 	case *syscall.SockaddrInet6:
-		// TODO
+		return &net.TCPAddr{
+			IP:   append([]byte{}, addr.Addr[:]...),
+			Port: addr.Port,
+			Zone: zoneName(addr.ZoneId),
+		}
 	case *syscall.SockaddrUnix:
 		return &net.UnixAddr{
 			Name: addr.Name,
@@ -59,6 +50,61 @@ func FromSockaddr(sockAddr syscall.Sockaddr) net.Addr {
 	return nil
 }
 
+// IsIPv6 returns true if ip is an IPv6 address which is not an IPv4-mapped one. A nil ip is not an IPv6 address, as
+// the unspecified address of sonic's listeners and dialers is the IPv4 one.
+func IsIPv6(ip net.IP) bool {
+	return ip != nil && ip.To4() == nil
+}
+
+// ipSockaddr returns the IPv4 or IPv6 socket address of the given ip, port and zone.
+func ipSockaddr(ip net.IP, port int, zone string) syscall.Sockaddr {
+	if IsIPv6(ip) {
+		sa := &syscall.SockaddrInet6{Port: port, ZoneId: zoneID(zone)}
+		copy(sa.Addr[:], ip.To16())
+		return sa
+	}
+
+	sa := &syscall.SockaddrInet4{Port: port}
+	copy(sa.Addr[:], ip.To4())
+	return sa
+}
+
+// zoneID returns the scope id of an IPv6 zone, which is either the name of an interface, as in fe80::1%eth0, or its
+// index, as in fe80::1%2.
+func zoneID(zone string) uint32 {
+	if zone == "" {
+		return 0
+	}
+	if iff, err := net.InterfaceByName(zone); err == nil {
+		return uint32(iff.Index)
+	}
+	if id, err := strconv.ParseUint(zone, 10, 32); err == nil {
+		return uint32(id)
+	}
+	return 0
+}
+
+// zoneName returns the IPv6 zone of a scope id, which is the name of its interface, or the id itself if no interface
+// has it, such that the zone converts back to the same scope id.
+func zoneName(id uint32) string {
+	if id == 0 {
+		return ""
+	}
+	if iff, err := net.InterfaceByIndex(int(id)); err == nil {
+		return iff.Name
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// unspecifiedIP returns the unspecified address of the family of network, for the addresses which have no IP, such as
+// ":8080". That is the IPv6 one for the tcp6 and udp6 networks, and nil, which is the IPv4 one, otherwise.
+func unspecifiedIP(network string) net.IP {
+	if strings.HasSuffix(network, "6") {
+		return net.IPv6unspecified
+	}
+	return nil
+}
+
 func IsNonblocking(fd int) (bool, error) {
 	v, err := unix.FcntlInt(uintptr(fd), unix.F_GETFL, 0)
 	if err != nil {
@@ -85,7 +131,7 @@ func FromSockaddrUDP(sockAddr syscall.Sockaddr, to *net.UDPAddr) *net.UDPAddr {
 		to.IP = util.ExtendSlice(to.IP, net.IPv6len)
 		copy(to.IP, addr.Addr[:])
 		to.Port = addr.Port
-		// TODO zoneID (not sure the encoding)
+		to.Zone = zoneName(addr.ZoneId)
 	default:
 		panic("not supported")
 	}
@@ -0,0 +1,51 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly || linux
+
+package internal
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestSockaddrIPv6Zones(t *testing.T) {
+	lo, err := net.InterfaceByIndex(1)
+	if err != nil {
+		t.Skip("no interface with index 1")
+	}
+
+	for _, c := range []struct {
+		zone, roundTrip string
+		id              uint32
+	}{
+		{"", "", 0},
+		{lo.Name, lo.Name, 1},
+		{"1", lo.Name, 1},
+		{"4242", "4242", 4242},
+	} {
+		for _, addr := range []net.Addr{
+			&net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 80, Zone: c.zone},
+			&net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 80, Zone: c.zone},
+		} {
+			sa, ok := ToSockaddr(addr).(*syscall.SockaddrInet6)
+			if !ok || sa.ZoneId != c.id || sa.Port != 80 {
+				t.Fatalf("%v: wrong socket address %#v", addr, sa)
+			}
+
+			if from := FromSockaddr(sa).(*net.TCPAddr); from.Zone != c.roundTrip || !from.IP.Equal(net.ParseIP("fe80::1")) {
+				t.Fatalf("%v: wrong address %v", addr, from)
+			}
+			if from := FromSockaddrUDP(sa, &net.UDPAddr{}); from.Zone != c.roundTrip {
+				t.Fatalf("%v: wrong address %v", addr, from)
+			}
+		}
+	}
+
+	// IPv4 addresses, including the IPv4-mapped ones, are not affected.
+	for _, ip := range []string{"127.0.0.1", "::ffff:127.0.0.1"} {
+		addr := &net.UDPAddr{IP: net.ParseIP(ip), Port: 80}
+		if sa, ok := ToSockaddr(addr).(*syscall.SockaddrInet4); !ok || sa.Addr != [4]byte{127, 0, 0, 1} {
+			t.Fatalf("%v: wrong socket address %#v", addr, ToSockaddr(addr))
+		}
+	}
+}
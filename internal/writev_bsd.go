@@ -0,0 +1,42 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package internal
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// Writev writes the buffers to fd in order with a single writev(2) call.
+//
+// golang.org/x/sys/unix does not provide Writev on the BSDs, so the iovecs are built here.
+func Writev(fd int, bufs [][]byte) (int, error) {
+	return vectored(syscall.SYS_WRITEV, fd, bufs)
+}
+
+// Readv reads from fd into the buffers in order with a single readv(2) call.
+func Readv(fd int, bufs [][]byte) (int, error) {
+	return vectored(syscall.SYS_READV, fd, bufs)
+}
+
+func vectored(trap uintptr, fd int, bufs [][]byte) (int, error) {
+	iovecs := make([]syscall.Iovec, 0, len(bufs))
+	for _, b := range bufs {
+		if len(b) == 0 {
+			continue
+		}
+		iov := syscall.Iovec{Base: &b[0]}
+		iov.SetLen(len(b))
+		iovecs = append(iovecs, iov)
+	}
+	if len(iovecs) == 0 {
+		return 0, nil
+	}
+
+	n, _, errno := syscall.Syscall(
+		trap, uintptr(fd), uintptr(unsafe.Pointer(&iovecs[0])), uintptr(len(iovecs)))
+	if errno != 0 {
+		return int(n), errno
+	}
+	return int(n), nil
+}
@@ -0,0 +1,15 @@
+//go:build linux
+
+package internal
+
+import "golang.org/x/sys/unix"
+
+// Writev writes the buffers to fd in order with a single writev(2) call.
+func Writev(fd int, bufs [][]byte) (int, error) {
+	return unix.Writev(fd, bufs)
+}
+
+// Readv reads from fd into the buffers in order with a single readv(2) call.
+func Readv(fd int, bufs [][]byte) (int, error) {
+	return unix.Readv(fd, bufs)
+}
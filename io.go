@@ -1,9 +1,10 @@
 package sonic
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io"
 	"os"
-	"runtime"
 	"syscall"
 	"time"
 
@@ -32,13 +33,67 @@ type IO struct {
 		// file descriptors are bound by a fixed range whose upper limit is controlled through RLIMIT_NOFILE.
 		static [4096]*internal.Slot
 
-		// This map covers the 1%, the degenerate case. Any Slot whose file descriptor is greater than or equal to
-		// 4096 goes here.
-		dynamic map[*internal.Slot]struct{}
+		// This covers the 1%, the degenerate case. Any Slot whose file descriptor is greater than or equal to 4096
+		// goes here.
+		dynamic pendingSlots
+
+		// The scheduled Timers.
+		timers pendingTimers
 	}
-	pendingTimers map[*Timer]struct{} // XXX: should be embedded into the above pending struct
+
+	heartbeat heartbeat
+	reloader  reloader
+
+	// memory is the root of the memory accounts of the IO's streams. See MemoryAccount.
+	memory MemoryAccount
+
+	// tlsSessions caches the TLS client sessions of the IO's connections. See TLSSessionCache.
+	tlsSessions tls.ClientSessionCache
+
+	// wheel holds the Timeouts of the IO, and is created with the first one. See ScheduleAfter.
+	wheel             *timerWheel
+	timeoutResolution time.Duration
+
+	// pollTimeout bounds how long Run blocks in a single poll. Negative means forever. See SetPollTimeout.
+	pollTimeout time.Duration
+
+	// adaptive is true if Run adapts the poll timeout to the load. idlePolls is the number of consecutive polls which
+	// did not process anything. See SetAdaptivePolling.
+	adaptive  bool
+	idlePolls int
+
+	// busyPolls is the number of consecutive idle polls for which Run busy-polls before it waits for events. See
+	// SetBusyPoll.
+	busyPolls int
+
+	// polls is the number of polls made so far. Streams use it to tell whether they went back to the IO loop since
+	// they last ran, see execBudget.
+	polls uint64
+
+	// interrupts is the number of polls interrupted by a signal, see Interrupts.
+	interrupts uint64
+
+	// dispatching is true while the IO polls and runs the handlers of the ready events. See Dispatching.
+	dispatching bool
+
+	// debug is true if the IO checks invariants and writes diagnostics to debugOutput. See SetDebug.
+	debug       bool
+	debugOutput io.Writer
+
+	// onError is the handler set with SetErrorHandler, which is also passed the invariant violations of debug mode.
+	onError func(fd int, err error)
 }
 
+const (
+	// AdaptiveBusyPolls is the number of consecutive idle polls after which an adaptive Run stops busy-polling and
+	// starts waiting for events.
+	AdaptiveBusyPolls = 128
+
+	// AdaptiveMaxWait is the longest an adaptive Run waits in a single poll before it falls back to the poll timeout
+	// set with SetPollTimeout.
+	AdaptiveMaxWait = 16 * time.Millisecond
+)
+
 func NewIO() (*IO, error) {
 	poller, err := internal.NewPoller()
 	if err != nil {
@@ -46,8 +101,8 @@ func NewIO() (*IO, error) {
 	}
 
 	return &IO{
-		poller:        poller,
-		pendingTimers: make(map[*Timer]struct{}),
+		poller:      poller,
+		pollTimeout: -1,
 	}, nil
 }
 
@@ -59,39 +114,166 @@ func MustIO() *IO {
 	return ioc
 }
 
+// Reserve preallocates the registries of the IO for the file descriptors up to maxFd and for timers Timers scheduled at
+// once, such that registering them does not allocate. This is meant for IOs which handle many connections, up to
+// millions, and cannot afford to allocate, and to grow their registries, while they connect.
+//
+// The registries grow on demand past what is reserved, and never shrink.
+func (ioc *IO) Reserve(maxFd, timers int) {
+	if maxFd >= len(ioc.pending.static) {
+		ioc.pending.dynamic.reserve(len(ioc.pending.static), maxFd+1)
+	}
+	ioc.pending.timers.reserve(timers)
+}
+
 func (ioc *IO) Register(slot *internal.Slot) {
+	if ioc.debug {
+		ioc.checkRegister(slot)
+	}
 	if slot.Fd >= len(ioc.pending.static) {
-		if ioc.pending.dynamic == nil {
-			ioc.pending.dynamic = make(map[*internal.Slot]struct{})
-		}
-		ioc.pending.dynamic[slot] = struct{}{}
+		ioc.pending.dynamic.add(slot)
 	} else {
 		ioc.pending.static[slot.Fd] = slot
 	}
 }
 
 func (ioc *IO) Deregister(slot *internal.Slot) {
+	if ioc.debug {
+		ioc.checkDeregister(slot)
+	}
 	if slot.Fd >= len(ioc.pending.static) {
-		delete(ioc.pending.dynamic, slot)
+		ioc.pending.dynamic.remove(slot)
 	} else {
 		ioc.pending.static[slot.Fd] = nil
 	}
 }
 
 func (ioc *IO) SetRead(slot *internal.Slot) error {
+	if ioc.debug {
+		ioc.checkSet(slot, internal.ReadEvent)
+	}
 	return ioc.poller.SetRead(slot)
 }
 
 func (ioc *IO) SetWrite(slot *internal.Slot) error {
+	if ioc.debug {
+		ioc.checkSet(slot, internal.WriteEvent)
+	}
 	return ioc.poller.SetWrite(slot)
 }
 
 // Run runs the event processing loop.
+//
+// Each poll blocks until an event occurs or until the poll timeout expires, see SetPollTimeout, SetBusyPoll and
+// SetAdaptivePolling.
 func (ioc *IO) Run() error {
 	for {
-		if err := ioc.RunOne(); err != nil && err != sonicerrors.ErrTimeout {
+		n, err := ioc.poll(ioc.nextPollTimeoutMs())
+		if err != nil && err != sonicerrors.ErrTimeout {
 			return err
 		}
+		ioc.adapt(n)
+	}
+}
+
+// SetPollTimeout sets how long a single poll of Run blocks waiting for events. A negative timeout, the default, blocks
+// until an event occurs. A timeout of 0 busy-polls, which gives the lowest latency at the cost of a full CPU. The
+// timeout is rounded down to the millisecond.
+//
+// SetPollTimeout can be called at any time, including from a handler. The change takes effect on the next poll.
+func (ioc *IO) SetPollTimeout(timeout time.Duration) {
+	if timeout < 0 {
+		timeout = -1
+	}
+	ioc.pollTimeout = timeout
+}
+
+// PollTimeout returns the timeout set with SetPollTimeout.
+func (ioc *IO) PollTimeout() time.Duration {
+	return ioc.pollTimeout
+}
+
+// SetAdaptivePolling makes Run adapt the poll timeout to the load, such that users do not have to tune it.
+//
+// While work is arriving, Run busy-polls. After AdaptiveBusyPolls consecutive polls without work, Run waits for
+// events in each poll, for 1ms at first, then twice as long on each idle poll, up to AdaptiveMaxWait. Run then falls
+// back to the timeout set with SetPollTimeout, which blocks by default. Any processed event brings Run back to
+// busy-polling.
+func (ioc *IO) SetAdaptivePolling(adaptive bool) {
+	ioc.adaptive = adaptive
+	ioc.idlePolls = 0
+}
+
+// AdaptivePolling returns true if adaptive polling is enabled.
+func (ioc *IO) AdaptivePolling() bool {
+	return ioc.adaptive
+}
+
+// SetBusyPoll makes Run busy-poll, with a zero timeout, for spins consecutive polls without work before it waits for
+// events with the timeout set with SetPollTimeout. Any processed event starts the spins again. Busy-polling keeps the
+// goroutine running the IO off the scheduler and the kernel's wait queues while the next event is likely to arrive
+// soon, which saves the microseconds of waking up at the cost of the CPU burnt spinning.
+//
+// If adaptive polling is enabled, spins replaces AdaptiveBusyPolls. A spins of 0, the default, disables busy-polling,
+// or leaves it to adaptive polling.
+// See sonicopts.BusyPoll for busy-polling the device queues of a socket in the kernel instead.
+func (ioc *IO) SetBusyPoll(spins int) {
+	if spins < 0 {
+		spins = 0
+	}
+	ioc.busyPolls = spins
+	ioc.idlePolls = 0
+}
+
+// BusyPoll returns the number of spins set with SetBusyPoll.
+func (ioc *IO) BusyPoll() int {
+	return ioc.busyPolls
+}
+
+// spins returns the number of consecutive idle polls for which Run busy-polls.
+func (ioc *IO) spins() int {
+	if ioc.busyPolls == 0 && ioc.adaptive {
+		return AdaptiveBusyPolls
+	}
+	return ioc.busyPolls
+}
+
+func (ioc *IO) nextPollTimeoutMs() int {
+	spins := ioc.spins()
+	if ioc.idlePolls < spins {
+		return 0
+	}
+
+	if !ioc.adaptive || ioc.idlePolls >= spins+waitSteps {
+		if ioc.pollTimeout < 0 {
+			return -1
+		}
+		return int(ioc.pollTimeout.Milliseconds())
+	}
+
+	wait := time.Millisecond << (ioc.idlePolls - spins)
+	if ioc.pollTimeout >= 0 && wait > ioc.pollTimeout {
+		wait = ioc.pollTimeout
+	}
+	return int(wait.Milliseconds())
+}
+
+// waitSteps is the number of times the wait of an adaptive Run doubles from 1ms before reaching AdaptiveMaxWait.
+var waitSteps = func() (n int) {
+	for wait := time.Millisecond; wait <= AdaptiveMaxWait; wait <<= 1 {
+		n++
+	}
+	return n
+}()
+
+func (ioc *IO) adapt(processed int) {
+	if !ioc.adaptive && ioc.busyPolls == 0 {
+		return
+	}
+	if processed > 0 {
+		ioc.idlePolls = 0
+	} else if ioc.idlePolls < ioc.spins()+waitSteps {
+		ioc.idlePolls++
 	}
 }
 
@@ -202,28 +384,69 @@ func (ioc *IO) PollOne() (n int, err error) {
 }
 
 func (ioc *IO) poll(timeoutMs int) (int, error) {
-	n, err := ioc.poller.Poll(timeoutMs)
+	ioc.polls++
 
-	if err != nil {
-		if err == syscall.EINTR {
-			// TODO not sure about this one, and whether returning timeout here is ok.
-			// need to look into syscall.EINTR again
-			if timeoutMs >= 0 {
+	ioc.dispatching = true
+	defer func() { ioc.dispatching = false }()
+
+	// The wait is interrupted whenever a signal is delivered to the thread, which happens all the time under a profiler
+	// or a debugger. Such interruptions are not errors: the wait is resumed, for what is left of the timeout.
+	var deadline time.Time
+	for {
+		n, err := ioc.poller.Poll(timeoutMs)
+		switch err {
+		case nil:
+			return n, nil
+		case sonicerrors.ErrTimeout:
+			return 0, err
+		case syscall.EINTR:
+		default:
+			return 0, os.NewSyscallError(fmt.Sprintf("poll_wait timeout=%d", timeoutMs), err)
+		}
+
+		ioc.interrupts++
+		if timeoutMs > 0 {
+			if deadline.IsZero() {
+				// Only taken once interrupted, such that uninterrupted polls do not pay for reading the clock. The
+				// wait thus lasts a little longer than the timeout.
+				deadline = time.Now().Add(time.Duration(timeoutMs) * time.Millisecond)
+			}
+			if timeoutMs = int(time.Until(deadline).Milliseconds()); timeoutMs <= 0 {
 				return 0, sonicerrors.ErrTimeout
 			}
-			runtime.Gosched()
-			return 0, nil
 		}
+	}
+}
 
-		if err == sonicerrors.ErrTimeout {
-			return 0, err
+// RunUntil runs the event processing loop until done returns true. It is the loop of the synchronous counterparts of
+// the asynchronous operations, such as Timer.Wait, which block the calling goroutine, which must be the one running the
+// IO, until their handler is invoked.
+//
+// Running the IO from one of its handlers would dispatch events while the ones of the current poll are being
+// dispatched, so sonicerrors.ErrReentrantWait is returned, without running the IO, if RunUntil is called from a
+// handler. See Dispatching.
+func (ioc *IO) RunUntil(done func() bool) error {
+	if ioc.dispatching {
+		return sonicerrors.ErrReentrantWait
+	}
+	for !done() {
+		if err := ioc.RunOne(); err != nil && err != sonicerrors.ErrTimeout {
+			return err
 		}
-
-		return 0, os.NewSyscallError(
-			fmt.Sprintf("poll_wait timeout=%d", timeoutMs), err)
 	}
+	return nil
+}
+
+// Dispatching returns true if called from a handler of the IO, in which case the IO cannot be run and the synchronous
+// counterparts of the asynchronous operations fail with sonicerrors.ErrReentrantWait.
+func (ioc *IO) Dispatching() bool {
+	return ioc.dispatching
+}
 
-	return n, nil
+// Interrupts returns the number of times a poll was interrupted by a signal, and transparently resumed. A steadily
+// growing count points to a process flooded with signals, for example by a profiler.
+func (ioc *IO) Interrupts() uint64 {
+	return ioc.interrupts
 }
 
 // Post schedules the provided handler to be run immediately by the event
@@ -234,6 +457,33 @@ func (ioc *IO) Post(handler func()) error {
 	return ioc.poller.Post(handler)
 }
 
+// SetErrorHandler sets a handler for the events the IO cannot match to an asynchronous operation, such as readiness
+// reported for a file descriptor which does not wait for it, and for the errors the IO runs into while dispatching
+// events. Such conditions usually point to a file descriptor closed or reused while it was registered, and are
+// otherwise ignored. Unmatched events are reported with an error wrapping sonicerrors.ErrUnsolicitedEvent.
+//
+// The handler is invoked on the goroutine running the IO. A nil handler, the default, ignores these conditions.
+//
+// In debug mode, the handler is also invoked with the invariant violations, see SetDebug.
+func (ioc *IO) SetErrorHandler(handler func(fd int, err error)) {
+	ioc.onError = handler
+	ioc.poller.SetErrorHandler(handler)
+}
+
+// SetMaxPosts bounds the number of handlers which can be posted and not yet executed, such that a stalled event loop
+// does not grow the queue of posted handlers forever. Post returns sonicerrors.ErrPostQueueFull once the bound is
+// reached. The default is 0, which means no bound.
+//
+// It is safe to call SetMaxPosts concurrently.
+func (ioc *IO) SetMaxPosts(n int) {
+	ioc.poller.SetMaxPosts(n)
+}
+
+// MaxPosts returns the value set with SetMaxPosts.
+func (ioc *IO) MaxPosts() int {
+	return ioc.poller.MaxPosts()
+}
+
 // Posted returns the number of handlers registered with Post.
 //
 // It is safe to call Posted concurrently.
@@ -245,7 +495,44 @@ func (ioc *IO) Pending() int64 {
 	return ioc.poller.Pending()
 }
 
+// SetMaxEventsPerPoll sets the maximum number of ready events, such as expired timers and readable sockets, which the
+// poller retrieves in one system call and a single poll of the event loop dispatches.
+//
+// The default is internal.DefaultMaxEvents. When many timers expire in the same tick, a larger value dispatches them
+// in fewer wakeups, at the expense of a longer time spent in a single poll.
+func (ioc *IO) SetMaxEventsPerPoll(n int) {
+	ioc.poller.SetMaxEvents(n)
+}
+
+// MaxEventsPerPoll returns the value set with SetMaxEventsPerPoll.
+func (ioc *IO) MaxEventsPerPoll() int {
+	return ioc.poller.MaxEvents()
+}
+
+// SetMaxTimersPerPoll bounds the number of expired timers dispatched in a single poll. It is an alias of
+// SetMaxEventsPerPoll: each expired timer is one ready event, so the bound is shared with the other events, such as
+// readable sockets.
+func (ioc *IO) SetMaxTimersPerPoll(n int) {
+	ioc.SetMaxEventsPerPoll(n)
+}
+
+// MaxTimersPerPoll returns the value set with SetMaxTimersPerPoll, which is the one of MaxEventsPerPoll.
+func (ioc *IO) MaxTimersPerPoll() int {
+	return ioc.MaxEventsPerPoll()
+}
+
+// MemoryAccount returns the root memory account of the IO, which holds the memory usage of all streams created on it.
+// Setting a limit on it bounds the memory of all those streams.
+func (ioc *IO) MemoryAccount() *MemoryAccount {
+	return &ioc.memory
+}
+
 func (ioc *IO) Close() error {
+	ioc.DisableHeartbeat()
+	ioc.DisableReloadOnSignal()
+	if ioc.wheel != nil {
+		ioc.wheel.close()
+	}
 	return ioc.poller.Close()
 }
 
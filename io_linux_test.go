@@ -0,0 +1,72 @@
+package sonic
+
+import (
+	"errors"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+// signalThread signals the calling thread every millisecond until the returned function is called.
+func signalThread() (stop func()) {
+	runtime.LockOSThread()
+	pid, tid := syscall.Getpid(), syscall.Gettid()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+				// SIGURG is the signal the runtime preempts goroutines with, so it is safe to send at any time.
+				_ = syscall.Tgkill(pid, tid, syscall.SIGURG)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		runtime.UnlockOSThread()
+	}
+}
+
+func TestIOPollInterrupted(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	stop := signalThread()
+	defer stop()
+
+	// A timed poll waits for its whole timeout.
+	start := time.Now()
+	if err := ioc.RunOneFor(100 * time.Millisecond); !errors.Is(err, sonicerrors.ErrTimeout) {
+		t.Fatalf("expected ErrTimeout got=%v", err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Fatalf("the poll returned after %s, before its timeout", d)
+	}
+
+	// A blocking poll waits for an event.
+	posted := false
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = ioc.Post(func() { posted = true })
+	}()
+	if err := ioc.RunOne(); err != nil {
+		t.Fatal(err)
+	}
+	if !posted {
+		t.Fatal("expected the poll to run the posted handler")
+	}
+
+	if ioc.Interrupts() == 0 {
+		t.Fatal("expected the polls to be interrupted")
+	}
+	t.Logf("interrupts=%d", ioc.Interrupts())
+}
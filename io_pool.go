@@ -0,0 +1,283 @@
+package sonic
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+	"github.com/csdenboer/sonic/util"
+)
+
+// PoolPolicy is how an IOPool assigns new connections and dispatched handlers to its IOs.
+type PoolPolicy uint8
+
+const (
+	// PoolRoundRobin assigns to the IOs in turn.
+	PoolRoundRobin PoolPolicy = iota
+
+	// PoolLeastLoaded assigns to the IO with the fewest open connections assigned by the pool. Ties are broken
+	// round-robin.
+	PoolLeastLoaded
+)
+
+func (p PoolPolicy) String() string {
+	switch p {
+	case PoolRoundRobin:
+		return "round_robin"
+	case PoolLeastLoaded:
+		return "least_loaded"
+	default:
+		return fmt.Sprintf("pool_policy(%d)", uint8(p))
+	}
+}
+
+// PoolAffinity picks the index of the IO of an IOPool a connection is handed over to, for example from a hash of its
+// remote address, such that the connections of a client are served by the same IO. An index out of the pool leaves the
+// pick to the policy of the pool.
+type PoolAffinity func(conn Conn) int
+
+// IOPool runs several IOs, each on its own goroutine, such that a server can scale beyond one core. Connections are
+// accepted on any IO and handed over to a member of the pool, on whose goroutine they are then served.
+//
+// An IO must only be used from the goroutine running it, so the IOs of a pool must only be used from the handlers
+// posted to them with Post or Dispatch, or from the handlers of the operations started there. The methods of IOPool
+// are safe to call concurrently.
+type IOPool struct {
+	members  []*poolMember
+	policy   PoolPolicy
+	affinity PoolAffinity
+	next     uint32
+
+	running uint32
+	stopped uint32
+	locked  bool
+	wg      sync.WaitGroup
+}
+
+type poolMember struct {
+	ioc   *IO
+	conns int64 // open connections assigned by the pool
+}
+
+// NewIOPool creates an IOPool of n IOs assigning the work with the given policy. If n <= 0, the pool has one IO per
+// CPU, see runtime.NumCPU.
+func NewIOPool(n int, policy PoolPolicy) (*IOPool, error) {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+
+	p := &IOPool{policy: policy}
+	for i := 0; i < n; i++ {
+		ioc, err := NewIO()
+		if err != nil {
+			_ = p.closeIOs()
+			return nil, err
+		}
+		p.members = append(p.members, &poolMember{ioc: ioc})
+	}
+	return p, nil
+}
+
+// Size returns the number of IOs of the pool.
+func (p *IOPool) Size() int {
+	return len(p.members)
+}
+
+// IO returns the IO with the given index.
+func (p *IOPool) IO(i int) *IO {
+	return p.members[i].ioc
+}
+
+// Load returns the number of open connections assigned by the pool to the IO with the given index.
+func (p *IOPool) Load(i int) int {
+	return int(atomic.LoadInt64(&p.members[i].conns))
+}
+
+// SetAffinity sets the affinity picking the IO of the connections handed over with Adopt. nil, the default, leaves the
+// pick to the policy of the pool. On linux, CPUSteering.Affinity picks the IO pinned to the CPU which processes the
+// packets of the connection, see PinCPUs. Unlike the other methods of IOPool, SetAffinity must not be called concurrently: it
+// is meant to be called before the pool serves connections.
+func (p *IOPool) SetAffinity(affinity PoolAffinity) {
+	p.affinity = affinity
+}
+
+// Run starts one goroutine per IO, running it until Close is called. If lockThreads is true, each goroutine is locked
+// to its OS thread, such that the thread can be pinned to a CPU.
+func (p *IOPool) Run(lockThreads bool) error {
+	if !atomic.CompareAndSwapUint32(&p.running, 0, 1) {
+		return fmt.Errorf("pool already running")
+	}
+	p.locked = lockThreads
+
+	for _, m := range p.members {
+		p.wg.Add(1)
+		go func(ioc *IO) {
+			defer p.wg.Done()
+			if lockThreads {
+				runtime.LockOSThread()
+				defer runtime.UnlockOSThread()
+			}
+			for atomic.LoadUint32(&p.stopped) == 0 {
+				if err := ioc.RunOne(); err != nil && err != sonicerrors.ErrTimeout {
+					return
+				}
+			}
+		}(m.ioc)
+	}
+	return nil
+}
+
+// PinCPUs pins the OS thread of the IO with index i to cpus[i], such that the IO runs on that CPU only. With a
+// CPUSteering affinity over the same cpus, connections are then served on the CPU which processes their packets.
+//
+// The pool must be running with its goroutines locked to their OS threads, see Run. PinCPUs blocks until all IOs are
+// pinned, so it must not be called from a handler of the pool. Pinning is a no-op on all platforms but linux.
+func (p *IOPool) PinCPUs(cpus []int) error {
+	if len(cpus) != len(p.members) {
+		return fmt.Errorf("got %d CPUs for a pool of %d", len(cpus), len(p.members))
+	}
+	if atomic.LoadUint32(&p.running) == 0 || !p.locked {
+		return fmt.Errorf("the pool must be running with locked threads")
+	}
+
+	errs := make(chan error, len(cpus))
+	for i, cpu := range cpus {
+		cpu := cpu
+		if err := p.Post(i, func() { errs <- util.PinTo(cpu) }); err != nil {
+			errs <- err
+		}
+	}
+
+	var all []error
+	for range cpus {
+		all = append(all, <-errs)
+	}
+	return errors.Join(all...)
+}
+
+// Post runs handler on the goroutine of the IO with the given index.
+func (p *IOPool) Post(i int, handler func()) error {
+	if i < 0 || i >= len(p.members) {
+		return fmt.Errorf("no IO with index %d in a pool of %d", i, len(p.members))
+	}
+	return p.members[i].ioc.Post(handler)
+}
+
+// Dispatch runs handler on the goroutine of the IO picked by the policy of the pool, and returns the index of that
+// IO.
+func (p *IOPool) Dispatch(handler func(ioc *IO)) (int, error) {
+	i := p.pick()
+	ioc := p.members[i].ioc
+	return i, ioc.Post(func() { handler(ioc) })
+}
+
+// Adopt hands conn over to the IO picked by the policy of the pool, and invokes handler with the new Conn on that
+// IO's goroutine. conn must not be used, nor closed, afterwards, see AdoptConn. The new Conn counts towards the load
+// of its IO until it is closed.
+//
+// If an error is returned, conn is left untouched.
+func (p *IOPool) Adopt(conn Conn, handler ConnHandler) (int, error) {
+	return p.AdoptIndexed(conn, func(_ int, conn Conn) { handler(conn) })
+}
+
+// AdoptIndexed is like Adopt, but handler is also given the index of the IO conn is handed over to, such that it can
+// find the state the caller keeps per IO.
+func (p *IOPool) AdoptIndexed(conn Conn, handler func(i int, conn Conn)) (int, error) {
+	i := p.pickFor(conn)
+	m := p.members[i]
+
+	adopted, err := AdoptConn(m.ioc, conn.RawFd())
+	if err != nil {
+		return i, err
+	}
+	if l, ok := conn.(*limitedConn); ok {
+		// conn is not closed once handed over, so it would stay counted by its ConnLimiter otherwise.
+		l.releaseConn()
+	}
+
+	atomic.AddInt64(&m.conns, 1)
+	pc := &pooledConn{Conn: adopted, m: m}
+	if err := m.ioc.Post(func() { handler(i, pc) }); err != nil {
+		atomic.AddInt64(&m.conns, -1)
+		return i, err
+	}
+	return i, nil
+}
+
+// Distribute returns a ConnHandler, such as the handler of an Acceptor, which hands each connection over to an IO of
+// the pool with Adopt, and then to handler. Connections which cannot be handed over are closed.
+func (p *IOPool) Distribute(handler ConnHandler) ConnHandler {
+	return func(conn Conn) {
+		if _, err := p.Adopt(conn, handler); err != nil {
+			_ = conn.Close()
+		}
+	}
+}
+
+func (p *IOPool) pickFor(conn Conn) int {
+	if p.affinity != nil {
+		if i := p.affinity(conn); i >= 0 && i < len(p.members) {
+			return i
+		}
+	}
+	return p.pick()
+}
+
+func (p *IOPool) pick() int {
+	n := uint32(len(p.members))
+	start := (atomic.AddUint32(&p.next, 1) - 1) % n
+	if p.policy != PoolLeastLoaded {
+		return int(start)
+	}
+
+	best, bestLoad := int(start), atomic.LoadInt64(&p.members[start].conns)
+	for k := uint32(1); k < n; k++ {
+		i := (start + k) % n
+		if load := atomic.LoadInt64(&p.members[i].conns); load < bestLoad {
+			best, bestLoad = int(i), load
+		}
+	}
+	return best
+}
+
+// Close stops the goroutines started by Run, waits for them to return and closes the IOs.
+func (p *IOPool) Close() error {
+	if !atomic.CompareAndSwapUint32(&p.stopped, 0, 1) {
+		return nil
+	}
+
+	// Wake up the IOs blocked in a poll.
+	for _, m := range p.members {
+		_ = m.ioc.Post(func() {})
+	}
+	p.wg.Wait()
+
+	return p.closeIOs()
+}
+
+func (p *IOPool) closeIOs() error {
+	var errs []error
+	for _, m := range p.members {
+		errs = append(errs, m.ioc.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// pooledConn is a connection adopted by an IO of an IOPool. It counts towards the load of that IO until it is closed.
+type pooledConn struct {
+	Conn
+	m *poolMember
+
+	released bool
+}
+
+func (c *pooledConn) Close() error {
+	if !c.released {
+		c.released = true
+		atomic.AddInt64(&c.m.conns, -1)
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,107 @@
+package sonic
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic/sonicopts"
+)
+
+func TestIOPool(t *testing.T) {
+	pool, err := NewIOPool(2, PoolLeastLoaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	if err := pool.Run(false); err != nil {
+		t.Fatal(err)
+	}
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := Listen(ioc, "tcp", "localhost:9992", sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// Each connection is echoed on the IO of the pool it is assigned to, until the peer closes it.
+	echo := func(conn Conn) {
+		b := make([]byte, 5)
+		var onRead AsyncCallback
+		onRead = func(err error, _ int) {
+			if err != nil {
+				conn.Close()
+				return
+			}
+			conn.AsyncWriteAll(b, func(error, int) { conn.AsyncReadAll(b, onRead) })
+		}
+		conn.AsyncReadAll(b, onRead)
+	}
+	NewAcceptor(ln, pool.Distribute(echo), func(err error) { t.Error(err) }).Start()
+
+	const nClients = 4
+
+	clients := make(chan net.Conn, nClients)
+	go func() {
+		for i := 0; i < nClients; i++ {
+			conn, err := net.Dial("tcp", "localhost:9992")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			b := make([]byte, 5)
+			if _, err := conn.Write([]byte("hello")); err != nil {
+				t.Error(err)
+			} else if _, err := io.ReadFull(conn, b); err != nil || string(b) != "hello" {
+				t.Errorf("expected the echo of hello got=%q err=%v", b, err)
+			}
+			clients <- conn
+		}
+	}()
+
+	var conns []net.Conn
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline) && len(conns) < nClients; {
+		select {
+		case conn := <-clients:
+			conns = append(conns, conn)
+		default:
+			_ = ioc.RunOneFor(time.Millisecond)
+		}
+	}
+	if len(conns) != nClients {
+		t.Fatalf("expected %d echoed clients got=%d", nClients, len(conns))
+	}
+
+	// The least loaded IO alternates, so each IO got every other connection.
+	if pool.Load(0) != 2 || pool.Load(1) != 2 {
+		t.Fatalf("expected 2 connections per IO got=%d,%d", pool.Load(0), pool.Load(1))
+	}
+
+	conns[0].Close()
+	conns[2].Close()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline) && pool.Load(0) != 0; {
+		time.Sleep(time.Millisecond)
+	}
+	if pool.Load(0) != 0 || pool.Load(1) != 2 {
+		t.Fatalf("expected the closed connections to be released got=%d,%d", pool.Load(0), pool.Load(1))
+	}
+	conns[1].Close()
+	conns[3].Close()
+
+	dispatched := make(chan *IO, 1)
+	i, err := pool.Dispatch(func(ioc *IO) { dispatched <- ioc })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 0 || <-dispatched != pool.IO(0) {
+		t.Fatalf("expected the handler to be dispatched to the least loaded IO got=%d", i)
+	}
+
+	if err := pool.Post(2, func() {}); err == nil {
+		t.Fatal("expected Post to fail for an IO out of the pool")
+	}
+}
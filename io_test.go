@@ -4,7 +4,11 @@ import (
 	"errors"
 	"github.com/csdenboer/sonic/internal"
 	"log"
+	"net"
 	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -54,6 +58,96 @@ func TestPost(t *testing.T) {
 	}
 }
 
+func TestPostManyWhileStalled(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	const (
+		goroutines = 16
+		perRoutine = 1 << 16 // ~1M in total
+	)
+
+	// The loop does not run while the handlers are posted, so the waker must not overflow.
+	var (
+		ran int64
+		wg  sync.WaitGroup
+	)
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perRoutine; j++ {
+				if err := ioc.Post(func() { ran++ }); err != nil {
+					panic(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := ioc.Posted(); n != goroutines*perRoutine {
+		t.Fatalf("expected %d posted handlers got=%d", goroutines*perRoutine, n)
+	}
+
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+	if ran != goroutines*perRoutine {
+		t.Fatalf("expected %d handlers to run got=%d", goroutines*perRoutine, ran)
+	}
+}
+
+func TestPostMaxPosts(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ioc.SetMaxPosts(1000)
+
+	var (
+		accepted int64
+		full     int64
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				switch err := ioc.Post(func() {}); err {
+				case nil:
+					atomic.AddInt64(&accepted, 1)
+				case sonicerrors.ErrPostQueueFull:
+					atomic.AddInt64(&full, 1)
+				default:
+					panic(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if accepted != 1000 || full != 7000 {
+		t.Fatalf("expected 1000 accepted and 7000 rejected posts got=%d and %d", accepted, full)
+	}
+
+	// Draining the queue makes room again.
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioc.Post(func() {}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPostAfterClose(t *testing.T) {
+	ioc := MustIO()
+	ioc.Close()
+
+	if err := ioc.Post(func() {}); !errors.Is(err, sonicerrors.ErrWakeupFailed) {
+		t.Fatalf("expected ErrWakeupFailed got=%v", err)
+	}
+}
+
 func TestEmptyPoll(t *testing.T) {
 	ioc := MustIO()
 	defer ioc.Close()
@@ -286,7 +380,7 @@ func TestIOPending(t *testing.T) {
 		}
 	}
 
-	if len(ioc.pending.dynamic) != 0 {
+	if ioc.pending.dynamic.Len() != 0 {
 		t.Fatal("pending dynamic should have length 0")
 	}
 
@@ -294,7 +388,7 @@ func TestIOPending(t *testing.T) {
 		slots = append(slots, &internal.Slot{Fd: i})
 		ioc.Register(slots[len(slots)-1])
 	}
-	if len(ioc.pending.dynamic) != 4096 {
+	if ioc.pending.dynamic.Len() != 4096 {
 		t.Fatal("pending dynamic should have 4096 entries")
 	}
 
@@ -306,7 +400,7 @@ func TestIOPending(t *testing.T) {
 			}
 		}
 	}
-	if len(ioc.pending.dynamic) != 0 {
+	if ioc.pending.dynamic.Len() != 0 {
 		t.Fatal("pending dynamic should have length 0")
 	}
 	for _, slot := range ioc.pending.static {
@@ -316,6 +410,190 @@ func TestIOPending(t *testing.T) {
 	}
 }
 
+// TestIOSoak registers a million connections, each with a Timer, and churns through them, to check that the registries
+// of the IO do not allocate once reserved, and that no registration stalls. It takes a while, so it is skipped with
+// -short.
+func TestIOSoak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the soak test in short mode")
+	}
+
+	const conns = 1 << 20
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	slots := make([]internal.Slot, conns)
+	timers := make([]Timer, conns)
+	for i := range slots {
+		slots[i].Fd = i
+	}
+
+	ioc.Reserve(conns-1, conns)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	var slowest time.Duration
+	batch := func(from, to int, op func(i int)) {
+		start := time.Now()
+		for i := from; i < to; i++ {
+			op(i)
+		}
+		if d := time.Since(start); d > slowest {
+			slowest = d
+		}
+	}
+	register := func(i int) {
+		ioc.Register(&slots[i])
+		ioc.pending.timers.add(&timers[i])
+	}
+	deregister := func(i int) {
+		ioc.Deregister(&slots[i])
+		ioc.pending.timers.remove(&timers[i])
+	}
+
+	for i := 0; i < conns; i += 1024 {
+		batch(i, i+1024, register)
+	}
+	if ioc.pending.dynamic.Len() != conns-len(ioc.pending.static) || ioc.pending.timers.Len() != conns {
+		t.Fatalf("expected %d connections got slots=%d timers=%d",
+			conns, ioc.pending.dynamic.Len(), ioc.pending.timers.Len())
+	}
+
+	// Connections come and go, in an order unrelated to the one they came in.
+	for round := 0; round < 4; round++ {
+		for i := round; i < conns; i += 1024 * 7 {
+			to := i + 1024
+			if to > conns {
+				to = conns
+			}
+			batch(i, to, deregister)
+			batch(i, to, register)
+		}
+	}
+
+	for i := 0; i < conns; i += 1024 {
+		batch(i, i+1024, deregister)
+	}
+	if ioc.pending.dynamic.Len() != 0 || ioc.pending.timers.Len() != 0 {
+		t.Fatalf("expected no connection got slots=%d timers=%d", ioc.pending.dynamic.Len(), ioc.pending.timers.Len())
+	}
+
+	runtime.ReadMemStats(&after)
+	if allocs := after.Mallocs - before.Mallocs; allocs > 100 {
+		t.Fatalf("expected no allocation got=%d", allocs)
+	}
+	if slowest > 100*time.Millisecond {
+		t.Fatalf("a batch of 1024 registrations took %s", slowest)
+	}
+	t.Logf("slowest batch of 1024 registrations took %s", slowest)
+}
+
+func TestIOErrorHandler(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	var fds [2]int
+	if err := syscall.Pipe(fds[:]); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+
+	var (
+		reportedFd  = -1
+		reportedErr error
+	)
+	ioc.SetErrorHandler(func(fd int, err error) {
+		reportedFd, reportedErr = fd, err
+	})
+
+	dispatched := false
+	slot := &internal.Slot{Fd: fds[0]}
+	slot.Set(internal.ReadEvent, func(error) { dispatched = true })
+	if err := ioc.SetRead(slot); err != nil {
+		t.Fatal(err)
+	}
+	ioc.Register(slot)
+	defer ioc.Deregister(slot)
+
+	// Simulate a bookkeeping bug: the slot forgets about the read it is registered for.
+	slot.Events = 0
+	if _, err := syscall.Write(fds[1], []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = ioc.RunOneFor(10 * time.Millisecond)
+	if dispatched {
+		t.Fatal("the read handler should not be dispatched")
+	}
+	if reportedFd != fds[0] {
+		t.Fatalf("expected the event to be reported for fd=%d got=%d", fds[0], reportedFd)
+	}
+	if !errors.Is(reportedErr, sonicerrors.ErrUnsolicitedEvent) {
+		t.Fatalf("expected ErrUnsolicitedEvent got=%v", reportedErr)
+	}
+}
+
+func BenchmarkIORegisterChurn(b *testing.B) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	// 100k live registrations, most of them past the static range, of which one is replaced on each iteration.
+	const live = 100_000
+	slots := make([]*internal.Slot, live)
+	for i := range slots {
+		slots[i] = &internal.Slot{Fd: i}
+		ioc.Register(slots[i])
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		j := (i * 7919) % live
+		ioc.Deregister(slots[j])
+		ioc.Register(slots[j])
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "churns/s")
+}
+
+func BenchmarkIOConnChurn(b *testing.B) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		AsyncDial(ioc, "tcp", ln.Addr().String(), func(err error, conn Conn) {
+			if err != nil {
+				b.Fatal(err)
+			}
+			conn.Close()
+		})
+		for ioc.poller.Pending() > 0 {
+			_, _ = ioc.PollOne()
+		}
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "conns/s")
+}
+
 func BenchmarkPollOne(b *testing.B) {
 	ioc := MustIO()
 	defer ioc.Close()
@@ -329,3 +607,167 @@ func BenchmarkPollOne(b *testing.B) {
 		ioc.PollOne()
 	}
 }
+
+func TestIOHeartbeat(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	if !ioc.LastLoopHeartbeat().IsZero() {
+		t.Fatal("heartbeat should not be set before being enabled")
+	}
+
+	if err := ioc.EnableHeartbeat(5 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// A wedged loop does not beat.
+	time.Sleep(50 * time.Millisecond)
+	if age := time.Since(ioc.LastLoopHeartbeat()); age < 50*time.Millisecond {
+		t.Fatalf("heartbeat should be stale age=%s", age)
+	}
+	if posted := ioc.Posted(); posted != 1 {
+		t.Fatalf("expected a single posted heartbeat got=%d", posted)
+	}
+
+	_ = ioc.RunOneFor(time.Millisecond)
+	if age := time.Since(ioc.LastLoopHeartbeat()); age > 10*time.Millisecond {
+		t.Fatalf("heartbeat should be fresh age=%s", age)
+	}
+
+	ioc.DisableHeartbeat()
+	time.Sleep(20 * time.Millisecond)
+	_ = ioc.RunOneFor(time.Millisecond) // a beat might have been posted right before disabling
+	before := ioc.LastLoopHeartbeat()
+	time.Sleep(20 * time.Millisecond)
+	_ = ioc.RunOneFor(time.Millisecond)
+	if !ioc.LastLoopHeartbeat().Equal(before) {
+		t.Fatal("heartbeat should not beat once disabled")
+	}
+}
+
+func TestIOHeartbeatPostQueueFull(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ioc.SetMaxPosts(1)
+	if err := ioc.Post(func() {}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioc.EnableHeartbeat(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	enabled := ioc.LastLoopHeartbeat()
+
+	// The heartbeats cannot be posted while the queue is full, which must not stop the watchdog.
+	time.Sleep(20 * time.Millisecond)
+	_ = ioc.RunOneFor(time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && !ioc.LastLoopHeartbeat().After(enabled) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if !ioc.LastLoopHeartbeat().After(enabled) {
+		t.Fatal("expected the heartbeat to beat once the post queue has room")
+	}
+}
+
+func TestIOAdaptivePolling(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	if ms := ioc.nextPollTimeoutMs(); ms != -1 {
+		t.Fatalf("expected to block by default got=%dms", ms)
+	}
+
+	ioc.SetAdaptivePolling(true)
+
+	for i := 0; i < AdaptiveBusyPolls; i++ {
+		if ms := ioc.nextPollTimeoutMs(); ms != 0 {
+			t.Fatalf("expected to busy-poll on idle poll %d got=%dms", i, ms)
+		}
+		ioc.adapt(0)
+	}
+
+	var waits []int
+	for i := 0; i < 10; i++ {
+		waits = append(waits, ioc.nextPollTimeoutMs())
+		ioc.adapt(0)
+	}
+	expected := []int{1, 2, 4, 8, 16, -1, -1, -1, -1, -1}
+	for i := range expected {
+		if waits[i] != expected[i] {
+			t.Fatalf("expected waits=%v got=%v", expected, waits)
+		}
+	}
+
+	// Work brings the loop back to busy-polling.
+	ioc.adapt(1)
+	if ms := ioc.nextPollTimeoutMs(); ms != 0 {
+		t.Fatalf("expected to busy-poll after processing events got=%dms", ms)
+	}
+
+	// The poll timeout bounds the waits.
+	ioc.SetPollTimeout(3 * time.Millisecond)
+	waits = waits[:0]
+	for i := 0; i < AdaptiveBusyPolls+10; i++ {
+		if ms := ioc.nextPollTimeoutMs(); ms != 0 {
+			waits = append(waits, ms)
+		}
+		ioc.adapt(0)
+	}
+	expected = []int{1, 2, 3, 3, 3, 3, 3, 3, 3, 3}
+	for i := range expected {
+		if waits[i] != expected[i] {
+			t.Fatalf("expected waits=%v got=%v", expected, waits)
+		}
+	}
+}
+
+func TestIOBusyPoll(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ioc.SetBusyPoll(3)
+	if ioc.BusyPoll() != 3 {
+		t.Fatalf("expected 3 spins got=%d", ioc.BusyPoll())
+	}
+
+	var timeouts []int
+	for i := 0; i < 6; i++ {
+		timeouts = append(timeouts, ioc.nextPollTimeoutMs())
+		ioc.adapt(0)
+	}
+	expected := []int{0, 0, 0, -1, -1, -1}
+	for i := range expected {
+		if timeouts[i] != expected[i] {
+			t.Fatalf("expected timeouts=%v got=%v", expected, timeouts)
+		}
+	}
+
+	// Work starts the spins again.
+	ioc.adapt(1)
+	if ms := ioc.nextPollTimeoutMs(); ms != 0 {
+		t.Fatalf("expected to busy-poll after processing events got=%dms", ms)
+	}
+
+	// The spins replace the busy polls of adaptive polling.
+	ioc.SetAdaptivePolling(true)
+	timeouts = timeouts[:0]
+	for i := 0; i < 6; i++ {
+		timeouts = append(timeouts, ioc.nextPollTimeoutMs())
+		ioc.adapt(0)
+	}
+	expected = []int{0, 0, 0, 1, 2, 4}
+	for i := range expected {
+		if timeouts[i] != expected[i] {
+			t.Fatalf("expected timeouts=%v got=%v", expected, timeouts)
+		}
+	}
+
+	ioc.SetAdaptivePolling(false)
+	ioc.SetBusyPoll(0)
+	if ms := ioc.nextPollTimeoutMs(); ms != -1 {
+		t.Fatalf("expected to block with busy-polling disabled got=%dms", ms)
+	}
+}
@@ -1,9 +1,11 @@
 package sonic
 
 import (
+	"errors"
 	"net"
 	"os"
 	"syscall"
+	"time"
 
 	"github.com/csdenboer/sonic/internal"
 	"github.com/csdenboer/sonic/sonicerrors"
@@ -12,12 +14,87 @@ import (
 
 var _ Listener = &listener{}
 
+const (
+	// DefaultAcceptRetryMinDelay and DefaultAcceptRetryMaxDelay bound the pauses of AsyncAccept when the process runs
+	// out of file descriptors, see Listener.SetAcceptRetryDelay.
+	DefaultAcceptRetryMinDelay = 5 * time.Millisecond
+	DefaultAcceptRetryMaxDelay = time.Second
+)
+
 type listener struct {
 	ioc  *IO
 	slot internal.Slot
 	addr net.Addr
 
+	// unlink is the path of the unix domain socket file removed on Close, if any.
+	unlink string
+
 	dispatched int
+
+	stats ListenerStats
+
+	// Connections accepted in the current second, which starts at rateStart. See ListenerStats.AcceptRate.
+	rateStart time.Time
+	rateCount uint64
+
+	throttle rateThrottle
+	retry    acceptRetry
+
+	// pending is set while an AsyncAccept waits for a connection or is paused by the throttle timer.
+	pending bool
+}
+
+// acceptRetry paces the accepts retried after the process or the system ran out of resources. The delay doubles from
+// min to max with each failed retry and is reset by a successful accept.
+type acceptRetry struct {
+	min, max time.Duration
+	delay    time.Duration
+}
+
+// next returns the delay of the next retry.
+func (r *acceptRetry) next() time.Duration {
+	if r.delay == 0 {
+		r.delay = r.min
+	} else if r.delay *= 2; r.delay > r.max {
+		r.delay = r.max
+	}
+	return r.delay
+}
+
+// exhausted returns true if err means the accept failed because the process or the system ran out of file descriptors
+// or memory. The pending connection stays in the accept queue, so the accept can be retried once resources are freed.
+func exhausted(err error) bool {
+	return errors.Is(err, syscall.EMFILE) ||
+		errors.Is(err, syscall.ENFILE) ||
+		errors.Is(err, syscall.ENOBUFS) ||
+		errors.Is(err, syscall.ENOMEM)
+}
+
+// rateThrottle is a token bucket limiting the rate of an operation, such as accepts or dials.
+type rateThrottle struct {
+	rate   float64 // tokens per second, 0 means no limit
+	burst  float64
+	tokens float64
+	last   time.Time
+	timer  *Timer
+}
+
+// wait returns how long to wait until the next operation is allowed, refilling the bucket first.
+func (t *rateThrottle) wait(now time.Time) time.Duration {
+	if t.rate <= 0 {
+		return 0
+	}
+
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if t.tokens > t.burst {
+		t.tokens = t.burst
+	}
+	t.last = now
+
+	if t.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - t.tokens) / t.rate * float64(time.Second))
 }
 
 // Listen creates a Listener that listens for new connections on the local address.
@@ -29,6 +106,9 @@ type listener struct {
 // If the option Nonblocking with value set to true is passed in, you should use AsyncAccept()
 // to accept incoming connections. In this case, AsyncAccept() will not block if no connections
 // are present in the queue.
+//
+// The option ReusePort lets several listeners bind the same address, for example one per IO of an IOPool, in which
+// case the kernel balances the incoming connections among them.
 func Listen(
 	ioc *IO,
 	network,
@@ -44,21 +124,52 @@ func Listen(
 		ioc:  ioc,
 		slot: internal.Slot{Fd: fd},
 		addr: listenAddr,
+		retry: acceptRetry{
+			min: DefaultAcceptRetryMinDelay,
+			max: DefaultAcceptRetryMaxDelay,
+		},
+	}
+	if network == "unix" {
+		l.unlink = addr
 	}
 	return l, nil
 }
 
 func (l *listener) Accept() (Conn, error) {
-	return l.accept()
+	return l.accept(false)
 }
 
 func (l *listener) AsyncAccept(cb AcceptCallback) {
+	if l.pending {
+		cb(sonicerrors.ErrAcceptPending, nil)
+		return
+	}
+
+	// The timer pausing the accepts is created upfront, as it cannot be once the process ran out of file descriptors.
+	if l.throttle.timer == nil && l.retry.min > 0 {
+		timer, err := NewTimer(l.ioc)
+		if err != nil {
+			cb(err, nil)
+			return
+		}
+		l.throttle.timer = timer
+	}
+
+	if wait := l.throttle.wait(time.Now()); wait > 0 {
+		l.stats.Throttled++
+		l.scheduleAccept(wait, cb)
+		return
+	}
+
 	if l.dispatched >= MaxCallbackDispatch {
 		l.asyncAccept(cb)
 	} else {
-		conn, err := l.accept()
+		conn, err := l.accept(true)
 		if err != nil && (err == sonicerrors.ErrWouldBlock) {
 			l.asyncAccept(cb)
+		} else if err != nil && l.retry.min > 0 && exhausted(err) {
+			l.stats.AcceptPauses++
+			l.scheduleAccept(l.retry.next(), cb)
 		} else {
 			l.dispatched++
 			cb(err, conn)
@@ -67,6 +178,28 @@ func (l *listener) AsyncAccept(cb AcceptCallback) {
 	}
 }
 
+// scheduleAccept retries AsyncAccept after wait.
+func (l *listener) scheduleAccept(wait time.Duration, cb AcceptCallback) {
+	if l.throttle.timer == nil {
+		timer, err := NewTimer(l.ioc)
+		if err != nil {
+			cb(err, nil)
+			return
+		}
+		l.throttle.timer = timer
+	}
+
+	err := l.throttle.timer.ScheduleOnce(wait, func() {
+		l.pending = false
+		l.AsyncAccept(cb)
+	})
+	if err != nil {
+		cb(err, nil)
+	} else {
+		l.pending = true
+	}
+}
+
 func (l *listener) asyncAccept(cb AcceptCallback) {
 	l.slot.Set(internal.ReadEvent, l.handleAsyncAccept(cb))
 
@@ -74,23 +207,26 @@ func (l *listener) asyncAccept(cb AcceptCallback) {
 		cb(err, nil)
 	} else {
 		l.ioc.Register(&l.slot)
+		l.pending = true
 	}
 }
 
 func (l *listener) handleAsyncAccept(cb AcceptCallback) internal.Handler {
 	return func(err error) {
 		l.ioc.Deregister(&l.slot)
+		l.pending = false
 
 		if err != nil {
 			cb(err, nil)
 		} else {
-			conn, err := l.accept()
-			cb(err, conn)
+			// The rate limit might have been set while waiting.
+			l.AsyncAccept(cb)
 		}
 	}
 }
 
-func (l *listener) accept() (Conn, error) {
+// accept accepts the next connection. It counts against the rate limit if async is set, see SetAcceptRateLimit.
+func (l *listener) accept(async bool) (Conn, error) {
 	fd, addr, err := syscall.Accept(l.slot.Fd)
 
 	if err != nil {
@@ -98,8 +234,11 @@ func (l *listener) accept() (Conn, error) {
 		if err == syscall.EWOULDBLOCK || err == syscall.EAGAIN {
 			return nil, sonicerrors.ErrWouldBlock
 		}
+		l.stats.AcceptErrors++
 		return nil, os.NewSyscallError("accept", err)
 	}
+	l.onAccepted(async)
+	l.retry.delay = 0
 
 	localAddr, err := internal.SocketAddress(fd)
 	if err != nil {
@@ -112,9 +251,79 @@ func (l *listener) accept() (Conn, error) {
 	return conn, syscall.SetNonblock(conn.RawFd(), true)
 }
 
+func (l *listener) onAccepted(async bool) {
+	l.stats.Accepted++
+
+	if async && l.throttle.rate > 0 {
+		l.throttle.tokens--
+	}
+
+	now := time.Now()
+	if elapsed := now.Sub(l.rateStart); elapsed >= time.Second {
+		if elapsed < 2*time.Second {
+			l.stats.AcceptRate = l.rateCount
+		} else {
+			// Nothing was accepted in the last complete second.
+			l.stats.AcceptRate = 0
+		}
+		l.rateStart = now
+		l.rateCount = 0
+	}
+	l.rateCount++
+}
+
+func (l *listener) Stats() ListenerStats {
+	stats := l.stats
+	if time.Since(l.rateStart) >= 2*time.Second {
+		stats.AcceptRate = 0
+	}
+
+	queued, capacity, err := internal.AcceptBacklog(l.slot.Fd)
+	if err != nil {
+		stats.Backlog, stats.BacklogCapacity = -1, -1
+	} else {
+		stats.Backlog, stats.BacklogCapacity = queued, capacity
+	}
+
+	if overflows, err := internal.ListenOverflows(); err != nil {
+		stats.HostListenOverflows = -1
+	} else {
+		stats.HostListenOverflows = int64(overflows)
+	}
+
+	return stats
+}
+
+func (l *listener) SetAcceptRateLimit(rate float64, burst int) {
+	if burst < 1 {
+		burst = 1
+	}
+	l.throttle.rate = rate
+	l.throttle.burst = float64(burst)
+	l.throttle.tokens = float64(burst)
+	l.throttle.last = time.Now()
+}
+
+func (l *listener) SetAcceptRetryDelay(min, max time.Duration) {
+	if max < min {
+		max = min
+	}
+	l.retry = acceptRetry{min: min, max: max}
+}
+
 func (l *listener) Close() error {
+	if l.throttle.timer != nil {
+		_ = l.throttle.timer.Close()
+	}
 	_ = l.ioc.poller.Del(&l.slot)
-	return syscall.Close(l.slot.Fd)
+	err := syscall.Close(l.slot.Fd)
+	if l.unlink != "" {
+		if uerr := internal.UnlinkUnix(l.unlink); err == nil {
+			err = uerr
+		}
+		l.unlink = ""
+	}
+	return err
 }
 
 func (l *listener) Addr() net.Addr {
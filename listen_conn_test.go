@@ -2,9 +2,17 @@ package sonic
 
 import (
 	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/csdenboer/sonic/internal"
+	"github.com/csdenboer/sonic/sonicerrors"
+	"github.com/csdenboer/sonic/sonicopts"
 )
 
 func TestTCPConnListenerDefaultOpts(t *testing.T) {
@@ -76,3 +84,305 @@ func TestTCPConnListenerDefaultOpts(t *testing.T) {
 		mark <- struct{}{}
 	}
 }
+
+func TestTCPConnListenerHostListenOverflows(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the listen overflows can only be queried on linux")
+	}
+
+	backlog := internal.ListenBacklog
+	internal.ListenBacklog = 1
+	defer func() { internal.ListenBacklog = backlog }()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := Listen(ioc, "tcp", "localhost:9987", sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	before := ln.Stats().HostListenOverflows
+	if before < 0 {
+		t.Fatal("expected the listen overflows to be queried")
+	}
+
+	// Nothing is accepted, so the clients which do not fit in the accept queue are dropped by the kernel.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if conn, err := net.DialTimeout("tcp", "localhost:9987", 200*time.Millisecond); err == nil {
+				_ = conn.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if after := ln.Stats().HostListenOverflows; after <= before {
+		t.Fatalf("expected the listen overflows to grow from %d got=%d", before, after)
+	}
+}
+
+func TestTCPConnListenerAcceptRateLimit(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := Listen(ioc, "tcp", "localhost:9995", sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ln.SetAcceptRateLimit(20, 2)
+
+	// The kernel completes the handshakes, so all clients are queued before anything is accepted.
+	var clients []net.Conn
+	for i := 0; i < 4; i++ {
+		conn, err := net.Dial("tcp", "localhost:9995")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		clients = append(clients, conn)
+	}
+
+	if runtime.GOOS == "linux" {
+		if stats := ln.Stats(); stats.Backlog != 4 || stats.BacklogCapacity <= 0 {
+			t.Fatalf("expected 4 queued connections got=%d capacity=%d", stats.Backlog, stats.BacklogCapacity)
+		}
+	}
+
+	accepted := 0
+	var onAccept AcceptCallback
+	onAccept = func(err error, conn Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		accepted++
+		if accepted < len(clients) {
+			ln.AsyncAccept(onAccept)
+		}
+	}
+	start := time.Now()
+	ln.AsyncAccept(onAccept)
+
+	// Only the burst is accepted right away.
+	if accepted != 2 {
+		t.Fatalf("expected 2 connections accepted right away got=%d", accepted)
+	}
+
+	for time.Since(start) < time.Second && accepted < len(clients) {
+		_, _ = ioc.PollOne()
+	}
+
+	if accepted != len(clients) {
+		t.Fatalf("expected %d accepted connections got=%d", len(clients), accepted)
+	}
+	// The remaining 2 connections are accepted at 20 per second.
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("connections accepted too fast elapsed=%s", elapsed)
+	}
+
+	stats := ln.Stats()
+	if stats.Accepted != 4 || stats.AcceptErrors != 0 || stats.Throttled == 0 {
+		t.Fatalf("invalid stats %+v", stats)
+	}
+}
+
+func TestTCPConnListenerAcceptPending(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := Listen(ioc, "tcp", "localhost:9988", sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ln.SetAcceptRateLimit(10, 1)
+
+	for i := 0; i < 4; i++ {
+		conn, err := net.Dial("tcp", "localhost:9988")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+
+	// Accept does not count against the rate limit.
+	for i := 0; i < 2; i++ {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+
+	var accepted []Conn
+	onAccept := func(err error, conn Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		accepted = append(accepted, conn)
+	}
+	ln.AsyncAccept(onAccept)
+	if len(accepted) != 1 {
+		t.Fatalf("expected the burst to be left for AsyncAccept got=%d accepted", len(accepted))
+	}
+	accepted[0].Close()
+
+	// The next accept is paused by the rate limit, so another one cannot be issued meanwhile.
+	ln.AsyncAccept(onAccept)
+	var pendingErr error
+	ln.AsyncAccept(func(err error, _ Conn) { pendingErr = err })
+	if pendingErr != sonicerrors.ErrAcceptPending {
+		t.Fatalf("expected ErrAcceptPending got=%v", pendingErr)
+	}
+
+	if err := ioc.RunUntil(func() bool { return len(accepted) == 2 }); err != nil {
+		t.Fatal(err)
+	}
+	accepted[1].Close()
+}
+
+func TestUnixListenerPeerCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sonic.sock")
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := Listen(ioc, "unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	if addr, ok := ln.Addr().(*net.UnixAddr); !ok || addr.Name != path {
+		t.Fatalf("unexpected listen address=%v", ln.Addr())
+	}
+
+	client, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	cred, err := GetPeerCredentials(conn.RawFd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.UID != uint32(os.Getuid()) || cred.PID != os.Getpid() {
+		t.Fatalf("expected the credentials of this process got=%+v", cred)
+	}
+	if runtime.GOOS == "linux" && cred.GID != uint32(os.Getgid()) {
+		t.Fatalf("expected the gid of this process got=%d", cred.GID)
+	}
+}
+
+func TestTCPConnListenerReusePort(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln1, err := Listen(ioc, "tcp", "127.0.0.1:0", sonicopts.Nonblocking(true), sonicopts.ReusePort(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln1.Close()
+
+	addr, err := internal.SocketAddress(ln1.RawFd())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln2, err := Listen(ioc, "tcp", addr.String(), sonicopts.Nonblocking(true), sonicopts.ReusePort(true))
+	if err != nil {
+		t.Fatalf("expected a second listener on %s err=%v", addr, err)
+	}
+	defer ln2.Close()
+
+	if _, err := Listen(ioc, "tcp", addr.String(), sonicopts.Nonblocking(true)); err == nil {
+		t.Fatal("expected a listener without ReusePort to fail")
+	}
+}
+
+func TestTCPConnListenerPausesWithoutFileDescriptors(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := Listen(ioc, "tcp", "127.0.0.1:0", sonicopts.Nonblocking(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	ln.SetAcceptRetryDelay(time.Millisecond, 10*time.Millisecond)
+
+	addr, err := internal.SocketAddress(ln.RawFd())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var accepted Conn
+	ln.AsyncAccept(func(err error, conn Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		accepted = conn
+	})
+
+	client, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Limit the process to the file descriptors it has, such that the accept fails with EMFILE.
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		t.Fatal(err)
+	}
+	lowest, err := syscall.Dup(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = syscall.Close(lowest)
+
+	exhausted := limit
+	exhausted.Cur = uint64(lowest)
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &exhausted); err != nil {
+		t.Skipf("cannot lower the file descriptor limit err=%v", err)
+	}
+
+	start := time.Now()
+	for ln.Stats().AcceptPauses < 3 && time.Since(start) < 5*time.Second {
+		_, _ = ioc.PollOne()
+	}
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		t.Fatal(err)
+	}
+	if stats := ln.Stats(); stats.AcceptPauses < 3 || accepted != nil {
+		t.Fatalf("expected the accepts to pause stats=%+v", stats)
+	}
+
+	for accepted == nil && time.Since(start) < 5*time.Second {
+		_, _ = ioc.PollOne()
+	}
+	if accepted == nil {
+		t.Fatal("expected the connection to be accepted once file descriptors are available")
+	}
+	defer accepted.Close()
+
+	if stats := ln.Stats(); stats.Accepted != 1 {
+		t.Fatalf("expected 1 accepted connection stats=%+v", stats)
+	}
+}
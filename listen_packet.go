@@ -2,10 +2,15 @@ package sonic
 
 import "github.com/csdenboer/sonic/sonicopts"
 
+// ListenPacket creates a PacketConn bound to addr. network is a UDP network, or unixgram, in which case addr is the
+// path of the socket, see ListenUnixgram.
 func ListenPacket(
 	ioc *IO,
 	network, addr string,
 	opts ...sonicopts.Option,
 ) (PacketConn, error) {
+	if network == "unixgram" {
+		return ListenUnixgram(ioc, addr, opts...)
+	}
 	return NewPacketConn(ioc, network, addr, opts...)
 }
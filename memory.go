@@ -0,0 +1,82 @@
+package sonic
+
+// MemoryAccount tracks the bytes held by a component, such as the buffers and write queues of a stream, along with the
+// high-water mark of that usage.
+//
+// Accounts form a tree: the usage of an account includes the usage of its children. Each IO has a root account,
+// returned by IO.MemoryAccount, under which the accounts of its streams are created, so the root account holds the
+// usage of all streams of the IO.
+//
+// A limit can be set on any account. Components check OverLimit, which considers the limits of all ancestors, to apply
+// backpressure, for example by refusing writes.
+//
+// A MemoryAccount must only be used from the goroutine running the IO.
+type MemoryAccount struct {
+	parent *MemoryAccount
+
+	own       int64 // bytes accounted directly, excluding children
+	inUse     int64 // own plus the usage of all children
+	highWater int64
+	limit     int64 // 0 means no limit
+}
+
+// NewMemoryAccount creates an account whose usage is included in the usage of parent. parent may be nil.
+func NewMemoryAccount(parent *MemoryAccount) *MemoryAccount {
+	return &MemoryAccount{parent: parent}
+}
+
+// Set sets the number of bytes held directly by the owner of the account.
+func (a *MemoryAccount) Set(n int) {
+	delta := int64(n) - a.own
+	a.own = int64(n)
+	for acc := a; acc != nil; acc = acc.parent {
+		acc.inUse += delta
+		if acc.inUse > acc.highWater {
+			acc.highWater = acc.inUse
+		}
+	}
+}
+
+// InUse returns the number of bytes held by the owner of the account and by the owners of its children.
+func (a *MemoryAccount) InUse() int64 {
+	return a.inUse
+}
+
+// HighWater returns the greatest value InUse had.
+func (a *MemoryAccount) HighWater() int64 {
+	return a.highWater
+}
+
+// ResetHighWater sets the high-water mark to the current usage.
+func (a *MemoryAccount) ResetHighWater() {
+	a.highWater = a.inUse
+}
+
+// SetLimit sets the number of bytes above which the account is over its limit. 0 removes the limit.
+func (a *MemoryAccount) SetLimit(n int64) {
+	if n < 0 {
+		n = 0
+	}
+	a.limit = n
+}
+
+// Limit returns the limit set with SetLimit.
+func (a *MemoryAccount) Limit() int64 {
+	return a.limit
+}
+
+// OverLimit returns true if the account, or any of its ancestors, holds more bytes than its limit.
+func (a *MemoryAccount) OverLimit() bool {
+	for acc := a; acc != nil; acc = acc.parent {
+		if acc.limit > 0 && acc.inUse > acc.limit {
+			return true
+		}
+	}
+	return false
+}
+
+// Close releases the bytes accounted directly. They can be accounted again with Set, for example by a component which
+// is reused after being closed.
+func (a *MemoryAccount) Close() {
+	a.Set(0)
+}
@@ -0,0 +1,105 @@
+package sonic
+
+import "testing"
+
+func TestConnMemoryAccountWriteQueue(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	client, server := socketPair(t, ioc)
+	defer server.Close()
+
+	// The first write fills the socket buffer and stays in progress, so the second one is queued behind it.
+	big := make([]byte, 8*1024*1024)
+	small := make([]byte, 1000)
+	client.AsyncWriteAll(big, func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	done := false
+	client.AsyncWrite(small, func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		done = true
+	})
+	if n := client.MemoryAccount().InUse(); n != int64(len(small)) {
+		t.Fatalf("expected %d bytes in use got=%d", len(small), n)
+	}
+	if n := ioc.MemoryAccount().InUse(); n != int64(len(small)) {
+		t.Fatalf("expected %d bytes in use by the IO got=%d", len(small), n)
+	}
+
+	b := make([]byte, 64*1024)
+	var onRead AsyncCallback
+	onRead = func(err error, _ int) {
+		if err == nil {
+			server.AsyncRead(b, onRead)
+		}
+	}
+	server.AsyncRead(b, onRead)
+	if err := ioc.RunUntil(func() bool { return done }); err != nil {
+		t.Fatal(err)
+	}
+	if n := ioc.MemoryAccount().InUse(); n != 0 {
+		t.Fatalf("expected no bytes in use once written got=%d", n)
+	}
+
+	// Queued writes are released when the connection is closed.
+	client.AsyncWriteAll(big, func(error, int) {})
+	client.AsyncWrite(small, func(error, int) {})
+	if n := ioc.MemoryAccount().InUse(); n == 0 {
+		t.Fatal("expected the queued write to be accounted")
+	}
+	client.Close()
+	if n := ioc.MemoryAccount().InUse(); n != 0 {
+		t.Fatalf("expected no bytes in use once closed got=%d", n)
+	}
+}
+
+func TestCodecConnMemoryAccount(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	client, in := socketPair(t, ioc)
+	defer client.Close()
+
+	src, dst := NewByteBuffer(), NewByteBuffer()
+	codecConn, err := NewNonblockingCodecConn[TestItem, TestItem](in, &TestCodec{}, src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := codecConn.WriteNext(TestItem{V: [5]byte{1, 2, 3, 4, 5}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := codecConn.ReadNext(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The account of the codec connection is under the one of the connection it runs over.
+	want := int64(src.Cap() + dst.Cap())
+	if want == 0 {
+		t.Fatal("expected the buffers to be allocated")
+	}
+	if n := codecConn.MemoryAccount().InUse(); n != want {
+		t.Fatalf("expected %d bytes in use got=%d", want, n)
+	}
+	if n := in.MemoryAccount().InUse(); n != want {
+		t.Fatalf("expected %d bytes in use by the connection got=%d", want, n)
+	}
+	if n := ioc.MemoryAccount().InUse(); n != want {
+		t.Fatalf("expected %d bytes in use by the IO got=%d", want, n)
+	}
+
+	if err := codecConn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := ioc.MemoryAccount().InUse(); n != 0 {
+		t.Fatalf("expected no bytes in use once closed got=%d", n)
+	}
+}
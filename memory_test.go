@@ -0,0 +1,45 @@
+package sonic
+
+import "testing"
+
+func TestMemoryAccount(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	root := ioc.MemoryAccount()
+	a := NewMemoryAccount(root)
+	b := NewMemoryAccount(root)
+
+	a.Set(100)
+	b.Set(50)
+	if root.InUse() != 150 {
+		t.Fatalf("expected 150 bytes in use got=%d", root.InUse())
+	}
+
+	a.Set(10)
+	if root.InUse() != 60 || root.HighWater() != 150 {
+		t.Fatalf("expected 60 bytes in use and a high-water of 150 got=%d and %d", root.InUse(), root.HighWater())
+	}
+	if a.HighWater() != 100 {
+		t.Fatalf("expected a high-water of 100 got=%d", a.HighWater())
+	}
+
+	root.SetLimit(100)
+	if a.OverLimit() {
+		t.Fatal("expected to be under the limit")
+	}
+	b.Set(200)
+	if !a.OverLimit() || !b.OverLimit() {
+		t.Fatal("expected the children to be over the limit of their parent")
+	}
+
+	b.Close()
+	if root.InUse() != 10 || a.OverLimit() {
+		t.Fatalf("expected 10 bytes in use got=%d", root.InUse())
+	}
+
+	root.ResetHighWater()
+	if root.HighWater() != 10 {
+		t.Fatalf("expected a high-water of 10 got=%d", root.HighWater())
+	}
+}
@@ -0,0 +1,263 @@
+package sonic
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+	"github.com/csdenboer/sonic/sonicopts"
+)
+
+// Endpoint is one of the addresses a MultiEndpointDialer dials.
+type Endpoint struct {
+	Addr string
+
+	// Priority ranks the endpoints: endpoints with a lower priority are only dialed if all endpoints with a higher
+	// priority, that is with a greater value, are unhealthy.
+	Priority int
+
+	// Weight is the share of the connections dialed to this endpoint among the healthy endpoints of the same
+	// priority. It is 1 if not positive.
+	Weight int
+}
+
+// EndpointStatus is the state of an Endpoint as seen by a MultiEndpointDialer.
+type EndpointStatus struct {
+	Endpoint
+
+	Healthy bool
+	Dialed  uint64 // Number of connections dialed successfully.
+	Failed  uint64 // Number of dials and health checks which failed.
+	LastErr error  // The error of the last failed dial or health check.
+}
+
+type endpointState struct {
+	EndpointStatus
+
+	// current is the running weight of the endpoint in the smooth weighted round-robin among the healthy endpoints of
+	// the same priority.
+	current int
+
+	// checking is set while a health check of the endpoint is in progress.
+	checking bool
+}
+
+// MultiEndpointDialer dials one of several endpoints exposing the same service, such as the gateways of an exchange.
+//
+// Each Dial picks the best healthy endpoint: the ones with the highest priority first and, among those, in proportion
+// to their weights. An endpoint which fails to be dialed is marked unhealthy and Dial fails over to the next best
+// endpoint. Unhealthy endpoints are only dialed if no healthy endpoint can be dialed, or once a health check, see
+// StartHealthChecks, succeeds to connect to them.
+//
+// A MultiEndpointDialer must only be used from the goroutine running the IO.
+type MultiEndpointDialer struct {
+	ioc       *IO
+	network   string
+	timeout   time.Duration
+	opts      []sonicopts.Option
+	endpoints []*endpointState
+
+	timer *Timer
+}
+
+// NewMultiEndpointDialer creates a MultiEndpointDialer for the given endpoints, all of which are initially healthy.
+// Each dial times out after timeout.
+func NewMultiEndpointDialer(
+	ioc *IO,
+	network string,
+	endpoints []Endpoint,
+	timeout time.Duration,
+	opts ...sonicopts.Option,
+) (*MultiEndpointDialer, error) {
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no endpoints to dial")
+	}
+
+	d := &MultiEndpointDialer{
+		ioc:     ioc,
+		network: network,
+		timeout: timeout,
+		opts:    opts,
+	}
+	for _, ep := range endpoints {
+		if ep.Weight <= 0 {
+			ep.Weight = 1
+		}
+		d.endpoints = append(d.endpoints, &endpointState{
+			EndpointStatus: EndpointStatus{Endpoint: ep, Healthy: true},
+		})
+	}
+	return d, nil
+}
+
+// AsyncDial connects to the best endpoint, failing over to the next best ones, without blocking the IO, see
+// AsyncDialTimeout. cb is invoked with the connection, or, if no endpoint can be dialed, with the errors of all dials,
+// joined.
+func (d *MultiEndpointDialer) AsyncDial(cb AcceptCallback) {
+	d.asyncDial(make(map[*endpointState]struct{}, len(d.endpoints)), nil, cb)
+}
+
+func (d *MultiEndpointDialer) asyncDial(tried map[*endpointState]struct{}, errs []error, cb AcceptCallback) {
+	ep := d.pick(tried)
+	if ep == nil {
+		cb(errors.Join(errs...), nil)
+		return
+	}
+	tried[ep] = struct{}{}
+
+	AsyncDialTimeout(d.ioc, d.network, ep.Addr, d.timeout, func(err error, conn Conn) {
+		if err == nil {
+			ep.Healthy = true
+			ep.Dialed++
+			cb(nil, conn)
+			return
+		}
+
+		d.markFailed(ep, err)
+		d.asyncDial(tried, append(errs, fmt.Errorf("%s: %w", ep.Addr, err)), cb)
+	}, d.opts...)
+}
+
+// Dial is the synchronous counterpart of AsyncDial: it blocks the calling goroutine, which must be the one running the
+// IO, until an endpoint is dialed or all failed. The IO is run in the meantime.
+//
+// sonicerrors.ErrReentrantWait is returned, without dialing, if Dial is called from a handler of the IO.
+func (d *MultiEndpointDialer) Dial() (Conn, error) {
+	if d.ioc.Dispatching() {
+		return nil, sonicerrors.ErrReentrantWait
+	}
+
+	var (
+		done bool
+		conn Conn
+		err  error
+	)
+	d.AsyncDial(func(dialErr error, c Conn) {
+		done, err, conn = true, dialErr, c
+	})
+
+	if runErr := d.ioc.RunUntil(func() bool { return done }); runErr != nil {
+		return nil, runErr
+	}
+	return conn, err
+}
+
+// pick returns the best endpoint which was not tried yet, or nil if all were tried.
+func (d *MultiEndpointDialer) pick(tried map[*endpointState]struct{}) *endpointState {
+	if ep := d.pickWeighted(tried); ep != nil {
+		return ep
+	}
+
+	// All healthy endpoints were tried, so try the unhealthy ones by priority as a last resort.
+	var best *endpointState
+	for _, ep := range d.endpoints {
+		if _, ok := tried[ep]; ok {
+			continue
+		}
+		if best == nil || ep.Priority > best.Priority {
+			best = ep
+		}
+	}
+	return best
+}
+
+// pickWeighted picks among the healthy endpoints of the highest priority with a smooth weighted round-robin, which
+// spreads the picks of each endpoint evenly rather than in bursts.
+func (d *MultiEndpointDialer) pickWeighted(tried map[*endpointState]struct{}) *endpointState {
+	var candidates []*endpointState
+	for _, ep := range d.endpoints {
+		if _, ok := tried[ep]; ok || !ep.Healthy {
+			continue
+		}
+		if len(candidates) > 0 && ep.Priority < candidates[0].Priority {
+			continue
+		}
+		if len(candidates) > 0 && ep.Priority > candidates[0].Priority {
+			candidates = candidates[:0]
+		}
+		candidates = append(candidates, ep)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	var (
+		best  *endpointState
+		total int
+	)
+	for _, ep := range candidates {
+		ep.current += ep.Weight
+		total += ep.Weight
+		if best == nil || ep.current > best.current {
+			best = ep
+		}
+	}
+	best.current -= total
+	return best
+}
+
+func (d *MultiEndpointDialer) markFailed(ep *endpointState, err error) {
+	ep.Healthy = false
+	ep.Failed++
+	ep.LastErr = err
+	ep.current = 0
+}
+
+// StartHealthChecks connects to each unhealthy endpoint every interval, with the dial timeout, and marks it healthy
+// again if the connection succeeds. The connection is closed right away.
+//
+// The connections are made without blocking the IO, see AsyncDialTimeout. An endpoint whose previous check is still in
+// progress is not checked again. Calling StartHealthChecks again changes the interval.
+func (d *MultiEndpointDialer) StartHealthChecks(interval time.Duration) error {
+	if d.timer == nil {
+		timer, err := NewTimer(d.ioc)
+		if err != nil {
+			return err
+		}
+		d.timer = timer
+	} else if d.timer.Scheduled() {
+		if err := d.timer.Cancel(); err != nil {
+			return err
+		}
+	}
+
+	return d.timer.ScheduleRepeating(interval, d.checkHealth)
+}
+
+func (d *MultiEndpointDialer) checkHealth() {
+	for _, ep := range d.endpoints {
+		if ep.Healthy || ep.checking {
+			continue
+		}
+
+		ep := ep
+		ep.checking = true
+		AsyncDialTimeout(d.ioc, d.network, ep.Addr, d.timeout, func(err error, conn Conn) {
+			ep.checking = false
+			if err != nil {
+				d.markFailed(ep, err)
+				return
+			}
+			_ = conn.Close()
+			ep.Healthy = true
+		}, d.opts...)
+	}
+}
+
+// Status returns the state of each endpoint, in the order in which they were given.
+func (d *MultiEndpointDialer) Status() []EndpointStatus {
+	status := make([]EndpointStatus, 0, len(d.endpoints))
+	for _, ep := range d.endpoints {
+		status = append(status, ep.EndpointStatus)
+	}
+	return status
+}
+
+// Close stops the health checks.
+func (d *MultiEndpointDialer) Close() error {
+	if d.timer != nil {
+		return d.timer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package sonic
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+func TestMultiEndpointDialer(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	a, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	b, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	// The preferred endpoint is down.
+	down, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	downAddr := down.Addr().String()
+	down.Close()
+
+	d, err := NewMultiEndpointDialer(ioc, "tcp", []Endpoint{
+		{Addr: a.Addr().String(), Weight: 2},
+		{Addr: b.Addr().String(), Weight: 1},
+		{Addr: downAddr, Priority: 1},
+	}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	for i := 0; i < 6; i++ {
+		conn, err := d.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+
+	status := d.Status()
+	if status[0].Dialed != 4 || status[1].Dialed != 2 {
+		t.Fatalf("expected 4 and 2 dials got=%d and %d", status[0].Dialed, status[1].Dialed)
+	}
+	if status[2].Healthy || status[2].Failed != 1 {
+		t.Fatalf("expected the down endpoint to fail once and be unhealthy got=%+v", status[2])
+	}
+
+	// The preferred endpoint comes back up and is dialed once a health check succeeds.
+	up, err := net.Listen("tcp", downAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer up.Close()
+
+	if err := d.StartHealthChecks(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && !d.Status()[2].Healthy {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+
+	conn, err := d.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if d.Status()[2].Dialed != 1 {
+		t.Fatal("expected the preferred endpoint to be dialed once healthy")
+	}
+}
+
+func TestMultiEndpointDialerAllDown(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	down, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	downAddr := down.Addr().String()
+	down.Close()
+
+	d, err := NewMultiEndpointDialer(ioc, "tcp", []Endpoint{{Addr: downAddr}}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if _, err := d.Dial(); err == nil {
+		t.Fatal("expected the dial to fail")
+	}
+	// Unhealthy endpoints are still tried as a last resort.
+	if _, err := d.Dial(); err == nil || d.Status()[0].Failed != 2 {
+		t.Fatal("expected the unhealthy endpoint to be tried again")
+	}
+}
+
+func TestMultiEndpointDialerAsyncDial(t *testing.T) {
+	// The preferred endpoint never completes the connect.
+	stuck := fullListener(t)
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	up, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer up.Close()
+
+	d, err := NewMultiEndpointDialer(ioc, "tcp", []Endpoint{
+		{Addr: stuck, Priority: 1},
+		{Addr: up.Addr().String()},
+	}, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	var (
+		conn    Conn
+		dialErr error
+		done    bool
+	)
+	d.AsyncDial(func(err error, c Conn) {
+		conn, dialErr, done = c, err, true
+	})
+	if done {
+		t.Fatal("expected the dial to not block the IO")
+	}
+	if err := ioc.RunUntil(func() bool { return done }); err != nil {
+		t.Fatal(err)
+	}
+	if dialErr != nil {
+		t.Fatal(dialErr)
+	}
+	conn.Close()
+
+	status := d.Status()
+	if status[0].Healthy || !errors.Is(status[0].LastErr, sonicerrors.ErrTimeout) {
+		t.Fatalf("expected the stuck endpoint to time out got=%+v", status[0])
+	}
+	if status[1].Dialed != 1 {
+		t.Fatal("expected to fail over to the next endpoint")
+	}
+
+	// The health check of the stuck endpoint does not block the IO either.
+	if err := d.StartHealthChecks(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := ioc.RunUntil(func() bool { return time.Since(start) > 5*time.Millisecond }); err != nil {
+		t.Fatal(err)
+	}
+
+	posted := false
+	start = time.Now()
+	if err := ioc.Post(func() { posted = true }); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioc.RunUntil(func() bool { return posted }); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 25*time.Millisecond {
+		t.Fatalf("expected the IO to not block on the health check got=%s", elapsed)
+	}
+}
@@ -70,7 +70,7 @@ func TestMain(m *testing.M) {
 				log.Printf(
 					"ipv6_addresses:: interface name=%s address=%s ip=%s",
 					iff.Name, addr.String(), addr.Network())
-				testInterfacesIPv6 = append(testInterfacesIPv4, iff)
+				testInterfacesIPv6 = append(testInterfacesIPv6, iff)
 			}
 		}
 	}
@@ -205,8 +205,11 @@ func interfacesWithIP(v int) (ret []interfaceWithIP, err error) {
 						ip:  addr,
 					})
 				}
-			} else {
-				panic("not supported")
+			} else if addr.Is6() && !addr.Is4In6() {
+				ret = append(ret, interfaceWithIP{
+					iff: iff,
+					ip:  addr,
+				})
 			}
 		}
 	}
@@ -10,6 +10,7 @@ import (
 	"github.com/csdenboer/sonic"
 	"github.com/csdenboer/sonic/internal"
 	"github.com/csdenboer/sonic/net/ipv4"
+	"github.com/csdenboer/sonic/net/ipv6"
 	"github.com/csdenboer/sonic/sonicerrors"
 )
 
@@ -224,6 +225,19 @@ func NewUDPPeer(ioc *sonic.IO, network string, addr string) (*UDPPeer, error) {
 		if err := ipv4.SetMulticastAll(p.socket, false); err != nil {
 			return nil, err
 		}
+	} else {
+		p.loop, err = ipv6.GetMulticastLoop(p.socket)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := ipv6.SetMulticastHops(p.socket, p.ttl); err != nil {
+			return nil, err
+		}
+
+		if err := ipv6.SetMulticastAll(p.socket, false); err != nil {
+			return nil, err
+		}
 	}
 
 	return p, nil
@@ -260,8 +274,29 @@ func (p *UDPPeer) SetOutboundIPv4(interfaceName string) error {
 //
 // This means Write(...) and AsyncWrite(...)  will use the specified interface
 // to send packets to the multicast group.
+//
+// IPv6 multicast is sent on an interface, not from one of its addresses, so
+// the IP returned by Outbound is the first IPv6 address of the interface, if
+// any.
 func (p *UDPPeer) SetOutboundIPv6(interfaceName string) error {
-	panic("IPv6 not supported")
+	iff, err := resolveMulticastInterface(interfaceName)
+	if err != nil {
+		return err
+	}
+
+	if err := ipv6.SetMulticastInterface(p.socket, iff); err != nil {
+		return err
+	}
+
+	p.outbound = iff
+	p.outboundIP = netip.IPv6Unspecified()
+	if addrs, err := GetAddressesForInterface(iff.Name); err == nil {
+		if addrs = FilterIPv6(addrs); len(addrs) > 0 {
+			p.outboundIP = addrs[0]
+		}
+	}
+
+	return nil
 }
 
 // Outbound returns the interface with which packets are sent to a multicast
@@ -298,7 +333,11 @@ func (p *UDPPeer) Inbound() *net.Interface {
 // multicast tests on a single host. Anything you write to a multicast group
 // will be made available to receivers that joined that multicast group.
 func (p *UDPPeer) SetLoop(loop bool) error {
-	if err := ipv4.SetMulticastLoop(p.socket, loop); err != nil {
+	setLoop := ipv4.SetMulticastLoop
+	if p.ipv == 6 {
+		setLoop = ipv6.SetMulticastLoop
+	}
+	if err := setLoop(p.socket, loop); err != nil {
 		return err
 	} else {
 		p.loop = loop
@@ -310,14 +349,18 @@ func (p *UDPPeer) Loop() bool {
 	return p.loop
 }
 
-// SetTTL Sets the time-to-live of udp multicast datagrams. The TTL is 1 by
-// default.
+// SetTTL Sets the time-to-live of udp multicast datagrams, which is their hop
+// limit for IPv6. The TTL is 1 by default.
 //
 // A TTL of 1 prevents datagrams from being forwarded beyond the local network.
 // Acceptable values are in the range [0, 255]. It is up to the caller to make
 // sure the uint8 arg does not overflow.
 func (p *UDPPeer) SetTTL(ttl uint8) error {
-	if err := ipv4.SetMulticastTTL(p.socket, ttl); err != nil {
+	setTTL := ipv4.SetMulticastTTL
+	if p.ipv == 6 {
+		setTTL = ipv6.SetMulticastHops
+	}
+	if err := setTTL(p.socket, ttl); err != nil {
 		return err
 	} else {
 		p.ttl = ttl
@@ -341,7 +384,11 @@ func (p *UDPPeer) TTL() uint8 {
 // get datagrams, but reader 2 gets datagrams as well. This is only on Linux. On
 // BSD, only reader 1 gets datagrams.
 func (p *UDPPeer) SetAll(all bool) error {
-	if err := ipv4.SetMulticastAll(p.socket, all); err != nil {
+	setAll := ipv4.SetMulticastAll
+	if p.ipv == 6 {
+		setAll = ipv6.SetMulticastAll
+	}
+	if err := setAll(p.socket, all); err != nil {
 		return err
 	} else {
 		p.all = all
@@ -433,11 +480,17 @@ func (p *UDPPeer) joinIPv4(
 }
 
 func (p *UDPPeer) joinIPv6(
-	ip netip.Addr,
+	multicastIP netip.Addr,
 	iff *net.Interface,
 	sourceIP netip.Addr,
-) error {
-	panic("IPv6 multicast peer not yet supported")
+) (err error) {
+	empty := netip.Addr{}
+	if sourceIP == empty {
+		err = ipv6.AddMembership(p.socket, multicastIP, iff)
+	} else {
+		err = ipv6.AddSourceMembership(p.socket, multicastIP, sourceIP, iff)
+	}
+	return
 }
 
 // Leave Leaves the multicast group  Join or JoinOn.
@@ -489,8 +542,14 @@ func (p *UDPPeer) leaveIPv4(multicastIP, sourceIP netip.Addr) (err error) {
 	return
 }
 
-func (p *UDPPeer) leaveIPv6(multicastIP, sourceIP netip.Addr) error {
-	panic("IPv6 multicast peer not yet supported")
+func (p *UDPPeer) leaveIPv6(multicastIP, sourceIP netip.Addr) (err error) {
+	empty := netip.Addr{}
+	if sourceIP == empty {
+		err = ipv6.DropMembership(p.socket, multicastIP)
+	} else {
+		err = ipv6.DropSourceMembership(p.socket, multicastIP, sourceIP)
+	}
+	return
 }
 
 // BlockSource Makes it such that any data originating from unicast IP sourceIP
@@ -522,7 +581,7 @@ func (p *UDPPeer) blockIPv4(multicastIP, sourceIP netip.Addr) (err error) {
 }
 
 func (p *UDPPeer) blockIPv6(multicastIP, sourceIP netip.Addr) (err error) {
-	panic("IPv6 multicast peer not yet supported")
+	return ipv6.BlockSource(p.socket, multicastIP, sourceIP)
 }
 
 // UnblockSource undoes BlockSource.
@@ -552,7 +611,7 @@ func (p *UDPPeer) unblockIPv4(multicastIP, sourceIP netip.Addr) (err error) {
 }
 
 func (p *UDPPeer) unblockIPv6(multicastIP, sourceIP netip.Addr) (err error) {
-	panic("IPv6 multicast peer not yet supported")
+	return ipv6.UnblockSource(p.socket, multicastIP, sourceIP)
 }
 
 func (p *UDPPeer) Read(b []byte) (int, netip.AddrPort, error) {
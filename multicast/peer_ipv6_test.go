@@ -3,9 +3,12 @@ package multicast
 import (
 	"log"
 	"net"
+	"net/netip"
 	"testing"
+	"time"
 
 	"github.com/csdenboer/sonic"
+	"github.com/csdenboer/sonic/net/ipv6"
 )
 
 func TestUDPPeerIPv6_Addresses(t *testing.T) {
@@ -85,3 +88,139 @@ func TestUDPPeerIPv6_Addresses(t *testing.T) {
 
 	log.Println("ran")
 }
+
+func TestUDPPeerIPv6_JoinOnAndRead(t *testing.T) {
+	iffs, err := interfacesWithIP(6)
+	if err != nil || len(iffs) == 0 {
+		return
+	}
+	iff := iffs[0].iff
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	multicastIP := "ff02::1:3"
+	r, err := NewUDPPeer(ioc, "udp6", "[::]:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if err := r.JoinOn(IP(multicastIP), InterfaceName(iff.Name)); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewUDPPeer(ioc, "udp6", "[::]:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if err := w.SetOutboundIPv6(iff.Name); err != nil {
+		t.Fatal(err)
+	}
+	if outbound, _ := w.Outbound(); outbound == nil || outbound.Name != iff.Name {
+		t.Fatalf("wrong outbound interface %v", outbound)
+	}
+	if err := w.SetLoop(true); err != nil {
+		t.Fatal(err)
+	}
+
+	multicastAddr := netip.AddrPortFrom(
+		netip.MustParseAddr(multicastIP), uint16(r.LocalAddr().Port))
+
+	var (
+		onRead func(error, int, netip.AddrPort)
+		rb     = make([]byte, 128)
+		nRead  = 0
+	)
+	onRead = func(err error, n int, from netip.AddrPort) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(rb[:n]) != "hello" {
+			t.Fatalf("wrong payload %q", rb[:n])
+		}
+		if !from.Addr().Is6() || from.Port() != uint16(w.LocalAddr().Port) {
+			t.Fatalf("wrong source %s", from)
+		}
+		nRead++
+		r.AsyncRead(rb, onRead)
+	}
+	r.AsyncRead(rb, onRead)
+
+	for i := 0; i < 10 && nRead == 0; i++ {
+		if _, err := w.Write([]byte("hello"), multicastAddr); err != nil {
+			t.Fatal(err)
+		}
+		_ = ioc.RunOneFor(10 * time.Millisecond)
+	}
+	if nRead == 0 {
+		t.Fatal("reader did not read anything")
+	}
+
+	if err := r.Leave(IP(multicastIP)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUDPPeerIPv6_Options(t *testing.T) {
+	iffs, err := interfacesWithIP(6)
+	if err != nil || len(iffs) == 0 {
+		return
+	}
+	iff := iffs[0].iff
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	peer, err := NewUDPPeer(ioc, "udp6", "[::]:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	if err := peer.SetTTL(16); err != nil {
+		t.Fatal(err)
+	}
+	if hops, err := ipv6.GetMulticastHops(peer.NextLayer()); err != nil || hops != 16 || peer.TTL() != 16 {
+		t.Fatalf("wrong hops=%d ttl=%d err=%v", hops, peer.TTL(), err)
+	}
+
+	for _, loop := range []bool{false, true} {
+		if err := peer.SetLoop(loop); err != nil {
+			t.Fatal(err)
+		}
+		if given, err := ipv6.GetMulticastLoop(peer.NextLayer()); err != nil || given != loop || peer.Loop() != loop {
+			t.Fatalf("wrong loop=%v expected=%v err=%v", given, loop, err)
+		}
+	}
+
+	var (
+		multicastIP = IP("ff02::1:4")
+		sourceIP    = SourceIP(iffs[0].ip.WithZone("").String())
+		on          = InterfaceName(iff.Name)
+	)
+	if err := peer.Leave(multicastIP); err == nil {
+		t.Fatal("should not leave a group which was not joined")
+	}
+	if err := peer.JoinOn(multicastIP, on); err != nil {
+		t.Fatal(err)
+	}
+	if err := peer.BlockSource(multicastIP, sourceIP); err != nil {
+		t.Fatal(err)
+	}
+	if err := peer.UnblockSource(multicastIP, sourceIP); err != nil {
+		t.Fatal(err)
+	}
+	if err := peer.Leave(multicastIP); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := peer.JoinSourceOn(multicastIP, sourceIP, on); err != nil {
+		t.Fatal(err)
+	}
+	if err := peer.LeaveSource(multicastIP, sourceIP); err != nil {
+		t.Fatal(err)
+	}
+}
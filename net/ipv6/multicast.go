@@ -0,0 +1,205 @@
+package ipv6
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+	"unsafe"
+
+	"github.com/csdenboer/sonic"
+	"golang.org/x/sys/unix"
+)
+
+// sizeofSockaddrStorage is the size of struct sockaddr_storage, which holds the
+// group and the source of a groupSourceReq.
+const sizeofSockaddrStorage = 128
+
+// IPv6 multicast is configured per interface index, not per interface address
+// as IPv4 multicast is. The index 0 lets the kernel pick the interface from the
+// routing table.
+
+func interfaceIndex(iff *net.Interface) int {
+	if iff == nil {
+		return 0
+	}
+	return iff.Index
+}
+
+func GetMulticastInterfaceIndex(socket *sonic.Socket) (int, error) {
+	return syscall.GetsockoptInt(
+		socket.RawFd(),
+		syscall.IPPROTO_IPV6,
+		syscall.IPV6_MULTICAST_IF,
+	)
+}
+
+// SetMulticastInterface sets the interface on which the socket sends the
+// packets destined to a multicast group. A nil interface lets the kernel pick
+// it.
+func SetMulticastInterface(socket *sonic.Socket, iff *net.Interface) error {
+	if iff != nil && iff.Flags&net.FlagMulticast == 0 {
+		return fmt.Errorf(
+			"interface=%s does not support multicast", iff.Name)
+	}
+	return syscall.SetsockoptInt(
+		socket.RawFd(),
+		syscall.IPPROTO_IPV6,
+		syscall.IPV6_MULTICAST_IF,
+		interfaceIndex(iff),
+	)
+}
+
+func SetMulticastLoop(socket *sonic.Socket, loop bool) error {
+	v := 0
+	if loop {
+		v = 1
+	}
+	return syscall.SetsockoptInt(
+		socket.RawFd(),
+		syscall.IPPROTO_IPV6,
+		syscall.IPV6_MULTICAST_LOOP,
+		v,
+	)
+}
+
+func GetMulticastLoop(socket *sonic.Socket) (bool, error) {
+	v, err := syscall.GetsockoptInt(
+		socket.RawFd(),
+		syscall.IPPROTO_IPV6,
+		syscall.IPV6_MULTICAST_LOOP,
+	)
+	return v != 0, err
+}
+
+// SetMulticastHops sets the hop limit of the multicast packets, the IPv6
+// counterpart of the IPv4 TTL.
+func SetMulticastHops(socket *sonic.Socket, hops uint8) error {
+	return syscall.SetsockoptInt(
+		socket.RawFd(),
+		syscall.IPPROTO_IPV6,
+		syscall.IPV6_MULTICAST_HOPS,
+		int(hops),
+	)
+}
+
+func GetMulticastHops(socket *sonic.Socket) (uint8, error) {
+	hops, err := syscall.GetsockoptInt(
+		socket.RawFd(),
+		syscall.IPPROTO_IPV6,
+		syscall.IPV6_MULTICAST_HOPS,
+	)
+	return uint8(hops), err
+}
+
+func ValidateMulticastIP(ip netip.Addr) error {
+	if !ip.Is6() || ip.Is4In6() {
+		return fmt.Errorf("expected an IPv6 address=%s", ip)
+	}
+	if !ip.IsMulticast() {
+		return fmt.Errorf("expected a multicast address=%s", ip)
+	}
+	return nil
+}
+
+func prepareMembership(
+	multicastIP netip.Addr,
+	iff *net.Interface,
+) *syscall.IPv6Mreq {
+	return &syscall.IPv6Mreq{
+		Multiaddr: multicastIP.As16(),
+		Interface: uint32(interfaceIndex(iff)),
+	}
+}
+
+// AddMembership makes the given socket a member of the specified multicast IP
+// on iff, or on the interface picked by the kernel if iff is nil.
+func AddMembership(
+	socket *sonic.Socket,
+	multicastIP netip.Addr,
+	iff *net.Interface,
+) error {
+	return syscall.SetsockoptIPv6Mreq(
+		socket.RawFd(),
+		syscall.IPPROTO_IPV6,
+		syscall.IPV6_JOIN_GROUP,
+		prepareMembership(multicastIP, iff),
+	)
+}
+
+// DropMembership drops the membership of the socket to the specified multicast
+// IP, on whichever interface it was added.
+func DropMembership(socket *sonic.Socket, multicastIP netip.Addr) error {
+	return syscall.SetsockoptIPv6Mreq(
+		socket.RawFd(),
+		syscall.IPPROTO_IPV6,
+		syscall.IPV6_LEAVE_GROUP,
+		prepareMembership(multicastIP, nil),
+	)
+}
+
+// IPv6 has no counterpart of the IPv4 IP_ADD_SOURCE_MEMBERSHIP family of
+// options, so the source-specific memberships are managed with the protocol
+// independent options of RFC 3678, which take a group_source_req.
+
+func setGroupSource(
+	socket *sonic.Socket,
+	opt int,
+	multicastIP, sourceIP netip.Addr,
+	iff *net.Interface,
+) (err error) {
+	req := &groupSourceReq{Interface: uint32(interfaceIndex(iff))}
+	putSockaddrInet6(&req.Group, multicastIP)
+	putSockaddrInet6(&req.Source, sourceIP)
+
+	/* #nosec G103 -- the use of unsafe has been audited */
+	_, _, errno := syscall.Syscall6(
+		uintptr(syscall.SYS_SETSOCKOPT),
+		uintptr(socket.RawFd()),
+		uintptr(syscall.IPPROTO_IPV6),
+		uintptr(opt),
+		uintptr(unsafe.Pointer(req)),
+		unsafe.Sizeof(*req),
+		0,
+	)
+	if errno != 0 {
+		err = errno
+	}
+	return err
+}
+
+// AddSourceMembership makes the given socket a member of the specified
+// multicast IP, receiving only the packets sent by sourceIP.
+func AddSourceMembership(
+	socket *sonic.Socket,
+	multicastIP netip.Addr,
+	sourceIP netip.Addr,
+	iff *net.Interface,
+) error {
+	return setGroupSource(
+		socket, unix.MCAST_JOIN_SOURCE_GROUP, multicastIP, sourceIP, iff)
+}
+
+func DropSourceMembership(
+	socket *sonic.Socket,
+	multicastIP, sourceIP netip.Addr,
+) error {
+	return setGroupSource(
+		socket, unix.MCAST_LEAVE_SOURCE_GROUP, multicastIP, sourceIP, nil)
+}
+
+func BlockSource(
+	socket *sonic.Socket,
+	multicastIP, sourceIP netip.Addr,
+) error {
+	return setGroupSource(
+		socket, unix.MCAST_BLOCK_SOURCE, multicastIP, sourceIP, nil)
+}
+
+func UnblockSource(
+	socket *sonic.Socket,
+	multicastIP, sourceIP netip.Addr,
+) error {
+	return setGroupSource(
+		socket, unix.MCAST_UNBLOCK_SOURCE, multicastIP, sourceIP, nil)
+}
@@ -0,0 +1,32 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package ipv6
+
+import (
+	"net/netip"
+	"syscall"
+	"unsafe"
+
+	"github.com/csdenboer/sonic"
+)
+
+// groupSourceReq is struct group_source_req, which is packed to 4 bytes on the
+// BSDs.
+type groupSourceReq struct {
+	Interface uint32
+	Group     [sizeofSockaddrStorage]byte
+	Source    [sizeofSockaddrStorage]byte
+}
+
+func putSockaddrInet6(b *[sizeofSockaddrStorage]byte, ip netip.Addr) {
+	/* #nosec G103 -- the use of unsafe has been audited */
+	sa := (*syscall.RawSockaddrInet6)(unsafe.Pointer(b))
+	sa.Len = syscall.SizeofSockaddrInet6
+	sa.Family = syscall.AF_INET6
+	sa.Addr = ip.As16()
+}
+
+func SetMulticastAll(socket *sonic.Socket, all bool) error {
+	// See ipv4.SetMulticastAll.
+	return nil
+}
@@ -0,0 +1,36 @@
+package ipv6
+
+import (
+	"net/netip"
+	"syscall"
+	"unsafe"
+
+	"github.com/csdenboer/sonic"
+	"golang.org/x/sys/unix"
+)
+
+// groupSourceReq is struct group_source_req, whose sockaddr_storage members
+// are 8-byte aligned on Linux.
+type groupSourceReq struct {
+	Interface uint32
+	_         [4]byte
+	Group     [sizeofSockaddrStorage]byte
+	Source    [sizeofSockaddrStorage]byte
+}
+
+func putSockaddrInet6(b *[sizeofSockaddrStorage]byte, ip netip.Addr) {
+	/* #nosec G103 -- the use of unsafe has been audited */
+	sa := (*syscall.RawSockaddrInet6)(unsafe.Pointer(b))
+	sa.Family = syscall.AF_INET6
+	sa.Addr = ip.As16()
+}
+
+func SetMulticastAll(socket *sonic.Socket, all bool) error {
+	// See ipv4.SetMulticastAll.
+	v := 0
+	if all {
+		v = 1
+	}
+	return syscall.SetsockoptInt(
+		socket.RawFd(), syscall.IPPROTO_IPV6, unix.IPV6_MULTICAST_ALL, v)
+}
@@ -0,0 +1,79 @@
+package sonic
+
+// OpPoolStats are the counters of an OpPool.
+type OpPoolStats struct {
+	// Gets and Puts count the calls to Get and Put.
+	Gets uint64
+	Puts uint64
+
+	// Allocs is the number of values allocated because the pool was empty. It stops growing once the pool holds as
+	// many values as there are operations in flight, which is how a steady-state workload is checked not to allocate.
+	Allocs uint64
+}
+
+// OpPool is a free list of the state of asynchronous operations, for the handlers which need state of their own per
+// operation, such as the requests of a protocol in flight. Getting the state from the pool, binding its handlers once
+// when it is allocated, and putting it back once the operation completes makes steady-state operations allocation
+// free, as sonic does for the reads and writes of its streams.
+//
+// Unlike a sync.Pool, an OpPool is never emptied by the garbage collector and is not safe for concurrent use. It must
+// only be used from the goroutine running the IO.
+type OpPool[T any] struct {
+	newFn func() *T
+	reset func(*T)
+	free  []*T
+	stats OpPoolStats
+}
+
+// NewOpPool creates a pool whose values are allocated with newFn, or new if newFn is nil. reset, if not nil, is
+// invoked on the values put back into the pool, for example to drop the references they hold.
+func NewOpPool[T any](newFn func() *T, reset func(*T)) *OpPool[T] {
+	if newFn == nil {
+		newFn = func() *T { return new(T) }
+	}
+	return &OpPool[T]{newFn: newFn, reset: reset}
+}
+
+// Reserve allocates values until the pool holds at least n of them, such that the first n operations in flight do
+// not allocate either.
+func (p *OpPool[T]) Reserve(n int) {
+	for len(p.free) < n {
+		p.free = append(p.free, p.newFn())
+		p.stats.Allocs++
+	}
+}
+
+// Get returns a value of the pool, or a newly allocated one if the pool is empty.
+func (p *OpPool[T]) Get() *T {
+	p.stats.Gets++
+	if n := len(p.free); n > 0 {
+		v := p.free[n-1]
+		p.free[n-1] = nil
+		p.free = p.free[:n-1]
+		return v
+	}
+	p.stats.Allocs++
+	return p.newFn()
+}
+
+// Put puts back a value returned by Get once its operation completed. The value must not be used afterwards.
+func (p *OpPool[T]) Put(v *T) {
+	if v == nil {
+		return
+	}
+	p.stats.Puts++
+	if p.reset != nil {
+		p.reset(v)
+	}
+	p.free = append(p.free, v)
+}
+
+// Len returns the number of values in the pool.
+func (p *OpPool[T]) Len() int {
+	return len(p.free)
+}
+
+// Stats returns the counters of the pool.
+func (p *OpPool[T]) Stats() OpPoolStats {
+	return p.stats
+}
@@ -0,0 +1,84 @@
+package sonic
+
+import (
+	"testing"
+)
+
+func TestOpPool(t *testing.T) {
+	type op struct {
+		id int
+		b  []byte
+	}
+	p := NewOpPool(nil, func(o *op) { o.b = nil })
+
+	p.Reserve(2)
+	if p.Len() != 2 || p.Stats().Allocs != 2 {
+		t.Fatalf("expected 2 reserved values got=%d stats=%+v", p.Len(), p.Stats())
+	}
+
+	a, b, c := p.Get(), p.Get(), p.Get()
+	if a == b || b == c || a == c {
+		t.Fatal("expected distinct values")
+	}
+	if stats := p.Stats(); stats.Gets != 3 || stats.Allocs != 3 {
+		t.Fatalf("wrong stats %+v", stats)
+	}
+
+	c.b = []byte("state")
+	p.Put(c)
+	if p.Get() != c || c.b != nil {
+		t.Fatal("expected the value put back to be reset and reused")
+	}
+	p.Put(nil)
+	if stats := p.Stats(); stats.Puts != 1 || stats.Allocs != 3 {
+		t.Fatalf("wrong stats %+v", stats)
+	}
+}
+
+// pooledWrite is the state of a write of TestOpPoolSteadyState, whose handler is bound once when allocated.
+type pooledWrite struct {
+	pool    *OpPool[pooledWrite]
+	n       int
+	written *int
+	onWrite AsyncCallback
+}
+
+func TestOpPoolSteadyState(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	a, b := connPair(t, ioc)
+	defer a.Close()
+	defer b.Close()
+
+	written := 0
+	var pool *OpPool[pooledWrite]
+	pool = NewOpPool(func() *pooledWrite {
+		w := &pooledWrite{pool: pool, written: &written}
+		w.onWrite = func(err error, n int) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			*w.written += n
+			w.pool.Put(w)
+		}
+		return w
+	}, nil)
+	pool.Reserve(1)
+
+	buf, rbuf := []byte("x"), make([]byte, 1)
+	allocs := testing.AllocsPerRun(100, func() {
+		w := pool.Get()
+		a.AsyncWrite(buf, w.onWrite)
+		_, _ = b.Read(rbuf)
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocation got=%v", allocs)
+	}
+	if stats := pool.Stats(); stats.Allocs != 1 || stats.Gets != stats.Puts {
+		t.Fatalf("expected the reserved value to be reused stats=%+v", stats)
+	}
+	if written != 101 {
+		t.Fatalf("expected 101 bytes written got=%d", written)
+	}
+}
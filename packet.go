@@ -21,6 +21,9 @@ type packetConn struct {
 	remoteAddr net.Addr
 	closed     uint32
 
+	// unlink is the path of the unix domain socket file removed on Close, if any.
+	unlink string
+
 	dispatched int
 }
 
@@ -50,10 +53,12 @@ func NewPacketConn(ioc *IO, network, addr string, opts ...sonicopts.Option) (Pac
 }
 
 func (c *packetConn) ReadFrom(b []byte) (n int, from net.Addr, err error) {
+	if c.Closed() {
+		return 0, nil, io.EOF
+	}
+
 	var addr syscall.Sockaddr
 	n, addr, err = syscall.Recvfrom(c.slot.Fd, b, 0)
-	from = internal.FromSockaddr(addr)
-
 	if err != nil {
 		if err == syscall.EWOULDBLOCK || err == syscall.EAGAIN {
 			return 0, nil, sonicerrors.ErrWouldBlock
@@ -61,15 +66,24 @@ func (c *packetConn) ReadFrom(b []byte) (n int, from net.Addr, err error) {
 		return 0, nil, err
 	}
 
-	if n == 0 {
-		return 0, from, io.EOF
-	}
+	// Unlike a stream, an empty datagram does not mean that the peer is gone, so it is read like any other.
+	return n, fromSockaddrPacket(addr), nil
+}
 
-	if n < 0 {
-		n = 0 // errors embeds the information
+// fromSockaddrPacket returns the source address of a datagram: a *net.UDPAddr or a *net.UnixAddr. It is nil if the
+// source is an unnamed unix domain socket.
+func fromSockaddrPacket(addr syscall.Sockaddr) net.Addr {
+	switch addr := addr.(type) {
+	case *syscall.SockaddrInet4, *syscall.SockaddrInet6:
+		return internal.FromSockaddrUDP(addr, &net.UDPAddr{})
+	case *syscall.SockaddrUnix:
+		if addr.Name == "" {
+			return nil
+		}
+		return &net.UnixAddr{Name: addr.Name, Net: "unixgram"}
+	default:
+		return nil
 	}
-
-	return n, from, err
 }
 
 func (c *packetConn) AsyncReadFrom(b []byte, cb AsyncReadCallbackPacket) {
@@ -137,6 +151,10 @@ func (c *packetConn) getReadHandler(b []byte, readBytes int, readAll bool, cb As
 }
 
 func (c *packetConn) WriteTo(b []byte, to net.Addr) error {
+	if c.Closed() {
+		return io.EOF
+	}
+
 	err := syscall.Sendto(c.slot.Fd, b, 0, internal.ToSockaddr(to))
 	if err == syscall.EWOULDBLOCK || err == syscall.EAGAIN {
 		return sonicerrors.ErrWouldBlock
@@ -193,9 +211,45 @@ func (c *packetConn) getWriteHandler(b []byte, to net.Addr, cb AsyncWriteCallbac
 	}
 }
 
+func (c *packetConn) Cancel() {
+	c.cancel(internal.ReadEvent, internal.PollerReadEvent)
+	c.cancel(internal.WriteEvent, internal.PollerWriteEvent)
+}
+
+func (c *packetConn) cancel(et internal.EventType, pe internal.PollerEvent) {
+	if c.slot.Events&pe != pe {
+		return
+	}
+
+	var err error
+	if et == internal.ReadEvent {
+		err = c.ioc.poller.DelRead(&c.slot)
+	} else {
+		err = c.ioc.poller.DelWrite(&c.slot)
+	}
+	if err == nil {
+		err = sonicerrors.ErrCancelled
+	}
+	c.slot.Handlers[et](err)
+}
+
 func (c *packetConn) Close() error {
-	atomic.StoreUint32(&c.closed, 1)
-	return syscall.Close(c.slot.Fd)
+	if !atomic.CompareAndSwapUint32(&c.closed, 0, 1) {
+		return io.EOF
+	}
+
+	err := c.ioc.poller.Del(&c.slot)
+	if err != nil {
+		return err
+	}
+
+	err = syscall.Close(c.slot.Fd)
+	if c.unlink != "" {
+		if uerr := internal.UnlinkUnix(c.unlink); err == nil {
+			err = uerr
+		}
+	}
+	return err
 }
 
 func (c *packetConn) Closed() bool {
@@ -3,6 +3,7 @@ package sonic
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"strings"
 	"syscall"
@@ -308,3 +309,121 @@ func TestPacketAsyncWriteTo(t *testing.T) {
 		ioc.RunOneFor(time.Millisecond)
 	}
 }
+
+func TestPacketAsyncReadFromSource(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	from, err := NewPacketConn(ioc, "udp", "localhost:9081")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer from.Close()
+
+	to, err := NewPacketConn(ioc, "udp", "localhost:9082")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An empty datagram, then a non-empty one.
+	for _, msg := range []string{"", "hello"} {
+		from.AsyncWriteTo([]byte(msg), to.LocalAddr(), func(err error) {
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	var (
+		b    = make([]byte, 128)
+		msgs []string
+	)
+	var onRead AsyncReadCallbackPacket
+	onRead = func(err error, n int, addr net.Addr) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok || udpAddr.Port != 9081 {
+			t.Fatalf("wrong source address %v", addr)
+		}
+		msgs = append(msgs, string(b[:n]))
+		if len(msgs) < 2 {
+			to.AsyncReadFrom(b, onRead)
+		}
+	}
+	to.AsyncReadFrom(b, onRead)
+
+	for start := time.Now(); len(msgs) < 2 && time.Since(start) < time.Second; {
+		ioc.RunOneFor(time.Millisecond)
+	}
+	if len(msgs) != 2 || msgs[0] != "" || msgs[1] != "hello" {
+		t.Fatalf("wrong datagrams %q", msgs)
+	}
+
+	var cancelled error
+	to.AsyncReadFrom(b, func(err error, _ int, _ net.Addr) {
+		cancelled = err
+	})
+	to.Cancel()
+	if !errors.Is(cancelled, sonicerrors.ErrCancelled) {
+		t.Fatalf("pending read should be cancelled, got %v", cancelled)
+	}
+
+	if err := to.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := to.Close(); !errors.Is(err, io.EOF) {
+		t.Fatalf("second close should return io.EOF, got %v", err)
+	}
+	if _, _, err := to.ReadFrom(b); !errors.Is(err, io.EOF) {
+		t.Fatalf("read after close should return io.EOF, got %v", err)
+	}
+}
+
+func TestPacketIPv6(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := NewPacketConn(ioc, "udp6", "[::1]:9083")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	peer, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	if err := conn.WriteTo([]byte("hello"), peer.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 128)
+	n, from, err := peer.ReadFromUDP(b)
+	if err != nil || string(b[:n]) != "hello" || from.Port != 9083 {
+		t.Fatalf("wrong datagram %q from=%v err=%v", b[:n], from, err)
+	}
+
+	if _, err := peer.WriteToUDP([]byte("world"), from); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		done bool
+		addr net.Addr
+	)
+	conn.AsyncReadFrom(b, func(err error, n int, a net.Addr) {
+		if err != nil || string(b[:n]) != "world" {
+			t.Fatalf("wrong datagram %q err=%v", b[:n], err)
+		}
+		done, addr = true, a
+	})
+	for start := time.Now(); !done && time.Since(start) < time.Second; {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if udpAddr, ok := addr.(*net.UDPAddr); !ok || !udpAddr.IP.Equal(net.IPv6loopback) ||
+		udpAddr.Port != peer.LocalAddr().(*net.UDPAddr).Port {
+		t.Fatalf("wrong source address %v", addr)
+	}
+}
@@ -0,0 +1,80 @@
+package sonic
+
+import "github.com/csdenboer/sonic/internal"
+
+// slotPageSize is the number of file descriptors covered by a page of pendingSlots. It must be a power of two.
+const slotPageSize = 4096
+
+// pendingSlots holds the Slots of the file descriptors past the IO's static range, indexed by file descriptor.
+//
+// The kernel hands out the lowest free descriptor, so descriptors are dense and pendingSlots indexes them in pages of
+// slotPageSize Slots, one page per range of descriptors. Registration is then O(1), without hashing, and only
+// allocates the first time a page is used, see reserve. Unlike a map, which rehashes all of its entries whenever it
+// grows, adding a page leaves the others untouched, so there is no latency spike when many connections come and go on
+// an IO with many descriptors. Pages are never freed: an IO which held many connections once does not allocate again
+// when they come back.
+type pendingSlots struct {
+	pages [][]*internal.Slot
+	n     int
+}
+
+// page returns the page of fd, allocating it if alloc is true, and the index of fd in it.
+func (s *pendingSlots) page(fd int, alloc bool) ([]*internal.Slot, int) {
+	p := fd / slotPageSize
+	if p >= len(s.pages) {
+		if !alloc {
+			return nil, 0
+		}
+		s.grow(p)
+	}
+	if s.pages[p] == nil && alloc {
+		s.pages[p] = make([]*internal.Slot, slotPageSize)
+	}
+	return s.pages[p], fd & (slotPageSize - 1)
+}
+
+func (s *pendingSlots) grow(p int) {
+	if p >= cap(s.pages) {
+		pages := make([][]*internal.Slot, p+1, 2*(p+1))
+		copy(pages, s.pages)
+		s.pages = pages
+	}
+	s.pages = s.pages[:p+1]
+}
+
+// reserve allocates the pages of the file descriptors in [from, to), such that registering them does not allocate.
+func (s *pendingSlots) reserve(from, to int) {
+	for fd := from &^ (slotPageSize - 1); fd < to; fd += slotPageSize {
+		s.page(fd, true)
+	}
+}
+
+// add holds slot for its file descriptor, in place of the Slot held for it, if any.
+func (s *pendingSlots) add(slot *internal.Slot) {
+	page, i := s.page(slot.Fd, true)
+	if page[i] == nil {
+		s.n++
+	}
+	page[i] = slot
+}
+
+// remove drops slot, if it is held for its file descriptor.
+func (s *pendingSlots) remove(slot *internal.Slot) {
+	if page, i := s.page(slot.Fd, false); page != nil && page[i] == slot {
+		page[i] = nil
+		s.n--
+	}
+}
+
+// lookup returns the Slot held for fd, if any.
+func (s *pendingSlots) lookup(fd int) *internal.Slot {
+	if page, i := s.page(fd, false); page != nil {
+		return page[i]
+	}
+	return nil
+}
+
+// Len returns the number of Slots held.
+func (s *pendingSlots) Len() int {
+	return s.n
+}
@@ -0,0 +1,59 @@
+package sonic
+
+// timerPageSize is the number of Timers held by a page of pendingTimers. It must be a power of two.
+const timerPageSize = 4096
+
+// pendingTimers holds the scheduled Timers of an IO, such that they stay reachable while scheduled.
+//
+// The Timers are packed in pages of timerPageSize entries, and each Timer knows its index, so adding and removing one
+// is O(1): a removed Timer is replaced by the last one. Only the first use of a page allocates, see reserve, and adding
+// a page leaves the others untouched, unlike a map which rehashes all of its entries whenever it grows. Pages are never
+// freed.
+type pendingTimers struct {
+	pages [][]*Timer
+	n     int
+}
+
+func (s *pendingTimers) at(i int) *Timer {
+	return s.pages[i/timerPageSize][i&(timerPageSize-1)]
+}
+
+func (s *pendingTimers) set(i int, t *Timer) {
+	s.pages[i/timerPageSize][i&(timerPageSize-1)] = t
+}
+
+// reserve allocates the pages of n Timers, such that adding them does not allocate.
+func (s *pendingTimers) reserve(n int) {
+	for len(s.pages)*timerPageSize < n {
+		s.pages = append(s.pages, make([]*Timer, timerPageSize))
+	}
+}
+
+// add holds t, if it is not held yet.
+func (s *pendingTimers) add(t *Timer) {
+	if t.pending > 0 {
+		return
+	}
+	s.reserve(s.n + 1)
+	s.set(s.n, t)
+	s.n++
+	t.pending = s.n
+}
+
+// remove drops t, if it is held.
+func (s *pendingTimers) remove(t *Timer) {
+	if t.pending == 0 {
+		return
+	}
+	i, last := t.pending-1, s.at(s.n-1)
+	s.set(i, last)
+	last.pending = i + 1
+	s.n--
+	s.set(s.n, nil)
+	t.pending = 0
+}
+
+// Len returns the number of Timers held.
+func (s *pendingTimers) Len() int {
+	return s.n
+}
@@ -0,0 +1,59 @@
+package sonic
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+// postRetryDelay is how long a postLoop waits before posting again when the post queue of the IO is full.
+const postRetryDelay = 10 * time.Millisecond
+
+// postOnce posts handler on ioc from a goroutine other than the one running the IO, for the results of the blocking
+// calls made off the IO. If the post queue is full, see SetMaxPosts, the handler is posted again after postRetryDelay.
+//
+// The handler is dropped, and sonicerrors.ErrClosed returned, if the IO is closed. Any other error means the handler
+// is queued, but waking up the loop failed, so it runs on the next poll.
+func postOnce(ioc *IO, handler func()) (err error) {
+	for {
+		if err = ioc.Post(handler); !errors.Is(err, sonicerrors.ErrPostQueueFull) {
+			return err
+		}
+		time.Sleep(postRetryDelay)
+	}
+}
+
+// postLoop posts handler on ioc each time events fires, until stop is closed or the IO is closed. It runs on a
+// goroutine of its own, for the watchdogs and the signal handlers of the IO.
+//
+// At most one handler is posted at a time, such that a stalled loop does not accumulate them: inflight is set while
+// the handler is posted and not yet run, and handler must reset it when it runs. The events fired in the meantime are
+// coalesced into the posted handler. If the post queue is full, see SetMaxPosts, the handler is posted again after
+// postRetryDelay.
+func postLoop[T any](ioc *IO, stop <-chan struct{}, events <-chan T, inflight *uint32, handler func()) {
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-stop:
+			return
+		case <-events:
+		case <-retry:
+		}
+		retry = nil
+
+		if !atomic.CompareAndSwapUint32(inflight, 0, 1) {
+			continue
+		}
+		err := ioc.Post(handler)
+		switch {
+		case errors.Is(err, sonicerrors.ErrClosed):
+			return
+		case errors.Is(err, sonicerrors.ErrPostQueueFull):
+			atomic.StoreUint32(inflight, 0)
+			retry = time.After(postRetryDelay)
+		}
+		// Otherwise the handler is queued, even if waking up the loop failed, and runs on its next poll.
+	}
+}
@@ -0,0 +1,120 @@
+package sonic
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// Reloadable is implemented by components whose configuration can be reloaded while the IO runs, such as TLS
+// certificates, rate limits or allowlists.
+type Reloadable interface {
+	// Reload loads and applies the new configuration. It is invoked on the IO's goroutine.
+	Reload() error
+}
+
+// ReloadFunc is a function implementing Reloadable.
+type ReloadFunc func() error
+
+func (f ReloadFunc) Reload() error {
+	return f()
+}
+
+type reloadEntry struct {
+	r       Reloadable
+	removed bool
+}
+
+// reloader is the registry of the Reloadables of an IO.
+type reloader struct {
+	entries  []*reloadEntry
+	inflight uint32 // 1 if a reload is posted but not yet run. Accessed atomically.
+	stop     chan struct{}
+}
+
+// RegisterReloadable adds r to the components reloaded by Reload. The returned function removes r from them.
+//
+// RegisterReloadable and the returned function must be called from the IO's goroutine.
+func (ioc *IO) RegisterReloadable(r Reloadable) (unregister func()) {
+	entry := &reloadEntry{r: r}
+	ioc.reloader.entries = append(ioc.reloader.entries, entry)
+
+	return func() {
+		if entry.removed {
+			return
+		}
+		entry.removed = true
+
+		entries := ioc.reloader.entries[:0]
+		for _, e := range ioc.reloader.entries {
+			if e != entry {
+				entries = append(entries, e)
+			}
+		}
+		ioc.reloader.entries = entries
+	}
+}
+
+// Reload reloads all registered components, in the order in which they were registered, and returns the errors they
+// returned, joined. A failing component does not prevent the next ones from being reloaded.
+//
+// Reload must be called from the IO's goroutine. As all components are reloaded within a single call, no handler
+// observes a configuration which is only partially reloaded.
+func (ioc *IO) Reload() error {
+	var errs []error
+	for _, e := range append([]*reloadEntry(nil), ioc.reloader.entries...) {
+		if e.removed {
+			continue
+		}
+		if err := e.r.Reload(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// EnableReloadOnSignal makes the IO call Reload on its goroutine whenever the process receives one of the given
+// signals, SIGHUP if none is given. onReload, if not nil, is then invoked on the IO's goroutine with the result of
+// Reload.
+//
+// Signals received while a reload is pending are coalesced into it. A reload which cannot be posted because the post
+// queue is full, see SetMaxPosts, is posted again shortly after. Calling EnableReloadOnSignal again replaces the
+// signals and the callback.
+//
+// The signals are handled until DisableReloadOnSignal or Close is called.
+func (ioc *IO) EnableReloadOnSignal(onReload func(err error), sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+
+	ioc.DisableReloadOnSignal()
+
+	rl := &ioc.reloader
+	atomic.StoreUint32(&rl.inflight, 0)
+	rl.stop = make(chan struct{})
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+
+	go func(stop chan struct{}) {
+		defer signal.Stop(ch)
+
+		postLoop(ioc, stop, ch, &rl.inflight, func() {
+			atomic.StoreUint32(&rl.inflight, 0)
+			err := ioc.Reload()
+			if onReload != nil {
+				onReload(err)
+			}
+		})
+	}(rl.stop)
+}
+
+// DisableReloadOnSignal stops the signal handling started by EnableReloadOnSignal, if any.
+func (ioc *IO) DisableReloadOnSignal() {
+	if rl := &ioc.reloader; rl.stop != nil {
+		close(rl.stop)
+		rl.stop = nil
+	}
+}
@@ -0,0 +1,106 @@
+package sonic
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIOReload(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	var order []string
+	ioc.RegisterReloadable(ReloadFunc(func() error {
+		order = append(order, "certs")
+		return nil
+	}))
+	unregister := ioc.RegisterReloadable(ReloadFunc(func() error {
+		order = append(order, "removed")
+		return nil
+	}))
+	failure := errors.New("bad allowlist")
+	ioc.RegisterReloadable(ReloadFunc(func() error {
+		order = append(order, "allowlist")
+		return failure
+	}))
+	unregister()
+
+	if err := ioc.Reload(); !errors.Is(err, failure) {
+		t.Fatalf("expected err=%v got=%v", failure, err)
+	}
+	if len(order) != 2 || order[0] != "certs" || order[1] != "allowlist" {
+		t.Fatalf("unexpected reload order=%v", order)
+	}
+}
+
+func TestIOReloadOnSignal(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	reloads := 0
+	ioc.RegisterReloadable(ReloadFunc(func() error {
+		reloads++
+		return nil
+	}))
+
+	done := false
+	ioc.EnableReloadOnSignal(func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		done = true
+	}, syscall.SIGHUP)
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && !done {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+
+	if !done || reloads != 1 {
+		t.Fatalf("expected a single reload got=%d", reloads)
+	}
+}
+
+func TestIOReloadOnSignalPostQueueFull(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	reloads := 0
+	ioc.RegisterReloadable(ReloadFunc(func() error {
+		reloads++
+		return nil
+	}))
+	ioc.EnableReloadOnSignal(nil, syscall.SIGHUP)
+
+	ioc.SetMaxPosts(1)
+	if err := ioc.Post(func() {}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reload cannot be posted while the queue is full. It must be posted once the queue has room, and signals
+	// must still be handled afterwards.
+	for i := 0; i < 2; i++ {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(30 * time.Millisecond)
+
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) && reloads <= i {
+			_ = ioc.RunOneFor(time.Millisecond)
+		}
+		if reloads != i+1 {
+			t.Fatalf("expected %d reloads got=%d", i+1, reloads)
+		}
+		if err := ioc.Post(func() {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
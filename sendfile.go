@@ -0,0 +1,181 @@
+package sonic
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/csdenboer/sonic/internal"
+)
+
+// sendFileChunk bounds the bytes sent by a single sendfile.
+const sendFileChunk = 1024 * 1024
+
+// fileSend sends the bytes of a file to a stream, see AsyncSendFile.
+type fileSend struct {
+	dst *file
+	src *os.File
+	fd  int
+
+	off  int64
+	n    int // negative if all the bytes are sent, until the end of src
+	sent int
+	cb   AsyncCallback
+
+	// copying is set once sendfile turned out not to support src or dst, after which the bytes are read into buf and
+	// written to dst.
+	copying bool
+	buf     []byte
+
+	onWritable internal.Handler
+	resume     func()
+}
+
+// AsyncSendFile writes n bytes of src, starting at offset off, to the file, or all of them until the end of src if n
+// is negative. cb is invoked with the number of bytes written once they are all sent, or once sending fails. If the
+// end of src is reached before n bytes are sent, cb is invoked with io.EOF. If n is negative, reaching the end of src
+// is not an error. The offset of src is not changed.
+//
+// The bytes are sent with sendfile(2), so they go from the page cache to the socket without being copied to user
+// space. On FreeBSD, sendfile never waits for the disk: the bytes which are not cached yet are read and written like
+// the ones of the platforms on which sendfile does not support src or dst, through a buffer.
+//
+// No other write must be issued on the file until cb is invoked. Cancelling the pending writes of the file fails the
+// transfer with sonicerrors.ErrCancelled.
+func (f *file) AsyncSendFile(src *os.File, off int64, n int, cb AsyncCallback) {
+	f.asyncSendFile(src, off, n, false, cb)
+}
+
+func (f *file) asyncSendFile(src *os.File, off int64, n int, copying bool, cb AsyncCallback) {
+	if off < 0 {
+		cb(fmt.Errorf("negative offset %d", off), 0)
+		return
+	}
+	if n == 0 {
+		cb(nil, 0)
+		return
+	}
+
+	fs := &fileSend{
+		dst:     f,
+		src:     src,
+		fd:      int(src.Fd()),
+		off:     off,
+		n:       n,
+		cb:      cb,
+		copying: copying,
+	}
+	fs.onWritable = func(err error) {
+		f.ioc.Deregister(&f.slot)
+		if err != nil {
+			fs.done(err)
+		} else {
+			fs.send()
+		}
+	}
+	fs.resume = func() {
+		if f.Closed() {
+			fs.done(io.EOF)
+		} else {
+			fs.send()
+		}
+	}
+	fs.send()
+}
+
+func (fs *fileSend) send() {
+	for fs.n < 0 || fs.sent < fs.n {
+		if fs.dst.budget.yield(fs.resume) {
+			return
+		}
+		if fs.dst.Closed() {
+			fs.done(io.EOF)
+			return
+		}
+		if fs.dst.writeShutdown {
+			fs.done(syscall.EPIPE)
+			return
+		}
+		if fs.copying {
+			fs.copy()
+			return
+		}
+
+		chunk := sendFileChunk
+		if fs.n > 0 && fs.n-fs.sent < chunk {
+			chunk = fs.n - fs.sent
+		}
+		written, err := internal.Sendfile(fs.dst.slot.Fd, fs.fd, fs.off+int64(fs.sent), chunk)
+		fs.sent += written
+
+		switch err {
+		case nil:
+			if written == 0 {
+				fs.done(forwardError(io.EOF, fs.n))
+				return
+			}
+		case syscall.EAGAIN:
+			fs.wait()
+			return
+		case syscall.EBUSY:
+			// The bytes are not in the page cache, see internal.Sendfile on FreeBSD.
+			fs.copy()
+			return
+		case syscall.EINVAL, syscall.ENOSYS, syscall.ENOTSOCK, syscall.EOPNOTSUPP:
+			if fs.sent > 0 {
+				fs.done(os.NewSyscallError("sendfile", err))
+				return
+			}
+			fs.copying = true
+		default:
+			fs.done(os.NewSyscallError("sendfile", err))
+			return
+		}
+	}
+	fs.done(nil)
+}
+
+// copy reads the next chunk of src into a buffer and writes it to dst, after which it sends the rest of src.
+func (fs *fileSend) copy() {
+	if fs.buf == nil {
+		fs.buf = make([]byte, forwardChunk)
+	}
+	b := fs.buf
+	if fs.n > 0 && fs.n-fs.sent < len(b) {
+		b = b[:fs.n-fs.sent]
+	}
+
+	nr, err := fs.src.ReadAt(b, fs.off+int64(fs.sent))
+	if nr == 0 {
+		if err == nil {
+			err = io.ErrNoProgress
+		}
+		fs.done(forwardError(err, fs.n))
+		return
+	}
+	fs.dst.AsyncWriteAll(b[:nr], func(err error, nw int) {
+		fs.sent += nw
+		if err != nil {
+			fs.done(err)
+		} else {
+			fs.send()
+		}
+	})
+}
+
+// wait waits for dst to become writable before sending again.
+func (fs *fileSend) wait() {
+	f := fs.dst
+	f.slot.WouldBlock(internal.WriteEvent)
+	f.slot.Set(internal.WriteEvent, fs.onWritable)
+	if err := f.ioc.SetWrite(&f.slot); err != nil {
+		fs.done(err)
+	} else {
+		f.ioc.Register(&f.slot)
+	}
+}
+
+func (fs *fileSend) done(err error) {
+	fs.cb(err, fs.sent)
+}
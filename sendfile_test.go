@@ -0,0 +1,150 @@
+package sonic
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// sendFileFixture returns a file of size bytes, and a connection whose peer sends back all the bytes it receives on
+// the returned channel once the connection is closed.
+func sendFileFixture(t *testing.T, ioc *IO, size int) (*os.File, []byte, Conn, <-chan []byte) {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	path := filepath.Join(t.TempDir(), "content")
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = src.Close() })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	received := make(chan []byte, 1)
+	go func() {
+		peer, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer peer.Close()
+		b, _ := io.ReadAll(peer)
+		received <- b
+	}()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return src, content, conn, received
+}
+
+func runSendFile(t *testing.T, ioc *IO, send func(cb AsyncCallback)) (int, error) {
+	var (
+		done   bool
+		sent   int
+		result error
+	)
+	send(func(err error, n int) {
+		done, sent, result = true, n, err
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for !done && time.Now().Before(deadline) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if !done {
+		t.Fatal("the transfer did not complete")
+	}
+	return sent, result
+}
+
+func TestConnAsyncSendFile(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	// Far bigger than the send buffer of the socket, so the transfer waits for the socket to become writable.
+	src, content, conn, received := sendFileFixture(t, ioc, 8*1024*1024+123)
+
+	sent, err := runSendFile(t, ioc, func(cb AsyncCallback) {
+		conn.AsyncSendFile(src, 100, -1, cb)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != len(content)-100 {
+		t.Fatalf("expected to send %d bytes got=%d", len(content)-100, sent)
+	}
+	_ = conn.Close()
+
+	select {
+	case b := <-received:
+		if !bytes.Equal(b, content[100:]) {
+			t.Fatal("the peer did not receive the file from the offset")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the peer did not receive all bytes")
+	}
+
+	if off, err := src.Seek(0, io.SeekCurrent); err != nil || off != 0 {
+		t.Fatalf("expected the offset of the file to be unchanged got=%d err=%v", off, err)
+	}
+}
+
+func TestConnAsyncSendFileCopy(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	src, content, c, received := sendFileFixture(t, ioc, 1024*1024)
+
+	sent, err := runSendFile(t, ioc, func(cb AsyncCallback) {
+		c.(*conn).asyncSendFile(src, 10, 200*1024, true, cb)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 200*1024 {
+		t.Fatalf("expected to send %d bytes got=%d", 200*1024, sent)
+	}
+	_ = c.Close()
+
+	select {
+	case b := <-received:
+		if !bytes.Equal(b, content[10:10+200*1024]) {
+			t.Fatal("the peer did not receive the requested range")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the peer did not receive all bytes")
+	}
+}
+
+func TestConnAsyncSendFilePastEnd(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	for _, copying := range []bool{false, true} {
+		src, content, c, _ := sendFileFixture(t, ioc, 4096)
+
+		sent, err := runSendFile(t, ioc, func(cb AsyncCallback) {
+			c.(*conn).asyncSendFile(src, 1000, 8192, copying, cb)
+		})
+		if err != io.EOF {
+			t.Fatalf("copying=%v: expected io.EOF got=%v", copying, err)
+		}
+		if sent != len(content)-1000 {
+			t.Fatalf("copying=%v: expected to send %d bytes got=%d", copying, len(content)-1000, sent)
+		}
+		_ = c.Close()
+	}
+}
@@ -0,0 +1,130 @@
+package sonic
+
+import "time"
+
+// DefaultSniffTimeout is the time a client has to send its first bytes before its connection is closed by a
+// TLSSniffer, unless set with SetSniffTimeout, and by SniffTLS.
+const DefaultSniffTimeout = 10 * time.Second
+
+// TLSRecordTypeHandshake is the content type of the TLS record carrying a ClientHello, which is always the first
+// record sent by a TLS client.
+const TLSRecordTypeHandshake = 0x16
+
+// IsTLSHandshake returns true if b, the first bytes sent by a client, is the start of a TLS handshake record.
+//
+// A single byte is enough to tell TLS apart from text protocols such as HTTP, whose first byte is printable. If more
+// bytes are given, the major version of the record is checked as well.
+func IsTLSHandshake(b []byte) bool {
+	if len(b) == 0 || b[0] != TLSRecordTypeHandshake {
+		return false
+	}
+	return len(b) == 1 || b[1] == 0x03
+}
+
+// TLSSniffer accepts connections from a Listener and routes each of them, based on its first bytes, either to a TLS
+// handler or to a plaintext handler. This lets a single port serve both ws:// and wss://, for example while clients
+// migrate from one to the other.
+//
+// The first bytes are peeked, so they are still read by the handler the connection is routed to. A connection which
+// is closed before sending anything is closed and routed nowhere.
+//
+// Connections are sniffed concurrently, so a client which does not send anything does not hold up the next accepts.
+// Such a client is closed once the sniff timeout elapses, see SetSniffTimeout, such that silent clients cannot pile
+// up.
+type TLSSniffer struct {
+	ioc     *IO
+	ln      Listener
+	timeout time.Duration
+
+	onTLS       func(Conn)
+	onPlaintext func(Conn)
+	onError     func(error)
+
+	accepting bool
+	stopped   bool
+}
+
+// NewTLSSniffer creates a TLSSniffer accepting connections from ln, which must be nonblocking and run by ioc. onError
+// is invoked when accepting fails, in which case the TLSSniffer stops accepting until Start is called again.
+func NewTLSSniffer(
+	ioc *IO,
+	ln Listener,
+	onTLS func(conn Conn),
+	onPlaintext func(conn Conn),
+	onError func(err error),
+) *TLSSniffer {
+	return &TLSSniffer{
+		ioc:         ioc,
+		ln:          ln,
+		timeout:     DefaultSniffTimeout,
+		onTLS:       onTLS,
+		onPlaintext: onPlaintext,
+		onError:     onError,
+	}
+}
+
+// Start starts accepting connections.
+func (s *TLSSniffer) Start() {
+	s.stopped = false
+	if !s.accepting {
+		s.accepting = true
+		s.ln.AsyncAccept(s.onAccept)
+	}
+}
+
+// Stop stops accepting connections once the pending accept completes. Connections being sniffed are still routed.
+func (s *TLSSniffer) Stop() {
+	s.stopped = true
+}
+
+// SetSniffTimeout sets the time a client has to send its first bytes, after which its connection is closed. It applies
+// to the connections accepted afterwards. timeout must be positive.
+func (s *TLSSniffer) SetSniffTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		s.timeout = timeout
+	}
+}
+
+func (s *TLSSniffer) onAccept(err error, conn Conn) {
+	if err != nil {
+		s.accepting = false
+		if s.onError != nil {
+			s.onError(err)
+		}
+		return
+	}
+
+	sniffTLS(s.ioc, conn, s.timeout, s.onTLS, s.onPlaintext)
+
+	if s.stopped {
+		s.accepting = false
+	} else {
+		s.ln.AsyncAccept(s.onAccept)
+	}
+}
+
+// sniffTLS peeks at the first bytes of conn and hands it to onTLS if they are a TLS handshake, or to onPlaintext
+// otherwise. conn is closed if nothing is received within timeout.
+func sniffTLS(ioc *IO, conn Conn, timeout time.Duration, onTLS, onPlaintext func(Conn)) {
+	// Closing the connection cancels the peek, which then completes with an error.
+	expiry, err := ioc.ScheduleAfter(timeout, func() { _ = conn.Close() })
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	b := make([]byte, 2)
+	conn.AsyncPeek(b, func(err error, n int) {
+		expiry.Cancel()
+		if err != nil {
+			_ = conn.Close()
+			return
+		}
+
+		if IsTLSHandshake(b[:n]) {
+			onTLS(conn)
+		} else {
+			onPlaintext(conn)
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package sonic
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic/sonicopts"
+)
+
+func TestIsTLSHandshake(t *testing.T) {
+	cases := []struct {
+		b   []byte
+		tls bool
+	}{
+		{nil, false},
+		{[]byte{0x16}, true},
+		{[]byte{0x16, 0x03, 0x01}, true},
+		{[]byte{0x16, 'E'}, false},
+		{[]byte("GET / HTTP/1.1"), false},
+	}
+	for _, c := range cases {
+		if IsTLSHandshake(c.b) != c.tls {
+			t.Fatalf("expected IsTLSHandshake(%q)=%v", c.b, c.tls)
+		}
+	}
+}
+
+func TestTLSSniffer(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := Listen(ioc, "tcp", "localhost:9993", sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var tlsConns, plainConns []Conn
+	sniffer := NewTLSSniffer(
+		ioc,
+		ln,
+		func(conn Conn) { tlsConns = append(tlsConns, conn) },
+		func(conn Conn) { plainConns = append(plainConns, conn) },
+		func(err error) { t.Fatal(err) },
+	)
+	sniffer.Start()
+
+	go func() {
+		conn, err := net.Dial("tcp", "localhost:9993")
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// The handshake never completes; only the ClientHello matters.
+		_ = conn.SetDeadline(time.Now().Add(time.Second))
+		_ = tls.Client(conn, &tls.Config{InsecureSkipVerify: true}).Handshake() //#nosec G402
+	}()
+	go func() {
+		conn, err := net.Dial("tcp", "localhost:9993")
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _ = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		time.Sleep(time.Second)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && len(tlsConns)+len(plainConns) < 2 {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	sniffer.Stop()
+
+	if len(tlsConns) != 1 || len(plainConns) != 1 {
+		t.Fatalf("expected 1 TLS and 1 plaintext connection got=%d and %d", len(tlsConns), len(plainConns))
+	}
+
+	// The sniffed bytes must still be readable.
+	b := make([]byte, 3)
+	if _, err := plainConns[0].Read(b); err != nil || string(b) != "GET" {
+		t.Fatalf("expected to read GET got=%q err=%v", b, err)
+	}
+}
+
+func TestTLSSnifferTimeout(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := Listen(ioc, "tcp", "localhost:9989", sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	routed := 0
+	sniffer := NewTLSSniffer(
+		ioc,
+		ln,
+		func(Conn) { routed++ },
+		func(Conn) { routed++ },
+		func(err error) { t.Fatal(err) },
+	)
+	sniffer.SetSniffTimeout(50 * time.Millisecond)
+	sniffer.Start()
+	defer sniffer.Stop()
+
+	// The client never sends anything, so its connection must be closed once the sniff timeout elapses.
+	closed := make(chan error, 1)
+	go func() {
+		conn, err := net.Dial("tcp", "localhost:9989")
+		if err != nil {
+			closed <- err
+			return
+		}
+		defer conn.Close()
+
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		closed <- err
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		select {
+		case err := <-closed:
+			if err != io.EOF {
+				t.Fatalf("expected the silent client to be closed got=%v", err)
+			}
+			if routed != 0 {
+				t.Fatalf("expected the silent client to be routed nowhere got=%d", routed)
+			}
+			return
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the silent client was not closed")
+		}
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+}
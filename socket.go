@@ -6,7 +6,9 @@ import (
 	"net"
 	"net/netip"
 	"syscall"
+	"time"
 
+	"github.com/csdenboer/sonic/internal"
 	"github.com/csdenboer/sonic/sonicerrors"
 	"golang.org/x/sys/unix"
 )
@@ -110,6 +112,7 @@ type Socket struct {
 	protocol          SocketProtocol
 	readSockAddr      syscall.Sockaddr
 	writeSockAddrIpv4 *syscall.SockaddrInet4
+	writeSockAddrIpv6 *syscall.SockaddrInet6
 	fd                int
 	boundInterface    *net.Interface
 }
@@ -133,6 +136,7 @@ func NewSocket(
 		socketType:        socketType,
 		protocol:          protocol,
 		writeSockAddrIpv4: &syscall.SockaddrInet4{},
+		writeSockAddrIpv6: &syscall.SockaddrInet6{},
 		fd:                -1,
 	}
 
@@ -245,9 +249,19 @@ func (s *Socket) SendTo(
 	flags SocketIOFlags, /* not yet usable */
 	peerAddr netip.AddrPort,
 ) (int, error) {
-	s.writeSockAddrIpv4.Addr = peerAddr.Addr().As4()
-	s.writeSockAddrIpv4.Port = int(peerAddr.Port())
-	if err := syscall.Sendto(s.fd, b, 0, s.writeSockAddrIpv4); err == nil {
+	var sa syscall.Sockaddr
+	if addr := peerAddr.Addr(); addr.Is4() || addr.Is4In6() {
+		s.writeSockAddrIpv4.Addr = addr.As4()
+		s.writeSockAddrIpv4.Port = int(peerAddr.Port())
+		sa = s.writeSockAddrIpv4
+	} else {
+		// As in Bind, the zone is not set: the interface of a multicast peer is the one of
+		// IPV6_MULTICAST_IF, see the ipv6 package.
+		s.writeSockAddrIpv6.Addr = addr.As16()
+		s.writeSockAddrIpv6.Port = int(peerAddr.Port())
+		sa = s.writeSockAddrIpv6
+	}
+	if err := syscall.Sendto(s.fd, b, 0, sa); err == nil {
 		return len(b), nil
 	} else if err == syscall.EWOULDBLOCK || err == syscall.EAGAIN {
 		return 0, sonicerrors.ErrWouldBlock
@@ -273,3 +287,46 @@ func (s *Socket) BoundDevice() *net.Interface {
 func (s *Socket) RawFd() int {
 	return s.fd
 }
+
+// SetDSCP changes the DSCP of the packets sent on the socket from now on, see sonicopts.DSCP.
+//
+// Connections carrying several classes of messages, such as orders and market data backfill, can be marked per message
+// by setting the DSCP of the class before writing a message. For TCP, the mark applies to the segments sent after the
+// call, which include the bytes of earlier writes which are still unsent.
+func SetDSCP(fd int, dscp uint8) error {
+	return internal.SetDSCP(fd, dscp)
+}
+
+// GetDSCP returns the DSCP of the packets sent on the socket.
+func GetDSCP(fd int) (uint8, error) {
+	return internal.GetDSCP(fd)
+}
+
+// SetPriority changes SO_PRIORITY on the socket, see sonicopts.Priority. It is only supported on Linux.
+func SetPriority(fd int, priority int) error {
+	return internal.SetPriority(fd, priority)
+}
+
+// SetBusyPoll changes SO_BUSY_POLL on the socket, see sonicopts.BusyPoll. It is only supported on Linux.
+func SetBusyPoll(fd int, d time.Duration) error {
+	return internal.SetBusyPoll(fd, d)
+}
+
+// PeerCredentials are the credentials of the process at the other end of a Unix domain socket, as of the time it
+// connected or called listen.
+type PeerCredentials struct {
+	UID uint32
+	GID uint32
+	PID int
+}
+
+// GetPeerCredentials returns the credentials of the peer of the connected Unix domain socket fd, such as a connection
+// accepted by a Listener on the "unix" network, through SO_PEERCRED on Linux and getpeereid's LOCAL_PEERCRED on macOS.
+// Local services can authorize their clients with these, without a handshake of their own.
+func GetPeerCredentials(fd int) (PeerCredentials, error) {
+	uid, gid, pid, err := internal.PeerCredentials(fd)
+	if err != nil {
+		return PeerCredentials{}, err
+	}
+	return PeerCredentials{UID: uid, GID: gid, PID: pid}, nil
+}
@@ -61,3 +61,7 @@ func (s *Socket) UnbindFromDevice() error {
 		return fmt.Errorf("cannot yet bind to device when domain is ipv6")
 	}
 }
+
+// bindNoPort is true if sockets can be bound to a local IP without reserving a port, see
+// sonicopts.BindAddressNoPort.
+const bindNoPort = false
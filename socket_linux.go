@@ -3,9 +3,14 @@
 package sonic
 
 import (
+	"bytes"
 	"net"
+	"os"
 	"syscall"
 	"unsafe"
+
+	"github.com/csdenboer/sonic/internal"
+	"golang.org/x/sys/unix"
 )
 
 // BindToDevice binds the socket to the device with the given name. The device
@@ -56,9 +61,10 @@ func (s *Socket) UnbindFromDevice() error {
 	}
 }
 
+// GetBoundDevice returns the name of the device the socket is bound to, or an empty string if it is not bound.
 func GetBoundDevice(fd int) (string, error) {
 	into := make([]byte, syscall.IFNAMSIZ)
-	n := 0
+	n := uint32(len(into))
 
 	/* #nosec G103 -- the use of unsafe has been audited */
 	_, _, errno := syscall.Syscall6(
@@ -74,6 +80,88 @@ func GetBoundDevice(fd int) (string, error) {
 		err := errno
 		return "", err
 	} else {
+		// The kernel includes the NUL terminator in the returned length.
+		if i := bytes.IndexByte(into[:n], 0); i >= 0 {
+			n = uint32(i)
+		}
 		return string(into[:n]), nil
 	}
 }
+
+// GetOriginalDestination returns the address a TCP connection was destined to before netfilter redirected it to us,
+// through SO_ORIGINAL_DST. This is what transparent proxies use to find out where to forward an accepted connection.
+func GetOriginalDestination(fd int) (*net.TCPAddr, error) {
+	return internal.OriginalDestination(fd)
+}
+
+// GetIncomingCPU returns the CPU which processes the packets of the socket, through SO_INCOMING_CPU. With receive side
+// scaling, this is the CPU handling the interrupts of the NIC queue the connection's flow is hashed to.
+func GetIncomingCPU(fd int) (int, error) {
+	cpu, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_INCOMING_CPU)
+	if err != nil {
+		return -1, os.NewSyscallError("getsockopt", err)
+	}
+	return cpu, nil
+}
+
+// CPUSteering assigns connections to event loops based on the CPU which processes their packets, such that a
+// connection is handled on the CPU its packets already are in the cache of.
+//
+// It is meant for servers running one IO per CPU, each in a goroutine locked to its OS thread which is pinned to that
+// CPU. The server accepts a connection, picks the loop with Pick and hands the connection's file descriptor to that
+// loop, which wraps it with AdoptConn. With an IOPool, whose IOs are pinned with PinCPUs, Affinity does all of that.
+type CPUSteering struct {
+	loops map[int]int // CPU to loop index
+	n     int
+	next  int
+}
+
+// NewCPUSteering creates a CPUSteering for len(cpus) loops, where the loop with index i is pinned to cpus[i].
+func NewCPUSteering(cpus []int) *CPUSteering {
+	s := &CPUSteering{
+		loops: make(map[int]int, len(cpus)),
+		n:     len(cpus),
+	}
+	for i, cpu := range cpus {
+		s.loops[cpu] = i
+	}
+	return s
+}
+
+// Pick returns the index of the loop which should handle the connection with the given file descriptor. Connections
+// whose CPU is unknown, or whose CPU has no loop, are assigned round-robin.
+func (s *CPUSteering) Pick(fd int) int {
+	if s.n == 0 {
+		return -1
+	}
+
+	if cpu, err := GetIncomingCPU(fd); err == nil {
+		if i, ok := s.loops[cpu]; ok {
+			return i
+		}
+	}
+
+	i := s.next
+	s.next = (s.next + 1) % s.n
+	return i
+}
+
+// Affinity returns a PoolAffinity handing each connection over to the IO of an IOPool whose index is the one of the
+// loop pinned to the CPU which processes the connection's packets, see IOPool.SetAffinity and IOPool.PinCPUs.
+// Connections whose CPU is unknown, or whose CPU has no loop, are left to the policy of the pool.
+func (s *CPUSteering) Affinity() PoolAffinity {
+	return func(conn Conn) int {
+		cpu, err := GetIncomingCPU(conn.RawFd())
+		if err != nil {
+			return -1
+		}
+		if i, ok := s.loops[cpu]; ok {
+			return i
+		}
+		return -1
+	}
+}
+
+// bindNoPort is true if sockets can be bound to a local IP without reserving a port, see
+// sonicopts.BindAddressNoPort.
+const bindNoPort = true
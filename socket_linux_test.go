@@ -1,8 +1,15 @@
 package sonic
 
 import (
+	"errors"
 	"log"
+	"net"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic/sonicopts"
+	"golang.org/x/sys/unix"
 )
 
 func TestGetBoundDeviceNone(t *testing.T) {
@@ -46,3 +53,276 @@ func TestGetBoundDeviceNone(t *testing.T) {
 //		_ = sock.Close()
 //	}
 //}
+
+func TestListenAndDialBindToDevice(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := Listen(ioc, "tcp", "localhost:9998", sonicopts.BindToDevice("lo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	name, err := GetBoundDevice(ln.RawFd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "lo" {
+		t.Fatalf("expected listener to be bound to lo got=%s", name)
+	}
+
+	conn, err := Dial(ioc, "tcp", "localhost:9998", sonicopts.BindToDevice("lo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	name, err = GetBoundDevice(conn.RawFd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "lo" {
+		t.Fatalf("expected conn to be bound to lo got=%s", name)
+	}
+}
+
+func TestListenFreeBind(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	// 192.0.2.0/24 is reserved for documentation, so it is not assigned to any local interface.
+	if _, err := Listen(ioc, "tcp", "192.0.2.1:9997"); err == nil {
+		t.Fatal("expected listen on a non-local address to fail without free bind")
+	}
+
+	ln, err := Listen(ioc, "tcp", "192.0.2.1:9997", sonicopts.FreeBind(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+}
+
+func TestCPUSteeringAndAdoptConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("hello"))
+		b := make([]byte, 1)
+		_, _ = conn.Read(b)
+	}()
+
+	accepting := MustIO()
+	defer accepting.Close()
+
+	conn, err := Dial(accepting, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cpu, err := GetIncomingCPU(conn.RawFd())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steering := NewCPUSteering([]int{cpu + 1, cpu})
+	if i := steering.Pick(conn.RawFd()); i != 1 {
+		t.Fatalf("expected the loop pinned to cpu=%d got loop=%d", cpu, i)
+	}
+
+	// Connections on CPUs without a loop are assigned round-robin.
+	steering = NewCPUSteering([]int{cpu + 1, cpu + 2})
+	if a, b := steering.Pick(conn.RawFd()), steering.Pick(conn.RawFd()); a != 0 || b != 1 {
+		t.Fatalf("expected round-robin assignment got=%d,%d", a, b)
+	}
+
+	handling := MustIO()
+	defer handling.Close()
+
+	adopted, err := AdoptConn(handling, conn.RawFd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adopted.Close()
+
+	if adopted.RemoteAddr().String() != ln.Addr().String() {
+		t.Fatalf("expected remote address=%s got=%s", ln.Addr(), adopted.RemoteAddr())
+	}
+
+	b := make([]byte, 5)
+	done := false
+	adopted.AsyncReadAll(b, func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		done = true
+	})
+	for i := 0; i < 100 && !done; i++ {
+		_ = handling.RunOneFor(10 * time.Millisecond)
+	}
+	if !done || string(b) != "hello" {
+		t.Fatalf("expected to read hello on the adopted connection got=%s", b)
+	}
+}
+
+func TestCPUSteeringPoolAffinity(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := Listen(ioc, "tcp", "localhost:9986", sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	probe, err := net.Dial("tcp", "localhost:9986")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer probe.Close()
+	accepted, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu, err := GetIncomingCPU(accepted.RawFd())
+	_ = accepted.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err := NewIOPool(2, PoolRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	if err := pool.Run(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.PinCPUs([]int{cpu, cpu}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the IO with index 1 is on the CPU of the connections, as far as the steering is concerned.
+	pool.SetAffinity(NewCPUSteering([]int{cpu + 1, cpu}).Affinity())
+
+	type assignment struct{ cpu, io int }
+	assignments := make(chan assignment, 16)
+	NewAcceptor(ln, func(conn Conn) {
+		connCPU, err := GetIncomingCPU(conn.RawFd())
+		if err != nil {
+			t.Error(err)
+		}
+		if _, err := pool.AdoptIndexed(conn, func(i int, conn Conn) {
+			assignments <- assignment{connCPU, i}
+			_ = conn.Close()
+		}); err != nil {
+			t.Error(err)
+		}
+	}, func(err error) { t.Error(err) }).Start()
+
+	// The connections are processed on any CPU, so they are opened until one is processed on the CPU of the steering.
+	matched := false
+	for i := 0; i < 16 && !matched; i++ {
+		conn, err := net.Dial("tcp", "localhost:9986")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		deadline := time.Now().Add(time.Second)
+		for {
+			_ = ioc.RunOneFor(time.Millisecond)
+			select {
+			case a := <-assignments:
+				if a.cpu == cpu {
+					if a.io != 1 {
+						t.Fatalf("expected the connection on cpu=%d to be served by IO 1 got=%d", cpu, a.io)
+					}
+					matched = true
+				}
+			default:
+				if time.Now().Before(deadline) {
+					continue
+				}
+				t.Fatal("the connection was not handed over")
+			}
+			break
+		}
+	}
+	if !matched {
+		t.Skip("no connection was processed on the CPU of the probe")
+	}
+}
+
+func TestDialBusyPoll(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String(), sonicopts.BusyPoll(50*time.Microsecond))
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("setting SO_BUSY_POLL requires CAP_NET_ADMIN")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if us, err := syscall.GetsockoptInt(conn.RawFd(), syscall.SOL_SOCKET, unix.SO_BUSY_POLL); err != nil || us != 50 {
+		t.Fatalf("expected busy_poll=50us got=%d err=%v", us, err)
+	}
+
+	if err := SetBusyPoll(conn.RawFd(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if us, _ := syscall.GetsockoptInt(conn.RawFd(), syscall.SOL_SOCKET, unix.SO_BUSY_POLL); us != 0 {
+		t.Fatalf("expected busy_poll=0us got=%d", us)
+	}
+}
+
+func TestDialDSCPAndPriority(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String(),
+		sonicopts.DSCP(sonicopts.DSCPLowEffort), sonicopts.Priority(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if dscp, err := GetDSCP(conn.RawFd()); err != nil || dscp != sonicopts.DSCPLowEffort {
+		t.Fatalf("expected dscp=%d got=%d err=%v", sonicopts.DSCPLowEffort, dscp, err)
+	}
+	if p, err := syscall.GetsockoptInt(conn.RawFd(), syscall.SOL_SOCKET, syscall.SO_PRIORITY); err != nil || p != 1 {
+		t.Fatalf("expected priority=1 got=%d err=%v", p, err)
+	}
+
+	// Mark the next messages as orders.
+	if err := SetDSCP(conn.RawFd(), sonicopts.DSCPExpedited); err != nil {
+		t.Fatal(err)
+	}
+	if dscp, _ := GetDSCP(conn.RawFd()); dscp != sonicopts.DSCPExpedited {
+		t.Fatalf("expected dscp=%d got=%d", sonicopts.DSCPExpedited, dscp)
+	}
+}
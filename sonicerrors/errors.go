@@ -1,6 +1,9 @@
 package sonicerrors
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrWouldBlock             = errors.New("operation would block")
@@ -8,4 +11,30 @@ var (
 	ErrTimeout                = errors.New("operation timed out")
 	ErrNeedMore               = errors.New("need to read/write more bytes")
 	ErrNoBufferSpaceAvailable = errors.New("no buffer space available")
+	ErrBackoffExhausted       = errors.New("backoff exhausted all attempts")
+	ErrInvalidBackoff         = errors.New("invalid backoff delays")
+	ErrRetryPending           = errors.New("a retry is already scheduled")
+	ErrPostQueueFull          = errors.New("too many handlers posted")
+	ErrWakeupFailed           = errors.New("could not wake up the event loop")
+	ErrStaleMark              = errors.New("buffer mark invalidated by a removal of bytes")
+	ErrMemoryLimit            = errors.New("memory limit exceeded")
+	ErrUnsolicitedEvent       = errors.New("event does not match a registered handler")
+	ErrTooManyHandshakes      = errors.New("too many handshakes in progress")
+	ErrInvariantViolation     = errors.New("invariant violated")
+	ErrBufferMaxSize          = errors.New("buffer maximum size exceeded")
+	ErrReentrantWait          = errors.New("blocking wait called from a handler of the IO")
+	ErrAcceptPending          = errors.New("an asynchronous accept is already pending")
+
+	// ErrPortsExhausted wraps the errors of the dials which failed because no local ephemeral port was left to connect
+	// from. A client hitting it should reuse its connections, spread them over several source IPs with
+	// sonic.DialSources, or widen the ephemeral port range of the host.
+	ErrPortsExhausted = errors.New("ephemeral ports exhausted")
+
+	// ErrClosed is an ErrWakeupFailed returned by Post once the IO is closed, after which no handler can be posted.
+	ErrClosed = fmt.Errorf("%w: the event loop is closed", ErrWakeupFailed)
+
+	// ErrIdleTimeout and ErrStallTimeout are both an ErrTimeout. ErrIdleTimeout means that no byte of the next
+	// message arrived in time, ErrStallTimeout that a started message was not received in full in time.
+	ErrIdleTimeout  = fmt.Errorf("%w: no message started", ErrTimeout)
+	ErrStallTimeout = fmt.Errorf("%w: message stalled", ErrTimeout)
 )
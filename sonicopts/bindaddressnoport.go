@@ -0,0 +1,24 @@
+package sonicopts
+
+type bindAddressNoPort struct {
+	v bool
+}
+
+// BindAddressNoPort sets IP_BIND_ADDRESS_NO_PORT on the socket, such that binding it to a local IP with BindSocket, and
+// port 0, does not reserve an ephemeral port right away. The port is picked when connecting instead, knowing the
+// remote address, so the same local port can be shared by connections to different remote addresses. Without it, a
+// client binding its connections to a source IP is limited to one connection per ephemeral port of that IP. It is only
+// supported on Linux.
+func BindAddressNoPort(v bool) Option {
+	return &bindAddressNoPort{
+		v: v,
+	}
+}
+
+func (o *bindAddressNoPort) Type() OptionType {
+	return TypeBindAddressNoPort
+}
+
+func (o *bindAddressNoPort) Value() interface{} {
+	return o.v
+}
@@ -0,0 +1,40 @@
+package sonicopts
+
+import "net"
+
+// PortRange is an inclusive range of local ports on the given IP.
+type PortRange struct {
+	IP   net.IP
+	Low  int
+	High int
+}
+
+type bindPortRange struct {
+	r PortRange
+}
+
+// BindPortRange covers the case in which the user wants to bind the socket to a local port from the inclusive range
+// [low, high] when Dialing a remote endpoint. This is needed by networks which whitelist the source ports of their
+// participants.
+//
+// A port is picked at random from the range. If it is in use, the next one is tried, until either the bind succeeds or
+// all ports in the range have been tried. The same goes for a connect which fails with EADDRNOTAVAIL or EADDRINUSE
+// after the bind succeeded, which happens with ReuseAddr if the port is used by another connection to the same peer:
+// the dial is retried from the next port of the range on a new socket.
+func BindPortRange(ip net.IP, low, high int) Option {
+	return &bindPortRange{
+		r: PortRange{
+			IP:   ip,
+			Low:  low,
+			High: high,
+		},
+	}
+}
+
+func (o *bindPortRange) Type() OptionType {
+	return TypeBindPortRange
+}
+
+func (o *bindPortRange) Value() interface{} {
+	return o.r
+}
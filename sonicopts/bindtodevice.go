@@ -0,0 +1,23 @@
+package sonicopts
+
+type bindToDevice struct {
+	name string
+}
+
+// BindToDevice pins the socket to the network interface with the given name, such that only packets going through
+// that interface are sent or received by the socket. On Linux, the name can also be that of a VRF device.
+//
+// This is useful on multi-homed hosts, where, for example, market data and order entry go through different NICs.
+func BindToDevice(name string) Option {
+	return &bindToDevice{
+		name: name,
+	}
+}
+
+func (o *bindToDevice) Type() OptionType {
+	return TypeBindToDevice
+}
+
+func (o *bindToDevice) Value() interface{} {
+	return o.name
+}
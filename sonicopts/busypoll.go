@@ -0,0 +1,26 @@
+package sonicopts
+
+import "time"
+
+type busyPoll struct {
+	v time.Duration
+}
+
+// BusyPoll sets SO_BUSY_POLL on the socket, such that a read finding no data busy-polls the device queue of the
+// network interface for up to d before giving up, instead of waiting for an interrupt. It trades CPU for the latency
+// of receiving, and is rounded down to the microsecond. Setting more than net.core.busy_read requires CAP_NET_ADMIN.
+//
+// It is only supported on Linux.
+func BusyPoll(d time.Duration) Option {
+	return &busyPoll{
+		v: d,
+	}
+}
+
+func (o *busyPoll) Type() OptionType {
+	return TypeBusyPoll
+}
+
+func (o *busyPoll) Value() interface{} {
+	return o.v
+}
@@ -16,6 +16,14 @@ const (
 	TypeNoDelay
 	TypeBindSocket
 	TypeMulticast
+	TypeBindToDevice
+	TypeTransparent
+	TypeFreeBind
+	TypeBindPortRange
+	TypeDSCP
+	TypePriority
+	TypeBindAddressNoPort
+	TypeBusyPoll
 	MaxOption
 )
 
@@ -33,6 +41,22 @@ func (t OptionType) String() string {
 		return "bind_socket"
 	case TypeMulticast:
 		return "multicast"
+	case TypeBindToDevice:
+		return "bind_to_device"
+	case TypeTransparent:
+		return "transparent"
+	case TypeFreeBind:
+		return "free_bind"
+	case TypeBindPortRange:
+		return "bind_port_range"
+	case TypeDSCP:
+		return "dscp"
+	case TypePriority:
+		return "priority"
+	case TypeBindAddressNoPort:
+		return "bind_address_no_port"
+	case TypeBusyPoll:
+		return "busy_poll"
 	default:
 		panic(fmt.Errorf("invalid option %d", t))
 	}
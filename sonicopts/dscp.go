@@ -0,0 +1,30 @@
+package sonicopts
+
+// Common DSCP values, see RFC 4594.
+const (
+	DSCPDefault   uint8 = 0  // best effort
+	DSCPLowEffort uint8 = 8  // CS1, e.g. market data backfill
+	DSCPAF41      uint8 = 34 // assured forwarding, e.g. interactive traffic
+	DSCPExpedited uint8 = 46 // EF, e.g. order entry
+)
+
+type dscp struct {
+	v uint8
+}
+
+// DSCP sets the differentiated services code point of the packets sent by the socket, through IP_TOS, or IPV6_TCLASS
+// for IPv6 sockets. Routers configured for it forward packets with a greater priority first. Only the 6 lower bits of
+// v are used.
+func DSCP(v uint8) Option {
+	return &dscp{
+		v: v & 0x3f,
+	}
+}
+
+func (o *dscp) Type() OptionType {
+	return TypeDSCP
+}
+
+func (o *dscp) Value() interface{} {
+	return o.v
+}
@@ -0,0 +1,21 @@
+package sonicopts
+
+type freeBind struct {
+	v bool
+}
+
+// FreeBind sets IP_FREEBIND on the socket, which allows it to bind to an address that is not, or not yet, assigned to
+// a local interface. It is only supported on Linux.
+func FreeBind(v bool) Option {
+	return &freeBind{
+		v: v,
+	}
+}
+
+func (o *freeBind) Type() OptionType {
+	return TypeFreeBind
+}
+
+func (o *freeBind) Value() interface{} {
+	return o.v
+}
@@ -0,0 +1,23 @@
+package sonicopts
+
+type priority struct {
+	v int
+}
+
+// Priority sets SO_PRIORITY on the socket, which selects the queue of the network interface its packets go through
+// when the interface uses a priority queueing discipline. Values from 0 to 6 can be set without CAP_NET_ADMIN.
+//
+// It is only supported on Linux.
+func Priority(v int) Option {
+	return &priority{
+		v: v,
+	}
+}
+
+func (o *priority) Type() OptionType {
+	return TypePriority
+}
+
+func (o *priority) Value() interface{} {
+	return o.v
+}
@@ -0,0 +1,23 @@
+package sonicopts
+
+type transparent struct {
+	v bool
+}
+
+// Transparent sets IP_TRANSPARENT on the socket, which allows it to bind to non-local addresses and to accept
+// connections redirected to it by a TPROXY rule. This is the building block of transparent proxies.
+//
+// Setting this option requires CAP_NET_ADMIN. It is only supported on Linux.
+func Transparent(v bool) Option {
+	return &transparent{
+		v: v,
+	}
+}
+
+func (o *transparent) Type() OptionType {
+	return TypeTransparent
+}
+
+func (o *transparent) Value() interface{} {
+	return o.v
+}
@@ -0,0 +1,211 @@
+//go:build linux
+
+package sonic
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/csdenboer/sonic/internal"
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+// Splicer moves bytes from one file descriptor to another, for example between two sockets when proxying, without
+// copying them to user space. The bytes are first spliced from the source into a pipe owned by the Splicer and then
+// from the pipe into the destination, see splice(2).
+//
+// A Splicer is not safe for concurrent use. A proxy should use one Splicer per direction.
+type Splicer struct {
+	pipe *internal.Pipe
+
+	// buffered is the number of bytes spliced from the source into the pipe, not yet spliced into the destination.
+	buffered int
+}
+
+var (
+	_ io.ReadWriter = &Splicer{}
+	_ io.ReaderFrom = &Splicer{}
+	_ io.WriterTo   = &Splicer{}
+)
+
+func NewSplicer() (*Splicer, error) {
+	pipe, err := internal.NewPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := pipe.SetReadNonblock(); err != nil {
+		pipe.Close()
+		return nil, err
+	}
+	if err := pipe.SetWriteNonblock(); err != nil {
+		pipe.Close()
+		return nil, err
+	}
+	return &Splicer{pipe: pipe}, nil
+}
+
+// Splice moves at most n bytes from src to dst and returns the number of bytes written into dst.
+//
+// If dst cannot take all the bytes read from src, the remaining bytes are kept in the Splicer and are written first
+// by the next call to Splice, before anything else is read from src. Buffered returns the number of such bytes.
+//
+// sonicerrors.ErrWouldBlock is returned if src has no bytes to read, when Buffered is 0, or if dst cannot be
+// written to, when Buffered is not 0. The caller should then wait for src to become readable or for dst to become
+// writable, respectively. io.EOF is returned if src reached the end of its stream.
+func (s *Splicer) Splice(dst, src FileDescriptor, n int) (written int64, err error) {
+	if s.buffered == 0 {
+		if _, err := s.fill(src, n); err != nil {
+			return 0, err
+		}
+	}
+	return s.flush(dst)
+}
+
+// Read reads the buffered bytes of the Splicer into b. io.EOF is returned if there are no buffered bytes.
+func (s *Splicer) Read(b []byte) (int, error) {
+	if s.buffered == 0 {
+		return 0, io.EOF
+	}
+	if len(b) > s.buffered {
+		b = b[:s.buffered]
+	}
+	n, err := s.pipe.Read(b)
+	if err != nil {
+		return 0, spliceError(err)
+	}
+	s.buffered -= n
+	return n, nil
+}
+
+// Write writes b into the pipe of the Splicer, where the bytes are buffered until written out by WriteTo or Splice.
+// sonicerrors.ErrWouldBlock is returned if the pipe is full.
+func (s *Splicer) Write(b []byte) (int, error) {
+	n, err := s.pipe.Write(b)
+	if err != nil {
+		return 0, spliceError(err)
+	}
+	s.buffered += n
+	return n, nil
+}
+
+// ReadFrom splices the bytes available in r into the pipe of the Splicer, without copying them to user space, if r
+// is a FileDescriptor. If r is a ByteBuffer, the bytes of its read area are written into the pipe and consumed from
+// r. The bytes are then buffered until written out by WriteTo or Splice.
+//
+// sonicerrors.ErrWouldBlock is returned if r has no bytes to read or if the pipe is full.
+func (s *Splicer) ReadFrom(r io.Reader) (int64, error) {
+	switch src := r.(type) {
+	case FileDescriptor:
+		return s.fill(src, spliceChunk)
+	case *ByteBuffer:
+		return s.readFromBuffer(src)
+	default:
+		return 0, fmt.Errorf("cannot splice from %T", r)
+	}
+}
+
+// WriteTo splices the buffered bytes of the Splicer into w, without copying them to user space, if w is a
+// FileDescriptor. If w is a ByteBuffer, the buffered bytes are read directly into its write area, which is not
+// grown.
+//
+// sonicerrors.ErrWouldBlock is returned if w cannot be written to. io.ErrShortBuffer is returned if w is a
+// ByteBuffer with a full write area.
+func (s *Splicer) WriteTo(w io.Writer) (int64, error) {
+	switch dst := w.(type) {
+	case FileDescriptor:
+		return s.flush(dst)
+	case *ByteBuffer:
+		return s.writeToBuffer(dst)
+	default:
+		return 0, fmt.Errorf("cannot splice into %T", w)
+	}
+}
+
+// fill splices at most n bytes from src into the pipe.
+func (s *Splicer) fill(src FileDescriptor, n int) (int64, error) {
+	nin, err := syscall.Splice(
+		src.RawFd(), nil,
+		s.pipe.WriteFd(), nil,
+		n,
+		unixSpliceMove|unixSpliceNonblock,
+	)
+	if err != nil {
+		return 0, spliceError(err)
+	}
+	if nin == 0 {
+		return 0, io.EOF
+	}
+	s.buffered += int(nin)
+	return int64(nin), nil
+}
+
+// flush splices the buffered bytes from the pipe into dst.
+func (s *Splicer) flush(dst FileDescriptor) (written int64, err error) {
+	for s.buffered > 0 {
+		nout, err := syscall.Splice(
+			s.pipe.ReadFd(), nil,
+			dst.RawFd(), nil,
+			s.buffered,
+			unixSpliceMove|unixSpliceNonblock,
+		)
+		if err != nil {
+			return written, spliceError(err)
+		}
+		written += int64(nout)
+		s.buffered -= int(nout)
+	}
+	return written, nil
+}
+
+func (s *Splicer) readFromBuffer(b *ByteBuffer) (int64, error) {
+	if b.ReadLen() == 0 {
+		return 0, nil
+	}
+	n, err := s.Write(b.Data())
+	b.Consume(n)
+	return int64(n), err
+}
+
+func (s *Splicer) writeToBuffer(b *ByteBuffer) (int64, error) {
+	if s.buffered == 0 {
+		return 0, io.EOF
+	}
+	if err := b.full(); err != nil {
+		return 0, err
+	}
+	if b.Reserved() == 0 {
+		return 0, io.ErrShortBuffer
+	}
+	n, err := s.Read(b.data[b.wi:cap(b.data)])
+	b.wi += n
+	b.data = b.data[:b.wi]
+	return int64(n), err
+}
+
+// Buffered returns the number of bytes read from the source which are not yet written to the destination.
+func (s *Splicer) Buffered() int {
+	return s.buffered
+}
+
+// Close closes the pipe of the Splicer. Buffered bytes are lost.
+func (s *Splicer) Close() error {
+	s.buffered = 0
+	return s.pipe.Close()
+}
+
+// spliceChunk is the default capacity of a pipe on Linux, see pipe(7). ReadFrom splices at most that many bytes.
+const spliceChunk = 64 * 1024
+
+const (
+	unixSpliceMove     = 0x1 // SPLICE_F_MOVE
+	unixSpliceNonblock = 0x2 // SPLICE_F_NONBLOCK
+)
+
+func spliceError(err error) error {
+	if err == syscall.EAGAIN || err == syscall.EWOULDBLOCK {
+		return sonicerrors.ErrWouldBlock
+	}
+	return os.NewSyscallError("splice", err)
+}
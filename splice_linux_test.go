@@ -0,0 +1,191 @@
+//go:build linux
+
+package sonic
+
+import (
+	"io"
+	"syscall"
+	"testing"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+func socketPair(t testing.TB, ioc *IO) (*conn, *conn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newConn(ioc, fds[0], nil, nil), newConn(ioc, fds[1], nil, nil)
+}
+
+func TestSplicer(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	// client -> (in) proxy (out) -> server
+	client, in := socketPair(t, ioc)
+	defer client.Close()
+	defer in.Close()
+	out, server := socketPair(t, ioc)
+	defer out.Close()
+	defer server.Close()
+
+	s, err := NewSplicer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := s.Splice(out, in, 1024); err != sonicerrors.ErrWouldBlock {
+		t.Fatalf("expected ErrWouldBlock got=%v", err)
+	}
+
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	n, err := s.Splice(out, in, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 || s.Buffered() != 0 {
+		t.Fatalf("expected to splice 5 bytes got=%d buffered=%d", n, s.Buffered())
+	}
+
+	b := make([]byte, 128)
+	nn, err := server.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:nn]) != "hello" {
+		t.Fatalf("expected hello got=%s", b[:nn])
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Splice(out, in, 1024); err != io.EOF {
+		t.Fatalf("expected EOF got=%v", err)
+	}
+}
+
+func TestSplicerByteBuffer(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	client, server := socketPair(t, ioc)
+	defer client.Close()
+	defer server.Close()
+
+	s, err := NewSplicer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// buffer -> splicer -> socket
+	src := NewByteBuffer()
+	src.Write([]byte("hello"))
+	src.Commit(5)
+	if n, err := src.WriteTo(s); err != nil || n != 5 {
+		t.Fatalf("expected to write 5 bytes into the splicer got=%d err=%v", n, err)
+	}
+	if src.ReadLen() != 0 || s.Buffered() != 5 {
+		t.Fatalf("expected the bytes to move into the splicer got=%d buffered=%d", src.ReadLen(), s.Buffered())
+	}
+	if n, err := s.WriteTo(client); err != nil || n != 5 {
+		t.Fatalf("expected to splice 5 bytes got=%d err=%v", n, err)
+	}
+
+	// socket -> splicer -> buffer
+	if n, err := s.ReadFrom(server); err != nil || n != 5 {
+		t.Fatalf("expected to splice 5 bytes got=%d err=%v", n, err)
+	}
+	dst := NewByteBuffer()
+	dst.Reserve(3)
+	dst.Claim(func(b []byte) int { return cap(b) })
+	if _, err := dst.ReadFrom(s); err != io.ErrShortBuffer {
+		t.Fatalf("expected ErrShortBuffer got=%v", err)
+	}
+
+	dst = NewByteBuffer()
+	if n, err := dst.ReadFrom(s); err != nil || n != 5 {
+		t.Fatalf("expected to read 5 bytes from the splicer got=%d err=%v", n, err)
+	}
+	dst.Commit(dst.WriteLen())
+	if string(dst.Data()) != "hello" || s.Buffered() != 0 {
+		t.Fatalf("expected hello got=%s buffered=%d", dst.Data(), s.Buffered())
+	}
+	if _, err := dst.ReadFrom(s); err != io.EOF {
+		t.Fatalf("expected EOF from an empty splicer got=%v", err)
+	}
+}
+
+func TestSplicerCodecConn(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	// client -> (in) proxy (out) -> server
+	client, in := socketPair(t, ioc)
+	defer client.Close()
+	defer in.Close()
+	out, server := socketPair(t, ioc)
+	defer out.Close()
+	defer server.Close()
+
+	codecConn, err := NewNonblockingCodecConn[TestItem, TestItem](
+		in, &TestCodec{}, NewByteBuffer(), NewByteBuffer())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSplicer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// The first message is decoded, the rest of the stream is handed over to the splicer.
+	if _, err := client.Write([]byte("helloworld")); err != nil {
+		t.Fatal(err)
+	}
+	item, err := codecConn.ReadNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(item.V[:]) != "hello" {
+		t.Fatalf("expected hello got=%s", item.V[:])
+	}
+	if n, err := codecConn.WriteTo(s); err != nil || n != 5 {
+		t.Fatalf("expected to drain 5 bytes got=%d err=%v", n, err)
+	}
+	if _, err := s.WriteTo(out); err != nil {
+		t.Fatal(err)
+	}
+
+	b := make([]byte, 128)
+	n, err := server.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:n]) != "world" {
+		t.Fatalf("expected world got=%s", b[:n])
+	}
+
+	// The bytes from the server are written back as they are, without encoding them.
+	if _, err := server.Write([]byte("back")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadFrom(out); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := codecConn.ReadFrom(s); err != nil || n != 4 {
+		t.Fatalf("expected to pass 4 bytes got=%d err=%v", n, err)
+	}
+	n, err = client.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:n]) != "back" {
+		t.Fatalf("expected back got=%s", b[:n])
+	}
+}
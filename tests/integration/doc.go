@@ -0,0 +1,10 @@
+// Package integration holds the end-to-end tests of the websocket client
+// against real TLS websocket servers, running in-process on an IO of their
+// own. They exercise the handshake, compression, reconnects, keep-alives and
+// the closing handshake together, to catch the regressions which span those
+// features before a release.
+//
+// The tests are behind the integration build tag:
+//
+//	go test -tags integration ./tests/integration
+package integration
@@ -0,0 +1,263 @@
+//go:build integration
+
+package integration
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic"
+	"github.com/csdenboer/sonic/codec/websocket"
+	"github.com/csdenboer/sonic/sonicopts"
+)
+
+// testTimeout bounds the time a test runs its IO for.
+const testTimeout = 5 * time.Second
+
+// serverConfig configures the behavior of a server. The zero value is a well
+// behaved echo server.
+type serverConfig struct {
+	// deflate enables permessage-deflate on the server streams.
+	deflate *websocket.DeflateConfig
+
+	// dropAfter makes the server close the connection, without a close frame,
+	// once it echoed that many messages. 0 means never.
+	dropAfter int
+
+	// mute makes the server never read from its streams once they are
+	// upgraded, so it answers neither messages nor pings.
+	mute bool
+
+	// invalidUTF8 makes the server answer every message with a text message
+	// which is not valid UTF-8.
+	invalidUTF8 bool
+}
+
+// server is a TLS websocket server running on an IO of its own, on a
+// goroutine of its own.
+type server struct {
+	cfg   serverConfig
+	addr  string
+	roots *x509.CertPool
+
+	upgraded int32
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startServer starts a server configured by cfg, which is stopped once the
+// test completes.
+func startServer(t *testing.T, cfg serverConfig) *server {
+	cert, roots := selfSignedCert(t)
+	s := &server{
+		cfg:   cfg,
+		roots: roots,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	ready := make(chan error, 1)
+	go s.run(&tls.Config{Certificates: []tls.Certificate{cert}}, ready)
+	if err := <-ready; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		close(s.stop)
+		<-s.done
+	})
+	return s
+}
+
+func (s *server) run(cfg *tls.Config, ready chan<- error) {
+	defer close(s.done)
+
+	ioc, err := sonic.NewIO()
+	if err != nil {
+		ready <- err
+		return
+	}
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(ioc, "tcp", "127.0.0.1:0", sonicopts.Nonblocking(true))
+	if err != nil {
+		ready <- err
+		return
+	}
+	defer ln.Close()
+
+	// The address of the listener is the one it was created with, whose port
+	// is 0.
+	sa, err := syscall.Getsockname(ln.RawFd())
+	if err != nil {
+		ready <- err
+		return
+	}
+	s.addr = fmt.Sprintf("localhost:%d", sa.(*syscall.SockaddrInet4).Port)
+
+	var streams []*websocket.WebsocketStream
+	pool := sonic.NewTLSHandshakePool(ioc, cfg, 1, 0)
+	sonic.NewAcceptor(ln, pool.Handler(func(tc *sonic.TLSStream) {
+		if ws := s.serve(ioc, tc); ws != nil {
+			streams = append(streams, ws)
+		}
+	}, nil), nil).Start()
+	ready <- nil
+
+	for {
+		select {
+		case <-s.stop:
+			for _, ws := range streams {
+				_ = ws.CloseNextLayer()
+			}
+			return
+		default:
+			_ = ioc.RunOneFor(time.Millisecond)
+		}
+	}
+}
+
+func (s *server) serve(ioc *sonic.IO, tc *sonic.TLSStream) *websocket.WebsocketStream {
+	ws, err := websocket.NewWebsocketStream(ioc, nil, websocket.RoleServer)
+	if err != nil {
+		_ = tc.Close()
+		return nil
+	}
+	if s.cfg.deflate != nil {
+		if err := ws.SetDeflate(s.cfg.deflate); err != nil {
+			_ = tc.Close()
+			return nil
+		}
+	}
+
+	ws.AsyncAccept(tc, func(err error) {
+		if err != nil {
+			_ = ws.CloseNextLayer()
+			return
+		}
+		atomic.AddInt32(&s.upgraded, 1)
+		if !s.cfg.mute {
+			s.echo(ws, make([]byte, websocket.MaxMessageSize), 0)
+		}
+	})
+	return ws
+}
+
+// echo writes the next message of ws back to it, until the connection is
+// closed.
+func (s *server) echo(ws *websocket.WebsocketStream, b []byte, echoed int) {
+	ws.AsyncNextMessage(b, func(err error, n int, mt websocket.MessageType) {
+		if err != nil {
+			_ = ws.CloseNextLayer()
+			return
+		}
+
+		reply := b[:n]
+		if s.cfg.invalidUTF8 {
+			mt, reply = websocket.TypeText, []byte{0xff, 0xfe}
+		}
+		ws.AsyncWrite(reply, mt, func(err error) {
+			echoed++
+			if err != nil || echoed == s.cfg.dropAfter {
+				_ = ws.CloseNextLayer()
+				return
+			}
+			s.echo(ws, b, echoed)
+		})
+	})
+}
+
+// Upgraded returns the number of connections upgraded by the server.
+func (s *server) Upgraded() int {
+	return int(atomic.LoadInt32(&s.upgraded))
+}
+
+// URL returns the address of the server for a client handshake.
+func (s *server) URL() string {
+	return "wss://" + s.addr + "/"
+}
+
+// ClientTLS returns the TLS configuration of the clients of the server, which
+// trusts its certificate.
+func (s *server) ClientTLS() *tls.Config {
+	return &tls.Config{RootCAs: s.roots, ServerName: "localhost"}
+}
+
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              []string{"localhost"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, roots
+}
+
+// newClient creates a client stream for s, on an IO closed once the test
+// completes.
+func newClient(t *testing.T, s *server) (*sonic.IO, *websocket.WebsocketStream) {
+	ioc := sonic.MustIO()
+	t.Cleanup(func() { _ = ioc.Close() })
+
+	ws, err := websocket.NewWebsocketStream(ioc, s.ClientTLS(), websocket.RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ws.CloseNextLayer() })
+	return ioc, ws
+}
+
+// runUntil runs ioc until done returns true, failing the test if it takes
+// longer than testTimeout.
+func runUntil(t *testing.T, ioc *sonic.IO, done func() bool) {
+	t.Helper()
+	for start := time.Now(); !done(); {
+		if time.Since(start) > testTimeout {
+			t.Fatal("timed out")
+		}
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+}
+
+// handshake performs the handshake of ws with s, running ioc until it
+// completes.
+func handshake(t *testing.T, ioc *sonic.IO, ws *websocket.WebsocketStream, s *server) {
+	t.Helper()
+	var (
+		done bool
+		err  error
+	)
+	ws.AsyncHandshake(s.URL(), func(e error) {
+		done, err = true, e
+	})
+	runUntil(t, ioc, func() bool { return done })
+	if err != nil {
+		t.Fatal(err)
+	}
+}
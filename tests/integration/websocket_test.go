@@ -0,0 +1,257 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic"
+	"github.com/csdenboer/sonic/codec/websocket"
+)
+
+func TestEchoAndClose(t *testing.T) {
+	s := startServer(t, serverConfig{})
+	ioc, ws := newClient(t, s)
+	handshake(t, ioc, ws, s)
+
+	b := make([]byte, 1024)
+	for i := 0; i < 10; i++ {
+		msg := fmt.Sprintf("hello %d", i)
+		if err := ws.Write([]byte(msg), websocket.TypeText); err != nil {
+			t.Fatal(err)
+		}
+		mt, n, err := ws.NextMessage(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mt != websocket.TypeText || string(b[:n]) != msg {
+			t.Fatalf("expected %q got=%q", msg, b[:n])
+		}
+	}
+
+	if err := ws.Close(websocket.CloseNormal, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ws.NextMessage(b); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF after the close reply, got %v", err)
+	}
+	if ws.State() != websocket.StateCloseAcked {
+		t.Fatalf("wrong state %s", ws.State())
+	}
+}
+
+func TestDeflate(t *testing.T) {
+	cfg := &websocket.DeflateConfig{}
+	s := startServer(t, serverConfig{deflate: cfg})
+	ioc, ws := newClient(t, s)
+	if err := ws.SetDeflate(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	written := 0
+	ws.SetTracer(&sonic.TraceConfig{
+		OnTrace: func(dir sonic.TraceDirection, _ []byte, n int) {
+			if dir == sonic.TraceWrite {
+				written += n
+			}
+		},
+	})
+	handshake(t, ioc, ws, s)
+
+	msg := bytes.Repeat([]byte("compressible "), 4096)
+	b := make([]byte, 2*len(msg))
+	for i := 0; i < 3; i++ {
+		written = 0
+		if err := ws.Write(msg, websocket.TypeText); err != nil {
+			t.Fatal(err)
+		}
+		if written*10 > len(msg) {
+			t.Fatalf("message of %d bytes written as %d bytes", len(msg), written)
+		}
+
+		_, n, err := ws.NextMessage(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b[:n], msg) {
+			t.Fatal("wrong echo")
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	const (
+		dropAfter = 3
+		messages  = 10
+	)
+	s := startServer(t, serverConfig{dropAfter: dropAfter})
+	ioc, ws := newClient(t, s)
+
+	backoff, err := sonic.NewBackoff(ioc, time.Millisecond, 10*time.Millisecond, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backoff.Close()
+
+	var (
+		echoed  int
+		failure error
+		b       = make([]byte, 1024)
+		connect func()
+		next    func()
+	)
+	reconnect := func() {
+		if err := backoff.AsyncRetry(connect); err != nil {
+			failure = err
+		}
+	}
+	next = func() {
+		msg := fmt.Sprintf("hello %d", echoed)
+		ws.AsyncWrite([]byte(msg), websocket.TypeText, func(err error) {
+			if err != nil {
+				reconnect()
+				return
+			}
+			ws.AsyncNextMessage(b, func(err error, n int, _ websocket.MessageType) {
+				if err != nil {
+					reconnect()
+					return
+				}
+				if string(b[:n]) != msg {
+					failure = fmt.Errorf("expected %q got=%q", msg, b[:n])
+					return
+				}
+				if echoed++; echoed < messages {
+					next()
+				}
+			})
+		})
+	}
+	connect = func() {
+		ws.AsyncHandshake(s.URL(), func(err error) {
+			if err != nil {
+				reconnect()
+				return
+			}
+			_ = backoff.Reset()
+			next()
+		})
+	}
+	connect()
+
+	runUntil(t, ioc, func() bool { return echoed == messages || failure != nil })
+	if failure != nil {
+		t.Fatal(failure)
+	}
+	if expected := (messages + dropAfter - 1) / dropAfter; s.Upgraded() != expected {
+		t.Fatalf("expected %d connections got=%d", expected, s.Upgraded())
+	}
+}
+
+func TestKeepAliveTimeout(t *testing.T) {
+	s := startServer(t, serverConfig{mute: true})
+	ioc, ws := newClient(t, s)
+	handshake(t, ioc, ws, s)
+
+	if err := ws.SetKeepAlive(10*time.Millisecond, 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		done bool
+		err  error
+	)
+	ws.AsyncNextMessage(make([]byte, 128), func(e error, _ int, _ websocket.MessageType) {
+		done, err = true, e
+	})
+	runUntil(t, ioc, func() bool { return done })
+
+	if !errors.Is(err, websocket.ErrKeepAliveTimeout) {
+		t.Fatalf("expected ErrKeepAliveTimeout got=%v", err)
+	}
+	if ws.State() != websocket.StateTerminated {
+		t.Fatalf("wrong state %s", ws.State())
+	}
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	s := startServer(t, serverConfig{invalidUTF8: true})
+	ioc, ws := newClient(t, s)
+	handshake(t, ioc, ws, s)
+
+	if err := ws.Write([]byte("hello"), websocket.TypeText); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ws.NextMessage(make([]byte, 128)); !errors.Is(err, websocket.ErrInvalidUTF8) {
+		t.Fatalf("expected ErrInvalidUTF8 got=%v", err)
+	}
+}
+
+// TestDrainOnClose closes the stream while writes are queued, and checks that
+// the queued messages are sent ahead of the close frame, and that their echoes
+// are read before the close reply.
+func TestDrainOnClose(t *testing.T) {
+	const messages = 20
+	s := startServer(t, serverConfig{})
+	ioc, ws := newClient(t, s)
+	handshake(t, ioc, ws, s)
+
+	var (
+		written, echoed int
+		closed, done    bool
+		failure         error
+		b               = make([]byte, 1024)
+		read            func()
+	)
+	for i := 0; i < messages; i++ {
+		ws.AsyncWrite([]byte(fmt.Sprintf("hello %d", i)), websocket.TypeText, func(err error) {
+			if err != nil {
+				failure = err
+			}
+			written++
+		})
+	}
+	ws.AsyncClose(websocket.CloseNormal, "bye", func(err error) {
+		if err != nil {
+			failure = err
+		}
+		closed = true
+	})
+	if ws.State() != websocket.StateClosedByUs {
+		t.Fatalf("wrong state %s", ws.State())
+	}
+
+	read = func() {
+		ws.AsyncNextMessage(b, func(err error, n int, _ websocket.MessageType) {
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					failure = err
+				}
+				done = true
+				return
+			}
+			if msg := fmt.Sprintf("hello %d", echoed); string(b[:n]) != msg {
+				failure = fmt.Errorf("expected %q got=%q", msg, b[:n])
+			}
+			echoed++
+			read()
+		})
+	}
+	read()
+
+	runUntil(t, ioc, func() bool { return done || failure != nil })
+	if failure != nil {
+		t.Fatal(failure)
+	}
+	if written != messages || !closed || echoed != messages {
+		t.Fatalf("written=%d closed=%v echoed=%d", written, closed, echoed)
+	}
+	if ws.State() != websocket.StateCloseAcked {
+		t.Fatalf("wrong state %s", ws.State())
+	}
+}
@@ -37,6 +37,9 @@ type Timer struct {
 	// This ensures that we do not schedule the timer again if the ScheduleRepeating
 	// callback cancelled the timer.
 	cancelled bool
+
+	// pending is the index of the timer in the scheduled Timers of the IO, plus one, or 0 if it is not scheduled.
+	pending int
 }
 
 func NewTimer(ioc *IO) (*Timer, error) {
@@ -65,13 +68,13 @@ func (t *Timer) ScheduleOnce(delay time.Duration, cb func()) (err error) {
 			cb()
 		} else {
 			err = t.it.Set(delay, func() {
-				delete(t.ioc.pendingTimers, t)
+				t.ioc.pending.timers.remove(t)
 				t.state = stateReady
 				cb()
 			})
 
 			if err == nil {
-				t.ioc.pendingTimers[t] = struct{}{}
+				t.ioc.pending.timers.add(t)
 				t.state = stateScheduled
 			}
 		}
@@ -81,25 +84,39 @@ func (t *Timer) ScheduleOnce(delay time.Duration, cb func()) (err error) {
 	return
 }
 
-// ScheduleRepeating schedules a callback for execution once per interval.
+// ScheduleRepeating schedules a callback for execution once per interval, until the timer is cancelled or closed.
 //
 // The callback is guaranteed to never be called before the repeat delay.
 // However, it is possible that it will be called a little after the
 // repeat delay.
 //
+// The ticks are scheduled at fixed points in time, start + k*repeat, rather than repeat after the previous callback
+// returned, so neither the time the callback takes nor the latency of the wake-ups accumulate: the ticks do not drift.
+// The ticks missed because the callback or the IO ran late by more than repeat are skipped, such that the callback is
+// not invoked in a burst to catch up.
+//
+// Calling Cancel, from the callback or otherwise, stops the future invocations.
+//
 // If the delay is negative or 0, the operation is cancelled.
 func (t *Timer) ScheduleRepeating(repeat time.Duration, cb func()) error {
 	if repeat <= 0 {
 		return sonicerrors.ErrCancelled
 	} else {
+		next := time.Now().Add(repeat)
+
 		var ccb func()
 		ccb = func() {
 			cb()
 			if t.cancelled {
 				t.cancelled = false
 			} else {
+				now := time.Now()
+				next = next.Add(repeat)
+				if late := now.Sub(next); late >= 0 {
+					next = next.Add((late/repeat + 1) * repeat)
+				}
 				// TODO this error should not be ignored
-				_ = t.ScheduleOnce(repeat, ccb)
+				_ = t.ScheduleOnce(next.Sub(now), ccb)
 			}
 		}
 
@@ -107,6 +124,33 @@ func (t *Timer) ScheduleRepeating(repeat time.Duration, cb func()) error {
 	}
 }
 
+// Wait is the synchronous counterpart of ScheduleOnce: it blocks the calling goroutine, which must be the one running
+// the IO, until the delay elapses. The IO is run in the meantime, so the handlers of other operations are executed
+// while waiting.
+//
+// sonicerrors.ErrReentrantWait is returned, without waiting, if Wait is called from a handler of the IO.
+func (t *Timer) Wait(delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	if t.ioc.Dispatching() {
+		return sonicerrors.ErrReentrantWait
+	}
+
+	fired := false
+	if err := t.ScheduleOnce(delay, func() { fired = true }); err != nil {
+		return err
+	}
+	if err := t.ioc.RunUntil(func() bool { return fired || t.state != stateScheduled }); err != nil {
+		_ = t.Cancel()
+		return err
+	}
+	if !fired {
+		return sonicerrors.ErrCancelled
+	}
+	return nil
+}
+
 func (t *Timer) Scheduled() bool {
 	return t.state == stateScheduled
 }
@@ -116,6 +160,7 @@ func (t *Timer) Cancel() error {
 	if err == nil {
 		t.cancelled = true
 		t.state = stateReady
+		t.ioc.pending.timers.remove(t)
 	}
 	return err
 }
@@ -129,7 +174,7 @@ func (t *Timer) Close() (err error) {
 		err = t.it.Close()
 		if err == nil {
 			t.state = stateClosed
-			delete(t.ioc.pendingTimers, t)
+			t.ioc.pending.timers.remove(t)
 		}
 	}
 	return
@@ -2,6 +2,8 @@ package sonic
 
 import (
 	"errors"
+	"fmt"
+	"syscall"
 	"testing"
 	"time"
 
@@ -692,7 +694,7 @@ func TestTimerScheduleRepeatingAndCancel(t *testing.T) {
 			if !timer.Scheduled() {
 				t.Fatal("timer should be scheduled")
 			}
-			if len(timer.ioc.pendingTimers) != 1 {
+			if timer.ioc.pending.timers.Len() != 1 {
 				t.Fatal("there should be a pending timer")
 			}
 			ioc.RunOne()
@@ -706,7 +708,7 @@ func TestTimerScheduleRepeatingAndCancel(t *testing.T) {
 		if timer.state != stateReady {
 			t.Fatal("timer should be in ready state")
 		}
-		if len(timer.ioc.pendingTimers) != 0 {
+		if timer.ioc.pending.timers.Len() != 0 {
 			t.Fatal("there should be no pending timers")
 		}
 	}
@@ -719,7 +721,7 @@ func TestTimerScheduleRepeatingAndCancel(t *testing.T) {
 		t.Fatal(err)
 	}
 	ioc.RunOne()
-	if len(timer.ioc.pendingTimers) != 0 {
+	if timer.ioc.pending.timers.Len() != 0 {
 		t.Fatal("there should be no pending timers")
 	}
 	if !once {
@@ -837,3 +839,266 @@ func BenchmarkTimerScheduleRepeating(b *testing.B) {
 	}
 	b.ReportAllocs()
 }
+
+func TestTimerMaxEventsPerPoll(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ioc.SetMaxTimersPerPoll(16)
+	if ioc.MaxTimersPerPoll() != 16 || ioc.MaxEventsPerPoll() != 16 {
+		t.Fatalf("expected 16 max timers per poll got=%d", ioc.MaxTimersPerPoll())
+	}
+
+	var timers []*Timer
+	for i := 0; i < 64; i++ {
+		timer, err := NewTimer(ioc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer timer.Close()
+		timers = append(timers, timer)
+	}
+
+	fired := 0
+	for _, timer := range timers {
+		if err := timer.ScheduleOnce(TimerTestDuration, func() { fired++ }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(2 * TimerTestDuration)
+
+	n, err := ioc.PollOne()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 16 || fired != 16 {
+		t.Fatalf("expected a single poll to dispatch 16 timers got n=%d fired=%d", n, fired)
+	}
+
+	for fired < len(timers) {
+		if _, err := ioc.PollOne(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTimerWait(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	timer, err := NewTimer(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer timer.Close()
+
+	other, err := NewTimer(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	// The handlers of other operations run while waiting.
+	otherFired := false
+	if err := other.ScheduleOnce(time.Millisecond, func() { otherFired = true }); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := timer.Wait(TimerTestDuration); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < TimerTestDuration {
+		t.Fatalf("expected Wait to block for %s, took %s", TimerTestDuration, elapsed)
+	}
+	if !otherFired {
+		t.Fatal("expected the other timer to fire while waiting")
+	}
+	if timer.Scheduled() {
+		t.Fatal("expected the timer to be ready after Wait")
+	}
+
+	// A handler cancelling the timer ends the wait.
+	if err := other.ScheduleOnce(time.Millisecond, func() { _ = timer.Cancel() }); err != nil {
+		t.Fatal(err)
+	}
+	if err := timer.Wait(time.Hour); err != sonicerrors.ErrCancelled {
+		t.Fatalf("expected ErrCancelled got=%v", err)
+	}
+}
+
+func TestTimerWaitReentrant(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	timer, err := NewTimer(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer timer.Close()
+
+	other, err := NewTimer(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	// Waiting from a handler must fail instead of running the IO while it dispatches the handler.
+	var waitErr error
+	if err := other.ScheduleOnce(time.Millisecond, func() {
+		waitErr = timer.Wait(time.Millisecond)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioc.RunUntil(func() bool { return !other.Scheduled() }); err != nil {
+		t.Fatal(err)
+	}
+	if waitErr != sonicerrors.ErrReentrantWait {
+		t.Fatalf("expected ErrReentrantWait got=%v", waitErr)
+	}
+	if timer.Scheduled() {
+		t.Fatal("expected the timer not to be scheduled by the failed wait")
+	}
+
+	// Waiting outside the handlers works as before.
+	if err := timer.Wait(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkTimerSynchronizedExpirations(b *testing.B) {
+	const numTimers = 100_000
+
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+		b.Fatal(err)
+	}
+	if rlimit.Cur < numTimers+64 {
+		rlimit.Cur = rlimit.Max
+		_ = syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rlimit)
+	}
+	if rlimit.Cur < numTimers+64 {
+		b.Skipf("RLIMIT_NOFILE=%d is too low for %d timers", rlimit.Cur, numTimers)
+	}
+
+	for _, maxEvents := range []int{128, 1024, 16384} {
+		b.Run(fmt.Sprintf("max_events_per_poll=%d", maxEvents), func(b *testing.B) {
+			ioc := MustIO()
+			defer ioc.Close()
+
+			ioc.SetMaxEventsPerPoll(maxEvents)
+
+			timers := make([]*Timer, numTimers)
+			for i := range timers {
+				timer, err := NewTimer(ioc)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer timer.Close()
+				timers[i] = timer
+			}
+
+			polls := 0
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				fired := 0
+				for _, timer := range timers {
+					_ = timer.ScheduleOnce(time.Millisecond, func() { fired++ })
+				}
+				for fired < numTimers {
+					_ = ioc.RunOneFor(time.Millisecond)
+					polls++
+				}
+			}
+			b.ReportMetric(float64(polls)/float64(b.N), "polls/op")
+		})
+	}
+}
+
+func TestTimerScheduleRepeatingNoDrift(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	timer, err := NewTimer(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer timer.Close()
+
+	const (
+		interval = 10 * time.Millisecond
+		ticks    = 20
+	)
+
+	// Each callback takes 4ms, which would delay every tick by as much if the timer was re-armed after it.
+	start := time.Now()
+	var last time.Duration
+	fired := 0
+	err = timer.ScheduleRepeating(interval, func() {
+		fired++
+		last = time.Since(start)
+		time.Sleep(4 * time.Millisecond)
+		if fired == ticks {
+			_ = timer.Cancel()
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for fired < ticks && time.Since(start) < 5*time.Second {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if fired != ticks {
+		t.Fatalf("expected %d ticks got=%d", ticks, fired)
+	}
+	if last < ticks*interval || last > ticks*interval+3*interval {
+		t.Fatalf("tick %d fired after %s, expected about %s", ticks, last, ticks*interval)
+	}
+	if timer.Scheduled() {
+		t.Fatal("timer should not be scheduled once cancelled")
+	}
+}
+
+func TestTimerScheduleRepeatingSkipsMissedTicks(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	timer, err := NewTimer(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer timer.Close()
+
+	const interval = 10 * time.Millisecond
+
+	start := time.Now()
+	var at []time.Duration
+	err = timer.ScheduleRepeating(interval, func() {
+		at = append(at, time.Since(start))
+		if len(at) == 1 {
+			// Miss the next two ticks.
+			time.Sleep(25 * time.Millisecond)
+		}
+		if len(at) == 3 {
+			_ = timer.Cancel()
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for len(at) < 3 && time.Since(start) < 5*time.Second {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if len(at) != 3 {
+		t.Fatalf("expected 3 ticks got=%v", at)
+	}
+
+	// The first tick returns at about 35ms, so the ticks of 20ms and 30ms are skipped and the next one fires at 40ms,
+	// not right away.
+	if at[1] < 4*interval || at[2] < 5*interval {
+		t.Fatalf("expected the missed ticks to be skipped got=%v", at)
+	}
+}
@@ -0,0 +1,285 @@
+package sonic
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultTimeoutResolution is the tick of the timing wheel of an IO unless set with SetTimeoutResolution.
+	DefaultTimeoutResolution = 10 * time.Millisecond
+
+	wheelBits   = 6
+	wheelSlots  = 1 << wheelBits
+	wheelMask   = wheelSlots - 1
+	wheelLevels = 4
+)
+
+// Timeout is a callback scheduled on the timing wheel of an IO with ScheduleAfter or ScheduleAt. It must only be used
+// from the goroutine running the IO.
+type Timeout struct {
+	w      *timerWheel
+	expiry int64 // the tick at which the callback is invoked
+	cb     func()
+
+	// The Timeouts of a slot of the wheel are a doubly linked list. slot is nil if the Timeout is not pending.
+	slot       *timeoutList
+	prev, next *Timeout
+}
+
+// Pending returns true if the callback is scheduled and was not invoked yet.
+func (t *Timeout) Pending() bool {
+	return t.slot != nil
+}
+
+// Cancel cancels the callback. It returns true if the callback was pending, false if it was already invoked or
+// cancelled.
+func (t *Timeout) Cancel() bool {
+	if t.slot == nil {
+		return false
+	}
+	t.slot.remove(t)
+	t.w.n--
+	return true
+}
+
+// Reset cancels the callback, if it is pending, and schedules it again after d. Resetting is the cheap way to push
+// back an idle timeout whenever its connection makes progress, as it allocates nothing.
+func (t *Timeout) Reset(d time.Duration) error {
+	t.Cancel()
+	return t.w.schedule(t, time.Now().Add(d))
+}
+
+type timeoutList struct {
+	head, tail *Timeout
+}
+
+func (l *timeoutList) push(t *Timeout) {
+	t.slot = l
+	t.prev, t.next = l.tail, nil
+	if l.tail != nil {
+		l.tail.next = t
+	} else {
+		l.head = t
+	}
+	l.tail = t
+}
+
+func (l *timeoutList) remove(t *Timeout) {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		l.head = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	} else {
+		l.tail = t.prev
+	}
+	t.slot, t.prev, t.next = nil, nil, nil
+}
+
+// timerWheel is a hierarchical timing wheel multiplexing the Timeouts of an IO onto a single Timer.
+//
+// Time is divided in ticks of the resolution of the wheel. Level 0 has a slot per tick for the next wheelSlots ticks,
+// and each slot of level l covers wheelSlots^l ticks. A Timeout is put in the level of its distance to the current tick
+// and cascades down a level whenever the slots of the level below wrap around, until it expires in a slot of level 0.
+// Timeouts beyond the span of the wheel wait in its top level, and are placed again once they cascade.
+//
+// Adding and cancelling a Timeout is O(1). The Timer only fires at the ticks whose slot holds a Timeout, and at the
+// ticks at which the wheel cascades, so idle timeouts cost a wake-up every wheelSlots ticks at most.
+type timerWheel struct {
+	ioc        *IO
+	timer      *Timer
+	resolution time.Duration
+	start      time.Time
+
+	current   int64 // the last tick processed
+	wakeAt    int64 // the tick the Timer is scheduled for, or 0 if it is not
+	n         int   // the number of pending Timeouts
+	advancing bool  // the wheel is invoking the callbacks of the expired Timeouts, after which it schedules the Timer
+
+	levels [wheelLevels][wheelSlots]timeoutList
+}
+
+// SetTimeoutResolution sets the tick of the timing wheel of the IO, see ScheduleAfter. A Timeout fires at the first
+// tick after its deadline, so a coarser resolution means fewer wake-ups and less precise timeouts. The resolution
+// must be set before the first Timeout is scheduled.
+func (ioc *IO) SetTimeoutResolution(resolution time.Duration) error {
+	if resolution <= 0 {
+		return fmt.Errorf("the timeout resolution must be positive")
+	}
+	if ioc.wheel != nil {
+		return fmt.Errorf("the timeout resolution must be set before the first timeout is scheduled")
+	}
+	ioc.timeoutResolution = resolution
+	return nil
+}
+
+// ScheduleAfter invokes cb on the goroutine running the IO once d elapsed, and never before.
+//
+// Unlike a Timer, which holds a kernel timer of its own, the Timeouts of an IO are kept in a hierarchical timing
+// wheel driven by a single kernel timer. Adding, resetting and cancelling a Timeout is O(1), which makes it fit for
+// coarse timeouts of which there are many, such as the idle timeouts of 100k connections. The timeouts fire with the
+// resolution of the wheel, DefaultTimeoutResolution unless set with SetTimeoutResolution. A Timer is the better fit
+// for the few timers which must be precise.
+func (ioc *IO) ScheduleAfter(d time.Duration, cb func()) (*Timeout, error) {
+	return ioc.ScheduleAt(time.Now().Add(d), cb)
+}
+
+// ScheduleAt invokes cb on the goroutine running the IO at deadline, and never before. See ScheduleAfter.
+func (ioc *IO) ScheduleAt(deadline time.Time, cb func()) (*Timeout, error) {
+	if ioc.wheel == nil {
+		timer, err := NewTimer(ioc)
+		if err != nil {
+			return nil, err
+		}
+		resolution := ioc.timeoutResolution
+		if resolution <= 0 {
+			resolution = DefaultTimeoutResolution
+		}
+		ioc.wheel = &timerWheel{
+			ioc:        ioc,
+			timer:      timer,
+			resolution: resolution,
+			start:      time.Now(),
+		}
+	}
+
+	t := &Timeout{w: ioc.wheel, cb: cb}
+	if err := ioc.wheel.schedule(t, deadline); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Timeouts returns the number of pending Timeouts of the IO.
+func (ioc *IO) Timeouts() int {
+	if ioc.wheel == nil {
+		return 0
+	}
+	return ioc.wheel.n
+}
+
+func (w *timerWheel) schedule(t *Timeout, deadline time.Time) error {
+	// The deadline is rounded up to a tick such that the Timeout never fires early. The Timeouts of the current tick
+	// and of the ones before, which the wheel processed already, fire at the next tick.
+	d := deadline.Sub(w.start)
+	expiry := int64((d + w.resolution - 1) / w.resolution)
+	if expiry <= w.current {
+		expiry = w.current + 1
+	}
+	t.expiry = expiry
+	w.place(t)
+	w.n++
+
+	if !w.advancing && (w.wakeAt == 0 || expiry < w.wakeAt) {
+		return w.arm(expiry)
+	}
+	return nil
+}
+
+// place puts t in the slot of its expiry.
+func (w *timerWheel) place(t *Timeout) {
+	delta := t.expiry - w.current
+	if delta <= 0 {
+		// The Timeout expires in the tick being processed.
+		w.levels[0][w.current&wheelMask].push(t)
+		return
+	}
+
+	expiry := t.expiry
+	for level := 0; level < wheelLevels; level++ {
+		if delta < int64(1)<<((level+1)*wheelBits) {
+			w.levels[level][(expiry>>(level*wheelBits))&wheelMask].push(t)
+			return
+		}
+	}
+
+	// Beyond the span of the wheel: wait in the slot of the top level processed last, from which t is placed again.
+	level := wheelLevels - 1
+	expiry = w.current + int64(1)<<(wheelLevels*wheelBits) - 1
+	w.levels[level][(expiry>>(level*wheelBits))&wheelMask].push(t)
+}
+
+// arm schedules the Timer for tick, replacing a later wake-up.
+func (w *timerWheel) arm(tick int64) error {
+	if w.wakeAt != 0 {
+		if err := w.timer.Cancel(); err != nil {
+			return err
+		}
+	}
+	w.wakeAt = tick
+
+	delay := w.start.Add(time.Duration(tick) * w.resolution).Sub(time.Now())
+	if delay <= 0 {
+		// The Timer invokes the callback right away otherwise, which would run the Timeouts in the middle of the
+		// operation scheduling one.
+		delay = time.Nanosecond
+	}
+	return w.timer.ScheduleOnce(delay, w.advance)
+}
+
+// advance processes the ticks elapsed since the last call, and schedules the next wake-up.
+func (w *timerWheel) advance() {
+	w.wakeAt = 0
+	w.advancing = true
+	defer func() { w.advancing = false }()
+
+	now := int64(time.Since(w.start) / w.resolution)
+	for w.current < now {
+		if w.n == 0 {
+			w.current = now
+			break
+		}
+		w.step()
+	}
+
+	if w.n > 0 {
+		_ = w.arm(w.next())
+	}
+}
+
+// step processes the next tick: it cascades the upper levels, if they wrap around, and invokes the callbacks of the
+// Timeouts expiring in it.
+func (w *timerWheel) step() {
+	w.current++
+	w.cascade()
+
+	slot := &w.levels[0][w.current&wheelMask]
+	for slot.head != nil {
+		t := slot.head
+		slot.remove(t)
+		w.n--
+		t.cb()
+	}
+}
+
+// cascade moves the Timeouts of the upper levels whose slots are now covered by level 0 down the wheel.
+func (w *timerWheel) cascade() {
+	for level := 1; level < wheelLevels; level++ {
+		if (w.current>>((level-1)*wheelBits))&wheelMask != 0 {
+			return
+		}
+		slot := &w.levels[level][(w.current>>(level*wheelBits))&wheelMask]
+		for slot.head != nil {
+			t := slot.head
+			slot.remove(t)
+			w.place(t)
+		}
+	}
+}
+
+// next returns the next tick at which the wheel has something to do: the first non-empty slot of level 0, or the next
+// cascade.
+func (w *timerWheel) next() int64 {
+	for tick := w.current + 1; ; tick++ {
+		if tick&wheelMask == 0 || w.levels[0][tick&wheelMask].head != nil {
+			return tick
+		}
+	}
+}
+
+func (w *timerWheel) close() {
+	_ = w.timer.Close()
+}
@@ -0,0 +1,187 @@
+package sonic
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestTimerWheelStep(t *testing.T) {
+	w := &timerWheel{resolution: time.Millisecond}
+
+	expiries := []int64{1, 2, 63, 64, 65, 100, 4095, 4096, 4097, 70000, 1 << 18, 1<<24 - 1, 1 << 24, 1<<24 + 100}
+	rng := rand.New(rand.NewSource(1)) //#nosec G404 -- test data
+	for i := 0; i < 1000; i++ {
+		expiries = append(expiries, 1+rng.Int63n(1<<20))
+	}
+
+	fired := make(map[*Timeout]int64)
+	var last int64
+	for _, expiry := range expiries {
+		to := &Timeout{w: w, expiry: expiry}
+		to.cb = func() { fired[to] = w.current }
+		w.place(to)
+		w.n++
+		if expiry > last {
+			last = expiry
+		}
+	}
+
+	for w.current < last {
+		w.step()
+	}
+
+	if w.n != 0 || len(fired) != len(expiries) {
+		t.Fatalf("expected all the timeouts to fire pending=%d fired=%d", w.n, len(fired))
+	}
+	for to, at := range fired {
+		if at != to.expiry {
+			t.Fatalf("timeout expiring at tick %d fired at tick %d", to.expiry, at)
+		}
+	}
+}
+
+func TestTimerWheelCancelDuringStep(t *testing.T) {
+	w := &timerWheel{resolution: time.Millisecond}
+
+	var a, b *Timeout
+	fired := 0
+	a = &Timeout{w: w, expiry: 10, cb: func() {
+		fired++
+		if !b.Cancel() {
+			t.Fatal("expected b to be pending")
+		}
+	}}
+	b = &Timeout{w: w, expiry: 10, cb: func() { fired++ }}
+	for _, to := range []*Timeout{a, b} {
+		w.place(to)
+		w.n++
+	}
+
+	for w.current < 20 {
+		w.step()
+	}
+	if fired != 1 || w.n != 0 || b.Pending() {
+		t.Fatalf("expected only a to fire fired=%d pending=%d", fired, w.n)
+	}
+}
+
+func TestIOScheduleAfter(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	if err := ioc.SetTimeoutResolution(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	delays := []time.Duration{30 * time.Millisecond, 5 * time.Millisecond, 80 * time.Millisecond, time.Millisecond}
+	var order []time.Duration
+	for _, d := range delays {
+		d := d
+		_, err := ioc.ScheduleAfter(d, func() {
+			if elapsed := time.Since(start); elapsed < d {
+				t.Fatalf("timeout of %s fired early after %s", d, elapsed)
+			}
+			order = append(order, d)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioc.SetTimeoutResolution(time.Second); err == nil {
+		t.Fatal("expected the resolution to be fixed once a timeout is scheduled")
+	}
+
+	cancelled, err := ioc.ScheduleAfter(10*time.Millisecond, func() { t.Fatal("cancelled timeout fired") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cancelled.Cancel() || cancelled.Cancel() {
+		t.Fatal("expected the first cancel to succeed and the second to fail")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ioc.Timeouts() > 0 && time.Now().Before(deadline) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+
+	expected := []time.Duration{time.Millisecond, 5 * time.Millisecond, 30 * time.Millisecond, 80 * time.Millisecond}
+	if len(order) != len(expected) {
+		t.Fatalf("expected %d timeouts to fire got=%v", len(expected), order)
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("wrong order got=%v", order)
+		}
+	}
+}
+
+func TestIOScheduleAfterReset(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	if err := ioc.SetTimeoutResolution(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	var fired time.Time
+	idle, err := ioc.ScheduleAfter(20*time.Millisecond, func() { fired = time.Now() })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Push the idle timeout back a few times, as the reads of a connection would.
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_ = ioc.RunOneFor(10 * time.Millisecond)
+		if err := idle.Reset(20 * time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reset := time.Now()
+
+	for idle.Pending() && time.Since(start) < 5*time.Second {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if fired.IsZero() || fired.Sub(reset) < 20*time.Millisecond {
+		t.Fatalf("expected the timeout to fire 20ms after the last reset, fired %s after", fired.Sub(reset))
+	}
+}
+
+func TestIOScheduleAfterMany(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	if err := ioc.SetTimeoutResolution(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 100000
+	rng := rand.New(rand.NewSource(1)) //#nosec G404 -- test data
+	start := time.Now()
+	fired, early := 0, 0
+	for i := 0; i < n; i++ {
+		d := time.Duration(rng.Int63n(int64(200 * time.Millisecond)))
+		_, err := ioc.ScheduleAfter(d, func() {
+			fired++
+			if time.Since(start) < d {
+				early++
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ioc.Timeouts() != n {
+		t.Fatalf("expected %d pending timeouts got=%d", n, ioc.Timeouts())
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for ioc.Timeouts() > 0 && time.Now().Before(deadline) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if fired != n || early != 0 {
+		t.Fatalf("expected %d timeouts to fire on time fired=%d early=%d", n, fired, early)
+	}
+}
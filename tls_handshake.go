@@ -0,0 +1,268 @@
+package sonic
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+var _ net.Conn = &tlsTransport{}
+
+// TLSHandshakePool performs the handshakes of TLS server connections without running their crypto on the IO
+// goroutine, such that a burst of handshakes, whose key exchanges and signatures are expensive, does not stall the
+// other connections of the IO.
+//
+// Each handshake runs on a goroutine of its own, but only a bounded number of them compute at once: a handshake holds
+// one of the workers of the pool while it computes, and gives it back while it waits for the peer. All the reads and
+// writes of the connection are still performed on the IO goroutine. The completion of a handshake is posted back to
+// the IO, where the TLSStream is handed to the caller.
+//
+// A TLSHandshakePool must only be used from the goroutine running the IO.
+type TLSHandshakePool struct {
+	ioc *IO
+	cfg *tls.Config
+
+	workers    chan struct{} // holds a token per computing handshake
+	maxPending int
+	pending    int
+	timeout    time.Duration
+}
+
+// NewTLSHandshakePool creates a TLSHandshakePool serving the handshakes of the connections of ioc with cfg. At most
+// workers handshakes compute at once, one per CPU if workers <= 0, and at most maxPending handshakes are in progress,
+// including the ones waiting for a worker or for the peer. Further handshakes fail with
+// sonicerrors.ErrTooManyHandshakes. maxPending <= 0 means no bound.
+func NewTLSHandshakePool(ioc *IO, cfg *tls.Config, workers, maxPending int) *TLSHandshakePool {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &TLSHandshakePool{
+		ioc:        ioc,
+		cfg:        cfg,
+		workers:    make(chan struct{}, workers),
+		maxPending: maxPending,
+	}
+}
+
+// SetHandshakeTimeout bounds the time a handshake can take, after which it fails with context.DeadlineExceeded. 0,
+// the default, means no bound.
+func (p *TLSHandshakePool) SetHandshakeTimeout(timeout time.Duration) {
+	p.timeout = timeout
+}
+
+// Pending returns the number of handshakes in progress.
+func (p *TLSHandshakePool) Pending() int {
+	return p.pending
+}
+
+// AsyncHandshake performs the server side of the TLS handshake of conn and invokes cb with the resulting TLSStream,
+// which then owns conn. conn is closed if the handshake fails. conn must not be used until cb is invoked.
+func (p *TLSHandshakePool) AsyncHandshake(conn Conn, cb func(err error, tc *TLSStream)) {
+	if p.maxPending > 0 && p.pending >= p.maxPending {
+		_ = conn.Close()
+		cb(sonicerrors.ErrTooManyHandshakes, nil)
+		return
+	}
+	p.pending++
+
+	t := newTLSTransport(p.ioc, conn, p.workers)
+	asyncTLSHandshake(t, tls.Server(t, p.cfg), p.timeout, func(err error, s *TLSStream) {
+		p.pending--
+		cb(err, s)
+	})
+}
+
+// Handler returns a ConnHandler, such as the handler of an Acceptor or the TLS handler of a TLSSniffer, which
+// performs the handshake of each connection on the pool and hands the resulting TLSStream to handler. Failed
+// handshakes are reported to onError, if not nil.
+func (p *TLSHandshakePool) Handler(handler func(tc *TLSStream), onError func(err error)) ConnHandler {
+	return func(conn Conn) {
+		p.AsyncHandshake(conn, func(err error, tc *TLSStream) {
+			if err != nil {
+				if onError != nil {
+					onError(err)
+				}
+				return
+			}
+			handler(tc)
+		})
+	}
+}
+
+// errTLSWouldBlock is returned to the tls.Conn of a TLSStream when the transport has no more bytes to read, in which
+// case the tls.Conn keeps the partial record it has read and the next read is retried once more bytes are read from
+// the connection.
+var errTLSWouldBlock net.Error = tlsWouldBlock{}
+
+type tlsWouldBlock struct{}
+
+func (tlsWouldBlock) Error() string   { return sonicerrors.ErrWouldBlock.Error() }
+func (tlsWouldBlock) Timeout() bool   { return true }
+func (tlsWouldBlock) Temporary() bool { return true }
+
+// tlsTransport is the net.Conn of the tls.Conn of a TLSStream.
+//
+// During the handshake, it is used by the goroutine of the handshake, which blocks while the IO goroutine reads from
+// or writes to the connection. It then gives back the worker it holds, and takes one again before returning.
+//
+// Once the handshake is done, it is used by the IO goroutine only and never blocks: reads are served from the bytes
+// read by the TLSStream, and writes are buffered until the TLSStream flushes them.
+type tlsTransport struct {
+	conn Conn
+	ioc  *IO
+
+	workers chan struct{}
+	working bool
+	done    chan struct{}
+	once    sync.Once
+
+	async bool
+	rbuf  []byte
+	in    []byte // the bytes read and not yet consumed
+	rerr  error  // the error of the last read, once in is consumed
+	out   []byte // the bytes written and not yet flushed
+}
+
+func newTLSTransport(ioc *IO, conn Conn, workers chan struct{}) *tlsTransport {
+	return &tlsTransport{
+		conn:    conn,
+		ioc:     ioc,
+		workers: workers,
+		done:    make(chan struct{}),
+		rbuf:    make([]byte, 16*1024),
+	}
+}
+
+type tlsTransportResult struct {
+	n   int
+	err error
+}
+
+func (t *tlsTransport) Read(b []byte) (int, error) {
+	if len(t.in) > 0 {
+		n := copy(b, t.in)
+		t.in = t.in[n:]
+		return n, nil
+	}
+
+	if t.async {
+		if t.rerr != nil {
+			return 0, t.rerr
+		}
+		return 0, errTLSWouldBlock
+	}
+
+	res := make(chan tlsTransportResult, 1)
+	err := t.ioc.Post(func() {
+		t.conn.AsyncRead(t.rbuf, func(err error, n int) {
+			res <- tlsTransportResult{n, err}
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	r, err := t.wait(res)
+	if err != nil {
+		return 0, err
+	}
+
+	// The bytes which do not fit in b are kept for the next reads, which is where the records following the
+	// handshake are read from once the TLSStream takes over.
+	n := copy(b, t.rbuf[:r.n])
+	t.in = t.rbuf[n:r.n]
+	return n, r.err
+}
+
+func (t *tlsTransport) Write(b []byte) (int, error) {
+	if t.async {
+		t.out = append(t.out, b...)
+		return len(b), nil
+	}
+
+	b = append([]byte(nil), b...)
+	res := make(chan tlsTransportResult, 1)
+	err := t.ioc.Post(func() {
+		t.conn.AsyncWriteAll(b, func(err error, n int) {
+			res <- tlsTransportResult{n, err}
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	r, err := t.wait(res)
+	if err != nil {
+		return 0, err
+	}
+	return r.n, r.err
+}
+
+// wait gives back the worker held by the handshake until the IO goroutine completes the operation, and then takes a
+// worker again. It fails if the handshake is aborted in the meantime.
+func (t *tlsTransport) wait(res chan tlsTransportResult) (tlsTransportResult, error) {
+	t.release()
+
+	var r tlsTransportResult
+	select {
+	case r = <-res:
+	case <-t.done:
+		return r, net.ErrClosed
+	}
+
+	select {
+	case t.workers <- struct{}{}:
+		t.working = true
+		return r, nil
+	case <-t.done:
+		return r, net.ErrClosed
+	}
+}
+
+func (t *tlsTransport) release() {
+	if t.working {
+		t.working = false
+		<-t.workers
+	}
+}
+
+func (t *tlsTransport) takeOut() []byte {
+	out := t.out
+	t.out = nil
+	return out
+}
+
+// Close aborts the handshake, if it is in progress, and closes the connection otherwise.
+func (t *tlsTransport) Close() error {
+	if !t.async {
+		t.once.Do(func() { close(t.done) })
+		return nil
+	}
+
+	// The close_notify alert is sent only if it can be sent right away.
+	if out := t.takeOut(); len(out) > 0 {
+		_, _ = t.conn.Write(out)
+	}
+	err := t.conn.Close()
+	if err == io.EOF {
+		err = net.ErrClosed
+	}
+	return err
+}
+
+func (t *tlsTransport) LocalAddr() net.Addr {
+	return t.conn.LocalAddr()
+}
+
+func (t *tlsTransport) RemoteAddr() net.Addr {
+	return t.conn.RemoteAddr()
+}
+
+// The deadlines are not supported, see TLSHandshakePool.SetHandshakeTimeout.
+
+func (t *tlsTransport) SetDeadline(time.Time) error      { return nil }
+func (t *tlsTransport) SetReadDeadline(time.Time) error  { return nil }
+func (t *tlsTransport) SetWriteDeadline(time.Time) error { return nil }
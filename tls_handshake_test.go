@@ -0,0 +1,145 @@
+package sonic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+	"github.com/csdenboer/sonic/sonicopts"
+)
+
+func TestTLSHandshakePool(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := Listen(ioc, "tcp", "localhost:9994", sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	pool := NewTLSHandshakePool(ioc, &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t)}}, 1, 0)
+
+	echo := func(tc *TLSStream) {
+		if !tc.ConnectionState().HandshakeComplete {
+			t.Fatal("expected a complete handshake")
+		}
+		b := make([]byte, 128)
+		var next func()
+		next = func() {
+			tc.AsyncRead(b, func(err error, n int) {
+				if err != nil {
+					_ = tc.Close()
+					return
+				}
+				tc.AsyncWriteAll(b[:n], func(err error, _ int) {
+					if err == nil {
+						next()
+					}
+				})
+			})
+		}
+		next()
+	}
+	NewAcceptor(ln, pool.Handler(echo, func(err error) { t.Fatal(err) }), nil).Start()
+
+	const clients = 4
+	results := make(chan error, clients)
+	for i := 0; i < clients; i++ {
+		go func(i int) {
+			conn, err := tls.Dial("tcp", "localhost:9994", &tls.Config{InsecureSkipVerify: true}) //#nosec G402
+			if err != nil {
+				results <- err
+				return
+			}
+			defer conn.Close()
+			_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			for j := 0; j < 3; j++ {
+				msg := fmt.Sprintf("hello %d/%d", i, j)
+				if _, err := conn.Write([]byte(msg)); err != nil {
+					results <- err
+					return
+				}
+				b := make([]byte, 128)
+				n, err := conn.Read(b)
+				if err != nil {
+					results <- err
+					return
+				}
+				if string(b[:n]) != msg {
+					results <- fmt.Errorf("expected %q got=%q", msg, b[:n])
+					return
+				}
+			}
+			results <- nil
+		}(i)
+	}
+
+	for done := 0; done < clients; {
+		select {
+		case err := <-results:
+			if err != nil {
+				t.Fatal(err)
+			}
+			done++
+		default:
+			_ = ioc.RunOneFor(time.Millisecond)
+		}
+	}
+	if pool.Pending() != 0 {
+		t.Fatalf("expected no pending handshake got=%d", pool.Pending())
+	}
+}
+
+func TestTLSHandshakePoolBounds(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := Listen(ioc, "tcp", "localhost:9994", sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	pool := NewTLSHandshakePool(ioc, &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t)}}, 1, 1)
+	pool.SetHandshakeTimeout(50 * time.Millisecond)
+
+	var errs []error
+	NewAcceptor(ln, pool.Handler(func(tc *TLSStream) {
+		t.Fatal("no handshake can succeed")
+	}, func(err error) {
+		errs = append(errs, err)
+	}), nil).Start()
+
+	// Both clients stay silent: the first one holds the only pending handshake until it times out, and the second
+	// one is rejected.
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", "localhost:9994")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); len(errs) < 2 && time.Now().Before(deadline); {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 failed handshakes got=%v", errs)
+	}
+	if !errors.Is(errs[0], sonicerrors.ErrTooManyHandshakes) {
+		t.Fatalf("expected ErrTooManyHandshakes got=%v", errs[0])
+	}
+	if !errors.Is(errs[1], context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded got=%v", errs[1])
+	}
+	if pool.Pending() != 0 {
+		t.Fatalf("expected no pending handshake got=%d", pool.Pending())
+	}
+}
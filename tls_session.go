@@ -0,0 +1,25 @@
+package sonic
+
+import "crypto/tls"
+
+// DefaultTLSSessionCacheCapacity is the number of TLS sessions kept by the cache returned by IO.TLSSessionCache
+// unless another cache is set with SetTLSSessionCache.
+const DefaultTLSSessionCacheCapacity = 64
+
+// TLSSessionCache returns the TLS client session cache shared by the connections of the IO, creating an in-memory
+// LRU cache of DefaultTLSSessionCacheCapacity sessions on first use.
+//
+// Setting it as the ClientSessionCache of a tls.Config makes a reconnect to a server resume the previous session with
+// an abbreviated handshake, which saves a round-trip and the key exchange when failing over a dropped feed.
+func (ioc *IO) TLSSessionCache() tls.ClientSessionCache {
+	if ioc.tlsSessions == nil {
+		ioc.tlsSessions = tls.NewLRUClientSessionCache(DefaultTLSSessionCacheCapacity)
+	}
+	return ioc.tlsSessions
+}
+
+// SetTLSSessionCache replaces the TLS client session cache of the IO, for example with one persisting the sessions
+// such that they survive a restart of the process. A nil cache resets it to the default in-memory cache.
+func (ioc *IO) SetTLSSessionCache(cache tls.ClientSessionCache) {
+	ioc.tlsSessions = cache
+}
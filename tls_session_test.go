@@ -0,0 +1,96 @@
+package sonic
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestIOTLSSessionCacheResumes(t *testing.T) {
+	ln, err := tls.Listen("tcp", "localhost:0", &tls.Config{
+		Certificates: []tls.Certificate{selfSignedCert(t)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// The byte makes the client process the session ticket sent after the handshake.
+			_, _ = conn.Write([]byte{1})
+			conn.Close()
+		}
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	cfg := &tls.Config{
+		InsecureSkipVerify: true, //#nosec G402
+		ClientSessionCache: ioc.TLSSessionCache(),
+	}
+
+	dial := func() bool {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		if _, err := conn.Read(make([]byte, 1)); err != nil {
+			t.Fatal(err)
+		}
+		return conn.ConnectionState().DidResume
+	}
+
+	if dial() {
+		t.Fatal("the first connection must not resume a session")
+	}
+	if !dial() {
+		t.Fatal("expected the second connection to resume the session")
+	}
+}
+
+func TestIOSetTLSSessionCache(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	cache := tls.NewLRUClientSessionCache(1)
+	ioc.SetTLSSessionCache(cache)
+	if ioc.TLSSessionCache() != cache {
+		t.Fatal("expected the cache set with SetTLSSessionCache")
+	}
+
+	ioc.SetTLSSessionCache(nil)
+	if ioc.TLSSessionCache() == nil || ioc.TLSSessionCache() == cache {
+		t.Fatal("expected the default cache")
+	}
+}
@@ -0,0 +1,257 @@
+package sonic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"time"
+
+	"github.com/csdenboer/sonic/sonicerrors"
+)
+
+var _ Stream = &TLSStream{}
+
+// TLSConn is the former name of TLSStream.
+type TLSConn = TLSStream
+
+// AsyncTLSClient performs the client side of the TLS handshake of conn with cfg and invokes cb with the resulting
+// TLSStream, which then owns conn. conn is closed if the handshake fails. conn must not be used until cb is invoked.
+//
+// cfg must set ServerName, unless InsecureSkipVerify is set. Setting IO.TLSSessionCache as its ClientSessionCache
+// resumes the sessions of the previous connections to the same server.
+//
+// The crypto of the handshake runs on a goroutine which lasts until the handshake completes, while its reads and
+// writes are performed on the IO goroutine. The handshake fails with context.DeadlineExceeded after timeout, if it is
+// not 0.
+func AsyncTLSClient(ioc *IO, conn Conn, cfg *tls.Config, timeout time.Duration, cb func(err error, s *TLSStream)) {
+	t := newTLSTransport(ioc, conn, make(chan struct{}, 1))
+	asyncTLSHandshake(t, tls.Client(t, cfg), timeout, cb)
+}
+
+// AsyncTLSServer performs the server side of the TLS handshake of conn with cfg, see AsyncTLSClient. A
+// TLSHandshakePool bounds the number of server handshakes computing at once, which AsyncTLSServer does not.
+func AsyncTLSServer(ioc *IO, conn Conn, cfg *tls.Config, timeout time.Duration, cb func(err error, s *TLSStream)) {
+	t := newTLSTransport(ioc, conn, make(chan struct{}, 1))
+	asyncTLSHandshake(t, tls.Server(t, cfg), timeout, cb)
+}
+
+// asyncTLSHandshake performs the handshake of tc, created over t, on a goroutine of its own, and posts its completion
+// back to the IO of t. If the IO is closed by then, the completion cannot be posted: the connection is closed and cb is
+// invoked right away, from the handshake goroutine, with sonicerrors.ErrClosed.
+func asyncTLSHandshake(t *tlsTransport, tc *tls.Conn, timeout time.Duration, cb func(err error, s *TLSStream)) {
+	go func() {
+		ctx := context.Background()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		err := tc.HandshakeContext(ctx)
+		t.release()
+
+		postErr := postOnce(t.ioc, func() {
+			if err != nil {
+				_ = t.conn.Close()
+				cb(err, nil)
+				return
+			}
+			t.async = true
+			cb(nil, &TLSStream{tls: tc, t: t})
+		})
+		if errors.Is(postErr, sonicerrors.ErrClosed) {
+			_ = t.conn.Close()
+			cb(postErr, nil)
+		}
+	}()
+}
+
+// TLSStream is a TLS connection over a Conn of an IO. Its handshake is performed by AsyncTLSClient, AsyncTLSServer
+// or a TLSHandshakePool. It then encrypts and decrypts the records on the IO goroutine, through the asynchronous reads
+// and writes of the Conn, and must only be used from there. It is a Stream, so the codecs of sonic run over it like
+// over a plain Conn.
+type TLSStream struct {
+	tls *tls.Conn
+	t   *tlsTransport
+
+	// Bounds the records decrypted from the bytes already read without yielding, such that a burst of records from
+	// one connection cannot stall the handlers of other connections. 0 means DefaultMaxBufferedReadsPerRun.
+	maxBufferedReads   int
+	bufferedReadsInRun int
+}
+
+// NetConn returns the underlying connection.
+func (c *TLSStream) NetConn() Conn {
+	return c.t.conn
+}
+
+// MemoryAccount returns the memory account of the underlying connection, so that the accounts of the layers above the
+// TLS stream, such as a codec connection, are under it.
+func (c *TLSStream) MemoryAccount() *MemoryAccount {
+	return c.t.conn.MemoryAccount()
+}
+
+// ConnectionState returns the state of the TLS connection, such as the negotiated protocol.
+func (c *TLSStream) ConnectionState() tls.ConnectionState {
+	return c.tls.ConnectionState()
+}
+
+func (c *TLSStream) RawFd() int {
+	return c.t.conn.RawFd()
+}
+
+// Read reads and decrypts the next bytes, reading as many records from the underlying connection as it takes. It
+// fails with sonicerrors.ErrWouldBlock if the underlying connection is nonblocking and has nothing to read.
+func (c *TLSStream) Read(b []byte) (int, error) {
+	for {
+		n, err := c.tls.Read(b)
+		if ferr := c.flush(); err == nil {
+			err = ferr
+		}
+		if err != errTLSWouldBlock {
+			return n, err
+		}
+
+		n, err = c.t.conn.Read(c.t.rbuf)
+		if err != nil {
+			return 0, err
+		}
+		c.t.in = c.t.rbuf[:n]
+	}
+}
+
+// Write encrypts b and writes the resulting records to the underlying connection.
+func (c *TLSStream) Write(b []byte) (int, error) {
+	n, err := c.tls.Write(b)
+	if ferr := c.flush(); err == nil {
+		err = ferr
+	}
+	return n, err
+}
+
+// flush writes the records buffered by the transport.
+func (c *TLSStream) flush() error {
+	out := c.t.takeOut()
+	for len(out) > 0 {
+		n, err := c.t.conn.Write(out)
+		if err != nil {
+			return err
+		}
+		out = out[n:]
+	}
+	return nil
+}
+
+func (c *TLSStream) AsyncRead(b []byte, cb AsyncCallback) {
+	c.asyncRead(b, 0, false, cb)
+}
+
+func (c *TLSStream) AsyncReadAll(b []byte, cb AsyncCallback) {
+	c.asyncRead(b, 0, true, cb)
+}
+
+func (c *TLSStream) asyncRead(b []byte, readBytes int, readAll bool, cb AsyncCallback) {
+	for {
+		if c.bufferedReadsInRun >= c.maxReadsPerRun() {
+			c.yieldRead(b, readBytes, readAll, cb)
+			return
+		}
+
+		n, err := c.tls.Read(b[readBytes:])
+		readBytes += n
+
+		// Reading may produce records to send back, such as the answer to a key update.
+		c.asyncFlush(nil)
+
+		if err == errTLSWouldBlock {
+			break
+		}
+		c.bufferedReadsInRun++
+		if err != nil || !readAll || readBytes == len(b) {
+			cb(err, readBytes)
+			return
+		}
+	}
+	c.bufferedReadsInRun = 0
+
+	c.t.conn.AsyncRead(c.t.rbuf, func(err error, n int) {
+		c.t.in = c.t.rbuf[:n]
+		if err != nil {
+			c.t.rerr = err
+		}
+		c.asyncRead(b, readBytes, readAll, cb)
+	})
+}
+
+// yieldRead posts the continuation of a read once too many records have been read back-to-back from the bytes already
+// read, such that other handlers get to run first.
+func (c *TLSStream) yieldRead(b []byte, readBytes int, readAll bool, cb AsyncCallback) {
+	c.bufferedReadsInRun = 0
+	err := c.t.ioc.Post(func() {
+		c.asyncRead(b, readBytes, readAll, cb)
+	})
+	if err != nil {
+		cb(err, readBytes)
+	}
+}
+
+// SetMaxBufferedReadsPerRun sets the number of reads served back-to-back from the records already read from the
+// underlying connection before the next read is posted to the IO. n must be at least 1.
+func (c *TLSStream) SetMaxBufferedReadsPerRun(n int) {
+	if n < 1 {
+		n = 1
+	}
+	c.maxBufferedReads = n
+}
+
+func (c *TLSStream) maxReadsPerRun() int {
+	if c.maxBufferedReads == 0 {
+		return DefaultMaxBufferedReadsPerRun
+	}
+	return c.maxBufferedReads
+}
+
+func (c *TLSStream) AsyncWrite(b []byte, cb AsyncCallback) {
+	c.AsyncWriteAll(b, cb)
+}
+
+func (c *TLSStream) AsyncWriteAll(b []byte, cb AsyncCallback) {
+	n, err := c.tls.Write(b)
+	if err != nil {
+		cb(err, n)
+		return
+	}
+	c.asyncFlush(func(err error) {
+		if err != nil {
+			n = 0
+		}
+		cb(err, n)
+	})
+}
+
+// asyncFlush writes the records buffered by the transport. The writes of the underlying connection are serialized, so
+// the records are sent in order.
+func (c *TLSStream) asyncFlush(cb func(err error)) {
+	out := c.t.takeOut()
+	if len(out) == 0 {
+		if cb != nil {
+			cb(nil)
+		}
+		return
+	}
+	c.t.conn.AsyncWriteAll(out, func(err error, _ int) {
+		if cb != nil {
+			cb(err)
+		}
+	})
+}
+
+// Cancel cancels the pending operations of the underlying connection.
+func (c *TLSStream) Cancel() {
+	c.t.conn.Cancel()
+}
+
+// Close sends a close_notify alert to the peer, if it can be sent right away, and closes the underlying connection.
+func (c *TLSStream) Close() error {
+	return c.tls.Close()
+}
@@ -0,0 +1,331 @@
+package sonic
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic/internal"
+	"github.com/csdenboer/sonic/sonicerrors"
+	"github.com/csdenboer/sonic/sonicopts"
+)
+
+func trustedTLSConfigs(t *testing.T) (server, client *tls.Config) {
+	cert := selfSignedCert(t)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+
+	server = &tls.Config{Certificates: []tls.Certificate{cert}}
+	client = &tls.Config{RootCAs: roots, ServerName: "localhost"}
+	return server, client
+}
+
+func TestTLSStreamClientServer(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	serverCfg, clientCfg := trustedTLSConfigs(t)
+
+	ln, err := Listen(ioc, "tcp", "127.0.0.1:0", sonicopts.Nonblocking(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	addr, err := internal.SocketAddress(ln.RawFd())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln.AsyncAccept(func(err error, conn Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		AsyncTLSServer(ioc, conn, serverCfg, time.Second, func(err error, s *TLSStream) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := make([]byte, 128)
+			s.AsyncRead(b, func(err error, n int) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				s.AsyncWriteAll(b[:n], func(err error, _ int) {
+					if err != nil {
+						t.Fatal(err)
+					}
+				})
+			})
+		})
+	})
+
+	conn, err := Dial(ioc, "tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		client *TLSStream
+		echoed string
+	)
+	AsyncTLSClient(ioc, conn, clientCfg, time.Second, func(err error, s *TLSStream) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		client = s
+		s.AsyncWriteAll([]byte("hello"), func(err error, _ int) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := make([]byte, 128)
+			s.AsyncRead(b, func(err error, n int) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				echoed = string(b[:n])
+			})
+		})
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for echoed == "" && time.Now().Before(deadline) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if echoed != "hello" {
+		t.Fatalf("wrong echo got=%q", echoed)
+	}
+	if state := client.ConnectionState(); !state.HandshakeComplete || state.ServerName != "localhost" {
+		t.Fatalf("invalid connection state %+v", state)
+	}
+	_ = client.Close()
+}
+
+func TestTLSStreamClientInterop(t *testing.T) {
+	serverCfg, clientCfg := trustedTLSConfigs(t)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		b := make([]byte, 128)
+		n, err := conn.Read(b)
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write(b[:n])
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var echoed string
+	AsyncTLSClient(ioc, conn, clientCfg, time.Second, func(err error, s *TLSStream) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 128)
+		s.AsyncRead(b, func(err error, n int) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			echoed = string(b[:n])
+			_ = s.Close()
+		})
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for echoed == "" && time.Now().Before(deadline) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if echoed != "hello" {
+		t.Fatalf("wrong echo got=%q", echoed)
+	}
+}
+
+func TestTLSStreamClientUntrusted(t *testing.T) {
+	serverCfg, _ := trustedTLSConfigs(t)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.(*tls.Conn).Handshake()
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		done   bool
+		result error
+	)
+	AsyncTLSClient(ioc, conn, &tls.Config{ServerName: "localhost"}, time.Second, func(err error, s *TLSStream) {
+		done, result = true, err
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !done && time.Now().Before(deadline) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	var unknown x509.UnknownAuthorityError
+	if !done || !errors.As(result, &unknown) {
+		t.Fatalf("expected an unknown authority error got=%v", result)
+	}
+	if _, err := conn.Write([]byte("x")); err == nil {
+		t.Fatal("expected the connection to be closed")
+	}
+}
+
+func TestTLSStreamReadBufferedRecordsYields(t *testing.T) {
+	serverCfg, clientCfg := trustedTLSConfigs(t)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		// Each write is a record of its own.
+		for i := 0; i < 10; i++ {
+			if _, err := conn.Write([]byte{'0' + byte(i)}); err != nil {
+				return
+			}
+		}
+		time.Sleep(time.Second)
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stream *TLSStream
+	AsyncTLSClient(ioc, conn, clientCfg, time.Second, func(err error, s *TLSStream) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = s
+	})
+	for deadline := time.Now().Add(5 * time.Second); stream == nil && time.Now().Before(deadline); {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if stream == nil {
+		t.Fatal("handshake did not complete")
+	}
+	defer stream.Close()
+	stream.SetMaxBufferedReadsPerRun(2)
+
+	// Let all records arrive, such that they are read at once from the connection.
+	time.Sleep(50 * time.Millisecond)
+
+	var (
+		read        []byte
+		readAtOther = -1
+		b           = make([]byte, 1)
+		onRead      AsyncCallback
+	)
+	onRead = func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		read = append(read, b[:n]...)
+		if len(read) == 1 {
+			// Another handler which must not wait for all buffered records to be read.
+			_ = ioc.Post(func() { readAtOther = len(read) })
+		}
+		if len(read) < 10 {
+			stream.AsyncRead(b, onRead)
+		}
+	}
+	stream.AsyncRead(b, onRead)
+
+	for deadline := time.Now().Add(time.Second); len(read) < 10 && time.Now().Before(deadline); {
+		_, _ = ioc.PollOne()
+	}
+	if string(read) != "0123456789" {
+		t.Fatalf("expected to read 0123456789 got=%s", read)
+	}
+	if readAtOther < 0 || readAtOther >= 10 {
+		t.Fatalf("posted handler ran after %d reads", readAtOther)
+	}
+}
+
+func TestTLSStreamHandshakeIOClosed(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ioc := MustIO()
+	c, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server never answers, and the IO is closed before the handshake completes.
+	_, clientCfg := trustedTLSConfigs(t)
+	done := make(chan error, 1)
+	AsyncTLSClient(ioc, c, clientCfg, 50*time.Millisecond, func(err error, _ *TLSStream) {
+		done <- err
+	})
+	ioc.Close()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, sonicerrors.ErrClosed) {
+			t.Fatalf("expected ErrClosed got=%v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the handshake did not complete")
+	}
+	if !c.(*conn).Closed() {
+		t.Fatal("expected the connection to be closed")
+	}
+}
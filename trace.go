@@ -0,0 +1,156 @@
+package sonic
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DefaultTraceMaxBytes is the number of bytes traced per read or write when TraceConfig.MaxBytes is 0.
+const DefaultTraceMaxBytes = 256
+
+// TraceDirection tells whether traced bytes were read from or written to a stream.
+type TraceDirection uint8
+
+const (
+	TraceRead TraceDirection = iota
+	TraceWrite
+)
+
+func (d TraceDirection) String() string {
+	switch d {
+	case TraceRead:
+		return "read"
+	case TraceWrite:
+		return "write"
+	default:
+		return "unknown"
+	}
+}
+
+// TraceConfig configures a TracedStream.
+type TraceConfig struct {
+	// MaxBytes bounds the number of bytes traced per read or write, the rest being elided. 0 means
+	// DefaultTraceMaxBytes and a negative value means no bound.
+	MaxBytes int
+
+	// SampleEvery traces one out of SampleEvery reads, and one out of SampleEvery writes, starting with the first one.
+	// 0 or 1 traces all of them.
+	SampleEvery int
+
+	// OnTrace is called with the bytes of a traced read or write, bounded by MaxBytes, and the number of bytes the
+	// operation transferred. b is only valid during the call. If nil, hex dumps of the bytes are written to Output.
+	OnTrace func(dir TraceDirection, b []byte, n int)
+
+	// Output is where the hex dumps are written if OnTrace is nil. The default is os.Stderr.
+	Output io.Writer
+}
+
+// TracedStream is a Stream tracing the raw bytes read from and written to the Stream it wraps, which is invaluable to
+// diagnose the interoperability issues of a codec with a peer which subtly violates the spec of its protocol. The
+// codec streams trace their wire format when created over a TracedStream:
+//
+//	traced := sonic.NewTracedStream(conn, sonic.TraceConfig{MaxBytes: 64, SampleEvery: 100})
+//	cc, err := sonic.NewNonblockingCodecConn[Enc, Dec](traced, codec, src, dst)
+//
+// Reads and writes are traced once they complete, with the bytes they transferred, including those of operations
+// which transferred some bytes and then failed. Tracing costs a completion handler per asynchronous operation, so a
+// TracedStream is meant for diagnostics, not for the hot path of production streams.
+type TracedStream struct {
+	Stream
+
+	cfg    TraceConfig
+	reads  uint64
+	writes uint64
+}
+
+var _ Stream = &TracedStream{}
+
+// NewTracedStream wraps stream, tracing its reads and writes as configured by cfg.
+func NewTracedStream(stream Stream, cfg TraceConfig) *TracedStream {
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = DefaultTraceMaxBytes
+	}
+	if cfg.Output == nil {
+		cfg.Output = os.Stderr
+	}
+	return &TracedStream{Stream: stream, cfg: cfg}
+}
+
+// NextLayer returns the traced Stream.
+func (s *TracedStream) NextLayer() Stream {
+	return s.Stream
+}
+
+func (s *TracedStream) Read(b []byte) (int, error) {
+	n, err := s.Stream.Read(b)
+	s.trace(TraceRead, b[:n])
+	return n, err
+}
+
+func (s *TracedStream) Write(b []byte) (int, error) {
+	n, err := s.Stream.Write(b)
+	s.trace(TraceWrite, b[:n])
+	return n, err
+}
+
+func (s *TracedStream) AsyncRead(b []byte, cb AsyncCallback) {
+	s.Stream.AsyncRead(b, func(err error, n int) {
+		s.trace(TraceRead, b[:n])
+		cb(err, n)
+	})
+}
+
+func (s *TracedStream) AsyncReadAll(b []byte, cb AsyncCallback) {
+	s.Stream.AsyncReadAll(b, func(err error, n int) {
+		s.trace(TraceRead, b[:n])
+		cb(err, n)
+	})
+}
+
+func (s *TracedStream) AsyncWrite(b []byte, cb AsyncCallback) {
+	s.Stream.AsyncWrite(b, func(err error, n int) {
+		s.trace(TraceWrite, b[:n])
+		cb(err, n)
+	})
+}
+
+func (s *TracedStream) AsyncWriteAll(b []byte, cb AsyncCallback) {
+	s.Stream.AsyncWriteAll(b, func(err error, n int) {
+		s.trace(TraceWrite, b[:n])
+		cb(err, n)
+	})
+}
+
+func (s *TracedStream) trace(dir TraceDirection, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+
+	count := &s.reads
+	if dir == TraceWrite {
+		count = &s.writes
+	}
+	*count++
+	if s.cfg.SampleEvery > 1 && (*count-1)%uint64(s.cfg.SampleEvery) != 0 {
+		return
+	}
+
+	n := len(b)
+	if s.cfg.MaxBytes > 0 && len(b) > s.cfg.MaxBytes {
+		b = b[:s.cfg.MaxBytes]
+	}
+
+	if s.cfg.OnTrace != nil {
+		s.cfg.OnTrace(dir, b, n)
+		return
+	}
+
+	w := s.cfg.Output
+	_, _ = fmt.Fprintf(w, "sonic: fd=%d %s %d bytes\n", s.RawFd(), dir, n)
+	_, _ = io.WriteString(w, hex.Dump(b))
+	if elided := n - len(b); elided > 0 {
+		_, _ = fmt.Fprintf(w, "sonic: ... %d bytes elided\n", elided)
+	}
+}
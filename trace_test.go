@@ -0,0 +1,68 @@
+package sonic
+
+import (
+	"bytes"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestTracedStream(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fd := range fds {
+		if err := syscall.SetNonblock(fd, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, b := newConn(ioc, fds[0], nil, nil), newConn(ioc, fds[1], nil, nil)
+	defer a.Close()
+	defer b.Close()
+
+	type trace struct {
+		dir TraceDirection
+		b   string
+		n   int
+	}
+	var traces []trace
+	writer := NewTracedStream(a, TraceConfig{
+		MaxBytes:    4,
+		SampleEvery: 2,
+		OnTrace: func(dir TraceDirection, b []byte, n int) {
+			traces = append(traces, trace{dir, string(b), n})
+		},
+	})
+
+	// Every other write is traced, up to 4 bytes.
+	for _, msg := range []string{"hello", "world", "abc"} {
+		if _, err := writer.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(traces) != 2 || traces[0] != (trace{TraceWrite, "hell", 5}) || traces[1] != (trace{TraceWrite, "abc", 3}) {
+		t.Fatalf("unexpected traces %v", traces)
+	}
+
+	var dump bytes.Buffer
+	reader := NewTracedStream(b, TraceConfig{Output: &dump})
+	buf := make([]byte, 13)
+	read := false
+	reader.AsyncReadAll(buf, func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		read = true
+	})
+	for !read {
+		_, _ = ioc.PollOne()
+	}
+
+	if !strings.Contains(dump.String(), "read") || !strings.Contains(dump.String(), "68 65 6c 6c 6f") {
+		t.Fatalf("expected a hex dump of the read got=%q", dump.String())
+	}
+}
@@ -0,0 +1,57 @@
+package sonic
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/csdenboer/sonic/internal"
+	"github.com/csdenboer/sonic/sonicopts"
+)
+
+// ListenUnix listens for the connections to the unix domain stream socket bound to path, which is created by
+// ListenUnix and removed when the Listener is closed. The accepted connections are read and written like TCP
+// connections, with AsyncAccept, AsyncRead and AsyncWrite.
+//
+// On Linux, a path starting with @ binds the socket in the abstract namespace, in which case no file is created.
+func ListenUnix(ioc *IO, path string, opts ...sonicopts.Option) (Listener, error) {
+	return Listen(ioc, "unix", path, opts...)
+}
+
+// ListenUnixgram creates a PacketConn bound to the unix domain datagram socket at path, which is created by
+// ListenUnixgram and removed when the PacketConn is closed. The source addresses reported by ReadFrom are
+// *net.UnixAddr, or nil for the datagrams of unnamed sockets, to which no reply can be sent.
+func ListenUnixgram(ioc *IO, path string, opts ...sonicopts.Option) (PacketConn, error) {
+	fd, localAddr, err := internal.ListenUnixgram(path, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &packetConn{
+		ioc:       ioc,
+		slot:      internal.Slot{Fd: fd},
+		localAddr: localAddr,
+		unlink:    path,
+	}, nil
+}
+
+// DialUnix connects to the unix domain socket bound to path. network is unix for a stream socket, or unixgram for a
+// datagram socket, in which case each write of the returned Conn sends a datagram and each read receives one.
+//
+// A unixgram socket is unnamed unless it is bound with sonicopts.BindSocket to a *net.UnixAddr, so the peer cannot
+// reply to its datagrams otherwise. The file of that bound socket is not removed when the Conn is closed.
+func DialUnix(ioc *IO, network, path string, opts ...sonicopts.Option) (Conn, error) {
+	return DialUnixTimeout(ioc, network, path, 10*time.Second, opts...)
+}
+
+// DialUnixTimeout is DialUnix with a timeout for the connect.
+func DialUnixTimeout(
+	ioc *IO,
+	network, path string,
+	timeout time.Duration,
+	opts ...sonicopts.Option,
+) (Conn, error) {
+	if network != "unix" && network != "unixgram" {
+		return nil, fmt.Errorf("network %s is not a unix domain network", network)
+	}
+	return DialTimeout(ioc, network, path, timeout, opts...)
+}
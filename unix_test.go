@@ -0,0 +1,160 @@
+package sonic
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/csdenboer/sonic/sonicopts"
+)
+
+func TestUnixStream(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	path := filepath.Join(t.TempDir(), "stream.sock")
+	ln, err := ListenUnix(ioc, path, sonicopts.Nonblocking(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected the socket file to exist err=%v", err)
+	}
+
+	var echoed string
+	ln.AsyncAccept(func(err error, conn Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 128)
+		conn.AsyncRead(b, func(err error, n int) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.AsyncWriteAll(b[:n], func(err error, _ int) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				conn.Close()
+			})
+		})
+	})
+
+	client, err := DialUnix(ioc, "unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if addr, ok := client.RemoteAddr().(*net.UnixAddr); !ok || addr.Name != path || addr.Net != "unix" {
+		t.Fatalf("wrong remote address got=%v", client.RemoteAddr())
+	}
+
+	client.AsyncWriteAll([]byte("hello"), func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 128)
+		client.AsyncRead(b, func(err error, n int) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			echoed = string(b[:n])
+		})
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for echoed == "" && time.Now().Before(deadline) {
+		_, _ = ioc.PollOne()
+	}
+	if echoed != "hello" {
+		t.Fatalf("wrong echo got=%q", echoed)
+	}
+
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the socket file to be removed on close err=%v", err)
+	}
+}
+
+func TestUnixgram(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	dir := t.TempDir()
+	serverPath := filepath.Join(dir, "server.sock")
+	clientPath := filepath.Join(dir, "client.sock")
+
+	server, err := ListenPacket(ioc, "unixgram", serverPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := DialUnix(ioc, "unixgram", serverPath,
+		sonicopts.BindSocket(&net.UnixAddr{Name: clientPath, Net: "unixgram"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if addr, ok := client.LocalAddr().(*net.UnixAddr); !ok || addr.Name != clientPath || addr.Net != "unixgram" {
+		t.Fatalf("wrong local address got=%v", client.LocalAddr())
+	}
+
+	b := make([]byte, 128)
+	server.AsyncReadFrom(b, func(err error, n int, from net.Addr) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b[:n]) != "ping" {
+			t.Fatalf("wrong datagram got=%q", b[:n])
+		}
+		if addr, ok := from.(*net.UnixAddr); !ok || addr.Name != clientPath {
+			t.Fatalf("wrong source address got=%v", from)
+		}
+		if err := server.WriteTo([]byte("pong"), from); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	var reply string
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	rb := make([]byte, 128)
+	client.AsyncRead(rb, func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply = string(rb[:n])
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for reply == "" && time.Now().Before(deadline) {
+		_, _ = ioc.PollOne()
+	}
+	if reply != "pong" {
+		t.Fatalf("wrong reply got=%q", reply)
+	}
+
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(serverPath); !os.IsNotExist(err) {
+		t.Fatalf("expected the socket file to be removed on close err=%v", err)
+	}
+}
+
+func TestDialUnixNetwork(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	if _, err := DialUnix(ioc, "tcp", "/tmp/sonic.sock"); err == nil {
+		t.Fatal("expected DialUnix to reject a non unix network")
+	}
+	if _, err := DialUnix(ioc, "unix", filepath.Join(t.TempDir(), "missing.sock")); err == nil {
+		t.Fatal("expected DialUnix to fail without a listener")
+	}
+}